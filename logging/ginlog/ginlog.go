@@ -0,0 +1,49 @@
+// Package ginlog wires the logging package into Gin routers. It is separate
+// from logging, so services without Gin do not depend on it.
+package ginlog
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
+)
+
+// NewRouter creates a Gin router for a service logging in format. With JSON
+// logs, requests are logged through logger instead of Gin's text logger.
+// Every request gets a request ID, see RequestIDMiddleware.
+func NewRouter(logger *slog.Logger, format logging.Format) *gin.Engine {
+	if format != logging.FormatJSON {
+		r := gin.Default()
+		r.Use(RequestIDMiddleware())
+		return r
+	}
+
+	// Route registration is printed as text in debug mode
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		logging.Request(logger, c.Request, c.Writer.Status(), time.Since(start))
+	}, gin.Recovery())
+	return r
+}
+
+// RequestIDMiddleware returns middleware adopting the X-Request-ID of
+// incoming requests, or generating one when it is missing or invalid. The
+// ID is echoed in the response and carried by the request context, so every
+// line logged while serving the request, and every outbound request passed
+// through logging.SetRequestIDHeader, carries it too.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(logging.RequestIDHeader, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package ginlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		incoming string
+		adopted  bool
+	}{
+		{name: "adopts the caller's ID", incoming: "engine-5c1d", adopted: true},
+		{name: "generates a missing ID"},
+		{name: "replaces an invalid ID", incoming: "forged\nline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			r := gin.New()
+			r.Use(RequestIDMiddleware())
+			r.GET("/", func(c *gin.Context) {
+				seen = logging.RequestID(c.Request.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(logging.RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(logging.RequestIDHeader)
+			if tt.adopted && id != tt.incoming {
+				t.Errorf("Expected the response to echo %q, got %q", tt.incoming, id)
+			}
+			if !tt.adopted && (id == tt.incoming || !logging.ValidRequestID(id)) {
+				t.Errorf("Expected a generated ID, got %q", id)
+			}
+			if seen != id {
+				t.Errorf("Expected the request context to carry %q, got %q", id, seen)
+			}
+		})
+	}
+}
+
+func TestNewRouter_JSONRequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	r := NewRouter(logger, logging.FormatJSON)
+	r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON request log, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "request" || entry["path"] != "/ping" || entry["status"] != float64(http.StatusNoContent) {
+		t.Errorf("Expected the request to be logged, got %v", entry)
+	}
+	if w.Header().Get(logging.RequestIDHeader) == "" {
+		t.Error("Expected the request to get a request ID")
+	}
+}
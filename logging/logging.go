@@ -0,0 +1,86 @@
+// Package logging configures the logger shared by the trade-sonic services.
+// Logs are plain text by default; LOG_FORMAT=json switches them to one JSON
+// object per line, with time, level and msg keys plus the logged fields, so
+// a log aggregator can parse them.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Format is the encoding of log lines
+type Format string
+
+const (
+	// FormatText writes human readable lines, the default
+	FormatText Format = "text"
+	// FormatJSON writes one JSON object per line
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses a LOG_FORMAT value. An empty value is FormatText.
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(value); format {
+	case "":
+		return FormatText, nil
+	case FormatText, FormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, expected %q or %q", value, FormatText, FormatJSON)
+	}
+}
+
+// New creates a logger writing to w in the given format. Lines logged with
+// a context carrying a request ID, see WithRequestID, include it as
+// request_id.
+func New(w io.Writer, format Format, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == FormatJSON {
+		return slog.New(contextHandler{slog.NewJSONHandler(w, opts)})
+	}
+	return slog.New(contextHandler{slog.NewTextHandler(w, opts)})
+}
+
+// Setup creates a logger writing to w and makes it the slog default, so
+// package-level slog calls honor the level and request IDs in either format.
+// The default also routes the standard log package through it, so lines
+// logged with log.Printf are in the same format.
+func Setup(w io.Writer, format Format, level slog.Leveler) *slog.Logger {
+	logger := New(w, format, level)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// FromEnv sets up a logger on stderr from LOG_FORMAT (text or json) and
+// LOG_LEVEL (debug, info, warn or error, info by default)
+func FromEnv() (*slog.Logger, Format, error) {
+	format, err := ParseFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid LOG_FORMAT: %w", err)
+	}
+
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return nil, "", fmt.Errorf("invalid LOG_LEVEL %q: %w", v, err)
+		}
+	}
+	return Setup(os.Stderr, format, level), format, nil
+}
+
+// Request logs a served HTTP request. Services log requests through it
+// instead of their router's text logger when logging JSON.
+func Request(logger *slog.Logger, r *http.Request, status int, latency time.Duration) {
+	logger.InfoContext(r.Context(), "request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"latency", latency,
+		"remote_addr", r.RemoteAddr,
+	)
+}
@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Format
+		wantErr  bool
+	}{
+		{value: "", expected: FormatText},
+		{value: "text", expected: FormatText},
+		{value: "json", expected: FormatJSON},
+		{value: "JSON", wantErr: true},
+		{value: "logfmt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		format, err := ParseFormat(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected an error for %q, got %s", tt.value, format)
+			}
+			continue
+		}
+		if err != nil || format != tt.expected {
+			t.Errorf("Expected %s for %q, got %s, %v", tt.expected, tt.value, format, err)
+		}
+	}
+}
+
+// decodeLine decodes a single JSON log line
+func decodeLine(t *testing.T, line string) map[string]interface{} {
+	t.Helper()
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("Expected a JSON log line, got %q: %v", line, err)
+	}
+	return entry
+}
+
+func TestNew_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelInfo)
+	logger.Info("Fetched positions", "account", "test-account", "count", 3)
+	logger.Debug("Suppressed below the level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 log line, got %d: %q", len(lines), buf.String())
+	}
+	entry := decodeLine(t, lines[0])
+	for _, key := range []string{"time", "level", "msg", "account", "count"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("Expected key %q in %v", key, entry)
+		}
+	}
+	if entry["level"] != "INFO" || entry["msg"] != "Fetched positions" || entry["account"] != "test-account" {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+}
+
+func TestNew_Text(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, FormatText, slog.LevelInfo).Info("Fetched positions", "count", 3)
+	if line := buf.String(); !strings.Contains(line, `msg="Fetched positions" count=3`) {
+		t.Errorf("Expected a text log line, got %q", line)
+	}
+}
+
+func TestSetup_RoutesStandardLog(t *testing.T) {
+	defaultLogger := slog.Default()
+	flags, writer := log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		log.SetFlags(flags)
+		log.SetOutput(writer)
+	})
+
+	var buf bytes.Buffer
+	Setup(&buf, FormatJSON, slog.LevelInfo)
+	log.Printf("Admin API listening on %s", ":8090")
+
+	entry := decodeLine(t, strings.TrimSpace(buf.String()))
+	if entry["msg"] != "Admin API listening on :8090" || entry["level"] != "INFO" {
+		t.Errorf("Expected the standard log line as JSON, got %v", entry)
+	}
+}
+
+func TestSetup_TextBecomesDefault(t *testing.T) {
+	defaultLogger := slog.Default()
+	flags, writer := log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		log.SetFlags(flags)
+		log.SetOutput(writer)
+	})
+
+	var buf bytes.Buffer
+	Setup(&buf, FormatText, slog.LevelWarn)
+	ctx := WithRequestID(context.Background(), "req-1")
+	slog.InfoContext(ctx, "Below the level")
+	slog.WarnContext(ctx, "Token refresh failed")
+
+	line := buf.String()
+	if strings.Contains(line, "Below the level") {
+		t.Errorf("Expected the level to apply to slog calls, got %q", line)
+	}
+	if !strings.Contains(line, `msg="Token refresh failed" request_id=req-1`) {
+		t.Errorf("Expected a text line with the request ID, got %q", line)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelInfo)
+	Request(logger, httptest.NewRequest("GET", "/positions?account_type=robinhood", nil), 200, 15*time.Millisecond)
+
+	entry := decodeLine(t, strings.TrimSpace(buf.String()))
+	if entry["method"] != "GET" || entry["path"] != "/positions" || entry["status"] != float64(200) {
+		t.Errorf("Unexpected request entry %v", entry)
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Errorf("Expected a latency in %v", entry)
+	}
+}
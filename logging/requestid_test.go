@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelInfo).With("service", "position-service")
+	ctx := WithRequestID(context.Background(), "req-123")
+
+	logger.InfoContext(ctx, "Fetched positions")
+	logger.Info("Background refresh")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d: %q", len(lines), buf.String())
+	}
+	entry := decodeLine(t, lines[0])
+	if entry["request_id"] != "req-123" || entry["service"] != "position-service" {
+		t.Errorf("Expected the request ID and logger attributes in %v", entry)
+	}
+	if entry := decodeLine(t, lines[1]); entry["request_id"] != nil {
+		t.Errorf("Expected no request ID without one in the context, got %v", entry)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected bool
+	}{
+		{id: "3f2a9c1b7d4e8f60", expected: true},
+		{id: "engine-5c1d", expected: true},
+		{id: "", expected: false},
+		{id: "with space", expected: false},
+		{id: "line\nbreak", expected: false},
+		{id: strings.Repeat("a", 129), expected: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidRequestID(tt.id); got != tt.expected {
+			t.Errorf("Expected %v for %q, got %v", tt.expected, tt.id, got)
+		}
+	}
+	if id := NewRequestID(); !ValidRequestID(id) {
+		t.Errorf("Expected a generated ID to be valid, got %q", id)
+	}
+}
+
+func TestSetRequestIDHeader(t *testing.T) {
+	req := httptest.NewRequest("GET", "/token", nil)
+	SetRequestIDHeader(req)
+	if got := req.Header.Get(RequestIDHeader); got != "" {
+		t.Errorf("Expected no header without a request ID, got %q", got)
+	}
+
+	req = req.WithContext(WithRequestID(req.Context(), "req-123"))
+	SetRequestIDHeader(req)
+	if got := req.Header.Get(RequestIDHeader); got != "req-123" {
+		t.Errorf("Expected req-123, got %q", got)
+	}
+}
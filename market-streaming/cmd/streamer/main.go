@@ -1,45 +1,98 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/trade-sonic/logging"
+	"trade-sonic/market-streaming/internal/candles"
 	"trade-sonic/market-streaming/internal/stream"
 	"trade-sonic/market-streaming/internal/stream/crypto"
 	"trade-sonic/market-streaming/internal/stream/stock"
+	"trade-sonic/market-streaming/internal/trades"
 )
 
-// createTradeHandler returns a handler function for processing trades
-func createTradeHandler(marketType string) stream.TradeHandler {
+// createTradeHandler returns a handler function for processing trades,
+// showing trade times in loc
+func createTradeHandler(marketType string, loc *time.Location) stream.TradeHandler {
 	return func(trade stream.Trade) {
-		// Convert timestamp to local time
-		tradeTime := time.Unix(trade.Timestamp/1000, 0).Local()
+		fmt.Println(formatTrade(marketType, trade, loc))
+	}
+}
 
-		// Clean up symbol name
-		symbol := trade.Symbol
-		if marketType == "crypto" {
-			symbol = trade.Symbol[8:] // Remove "BINANCE:" prefix
-		}
+// formatTrade formats a trade for the ticker, with its time in loc
+func formatTrade(marketType string, trade stream.Trade, loc *time.Location) string {
+	tradeTime := time.Unix(trade.Timestamp/1000, 0).In(loc)
 
-		fmt.Printf("[%s] %s %s: $%.2f, Volume: %.4f\n",
-			tradeTime.Format("15:04:05"),
-			marketType,
-			symbol,
-			trade.Price,
-			trade.Volume)
+	// Clean up symbol name
+	symbol := trade.Symbol
+	if marketType == "crypto" {
+		symbol = trade.Symbol[8:] // Remove "BINANCE:" prefix
 	}
+
+	return fmt.Sprintf("[%s] %s %s: $%.2f, Volume: %.4f",
+		tradeTime.Format("15:04:05"),
+		marketType,
+		symbol,
+		trade.Price,
+		trade.Volume)
+}
+
+// loadTimezone loads the IANA timezone named by an environment variable,
+// or returns def when it is not set
+func loadTimezone(name string, def *time.Location) *time.Location {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		log.Fatalf("Invalid %s %q, expected an IANA timezone like Europe/London: %v", name, v, err)
+	}
+	return loc
+}
+
+// loadAPIKey returns the Finnhub API key, read from the file named by
+// FINNHUB_API_KEY_FILE, e.g. a mounted secret, or else from FINNHUB_API_KEY
+func loadAPIKey() (string, error) {
+	if path := os.Getenv("FINNHUB_API_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading FINNHUB_API_KEY_FILE: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("FINNHUB_API_KEY_FILE %s is empty", path)
+		}
+		return key, nil
+	}
+	if key := os.Getenv("FINNHUB_API_KEY"); key != "" {
+		return key, nil
+	}
+	return "", errors.New("please set the FINNHUB_API_KEY or FINNHUB_API_KEY_FILE environment variable")
 }
 
 // main is the entry point of the program that sets up and runs both crypto and stock market data streams.
 // It handles graceful shutdown on interrupt signal and displays real-time trade data from both markets.
 func main() {
-	// Get API key from environment
-	apiKey := os.Getenv("FINNHUB_API_KEY")
-	if apiKey == "" {
-		log.Fatal("Please set FINNHUB_API_KEY environment variable")
+	// LOG_FORMAT=json switches the log lines below to structured logs. Trades
+	// are still printed to stdout as a ticker.
+	if _, _, err := logging.FromEnv(); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+
+	// Get API key from a secret file or the environment
+	apiKey, err := loadAPIKey()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Define crypto pairs to track
@@ -55,10 +108,22 @@ func main() {
 		"MSFT",  // Microsoft
 		"GOOGL", // Google
 	}
+	// STOCK_SYMBOLS replaces them, e.g. with a watchlist synced from the
+	// position service
+	if v := os.Getenv("STOCK_SYMBOLS"); v != "" {
+		stockSymbols = nil
+		for _, symbol := range strings.Split(v, ",") {
+			if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+				stockSymbols = append(stockSymbols, symbol)
+			}
+		}
+		if len(stockSymbols) == 0 {
+			log.Fatalf("Invalid STOCK_SYMBOLS %q, expected comma-separated symbols", v)
+		}
+	}
 
 	// Create crypto streamer with retry
 	var cryptoStreamer *crypto.Streamer
-	var err error
 	for retries := 0; retries < 3; retries++ {
 		cryptoStreamer, err = crypto.NewStreamer(apiKey, cryptoPairs)
 		if err == nil {
@@ -75,10 +140,27 @@ func main() {
 	// Wait before creating stock streamer to avoid rate limits
 	time.Sleep(2 * time.Second)
 
+	// Trade times are shown in DISPLAY_TIMEZONE, local time by default, and
+	// stock trading hours are checked in MARKET_TIMEZONE
+	displayLocation := loadTimezone("DISPLAY_TIMEZONE", time.Local)
+	marketHours := stream.USStockHours(loadTimezone("MARKET_TIMEZONE", nil))
+
+	// Backup keys replace a revoked or rotated primary key on reconnect
+	stockOpts := []stream.Option{stream.WithMarketHours(marketHours)}
+	if backups := os.Getenv("FINNHUB_BACKUP_API_KEYS"); backups != "" {
+		keys := []string{apiKey}
+		for _, key := range strings.Split(backups, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		stockOpts = append(stockOpts, stream.WithKeyProvider(stream.NewKeyList(keys...), 0))
+	}
+
 	// Create stock streamer with retry
 	var stockStreamer *stock.Streamer
 	for retries := 0; retries < 3; retries++ {
-		stockStreamer, err = stock.NewStreamer(apiKey, stockSymbols)
+		stockStreamer, err = stock.NewStreamer(apiKey, stockSymbols, stockOpts...)
 		if err == nil {
 			break
 		}
@@ -90,9 +172,53 @@ func main() {
 	}
 	defer stockStreamer.Close()
 
+	// Serve completed candles to websocket subscribers
+	candleServer := candles.NewServer()
+	candleAddr := os.Getenv("CANDLE_SERVER_ADDR")
+	if candleAddr == "" {
+		candleAddr = ":8082"
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/candles", candleServer)
+	// Relay raw trades, e.g. to the position service for live prices
+	tradeServer := trades.NewServer()
+	mux.Handle("/trades", tradeServer)
+	// Report which requested symbols are actually streaming
+	mux.HandleFunc("/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]map[string]string{
+			"crypto": cryptoStreamer.SubscriptionStatus(),
+			"stock":  stockStreamer.SubscriptionStatus(),
+		})
+	})
+	httpServer := &http.Server{Addr: candleAddr, Handler: mux}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Candle server error: %v", err)
+		}
+	}()
+	defer httpServer.Close()
+
+	// Optionally force a reconnect when a connection stays silent. Stocks
+	// are only expected to trade during market hours.
+	var cryptoStream, stockStream stream.MarketStreamer = cryptoStreamer, stockStreamer
+	if v := os.Getenv("STREAM_IDLE_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			log.Fatalf("Invalid STREAM_IDLE_TIMEOUT %q, expected a positive duration like 2m", v)
+		}
+		cryptoStream = stream.NewIdleReconnectWrapper(cryptoStreamer, timeout)
+		stockStream = stream.NewIdleReconnectWrapper(stockStreamer, timeout,
+			stream.WithActiveHours(marketHours.IsOpen))
+	}
+
 	// Add handlers
-	cryptoStreamer.AddHandler(createTradeHandler("crypto"))
-	stockStreamer.AddHandler(createTradeHandler("stock"))
+	cryptoStreamer.AddHandler(createTradeHandler("crypto", displayLocation))
+	stockStreamer.AddHandler(createTradeHandler("stock", displayLocation))
+	cryptoStreamer.AddHandler(candleServer.HandleTrade)
+	stockStreamer.AddHandler(candleServer.HandleTrade)
+	cryptoStreamer.AddHandler(tradeServer.HandleTrade)
+	stockStreamer.AddHandler(tradeServer.HandleTrade)
 
 	// Subscribe to streams with delay between them
 	if err := cryptoStreamer.Subscribe(); err != nil {
@@ -117,7 +243,7 @@ func main() {
 	// Start crypto streaming
 	go func() {
 		defer wg.Done()
-		if err := cryptoStreamer.Stream(); err != nil {
+		if err := cryptoStream.Stream(); err != nil {
 			log.Printf("Crypto streaming error: %v", err)
 			os.Exit(1)
 		}
@@ -126,7 +252,7 @@ func main() {
 	// Start stock streaming
 	go func() {
 		defer wg.Done()
-		if err := stockStreamer.Stream(); err != nil {
+		if err := stockStream.Stream(); err != nil {
 			log.Printf("Stock streaming error: %v", err)
 			os.Exit(1)
 		}
@@ -135,6 +261,9 @@ func main() {
 	log.Printf("Both streamers are running. Waiting for market data...\n")
 	log.Printf("Crypto pairs: %v\n", cryptoPairs)
 	log.Printf("Stock symbols: %v\n", stockSymbols)
+	log.Printf("Candles available at ws://%s/candles?symbol=...&interval=1m\n", candleAddr)
+	log.Printf("Trades available at ws://%s/trades?symbols=...\n", candleAddr)
+	log.Printf("Subscription status available at http://%s/subscriptions\n", candleAddr)
 
 	// Wait for interrupt signal
 	<-interrupt
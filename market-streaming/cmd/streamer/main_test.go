@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trade-sonic/market-streaming/internal/stream"
+)
+
+func TestFormatTrade(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	// 2026-03-04 15:04:05 UTC
+	at := time.Date(2026, 3, 4, 15, 4, 5, 0, time.UTC)
+	trade := stream.Trade{Symbol: "BINANCE:BTCUSDT", Price: 65000.5, Volume: 0.25, Timestamp: at.UnixMilli()}
+
+	tests := []struct {
+		name     string
+		loc      *time.Location
+		expected string
+	}{
+		{name: "UTC", loc: time.UTC, expected: "[15:04:05] crypto BTCUSDT: $65000.50, Volume: 0.2500"},
+		{name: "London", loc: london, expected: "[15:04:05] crypto BTCUSDT: $65000.50, Volume: 0.2500"},
+		{name: "fixed offset", loc: time.FixedZone("UTC-5", -5*3600), expected: "[10:04:05] crypto BTCUSDT: $65000.50, Volume: 0.2500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTrade("crypto", trade, tt.loc); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLoadAPIKey(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "finnhub-key")
+	if err := os.WriteFile(secret, []byte("  file-key\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		env       string
+		expected  string
+		expectErr bool
+	}{
+		{name: "file", file: secret, expected: "file-key"},
+		{name: "file preferred over env", file: secret, env: "env-key", expected: "file-key"},
+		{name: "env", env: "env-key", expected: "env-key"},
+		{name: "missing file", file: filepath.Join(dir, "missing"), env: "env-key", expectErr: true},
+		{name: "empty file", file: empty, expectErr: true},
+		{name: "neither set", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FINNHUB_API_KEY_FILE", tt.file)
+			t.Setenv("FINNHUB_API_KEY", tt.env)
+
+			key, err := loadAPIKey()
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got key %q", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if key != tt.expected {
+				t.Errorf("Expected key %q, got %q", tt.expected, key)
+			}
+		})
+	}
+}
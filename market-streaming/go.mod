@@ -2,6 +2,11 @@ module trade-sonic/market-streaming
 
 go 1.21
 
-require github.com/gorilla/websocket v1.5.1
+require (
+	github.com/gorilla/websocket v1.5.1
+	github.com/trade-sonic/logging v0.0.0
+)
 
 require golang.org/x/net v0.17.0 // indirect
+
+replace github.com/trade-sonic/logging => ../logging
@@ -0,0 +1,173 @@
+package candles
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"trade-sonic/market-streaming/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultInterval is the candle interval used when a subscriber does not pass one
+	DefaultInterval = time.Minute
+	// subscriberBuffer is how many candles may queue for a slow subscriber
+	// before further candles are dropped
+	subscriberBuffer = 16
+	// writeTimeout bounds how long a single candle frame may take to send
+	writeTimeout = 10 * time.Second
+)
+
+// feed identifies the candles of one symbol at one interval
+type feed struct {
+	symbol   string
+	interval time.Duration
+}
+
+// subscriber is a websocket client receiving the candles of one feed
+type subscriber struct {
+	candles chan stream.Candle
+}
+
+// Server pushes completed candles to websocket subscribers. Trades are fed
+// with HandleTrade and aggregated once per subscribed symbol and interval,
+// however many clients share the feed.
+type Server struct {
+	mu          sync.Mutex
+	upgrader    websocket.Upgrader
+	aggregators map[feed]*stream.CandleAggregator
+	subscribers map[feed]map[*subscriber]struct{}
+}
+
+// NewServer creates a candle server
+func NewServer() *Server {
+	return &Server{
+		upgrader:    websocket.Upgrader{},
+		aggregators: make(map[feed]*stream.CandleAggregator),
+		subscribers: make(map[feed]map[*subscriber]struct{}),
+	}
+}
+
+// HandleTrade feeds a trade to every aggregator of its symbol. It is a
+// stream.TradeHandler, so it can be added to the market streamers.
+func (s *Server) HandleTrade(trade stream.Trade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for f, aggregator := range s.aggregators {
+		if f.symbol == trade.Symbol {
+			aggregator.AddTrade(trade)
+		}
+	}
+}
+
+// ServeHTTP upgrades /candles?symbol=...&interval=1m requests to a websocket
+// and streams the completed candles of that feed as JSON frames
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	if symbol == "" {
+		http.Error(w, "symbol is required", http.StatusBadRequest)
+		return
+	}
+
+	interval := DefaultInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid interval %q, expected a positive duration like 1m", v), http.StatusBadRequest)
+			return
+		}
+		interval = parsed
+	}
+
+	// Subscribe before upgrading so no candle completed after the handshake is missed
+	f := feed{symbol: symbol, interval: interval}
+	sub := s.subscribe(f)
+	defer s.unsubscribe(f, sub)
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading candle subscriber: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Read until the client goes away, the client sends nothing else
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case candle := <-sub.candles:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(candle); err != nil {
+				log.Printf("Error sending candle to subscriber: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// subscribe registers a subscriber, creating the feed's aggregator if needed
+func (s *Server) subscribe(f feed) *subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := &subscriber{candles: make(chan stream.Candle, subscriberBuffer)}
+	if _, exists := s.aggregators[f]; !exists {
+		// The handler runs from HandleTrade, which already holds the lock
+		s.aggregators[f] = stream.NewCandleAggregator(f.interval, func(candle stream.Candle) {
+			s.broadcast(f, candle)
+		})
+		s.subscribers[f] = make(map[*subscriber]struct{})
+	}
+	s.subscribers[f][sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscriber and drops the feed's aggregator once it has none left
+func (s *Server) unsubscribe(f feed, sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers[f], sub)
+	if len(s.subscribers[f]) == 0 {
+		delete(s.subscribers, f)
+		delete(s.aggregators, f)
+	}
+}
+
+// broadcast queues a candle for every subscriber of the feed. The caller must hold the lock.
+func (s *Server) broadcast(f feed, candle stream.Candle) {
+	for sub := range s.subscribers[f] {
+		select {
+		case sub.candles <- candle:
+		default:
+			log.Printf("Candle subscriber for %s is too slow, dropping candle", f.symbol)
+		}
+	}
+}
+
+// SubscriberCount returns the number of connected subscribers across all feeds
+func (s *Server) SubscriberCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, subs := range s.subscribers {
+		count += len(subs)
+	}
+	return count
+}
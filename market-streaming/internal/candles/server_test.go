@@ -0,0 +1,125 @@
+package candles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"trade-sonic/market-streaming/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial connects a websocket subscriber to the candle server
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/candles?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitForSubscribers polls until the server has the expected number of subscribers
+func waitForSubscribers(t *testing.T, s *Server, expected int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s.SubscriberCount() == expected {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers, got %d", expected, s.SubscriberCount())
+}
+
+func TestServer_PushesCompletedCandles(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// Two subscribers share the AAPL feed, a third watches another interval
+	first := dial(t, srv, "symbol=AAPL&interval=1m")
+	second := dial(t, srv, "symbol=AAPL&interval=1m")
+	hourly := dial(t, srv, "symbol=AAPL&interval=1h")
+
+	base := time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)
+	trades := []stream.Trade{
+		{Symbol: "AAPL", Price: 100, Volume: 1, Timestamp: base.UnixMilli()},
+		{Symbol: "MSFT", Price: 400, Volume: 5, Timestamp: base.Add(5 * time.Second).UnixMilli()},
+		{Symbol: "AAPL", Price: 110, Volume: 2, Timestamp: base.Add(30 * time.Second).UnixMilli()},
+		{Symbol: "AAPL", Price: 105, Volume: 1, Timestamp: base.Add(61 * time.Second).UnixMilli()},
+	}
+	for _, trade := range trades {
+		s.HandleTrade(trade)
+	}
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var candle stream.Candle
+		if err := conn.ReadJSON(&candle); err != nil {
+			t.Fatalf("Failed to read candle: %v", err)
+		}
+
+		if candle.Symbol != "AAPL" || !candle.Start.Equal(base) || !candle.End.Equal(base.Add(time.Minute)) {
+			t.Errorf("Unexpected candle bucket: %+v", candle)
+		}
+		if candle.Open != 100 || candle.High != 110 || candle.Low != 100 || candle.Close != 110 || candle.Volume != 3 {
+			t.Errorf("Unexpected candle values: %+v", candle)
+		}
+	}
+
+	// The hourly candle is still open
+	hourly.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := hourly.ReadMessage(); err == nil {
+		t.Error("Expected no hourly candle yet")
+	}
+}
+
+func TestServer_CleansUpOnDisconnect(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn := dial(t, srv, "symbol=AAPL")
+	waitForSubscribers(t, s, 1)
+
+	conn.Close()
+	waitForSubscribers(t, s, 0)
+
+	s.mu.Lock()
+	aggregators := len(s.aggregators)
+	s.mu.Unlock()
+	if aggregators != 0 {
+		t.Errorf("Expected the unused aggregator to be removed, got %d", aggregators)
+	}
+}
+
+func TestServer_RejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "missing symbol", query: "interval=1m"},
+		{name: "invalid interval", query: "symbol=AAPL&interval=soon"},
+		{name: "non-positive interval", query: "symbol=AAPL&interval=0s"},
+	}
+
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/candles?" + tt.query)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+}
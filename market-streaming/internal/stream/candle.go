@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// Candle is an OHLCV bar aggregated from the trades of one symbol over one interval
+type Candle struct {
+	Symbol string    `json:"symbol"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Open   float64   `json:"open"`
+	High   float64   `json:"high"`
+	Low    float64   `json:"low"`
+	Close  float64   `json:"close"`
+	Volume float64   `json:"volume"`
+	Trades int       `json:"trades"`
+}
+
+// CandleHandler is a function type that handles completed candles
+type CandleHandler func(Candle)
+
+// CandleAggregator builds fixed-interval candles from trades. Buckets are
+// aligned to the interval and use the trade timestamps, so a candle is
+// completed by the first trade that falls into a later bucket.
+type CandleAggregator struct {
+	mu       sync.Mutex
+	interval time.Duration
+	handler  CandleHandler
+	open     map[string]*Candle // In-progress candles keyed by symbol
+}
+
+// NewCandleAggregator creates an aggregator that passes completed candles to
+// handler. The handler runs synchronously from AddTrade and must not block.
+func NewCandleAggregator(interval time.Duration, handler CandleHandler) *CandleAggregator {
+	return &CandleAggregator{
+		interval: interval,
+		handler:  handler,
+		open:     make(map[string]*Candle),
+	}
+}
+
+// Interval returns the candle interval
+func (a *CandleAggregator) Interval() time.Duration {
+	return a.interval
+}
+
+// AddTrade adds a trade to its symbol's candle. Trades older than the
+// current candle are dropped.
+func (a *CandleAggregator) AddTrade(trade Trade) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tradeTime := time.UnixMilli(trade.Timestamp).UTC()
+	start := tradeTime.Truncate(a.interval)
+
+	candle, exists := a.open[trade.Symbol]
+	if exists && start.Before(candle.Start) {
+		return
+	}
+	if exists && start.After(candle.Start) {
+		a.handler(*candle)
+		exists = false
+	}
+	if !exists {
+		a.open[trade.Symbol] = &Candle{
+			Symbol: trade.Symbol,
+			Start:  start,
+			End:    start.Add(a.interval),
+			Open:   trade.Price,
+			High:   trade.Price,
+			Low:    trade.Price,
+			Close:  trade.Price,
+			Volume: trade.Volume,
+			Trades: 1,
+		}
+		return
+	}
+
+	if trade.Price > candle.High {
+		candle.High = trade.Price
+	}
+	if trade.Price < candle.Low {
+		candle.Low = trade.Price
+	}
+	candle.Close = trade.Price
+	candle.Volume += trade.Volume
+	candle.Trades++
+}
+
+// Handler returns a TradeHandler that feeds the aggregator, for use with AddHandler
+func (a *CandleAggregator) Handler() TradeHandler {
+	return a.AddTrade
+}
@@ -0,0 +1,55 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCandleAggregator(t *testing.T) {
+	var candles []Candle
+	a := NewCandleAggregator(time.Minute, func(c Candle) {
+		candles = append(candles, c)
+	})
+
+	base := time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)
+	trade := func(symbol string, offset time.Duration, price, volume float64) Trade {
+		return Trade{Symbol: symbol, Price: price, Volume: volume, Timestamp: base.Add(offset).UnixMilli()}
+	}
+
+	a.AddTrade(trade("AAPL", 0, 100, 1))
+	a.AddTrade(trade("AAPL", 10*time.Second, 104, 2))
+	a.AddTrade(trade("MSFT", 15*time.Second, 400, 1))
+	a.AddTrade(trade("AAPL", 20*time.Second, 98, 1))
+	a.AddTrade(trade("AAPL", 59*time.Second, 101, 3))
+	if len(candles) != 0 {
+		t.Fatalf("Expected no completed candles within the bucket, got %d", len(candles))
+	}
+
+	// The first trade of the next bucket completes the AAPL candle only
+	a.AddTrade(trade("AAPL", 61*time.Second, 102, 1))
+	if len(candles) != 1 {
+		t.Fatalf("Expected 1 completed candle, got %d", len(candles))
+	}
+
+	expected := Candle{
+		Symbol: "AAPL",
+		Start:  base,
+		End:    base.Add(time.Minute),
+		Open:   100,
+		High:   104,
+		Low:    98,
+		Close:  101,
+		Volume: 7,
+		Trades: 4,
+	}
+	if candles[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, candles[0])
+	}
+
+	// Late trades for a completed bucket are dropped
+	a.AddTrade(trade("AAPL", 30*time.Second, 500, 1))
+	a.AddTrade(trade("AAPL", 2*time.Minute, 103, 1))
+	if len(candles) != 2 || candles[1].High != 102 {
+		t.Errorf("Expected the late trade to be dropped, got %+v", candles)
+	}
+}
@@ -1,9 +1,9 @@
 package crypto
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 	"trade-sonic/market-streaming/internal/stream"
 
@@ -13,19 +13,31 @@ import (
 // Streamer handles cryptocurrency data streaming
 type Streamer struct {
 	conn      *websocket.Conn
+	connMu    sync.Mutex // Guards conn, which Close reads from other goroutines
 	apiKey    string
 	symbols   []string
 	handlers  []stream.TradeHandler
 	connected bool
+	opts      stream.Options
+	dialer    *websocket.Dialer
+	prices    *stream.LastPrices
+	subs      *stream.Subscriptions
+	decoder   *stream.TradeDecoder
 }
 
 // NewStreamer creates a new crypto market data streamer
-func NewStreamer(apiKey string, symbols []string) (*Streamer, error) {
+func NewStreamer(apiKey string, symbols []string, opts ...stream.Option) (*Streamer, error) {
+	options := stream.NewOptions(opts...)
 	s := &Streamer{
 		apiKey:    apiKey,
 		symbols:   symbols,
 		handlers:  make([]stream.TradeHandler, 0),
 		connected: false,
+		opts:      options,
+		dialer:    options.Dialer(),
+		prices:    stream.NewLastPrices(),
+		subs:      stream.NewSubscriptions(symbols),
+		decoder:   stream.NewTradeDecoder(options.TradeFields),
 	}
 
 	if err := s.connect(); err != nil {
@@ -40,34 +52,51 @@ func (s *Streamer) AddHandler(handler stream.TradeHandler) {
 	s.handlers = append(s.handlers, handler)
 }
 
-// Subscribe subscribes to the specified crypto symbols
+// Subscribe subscribes to the specified crypto symbols, in batches when configured
 func (s *Streamer) Subscribe() error {
+	// Finnhub silently drops subscriptions beyond the plan limit
+	if err := s.opts.CheckSymbolLimit(s.symbols); err != nil {
+		s.subs.SetAll(stream.StateFailed)
+		return err
+	}
+
 	log.Printf("Subscribing to crypto symbols: %v", s.symbols)
-	for _, symbol := range s.symbols {
-		msg := fmt.Sprintf(`{"type":"subscribe","symbol":"%s"}`, symbol)
-		if err := s.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+	return s.opts.SubscribeBatched(s.symbols, func(symbol string) error {
+		msg, err := s.opts.SubscribeMessage(symbol)
+		if err != nil {
+			s.subs.Set(symbol, stream.StateFailed)
+			return err
+		}
+		if err := s.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			s.subs.Set(symbol, stream.StateFailed)
 			return fmt.Errorf("error subscribing to symbol %s: %w", symbol, err)
 		}
+		s.subs.Set(symbol, stream.StateSubscribed)
 		log.Printf("Subscribed to crypto %s", symbol)
-	}
-	return nil
+		return nil
+	})
 }
 
 // connect establishes a new websocket connection
 func (s *Streamer) connect() error {
 	log.Printf("Connecting to Finnhub crypto websocket...")
-	url := fmt.Sprintf("wss://ws.finnhub.io?token=%s", s.apiKey)
-	c, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	c, resp, err := s.dialer.Dial(s.opts.Endpoint(s.apiKey), nil)
 	if err != nil {
 		return fmt.Errorf("error connecting to websocket: %w, response: %+v", err, resp)
 	}
+	s.connMu.Lock()
 	s.conn = c
+	s.connMu.Unlock()
 	s.connected = true
+	// A new connection starts without subscriptions
+	s.subs.SetAll(stream.StateRequested)
 	log.Printf("Successfully connected to Finnhub crypto websocket")
 	return nil
 }
 
-// Stream starts streaming crypto market data
+// Stream starts streaming crypto market data. It only returns once the
+// reconnect handler gives up, with an error wrapping
+// stream.ErrReconnectAborted.
 func (s *Streamer) Stream() error {
 	log.Printf("Starting to stream crypto market data...")
 
@@ -82,9 +111,9 @@ func (s *Streamer) Stream() error {
 			s.connected = false
 
 			// Reconnection loop
-			for {
+			for attempt := 1; ; attempt++ {
 				log.Printf("Waiting %v before reconnecting...", backoff)
-				time.Sleep(backoff)
+				s.opts.Clock.Sleep(backoff)
 
 				// Exponential backoff
 				backoff *= 2
@@ -95,6 +124,9 @@ func (s *Streamer) Stream() error {
 				// Try to reconnect
 				if err := s.connect(); err != nil {
 					log.Printf("Reconnection failed: %v", err)
+					if err := s.opts.RetryReconnect(attempt, err); err != nil {
+						return err
+					}
 					continue
 				}
 
@@ -103,6 +135,9 @@ func (s *Streamer) Stream() error {
 					log.Printf("Error resubscribing to symbols: %v", err)
 					s.conn.Close()
 					s.connected = false
+					if err := s.opts.RetryReconnect(attempt, err); err != nil {
+						return err
+					}
 					continue
 				}
 
@@ -114,8 +149,7 @@ func (s *Streamer) Stream() error {
 		}
 
 		// Parse and handle the message
-		var tradeData stream.TradeData
-		err = json.Unmarshal(message, &tradeData)
+		tradeData, err := s.decoder.Decode(message)
 		if err != nil {
 			log.Printf("Error parsing message: %v", err)
 			continue
@@ -124,17 +158,35 @@ func (s *Streamer) Stream() error {
 		// Process trades if we have any
 		if tradeData.Type == "trade" {
 			for _, trade := range tradeData.Data {
-				for _, handler := range s.handlers {
-					handler(trade)
-				}
+				s.prices.Update(trade)
+				s.subs.Set(trade.Symbol, stream.StateReceiving)
+				s.opts.Dispatch(s.handlers, trade)
 			}
 		}
 	}
 }
 
-// Close closes the websocket connection
+// LastPrice returns the last traded price of a symbol and the time of that
+// trade, without subscribing a handler. ok is false until a trade of the
+// symbol is received.
+func (s *Streamer) LastPrice(symbol string) (price float64, at time.Time, ok bool) {
+	return s.prices.Get(symbol)
+}
+
+// SubscriptionStatus returns the state of every requested symbol: requested,
+// subscribed, receiving or failed. States restart from requested on every
+// reconnect.
+func (s *Streamer) SubscriptionStatus() map[string]string {
+	return s.subs.Status()
+}
+
+// Close closes the websocket connection. It may be called while streaming,
+// e.g. to force a reconnect.
 func (s *Streamer) Close() error {
-	return s.conn.Close()
+	s.connMu.Lock()
+	conn := s.conn
+	s.connMu.Unlock()
+	return conn.Close()
 }
 
 // FormatSymbol formats a crypto pair into Finnhub format
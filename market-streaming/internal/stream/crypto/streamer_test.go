@@ -0,0 +1,416 @@
+package crypto
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"trade-sonic/market-streaming/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+// newFakeFinnhub starts a websocket server that sends msg once a client subscribes.
+// Negotiated extensions are reported on the extensions channel.
+func newFakeFinnhub(t *testing.T, msg string, extensions chan<- string) *httptest.Server {
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extensions <- r.Header.Get("Sec-WebSocket-Extensions")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.EnableWriteCompression(true)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Errorf("write failed: %v", err)
+			return
+		}
+		// Keep the connection open until the client goes away
+		conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStreamer_Compression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression bool
+	}{
+		{name: "compression enabled", compression: true},
+		{name: "compression disabled", compression: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extensions := make(chan string, 1)
+			msg := `{"type":"trade","data":[{"p":50000.5,"s":"BINANCE:BTCUSDT","t":1700000000000,"v":0.25}]}`
+			srv := newFakeFinnhub(t, msg, extensions)
+
+			url := "ws" + strings.TrimPrefix(srv.URL, "http")
+			s, err := NewStreamer("test-key", []string{FormatSymbol("BTC", "USDT")},
+				stream.WithURL(url), stream.WithCompression(tt.compression))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer s.Close()
+
+			negotiated := strings.Contains(<-extensions, "permessage-deflate")
+			if negotiated != tt.compression {
+				t.Errorf("Expected permessage-deflate offered=%v, got %v", tt.compression, negotiated)
+			}
+
+			trades := make(chan stream.Trade, 1)
+			s.AddHandler(func(trade stream.Trade) {
+				trades <- trade
+			})
+			if err := s.Subscribe(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			go s.Stream()
+
+			select {
+			case trade := <-trades:
+				if trade.Symbol != "BINANCE:BTCUSDT" || trade.Price != 50000.5 || trade.Volume != 0.25 {
+					t.Errorf("Unexpected trade decoded: %+v", trade)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for trade")
+			}
+		})
+	}
+}
+
+func TestStreamer_ReconnectsAfterDrop(t *testing.T) {
+	var connections atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		n := connections.Add(1)
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		// Drop the first connection right after the subscribe
+		if n == 1 {
+			return
+		}
+		msg := `{"type":"trade","data":[{"p":3000,"s":"BINANCE:ETHUSDT","t":1700000000000,"v":1}]}`
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Errorf("write failed: %v", err)
+			return
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	s, err := NewStreamer("test-key", []string{FormatSymbol("ETH", "USDT")}, stream.WithURL(url))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	trades := make(chan stream.Trade, 1)
+	s.AddHandler(func(trade stream.Trade) {
+		trades <- trade
+	})
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go s.Stream()
+
+	select {
+	case trade := <-trades:
+		if trade.Symbol != "BINANCE:ETHUSDT" {
+			t.Errorf("Unexpected trade decoded: %+v", trade)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for trade after reconnect")
+	}
+	if n := connections.Load(); n != 2 {
+		t.Errorf("Expected 2 connections, got %d", n)
+	}
+}
+
+func TestStreamer_LastPrice(t *testing.T) {
+	extensions := make(chan string, 1)
+	msg := `{"type":"trade","data":[` +
+		`{"p":50000,"s":"BINANCE:BTCUSDT","t":1700000000000,"v":0.1},` +
+		`{"p":3000,"s":"BINANCE:ETHUSDT","t":1700000000500,"v":1},` +
+		`{"p":50010.5,"s":"BINANCE:BTCUSDT","t":1700000001000,"v":0.2}]}`
+	srv := newFakeFinnhub(t, msg, extensions)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	s, err := NewStreamer("test-key", []string{FormatSymbol("BTC", "USDT"), FormatSymbol("ETH", "USDT")}, stream.WithURL(url))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+	<-extensions
+
+	if _, _, ok := s.LastPrice("BINANCE:BTCUSDT"); ok {
+		t.Error("Expected no last price before any trade")
+	}
+
+	trades := make(chan stream.Trade, 3)
+	s.AddHandler(func(trade stream.Trade) {
+		trades <- trade
+	})
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go s.Stream()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-trades:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for trades")
+		}
+	}
+
+	tests := []struct {
+		symbol        string
+		expectedOK    bool
+		expectedPrice float64
+		expectedAt    time.Time
+	}{
+		{symbol: "BINANCE:BTCUSDT", expectedOK: true, expectedPrice: 50010.5, expectedAt: time.UnixMilli(1700000001000)},
+		{symbol: "BINANCE:ETHUSDT", expectedOK: true, expectedPrice: 3000, expectedAt: time.UnixMilli(1700000000500)},
+		{symbol: "BINANCE:SOLUSDT", expectedOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			price, at, ok := s.LastPrice(tt.symbol)
+			if ok != tt.expectedOK {
+				t.Fatalf("Expected ok %v, got %v", tt.expectedOK, ok)
+			}
+			if price != tt.expectedPrice || (ok && !at.Equal(tt.expectedAt)) {
+				t.Errorf("Expected %v at %v, got %v at %v", tt.expectedPrice, tt.expectedAt, price, at)
+			}
+		})
+	}
+}
+
+func TestStreamer_SubscriptionStatus(t *testing.T) {
+	extensions := make(chan string, 1)
+	msg := `{"type":"trade","data":[{"p":50000,"s":"BINANCE:BTCUSDT","t":1700000000000,"v":0.1}]}`
+	srv := newFakeFinnhub(t, msg, extensions)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	symbol := FormatSymbol("BTC", "USDT")
+	s, err := NewStreamer("test-key", []string{symbol}, stream.WithURL(url))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+	<-extensions
+
+	if state := s.SubscriptionStatus()[symbol]; state != "requested" {
+		t.Errorf("Expected requested before subscribing, got %q", state)
+	}
+
+	// Trades are only read once Stream starts, after the subscribed check
+	trades := make(chan stream.Trade, 1)
+	s.AddHandler(func(trade stream.Trade) {
+		trades <- trade
+	})
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if state := s.SubscriptionStatus()[symbol]; state != "subscribed" {
+		t.Errorf("Expected subscribed before any trade, got %q", state)
+	}
+	go s.Stream()
+
+	select {
+	case <-trades:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a trade")
+	}
+	if state := s.SubscriptionStatus()[symbol]; state != "receiving" {
+		t.Errorf("Expected receiving after a trade, got %q", state)
+	}
+}
+
+// noSleep is a clock that returns immediately
+type noSleep struct{}
+
+func (noSleep) Sleep(time.Duration) {}
+
+func TestStreamer_ReconnectHandlerAborts(t *testing.T) {
+	var connections atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the first connection is accepted, and dropped after the subscribe
+		if connections.Add(1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	var attempts []int
+	s, err := NewStreamer("test-key", []string{FormatSymbol("ETH", "USDT")},
+		stream.WithURL(url),
+		stream.WithClock(noSleep{}),
+		stream.WithReconnectHandler(func(attempt int, err error) bool {
+			attempts = append(attempts, attempt)
+			return attempt < 2
+		}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Stream() }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, stream.ErrReconnectAborted) {
+			t.Errorf("Expected ErrReconnectAborted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Stream to give up")
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("Expected the handler to be consulted for attempts 1 and 2, got %v", attempts)
+	}
+}
+
+func TestStreamer_SurvivesPanickingHandler(t *testing.T) {
+	extensions := make(chan string, 1)
+	msg := `{"type":"trade","data":[` +
+		`{"p":50000,"s":"BINANCE:BTCUSDT","t":1700000000000,"v":0.1},` +
+		`{"p":50010.5,"s":"BINANCE:BTCUSDT","t":1700000001000,"v":0.2}]}`
+	srv := newFakeFinnhub(t, msg, extensions)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	var panics atomic.Int32
+	s, err := NewStreamer("test-key", []string{FormatSymbol("BTC", "USDT")},
+		stream.WithURL(url),
+		stream.WithHandlerPanicHandler(func(trade stream.Trade, recovered interface{}) {
+			panics.Add(1)
+		}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+	<-extensions
+
+	trades := make(chan stream.Trade, 2)
+	s.AddHandler(func(trade stream.Trade) {
+		panic("buggy handler")
+	})
+	s.AddHandler(func(trade stream.Trade) {
+		trades <- trade
+	})
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go s.Stream()
+
+	// Both trades reach the second handler although the first panics on each
+	for _, expected := range []float64{50000, 50010.5} {
+		select {
+		case trade := <-trades:
+			if trade.Price != expected {
+				t.Errorf("Expected price %v, got %v", expected, trade.Price)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for trades")
+		}
+	}
+	if got := panics.Load(); got != 2 {
+		t.Errorf("Expected 2 recovered panics, got %d", got)
+	}
+}
+
+func TestStreamer_IdleReconnectWhileStreaming(t *testing.T) {
+	var connections atomic.Int32
+	var stopping atomic.Bool
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stopping.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		connections.Add(1)
+		// Never send a trade, so the connection looks idle
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	s, err := NewStreamer("test-key", []string{FormatSymbol("ETH", "USDT")},
+		stream.WithURL(url),
+		stream.WithClock(noSleep{}),
+		stream.WithReconnectHandler(func(int, error) bool { return !stopping.Load() }))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The wrapper closes the connection from its own goroutine while Stream
+	// replaces it, which the race detector checks
+	w := stream.NewIdleReconnectWrapper(s, 20*time.Millisecond, stream.WithIdleCheckInterval(5*time.Millisecond))
+	if err := w.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Stream() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for connections.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := connections.Load(); n < 3 {
+		t.Fatalf("Expected idle reconnects, got %d connections", n)
+	}
+
+	stopping.Store(true)
+	w.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, stream.ErrReconnectAborted) {
+			t.Errorf("Expected ErrReconnectAborted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Stream to stop")
+	}
+}
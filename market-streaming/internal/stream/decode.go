@@ -0,0 +1,242 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// TradeFields names the JSON fields of a trade in the provider's frames
+type TradeFields struct {
+	Price     string
+	Symbol    string
+	Timestamp string
+	Volume    string
+	// Conditions is optional, an empty name ignores conditions
+	Conditions string
+}
+
+// DefaultTradeFields returns Finnhub's trade field names
+func DefaultTradeFields() TradeFields {
+	return TradeFields{
+		Price:      "p",
+		Symbol:     "s",
+		Timestamp:  "t",
+		Volume:     "v",
+		Conditions: "c",
+	}
+}
+
+// withDefaults fills the required names left empty, price, symbol and
+// timestamp, with Finnhub's; a trade could never be decoded otherwise
+func (f TradeFields) withDefaults() TradeFields {
+	defaults := DefaultTradeFields()
+	if f.Price == "" {
+		f.Price = defaults.Price
+	}
+	if f.Symbol == "" {
+		f.Symbol = defaults.Symbol
+	}
+	if f.Timestamp == "" {
+		f.Timestamp = defaults.Timestamp
+	}
+	return f
+}
+
+// names returns the configured field names
+func (f TradeFields) names() []string {
+	return []string{f.Price, f.Symbol, f.Timestamp, f.Volume, f.Conditions}
+}
+
+// TradeDecoder decodes trade frames leniently, so a frame Finnhub changed
+// slightly still delivers its trades. Numbers may arrive as JSON strings,
+// data may be a single trade instead of a list and unknown fields are
+// ignored. Trades without a symbol, price or timestamp are skipped.
+// Everything unexpected is logged: skipped trades every time, unknown fields
+// once per name. It is safe for concurrent use.
+type TradeDecoder struct {
+	fields TradeFields
+
+	mu       sync.Mutex
+	reported map[string]bool // Unknown field names already logged
+}
+
+// NewTradeDecoder creates a decoder for trades with the given field names.
+// Empty price, symbol or timestamp names keep Finnhub's.
+func NewTradeDecoder(fields TradeFields) *TradeDecoder {
+	return &TradeDecoder{fields: fields.withDefaults(), reported: make(map[string]bool)}
+}
+
+// Decode decodes a websocket frame. Frames that are not trades, e.g. pings,
+// are returned without data. It only fails for frames that are not a JSON
+// object.
+func (d *TradeDecoder) Decode(message []byte) (TradeData, error) {
+	var frame struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(message, &frame); err != nil {
+		return TradeData{}, err
+	}
+	tradeData := TradeData{Type: frame.Type}
+	if frame.Type != "trade" {
+		return tradeData, nil
+	}
+
+	var entries []json.RawMessage
+	switch data := bytes.TrimSpace(frame.Data); {
+	case len(data) == 0 || bytes.Equal(data, []byte("null")):
+		log.Printf("Trade frame without data: %s", message)
+		return tradeData, nil
+	case data[0] == '{':
+		// A single trade rather than a list of them
+		entries = []json.RawMessage{data}
+	default:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			log.Printf("Unexpected trade data, expected a list of trades: %s", data)
+			return tradeData, nil
+		}
+	}
+
+	for i, entry := range entries {
+		trade, err := d.decodeTrade(entry)
+		if err != nil {
+			log.Printf("Skipping trade %d of frame: %v: %s", i, err, entry)
+			continue
+		}
+		tradeData.Data = append(tradeData.Data, trade)
+	}
+	return tradeData, nil
+}
+
+// decodeTrade decodes a single trade object
+func (d *TradeDecoder) decodeTrade(entry json.RawMessage) (Trade, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &raw); err != nil {
+		return Trade{}, errors.New("not an object")
+	}
+	d.reportUnknownFields(raw)
+
+	var trade Trade
+	if err := decodeField(raw, d.fields.Symbol, true, decodeString, &trade.Symbol); err != nil {
+		return Trade{}, err
+	}
+	if trade.Symbol == "" {
+		return Trade{}, fmt.Errorf("empty %s", d.fields.Symbol)
+	}
+	if err := decodeField(raw, d.fields.Price, true, decodeNumber, &trade.Price); err != nil {
+		return Trade{}, err
+	}
+	var timestamp float64
+	if err := decodeField(raw, d.fields.Timestamp, true, decodeNumber, &timestamp); err != nil {
+		return Trade{}, err
+	}
+	trade.Timestamp = int64(timestamp)
+	// Some crypto frames carry no volume
+	if err := decodeField(raw, d.fields.Volume, false, decodeNumber, &trade.Volume); err != nil {
+		return Trade{}, err
+	}
+	if d.fields.Conditions != "" {
+		if err := decodeField(raw, d.fields.Conditions, false, decodeConditions, &trade.Conditions); err != nil {
+			// Conditions are informational, the trade is still valid
+			log.Printf("Ignoring trade conditions of %s: %v", trade.Symbol, err)
+		}
+	}
+	return trade, nil
+}
+
+// reportUnknownFields logs fields outside the configured ones, each name
+// only the first time it is seen
+func (d *TradeDecoder) reportUnknownFields(raw map[string]json.RawMessage) {
+	known := d.fields.names()
+	var unknown []string
+	d.mu.Lock()
+	for name := range raw {
+		if !contains(known, name) && !d.reported[name] {
+			d.reported[name] = true
+			unknown = append(unknown, name)
+		}
+	}
+	d.mu.Unlock()
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Printf("Ignoring unknown trade fields %v", unknown)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeField decodes the field name of raw into out. A missing or null
+// field is an error when required and leaves out unchanged otherwise.
+func decodeField[T any](raw map[string]json.RawMessage, name string, required bool, decode func(json.RawMessage) (T, error), out *T) error {
+	value, exists := raw[name]
+	if !exists || bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		if required {
+			return fmt.Errorf("missing %s", name)
+		}
+		return nil
+	}
+	decoded, err := decode(value)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", name, err)
+	}
+	*out = decoded
+	return nil
+}
+
+func decodeString(value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", fmt.Errorf("expected a string, got %s", value)
+	}
+	return s, nil
+}
+
+// decodeNumber accepts a JSON number or a string holding one
+func decodeNumber(value json.RawMessage) (float64, error) {
+	var n float64
+	if err := json.Unmarshal(value, &n); err == nil {
+		return n, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("expected a number, got %s", value)
+}
+
+// decodeConditions accepts a list of strings or numbers, or a single one
+func decodeConditions(value json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(value, &list); err != nil {
+		list = []json.RawMessage{value}
+	}
+	conditions := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, err := decodeString(item); err == nil {
+			conditions = append(conditions, s)
+			continue
+		}
+		var n json.Number
+		if err := json.Unmarshal(item, &n); err != nil {
+			return nil, fmt.Errorf("expected strings or numbers, got %s", value)
+		}
+		conditions = append(conditions, n.String())
+	}
+	return conditions, nil
+}
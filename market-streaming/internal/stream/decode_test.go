@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captureLog collects what the standard logger writes during the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestTradeDecoder_Decode(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected []Trade
+	}{
+		{
+			name:     "plain trade",
+			message:  `{"type":"trade","data":[{"p":150.5,"s":"AAPL","t":1700000000000,"v":100}]}`,
+			expected: []Trade{{Price: 150.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 100}},
+		},
+		{
+			name:    "extra and optional fields",
+			message: `{"type":"trade","data":[{"p":150.5,"s":"AAPL","t":1700000000000,"v":100,"c":["1","12"],"x":"NASDAQ"}]}`,
+			expected: []Trade{{
+				Price: 150.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 100,
+				Conditions: []string{"1", "12"},
+			}},
+		},
+		{
+			name:    "numeric conditions",
+			message: `{"type":"trade","data":[{"p":150.5,"s":"AAPL","t":1700000000000,"v":100,"c":[1,24]}]}`,
+			expected: []Trade{{
+				Price: 150.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 100,
+				Conditions: []string{"1", "24"},
+			}},
+		},
+		{
+			name:     "numbers as strings",
+			message:  `{"type":"trade","data":[{"p":"50000.5","s":"BINANCE:BTCUSDT","t":"1700000000000","v":"0.25"}]}`,
+			expected: []Trade{{Price: 50000.5, Symbol: "BINANCE:BTCUSDT", Timestamp: 1700000000000, Volume: 0.25}},
+		},
+		{
+			name:     "missing volume",
+			message:  `{"type":"trade","data":[{"p":150.5,"s":"AAPL","t":1700000000000}]}`,
+			expected: []Trade{{Price: 150.5, Symbol: "AAPL", Timestamp: 1700000000000}},
+		},
+		{
+			name:     "single trade instead of a list",
+			message:  `{"type":"trade","data":{"p":150.5,"s":"AAPL","t":1700000000000,"v":100}}`,
+			expected: []Trade{{Price: 150.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 100}},
+		},
+		{
+			name: "malformed trades are skipped",
+			message: `{"type":"trade","data":[
+				{"p":150.5,"t":1700000000000,"v":100},
+				{"p":"n/a","s":"MSFT","t":1700000000000,"v":100},
+				"AAPL",
+				{"p":300,"s":"MSFT","t":1700000000000,"v":5}
+			]}`,
+			expected: []Trade{{Price: 300, Symbol: "MSFT", Timestamp: 1700000000000, Volume: 5}},
+		},
+		{
+			name:     "malformed conditions keep the trade",
+			message:  `{"type":"trade","data":[{"p":150.5,"s":"AAPL","t":1700000000000,"v":100,"c":{"code":1}}]}`,
+			expected: []Trade{{Price: 150.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 100}},
+		},
+		{
+			name:    "unexpected data",
+			message: `{"type":"trade","data":"AAPL"}`,
+		},
+		{
+			name:    "ping",
+			message: `{"type":"ping"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			tradeData, err := NewTradeDecoder(DefaultTradeFields()).Decode([]byte(tt.message))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(tradeData.Data, tt.expected) {
+				t.Errorf("Expected trades %+v, got %+v", tt.expected, tradeData.Data)
+			}
+		})
+	}
+}
+
+func TestTradeDecoder_InvalidFrame(t *testing.T) {
+	if _, err := NewTradeDecoder(DefaultTradeFields()).Decode([]byte(`not json`)); err == nil {
+		t.Error("Expected an error for a frame that is not JSON")
+	}
+}
+
+func TestTradeDecoder_ReportsUnknownFieldsOnce(t *testing.T) {
+	logs := captureLog(t)
+	decoder := NewTradeDecoder(DefaultTradeFields())
+
+	message := []byte(`{"type":"trade","data":[{"p":150.5,"s":"AAPL","t":1700000000000,"v":100,"x":"NASDAQ"}]}`)
+	for i := 0; i < 3; i++ {
+		if _, err := decoder.Decode(message); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if count := strings.Count(logs.String(), "unknown trade fields [x]"); count != 1 {
+		t.Errorf("Expected the unknown field to be reported once, got %d times: %s", count, logs)
+	}
+}
+
+func TestTradeDecoder_CustomFields(t *testing.T) {
+	captureLog(t)
+	fields := TradeFields{Price: "price", Symbol: "symbol", Timestamp: "ts", Volume: "size"}
+	decoder := NewTradeDecoder(fields)
+
+	tradeData, err := decoder.Decode([]byte(`{"type":"trade","data":[{"price":1.5,"symbol":"AAPL","ts":1700000000000,"size":3,"c":["1"]}]}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Conditions are not mapped, so c is just an unknown field
+	expected := []Trade{{Price: 1.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 3}}
+	if !reflect.DeepEqual(tradeData.Data, expected) {
+		t.Errorf("Expected trades %+v, got %+v", expected, tradeData.Data)
+	}
+
+	if opts := NewOptions(WithTradeFields(fields)); opts.TradeFields != fields {
+		t.Errorf("Expected trade fields %+v, got %+v", fields, opts.TradeFields)
+	}
+}
+
+func TestTradeDecoder_PartialCustomFields(t *testing.T) {
+	captureLog(t)
+	// Only the volume is renamed, the required names keep Finnhub's
+	fields := TradeFields{Volume: "size"}
+	expectedFields := TradeFields{Price: "p", Symbol: "s", Timestamp: "t", Volume: "size"}
+	if opts := NewOptions(WithTradeFields(fields)); opts.TradeFields != expectedFields {
+		t.Errorf("Expected trade fields %+v, got %+v", expectedFields, opts.TradeFields)
+	}
+
+	tradeData, err := NewTradeDecoder(fields).Decode([]byte(`{"type":"trade","data":[{"p":1.5,"s":"AAPL","t":1700000000000,"size":3}]}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []Trade{{Price: 1.5, Symbol: "AAPL", Timestamp: 1700000000000, Volume: 3}}
+	if !reflect.DeepEqual(tradeData.Data, expected) {
+		t.Errorf("Expected trades %+v, got %+v", expected, tradeData.Data)
+	}
+}
@@ -0,0 +1,30 @@
+package stream
+
+import (
+	"log"
+	"runtime/debug"
+)
+
+// Dispatch calls every handler with trade in order. A panicking handler is
+// recovered, logged and reported to OnHandlerPanic, so it neither stops the
+// stream nor keeps the other handlers from receiving the trade.
+func (o Options) Dispatch(handlers []TradeHandler, trade Trade) {
+	for _, handler := range handlers {
+		o.callHandler(handler, trade)
+	}
+}
+
+// callHandler calls handler with trade, recovering from a panic
+func (o Options) callHandler(handler TradeHandler, trade Trade) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		log.Printf("Trade handler panicked on %s: %v\n%s", trade.Symbol, recovered, debug.Stack())
+		if o.OnHandlerPanic != nil {
+			o.OnHandlerPanic(trade, recovered)
+		}
+	}()
+	handler(trade)
+}
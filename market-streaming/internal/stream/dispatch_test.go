@@ -0,0 +1,43 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispatch_RecoversPanickingHandler(t *testing.T) {
+	var calls []string
+	var panics []interface{}
+	opts := NewOptions(WithHandlerPanicHandler(func(trade Trade, recovered interface{}) {
+		calls = append(calls, "panic "+trade.Symbol)
+		panics = append(panics, recovered)
+	}))
+
+	handlers := []TradeHandler{
+		func(trade Trade) { calls = append(calls, "first "+trade.Symbol) },
+		func(trade Trade) { panic("buggy handler") },
+		func(trade Trade) { calls = append(calls, "last "+trade.Symbol) },
+	}
+	opts.Dispatch(handlers, Trade{Symbol: "AAPL"})
+	opts.Dispatch(handlers, Trade{Symbol: "MSFT"})
+
+	expected := "first AAPL,panic AAPL,last AAPL,first MSFT,panic MSFT,last MSFT"
+	if got := strings.Join(calls, ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if len(panics) != 2 || panics[0] != "buggy handler" {
+		t.Errorf("Expected the recovered values, got %v", panics)
+	}
+}
+
+func TestDispatch_WithoutPanicHandler(t *testing.T) {
+	received := 0
+	NewOptions().Dispatch([]TradeHandler{
+		func(Trade) { panic("buggy handler") },
+		func(Trade) { received++ },
+	}, Trade{Symbol: "AAPL"})
+
+	if received != 1 {
+		t.Errorf("Expected the handler after the panicking one to run, got %d calls", received)
+	}
+}
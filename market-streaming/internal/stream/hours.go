@@ -0,0 +1,49 @@
+package stream
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultMarketTimezone is the timezone of the US stock exchanges
+const DefaultMarketTimezone = "America/New_York"
+
+// MarketHours are the regular weekday trading hours of an exchange. Open and
+// Close are times of day in Location, the exchange's timezone.
+type MarketHours struct {
+	Location *time.Location
+	Open     time.Duration
+	Close    time.Duration
+}
+
+// USStockHours returns the regular hours of the US stock exchanges, 9:30 AM
+// to 4:00 PM in loc. A nil loc loads DefaultMarketTimezone.
+func USStockHours(loc *time.Location) MarketHours {
+	return MarketHours{
+		Location: loc,
+		Open:     9*time.Hour + 30*time.Minute,
+		Close:    16 * time.Hour,
+	}
+}
+
+// IsOpen reports whether t falls strictly within the trading hours of a
+// weekday in the market's timezone. Without a location, DefaultMarketTimezone
+// is loaded; if that fails the market is reported closed.
+func (h MarketHours) IsOpen(t time.Time) bool {
+	loc := h.Location
+	if loc == nil {
+		var err error
+		if loc, err = time.LoadLocation(DefaultMarketTimezone); err != nil {
+			log.Printf("Error loading timezone: %v", err)
+			return false
+		}
+	}
+
+	local := t.In(loc)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return false
+	}
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	open, close := midnight.Add(h.Open), midnight.Add(h.Close)
+	return local.After(open) && local.Before(close)
+}
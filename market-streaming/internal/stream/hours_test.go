@@ -0,0 +1,47 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarketHours_IsOpen(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	// LSE hours, to show another exchange can use its own
+	lse := MarketHours{Location: london, Open: 8 * time.Hour, Close: 16*time.Hour + 30*time.Minute}
+
+	tests := []struct {
+		name     string
+		hours    MarketHours
+		at       time.Time
+		expected bool
+	}{
+		{name: "default during US hours", hours: USStockHours(nil), at: time.Date(2026, 3, 4, 10, 0, 0, 0, newYork), expected: true},
+		{name: "default before the US open", hours: USStockHours(nil), at: time.Date(2026, 3, 4, 9, 0, 0, 0, newYork), expected: false},
+		{name: "default after the US close", hours: USStockHours(nil), at: time.Date(2026, 3, 4, 16, 0, 0, 0, newYork), expected: false},
+		{name: "US weekend", hours: USStockHours(newYork), at: time.Date(2026, 3, 7, 12, 0, 0, 0, newYork), expected: false},
+		// 3 PM in London is 10 AM in New York
+		{name: "US hours from a London time", hours: USStockHours(newYork), at: time.Date(2026, 3, 4, 15, 0, 0, 0, london), expected: true},
+		// Noon in New York is 5 PM in London
+		{name: "US hours moved to London", hours: USStockHours(london), at: time.Date(2026, 3, 4, 12, 0, 0, 0, newYork), expected: false},
+		{name: "LSE open", hours: lse, at: time.Date(2026, 3, 4, 8, 30, 0, 0, london), expected: true},
+		{name: "LSE closed during US hours", hours: lse, at: time.Date(2026, 3, 4, 13, 0, 0, 0, newYork), expected: false},
+		// Friday evening in New York is already Saturday in Tokyo
+		{name: "weekday in the market timezone", hours: MarketHours{Location: newYork, Open: 0, Close: 24 * time.Hour}, at: time.Date(2026, 3, 7, 8, 0, 0, 0, time.FixedZone("JST", 9*3600)), expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if open := tt.hours.IsOpen(tt.at); open != tt.expected {
+				t.Errorf("Expected open %v at %v, got %v", tt.expected, tt.at, open)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package stream
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// IdleReconnectWrapper wraps a MarketStreamer and forces a reconnect when
+// no trade was delivered within the idle timeout. A connection can stay open
+// while the server stops sending, which the streamers cannot tell from a
+// quiet market. The wrapper closes the connection instead; the wrapped
+// streamer's Stream loop then re-dials and resubscribes, as after any
+// connection error.
+type IdleReconnectWrapper struct {
+	MarketStreamer
+
+	timeout     time.Duration
+	interval    time.Duration
+	active      func(now time.Time) bool
+	now         func() time.Time
+	onReconnect func(idle time.Duration)
+
+	mu           sync.Mutex
+	lastActivity time.Time
+	reconnects   int
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// IdleOption configures an IdleReconnectWrapper
+type IdleOption func(*IdleReconnectWrapper)
+
+// WithActiveHours limits idle reconnects to times for which active returns
+// true, e.g. stock market trading hours. The idle window starts over when
+// the active hours begin.
+func WithActiveHours(active func(now time.Time) bool) IdleOption {
+	return func(w *IdleReconnectWrapper) {
+		w.active = active
+	}
+}
+
+// WithIdleCheckInterval sets how often the idle time is checked. It
+// defaults to a quarter of the timeout.
+func WithIdleCheckInterval(interval time.Duration) IdleOption {
+	return func(w *IdleReconnectWrapper) {
+		w.interval = interval
+	}
+}
+
+// WithIdleReconnectHandler registers a callback for forced reconnects
+func WithIdleReconnectHandler(handler func(idle time.Duration)) IdleOption {
+	return func(w *IdleReconnectWrapper) {
+		w.onReconnect = handler
+	}
+}
+
+// withNow replaces the wrapper's clock, for tests
+func withNow(now func() time.Time) IdleOption {
+	return func(w *IdleReconnectWrapper) {
+		w.now = now
+	}
+}
+
+// NewIdleReconnectWrapper wraps streamer with an idle timeout. It adds a
+// handler to streamer to observe delivered trades, so it must be created
+// before the streamer starts streaming.
+func NewIdleReconnectWrapper(streamer MarketStreamer, timeout time.Duration, opts ...IdleOption) *IdleReconnectWrapper {
+	w := &IdleReconnectWrapper{
+		MarketStreamer: streamer,
+		timeout:        timeout,
+		interval:       timeout / 4,
+		now:            time.Now,
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.lastActivity = w.now()
+	streamer.AddHandler(func(Trade) { w.touch() })
+	return w
+}
+
+// Stream monitors the idle time while the wrapped streamer streams
+func (w *IdleReconnectWrapper) Stream() error {
+	go w.monitor()
+	return w.MarketStreamer.Stream()
+}
+
+// Close stops monitoring and closes the wrapped streamer
+func (w *IdleReconnectWrapper) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	return w.MarketStreamer.Close()
+}
+
+// LastActivity returns when a trade was last delivered, or when the idle
+// window last started over
+func (w *IdleReconnectWrapper) LastActivity() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastActivity
+}
+
+// Reconnects returns how many reconnects the idle timeout forced
+func (w *IdleReconnectWrapper) Reconnects() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reconnects
+}
+
+// monitor checks the idle time every interval until the wrapper is closed
+func (w *IdleReconnectWrapper) monitor() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check forces a reconnect if the streamer has been idle for longer than
+// the timeout during active hours, and reports whether it did
+func (w *IdleReconnectWrapper) check() bool {
+	now := w.now()
+
+	w.mu.Lock()
+	if w.active != nil && !w.active(now) {
+		w.lastActivity = now
+		w.mu.Unlock()
+		return false
+	}
+	idle := now.Sub(w.lastActivity)
+	if idle < w.timeout {
+		w.mu.Unlock()
+		return false
+	}
+	// Give the new connection a full window before the next reconnect
+	w.lastActivity = now
+	w.reconnects++
+	w.mu.Unlock()
+
+	log.Printf("No trades for %v, forcing a reconnect", idle.Round(time.Second))
+	if w.onReconnect != nil {
+		w.onReconnect(idle)
+	}
+	if err := w.MarketStreamer.Close(); err != nil {
+		log.Printf("Error closing idle connection: %v", err)
+	}
+	return true
+}
+
+// touch records a delivered trade
+func (w *IdleReconnectWrapper) touch() {
+	now := w.now()
+	w.mu.Lock()
+	w.lastActivity = now
+	w.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStreamer delivers trades on demand and counts closes. Stream blocks
+// until stop is closed.
+type fakeStreamer struct {
+	mu       sync.Mutex
+	handlers []TradeHandler
+	closes   int
+	closed   chan struct{}
+	stop     chan struct{}
+}
+
+func newFakeStreamer() *fakeStreamer {
+	return &fakeStreamer{closed: make(chan struct{}, 16), stop: make(chan struct{})}
+}
+
+func (f *fakeStreamer) Subscribe() error                            { return nil }
+func (f *fakeStreamer) LastPrice(string) (float64, time.Time, bool) { return 0, time.Time{}, false }
+func (f *fakeStreamer) SubscriptionStatus() map[string]string       { return nil }
+
+func (f *fakeStreamer) Stream() error {
+	<-f.stop
+	return nil
+}
+
+func (f *fakeStreamer) AddHandler(handler TradeHandler) {
+	f.handlers = append(f.handlers, handler)
+}
+
+func (f *fakeStreamer) Close() error {
+	f.mu.Lock()
+	f.closes++
+	f.mu.Unlock()
+	f.closed <- struct{}{}
+	return nil
+}
+
+func (f *fakeStreamer) deliver(trade Trade) {
+	for _, handler := range f.handlers {
+		handler(trade)
+	}
+}
+
+func (f *fakeStreamer) closeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closes
+}
+
+func TestIdleReconnectWrapper_ReconnectsWhenIdle(t *testing.T) {
+	start := time.Date(2025, 3, 10, 15, 0, 0, 0, time.UTC)
+	now := start
+	var idles []time.Duration
+	f := newFakeStreamer()
+	w := NewIdleReconnectWrapper(f, time.Minute,
+		withNow(func() time.Time { return now }),
+		WithIdleReconnectHandler(func(idle time.Duration) { idles = append(idles, idle) }))
+
+	now = start.Add(30 * time.Second)
+	if w.check() {
+		t.Fatal("Expected no reconnect within the timeout")
+	}
+
+	// A trade restarts the idle window
+	f.deliver(Trade{Symbol: "AAPL", Price: 190})
+	now = start.Add(80 * time.Second)
+	if w.check() || f.closeCount() != 0 {
+		t.Fatalf("Expected no reconnect 50s after a trade, got %d closes", f.closeCount())
+	}
+
+	now = start.Add(91 * time.Second)
+	if !w.check() {
+		t.Fatal("Expected a reconnect after 61s without trades")
+	}
+	if f.closeCount() != 1 || w.Reconnects() != 1 {
+		t.Errorf("Expected 1 close and 1 reconnect, got %d and %d", f.closeCount(), w.Reconnects())
+	}
+	if len(idles) != 1 || idles[0] != 61*time.Second {
+		t.Errorf("Expected the handler to get 61s idle, got %v", idles)
+	}
+
+	// The new connection gets a full window
+	now = start.Add(120 * time.Second)
+	if w.check() {
+		t.Error("Expected no reconnect right after the last one")
+	}
+	now = start.Add(151 * time.Second)
+	if !w.check() || w.Reconnects() != 2 {
+		t.Errorf("Expected a second reconnect, got %d", w.Reconnects())
+	}
+}
+
+func TestIdleReconnectWrapper_OutsideActiveHours(t *testing.T) {
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	now := start
+	trading := false
+	f := newFakeStreamer()
+	w := NewIdleReconnectWrapper(f, time.Minute,
+		withNow(func() time.Time { return now }),
+		WithActiveHours(func(time.Time) bool { return trading }))
+
+	now = start.Add(2 * time.Hour)
+	if w.check() {
+		t.Fatal("Expected no reconnect outside active hours")
+	}
+
+	// The window starts over when the market opens
+	trading = true
+	now = start.Add(2*time.Hour + 30*time.Second)
+	if w.check() {
+		t.Fatal("Expected no reconnect 30s into active hours")
+	}
+	now = start.Add(2*time.Hour + 61*time.Second)
+	if !w.check() || f.closeCount() != 1 {
+		t.Errorf("Expected a reconnect once idle during active hours, got %d closes", f.closeCount())
+	}
+}
+
+func TestIdleReconnectWrapper_Stream(t *testing.T) {
+	f := newFakeStreamer()
+	w := NewIdleReconnectWrapper(f, 20*time.Millisecond, WithIdleCheckInterval(5*time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		w.Stream()
+		close(done)
+	}()
+
+	select {
+	case <-f.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an idle reconnect")
+	}
+
+	// Closing the wrapper stops the monitor and closes the streamer
+	w.Close()
+	closes := f.closeCount()
+	time.Sleep(50 * time.Millisecond)
+	if f.closeCount() != closes {
+		t.Errorf("Expected no reconnects after Close, got %d more", f.closeCount()-closes)
+	}
+	close(f.stop)
+	<-done
+}
@@ -0,0 +1,71 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultKeyRotationThreshold is how many consecutive auth failures a
+// streamer tolerates before asking the key provider for the next key
+const DefaultKeyRotationThreshold = 2
+
+// ErrAuthRejected is returned when Finnhub refuses the websocket handshake
+// because of the API key
+var ErrAuthRejected = errors.New("API key rejected")
+
+// KeyProvider supplies replacement API keys, e.g. after a key was rotated
+// and the one in use revoked
+type KeyProvider interface {
+	// NextKey returns the key to use once rejected was refused
+	NextKey(rejected string) (string, error)
+}
+
+// KeyList is a KeyProvider cycling through a fixed list of keys
+type KeyList struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+// NewKeyList creates a provider cycling through keys in order
+func NewKeyList(keys ...string) *KeyList {
+	return &KeyList{keys: keys}
+}
+
+// NextKey implements KeyProvider. It returns the key following rejected,
+// wrapping around, or the first key when rejected is not in the list.
+func (l *KeyList) NextKey(rejected string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, key := range l.keys {
+		if key == rejected {
+			next := l.keys[(i+1)%len(l.keys)]
+			if next == rejected {
+				return "", errors.New("no other API key configured")
+			}
+			return next, nil
+		}
+	}
+	if len(l.keys) == 0 {
+		return "", errors.New("no API keys configured")
+	}
+	return l.keys[0], nil
+}
+
+// IsAuthFailure reports whether a connection or read error means the API
+// key was refused: a rejected handshake, or a policy violation close as
+// Finnhub sends when a key is revoked mid-session
+func IsAuthFailure(err error) bool {
+	return errors.Is(err, ErrAuthRejected) || websocket.IsCloseError(err, websocket.ClosePolicyViolation)
+}
+
+// MaskKey shortens an API key to its last four characters for logging
+func MaskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return fmt.Sprintf("****%s", key[len(key)-4:])
+}
@@ -0,0 +1,62 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestKeyList_NextKey(t *testing.T) {
+	keys := NewKeyList("key-a", "key-b", "key-c")
+
+	tests := []struct {
+		rejected string
+		expected string
+	}{
+		{rejected: "key-a", expected: "key-b"},
+		{rejected: "key-c", expected: "key-a"},
+		{rejected: "unknown", expected: "key-a"},
+	}
+	for _, tt := range tests {
+		next, err := keys.NextKey(tt.rejected)
+		if err != nil || next != tt.expected {
+			t.Errorf("Expected %s after %s, got %s, %v", tt.expected, tt.rejected, next, err)
+		}
+	}
+
+	if _, err := NewKeyList("key-a").NextKey("key-a"); err == nil {
+		t.Error("Expected an error without another key")
+	}
+	if _, err := NewKeyList().NextKey("key-a"); err == nil {
+		t.Error("Expected an error without keys")
+	}
+}
+
+func TestIsAuthFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "rejected handshake", err: fmt.Errorf("connecting: %w", ErrAuthRejected), expected: true},
+		{name: "policy violation close", err: &websocket.CloseError{Code: websocket.ClosePolicyViolation}, expected: true},
+		{name: "abnormal close", err: &websocket.CloseError{Code: websocket.CloseAbnormalClosure}},
+		{name: "network error", err: errors.New("connection reset by peer")},
+	}
+	for _, tt := range tests {
+		if got := IsAuthFailure(tt.err); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	if masked := MaskKey("abcdef123456"); masked != "****3456" {
+		t.Errorf("Expected ****3456, got %s", masked)
+	}
+	if masked := MaskKey("abc"); masked != "****" {
+		t.Errorf("Expected ****, got %s", masked)
+	}
+}
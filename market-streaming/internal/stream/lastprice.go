@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// LastPrices tracks the most recent trade price of each symbol. It is safe
+// for concurrent use, so readers can query it while a streamer updates it.
+type LastPrices struct {
+	mu     sync.RWMutex
+	prices map[string]lastPrice
+}
+
+// lastPrice is the price and time of a symbol's most recent trade
+type lastPrice struct {
+	price float64
+	at    time.Time
+}
+
+// NewLastPrices creates an empty last price tracker
+func NewLastPrices() *LastPrices {
+	return &LastPrices{prices: make(map[string]lastPrice)}
+}
+
+// Update records a trade, unless a later trade of the symbol was already
+// recorded
+func (l *LastPrices) Update(trade Trade) {
+	at := time.UnixMilli(trade.Timestamp)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, exists := l.prices[trade.Symbol]; exists && at.Before(last.at) {
+		return
+	}
+	l.prices[trade.Symbol] = lastPrice{price: trade.Price, at: at}
+}
+
+// Get returns the last traded price of a symbol and the time of that trade.
+// ok is false when no trade of the symbol has been seen.
+func (l *LastPrices) Get(symbol string) (price float64, at time.Time, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	last, ok := l.prices[symbol]
+	return last.price, last.at, ok
+}
@@ -0,0 +1,74 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLastPrices(t *testing.T) {
+	base := time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)
+	trade := func(symbol string, offset time.Duration, price float64) Trade {
+		return Trade{Symbol: symbol, Price: price, Volume: 1, Timestamp: base.Add(offset).UnixMilli()}
+	}
+
+	l := NewLastPrices()
+	l.Update(trade("AAPL", 0, 100))
+	l.Update(trade("AAPL", 2*time.Second, 102))
+	l.Update(trade("MSFT", time.Second, 400))
+	// Arrives late, so the newer AAPL price is kept
+	l.Update(trade("AAPL", time.Second, 101))
+
+	tests := []struct {
+		symbol        string
+		expectedOK    bool
+		expectedPrice float64
+		expectedAt    time.Time
+	}{
+		{symbol: "AAPL", expectedOK: true, expectedPrice: 102, expectedAt: base.Add(2 * time.Second)},
+		{symbol: "MSFT", expectedOK: true, expectedPrice: 400, expectedAt: base.Add(time.Second)},
+		{symbol: "TSLA", expectedOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			price, at, ok := l.Get(tt.symbol)
+			if ok != tt.expectedOK {
+				t.Fatalf("Expected ok %v, got %v", tt.expectedOK, ok)
+			}
+			if price != tt.expectedPrice {
+				t.Errorf("Expected price %v, got %v", tt.expectedPrice, price)
+			}
+			if tt.expectedOK && !at.Equal(tt.expectedAt) {
+				t.Errorf("Expected time %v, got %v", tt.expectedAt, at)
+			}
+		})
+	}
+}
+
+func TestLastPrices_Concurrent(t *testing.T) {
+	l := NewLastPrices()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				l.Update(Trade{Symbol: "AAPL", Price: float64(n), Timestamp: int64(i*100 + n)})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				l.Get("AAPL")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The latest timestamp wins regardless of update order
+	if price, _, ok := l.Get("AAPL"); !ok || price != 99 {
+		t.Errorf("Expected price 99, got %v (ok %v)", price, ok)
+	}
+}
@@ -1,5 +1,7 @@
 package stream
 
+import "time"
+
 // MarketStreamer defines the interface for market data streaming
 type MarketStreamer interface {
 	// Subscribe subscribes to the specified symbols
@@ -8,6 +10,10 @@ type MarketStreamer interface {
 	Stream() error
 	// AddHandler adds a new trade handler
 	AddHandler(handler TradeHandler)
+	// LastPrice returns the last traded price of a symbol and its time
+	LastPrice(symbol string) (float64, time.Time, bool)
+	// SubscriptionStatus returns the subscription state of each requested symbol
+	SubscriptionStatus() map[string]string
 	// Close closes the connection
 	Close() error
 }
@@ -14,6 +14,8 @@ type Trade struct {
 	Symbol    string  `json:"s"` // Symbol
 	Timestamp int64   `json:"t"` // Timestamp
 	Volume    float64 `json:"v"` // Volume
+	// Conditions are the exchange's trade condition codes, when reported
+	Conditions []string `json:"c,omitempty"`
 }
 
 // FormatSymbol formats a crypto pair into Finnhub format
@@ -0,0 +1,272 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultURL is the Finnhub websocket endpoint
+	DefaultURL = "wss://ws.finnhub.io"
+	// DefaultMaxSymbols is the Finnhub free tier limit on concurrent symbol subscriptions
+	DefaultMaxSymbols = 50
+)
+
+var (
+	// ErrTooManySymbols is returned when subscribing to more symbols than the configured limit
+	ErrTooManySymbols = errors.New("too many symbols")
+	// ErrReconnectAborted is returned by Stream when the reconnect handler
+	// stops the reconnect loop
+	ErrReconnectAborted = errors.New("reconnect aborted")
+)
+
+// Options holds the connection settings shared by the market streamers
+type Options struct {
+	// URL is the websocket endpoint, without the token query parameter
+	URL string
+	// EnableCompression negotiates permessage-deflate with the server
+	EnableCompression bool
+	// SubscribeRetries is how many times a streamer reconnects when a
+	// subscribe frame cannot be written before giving up
+	SubscribeRetries int
+	// SubscribeRetryDelay is the wait before each subscribe reconnect
+	SubscribeRetryDelay time.Duration
+	// MaxSymbols caps how many symbols a streamer may subscribe to. Zero
+	// disables the check.
+	MaxSymbols int
+	// SubscribeBatchSize is how many subscribe frames are sent back-to-back
+	// before waiting SubscribeBatchDelay. Zero sends every frame at once.
+	SubscribeBatchSize int
+	// SubscribeBatchDelay is the wait between subscribe batches
+	SubscribeBatchDelay time.Duration
+	// Clock performs the streamer's waits, replaced in tests
+	Clock Clock
+	// KeyProvider, if set, replaces the API key once it was refused
+	// KeyRotationThreshold times in a row
+	KeyProvider          KeyProvider
+	KeyRotationThreshold int
+	// OnKeyRotated is called with the refused and the new key after a switch
+	OnKeyRotated func(previous, next string)
+	// MarketHours are the trading hours of the streamed exchange, used for
+	// warnings when subscribing while it is closed
+	MarketHours MarketHours
+	// OnReconnectFailed is consulted after every failed reconnect with the
+	// number of consecutive failures and the last error. Returning false
+	// stops reconnecting. Without it the streamer retries forever.
+	OnReconnectFailed func(attempt int, err error) (retry bool)
+	// OnHandlerPanic is called with the trade and the recovered value when a
+	// trade handler panics. The stream continues either way.
+	OnHandlerPanic func(trade Trade, recovered interface{})
+	// SubscribeParams are extra fields sent in every subscribe frame, for
+	// providers supporting subscription parameters
+	SubscribeParams map[string]interface{}
+	// TradeFields are the JSON field names of trades in incoming frames
+	TradeFields TradeFields
+}
+
+// Clock abstracts waiting so tests can observe delays without sleeping
+type Clock interface {
+	Sleep(d time.Duration)
+}
+
+// realClock sleeps for real
+type realClock struct{}
+
+// Sleep implements Clock
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Option configures a streamer
+type Option func(*Options)
+
+// DefaultOptions returns the default streamer options
+func DefaultOptions() Options {
+	return Options{
+		URL:                  DefaultURL,
+		EnableCompression:    true,
+		SubscribeRetries:     3,
+		SubscribeRetryDelay:  time.Second,
+		MaxSymbols:           DefaultMaxSymbols,
+		Clock:                realClock{},
+		KeyRotationThreshold: DefaultKeyRotationThreshold,
+		MarketHours:          USStockHours(nil),
+		TradeFields:          DefaultTradeFields(),
+	}
+}
+
+// NewOptions applies the given options on top of the defaults
+func NewOptions(opts ...Option) Options {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithURL overrides the websocket endpoint
+func WithURL(url string) Option {
+	return func(o *Options) {
+		o.URL = url
+	}
+}
+
+// WithCompression toggles permessage-deflate negotiation
+func WithCompression(enabled bool) Option {
+	return func(o *Options) {
+		o.EnableCompression = enabled
+	}
+}
+
+// WithSubscribeRetry sets how many reconnects are attempted when subscribing
+// fails, and the delay before each one
+func WithSubscribeRetry(retries int, delay time.Duration) Option {
+	return func(o *Options) {
+		o.SubscribeRetries = retries
+		o.SubscribeRetryDelay = delay
+	}
+}
+
+// WithMaxSymbols sets the subscription limit, zero disables it
+func WithMaxSymbols(max int) Option {
+	return func(o *Options) {
+		o.MaxSymbols = max
+	}
+}
+
+// WithSubscribeBatching sends subscribe frames in batches of size with delay
+// between batches, on the initial subscribe and after every reconnect. This
+// keeps Finnhub from throttling large symbol sets. A size of zero disables
+// batching.
+func WithSubscribeBatching(size int, delay time.Duration) Option {
+	return func(o *Options) {
+		o.SubscribeBatchSize = size
+		o.SubscribeBatchDelay = delay
+	}
+}
+
+// WithClock replaces the clock used for the streamer's waits
+func WithClock(clock Clock) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+// WithKeyProvider makes the streamer ask provider for a new API key when the
+// current one is refused threshold times in a row while reconnecting. A
+// non-positive threshold keeps the default.
+func WithKeyProvider(provider KeyProvider, threshold int) Option {
+	return func(o *Options) {
+		o.KeyProvider = provider
+		if threshold > 0 {
+			o.KeyRotationThreshold = threshold
+		}
+	}
+}
+
+// WithKeyRotatedHandler registers a callback for API key switches
+func WithKeyRotatedHandler(handler func(previous, next string)) Option {
+	return func(o *Options) {
+		o.OnKeyRotated = handler
+	}
+}
+
+// WithMarketHours sets the trading hours of the streamed exchange, e.g. in
+// another timezone
+func WithMarketHours(hours MarketHours) Option {
+	return func(o *Options) {
+		o.MarketHours = hours
+	}
+}
+
+// WithReconnectHandler registers a callback deciding whether to keep
+// reconnecting after a reconnect failed, e.g. to give up and alert after a
+// number of attempts
+func WithReconnectHandler(handler func(attempt int, err error) (retry bool)) Option {
+	return func(o *Options) {
+		o.OnReconnectFailed = handler
+	}
+}
+
+// WithHandlerPanicHandler registers a callback for trade handlers that
+// panicked, e.g. to count or alert on a buggy handler
+func WithHandlerPanicHandler(handler func(trade Trade, recovered interface{})) Option {
+	return func(o *Options) {
+		o.OnHandlerPanic = handler
+	}
+}
+
+// WithSubscribeParams adds fields to every subscribe frame besides type and
+// symbol, for providers supporting subscription parameters
+func WithSubscribeParams(params map[string]interface{}) Option {
+	return func(o *Options) {
+		o.SubscribeParams = params
+	}
+}
+
+// WithTradeFields maps trade fields to other JSON names, for providers or
+// Finnhub message variants naming them differently. Empty price, symbol or
+// timestamp names keep Finnhub's.
+func WithTradeFields(fields TradeFields) Option {
+	return func(o *Options) {
+		o.TradeFields = fields.withDefaults()
+	}
+}
+
+// SubscribeMessage returns the subscribe frame for symbol, including
+// SubscribeParams
+func (o Options) SubscribeMessage(symbol string) ([]byte, error) {
+	frame := NewSubscribeFrame(symbol)
+	for name, value := range o.SubscribeParams {
+		frame = frame.With(name, value)
+	}
+	return frame.Marshal()
+}
+
+// RetryReconnect reports whether a streamer should try again after attempt
+// consecutive failed reconnects, the last failing with err. It returns the
+// error Stream ends with when the reconnect handler gives up.
+func (o Options) RetryReconnect(attempt int, err error) error {
+	if o.OnReconnectFailed == nil || o.OnReconnectFailed(attempt, err) {
+		return nil
+	}
+	return fmt.Errorf("%w after %d failed attempts: %w", ErrReconnectAborted, attempt, err)
+}
+
+// SubscribeBatched calls subscribe for every symbol in order, waiting
+// SubscribeBatchDelay after each full batch except the last. It stops at the
+// first error.
+func (o Options) SubscribeBatched(symbols []string, subscribe func(symbol string) error) error {
+	for i, symbol := range symbols {
+		if o.SubscribeBatchSize > 0 && i > 0 && i%o.SubscribeBatchSize == 0 {
+			o.Clock.Sleep(o.SubscribeBatchDelay)
+		}
+		if err := subscribe(symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSymbolLimit returns ErrTooManySymbols if symbols exceeds MaxSymbols
+func (o Options) CheckSymbolLimit(symbols []string) error {
+	if o.MaxSymbols > 0 && len(symbols) > o.MaxSymbols {
+		return fmt.Errorf("%w: %d symbols exceeds the limit of %d", ErrTooManySymbols, len(symbols), o.MaxSymbols)
+	}
+	return nil
+}
+
+// Dialer returns a websocket dialer configured from the options
+func (o Options) Dialer() *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.EnableCompression = o.EnableCompression
+	return &d
+}
+
+// Endpoint returns the websocket URL authenticated with the API key
+func (o Options) Endpoint(apiKey string) string {
+	return fmt.Sprintf("%s?token=%s", o.URL, apiKey)
+}
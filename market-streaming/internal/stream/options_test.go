@@ -0,0 +1,54 @@
+package stream
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingClock logs waits into the same event list as the subscribes
+type recordingClock struct {
+	events *[]string
+}
+
+func (c recordingClock) Sleep(d time.Duration) {
+	*c.events = append(*c.events, "wait "+d.String())
+}
+
+func TestSubscribeBatched(t *testing.T) {
+	symbols := []string{"A", "B", "C", "D", "E"}
+
+	tests := []struct {
+		name      string
+		batchSize int
+		failOn    string
+		expected  string
+	}{
+		{name: "batching disabled", batchSize: 0, expected: "A,B,C,D,E"},
+		{name: "uneven batches", batchSize: 2, expected: "A,B,wait 1s,C,D,wait 1s,E"},
+		{name: "single batch", batchSize: 5, expected: "A,B,C,D,E"},
+		{name: "stops at the first error", batchSize: 2, failOn: "C", expected: "A,B,wait 1s,C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			opts := NewOptions(WithSubscribeBatching(tt.batchSize, time.Second), WithClock(recordingClock{events: &events}))
+
+			err := opts.SubscribeBatched(symbols, func(symbol string) error {
+				events = append(events, symbol)
+				if symbol == tt.failOn {
+					return errors.New("write failed")
+				}
+				return nil
+			})
+			if (err != nil) != (tt.failOn != "") {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if got := strings.Join(events, ","); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,9 +1,10 @@
 package stock
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"sync"
 	"time"
 	"trade-sonic/market-streaming/internal/stream"
 
@@ -13,27 +14,58 @@ import (
 // Streamer handles stock market data streaming
 type Streamer struct {
 	conn     *websocket.Conn
+	connMu   sync.Mutex // Guards conn, which Close reads from other goroutines
 	apiKey   string
 	symbols  []string
 	handlers []stream.TradeHandler
+	opts     stream.Options
+	dialer   *websocket.Dialer
+	prices   *stream.LastPrices
+	subs     *stream.Subscriptions
+	decoder  *stream.TradeDecoder
+
+	// authFailures counts consecutive refusals of apiKey
+	authFailures int
 }
 
 // NewStreamer creates a new stock market data streamer
-func NewStreamer(apiKey string, symbols []string) (*Streamer, error) {
+func NewStreamer(apiKey string, symbols []string, opts ...stream.Option) (*Streamer, error) {
+	options := stream.NewOptions(opts...)
+	s := &Streamer{
+		apiKey:   apiKey,
+		symbols:  symbols,
+		handlers: make([]stream.TradeHandler, 0),
+		opts:     options,
+		dialer:   options.Dialer(),
+		prices:   stream.NewLastPrices(),
+		subs:     stream.NewSubscriptions(symbols),
+		decoder:  stream.NewTradeDecoder(options.TradeFields),
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// connect establishes a new websocket connection
+func (s *Streamer) connect() error {
 	log.Printf("Connecting to Finnhub stock websocket...")
-	url := fmt.Sprintf("wss://ws.finnhub.io?token=%s", apiKey)
-	c, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	c, resp, err := s.dialer.Dial(s.opts.Endpoint(s.apiKey), nil)
 	if err != nil {
-		return nil, fmt.Errorf("error connecting to websocket: %w, response: %+v", err, resp)
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return fmt.Errorf("error connecting to websocket: %w: status %d", stream.ErrAuthRejected, resp.StatusCode)
+		}
+		return fmt.Errorf("error connecting to websocket: %w, response: %+v", err, resp)
 	}
+	s.connMu.Lock()
+	s.conn = c
+	s.connMu.Unlock()
+	// A new connection starts without subscriptions
+	s.subs.SetAll(stream.StateRequested)
 	log.Printf("Successfully connected to Finnhub stock websocket")
-
-	return &Streamer{
-		conn:     c,
-		apiKey:   apiKey,
-		symbols:  symbols,
-		handlers: make([]stream.TradeHandler, 0),
-	}, nil
+	return nil
 }
 
 // AddHandler adds a new trade handler
@@ -41,52 +73,93 @@ func (s *Streamer) AddHandler(handler stream.TradeHandler) {
 	s.handlers = append(s.handlers, handler)
 }
 
-// IsTrading checks if the stock market is currently trading
+// IsTrading checks if the US stock market is currently trading, 9:30 AM -
+// 4:00 PM Eastern Time on weekdays
 func IsTrading() bool {
-	now := time.Now()
-	
-	// Check if it's weekend
-	if now.Weekday() == time.Saturday || now.Weekday() == time.Sunday {
-		return false
-	}
-	
-	// Convert current time to Eastern Time
-	et, err := time.LoadLocation("America/New_York")
-	if err != nil {
-		log.Printf("Error loading timezone: %v", err)
-		return false
+	return stream.USStockHours(nil).IsOpen(time.Now())
+}
+
+// IsTrading checks if the streamed market is currently trading, per the
+// configured market hours
+func (s *Streamer) IsTrading() bool {
+	return s.opts.MarketHours.IsOpen(time.Now())
+}
+
+// formatTimeOfDay formats a time of day like 9:30 AM
+func formatTimeOfDay(d time.Duration) string {
+	return time.Time{}.Add(d).Format("3:04 PM")
+}
+
+// marketTimezone names the timezone of market hours
+func marketTimezone(hours stream.MarketHours) string {
+	if hours.Location == nil {
+		return stream.DefaultMarketTimezone
 	}
-	
-	etNow := now.In(et)
-	
-	// Trading hours are 9:30 AM - 4:00 PM ET
-	open := time.Date(etNow.Year(), etNow.Month(), etNow.Day(), 9, 30, 0, 0, et)
-	close := time.Date(etNow.Year(), etNow.Month(), etNow.Day(), 16, 0, 0, 0, et)
-	
-	return etNow.After(open) && etNow.Before(close)
+	return hours.Location.String()
 }
 
-// Subscribe subscribes to the specified stock symbols
+// Subscribe subscribes to the specified stock symbols. If a subscribe frame
+// cannot be written, the streamer reconnects and subscribes again, up to the
+// configured number of retries.
 func (s *Streamer) Subscribe() error {
-	if !IsTrading() {
+	// Finnhub silently drops subscriptions beyond the plan limit
+	if err := s.opts.CheckSymbolLimit(s.symbols); err != nil {
+		s.subs.SetAll(stream.StateFailed)
+		return err
+	}
+
+	if !s.IsTrading() {
+		hours := s.opts.MarketHours
 		log.Printf("Warning: Stock market is currently closed. Regular trading hours are:")
-		log.Printf("Monday-Friday, 9:30 AM - 4:00 PM Eastern Time")
+		log.Printf("Monday-Friday, %s - %s %s", formatTimeOfDay(hours.Open), formatTimeOfDay(hours.Close), marketTimezone(hours))
 		log.Printf("You may still connect to the stream but might not receive any data")
 		log.Printf("")
 	}
 
 	log.Printf("Subscribing to stock symbols: %v", s.symbols)
-	for _, symbol := range s.symbols {
-		msg := fmt.Sprintf(`{"type":"subscribe","symbol":"%s"}`, symbol)
-		if err := s.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+	for attempt := 0; ; attempt++ {
+		err := s.subscribeAll()
+		if err == nil {
+			return nil
+		}
+		if attempt >= s.opts.SubscribeRetries {
+			return err
+		}
+
+		log.Printf("%v. Reconnecting (attempt %d/%d)...", err, attempt+1, s.opts.SubscribeRetries)
+		s.conn.Close()
+		s.opts.Clock.Sleep(s.opts.SubscribeRetryDelay)
+
+		// A new connection starts without subscriptions, so every symbol is
+		// sent again rather than only the ones that failed
+		if err := s.connect(); err != nil {
+			log.Printf("Reconnection failed: %v", err)
+		}
+	}
+}
+
+// subscribeAll sends a subscribe frame for every symbol on the current
+// connection, in batches when configured
+func (s *Streamer) subscribeAll() error {
+	return s.opts.SubscribeBatched(s.symbols, func(symbol string) error {
+		msg, err := s.opts.SubscribeMessage(symbol)
+		if err != nil {
+			s.subs.Set(symbol, stream.StateFailed)
+			return err
+		}
+		if err := s.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			s.subs.Set(symbol, stream.StateFailed)
 			return fmt.Errorf("error subscribing to symbol %s: %w", symbol, err)
 		}
+		s.subs.Set(symbol, stream.StateSubscribed)
 		log.Printf("Subscribed to stock %s", symbol)
-	}
-	return nil
+		return nil
+	})
 }
 
-// Stream starts streaming stock market data
+// Stream starts streaming stock market data. It only returns once the
+// reconnect handler gives up, with an error wrapping
+// stream.ErrReconnectAborted.
 func (s *Streamer) Stream() error {
 	log.Printf("Starting to stream stock market data...")
 	backoff := time.Second
@@ -97,11 +170,12 @@ func (s *Streamer) Stream() error {
 		if err != nil {
 			log.Printf("Connection error: %v. Attempting to reconnect...", err)
 			s.conn.Close()
+			s.trackAuthFailure(err)
 
 			// Reconnection loop
-			for {
+			for attempt := 1; ; attempt++ {
 				log.Printf("Waiting %v before reconnecting...", backoff)
-				time.Sleep(backoff)
+				s.opts.Clock.Sleep(backoff)
 
 				// Exponential backoff
 				backoff *= 2
@@ -110,21 +184,22 @@ func (s *Streamer) Stream() error {
 				}
 
 				// Try to reconnect
-				url := fmt.Sprintf("wss://ws.finnhub.io?token=%s", s.apiKey)
-				newConn, _, err := websocket.DefaultDialer.Dial(url, nil)
-				if err != nil {
+				if err := s.connect(); err != nil {
 					log.Printf("Reconnection failed: %v", err)
+					s.trackAuthFailure(err)
+					if err := s.opts.RetryReconnect(attempt, err); err != nil {
+						return err
+					}
 					continue
 				}
 
-				// Reconnected successfully
-				s.conn = newConn
-				log.Printf("Successfully reconnected to Finnhub stock websocket")
-
 				// Resubscribe to symbols
 				if err := s.Subscribe(); err != nil {
 					log.Printf("Error resubscribing to symbols: %v", err)
 					s.conn.Close()
+					if err := s.opts.RetryReconnect(attempt, err); err != nil {
+						return err
+					}
 					continue
 				}
 
@@ -135,23 +210,70 @@ func (s *Streamer) Stream() error {
 			continue
 		}
 
-		var tradeData stream.TradeData
-		if err := json.Unmarshal(message, &tradeData); err != nil {
+		// Any message means the key was accepted
+		s.authFailures = 0
+
+		tradeData, err := s.decoder.Decode(message)
+		if err != nil {
 			log.Printf("Error parsing message: %v", err)
 			continue
 		}
 
 		if tradeData.Type == "trade" {
 			for _, trade := range tradeData.Data {
-				for _, handler := range s.handlers {
-					handler(trade)
-				}
+				s.prices.Update(trade)
+				s.subs.Set(trade.Symbol, stream.StateReceiving)
+				s.opts.Dispatch(s.handlers, trade)
 			}
 		}
 	}
 }
 
-// Close closes the websocket connection
+// trackAuthFailure counts consecutive refusals of the API key and, once
+// they reach the rotation threshold, switches to the next key from the key
+// provider. Other errors leave the count as is, so a network blip between
+// refusals does not hide a revoked key.
+func (s *Streamer) trackAuthFailure(err error) {
+	if !stream.IsAuthFailure(err) {
+		return
+	}
+	s.authFailures++
+	if s.opts.KeyProvider == nil || s.authFailures < s.opts.KeyRotationThreshold {
+		return
+	}
+
+	next, err := s.opts.KeyProvider.NextKey(s.apiKey)
+	if err != nil {
+		log.Printf("Stock API key %s refused %d times, no replacement available: %v", stream.MaskKey(s.apiKey), s.authFailures, err)
+		return
+	}
+	log.Printf("Stock API key %s refused %d times, switching to %s", stream.MaskKey(s.apiKey), s.authFailures, stream.MaskKey(next))
+	if s.opts.OnKeyRotated != nil {
+		s.opts.OnKeyRotated(s.apiKey, next)
+	}
+	s.apiKey = next
+	s.authFailures = 0
+}
+
+// LastPrice returns the last traded price of a symbol and the time of that
+// trade, without subscribing a handler. ok is false until a trade of the
+// symbol is received.
+func (s *Streamer) LastPrice(symbol string) (price float64, at time.Time, ok bool) {
+	return s.prices.Get(symbol)
+}
+
+// SubscriptionStatus returns the state of every requested symbol: requested,
+// subscribed, receiving or failed. States restart from requested on every
+// reconnect.
+func (s *Streamer) SubscriptionStatus() map[string]string {
+	return s.subs.Status()
+}
+
+// Close closes the websocket connection. It may be called while streaming,
+// e.g. to force a reconnect.
 func (s *Streamer) Close() error {
-	return s.conn.Close()
+	s.connMu.Lock()
+	conn := s.conn
+	s.connMu.Unlock()
+	return conn.Close()
 }
@@ -0,0 +1,386 @@
+package stock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"trade-sonic/market-streaming/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeFinnhub records the symbols subscribed on each accepted connection
+type fakeFinnhub struct {
+	mu          sync.Mutex
+	connections [][]string
+	received    chan struct{}
+}
+
+func newFakeFinnhub(t *testing.T) (*fakeFinnhub, *httptest.Server) {
+	f := &fakeFinnhub{received: make(chan struct{}, 16)}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		f.mu.Lock()
+		f.connections = append(f.connections, nil)
+		idx := len(f.connections) - 1
+		f.mu.Unlock()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var sub struct {
+				Symbol string `json:"symbol"`
+			}
+			if err := json.Unmarshal(message, &sub); err != nil {
+				t.Errorf("invalid subscribe frame: %s", message)
+				return
+			}
+			f.mu.Lock()
+			f.connections[idx] = append(f.connections[idx], sub.Symbol)
+			f.mu.Unlock()
+			f.received <- struct{}{}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeFinnhub) snapshot() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([][]string, len(f.connections))
+	copy(out, f.connections)
+	return out
+}
+
+func TestStreamer_SubscribeReconnectsAfterWriteFailure(t *testing.T) {
+	f, srv := newFakeFinnhub(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	symbols := []string{"AAPL", "MSFT"}
+
+	s, err := NewStreamer("test-key", symbols,
+		stream.WithURL(url), stream.WithSubscribeRetry(1, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	// Drop the connection so the first subscribe write fails
+	s.conn.Close()
+
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected subscribe to recover, got %v", err)
+	}
+
+	for range symbols {
+		select {
+		case <-f.received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for subscribe frames")
+		}
+	}
+
+	connections := f.snapshot()
+	if len(connections) != 2 {
+		t.Fatalf("Expected 2 connections, got %d", len(connections))
+	}
+	if strings.Join(connections[1], ",") != "AAPL,MSFT" {
+		t.Errorf("Expected all symbols on the new connection, got %v", connections[1])
+	}
+	for _, symbol := range symbols {
+		if state := s.SubscriptionStatus()[symbol]; state != "subscribed" {
+			t.Errorf("Expected %s to be subscribed on the new connection, got %q", symbol, state)
+		}
+	}
+}
+
+func TestStreamer_SubscribeGivesUpAfterRetries(t *testing.T) {
+	_, srv := newFakeFinnhub(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	s, err := NewStreamer("test-key", []string{"AAPL"},
+		stream.WithURL(url), stream.WithSubscribeRetry(0, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	s.conn.Close()
+
+	if err := s.Subscribe(); err == nil {
+		t.Fatal("Expected an error when no retries are allowed")
+	}
+	if state := s.SubscriptionStatus()["AAPL"]; state != "failed" {
+		t.Errorf("Expected AAPL to have failed, got %q", state)
+	}
+}
+
+func TestStreamer_SubscribeSymbolLimit(t *testing.T) {
+	f, srv := newFakeFinnhub(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	s, err := NewStreamer("test-key", []string{"AAPL", "MSFT", "GOOGL"},
+		stream.WithURL(url), stream.WithMaxSymbols(2))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Subscribe(); !errors.Is(err, stream.ErrTooManySymbols) {
+		t.Fatalf("Expected ErrTooManySymbols, got %v", err)
+	}
+	for symbol, state := range s.SubscriptionStatus() {
+		if state != "failed" {
+			t.Errorf("Expected %s to have failed, got %q", symbol, state)
+		}
+	}
+
+	// Nothing is sent once the guard trips
+	select {
+	case <-f.received:
+		t.Error("Expected no subscribe frames to be sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeClock records waits instead of sleeping
+type fakeClock struct {
+	mu     sync.Mutex
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+}
+
+func TestStreamer_SubscribeInBatches(t *testing.T) {
+	f, srv := newFakeFinnhub(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	symbols := []string{"AAPL", "MSFT", "GOOGL", "AMZN", "NVDA"}
+	clock := &fakeClock{}
+
+	s, err := NewStreamer("test-key", symbols,
+		stream.WithURL(url), stream.WithSubscribeBatching(2, time.Second), stream.WithClock(clock))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for range symbols {
+		select {
+		case <-f.received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for subscribe frames")
+		}
+	}
+
+	connections := f.snapshot()
+	if len(connections) != 1 || strings.Join(connections[0], ",") != strings.Join(symbols, ",") {
+		t.Errorf("Expected every symbol in order on one connection, got %v", connections)
+	}
+
+	// Three batches, so two waits between them
+	if len(clock.sleeps) != 2 || clock.sleeps[0] != time.Second || clock.sleeps[1] != time.Second {
+		t.Errorf("Expected 2 waits of 1s, got %v", clock.sleeps)
+	}
+}
+
+func TestStreamer_SwitchesKeyAfterAuthFailures(t *testing.T) {
+	var mu sync.Mutex
+	var tokens []string
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		mu.Lock()
+		tokens = append(tokens, token)
+		mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// Wait for the subscription, then refuse a revoked key the way
+		// Finnhub closes the session
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if token != "fresh-key" {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invalid token"))
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"trade","data":[{"s":"AAPL","p":190.5,"v":10,"t":1700000000000}]}`))
+		conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	type rotation struct{ previous, next string }
+	rotations := make(chan rotation, 4)
+	s, err := NewStreamer("dead-key", []string{"AAPL"},
+		stream.WithURL(url),
+		stream.WithClock(&fakeClock{}),
+		stream.WithKeyProvider(stream.NewKeyList("dead-key", "fresh-key"), 2),
+		stream.WithKeyRotatedHandler(func(previous, next string) {
+			rotations <- rotation{previous, next}
+		}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	trades := make(chan stream.Trade, 4)
+	s.AddHandler(func(trade stream.Trade) { trades <- trade })
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go s.Stream()
+
+	select {
+	case trade := <-trades:
+		if trade.Symbol != "AAPL" || trade.Price != 190.5 {
+			t.Errorf("Expected the AAPL trade, got %+v", trade)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a trade on the fresh key")
+	}
+
+	select {
+	case r := <-rotations:
+		if r.previous != "dead-key" || r.next != "fresh-key" {
+			t.Errorf("Expected a switch from dead-key to fresh-key, got %+v", r)
+		}
+	default:
+		t.Error("Expected the key switch to be reported")
+	}
+	if len(rotations) != 0 {
+		t.Errorf("Expected a single key switch, got %d more", len(rotations))
+	}
+
+	// The revoked key is retried until the threshold is reached
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(tokens, ",") != "dead-key,dead-key,fresh-key" {
+		t.Errorf("Expected two attempts with the revoked key, got %v", tokens)
+	}
+}
+
+func TestStreamer_ReconnectHandlerAborts(t *testing.T) {
+	var connections atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the first connection is accepted, and dropped after the subscribe
+		if connections.Add(1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var attempts []int
+	s, err := NewStreamer("test-key", []string{"AAPL"},
+		stream.WithURL(url),
+		stream.WithClock(&fakeClock{}),
+		stream.WithReconnectHandler(func(attempt int, err error) bool {
+			attempts = append(attempts, attempt)
+			return attempt < 2
+		}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Stream() }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, stream.ErrReconnectAborted) {
+			t.Errorf("Expected ErrReconnectAborted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Stream to give up")
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("Expected the handler to be consulted for attempts 1 and 2, got %v", attempts)
+	}
+	// The initial connection and two failed reconnects
+	if n := connections.Load(); n != 3 {
+		t.Errorf("Expected 3 connection attempts, got %d", n)
+	}
+}
+
+func TestStreamer_IdleReconnectWhileStreaming(t *testing.T) {
+	f, srv := newFakeFinnhub(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var stopping atomic.Bool
+	s, err := NewStreamer("test-key", []string{"AAPL"},
+		stream.WithURL(url),
+		stream.WithClock(&fakeClock{}),
+		stream.WithReconnectHandler(func(int, error) bool { return !stopping.Load() }))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The fake never sends trades: the wrapper keeps closing the connection
+	// from its own goroutine while Stream replaces it
+	w := stream.NewIdleReconnectWrapper(s, 20*time.Millisecond, stream.WithIdleCheckInterval(5*time.Millisecond))
+	if err := w.Subscribe(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Stream() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.snapshot()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := len(f.snapshot()); n < 3 {
+		t.Fatalf("Expected idle reconnects, got %d connections", n)
+	}
+
+	stopping.Store(true)
+	srv.Close()
+	w.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, stream.ErrReconnectAborted) {
+			t.Errorf("Expected ErrReconnectAborted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Stream to stop")
+	}
+}
@@ -0,0 +1,48 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SubscribeFrame builds the subscribe message sent for a symbol. Besides
+// type and symbol it may carry extra subscription parameters supported by
+// the provider. It is marshaled with encoding/json, so symbols are escaped
+// whatever they contain.
+type SubscribeFrame struct {
+	symbol string
+	params map[string]interface{}
+}
+
+// NewSubscribeFrame starts a subscribe frame for symbol
+func NewSubscribeFrame(symbol string) SubscribeFrame {
+	return SubscribeFrame{symbol: symbol}
+}
+
+// With returns a copy of the frame with the parameter name set to value.
+// type and symbol cannot be overridden, setting them is ignored.
+func (f SubscribeFrame) With(name string, value interface{}) SubscribeFrame {
+	params := make(map[string]interface{}, len(f.params)+1)
+	for k, v := range f.params {
+		params[k] = v
+	}
+	params[name] = value
+	f.params = params
+	return f
+}
+
+// Marshal returns the frame as JSON, e.g. {"symbol":"AAPL","type":"subscribe"}
+func (f SubscribeFrame) Marshal() ([]byte, error) {
+	fields := make(map[string]interface{}, len(f.params)+2)
+	for k, v := range f.params {
+		fields[k] = v
+	}
+	fields["type"] = "subscribe"
+	fields["symbol"] = f.symbol
+
+	msg, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("error building subscribe frame for %s: %w", f.symbol, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSubscribeFrame_EscapesSymbols(t *testing.T) {
+	tests := []struct {
+		name     string
+		symbol   string
+		expected string
+	}{
+		{name: "plain", symbol: "BINANCE:BTCUSDT", expected: `{"symbol":"BINANCE:BTCUSDT","type":"subscribe"}`},
+		{name: "quote", symbol: `BRK"B`, expected: `{"symbol":"BRK\"B","type":"subscribe"}`},
+		{name: "backslash", symbol: `FX\EUR`, expected: `{"symbol":"FX\\EUR","type":"subscribe"}`},
+		{name: "injection", symbol: `AAPL","type":"unsubscribe`, expected: `{"symbol":"AAPL\",\"type\":\"unsubscribe","type":"subscribe"}`},
+		{name: "control character", symbol: "AAPL\n", expected: `{"symbol":"AAPL\n","type":"subscribe"}`},
+		{name: "unicode", symbol: "東証:7203", expected: `{"symbol":"東証:7203","type":"subscribe"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewSubscribeFrame(tt.symbol).Marshal()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if string(msg) != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, msg)
+			}
+
+			// The symbol survives the round trip unchanged
+			var frame map[string]string
+			if err := json.Unmarshal(msg, &frame); err != nil {
+				t.Fatalf("Expected valid JSON, got %v", err)
+			}
+			if frame["symbol"] != tt.symbol || frame["type"] != "subscribe" {
+				t.Errorf("Expected a subscribe frame for %q, got %v", tt.symbol, frame)
+			}
+		})
+	}
+}
+
+func TestSubscribeFrame_OptionalFields(t *testing.T) {
+	base := NewSubscribeFrame("AAPL")
+	frame := base.With("depth", 10).With("type", "unsubscribe").With("symbol", "MSFT")
+
+	msg, err := frame.Marshal()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"depth":10,"symbol":"AAPL","type":"subscribe"}`
+	if string(msg) != expected {
+		t.Errorf("Expected %s, got %s", expected, msg)
+	}
+
+	// With copies, so the base frame is unchanged
+	msg, err = base.Marshal()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := `{"symbol":"AAPL","type":"subscribe"}`; string(msg) != expected {
+		t.Errorf("Expected %s, got %s", expected, msg)
+	}
+
+	if _, err := base.With("bad", make(chan int)).Marshal(); err == nil {
+		t.Error("Expected an error for a field that cannot be marshaled")
+	}
+}
+
+func TestOptions_SubscribeMessage(t *testing.T) {
+	opts := NewOptions(WithSubscribeParams(map[string]interface{}{"exchange": "US"}))
+
+	msg, err := opts.SubscribeMessage(`BRK"B`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"exchange":"US","symbol":"BRK\"B","type":"subscribe"}`
+	if string(msg) != expected {
+		t.Errorf("Expected %s, got %s", expected, msg)
+	}
+}
@@ -0,0 +1,65 @@
+package stream
+
+import "sync"
+
+// SubscriptionState is how far a requested symbol got towards streaming
+type SubscriptionState string
+
+const (
+	// StateRequested symbols are configured but not subscribed on the
+	// current connection
+	StateRequested SubscriptionState = "requested"
+	// StateSubscribed symbols had their subscribe frame sent, but no trade
+	// has arrived since
+	StateSubscribed SubscriptionState = "subscribed"
+	// StateReceiving symbols had a trade delivered on the current connection
+	StateReceiving SubscriptionState = "receiving"
+	// StateFailed symbols could not be subscribed
+	StateFailed SubscriptionState = "failed"
+)
+
+// Subscriptions tracks the subscription state of each requested symbol. It is
+// safe for concurrent use, so readers can query it while a streamer updates it.
+type Subscriptions struct {
+	mu     sync.RWMutex
+	states map[string]SubscriptionState
+}
+
+// NewSubscriptions creates a tracker with every symbol requested
+func NewSubscriptions(symbols []string) *Subscriptions {
+	s := &Subscriptions{states: make(map[string]SubscriptionState, len(symbols))}
+	for _, symbol := range symbols {
+		s.states[symbol] = StateRequested
+	}
+	return s
+}
+
+// Set records the state of a requested symbol. Symbols that were not
+// requested are ignored.
+func (s *Subscriptions) Set(symbol string, state SubscriptionState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, requested := s.states[symbol]; requested {
+		s.states[symbol] = state
+	}
+}
+
+// SetAll records the same state for every requested symbol
+func (s *Subscriptions) SetAll(state SubscriptionState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for symbol := range s.states {
+		s.states[symbol] = state
+	}
+}
+
+// Status returns the state of every requested symbol
+func (s *Subscriptions) Status() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := make(map[string]string, len(s.states))
+	for symbol, state := range s.states {
+		status[symbol] = string(state)
+	}
+	return status
+}
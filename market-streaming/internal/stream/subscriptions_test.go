@@ -0,0 +1,33 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubscriptions(t *testing.T) {
+	s := NewSubscriptions([]string{"AAPL", "MSFT"})
+
+	expected := map[string]string{"AAPL": "requested", "MSFT": "requested"}
+	if status := s.Status(); !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %v, got %v", expected, status)
+	}
+
+	s.Set("AAPL", StateSubscribed)
+	s.Set("MSFT", StateFailed)
+	s.Set("AAPL", StateReceiving)
+	// Symbols that were not requested are not tracked
+	s.Set("TSLA", StateReceiving)
+
+	expected = map[string]string{"AAPL": "receiving", "MSFT": "failed"}
+	if status := s.Status(); !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %v, got %v", expected, status)
+	}
+
+	// A reconnect starts every symbol over
+	s.SetAll(StateRequested)
+	expected = map[string]string{"AAPL": "requested", "MSFT": "requested"}
+	if status := s.Status(); !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %v, got %v", expected, status)
+	}
+}
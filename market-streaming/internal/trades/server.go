@@ -0,0 +1,164 @@
+package trades
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"trade-sonic/market-streaming/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// subscriberBuffer is how many trades may queue for a slow subscriber
+	// before further trades are dropped
+	subscriberBuffer = 256
+	// writeTimeout bounds how long a single trade frame may take to send
+	writeTimeout = 10 * time.Second
+)
+
+// subscriber is a websocket client receiving the trades of a set of symbols
+type subscriber struct {
+	symbols map[string]bool
+	trades  chan stream.Trade
+}
+
+// Server relays raw trades to websocket subscribers, e.g. services keeping
+// live prices of the symbols they hold
+type Server struct {
+	mu          sync.Mutex
+	upgrader    websocket.Upgrader
+	subscribers map[*subscriber]struct{}
+}
+
+// NewServer creates a trade relay server
+func NewServer() *Server {
+	return &Server{
+		upgrader:    websocket.Upgrader{},
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// HandleTrade queues a trade for every subscriber of its symbol. It is a
+// stream.TradeHandler, so it can be added to the market streamers.
+func (s *Server) HandleTrade(trade stream.Trade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subscribers {
+		if !sub.symbols[trade.Symbol] {
+			continue
+		}
+		select {
+		case sub.trades <- trade:
+		default:
+			log.Printf("Trade subscriber for %s is too slow, dropping trade", trade.Symbol)
+		}
+	}
+}
+
+// subscribeMessage adds symbols to an open subscription
+type subscribeMessage struct {
+	Type    string   `json:"type"`
+	Symbols []string `json:"symbols"`
+}
+
+// ServeHTTP upgrades /trades?symbols=AAPL,MSFT requests to a websocket and
+// relays every trade of those symbols as a JSON frame. Clients subscribe
+// more symbols on the open connection with
+// {"type":"subscribe","symbols":["NVDA"]}.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	symbols := make(map[string]bool)
+	for _, symbol := range strings.Split(r.URL.Query().Get("symbols"), ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			symbols[symbol] = true
+		}
+	}
+	if len(symbols) == 0 {
+		http.Error(w, "symbols is required", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before upgrading so no trade after the handshake is missed
+	sub := s.subscribe(symbols)
+	defer s.unsubscribe(sub)
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading trade subscriber: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Read subscriptions until the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg subscribeMessage
+			if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "subscribe" {
+				log.Printf("Ignoring unexpected trade subscriber message: %s", data)
+				continue
+			}
+			s.addSymbols(sub, msg.Symbols)
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case trade := <-sub.trades:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(trade); err != nil {
+				log.Printf("Error sending trade to subscriber: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// subscribe registers a subscriber to the given symbols
+func (s *Server) subscribe(symbols map[string]bool) *subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := &subscriber{symbols: symbols, trades: make(chan stream.Trade, subscriberBuffer)}
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// addSymbols subscribes a subscriber to more symbols
+func (s *Server) addSymbols(sub *subscriber, symbols []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, symbol := range symbols {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			sub.symbols[symbol] = true
+		}
+	}
+}
+
+// unsubscribe removes a subscriber
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers, sub)
+}
+
+// SubscriberCount returns the number of connected subscribers
+func (s *Server) SubscriberCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.subscribers)
+}
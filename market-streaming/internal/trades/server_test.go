@@ -0,0 +1,138 @@
+package trades
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"trade-sonic/market-streaming/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial connects a websocket subscriber to the trade relay
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/trades?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitForSubscribers polls until the server has the expected number of subscribers
+func waitForSubscribers(t *testing.T, s *Server, expected int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s.SubscriberCount() == expected {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers, got %d", expected, s.SubscriberCount())
+}
+
+func TestServer_RelaysSubscribedTrades(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn := dial(t, srv, "symbols=AAPL,MSFT")
+	other := dial(t, srv, "symbols=GOOGL")
+	waitForSubscribers(t, s, 2)
+
+	trades := []stream.Trade{
+		{Symbol: "AAPL", Price: 182.5, Volume: 1, Timestamp: 1000},
+		{Symbol: "TSLA", Price: 250, Volume: 2, Timestamp: 1001},
+		{Symbol: "MSFT", Price: 410.25, Volume: 3, Timestamp: 1002},
+	}
+	for _, trade := range trades {
+		s.HandleTrade(trade)
+	}
+
+	for _, expected := range []stream.Trade{trades[0], trades[2]} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var trade stream.Trade
+		if err := conn.ReadJSON(&trade); err != nil {
+			t.Fatalf("Failed to read trade: %v", err)
+		}
+		if !reflect.DeepEqual(trade, expected) {
+			t.Errorf("Expected trade %+v, got %+v", expected, trade)
+		}
+	}
+
+	// No trade of GOOGL was relayed
+	other.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := other.ReadMessage(); err == nil {
+		t.Error("Expected no trade for the GOOGL subscriber")
+	}
+}
+
+func TestServer_RequiresSymbols(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/trades?symbols=,")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestServer_RemovesSubscriberOnDisconnect(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn := dial(t, srv, "symbols=AAPL")
+	waitForSubscribers(t, s, 1)
+
+	conn.Close()
+	waitForSubscribers(t, s, 0)
+}
+
+func TestServer_SubscribesOnOpenConnection(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn := dial(t, srv, "symbols=AAPL")
+	waitForSubscribers(t, s, 1)
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "symbols": []string{"NVDA"}}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	// The subscription is applied asynchronously, publish until it arrives
+	expected := stream.Trade{Symbol: "NVDA", Price: 900.5, Volume: 1, Timestamp: 1000}
+	received := make(chan stream.Trade, 1)
+	go func() {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var trade stream.Trade
+		if err := conn.ReadJSON(&trade); err == nil {
+			received <- trade
+		}
+		close(received)
+	}()
+	for {
+		s.HandleTrade(expected)
+		select {
+		case trade, ok := <-received:
+			if !ok {
+				t.Fatal("Expected a trade of the symbol subscribed on the open connection")
+			}
+			if !reflect.DeepEqual(trade, expected) {
+				t.Errorf("Expected trade %+v, got %+v", expected, trade)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
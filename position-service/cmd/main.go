@@ -1,46 +1,490 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
+	"github.com/trade-sonic/logging/ginlog"
 	"github.com/trade-sonic/position-service/internal/position"
+	"github.com/trade-sonic/position-service/internal/rediscache"
+	"github.com/trade-sonic/position-service/internal/snapshot"
 )
 
 func main() {
+	// Configure logging, e.g. LOG_LEVEL=debug for per-position details and
+	// LOG_FORMAT=json for structured logs
+	logger, logFormat, err := logging.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+
 	// Create a new Gin router
-	r := gin.Default()
+	r := ginlog.NewRouter(logger, logFormat)
+
+	// Get Robinhood account ID from environment variable or use a default
+	// for development, unless STRICT_ACCOUNT_ID=true
+	accountID, isDefault, err := accountIDFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid account configuration: %v", err)
+	}
+	if isDefault {
+		logger.Warn("Using default account ID. Set ROBINHOOD_ACCOUNT_ID environment variable for production, and STRICT_ACCOUNT_ID=true to require it.")
+	}
+
+	var positionService *position.Service
+	if os.Getenv("POSITION_SERVICE_MODE") == "mock" {
+		// Serve positions from a local fixture without Robinhood or the token
+		// service, optionally walking prices by up to MOCK_PRICE_WALK per fetch.
+		// Without MOCK_POSITIONS_FILE a built-in sample fixture is served.
+		fixture := os.Getenv("MOCK_POSITIONS_FILE")
+		var priceWalk float64
+		if v := os.Getenv("MOCK_PRICE_WALK"); v != "" {
+			var err error
+			if priceWalk, err = strconv.ParseFloat(v, 64); err != nil {
+				log.Fatalf("Invalid MOCK_PRICE_WALK %q, expected a fraction like 0.01", v)
+			}
+		}
+		var err error
+		positionService, err = position.NewMockService(fixture, priceWalk)
+		if err != nil {
+			log.Fatalf("Failed to start in mock mode: %v", err)
+		}
+		if fixture == "" {
+			fixture = "built-in"
+		}
+		logger.Warn("Running in mock mode", "fixture", fixture)
+	} else {
+		// Initialize the token client
+		// Assuming the token service is running on localhost:8080
+		tokenClient := position.NewTokenClient("http://localhost:8080")
+		if os.Getenv("USE_READ_ONLY_TOKEN") == "true" {
+			tokenClient.SetReadOnly(true)
+		}
+
+		// Bound each token request with TOKEN_TIMEOUT, e.g. 5s, and retry
+		// connection failures up to TOKEN_ATTEMPTS times in total
+		if v := os.Getenv("TOKEN_TIMEOUT"); v != "" {
+			timeout, err := time.ParseDuration(v)
+			if err != nil || timeout < 0 {
+				log.Fatalf("Invalid TOKEN_TIMEOUT %q, expected a duration like 5s", v)
+			}
+			tokenClient.SetTimeout(timeout)
+		}
+		if v := os.Getenv("TOKEN_ATTEMPTS"); v != "" {
+			attempts, err := strconv.Atoi(v)
+			if err != nil || attempts < 1 {
+				log.Fatalf("Invalid TOKEN_ATTEMPTS %q, expected a positive integer", v)
+			}
+			policy := position.DefaultTokenRetryPolicy()
+			policy.MaxAttempts = attempts
+			tokenClient.SetRetryPolicy(policy)
+		}
+		// Tokens are cached until TOKEN_EXPIRY_BUFFER, e.g. 1m, before they expire
+		if v := os.Getenv("TOKEN_EXPIRY_BUFFER"); v != "" {
+			buffer, err := time.ParseDuration(v)
+			if err != nil || buffer < 0 {
+				log.Fatalf("Invalid TOKEN_EXPIRY_BUFFER %q, expected a duration like 1m", v)
+			}
+			tokenClient.SetExpiryBuffer(buffer)
+		}
+
+		// Initialize the position service with the account ID
+		positionService = position.NewService(tokenClient, accountID)
+	}
+	positionService.SetLogger(logger)
+
+	// Register additional accounts as comma-separated label=account_number
+	// pairs. An account of another Robinhood login names the token service
+	// label of that login: label=account_number@token_label. Mock mode takes
+	// its accounts from the fixture.
+	if v := os.Getenv("ROBINHOOD_ACCOUNTS"); v != "" && !positionService.IsMock() {
+		for _, pair := range strings.Split(v, ",") {
+			label, number, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			number, tokenLabel, hasLogin := strings.Cut(number, "@")
+			if !ok || label == "" || number == "" || (hasLogin && tokenLabel == "") {
+				log.Fatalf("Invalid ROBINHOOD_ACCOUNTS entry %q, expected label=account_number or label=account_number@token_label", pair)
+			}
+			positionService.AddAccountWithLogin(label, number, tokenLabel)
+		}
+	}
+
+	// Point the service at another Robinhood API host, e.g. a local stub or
+	// a debugging proxy: ROBINHOOD_BASE_URL=http://localhost:9000/robinhood
+	if v := os.Getenv("ROBINHOOD_BASE_URL"); v != "" {
+		if err := positionService.SetRobinhoodBaseURL(v); err != nil {
+			log.Fatalf("Invalid ROBINHOOD_BASE_URL: %v", err)
+		}
+	}
+
+	// Optionally hide positions below a market value floor (disabled by default)
+	if v := os.Getenv("MIN_MARKET_VALUE"); v != "" {
+		minMarketValue, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid MIN_MARKET_VALUE %q: %v", v, err)
+		}
+		positionService.SetMinMarketValue(minMarketValue)
+	}
+
+	// Pace outbound Robinhood requests, e.g. ROBINHOOD_RATE_LIMIT=2 and ROBINHOOD_RATE_BURST=5
+	if v := os.Getenv("ROBINHOOD_RATE_LIMIT"); v != "" {
+		requestsPerSecond, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid ROBINHOOD_RATE_LIMIT %q: %v", v, err)
+		}
+		burst := position.DefaultRateBurst
+		if b := os.Getenv("ROBINHOOD_RATE_BURST"); b != "" {
+			if burst, err = strconv.Atoi(b); err != nil {
+				log.Fatalf("Invalid ROBINHOOD_RATE_BURST %q: %v", b, err)
+			}
+		}
+		positionService.SetRateLimit(requestsPerSecond, burst)
+	}
+
+	// Option IDs are sent OPTION_BATCH_SIZE per request, with up to
+	// OPTION_BATCH_WORKERS requests in flight
+	optionBatchSize, optionBatchWorkers := 0, 0
+	if v := os.Getenv("OPTION_BATCH_SIZE"); v != "" {
+		var err error
+		if optionBatchSize, err = strconv.Atoi(v); err != nil || optionBatchSize < 1 {
+			log.Fatalf("Invalid OPTION_BATCH_SIZE %q, expected a positive integer", v)
+		}
+	}
+	if v := os.Getenv("OPTION_BATCH_WORKERS"); v != "" {
+		var err error
+		if optionBatchWorkers, err = strconv.Atoi(v); err != nil || optionBatchWorkers < 1 {
+			log.Fatalf("Invalid OPTION_BATCH_WORKERS %q, expected a positive integer", v)
+		}
+	}
+	positionService.SetOptionBatching(optionBatchSize, optionBatchWorkers)
+
+	// Bound each request's broker calls, e.g. REQUEST_TIMEOUT=10s (disabled by default)
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout < 0 {
+			log.Fatalf("Invalid REQUEST_TIMEOUT %q, expected a duration like 10s", v)
+		}
+		positionService.SetRequestTimeout(timeout)
+	}
+
+	// Order history backs realized P&L and is cached for ORDER_HISTORY_TTL, e.g. 1h
+	if v := os.Getenv("ORDER_HISTORY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid ORDER_HISTORY_TTL %q, expected a duration like 15m", v)
+		}
+		positionService.SetOrderHistoryTTL(ttl)
+	}
+
+	// GET /orders serves orders cached for ORDER_CACHE_TTL, e.g. 10s
+	if v := os.Getenv("ORDER_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid ORDER_CACHE_TTL %q, expected a duration like 30s", v)
+		}
+		positionService.SetOrderCacheTTL(ttl)
+	}
+
+	// GET /dividends serves dividends cached for DIVIDEND_CACHE_TTL, a day by default
+	if v := os.Getenv("DIVIDEND_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid DIVIDEND_CACHE_TTL %q, expected a duration like 24h", v)
+		}
+		positionService.SetDividendCacheTTL(ttl)
+	}
+
+	// Share cached positions between replicas in Redis with POSITION_CACHE=redis,
+	// expiring them after POSITION_CACHE_TTL. The default cache is in memory.
+	switch cacheType := os.Getenv("POSITION_CACHE"); cacheType {
+	case "", "memory":
+	case "redis":
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			log.Fatalf("REDIS_URL is required with POSITION_CACHE=redis")
+		}
+		var ttl time.Duration
+		if v := os.Getenv("POSITION_CACHE_TTL"); v != "" {
+			var err error
+			if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+				log.Fatalf("Invalid POSITION_CACHE_TTL %q, expected a positive duration like 5m", v)
+			}
+		}
+		cache, err := rediscache.Open(redisURL, ttl)
+		if err != nil {
+			log.Fatalf("Failed to configure the position cache: %v", err)
+		}
+		defer cache.Close()
+		// Requests fall back to the broker while Redis is down
+		if err := cache.Ping(context.Background()); err != nil {
+			logger.Warn("Redis unreachable, positions are fetched directly until it is back", "error", err)
+		}
+		positionService.SetPositionCache(cache)
+	default:
+		log.Fatalf("Invalid POSITION_CACHE %q, expected memory or redis", cacheType)
+	}
+
+	// Optionally record position snapshots in Postgres, keeping
+	// SNAPSHOT_RETENTION_DAYS days of history (all history when unset)
+	var historyHandler *snapshot.Handler
+	if databaseURL := os.Getenv("SNAPSHOT_DATABASE_URL"); databaseURL != "" {
+		var retention time.Duration
+		if v := os.Getenv("SNAPSHOT_RETENTION_DAYS"); v != "" {
+			days, err := strconv.Atoi(v)
+			if err != nil || days < 0 {
+				log.Fatalf("Invalid SNAPSHOT_RETENTION_DAYS %q, expected a non-negative number of days", v)
+			}
+			retention = time.Duration(days) * 24 * time.Hour
+		}
+		snapshots, err := snapshot.Open(databaseURL, retention)
+		if err != nil {
+			log.Fatalf("Failed to open snapshot store: %v", err)
+		}
+		defer snapshots.Close()
+		positionService.SetSnapshotStore(snapshots)
+		historyHandler = snapshot.NewHandler(snapshots)
+		historyHandler.SetLogger(logger)
+	}
+
+	// Deep health checks fail once the last position fetch is older than
+	// HEALTH_MAX_FETCH_AGE, e.g. 15m, and reuse token checks for HEALTH_TOKEN_CHECK_TTL
+	var tokenCheckTTL, maxFetchAge time.Duration
+	if v := os.Getenv("HEALTH_TOKEN_CHECK_TTL"); v != "" {
+		var err error
+		if tokenCheckTTL, err = time.ParseDuration(v); err != nil || tokenCheckTTL <= 0 {
+			log.Fatalf("Invalid HEALTH_TOKEN_CHECK_TTL %q, expected a positive duration like 30s", v)
+		}
+	}
+	if v := os.Getenv("HEALTH_MAX_FETCH_AGE"); v != "" {
+		var err error
+		if maxFetchAge, err = time.ParseDuration(v); err != nil || maxFetchAge <= 0 {
+			log.Fatalf("Invalid HEALTH_MAX_FETCH_AGE %q, expected a positive duration like 15m", v)
+		}
+	}
+	positionService.SetHealthThresholds(tokenCheckTTL, maxFetchAge)
 
-	// Get Robinhood account ID from environment variable or use a default for development
-	accountID := os.Getenv("ROBINHOOD_ACCOUNT_ID")
-	if accountID == "" {
-		accountID = "507617876"
-		log.Printf("Warning: Using default account ID. Set ROBINHOOD_ACCOUNT_ID environment variable for production.")
+	// Positions delta history, see GET /positions/delta
+	deltaHistory, deltaEpsilon := position.DefaultDeltaHistorySize, position.DefaultDeltaEpsilon
+	if v := os.Getenv("POSITION_DELTA_HISTORY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid POSITION_DELTA_HISTORY %q, expected a positive integer", v)
+		}
+		deltaHistory = n
+	}
+	if v := os.Getenv("POSITION_DELTA_EPSILON"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Fatalf("Invalid POSITION_DELTA_EPSILON %q, expected a non-negative number", v)
+		}
+		deltaEpsilon = f
 	}
+	positionService.SetDeltaHistory(deltaHistory, deltaEpsilon)
 
-	// Initialize the token client
-	// Assuming the token service is running on localhost:8080
-	tokenClient := position.NewTokenClient("http://localhost:8080")
+	// Circuit breaker around Robinhood requests
+	breakerThreshold, breakerCooldown := position.DefaultBreakerThreshold, position.DefaultBreakerCooldown
+	if v := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid CIRCUIT_BREAKER_THRESHOLD %q, expected a non-negative integer, 0 disables the breaker", v)
+		}
+		breakerThreshold = n
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid CIRCUIT_BREAKER_COOLDOWN %q, expected a positive duration like 30s", v)
+		}
+		breakerCooldown = d
+	}
+	positionService.SetCircuitBreaker(breakerThreshold, breakerCooldown)
 
-	// Initialize the position service with the account ID
-	positionService := position.NewService(tokenClient, accountID)
+	// Shed /positions requests while Robinhood fails or is slow: set
+	// LOAD_SHED_ERROR_RATE, e.g. 0.5, and/or LOAD_SHED_MAX_LATENCY, e.g. 5s,
+	// judged over LOAD_SHED_WINDOW once it holds LOAD_SHED_MIN_REQUESTS.
+	// LOAD_SHED_SERVE_STALE=true answers shed requests from the cache.
+	shedConfig := position.DefaultLoadShedConfig()
+	if v := os.Getenv("LOAD_SHED_ERROR_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Fatalf("Invalid LOAD_SHED_ERROR_RATE %q, expected a fraction between 0 and 1", v)
+		}
+		shedConfig.MaxErrorRate = rate
+	}
+	if v := os.Getenv("LOAD_SHED_MAX_LATENCY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid LOAD_SHED_MAX_LATENCY %q, expected a duration like 5s", v)
+		}
+		shedConfig.MaxLatency = d
+	}
+	if v := os.Getenv("LOAD_SHED_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid LOAD_SHED_WINDOW %q, expected a positive duration like 30s", v)
+		}
+		shedConfig.Window = d
+	}
+	if v := os.Getenv("LOAD_SHED_MIN_REQUESTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid LOAD_SHED_MIN_REQUESTS %q, expected a positive integer", v)
+		}
+		shedConfig.MinRequests = n
+	}
+	shedConfig.ServeStale = os.Getenv("LOAD_SHED_SERVE_STALE") == "true"
+	positionService.SetLoadShedding(shedConfig)
+
+	// Optionally keep the cache warm with a background refresher
+	if v := os.Getenv("POSITION_REFRESH_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid POSITION_REFRESH_INTERVAL %q, expected a positive duration like 1m", v)
+		}
+		positionService.StartRefresher(position.Robinhood, interval)
+		defer positionService.Stop()
+	}
+
+	// Optionally revalue equities at live prices streamed from the market
+	// streaming trade relay, e.g. ws://localhost:8082/trades. Only mock
+	// positions hold equities: Robinhood positions are fetched as options,
+	// which keep their marks, so the feed is not started against Robinhood.
+	if v := os.Getenv("PRICE_FEED_URL"); v != "" && !positionService.IsMock() {
+		logger.Warn("Ignoring PRICE_FEED_URL, Robinhood positions are options only and keep their marks")
+	} else if v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+			log.Fatalf("Invalid PRICE_FEED_URL %q, expected a websocket URL like ws://localhost:8082/trades", v)
+		}
+		priceFeed := position.NewPriceFeed(v, logger)
+		positionService.SetPriceFeed(priceFeed)
+		feedCtx, stopFeed := context.WithCancel(context.Background())
+		defer stopFeed()
+		go priceFeed.Run(feedCtx)
+	}
+
+	// Require one of API_KEYS, comma-separated, on every endpoint but
+	// /health. Without them the endpoints are open to anyone reaching the port.
+	var apiKeys []string
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	if len(apiKeys) > 0 {
+		r.Use(position.NewAPIKeyAuth(apiKeys))
+	} else {
+		logger.Warn("API_KEYS not set, position endpoints are served without authentication")
+	}
 
 	// Initialize the position handler
 	handler := position.NewHandler(positionService)
 
 	// Register routes
-	r.POST("/positions", handler.GetPositions)
+	shedder := position.NewLoadShedder(positionService)
+	r.GET("/positions", shedder, handler.ListPositions)
+	r.GET("/positions/spreads", shedder, handler.ListSpreads)
+	r.GET("/positions/delta", shedder, handler.PositionsDelta)
+	r.GET("/positions/:symbol", shedder, handler.GetPosition)
+	r.POST("/positions", shedder, handler.GetPositions)
+	r.GET("/exposure", shedder, handler.Exposure)
+	// Long-lived, so not counted by the load shedder
+	r.GET("/positions/stream", handler.StreamPositions)
+	r.GET("/orders", handler.ListOrders)
+	r.GET("/pnl/realized", handler.RealizedPnL)
+	r.GET("/dividends", handler.Dividends)
+	r.GET("/watchlists", handler.Watchlists)
+	r.GET("/watchlists/:name", handler.GetWatchlist)
+	r.POST("/watchlists/:name/sync-to-engine", handler.SyncWatchlistToEngine)
 
-	// Add a health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status": "up",
-		})
-	})
+	// History is served from snapshots, so only when they are recorded
+	if historyHandler != nil {
+		r.GET("/positions/history", historyHandler.PositionHistory)
+		r.GET("/portfolio/history", historyHandler.PortfolioHistory)
+	}
 
-	// Start the server
-	if err := r.Run(":8081"); err != nil {
+	// Raw Robinhood responses for troubleshooting, only when asked for. The
+	// debug token takes Authorization, so with API_KEYS set the API key goes
+	// in X-API-Key.
+	debug := position.DebugConfig{
+		Enabled: os.Getenv("DEBUG_ENDPOINTS") == "true",
+		Token:   os.Getenv("DEBUG_TOKEN"),
+	}
+	if err := handler.RegisterDebugRoutes(r, debug); err != nil {
+		log.Fatalf("Invalid DEBUG_ENDPOINTS configuration: %v, set DEBUG_TOKEN", err)
+	}
+
+	// Health checks, /health?deep=true also checks the token service and
+	// position freshness
+	r.GET("/health", handler.Health)
+
+	// Listen on PORT, 8081 by default. On SIGINT or SIGTERM, new
+	// connections are refused and in-flight requests get SHUTDOWN_TIMEOUT,
+	// e.g. 15s, to complete before the refresher is stopped and the stores
+	// are closed.
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+	shutdownTimeout := defaultShutdownTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if shutdownTimeout, err = time.ParseDuration(v); err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT %q, expected a positive duration like 15s", v)
+		}
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	logger.Info("Listening", "addr", listener.Addr().String())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Handler: r}
+	// Position streams never complete, so they would hold the shutdown
+	// until SHUTDOWN_TIMEOUT
+	srv.RegisterOnShutdown(handler.CloseStreams)
+	if err := serve(ctx, srv, listener, shutdownTimeout, logger); err != nil {
+		logger.Error("Server stopped", "error", err)
+	}
+
+	// No request is running anymore, so nothing races the refresher's last
+	// round; snapshots are written synchronously, so closing the store by
+	// the deferred calls loses none
+	positionService.Stop()
+	logger.Info("Shutdown complete")
+}
+
+// defaultAccountID is the development account used when ROBINHOOD_ACCOUNT_ID
+// is unset outside strict mode
+const defaultAccountID = "507617876"
+
+// accountIDFromEnv returns ROBINHOOD_ACCOUNT_ID, or defaultAccountID and
+// true when it is unset. With STRICT_ACCOUNT_ID=true an unset account ID is
+// an error instead, so production never queries the development account.
+func accountIDFromEnv() (string, bool, error) {
+	strict := false
+	if v := os.Getenv("STRICT_ACCOUNT_ID"); v != "" {
+		var err error
+		if strict, err = strconv.ParseBool(v); err != nil {
+			return "", false, fmt.Errorf("invalid STRICT_ACCOUNT_ID %q, expected true or false", v)
+		}
+	}
+
+	if accountID := strings.TrimSpace(os.Getenv("ROBINHOOD_ACCOUNT_ID")); accountID != "" {
+		return accountID, false, nil
+	}
+	if strict {
+		return "", false, fmt.Errorf("ROBINHOOD_ACCOUNT_ID is required with STRICT_ACCOUNT_ID=true")
+	}
+	return defaultAccountID, true, nil
 }
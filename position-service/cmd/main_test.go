@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestAccountIDFromEnv(t *testing.T) {
+	tests := []struct {
+		name            string
+		accountID       string
+		strict          string
+		expected        string
+		expectedDefault bool
+		expectErr       bool
+	}{
+		{name: "account ID", accountID: "123456789", expected: "123456789"},
+		{name: "default", expected: defaultAccountID, expectedDefault: true},
+		{name: "strict off", strict: "false", expected: defaultAccountID, expectedDefault: true},
+		{name: "strict with account ID", accountID: "123456789", strict: "true", expected: "123456789"},
+		{name: "strict without account ID", strict: "true", expectErr: true},
+		{name: "strict with blank account ID", accountID: "  ", strict: "true", expectErr: true},
+		{name: "invalid strict", accountID: "123456789", strict: "yes please", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ROBINHOOD_ACCOUNT_ID", tt.accountID)
+			t.Setenv("STRICT_ACCOUNT_ID", tt.strict)
+
+			accountID, isDefault, err := accountIDFromEnv()
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got account ID %q", accountID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if accountID != tt.expected {
+				t.Errorf("Expected account ID %q, got %q", tt.expected, accountID)
+			}
+			if isDefault != tt.expectedDefault {
+				t.Errorf("Expected default %v, got %v", tt.expectedDefault, isDefault)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultShutdownTimeout bounds how long in-flight requests may take to
+// complete once shutdown starts
+const defaultShutdownTimeout = 15 * time.Second
+
+// serve serves HTTP on the listener until ctx is cancelled, then stops
+// accepting connections and waits up to shutdownTimeout for in-flight
+// requests to complete. It returns nil after a clean shutdown.
+func serve(ctx context.Context, srv *http.Server, listener net.Listener, shutdownTimeout time.Duration, logger *slog.Logger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down, waiting for in-flight requests", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Requests still running past the deadline are cut off
+		srv.Close()
+		return fmt.Errorf("shutdown incomplete: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server stopped: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowServer starts serve with a handler that signals started and then
+// takes delay to answer
+func slowServer(t *testing.T, delay, shutdownTimeout time.Duration) (url string, started <-chan struct{}, cancel context.CancelFunc, done <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startedCh := make(chan struct{}, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedCh <- struct{}{}
+		time.Sleep(delay)
+		w.Write([]byte("done"))
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- serve(ctx, srv, listener, shutdownTimeout, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+	t.Cleanup(cancel)
+	return "http://" + listener.Addr().String(), startedCh, cancel, doneCh
+}
+
+func TestServe_CompletesInFlightRequests(t *testing.T) {
+	url, started, cancel, done := slowServer(t, 200*time.Millisecond, 5*time.Second)
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-responses
+	if res.err != nil || res.body != "done" {
+		t.Errorf("Expected the in-flight request to complete, got %q, %v", res.body, res.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+
+	// New connections are refused once shut down
+	if _, err := http.Get(url); err == nil {
+		t.Error("Expected requests after shutdown to fail")
+	}
+}
+
+func TestServe_ShutdownDeadline(t *testing.T) {
+	url, started, cancel, done := slowServer(t, time.Second, 50*time.Millisecond)
+
+	go http.Get(url)
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error when requests outlast the shutdown timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+}
@@ -0,0 +1,51 @@
+package position
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// PrimaryAccountLabel is the label of the account passed to NewService
+	PrimaryAccountLabel = "primary"
+	// AllAccounts selects every configured account in a PositionQuery
+	AllAccounts = "all"
+)
+
+var (
+	// ErrUnsupportedAccountType is returned for account types the service does not handle
+	ErrUnsupportedAccountType = errors.New("unsupported account type")
+	// ErrUnknownAccount is returned when a query names an account that is not configured
+	ErrUnknownAccount = errors.New("unknown account")
+	// ErrRateLimited is returned when the broker API rejects a request for exceeding its rate limit
+	ErrRateLimited = errors.New("rate limited")
+	// ErrNotConfigured is returned when the service lacks the configuration a
+	// request needs, e.g. an account
+	ErrNotConfigured = errors.New("not configured")
+	// ErrUpstreamAuth is returned when no valid broker token can be obtained,
+	// from the token service or the broker
+	ErrUpstreamAuth = errors.New("upstream authentication failed")
+	// ErrUpstreamUnavailable is returned when the broker API cannot be reached
+	// or keeps failing after retries
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	// ErrCircuitOpen is returned for Robinhood requests not sent because the
+	// circuit breaker is open
+	ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", ErrUpstreamUnavailable)
+	// ErrRequestCanceled is returned when the caller cancels a request
+	// before it completes
+	ErrRequestCanceled = errors.New("request canceled")
+	// ErrRequestTimeout is returned when a request outlasts the request timeout
+	ErrRequestTimeout = errors.New("request timed out")
+	// ErrUnauthorized is returned when the broker API still rejects the token
+	// after it was refreshed
+	ErrUnauthorized = fmt.Errorf("%w: broker rejected the access token", ErrUpstreamAuth)
+)
+
+// Account is a brokerage account the service can fetch positions for
+type Account struct {
+	Label string `json:"label"`
+	ID    string `json:"id"` // Broker account number
+	// TokenLabel is the label of the token service account the broker login
+	// of this account is configured under, empty for its default account
+	TokenLabel string `json:"token_label,omitempty"`
+}
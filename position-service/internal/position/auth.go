@@ -0,0 +1,58 @@
+package position
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries an API key as an alternative to a bearer token, e.g.
+// alongside the debug token in Authorization
+const APIKeyHeader = "X-API-Key"
+
+// NewAPIKeyAuth returns middleware requiring one of keys on every request
+// but /health, either as "Authorization: Bearer <key>" or in X-API-Key,
+// which takes precedence. Keys are compared in constant time. Requests
+// without a valid key are rejected with a 401, and so are all requests
+// when keys is empty.
+func NewAPIKeyAuth(keys []string) gin.HandlerFunc {
+	// Comparing digests keeps the comparison time independent of key lengths
+	digests := make([][sha256.Size]byte, 0, len(keys))
+	for _, key := range keys {
+		if key != "" {
+			digests = append(digests, sha256.Sum256([]byte(key)))
+		}
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+
+		presented := c.GetHeader(APIKeyHeader)
+		if presented == "" {
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+				presented = token
+			}
+		}
+
+		valid := 0
+		if presented != "" {
+			digest := sha256.Sum256([]byte(presented))
+			for _, expected := range digests {
+				valid |= subtle.ConstantTimeCompare(digest[:], expected[:])
+			}
+		}
+		if valid != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="position-service"`)
+			writeError(c, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid API key")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,79 @@
+package position
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// performAuthRequest runs a GET request with the given headers through a
+// router requiring the API keys
+func performAuthRequest(t *testing.T, keys []string, target string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	s, err := NewMockService(mockFixture, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	h := NewHandler(s)
+
+	r := gin.New()
+	r.Use(NewAPIKeyAuth(keys))
+	r.GET("/positions", h.ListPositions)
+	r.GET("/health", h.Health)
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	keys := []string{"engine-key", "dashboard-key"}
+	tests := []struct {
+		name           string
+		keys           []string
+		target         string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{name: "missing key", keys: keys, target: "/positions?account_type=robinhood", expectedStatus: http.StatusUnauthorized},
+		{name: "wrong bearer key", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{"Authorization": "Bearer wrong-key"}, expectedStatus: http.StatusUnauthorized},
+		{name: "wrong header key", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{APIKeyHeader: "wrong-key"}, expectedStatus: http.StatusUnauthorized},
+		{name: "key without bearer scheme", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{"Authorization": "engine-key"}, expectedStatus: http.StatusUnauthorized},
+		{name: "key prefix", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{"Authorization": "Bearer engine"}, expectedStatus: http.StatusUnauthorized},
+		{name: "valid bearer key", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{"Authorization": "Bearer engine-key"}, expectedStatus: http.StatusOK},
+		{name: "valid header key", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{APIKeyHeader: "dashboard-key"}, expectedStatus: http.StatusOK},
+		{name: "header key takes precedence", keys: keys, target: "/positions?account_type=robinhood", headers: map[string]string{APIKeyHeader: "engine-key", "Authorization": "Bearer debug-token"}, expectedStatus: http.StatusOK},
+		{name: "no keys configured", target: "/positions?account_type=robinhood", headers: map[string]string{"Authorization": "Bearer "}, expectedStatus: http.StatusUnauthorized},
+		{name: "health without key", keys: keys, target: "/health", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performAuthRequest(t, tt.keys, tt.target, tt.headers)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusUnauthorized {
+				return
+			}
+
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Expected a JSON error, got %v", err)
+			}
+			if response.Code != CodeUnauthorized || response.Message != "missing or invalid API key" {
+				t.Errorf("Expected the unauthorized error body, got %+v", response)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package position
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestSetRobinhoodBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		expected string
+		wantErr  bool
+	}{
+		{name: "host", baseURL: "http://localhost:9000", expected: "http://localhost:9000"},
+		{name: "trailing slash", baseURL: "https://proxy.local/robinhood/", expected: "https://proxy.local/robinhood"},
+		{name: "no scheme", baseURL: "localhost:9000", wantErr: true},
+		{name: "unsupported scheme", baseURL: "ftp://localhost", wantErr: true},
+		{name: "no host", baseURL: "http://", wantErr: true},
+		{name: "unparsable", baseURL: "http://[::1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			err := s.SetRobinhoodBaseURL(tt.baseURL)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q", tt.baseURL)
+				}
+				if s.baseURL != "https://api.robinhood.com" {
+					t.Errorf("Expected the default base URL to be kept, got %s", s.baseURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if s.baseURL != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, s.baseURL)
+			}
+		})
+	}
+}
+
+// TestRobinhoodBaseURL_Proxy runs the full position and order fetches
+// through a proxy mounted under a path prefix
+func TestRobinhoodBaseURL_Proxy(t *testing.T) {
+	fixtures := make(map[string]string)
+	for path, name := range robinhoodFixtures {
+		fixtures[path] = name
+	}
+	for path, name := range orderFixtures {
+		fixtures[path] = name
+	}
+	upstream := newFixtureServer(t, fixtures)
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	srv := httptest.NewServer(http.StripPrefix("/robinhood", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.Path)
+		mu.Unlock()
+		r.Header.Set("X-Forwarded-Prefix", "/robinhood")
+		proxy.ServeHTTP(w, r)
+	})))
+	t.Cleanup(srv.Close)
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	if err := s.SetRobinhoodBaseURL(srv.URL + "/robinhood/"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(positions.Positions) == 0 {
+		t.Fatal("Expected positions through the proxy")
+	}
+	for _, pos := range positions.Positions {
+		if pos.OccSymbol == "" || pos.CurrentPrice == 0 {
+			t.Errorf("Expected instrument details and prices for %+v", pos)
+		}
+	}
+
+	if _, err := s.ListOrders(context.Background(), OrderQuery{AccountType: Robinhood}); err != nil {
+		t.Fatalf("Expected no error fetching orders, got %v", err)
+	}
+
+	for _, path := range []string{"/options/positions/", "/options/instruments/", "/marketdata/options/", "/orders/", "/options/orders/"} {
+		found := false
+		for _, p := range proxied {
+			found = found || p == path
+		}
+		if !found {
+			t.Errorf("Expected %s to go through the proxy, got %v", path, proxied)
+		}
+	}
+}
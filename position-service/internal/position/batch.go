@@ -0,0 +1,122 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultOptionBatchSize is the number of option IDs sent per request.
+	// Robinhood rejects or truncates larger ids lists.
+	DefaultOptionBatchSize = 17
+	// DefaultOptionBatchWorkers bounds the batches fetched concurrently
+	DefaultOptionBatchWorkers = 4
+)
+
+// FailedBatch is a batch of option IDs that could not be fetched
+type FailedBatch struct {
+	Index     int // Position of the batch in the request, from 0
+	OptionIDs []string
+	Err       error
+}
+
+// BatchError reports the failed batches of a batched fetch. The results of
+// the other batches are still returned alongside it.
+type BatchError struct {
+	Batches int // Total number of batches
+	Failed  []FailedBatch
+}
+
+func (e *BatchError) Error() string {
+	failures := make([]string, 0, len(e.Failed))
+	for _, batch := range e.Failed {
+		failures = append(failures, fmt.Sprintf("batch %d (%d ids): %v", batch.Index, len(batch.OptionIDs), batch.Err))
+	}
+	return fmt.Sprintf("%d of %d batches failed: %s", len(e.Failed), e.Batches, strings.Join(failures, "; "))
+}
+
+// Unwrap returns the errors of the failed batches, so errors.Is sees through
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, batch := range e.Failed {
+		errs = append(errs, batch.Err)
+	}
+	return errs
+}
+
+// FailedOptionIDs returns the option IDs of every failed batch
+func (e *BatchError) FailedOptionIDs() []string {
+	var ids []string
+	for _, batch := range e.Failed {
+		ids = append(ids, batch.OptionIDs...)
+	}
+	return ids
+}
+
+// SetOptionBatching sets how many option IDs are sent per request and how
+// many batches are fetched concurrently. Non-positive values keep the
+// current setting.
+func (s *Service) SetOptionBatching(size, workers int) {
+	if size > 0 {
+		s.optionBatchSize = size
+	}
+	if workers > 0 {
+		s.optionBatchWorkers = workers
+	}
+}
+
+// chunkIDs splits ids into consecutive batches of at most size IDs
+func chunkIDs(ids []string, size int) [][]string {
+	var batches [][]string
+	for len(ids) > size {
+		batches = append(batches, ids[:size:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+// fetchOptionBatches calls fetch for each batch of option IDs, running up to
+// the configured number of batches concurrently. fetch must be safe for
+// concurrent use. Failed batches are reported as a *BatchError once every
+// batch is done.
+func (s *Service) fetchOptionBatches(ctx context.Context, optionIDs []string, fetch func(ctx context.Context, batch []string) error) error {
+	batches := chunkIDs(optionIDs, s.optionBatchSize)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []FailedBatch
+	)
+	workers := make(chan struct{}, s.optionBatchWorkers)
+	for i, batch := range batches {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(i int, batch []string) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			if err := fetch(ctx, batch); err != nil {
+				mu.Lock()
+				failed = append(failed, FailedBatch{Index: i, OptionIDs: batch, Err: err})
+				mu.Unlock()
+			}
+		}(i, batch)
+	}
+	wg.Wait()
+
+	if len(failed) == 0 {
+		return nil
+	}
+	// Report failures in request order, not completion order
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Index < failed[j].Index
+	})
+	return &BatchError{Batches: len(batches), Failed: failed}
+}
@@ -0,0 +1,189 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChunkIDs(t *testing.T) {
+	ids := func(n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = fmt.Sprintf("opt-%d", i)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		ids      []string
+		expected []int // Batch sizes
+	}{
+		{name: "empty", ids: nil, expected: nil},
+		{name: "single partial batch", ids: ids(5), expected: []int{5}},
+		{name: "exactly one batch", ids: ids(17), expected: []int{17}},
+		{name: "one over a batch", ids: ids(18), expected: []int{17, 1}},
+		{name: "several batches", ids: ids(60), expected: []int{17, 17, 17, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := chunkIDs(tt.ids, DefaultOptionBatchSize)
+			var sizes []int
+			var joined []string
+			for _, batch := range batches {
+				sizes = append(sizes, len(batch))
+				joined = append(joined, batch...)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected batch sizes %v, got %v", tt.expected, sizes)
+			}
+			// Batches keep every ID, in order
+			if strings.Join(joined, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("Expected IDs %v, got %v", tt.ids, joined)
+			}
+		})
+	}
+}
+
+// newBatchServer serves count long option positions opt-0 to opt-<count-1>,
+// priced at 2 each. Price requests including failID fail with a 400.
+func newBatchServer(t *testing.T, count int, failID string) (*httptest.Server, func() [][]string) {
+	var mu sync.Mutex
+	var batches [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type result map[string]string
+		var results []result
+		switch r.URL.Path {
+		case "/options/positions/":
+			for i := 0; i < count; i++ {
+				results = append(results, result{
+					"id":                     fmt.Sprintf("pos-%d", i),
+					"option_id":              fmt.Sprintf("opt-%d", i),
+					"chain_symbol":           "AAPL",
+					"type":                   "long",
+					"quantity":               "1.0000",
+					"average_price":          "100.0000",
+					"clearing_cost_basis":    "100.0000",
+					"trade_value_multiplier": "100.0000",
+				})
+			}
+		case "/marketdata/options/":
+			ids := strings.Split(r.URL.Query().Get("ids"), ",")
+			mu.Lock()
+			batches = append(batches, ids)
+			mu.Unlock()
+			for _, id := range ids {
+				if id == failID {
+					http.Error(w, `{"detail":"invalid ids"}`, http.StatusBadRequest)
+					return
+				}
+				results = append(results, result{"instrument_id": id, "mark_price": "2.0000"})
+			}
+		case "/options/instruments/":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		sorted := append([][]string(nil), batches...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i][0] < sorted[j][0]
+		})
+		return sorted
+	}
+}
+
+func TestGetPositions_BatchesOptionPrices(t *testing.T) {
+	srv, batches := newBatchServer(t, 8, "")
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetOptionBatching(3, 2)
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := [][]string{
+		{"opt-0", "opt-1", "opt-2"},
+		{"opt-3", "opt-4", "opt-5"},
+		{"opt-6", "opt-7"},
+	}
+	if fmt.Sprint(batches()) != fmt.Sprint(expected) {
+		t.Errorf("Expected batches %v, got %v", expected, batches())
+	}
+
+	if len(positions.Positions) != 8 {
+		t.Fatalf("Expected 8 positions, got %d", len(positions.Positions))
+	}
+	for _, p := range positions.Positions {
+		if p.CurrentPrice != 2 || p.PriceUnavailable || p.MarketValue != 200 {
+			t.Errorf("Expected %s priced at 2, got %+v", p.ID, p)
+		}
+	}
+}
+
+func TestGetPositions_PartialOptionPriceFailure(t *testing.T) {
+	srv, _ := newBatchServer(t, 8, "opt-4")
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetOptionBatching(3, 2)
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Only the batch of opt-3 to opt-5 is missing its prices
+	for i, p := range positions.Positions {
+		failed := i >= 3 && i <= 5
+		if p.PriceUnavailable != failed {
+			t.Errorf("%s: expected price unavailable %v, got %v", p.ID, failed, p.PriceUnavailable)
+		}
+		if failed && (p.MarketValue != 0 || p.UnrealizedPnL != 0) {
+			t.Errorf("%s: expected no market value or P&L without a price, got %+v", p.ID, p)
+		}
+		if !failed && p.UnrealizedPnL != 100 {
+			t.Errorf("%s: expected P&L 100, got %v", p.ID, p.UnrealizedPnL)
+		}
+	}
+}
+
+func TestFetchOptionPrices_ReportsFailedBatches(t *testing.T) {
+	srv, _ := newBatchServer(t, 0, "opt-4")
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetOptionBatching(2, 3)
+
+	ids := []string{"opt-0", "opt-1", "opt-2", "opt-3", "opt-4", "opt-5", "opt-6"}
+	prices, err := s.fetchOptionPrices(context.Background(), ids, "test-token", nil)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected a BatchError, got %v", err)
+	}
+	if batchErr.Batches != 4 || len(batchErr.Failed) != 1 || batchErr.Failed[0].Index != 2 {
+		t.Errorf("Expected batch 2 of 4 to fail, got %v", batchErr)
+	}
+	if got := strings.Join(batchErr.FailedOptionIDs(), ","); got != "opt-4,opt-5" {
+		t.Errorf("Expected failed IDs opt-4,opt-5, got %s", got)
+	}
+	if len(prices) != 5 {
+		t.Errorf("Expected the 5 prices of the other batches, got %v", prices)
+	}
+}
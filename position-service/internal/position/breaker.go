@@ -0,0 +1,195 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is how many consecutive failed Robinhood
+	// requests open the circuit breaker
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long the circuit breaker stays open
+	// before a probe request is let through
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerState is the state of the circuit breaker around Robinhood requests
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails every request without sending it
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single probe request through, whose outcome
+	// closes or reopens the breaker
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerStatus reports the circuit breaker for /health
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Opens               int64        `json:"opens"` // Times the breaker opened since the service started
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+}
+
+// circuitBreaker stops Robinhood requests after consecutive failures, so an
+// outage does not cost every caller the full retries and timeouts. Only
+// ErrUpstreamUnavailable counts as a failure: any other response shows the
+// API is reachable.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // Zero disables the breaker
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // A half-open probe is in flight
+	opens    int64
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// allow reports whether a request may be sent, and ErrCircuitOpen if not.
+// Once the cooldown has passed, the first request is let through as the
+// probe and the others keep failing until it completes.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record records the outcome of a request let through by allow. Requests
+// abandoned by their caller say nothing about Robinhood and only free the
+// probe slot.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == BreakerHalfOpen && b.probing
+	b.probing = false
+	switch {
+	case ctx.Err() != nil:
+		return
+	case err == nil || !errors.Is(err, ErrUpstreamUnavailable):
+		b.state = BreakerClosed
+		b.failures = 0
+	case wasProbe:
+		b.open()
+	default:
+		b.failures++
+		if b.threshold > 0 && b.failures >= b.threshold && b.state == BreakerClosed {
+			b.open()
+		}
+	}
+}
+
+// open opens the breaker, must be called with b.mu held
+func (b *circuitBreaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.opens++
+}
+
+// status returns the breaker's state for /health
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Opens:               b.opens,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// SetCircuitBreaker sets how many consecutive failed Robinhood requests open
+// the circuit breaker and how long it stays open before probing. A
+// non-positive threshold disables the breaker.
+func (s *Service) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+	if threshold < 0 {
+		threshold = 0
+	}
+	s.breaker.threshold = threshold
+	s.breaker.cooldown = cooldown
+	if threshold == 0 {
+		s.breaker.state = BreakerClosed
+		s.breaker.probing = false
+	}
+}
+
+// CircuitBreakerStatus returns the state of the circuit breaker around
+// Robinhood requests
+func (s *Service) CircuitBreakerStatus() BreakerStatus {
+	return s.breaker.status()
+}
+
+// checkCircuitBreaker fails the deep health check while the breaker is not
+// closed
+func (s *Service) checkCircuitBreaker() HealthCheck {
+	status := s.breaker.status()
+	check := HealthCheck{Status: HealthUp, CheckedAt: time.Now()}
+	if status.State != BreakerClosed {
+		check.Status = HealthDown
+		check.Message = fmt.Sprintf("circuit breaker %s since %s after %d consecutive failures",
+			status.State, status.OpenedAt.Format(time.RFC3339), status.ConsecutiveFailures)
+	}
+	return check
+}
+
+// getPositionsOrStale returns the positions of an account like getPositions.
+// While the circuit breaker is open, the cached positions are served instead,
+// flagged as stale.
+func (s *Service) getPositionsOrStale(ctx context.Context, accountType AccountType, account Account, refresh bool) (*PositionList, error) {
+	positions, err := s.getPositions(ctx, accountType, account, refresh)
+	if !errors.Is(err, ErrCircuitOpen) {
+		return positions, err
+	}
+
+	cached, exists, cacheErr := s.positionCache.Get(ctx, accountType, account.ID)
+	if cacheErr != nil || !exists {
+		return nil, err
+	}
+	s.logger.WarnContext(ctx, "Circuit breaker open, serving cached positions", "account", account.Label)
+	stale := *cached
+	stale.Stale = true
+	return &stale, nil
+}
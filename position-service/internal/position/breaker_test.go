@@ -0,0 +1,182 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyUpstream serves the Robinhood fixtures, or 503 for every request
+// while down is set. hits counts the requests that reached it.
+func newFlakyUpstream(t *testing.T, down *atomic.Bool) (*httptest.Server, *atomic.Int64) {
+	upstream := newFixtureServer(t, robinhoodFixtures)
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestCircuitBreaker_ServesStalePositions(t *testing.T) {
+	var down atomic.Bool
+	srv, hits := newFlakyUpstream(t, &down)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+	s.SetCircuitBreaker(2, time.Minute)
+	now := time.Now()
+	s.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := s.GetPositions(ctx, Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Failures below the threshold are returned as they are
+	down.Store(true)
+	for i := 0; i < 2; i++ {
+		_, err := s.RefreshPositions(ctx, Robinhood)
+		if !errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Expected an upstream failure, got %v", err)
+		}
+	}
+	if state := s.CircuitBreakerStatus().State; state != BreakerOpen {
+		t.Fatalf("Expected the breaker to open, got %s", state)
+	}
+
+	// While open, cached positions are served without calling Robinhood
+	before := hits.Load()
+	positions, err := s.RefreshPositions(ctx, Robinhood)
+	if err != nil {
+		t.Fatalf("Expected the cached positions, got %v", err)
+	}
+	if !positions.Stale || len(positions.Positions) != 2 {
+		t.Errorf("Expected 2 stale positions, got %+v", positions)
+	}
+	if hits.Load() != before {
+		t.Errorf("Expected no request to Robinhood, got %d", hits.Load()-before)
+	}
+	check := s.CheckHealth(ctx, Robinhood).Checks["circuit_breaker"]
+	if check.Status != HealthDown {
+		t.Errorf("Expected the circuit breaker check to fail, got %+v", check)
+	}
+
+	// After the cooldown a probe closes the breaker again
+	down.Store(false)
+	now = now.Add(time.Minute)
+	positions, err = s.RefreshPositions(ctx, Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if positions.Stale {
+		t.Error("Expected fresh positions")
+	}
+	status := s.CircuitBreakerStatus()
+	if status.State != BreakerClosed || status.Opens != 1 {
+		t.Errorf("Expected a closed breaker that opened once, got %+v", status)
+	}
+}
+
+func TestCircuitBreaker_NoCache(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	srv, _ := newFlakyUpstream(t, &down)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+	s.SetCircuitBreaker(1, time.Minute)
+	h := NewHandler(s)
+
+	performRequest(h, http.MethodGet, "/positions?account_type=robinhood", "")
+	w := performRequest(h, http.MethodGet, "/positions?account_type=robinhood", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected an error response, got %v", err)
+	}
+	if response.Code != CodeUpstreamUnavailable {
+		t.Errorf("Expected code %s, got %s", CodeUpstreamUnavailable, response.Code)
+	}
+
+	// The breaker state shows in /health
+	w = performRequest(h, http.MethodGet, "/health", "")
+	var health struct {
+		Status         string        `json:"status"`
+		CircuitBreaker BreakerStatus `json:"circuit_breaker"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+		t.Fatalf("Expected a health response, got %v", err)
+	}
+	if health.Status != "degraded" || health.CircuitBreaker.State != BreakerOpen || health.CircuitBreaker.OpenedAt == nil {
+		t.Errorf("Expected a degraded status with an open breaker, got %+v", health)
+	}
+}
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	b := newCircuitBreaker(2, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+	failure := ErrUpstreamUnavailable
+
+	// Other errors show Robinhood is reachable and reset the count
+	b.record(ctx, failure)
+	b.record(ctx, ErrUnauthorized)
+	b.record(ctx, failure)
+	if b.status().State != BreakerClosed {
+		t.Fatalf("Expected a closed breaker, got %+v", b.status())
+	}
+	b.record(ctx, failure)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	// A single probe is let through after the cooldown
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected the probe to be allowed, got %v", err)
+	}
+	if b.status().State != BreakerHalfOpen {
+		t.Errorf("Expected a half-open breaker, got %s", b.status().State)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected concurrent requests to fail during the probe, got %v", err)
+	}
+
+	// An abandoned probe frees the slot for the next one
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.record(canceled, failure)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a new probe to be allowed, got %v", err)
+	}
+
+	// A failed probe reopens the breaker for another cooldown
+	b.record(ctx, failure)
+	if status := b.status(); status.State != BreakerOpen || status.Opens != 2 {
+		t.Errorf("Expected the breaker to reopen, got %+v", status)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+}
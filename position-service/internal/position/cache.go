@@ -0,0 +1,41 @@
+package position
+
+import (
+	"context"
+	"sync"
+)
+
+// PositionCache stores the fetched positions of each account. Get reports a
+// miss with ok false. An error means the cache itself is unavailable, in
+// which case the service fetches from the broker instead.
+type PositionCache interface {
+	Get(ctx context.Context, accountType AccountType, accountID string) (positions *PositionList, ok bool, err error)
+	Set(ctx context.Context, accountType AccountType, accountID string, positions *PositionList) error
+}
+
+// memoryCache is the default PositionCache, private to the process. Entries
+// never expire, they are replaced by refreshes.
+type memoryCache struct {
+	mu    sync.RWMutex
+	lists map[cacheKey]*PositionList
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{lists: make(map[cacheKey]*PositionList)}
+}
+
+// Get implements PositionCache
+func (c *memoryCache) Get(ctx context.Context, accountType AccountType, accountID string) (*PositionList, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	positions, ok := c.lists[cacheKey{accountType: accountType, accountID: accountID}]
+	return positions, ok, nil
+}
+
+// Set implements PositionCache
+func (c *memoryCache) Set(ctx context.Context, accountType AccountType, accountID string, positions *PositionList) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lists[cacheKey{accountType: accountType, accountID: accountID}] = positions
+	return nil
+}
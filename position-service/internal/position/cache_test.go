@@ -0,0 +1,154 @@
+package position
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeCache is a PositionCache that can be taken down like a shared cache
+type fakeCache struct {
+	*memoryCache
+	down atomic.Bool
+	sets atomic.Int32
+}
+
+var errCacheDown = errors.New("connection refused")
+
+func (c *fakeCache) Get(ctx context.Context, accountType AccountType, accountID string) (*PositionList, bool, error) {
+	if c.down.Load() {
+		return nil, false, errCacheDown
+	}
+	return c.memoryCache.Get(ctx, accountType, accountID)
+}
+
+func (c *fakeCache) Set(ctx context.Context, accountType AccountType, accountID string, positions *PositionList) error {
+	c.sets.Add(1)
+	if c.down.Load() {
+		return errCacheDown
+	}
+	return c.memoryCache.Set(ctx, accountType, accountID, positions)
+}
+
+func TestGetPositions_PositionCache(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	cache := &fakeCache{memoryCache: newMemoryCache()}
+
+	var buf bytes.Buffer
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetPositionCache(cache)
+	s.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	// A miss is fetched and cached
+	if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok, _ := cache.memoryCache.Get(context.Background(), Robinhood, "test-account"); !ok {
+		t.Fatal("Expected the positions to be cached")
+	}
+
+	// Positions cached by another replica are served without fetching
+	cache.memoryCache.Set(context.Background(), Robinhood, "test-account", &PositionList{
+		Positions: []Position{{ID: "from-cache"}},
+		AccountID: "test-account",
+	})
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(positions.Positions) != 1 || positions.Positions[0].ID != "from-cache" {
+		t.Errorf("Expected the cached positions, got %+v", positions.Positions)
+	}
+	if strings.Contains(buf.String(), "level=WARN") {
+		t.Errorf("Expected no warnings, got %s", buf.String())
+	}
+}
+
+func TestGetPositions_PositionCacheDown(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	cache := &fakeCache{memoryCache: newMemoryCache()}
+	cache.down.Store(true)
+
+	var buf bytes.Buffer
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetPositionCache(cache)
+	s.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	// Reads and writes fail, the positions are fetched directly
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected the fetch to fall back to the broker, got %v", err)
+	}
+	if len(positions.Positions) != 2 {
+		t.Errorf("Expected 2 positions, got %d", len(positions.Positions))
+	}
+	if cache.sets.Load() != 1 {
+		t.Errorf("Expected 1 cache write attempt, got %d", cache.sets.Load())
+	}
+
+	logs := buf.String()
+	for _, msg := range []string{"Position cache unavailable", "Failed to cache positions"} {
+		if !strings.Contains(logs, "level=WARN msg=\""+msg) {
+			t.Errorf("Expected a %q warning, got %s", msg, logs)
+		}
+	}
+}
+
+func TestPositionCache_KeyedByAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "positions.json")
+	fixture := `{"account_id":"all","accounts":[
+		{"account_id":"111","positions":[{"id":"a","symbol":"AAPL"}]},
+		{"account_id":"222","account_label":"ira","positions":[{"id":"b","symbol":"MSFT"}]}
+	]}`
+	if err := os.WriteFile(path, []byte(fixture), 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	s, err := NewMockService(path, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cache := newMemoryCache()
+	s.SetPositionCache(cache)
+	ctx := context.Background()
+
+	// Both accounts of the same account type are cached side by side
+	for _, account := range []string{"111", "ira"} {
+		if _, err := s.QueryPositions(ctx, PositionQuery{AccountType: Robinhood, Account: account}); err != nil {
+			t.Fatalf("Expected no error for account %s, got %v", account, err)
+		}
+	}
+	for accountID, expectedID := range map[string]string{"111": "a", "222": "b"} {
+		cached, ok, _ := cache.Get(ctx, Robinhood, accountID)
+		if !ok || len(cached.Positions) != 1 || cached.Positions[0].ID != expectedID {
+			t.Errorf("Expected account %s cached with position %s, got %+v", accountID, expectedID, cached)
+		}
+		if history := s.history[cacheKey{accountType: Robinhood, accountID: accountID}]; len(history) != 1 {
+			t.Errorf("Expected 1 history entry for account %s, got %d", accountID, len(history))
+		}
+	}
+
+	// Replacing one account's entry leaves the other untouched
+	cache.Set(ctx, Robinhood, "222", &PositionList{Positions: []Position{{ID: "from-cache"}}, AccountID: "222"})
+	primary, err := s.QueryPositions(ctx, PositionQuery{AccountType: Robinhood})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(primary.Positions) != 1 || primary.Positions[0].ID != "a" {
+		t.Errorf("Expected the primary account's own positions, got %+v", primary.Positions)
+	}
+	ira, err := s.QueryPositions(ctx, PositionQuery{AccountType: Robinhood, Account: "ira"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ira.Positions) != 1 || ira.Positions[0].ID != "from-cache" {
+		t.Errorf("Expected the replaced ira positions, got %+v", ira.Positions)
+	}
+}
@@ -0,0 +1,118 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStalledPricesServer serves the option position fixtures, except that
+// option prices hang until the request is abandoned
+func newStalledPricesServer(t *testing.T) *httptest.Server {
+	fixtures := newFixtureServer(t, robinhoodFixtures)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/marketdata/options/" {
+			<-r.Context().Done()
+			return
+		}
+		fixtures.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestQueryPositions_Cancelled(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration // Request timeout of the service
+		ctx      func() (context.Context, context.CancelFunc)
+		expected error
+	}{
+		{
+			name: "caller cancels",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expected: ErrRequestCanceled,
+		},
+		{
+			name: "caller deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			expected: ErrRequestTimeout,
+		},
+		{
+			name:    "request timeout",
+			timeout: 50 * time.Millisecond,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			expected: ErrRequestTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newStalledPricesServer(t)
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			s.baseURL = srv.URL
+			s.SetRequestTimeout(tt.timeout)
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			_, err := s.GetPositions(ctx, Robinhood)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Expected the request to be abandoned promptly, took %v", elapsed)
+			}
+
+			// Positions without prices must not be cached
+			if _, cached := cachedPositions(s, "test-account"); cached {
+				t.Error("Expected the abandoned fetch not to be cached")
+			}
+		})
+	}
+}
+
+func TestHandler_Cancelled(t *testing.T) {
+	srv := newStalledPricesServer(t)
+	// Each case gets its own service, an abandoned fetch of the previous one
+	// may still be running
+	newHandler := func() (*Service, *Handler) {
+		s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+		s.baseURL = srv.URL
+		return s, NewHandler(s)
+	}
+
+	t.Run("client goes away", func(t *testing.T) {
+		_, h := newHandler()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		req := httptest.NewRequest(http.MethodGet, "/positions?account_type=robinhood", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		newRouter(h).ServeHTTP(w, req)
+		if w.Code != StatusClientClosedRequest {
+			t.Errorf("Expected status %d, got %d: %s", StatusClientClosedRequest, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("request timeout", func(t *testing.T) {
+		s, h := newHandler()
+		s.SetRequestTimeout(50 * time.Millisecond)
+		w := performRequest(h, http.MethodGet, "/positions?account_type=robinhood", "")
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("Expected status %d, got %d: %s", http.StatusGatewayTimeout, w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package position
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/robinhood"
+)
+
+// CodeUnauthorized is the ErrorResponse code of requests without valid
+// credentials
+const CodeUnauthorized = "unauthorized"
+
+// RawPositions is what Robinhood returned for the last position fetch of an
+// account, before any transformation
+type RawPositions struct {
+	AccountID        string                       `json:"account_id"`
+	CapturedAt       time.Time                    `json:"captured_at"`
+	Positions        []robinhood.OptionPosition   `json:"positions"`
+	OptionMarketData []robinhood.OptionMarketData `json:"option_market_data"`
+}
+
+// DebugConfig controls the debug endpoints. They are only served when
+// Enabled, and every request must carry Token as a bearer token.
+type DebugConfig struct {
+	Enabled bool
+	Token   string
+}
+
+// SetDebugCapture enables keeping the raw broker responses of the last
+// position fetch of each account. Disabling it drops the captured responses.
+func (s *Service) SetDebugCapture(enabled bool) {
+	s.debugMutex.Lock()
+	defer s.debugMutex.Unlock()
+	s.debugCapture = enabled
+	if !enabled {
+		s.rawPositions = nil
+	}
+}
+
+// LastRawPositions returns the raw broker responses captured for each
+// account, sorted by account ID
+func (s *Service) LastRawPositions() []RawPositions {
+	s.debugMutex.Lock()
+	defer s.debugMutex.Unlock()
+	captures := make([]RawPositions, 0, len(s.rawPositions))
+	for _, capture := range s.rawPositions {
+		captures = append(captures, capture)
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].AccountID < captures[j].AccountID
+	})
+	return captures
+}
+
+// debugCaptureEnabled reports whether raw broker responses are captured
+func (s *Service) debugCaptureEnabled() bool {
+	s.debugMutex.Lock()
+	defer s.debugMutex.Unlock()
+	return s.debugCapture
+}
+
+// recordRawPositions replaces the captured responses of an account
+func (s *Service) recordRawPositions(capture RawPositions) {
+	s.debugMutex.Lock()
+	defer s.debugMutex.Unlock()
+	if !s.debugCapture {
+		return
+	}
+	if s.rawPositions == nil {
+		s.rawPositions = make(map[string]RawPositions)
+	}
+	s.rawPositions[capture.AccountID] = capture
+}
+
+// RegisterDebugRoutes serves GET /debug/positions on r and enables raw
+// response capture when cfg is enabled, and does nothing otherwise. The
+// endpoints expose account data, so an enabled config without a token is
+// rejected.
+func (h *Handler) RegisterDebugRoutes(r gin.IRoutes, cfg DebugConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Token == "" {
+		return errors.New("debug endpoints require a token")
+	}
+
+	h.service.SetDebugCapture(true)
+	r.GET("/debug/positions", requireBearerToken(cfg.Token), h.DebugPositions)
+	return nil
+}
+
+// DebugPositions handles GET /debug/positions requests with the raw broker
+// responses of the last position fetch of each account. Accounts are only
+// listed once fetched with debug capture enabled.
+func (h *Handler) DebugPositions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"accounts": h.service.LastRawPositions()})
+}
+
+// requireBearerToken rejects requests whose bearer token is not token
+func requireBearerToken(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			writeError(c, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid token")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,115 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// performDebugRequest runs a request through a router with the debug routes
+// registered per cfg
+func performDebugRequest(t *testing.T, h *Handler, cfg DebugConfig, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := newRouter(h)
+	if err := h.RegisterDebugRoutes(r, cfg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/debug/positions", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDebugPositions_Disabled(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	h := NewHandler(s)
+
+	w := performDebugRequest(t, h, DebugConfig{Token: "debug-token"}, "debug-token")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if captures := s.LastRawPositions(); len(captures) != 0 {
+		t.Errorf("Expected nothing captured, got %d captures", len(captures))
+	}
+}
+
+func TestDebugPositions_RequiresToken(t *testing.T) {
+	h := NewHandler(NewService(&stubTokenService{token: "test-token"}, "test-account"))
+	if err := h.RegisterDebugRoutes(newRouter(h), DebugConfig{Enabled: true}); err == nil {
+		t.Error("Expected an error for an enabled config without a token")
+	}
+
+	cfg := DebugConfig{Enabled: true, Token: "debug-token"}
+	for _, token := range []string{"", "wrong-token"} {
+		w := performDebugRequest(t, h, cfg, token)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for token %q, got %d", http.StatusUnauthorized, token, w.Code)
+		}
+	}
+}
+
+func TestDebugPositions_ReturnsCapturedResponses(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	h := NewHandler(s)
+	cfg := DebugConfig{Enabled: true, Token: "debug-token"}
+
+	// Nothing is captured before the first fetch
+	w := performDebugRequest(t, h, cfg, "debug-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Accounts []RawPositions `json:"accounts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON response, got %v", err)
+	}
+	if len(response.Accounts) != 0 {
+		t.Errorf("Expected no accounts, got %d", len(response.Accounts))
+	}
+
+	if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	w = performDebugRequest(t, h, cfg, "debug-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON response, got %v", err)
+	}
+	if len(response.Accounts) != 1 {
+		t.Fatalf("Expected 1 account, got %d", len(response.Accounts))
+	}
+	capture := response.Accounts[0]
+	if capture.AccountID != "test-account" {
+		t.Errorf("Expected account test-account, got %s", capture.AccountID)
+	}
+	if capture.CapturedAt.IsZero() {
+		t.Error("Expected a capture time")
+	}
+	// The closed position is kept, the raw response is not filtered
+	if len(capture.Positions) != 3 {
+		t.Errorf("Expected 3 raw positions, got %d", len(capture.Positions))
+	}
+	marks := make(map[string]string)
+	for _, data := range capture.OptionMarketData {
+		marks[data.InstrumentID] = data.MarkPrice
+	}
+	if marks["opt-aapl-call"] != "3.0000" || marks["opt-msft-put"] != "1.0000" {
+		t.Errorf("Expected the raw mark prices, got %v", marks)
+	}
+}
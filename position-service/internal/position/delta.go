@@ -0,0 +1,180 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultDeltaHistorySize is how many fetched position lists are kept per
+	// account for PositionsDelta, an hour at a one minute refresh interval
+	DefaultDeltaHistorySize = 60
+	// DefaultDeltaEpsilon is the smallest change in quantity, price or P&L
+	// that PositionsDelta reports
+	DefaultDeltaEpsilon = 0.005
+)
+
+// ErrHistoryExpired is returned when a delta is requested since a time that
+// predates the retained position history
+var ErrHistoryExpired = errors.New("position history expired")
+
+// PositionDelta is the difference between the positions the service had at
+// Since and its current ones. Removed positions are as they were at Since.
+type PositionDelta struct {
+	Added       []Position  `json:"added"`
+	Removed     []Position  `json:"removed"`
+	Changed     []Position  `json:"changed"`
+	Since       time.Time   `json:"since"`
+	AccountID   string      `json:"account_id"`
+	AccountType AccountType `json:"account_type"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// SetDeltaHistory sets how many fetched position lists are kept per account
+// and the smallest change PositionsDelta reports. A non-positive size keeps
+// DefaultDeltaHistorySize.
+func (s *Service) SetDeltaHistory(size int, epsilon float64) {
+	if size <= 0 {
+		size = DefaultDeltaHistorySize
+	}
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historySize = size
+	s.deltaEpsilon = epsilon
+	for key, lists := range s.history {
+		if len(lists) > size {
+			s.history[key] = lists[len(lists)-size:]
+		}
+	}
+}
+
+// recordHistory keeps a fetched position list for PositionsDelta, dropping
+// the oldest beyond the history size
+func (s *Service) recordHistory(accountType AccountType, account Account, positions *PositionList) {
+	key := cacheKey{accountType: accountType, accountID: account.ID}
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	lists := append(s.history[key], positions)
+	if len(lists) > s.historySize {
+		lists = lists[len(lists)-s.historySize:]
+	}
+	s.history[key] = lists
+}
+
+// historyAt returns the newest position list of an account fetched at or
+// before since, and ErrHistoryExpired if there is none
+func (s *Service) historyAt(accountType AccountType, account Account, since time.Time) (*PositionList, error) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	lists := s.history[cacheKey{accountType: accountType, accountID: account.ID}]
+	for i := len(lists) - 1; i >= 0; i-- {
+		if !lists[i].UpdatedAt.After(since) {
+			return lists[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no positions of account %s retained from %s, fetch them in full",
+		ErrHistoryExpired, account.Label, since.Format(time.RFC3339))
+}
+
+// PositionsDelta returns the positions of the selected account added,
+// removed or changed since the given time, relative to the positions the
+// service had fetched by then. Only fetches made by this instance are
+// retained, in memory, so a time before the oldest of them or before the
+// service started returns ErrHistoryExpired.
+func (s *Service) PositionsDelta(ctx context.Context, q PositionQuery, since time.Time) (*PositionDelta, error) {
+	s.historyMutex.Lock()
+	epsilon := s.deltaEpsilon
+	s.historyMutex.Unlock()
+	s.cacheMutex.RLock()
+	minMarketValue := s.minMarketValue
+	s.cacheMutex.RUnlock()
+
+	accounts, accountID, err := s.selectAccounts(q.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	delta := &PositionDelta{
+		Added:       []Position{},
+		Removed:     []Position{},
+		Changed:     []Position{},
+		Since:       since,
+		AccountID:   accountID,
+		AccountType: q.AccountType,
+	}
+	for _, account := range accounts {
+		current, err := s.getPositions(ctx, q.AccountType, account, q.Refresh)
+		if err != nil {
+			if len(accounts) > 1 {
+				err = fmt.Errorf("account %s: %w", account.Label, err)
+			}
+			return nil, contextError(ctx, err)
+		}
+		base, err := s.historyAt(q.AccountType, account, since)
+		if err != nil {
+			return nil, err
+		}
+
+		added, removed, changed := DiffPositions(
+			base.FilterByMinMarketValue(minMarketValue).Positions,
+			current.FilterByMinMarketValue(minMarketValue).Positions,
+			epsilon,
+		)
+		delta.Added = append(delta.Added, added...)
+		delta.Removed = append(delta.Removed, removed...)
+		delta.Changed = append(delta.Changed, changed...)
+		if current.UpdatedAt.After(delta.UpdatedAt) {
+			delta.UpdatedAt = current.UpdatedAt
+		}
+	}
+	return delta, nil
+}
+
+// DiffPositions compares two lists of positions by account and ID. A
+// position changed when its quantity, current price or unrealized P&L moved
+// by more than epsilon. Each result is sorted by symbol, then ID.
+func DiffPositions(before, after []Position, epsilon float64) (added, removed, changed []Position) {
+	type key struct{ accountID, id string }
+	previous := make(map[key]Position, len(before))
+	for _, p := range before {
+		previous[key{p.AccountID, p.ID}] = p
+	}
+
+	added, removed, changed = []Position{}, []Position{}, []Position{}
+	for _, p := range after {
+		k := key{p.AccountID, p.ID}
+		old, ok := previous[k]
+		if !ok {
+			added = append(added, p)
+			continue
+		}
+		delete(previous, k)
+		if math.Abs(p.Quantity-old.Quantity) > epsilon ||
+			math.Abs(p.CurrentPrice-old.CurrentPrice) > epsilon ||
+			math.Abs(p.UnrealizedPnL-old.UnrealizedPnL) > epsilon {
+			changed = append(changed, p)
+		}
+	}
+	for _, p := range before {
+		if _, ok := previous[key{p.AccountID, p.ID}]; ok {
+			removed = append(removed, p)
+		}
+	}
+
+	for _, list := range [][]Position{added, removed, changed} {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].Symbol != list[j].Symbol {
+				return list[i].Symbol < list[j].Symbol
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+	return added, removed, changed
+}
@@ -0,0 +1,156 @@
+package position
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDiffPositions(t *testing.T) {
+	before := []Position{
+		{ID: "aapl", Symbol: "AAPL", Quantity: 2, CurrentPrice: 3, UnrealizedPnL: 100},
+		{ID: "msft", Symbol: "MSFT", Quantity: 1, CurrentPrice: 1, UnrealizedPnL: -50},
+		{ID: "tsla", Symbol: "TSLA", Quantity: 1, CurrentPrice: 5, UnrealizedPnL: 10},
+		{ID: "spy", Symbol: "SPY", Quantity: 1, CurrentPrice: 2, UnrealizedPnL: 0},
+	}
+	after := []Position{
+		{ID: "aapl", Symbol: "AAPL", Quantity: 3, CurrentPrice: 3, UnrealizedPnL: 100},     // Quantity changed
+		{ID: "msft", Symbol: "MSFT", Quantity: 1, CurrentPrice: 1.001, UnrealizedPnL: -50}, // Within epsilon
+		{ID: "spy", Symbol: "SPY", Quantity: 1, CurrentPrice: 2, UnrealizedPnL: 25},        // P&L changed
+		{ID: "nvda", Symbol: "NVDA", Quantity: 1, CurrentPrice: 4, UnrealizedPnL: 0},       // Added
+	}
+
+	added, removed, changed := DiffPositions(before, after, 0.01)
+	if len(added) != 1 || added[0].ID != "nvda" {
+		t.Errorf("Expected nvda added, got %+v", added)
+	}
+	if len(removed) != 1 || removed[0].ID != "tsla" {
+		t.Errorf("Expected tsla removed, got %+v", removed)
+	}
+	if len(changed) != 2 || changed[0].ID != "aapl" || changed[1].ID != "spy" {
+		t.Errorf("Expected aapl and spy changed, got %+v", changed)
+	}
+
+	// Without an epsilon any change counts
+	if _, _, changed := DiffPositions(before, after, 0); len(changed) != 3 {
+		t.Errorf("Expected 3 changed positions, got %d", len(changed))
+	}
+}
+
+func TestHandler_PositionsDelta(t *testing.T) {
+	start := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	s := newCachedService(
+		Position{ID: "aapl", AccountID: "test-account", Symbol: "AAPL", Quantity: 2, CurrentPrice: 3.5},
+		Position{ID: "nvda", AccountID: "test-account", Symbol: "NVDA", Quantity: 1, CurrentPrice: 4},
+	)
+	account := Account{Label: PrimaryAccountLabel, ID: "test-account"}
+	s.recordHistory(Robinhood, account, &PositionList{
+		Positions: []Position{
+			{ID: "aapl", AccountID: "test-account", Symbol: "AAPL", Quantity: 2, CurrentPrice: 3},
+			{ID: "msft", AccountID: "test-account", Symbol: "MSFT", Quantity: 1, CurrentPrice: 1},
+		},
+		AccountID: "test-account",
+		UpdatedAt: start,
+	})
+	s.recordHistory(Robinhood, account, &PositionList{
+		Positions: []Position{
+			{ID: "aapl", AccountID: "test-account", Symbol: "AAPL", Quantity: 2, CurrentPrice: 3.5},
+			{ID: "nvda", AccountID: "test-account", Symbol: "NVDA", Quantity: 1, CurrentPrice: 4},
+		},
+		AccountID: "test-account",
+		UpdatedAt: start.Add(time.Minute),
+	})
+	h := NewHandler(s)
+
+	tests := []struct {
+		name            string
+		target          string
+		expectedStatus  int
+		expectedCode    string
+		expectedAdded   int
+		expectedRemoved int
+		expectedChanged int
+	}{
+		{
+			name:            "since the first snapshot",
+			target:          "/positions/delta?account_type=robinhood&since=2026-03-02T15:00:30Z",
+			expectedStatus:  http.StatusOK,
+			expectedAdded:   1,
+			expectedRemoved: 1,
+			expectedChanged: 1,
+		},
+		{
+			name:           "since the latest snapshot",
+			target:         "/positions/delta?account_type=robinhood&since=2026-03-02T15:05:00Z",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "since predates history",
+			target:         "/positions/delta?account_type=robinhood&since=2026-03-02T14:59:00Z",
+			expectedStatus: http.StatusGone,
+			expectedCode:   CodeHistoryExpired,
+		},
+		{
+			name:           "invalid since",
+			target:         "/positions/delta?account_type=robinhood&since=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeInvalidRequest,
+		},
+		{
+			name:           "missing since",
+			target:         "/positions/delta?account_type=robinhood",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(h, http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatalf("Expected an error response, got %v", err)
+				}
+				if response.Code != tt.expectedCode {
+					t.Errorf("Expected code %s, got %s", tt.expectedCode, response.Code)
+				}
+				return
+			}
+
+			var delta PositionDelta
+			if err := json.Unmarshal(w.Body.Bytes(), &delta); err != nil {
+				t.Fatalf("Expected a delta, got %v", err)
+			}
+			if len(delta.Added) != tt.expectedAdded || len(delta.Removed) != tt.expectedRemoved || len(delta.Changed) != tt.expectedChanged {
+				t.Errorf("Expected %d added, %d removed and %d changed, got %+v",
+					tt.expectedAdded, tt.expectedRemoved, tt.expectedChanged, delta)
+			}
+		})
+	}
+}
+
+func TestRecordHistory_KeepsHistorySize(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.SetDeltaHistory(2, DefaultDeltaEpsilon)
+	account := Account{Label: PrimaryAccountLabel, ID: "test-account"}
+	start := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		s.recordHistory(Robinhood, account, &PositionList{AccountID: "test-account", UpdatedAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	if _, err := s.historyAt(Robinhood, account, start); err == nil {
+		t.Error("Expected the oldest list to be dropped")
+	}
+	list, err := s.historyAt(Robinhood, account, start.Add(90*time.Second))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !list.UpdatedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the list fetched at %v, got %v", start.Add(time.Minute), list.UpdatedAt)
+	}
+}
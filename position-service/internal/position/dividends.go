@@ -0,0 +1,216 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDividendCacheTTL is how long fetched dividends are served. They
+// change rarely, a few times a quarter.
+const DefaultDividendCacheTTL = 24 * time.Hour
+
+// Dividend states reported by Robinhood
+const (
+	DividendPending    = "pending"
+	DividendPaid       = "paid"
+	DividendReinvested = "reinvested"
+	DividendVoided     = "voided"
+)
+
+// Dividend is a dividend paid or scheduled on an equity position
+type Dividend struct {
+	ID        string  `json:"id"`
+	AccountID string  `json:"account_id"`
+	Symbol    string  `json:"symbol"`
+	Amount    float64 `json:"amount"`   // Total paid for the position
+	Rate      float64 `json:"rate"`     // Paid per share
+	Position  float64 `json:"position"` // Shares held on the record date
+	// PayableDate is the YYYY-MM-DD date the dividend is paid on
+	PayableDate string `json:"payable_date"`
+	State       string `json:"state"` // pending, paid, reinvested or voided
+}
+
+// DividendSummary totals the dividends of a report
+type DividendSummary struct {
+	// Received is paid or reinvested in the report period
+	Received float64 `json:"received"`
+	// Upcoming is announced but not paid yet, whatever the period
+	Upcoming      float64 `json:"upcoming"`
+	UpcomingCount int     `json:"upcoming_count"`
+}
+
+// DividendQuery selects the account and period of a dividend report
+type DividendQuery struct {
+	AccountType AccountType
+	// Account is an account number or label, as in PositionQuery
+	Account string
+	// Dividends payable in [From, To) are reported
+	From time.Time
+	To   time.Time
+	// Refresh bypasses the dividend cache
+	Refresh bool
+}
+
+// DividendReport lists the dividends of an account payable in a period,
+// newest first
+type DividendReport struct {
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	AccountID   string          `json:"account_id"`
+	AccountType AccountType     `json:"account_type"`
+	Dividends   []Dividend      `json:"dividends"`
+	Summary     DividendSummary `json:"summary"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// dividendHistory is the cached dividend history of an account
+type dividendHistory struct {
+	dividends []Dividend
+	fetchedAt time.Time
+}
+
+// SetDividendCacheTTL sets how long fetched dividends are served. A
+// non-positive TTL disables the cache.
+func (s *Service) SetDividendCacheTTL(ttl time.Duration) {
+	s.dividendMutex.Lock()
+	s.dividendCacheTTL = ttl
+	s.dividendMutex.Unlock()
+}
+
+// Dividends reports the dividends of the selected account payable in the
+// query period, with the amount received in it and the amount still
+// upcoming. Voided dividends are left out.
+func (s *Service) Dividends(ctx context.Context, q DividendQuery) (*DividendReport, error) {
+	accounts, accountID, err := s.selectAccounts(q.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	report := &DividendReport{
+		From:        q.From,
+		To:          q.To,
+		AccountID:   accountID,
+		AccountType: q.AccountType,
+		Dividends:   []Dividend{},
+	}
+	for _, account := range accounts {
+		history, err := s.getDividends(ctx, q.AccountType, account, q.Refresh)
+		if err != nil {
+			if len(accounts) > 1 {
+				err = fmt.Errorf("account %s: %w", account.Label, err)
+			}
+			return nil, contextError(ctx, err)
+		}
+
+		for _, dividend := range history.dividends {
+			switch dividend.State {
+			case DividendVoided:
+				continue
+			case DividendPending:
+				report.Summary.Upcoming += dividend.Amount
+				report.Summary.UpcomingCount++
+			}
+
+			payable, err := time.Parse(time.DateOnly, dividend.PayableDate)
+			if err != nil || payable.Before(q.From) || !payable.Before(q.To) {
+				continue
+			}
+			if dividend.State == DividendPaid || dividend.State == DividendReinvested {
+				report.Summary.Received += dividend.Amount
+			}
+			report.Dividends = append(report.Dividends, dividend)
+		}
+		if history.fetchedAt.After(report.UpdatedAt) {
+			report.UpdatedAt = history.fetchedAt
+		}
+	}
+
+	sort.SliceStable(report.Dividends, func(i, j int) bool {
+		return report.Dividends[i].PayableDate > report.Dividends[j].PayableDate
+	})
+	return report, nil
+}
+
+// getDividends returns the cached dividend history of an account, fetching
+// it if missing, expired or when refresh is set
+func (s *Service) getDividends(ctx context.Context, accountType AccountType, account Account, refresh bool) (*dividendHistory, error) {
+	key := cacheKey{accountType: accountType, accountID: account.ID}
+
+	s.dividendMutex.Lock()
+	cached, exists := s.dividendCache[key]
+	ttl := s.dividendCacheTTL
+	s.dividendMutex.Unlock()
+	if exists && !refresh && time.Since(cached.fetchedAt) < ttl {
+		return cached, nil
+	}
+
+	// Mock mode has no dividends
+	var dividends []Dividend
+	if s.mock == nil {
+		err := s.withToken(ctx, accountType, account, func(token string) error {
+			var err error
+			dividends, err = s.fetchAccountDividends(ctx, accountType, token, account.ID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	history := &dividendHistory{dividends: dividends, fetchedAt: time.Now()}
+	s.dividendMutex.Lock()
+	s.dividendCache[key] = history
+	s.dividendMutex.Unlock()
+
+	return history, nil
+}
+
+// fetchAccountDividends fetches the dividends of an account from its broker
+func (s *Service) fetchAccountDividends(ctx context.Context, accountType AccountType, token, accountID string) ([]Dividend, error) {
+	switch accountType {
+	case Robinhood:
+		return s.fetchRobinhoodDividends(ctx, token, accountID)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
+	}
+}
+
+// fetchRobinhoodDividends fetches the dividends of an account, every page of
+// them. Robinhood lists the dividends of every account of the user, so
+// those of other accounts are skipped.
+func (s *Service) fetchRobinhoodDividends(ctx context.Context, token, accountID string) ([]Dividend, error) {
+	items, err := s.robinhood().GetDividends(ctx, token)
+	if err != nil {
+		return nil, s.robinhoodError(ctx, "dividends", err)
+	}
+
+	var dividends []Dividend
+	for _, item := range items {
+		if item.Account != "" && path.Base(strings.TrimSuffix(item.Account, "/")) != accountID {
+			continue
+		}
+		symbol, err := s.instrumentSymbol(ctx, item.Instrument, token)
+		if err != nil {
+			return nil, fmt.Errorf("dividend %s: %w", item.ID, err)
+		}
+
+		dividends = append(dividends, Dividend{
+			ID:          item.ID,
+			AccountID:   accountID,
+			Symbol:      symbol,
+			Amount:      parseDecimal(item.Amount),
+			Rate:        parseDecimal(item.Rate),
+			Position:    parseDecimal(item.Position),
+			PayableDate: item.PayableDate,
+			State:       item.State,
+		})
+	}
+	return dividends, nil
+}
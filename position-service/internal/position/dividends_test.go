@@ -0,0 +1,138 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// dividendFixtures maps the Robinhood endpoints used for dividends to their fixtures
+var dividendFixtures = map[string]string{
+	"/dividends/":          "dividends.json",
+	"/dividends/?cursor=2": "dividends_page2.json",
+	"/instruments/aapl/":   "instrument_aapl.json",
+	"/instruments/msft/":   "instrument_msft.json",
+}
+
+func TestDividends(t *testing.T) {
+	srv := newFixtureServer(t, dividendFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	report, err := s.Dividends(context.Background(), DividendQuery{
+		AccountType: Robinhood,
+		From:        time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Both pages are read; the voided dividend, the one paid before the
+	// period, the upcoming one and the other account's are not listed
+	if len(report.Dividends) != 2 {
+		t.Fatalf("Expected 2 dividends, got %d: %+v", len(report.Dividends), report.Dividends)
+	}
+	expected := []Dividend{
+		{ID: "div-msft-reinvested", AccountID: "test-account", Symbol: "MSFT", Amount: 8.30, Rate: 0.83, Position: 10, PayableDate: "2025-03-13", State: DividendReinvested},
+		{ID: "div-aapl-paid", AccountID: "test-account", Symbol: "AAPL", Amount: 2.50, Rate: 0.25, Position: 10, PayableDate: "2025-02-13", State: DividendPaid},
+	}
+	for i, want := range expected {
+		if report.Dividends[i] != want {
+			t.Errorf("Expected dividend %d to be %+v, got %+v", i, want, report.Dividends[i])
+		}
+	}
+
+	if !almostEqual(report.Summary.Received, 10.80) {
+		t.Errorf("Expected 10.80 received, got %v", report.Summary.Received)
+	}
+	if !almostEqual(report.Summary.Upcoming, 2.60) || report.Summary.UpcomingCount != 1 {
+		t.Errorf("Expected 1 upcoming dividend of 2.60, got %d of %v", report.Summary.UpcomingCount, report.Summary.Upcoming)
+	}
+	if report.AccountID != "test-account" || report.UpdatedAt.IsZero() {
+		t.Errorf("Expected the report of test-account with a fetch time, got %+v", report)
+	}
+}
+
+func TestDividends_Cache(t *testing.T) {
+	srv := newFixtureServer(t, dividendFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	q := DividendQuery{
+		AccountType: Robinhood,
+		From:        time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if _, err := s.Dividends(context.Background(), q); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Once fetched, dividends are served without Robinhood
+	srv.Close()
+	s.SetRetryPolicy(fastRetryPolicy)
+	report, err := s.Dividends(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Expected cached dividends, got %v", err)
+	}
+	if len(report.Dividends) != 2 {
+		t.Errorf("Expected 2 cached dividends, got %d", len(report.Dividends))
+	}
+
+	q.Refresh = true
+	if _, err := s.Dividends(context.Background(), q); err == nil {
+		t.Error("Expected a refresh to reach Robinhood")
+	}
+
+	// Without a cache every query reaches Robinhood
+	q.Refresh = false
+	s.SetDividendCacheTTL(0)
+	if _, err := s.Dividends(context.Background(), q); err == nil {
+		t.Error("Expected an uncached query to reach Robinhood")
+	}
+}
+
+func TestHandler_Dividends(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{name: "period", target: "/dividends?account_type=robinhood&from=2025-01-01&to=2025-04-01", expectedStatus: http.StatusOK, expectedCount: 2},
+		{name: "wider period", target: "/dividends?account_type=robinhood&from=2024-01-01&to=2025-04-01", expectedStatus: http.StatusOK, expectedCount: 3},
+		{name: "missing account type", target: "/dividends?from=2025-01-01", expectedStatus: http.StatusBadRequest},
+		{name: "invalid from", target: "/dividends?account_type=robinhood&from=yesterday", expectedStatus: http.StatusBadRequest},
+		{name: "from after to", target: "/dividends?account_type=robinhood&from=2025-04-01&to=2025-01-01", expectedStatus: http.StatusBadRequest},
+		{name: "unknown account", target: "/dividends?account_type=robinhood&account_label=ira", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFixtureServer(t, dividendFixtures)
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			s.baseURL = srv.URL
+
+			w := performRequest(NewHandler(s), http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var report DividendReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Expected a dividend report, got %v", err)
+			}
+			if len(report.Dividends) != tt.expectedCount {
+				t.Errorf("Expected %d dividends, got %d", tt.expectedCount, len(report.Dividends))
+			}
+			if report.Summary.UpcomingCount != 1 {
+				t.Errorf("Expected 1 upcoming dividend, got %d", report.Summary.UpcomingCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package position
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Exposure is the combined exposure of every position on one underlying,
+// options and shares alike. Contracts and Shares are net of short
+// positions.
+type Exposure struct {
+	Symbol      string  `json:"symbol"`
+	MarketValue float64 `json:"market_value"`
+	CostBasis   float64 `json:"cost_basis"`
+	// NetDelta is in shares of the underlying, nil unless the delta of every
+	// option position is known
+	NetDelta  *float64 `json:"net_delta,omitempty"`
+	Contracts float64  `json:"contracts"`
+	Shares    float64  `json:"shares"`
+	Positions int      `json:"positions"`
+}
+
+// ExposureReport is the exposure of an account, or of every account when
+// AccountID is AllAccounts, by underlying symbol
+type ExposureReport struct {
+	Exposures   map[string]Exposure `json:"exposures"`
+	AccountID   string              `json:"account_id"`
+	AccountType AccountType         `json:"account_type"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// ExposureByUnderlying returns the exposure of the primary account's cached
+// positions by underlying symbol
+func (s *Service) ExposureByUnderlying(ctx context.Context, accountType AccountType) (map[string]Exposure, error) {
+	positions, err := s.GetPositions(ctx, accountType)
+	if err != nil {
+		return nil, err
+	}
+	return GroupExposure(positions.Positions), nil
+}
+
+// GroupExposure sums positions by underlying symbol, ignoring case. Equity
+// positions count towards the net delta one for one.
+func GroupExposure(positions []Position) map[string]Exposure {
+	exposures := make(map[string]Exposure)
+	deltaKnown := make(map[string]bool)
+	for _, p := range positions {
+		symbol := p.UnderlyingSymbol
+		if symbol == "" {
+			symbol = p.Symbol
+		}
+		symbol = strings.ToUpper(symbol)
+
+		exposure, ok := exposures[symbol]
+		if !ok {
+			exposure = Exposure{Symbol: symbol, NetDelta: new(float64)}
+			deltaKnown[symbol] = true
+		}
+		exposure.MarketValue += p.MarketValue
+		exposure.CostBasis += p.CostBasis
+		exposure.Positions++
+
+		if p.OptionType == "" {
+			exposure.Shares += p.Quantity
+			*exposure.NetDelta += p.Quantity
+		} else {
+			exposure.Contracts += p.Quantity
+			if p.Delta == nil {
+				deltaKnown[symbol] = false
+			} else {
+				multiplier := p.Multiplier
+				if multiplier == 0 {
+					multiplier = optionMultiplier
+				}
+				*exposure.NetDelta += *p.Delta * p.Quantity * multiplier
+			}
+		}
+		exposures[symbol] = exposure
+	}
+
+	for symbol, known := range deltaKnown {
+		if !known {
+			exposure := exposures[symbol]
+			exposure.NetDelta = nil
+			exposures[symbol] = exposure
+		}
+	}
+	return exposures
+}
@@ -0,0 +1,114 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// exposurePositions holds three AAPL option legs, AAPL shares and an
+// unrelated MSFT put
+func exposurePositions() []Position {
+	delta := func(d float64) *float64 { return &d }
+	return []Position{
+		{ID: "aapl-long-call", Symbol: "AAPL", UnderlyingSymbol: "AAPL", OptionType: Call, Side: Long, Quantity: 2, Multiplier: 100,
+			MarketValue: 1200, CostBasis: 1000, Delta: delta(0.6)},
+		{ID: "aapl-short-call", Symbol: "AAPL", UnderlyingSymbol: "AAPL", OptionType: Call, Side: Short, Quantity: -2, Multiplier: 100,
+			MarketValue: -500, CostBasis: -400, Delta: delta(0.3)},
+		{ID: "aapl-long-put", Symbol: "AAPL", UnderlyingSymbol: "AAPL", OptionType: Put, Side: Long, Quantity: 1,
+			MarketValue: 300, CostBasis: 250, Delta: delta(-0.4)},
+		{ID: "aapl-shares", Symbol: "aapl", Side: Long, Quantity: 50, MarketValue: 9500, CostBasis: 9000},
+		{ID: "msft-put", Symbol: "MSFT", UnderlyingSymbol: "MSFT", OptionType: Put, Side: Long, Quantity: 1, Multiplier: 100,
+			MarketValue: 100, CostBasis: 120},
+	}
+}
+
+func TestGroupExposure(t *testing.T) {
+	exposures := GroupExposure(exposurePositions())
+	if len(exposures) != 2 {
+		t.Fatalf("Expected AAPL and MSFT, got %+v", exposures)
+	}
+
+	aapl := exposures["AAPL"]
+	if aapl.Positions != 4 || aapl.Contracts != 1 || aapl.Shares != 50 {
+		t.Errorf("Expected 4 positions, 1 net contract and 50 shares, got %+v", aapl)
+	}
+	if !almostEqual(aapl.MarketValue, 10500) || !almostEqual(aapl.CostBasis, 9850) {
+		t.Errorf("Expected market value 10500 and cost basis 9850, got %v and %v", aapl.MarketValue, aapl.CostBasis)
+	}
+	// 120 - 60 - 40 from the options, the put defaulting to 100 shares a
+	// contract, plus the 50 shares
+	if aapl.NetDelta == nil || !almostEqual(*aapl.NetDelta, 70) {
+		t.Errorf("Expected a net delta of 70, got %v", aapl.NetDelta)
+	}
+
+	// Without the put's delta the net delta is unknown
+	msft := exposures["MSFT"]
+	if msft.Positions != 1 || msft.Contracts != 1 || msft.NetDelta != nil {
+		t.Errorf("Expected a single contract without delta, got %+v", msft)
+	}
+	if !almostEqual(msft.MarketValue, 100) || !almostEqual(msft.CostBasis, 120) {
+		t.Errorf("Expected market value 100 and cost basis 120, got %v and %v", msft.MarketValue, msft.CostBasis)
+	}
+}
+
+func TestExposureByUnderlying_UsesCachedPositions(t *testing.T) {
+	s := NewService(&stubTokenService{err: ErrNotConfigured}, "test-account")
+	list := &PositionList{Positions: exposurePositions(), AccountID: "test-account", AccountType: Robinhood}
+	if err := s.positionCache.Set(context.Background(), Robinhood, "test-account", list); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	exposures, err := s.ExposureByUnderlying(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(exposures) != 2 || exposures["AAPL"].Positions != 4 {
+		t.Errorf("Expected the cached positions grouped, got %+v", exposures)
+	}
+}
+
+func TestExposureByUnderlying_Delta(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	exposures, err := s.ExposureByUnderlying(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// 2 contracts at 0.55
+	if aapl := exposures["AAPL"]; aapl.NetDelta == nil || !almostEqual(*aapl.NetDelta, 110) {
+		t.Errorf("Expected an AAPL net delta of 110, got %+v", aapl)
+	}
+	if msft := exposures["MSFT"]; msft.NetDelta != nil {
+		t.Errorf("Expected no MSFT delta, got %v", *msft.NetDelta)
+	}
+}
+
+func TestHandler_Exposure(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	w := performRequest(NewHandler(s), http.MethodGet, "/exposure?account_type=robinhood", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report ExposureReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Expected an exposure report, got %v", err)
+	}
+	if report.AccountID != "test-account" || len(report.Exposures) != 2 {
+		t.Errorf("Expected AAPL and MSFT for test-account, got %+v", report)
+	}
+	if aapl := report.Exposures["AAPL"]; !almostEqual(aapl.MarketValue, 600) || aapl.Contracts != 2 {
+		t.Errorf("Expected 2 AAPL contracts worth 600, got %+v", aapl)
+	}
+
+	w = performRequest(NewHandler(s), http.MethodGet, "/exposure", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -1,41 +1,787 @@
 package position
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
 )
 
+// PositionProvider is the service behind Handler. Service implements it,
+// handler tests can use the fake in the testutil package instead.
+type PositionProvider interface {
+	GetPositions(ctx context.Context, accountType AccountType) (*PositionList, error)
+	QueryPositions(ctx context.Context, q PositionQuery) (*PositionList, error)
+	Spreads(ctx context.Context, q PositionQuery) (*SpreadList, error)
+	PositionsDelta(ctx context.Context, q PositionQuery, since time.Time) (*PositionDelta, error)
+	ListOrders(ctx context.Context, q OrderQuery) (*OrderList, error)
+	RealizedPnL(ctx context.Context, q PnLQuery) (*RealizedPnLReport, error)
+	Dividends(ctx context.Context, q DividendQuery) (*DividendReport, error)
+	Watchlists(ctx context.Context, accountType AccountType) (*WatchlistList, error)
+	Watchlist(ctx context.Context, accountType AccountType, name string) (*Watchlist, error)
+	SubscribePositions(accountType AccountType, account string) (updates <-chan *PositionList, cancel func(), err error)
+
+	// Health and debugging
+	CheckHealth(ctx context.Context, accountType AccountType) HealthReport
+	RefreshStatus() []RefreshStatus
+	CircuitBreakerStatus() BreakerStatus
+	IsMock() bool
+	RetryCount() int64
+	RateLimitWait() time.Duration
+	SetDebugCapture(enabled bool)
+	LastRawPositions() []RawPositions
+
+	// Logger logs the failed requests
+	Logger() *slog.Logger
+}
+
 // Handler handles HTTP requests for positions
 type Handler struct {
-	service *Service
+	service PositionProvider
+	// streamKeepAlive is the interval of keepalive comments on position
+	// streams, see SetStreamKeepAlive
+	streamKeepAlive time.Duration
+	// streamsDone is closed by CloseStreams to end the position streams
+	streamsDone  chan struct{}
+	closeStreams sync.Once
 }
 
-// PositionRequest represents a request for positions
+// DefaultStreamKeepAlive is how often an idle position stream sends a
+// keepalive comment, well below common proxy idle timeouts
+const DefaultStreamKeepAlive = 15 * time.Second
+
+// PositionRequest represents a request for positions. It is bound from the
+// JSON body for POST requests and from query parameters for GET requests.
 type PositionRequest struct {
-	AccountType AccountType `json:"account_type" binding:"required"`
+	AccountType AccountType `json:"account_type" form:"account_type" binding:"required"`
+	// AccountID or AccountLabel select the account, defaulting to the primary.
+	// Use "all" to merge every configured account.
+	AccountID    string `json:"account_id" form:"account_id"`
+	AccountLabel string `json:"account_label" form:"account_label"`
+	// Refresh bypasses the position cache
+	Refresh bool `json:"refresh" form:"refresh"`
+	// MinMarketValue optionally excludes positions below this market value
+	MinMarketValue float64 `json:"min_market_value" form:"min_market_value" binding:"gte=0"`
+	// Sort optionally orders the list by pnl, pnl_percent, market_value or
+	// symbol, in ascending Order unless Order is desc
+	Sort  string `json:"sort" form:"sort"`
+	Order string `json:"order" form:"order"`
+	// AssetType optionally keeps only option, equity or crypto positions
+	AssetType string `json:"asset_type" form:"asset_type"`
+	// Symbol optionally keeps only positions whose symbol starts with it
+	Symbol string `json:"symbol" form:"symbol"`
+}
+
+// DeltaRequest holds the query parameters of the positions delta endpoint.
+// Since is an RFC 3339 time.
+type DeltaRequest struct {
+	AccountType  AccountType `form:"account_type" binding:"required"`
+	AccountID    string      `form:"account_id"`
+	AccountLabel string      `form:"account_label"`
+	Since        string      `form:"since" binding:"required"`
+	// Refresh bypasses the position cache for the current positions
+	Refresh bool `form:"refresh"`
+}
+
+// PnLRequest holds the query parameters of the realized P&L endpoint. from
+// and to accept RFC 3339 times or YYYY-MM-DD dates; trades closed in
+// [from, to) are reported. The period defaults to the year to date.
+type PnLRequest struct {
+	AccountType  AccountType `form:"account_type" binding:"required"`
+	AccountID    string      `form:"account_id"`
+	AccountLabel string      `form:"account_label"`
+	From         string      `form:"from"`
+	To           string      `form:"to"`
+	// Refresh bypasses the order history cache
+	Refresh bool `form:"refresh"`
+}
+
+// OrderRequest holds the query parameters of the orders endpoint. since
+// accepts an RFC 3339 time or a YYYY-MM-DD date and keeps orders updated at
+// or after it.
+type OrderRequest struct {
+	AccountType  AccountType `form:"account_type" binding:"required"`
+	AccountID    string      `form:"account_id"`
+	AccountLabel string      `form:"account_label"`
+	State        string      `form:"state"`
+	Since        string      `form:"since"`
+	// Refresh bypasses the order cache
+	Refresh bool `form:"refresh"`
+}
+
+// DividendRequest holds the query parameters of the dividends endpoint. from
+// and to accept RFC 3339 times or YYYY-MM-DD dates; dividends payable in
+// [from, to) are listed. The period defaults to the year to date.
+type DividendRequest struct {
+	AccountType  AccountType `form:"account_type" binding:"required"`
+	AccountID    string      `form:"account_id"`
+	AccountLabel string      `form:"account_label"`
+	From         string      `form:"from"`
+	To           string      `form:"to"`
+	// Refresh bypasses the dividend cache
+	Refresh bool `form:"refresh"`
+}
+
+// StreamRequest holds the query parameters of the position stream endpoint
+type StreamRequest struct {
+	AccountType AccountType `form:"account_type" binding:"required"`
+	// AccountID or AccountLabel limit the stream to one account, it carries
+	// every account of the type by default or with "all"
+	AccountID    string `form:"account_id"`
+	AccountLabel string `form:"account_label"`
+	// MinMarketValue optionally excludes positions below this market value
+	MinMarketValue float64 `form:"min_market_value" binding:"gte=0"`
 }
 
+// WatchlistRequest holds the query parameters of the watchlist endpoints
+type WatchlistRequest struct {
+	AccountType AccountType `form:"account_type" binding:"required"`
+}
+
+// HealthRequest holds the query parameters of the health endpoint
+type HealthRequest struct {
+	// Deep runs the health checks, see Service.CheckHealth
+	Deep bool `form:"deep"`
+}
+
+// ErrorResponse is the body of every error response. Code is stable and
+// meant for clients to branch on, Message is for humans. RequestID is the
+// X-Request-ID the failure was logged under, for reporting it.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error codes of ErrorResponse
+const (
+	CodeInvalidRequest         = "invalid_request"
+	CodeUnsupportedAccountType = "unsupported_account_type"
+	CodeUnknownAccount         = "unknown_account"
+	CodeNotFound               = "not_found"
+	CodeUpstreamAuth           = "upstream_auth"
+	CodeUpstreamUnavailable    = "upstream_unavailable"
+	CodeNotConfigured          = "not_configured"
+	CodeInternal               = "internal"
+	CodeCanceled               = "canceled"
+	CodeTimeout                = "timeout"
+	CodeHistoryExpired         = "history_expired"
+	CodeOverloaded             = "overloaded"
+)
+
+// StatusClientClosedRequest is the non-standard status reported for requests
+// the client abandoned
+const StatusClientClosedRequest = 499
+
 // NewHandler creates a new position handler
-func NewHandler(service *Service) *Handler {
+func NewHandler(service PositionProvider) *Handler {
 	return &Handler{
-		service: service,
+		service:         service,
+		streamKeepAlive: DefaultStreamKeepAlive,
+		streamsDone:     make(chan struct{}),
 	}
 }
 
-// GetPositions handles requests to get positions
+// SetStreamKeepAlive sets how often an idle position stream sends a
+// keepalive comment
+func (h *Handler) SetStreamKeepAlive(interval time.Duration) {
+	h.streamKeepAlive = interval
+}
+
+// GetPositions handles POST requests to get positions
 func (h *Handler) GetPositions(c *gin.Context) {
 	var req PositionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBadRequest(c, err)
 		return
 	}
 
-	positions, err := h.service.GetPositions(req.AccountType)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	h.respondPositions(c, req)
+}
+
+// ListPositions handles GET requests to get positions using query parameters
+func (h *Handler) ListPositions(c *gin.Context) {
+	var req PositionRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	h.respondPositions(c, req)
+}
+
+// GetPosition handles GET requests for the positions of a single symbol
+func (h *Handler) GetPosition(c *gin.Context) {
+	var req PositionRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	positions, ok := h.fetchPositions(c, req)
+	if !ok {
+		return
+	}
+
+	symbol := c.Param("symbol")
+	positions = positions.FilterBySymbol(symbol)
+	if len(positions.Positions) == 0 {
+		writeError(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("no positions found for symbol: %s", symbol))
 		return
 	}
 
 	c.JSON(http.StatusOK, positions)
 }
+
+// respondPositions validates a bound request and writes the matching
+// positions, filtered and sorted as requested. The list options are checked
+// before fetching, so an invalid one never reaches the broker.
+func (h *Handler) respondPositions(c *gin.Context, req PositionRequest) {
+	var sortKey PositionSort
+	if req.Sort != "" {
+		var err error
+		if sortKey, err = ParsePositionSort(req.Sort); err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	descending := false
+	switch strings.ToLower(req.Order) {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid order %q, expected asc or desc", req.Order))
+		return
+	}
+	var assetClass AssetClass
+	if req.AssetType != "" {
+		var err error
+		if assetClass, err = ParseAssetClass(req.AssetType); err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	positions, ok := h.fetchPositions(c, req)
+	if !ok {
+		return
+	}
+
+	if assetClass != "" {
+		positions = positions.FilterByAssetClass(assetClass)
+	}
+	if req.Symbol != "" {
+		positions = positions.FilterBySymbolPrefix(req.Symbol)
+	}
+	if sortKey != "" {
+		positions = positions.SortBy(sortKey, descending)
+	}
+
+	c.JSON(http.StatusOK, positions)
+}
+
+// fetchPositions validates a bound request and returns the matching positions.
+// On failure the error response has already been written and ok is false.
+func (h *Handler) fetchPositions(c *gin.Context, req PositionRequest) (*PositionList, bool) {
+	// JSON bodies are validated while binding, query parameters are not
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return nil, false
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+
+	positions, err := h.service.QueryPositions(c.Request.Context(), PositionQuery{
+		AccountType: accountType,
+		Account:     account,
+		Refresh:     req.Refresh,
+	})
+	if !h.respondError(c, err) {
+		return nil, false
+	}
+
+	return positions.FilterByMinMarketValue(req.MinMarketValue), true
+}
+
+// ListSpreads handles GET /positions/spreads requests. It takes the same
+// query parameters as ListPositions; min_market_value applies to the
+// combined market value of each spread.
+func (h *Handler) ListSpreads(c *gin.Context) {
+	var req PositionRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+
+	spreads, err := h.service.Spreads(c.Request.Context(), PositionQuery{
+		AccountType: accountType,
+		Account:     account,
+		Refresh:     req.Refresh,
+	})
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, spreads.FilterByMinMarketValue(req.MinMarketValue))
+}
+
+// PositionsDelta handles GET /positions/delta requests with the positions
+// added, removed or changed since a time. When the time predates the
+// retained history it answers 410 Gone, and the client should fetch
+// /positions in full instead.
+func (h *Handler) PositionsDelta(c *gin.Context) {
+	var req DeltaRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+	since, err := time.Parse(time.RFC3339, req.Since)
+	if err != nil {
+		respondBadRequest(c, fmt.Errorf("invalid since %q, expected an RFC 3339 time", req.Since))
+		return
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+
+	delta, err := h.service.PositionsDelta(c.Request.Context(), PositionQuery{
+		AccountType: accountType,
+		Account:     account,
+		Refresh:     req.Refresh,
+	}, since)
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, delta)
+}
+
+// Exposure handles GET /exposure requests. It takes the same query
+// parameters as ListPositions and groups the positions by underlying.
+func (h *Handler) Exposure(c *gin.Context) {
+	var req PositionRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	positions, ok := h.fetchPositions(c, req)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, ExposureReport{
+		Exposures:   GroupExposure(positions.Positions),
+		AccountID:   positions.AccountID,
+		AccountType: positions.AccountType,
+		UpdatedAt:   positions.UpdatedAt,
+	})
+}
+
+// RealizedPnL handles GET /pnl/realized requests
+func (h *Handler) RealizedPnL(c *gin.Context) {
+	var req PnLRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	from, to, ok := parseDateRange(c, req.From, req.To)
+	if !ok {
+		return
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+
+	report, err := h.service.RealizedPnL(c.Request.Context(), PnLQuery{
+		AccountType: accountType,
+		Account:     account,
+		From:        from,
+		To:          to,
+		Refresh:     req.Refresh,
+	})
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListOrders handles GET /orders requests
+func (h *Handler) ListOrders(c *gin.Context) {
+	var req OrderRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	var since time.Time
+	if req.Since != "" {
+		if since, err = parseTime(req.Since); err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid since: %v", err))
+			return
+		}
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+
+	orders, err := h.service.ListOrders(c.Request.Context(), OrderQuery{
+		AccountType: accountType,
+		Account:     account,
+		State:       strings.ToLower(req.State),
+		Since:       since,
+		Refresh:     req.Refresh,
+	})
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+// Dividends handles GET /dividends requests
+func (h *Handler) Dividends(c *gin.Context) {
+	var req DividendRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	from, to, ok := parseDateRange(c, req.From, req.To)
+	if !ok {
+		return
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+
+	report, err := h.service.Dividends(c.Request.Context(), DividendQuery{
+		AccountType: accountType,
+		Account:     account,
+		From:        from,
+		To:          to,
+		Refresh:     req.Refresh,
+	})
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Watchlists handles GET /watchlists requests
+func (h *Handler) Watchlists(c *gin.Context) {
+	accountType, ok := bindWatchlistRequest(c)
+	if !ok {
+		return
+	}
+
+	watchlists, err := h.service.Watchlists(c.Request.Context(), accountType)
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, watchlists)
+}
+
+// GetWatchlist handles GET /watchlists/:name requests
+func (h *Handler) GetWatchlist(c *gin.Context) {
+	accountType, ok := bindWatchlistRequest(c)
+	if !ok {
+		return
+	}
+
+	watchlist, err := h.service.Watchlist(c.Request.Context(), accountType, c.Param("name"))
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, watchlist)
+}
+
+// SyncWatchlistToEngine handles POST /watchlists/:name/sync-to-engine
+// requests. It returns the watchlist as market streamer configuration, so
+// the watchlist stays the one source of truth for what is streamed.
+func (h *Handler) SyncWatchlistToEngine(c *gin.Context) {
+	accountType, ok := bindWatchlistRequest(c)
+	if !ok {
+		return
+	}
+
+	watchlist, err := h.service.Watchlist(c.Request.Context(), accountType, c.Param("name"))
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, NewEngineSymbols(watchlist))
+}
+
+// bindWatchlistRequest binds and validates the query parameters of the
+// watchlist endpoints. On failure the error response has already been
+// written and ok is false.
+func bindWatchlistRequest(c *gin.Context) (AccountType, bool) {
+	var req WatchlistRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return "", false
+	}
+
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return "", false
+	}
+	return accountType, true
+}
+
+// StreamPositions handles GET /positions/stream requests with Server-Sent
+// Events. A positions event carrying the PositionList of an account is sent
+// each time the background refresher updates it, so nothing is sent unless
+// the refresher runs. The lists are priced and filtered like GET /positions
+// responses. Keepalive comments hold the connection open while idle. The
+// stream ends when the client disconnects or CloseStreams is called.
+func (h *Handler) StreamPositions(c *gin.Context) {
+	var req StreamRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+	accountType, err := ParseAccountType(string(req.AccountType))
+	if err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	account := accountOf(req.AccountID, req.AccountLabel)
+	updates, cancel, err := h.service.SubscribePositions(accountType, account)
+	if !h.respondError(c, err) {
+		return
+	}
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	// Send the headers now, so the client knows it is subscribed
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(h.streamKeepAlive)
+	defer keepAlive.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.streamsDone:
+			return
+		case positions := <-updates:
+			c.SSEvent("positions", positions.FilterByMinMarketValue(req.MinMarketValue))
+		case <-keepAlive.C:
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// CloseStreams ends the open position streams and every stream opened
+// afterwards. Register it with http.Server.RegisterOnShutdown, the server
+// does not wait for them otherwise, as they never complete on their own.
+func (h *Handler) CloseStreams() {
+	h.closeStreams.Do(func() { close(h.streamsDone) })
+}
+
+// Health handles GET /health requests. The shallow check only reports the
+// refresh and broker request state and is always 200, so it stays cheap for
+// load balancers. With deep=true the health checks run too, and a failing
+// check turns the response into a 503.
+func (h *Handler) Health(c *gin.Context) {
+	var req HealthRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+
+	status := "up"
+	refreshes := h.service.RefreshStatus()
+	for _, refresh := range refreshes {
+		if !refresh.Healthy() {
+			status = "degraded"
+		}
+	}
+	breaker := h.service.CircuitBreakerStatus()
+	if breaker.State != BreakerClosed {
+		status = "degraded"
+	}
+	body := gin.H{
+		"status":            status,
+		"mock":              h.service.IsMock(),
+		"refresh":           refreshes,
+		"robinhood_retries": h.service.RetryCount(),
+		"rate_limit_wait":   h.service.RateLimitWait().String(),
+		"circuit_breaker":   breaker,
+	}
+	if !req.Deep {
+		c.JSON(http.StatusOK, body)
+		return
+	}
+
+	report := h.service.CheckHealth(c.Request.Context(), Robinhood)
+	body["status"] = report.Status
+	body["checks"] = report.Checks
+	if !report.Healthy() {
+		c.JSON(http.StatusServiceUnavailable, body)
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// respondError writes the error response for a failed service call and
+// reports whether the call succeeded. Upstream and internal failures are
+// logged and answered with a generic message, so broker responses are never
+// passed on to clients.
+func (h *Handler) respondError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	switch {
+	case errors.Is(err, ErrUnsupportedAccountType):
+		writeError(c, http.StatusBadRequest, CodeUnsupportedAccountType, err.Error())
+		return false
+	case errors.Is(err, ErrUnknownAccount):
+		writeError(c, http.StatusBadRequest, CodeUnknownAccount, err.Error())
+		return false
+	case errors.Is(err, ErrWatchlistNotFound):
+		writeError(c, http.StatusNotFound, CodeNotFound, err.Error())
+		return false
+	case errors.Is(err, ErrHistoryExpired):
+		writeError(c, http.StatusGone, CodeHistoryExpired, err.Error())
+		return false
+	case errors.Is(err, ErrOverloaded):
+		// Already logged by the load shedder
+		writeError(c, http.StatusServiceUnavailable, CodeOverloaded, "service overloaded, try again later")
+		return false
+	case errors.Is(err, ErrRequestCanceled):
+		// Nobody reads the response, the status only shows in access logs
+		writeError(c, StatusClientClosedRequest, CodeCanceled, "request canceled")
+		return false
+	}
+
+	h.service.Logger().ErrorContext(c.Request.Context(), "Request failed", "path", c.Request.URL.Path, "error", err)
+	switch {
+	case errors.Is(err, ErrRequestTimeout):
+		writeError(c, http.StatusGatewayTimeout, CodeTimeout, "request timed out")
+	case errors.Is(err, ErrUpstreamAuth):
+		// The caller is not at fault when the broker rejects our credentials
+		writeError(c, http.StatusBadGateway, CodeUpstreamAuth, "broker authentication failed")
+	case errors.Is(err, ErrUpstreamUnavailable):
+		writeError(c, http.StatusServiceUnavailable, CodeUpstreamUnavailable, "broker unavailable, try again later")
+	case errors.Is(err, ErrNotConfigured):
+		writeError(c, http.StatusInternalServerError, CodeNotConfigured, "service not configured")
+	default:
+		writeError(c, http.StatusInternalServerError, CodeInternal, "internal error")
+	}
+	return false
+}
+
+// respondBadRequest writes the error response for an invalid request
+func respondBadRequest(c *gin.Context, err error) {
+	code := CodeInvalidRequest
+	if errors.Is(err, ErrUnsupportedAccountType) {
+		code = CodeUnsupportedAccountType
+	}
+	writeError(c, http.StatusBadRequest, code, err.Error())
+}
+
+// writeError writes an error response
+func writeError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, ErrorResponse{Code: code, Message: message, RequestID: logging.RequestID(c.Request.Context())})
+}
+
+// accountOf returns the account selected by a request, by number or else
+// by label. Empty selects the default account.
+func accountOf(accountID, accountLabel string) string {
+	if accountID != "" {
+		return accountID
+	}
+	return accountLabel
+}
+
+// parseDateRange parses the from and to parameters of a report, each an
+// RFC 3339 time or a YYYY-MM-DD date. to defaults to now and from to the
+// start of the year of to. On failure the error response has already been
+// written and ok is false.
+func parseDateRange(c *gin.Context, fromValue, toValue string) (from, to time.Time, ok bool) {
+	var err error
+	to = time.Now().UTC()
+	if toValue != "" {
+		if to, err = parseTime(toValue); err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid to: %v", err))
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	from = time.Date(to.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	if fromValue != "" {
+		if from, err = parseTime(fromValue); err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid from: %v", err))
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	if !to.After(from) {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "from must be before to")
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// parseTime parses an RFC 3339 time or a YYYY-MM-DD date in UTC
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,209 @@
+package position_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/position-service/internal/position"
+	"github.com/trade-sonic/position-service/internal/testutil"
+)
+
+// serveFake runs a single request through the position routes backed by a fake provider
+func serveFake(fake *testutil.FakePositionProvider, method, target, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	h := position.NewHandler(fake)
+	r := gin.New()
+	r.GET("/positions", h.ListPositions)
+	r.GET("/positions/:symbol", h.GetPosition)
+	r.POST("/positions", h.GetPositions)
+	r.GET("/watchlists/:name", h.GetWatchlist)
+	r.GET("/health", h.Health)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// fakePositions is a position list of an AAPL call and an MSFT share
+func fakePositions() *position.PositionList {
+	return &position.PositionList{
+		AccountID:   "test-account",
+		AccountType: position.Robinhood,
+		UpdatedAt:   time.Date(2025, 3, 3, 15, 0, 0, 0, time.UTC),
+		Positions: []position.Position{
+			{ID: "1", Symbol: "AAPL", OccSymbol: "AAPL  250620C00200000", Quantity: 2, MarketValue: 600},
+			{ID: "2", Symbol: "MSFT", Quantity: 10, MarketValue: 4000},
+		},
+	}
+}
+
+func TestProviderHandler_Validation(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		expectedCode string
+	}{
+		{name: "missing account type", method: http.MethodGet, target: "/positions", expectedCode: position.CodeInvalidRequest},
+		{name: "unknown account type", method: http.MethodGet, target: "/positions?account_type=etrade", expectedCode: position.CodeUnsupportedAccountType},
+		{name: "invalid sort", method: http.MethodGet, target: "/positions?account_type=robinhood&sort=volume", expectedCode: position.CodeInvalidRequest},
+		{name: "invalid order", method: http.MethodGet, target: "/positions?account_type=robinhood&order=up", expectedCode: position.CodeInvalidRequest},
+		{name: "negative min market value", method: http.MethodPost, target: "/positions", body: `{"account_type":"robinhood","min_market_value":-1}`, expectedCode: position.CodeInvalidRequest},
+		{name: "malformed body", method: http.MethodPost, target: "/positions", body: `{`, expectedCode: position.CodeInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testutil.NewFakePositionProvider()
+			fake.PositionList = fakePositions()
+
+			w := serveFake(fake, tt.method, tt.target, tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+			var body position.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected an error response, got %v", err)
+			}
+			if body.Code != tt.expectedCode {
+				t.Errorf("Expected code %s, got %s", tt.expectedCode, body.Code)
+			}
+			// Invalid requests never reach the service
+			if len(fake.PositionQueries) != 0 {
+				t.Errorf("Expected no queries, got %v", fake.PositionQueries)
+			}
+		})
+	}
+}
+
+func TestProviderHandler_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{err: position.ErrUnknownAccount, expectedStatus: http.StatusBadRequest, expectedCode: position.CodeUnknownAccount},
+		{err: position.ErrUnsupportedAccountType, expectedStatus: http.StatusBadRequest, expectedCode: position.CodeUnsupportedAccountType},
+		{err: position.ErrHistoryExpired, expectedStatus: http.StatusGone, expectedCode: position.CodeHistoryExpired},
+		{err: position.ErrRequestCanceled, expectedStatus: position.StatusClientClosedRequest, expectedCode: position.CodeCanceled},
+		{err: position.ErrRequestTimeout, expectedStatus: http.StatusGatewayTimeout, expectedCode: position.CodeTimeout},
+		{err: position.ErrUnauthorized, expectedStatus: http.StatusBadGateway, expectedCode: position.CodeUpstreamAuth},
+		{err: position.ErrCircuitOpen, expectedStatus: http.StatusServiceUnavailable, expectedCode: position.CodeUpstreamUnavailable},
+		{err: position.ErrNotConfigured, expectedStatus: http.StatusInternalServerError, expectedCode: position.CodeNotConfigured},
+		{err: fmt.Errorf("unexpected broker response"), expectedStatus: http.StatusInternalServerError, expectedCode: position.CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			fake := testutil.NewFakePositionProvider()
+			fake.Err = fmt.Errorf("fetching positions: %w", tt.err)
+
+			w := serveFake(fake, http.MethodGet, "/positions?account_type=robinhood", "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			var body position.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected an error response, got %v", err)
+			}
+			if body.Code != tt.expectedCode {
+				t.Errorf("Expected code %s, got %s", tt.expectedCode, body.Code)
+			}
+			// Broker details are never passed on
+			if strings.Contains(body.Message, "unexpected broker response") {
+				t.Errorf("Expected a generic message, got %q", body.Message)
+			}
+		})
+	}
+}
+
+func TestProviderHandler_Positions(t *testing.T) {
+	fake := testutil.NewFakePositionProvider()
+	fake.PositionList = fakePositions()
+
+	w := serveFake(fake, http.MethodGet, "/positions?account_type=robinhood&account_label=ira&refresh=true&sort=market_value&order=desc&min_market_value=1000", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	expectedQuery := position.PositionQuery{AccountType: position.Robinhood, Account: "ira", Refresh: true}
+	if len(fake.PositionQueries) != 1 || fake.PositionQueries[0] != expectedQuery {
+		t.Fatalf("Expected query %+v, got %+v", expectedQuery, fake.PositionQueries)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body, got %v", err)
+	}
+	for _, field := range []string{"positions", "account_id", "account_type", "updated_at"} {
+		if _, ok := body[field]; !ok {
+			t.Errorf("Expected field %s in %v", field, body)
+		}
+	}
+	positions := body["positions"].([]interface{})
+	if len(positions) != 1 {
+		t.Fatalf("Expected 1 position above the min market value, got %d", len(positions))
+	}
+	if symbol := positions[0].(map[string]interface{})["symbol"]; symbol != "MSFT" {
+		t.Errorf("Expected MSFT, got %v", symbol)
+	}
+}
+
+func TestProviderHandler_PositionNotFound(t *testing.T) {
+	fake := testutil.NewFakePositionProvider()
+	fake.PositionList = fakePositions()
+
+	w := serveFake(fake, http.MethodGet, "/positions/TSLA?account_type=robinhood", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = serveFake(fake, http.MethodGet, "/positions/AAPL?account_type=robinhood", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestProviderHandler_WatchlistNotFound(t *testing.T) {
+	fake := testutil.NewFakePositionProvider()
+	fake.WatchlistList = &position.WatchlistList{
+		Watchlists: []position.Watchlist{{Name: "Tech", Symbols: []string{"AAPL"}}},
+	}
+
+	w := serveFake(fake, http.MethodGet, "/watchlists/tech?account_type=robinhood", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	w = serveFake(fake, http.MethodGet, "/watchlists/energy?account_type=robinhood", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestProviderHandler_HealthDegraded(t *testing.T) {
+	fake := testutil.NewFakePositionProvider()
+	fake.Breaker = position.BreakerStatus{State: position.BreakerOpen, ConsecutiveFailures: 5}
+
+	w := serveFake(fake, http.MethodGet, "/health", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body, got %v", err)
+	}
+	if body["status"] != "degraded" {
+		t.Errorf("Expected status degraded, got %v", body["status"])
+	}
+}
@@ -0,0 +1,462 @@
+package position
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newRouter returns a router with the position routes registered
+func newRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	r.GET("/positions", h.ListPositions)
+	r.GET("/positions/spreads", h.ListSpreads)
+	r.GET("/positions/delta", h.PositionsDelta)
+	r.GET("/positions/:symbol", h.GetPosition)
+	r.POST("/positions", h.GetPositions)
+	r.GET("/exposure", h.Exposure)
+	r.GET("/orders", h.ListOrders)
+	r.GET("/pnl/realized", h.RealizedPnL)
+	r.GET("/dividends", h.Dividends)
+	r.GET("/watchlists", h.Watchlists)
+	r.GET("/watchlists/:name", h.GetWatchlist)
+	r.POST("/watchlists/:name/sync-to-engine", h.SyncWatchlistToEngine)
+	r.GET("/health", h.Health)
+	return r
+}
+
+// performRequest runs a single request through a router with the position routes registered
+func performRequest(h *Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler_Positions(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+	}{
+		{name: "GET with account type", method: http.MethodGet, target: "/positions?account_type=robinhood&refresh=false", expectedStatus: http.StatusOK},
+		{name: "GET missing account type", method: http.MethodGet, target: "/positions", expectedStatus: http.StatusBadRequest},
+		{name: "GET unknown account type", method: http.MethodGet, target: "/positions?account_type=etrade", expectedStatus: http.StatusBadRequest},
+		{name: "POST with account type", method: http.MethodPost, target: "/positions", body: `{"account_type":"robinhood"}`, expectedStatus: http.StatusOK},
+		{name: "POST missing account type", method: http.MethodPost, target: "/positions", body: `{}`, expectedStatus: http.StatusBadRequest},
+		{name: "POST unknown account type", method: http.MethodPost, target: "/positions", body: `{"account_type":"etrade"}`, expectedStatus: http.StatusBadRequest},
+		{name: "GET case variant account type", method: http.MethodGet, target: "/positions?account_type=Robinhood", expectedStatus: http.StatusOK},
+		{name: "POST case variant account type", method: http.MethodPost, target: "/positions", body: `{"account_type":"ROBINHOOD"}`, expectedStatus: http.StatusOK},
+		{name: "GET primary account by label", method: http.MethodGet, target: "/positions?account_type=robinhood&account_label=primary", expectedStatus: http.StatusOK},
+		{name: "GET unknown account", method: http.MethodGet, target: "/positions?account_type=robinhood&account_id=999", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(newCachedService(Position{ID: "pos-1", Symbol: "AAPL", MarketValue: 100}))
+
+			w := performRequest(h, tt.method, tt.target, tt.body)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var positions PositionList
+			if err := json.Unmarshal(w.Body.Bytes(), &positions); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(positions.Positions) != 1 || positions.Positions[0].ID != "pos-1" {
+				t.Errorf("Expected cached position pos-1, got %+v", positions.Positions)
+			}
+		})
+	}
+}
+
+func TestHandler_PositionsSortAndFilter(t *testing.T) {
+	positions := []Position{
+		{ID: "aapl-call", Symbol: "AAPL", OptionType: Call, UnrealizedPnL: 50, UnrealizedPnLPercent: 10, MarketValue: 600},
+		{ID: "aapl-shares", Symbol: "AAPL", UnrealizedPnL: 50, UnrealizedPnLPercent: 5, MarketValue: 1900},
+		{ID: "amd-put", Symbol: "AMD", OptionType: Put, UnrealizedPnL: -20, UnrealizedPnLPercent: -20, MarketValue: 80},
+		{ID: "msft-shares", Symbol: "MSFT", UnrealizedPnL: 120, UnrealizedPnLPercent: 12, MarketValue: 1120},
+		{ID: "amzn-call", Symbol: "AMZN", OccSymbol: "AMZN  250620C00200000", UnrealizedPnL: 50, UnrealizedPnLPercent: 25, MarketValue: 250},
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedIDs    string
+		expectedError  string
+	}{
+		{name: "no options keep the cached order", target: "/positions?account_type=robinhood",
+			expectedIDs: "aapl-call,aapl-shares,amd-put,msft-shares,amzn-call"},
+		// The three positions tied at 50 keep their order both ways
+		{name: "pnl ascending", target: "/positions?account_type=robinhood&sort=pnl",
+			expectedIDs: "amd-put,aapl-call,aapl-shares,amzn-call,msft-shares"},
+		{name: "pnl descending", target: "/positions?account_type=robinhood&sort=pnl&order=desc",
+			expectedIDs: "msft-shares,aapl-call,aapl-shares,amzn-call,amd-put"},
+		{name: "options by market value", target: "/positions?account_type=robinhood&sort=market_value&order=DESC&asset_type=option",
+			expectedIDs: "aapl-call,amzn-call,amd-put"},
+		{name: "equities by pnl percent", target: "/positions?account_type=robinhood&asset_type=equity&sort=pnl_percent",
+			expectedIDs: "aapl-shares,msft-shares"},
+		{name: "symbol prefix by symbol", target: "/positions?account_type=robinhood&symbol=a&sort=symbol",
+			expectedIDs: "aapl-call,aapl-shares,amd-put,amzn-call"},
+		{name: "prefix, asset type and market value", target: "/positions?account_type=robinhood&symbol=AM&asset_type=option&min_market_value=100",
+			expectedIDs: "amzn-call"},
+		{name: "no crypto positions", target: "/positions?account_type=robinhood&asset_type=crypto"},
+		{name: "POST body", method: http.MethodPost, target: "/positions", body: `{"account_type":"robinhood","sort":"symbol","order":"desc"}`,
+			expectedIDs: "msft-shares,amzn-call,amd-put,aapl-call,aapl-shares"},
+		{name: "invalid sort", target: "/positions?account_type=robinhood&sort=volume", expectedStatus: http.StatusBadRequest,
+			expectedError: "pnl, pnl_percent, market_value, symbol"},
+		{name: "invalid order", target: "/positions?account_type=robinhood&sort=pnl&order=up", expectedStatus: http.StatusBadRequest,
+			expectedError: "asc or desc"},
+		{name: "invalid asset type", target: "/positions?account_type=robinhood&asset_type=bond", expectedStatus: http.StatusBadRequest,
+			expectedError: "option, equity, crypto"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, expectedStatus := tt.method, tt.expectedStatus
+			if method == "" {
+				method = http.MethodGet
+			}
+			if expectedStatus == 0 {
+				expectedStatus = http.StatusOK
+			}
+			h := NewHandler(newCachedService(positions...))
+
+			w := performRequest(h, method, tt.target, tt.body)
+			if w.Code != expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", expectedStatus, w.Code, w.Body.String())
+			}
+			if expectedStatus != http.StatusOK {
+				var body ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				if body.Code != CodeInvalidRequest || !strings.Contains(body.Message, tt.expectedError) {
+					t.Errorf("Expected an invalid request listing %q, got %+v", tt.expectedError, body)
+				}
+				return
+			}
+
+			var list PositionList
+			if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := make([]string, 0, len(list.Positions))
+			for _, p := range list.Positions {
+				ids = append(ids, p.ID)
+			}
+			if got := strings.Join(ids, ","); got != tt.expectedIDs {
+				t.Errorf("Expected %s, got %s", tt.expectedIDs, got)
+			}
+		})
+	}
+}
+
+func TestHandler_GetPosition(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{name: "hit", target: "/positions/msft?account_type=robinhood", expectedStatus: http.StatusOK, expectedIDs: []string{"msft-call"}},
+		{name: "multiple contracts", target: "/positions/AAPL?account_type=robinhood", expectedStatus: http.StatusOK, expectedIDs: []string{"aapl-call", "aapl-put"}},
+		{name: "miss", target: "/positions/TSLA?account_type=robinhood", expectedStatus: http.StatusNotFound},
+		{name: "missing account type", target: "/positions/AAPL", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(newCachedService(
+				Position{ID: "aapl-call", Symbol: "AAPL"},
+				Position{ID: "msft-call", Symbol: "MSFT"},
+				Position{ID: "aapl-put", Symbol: "AAPL"},
+			))
+
+			w := performRequest(h, http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var positions PositionList
+			if err := json.Unmarshal(w.Body.Bytes(), &positions); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(positions.Positions) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d positions, got %d", len(tt.expectedIDs), len(positions.Positions))
+			}
+			for i, id := range tt.expectedIDs {
+				if positions.Positions[i].ID != id {
+					t.Errorf("Expected position %d to be %s, got %s", i, id, positions.Positions[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_BrokerRejectsToken(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "expired-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	w := performRequest(NewHandler(s), http.MethodGet, "/positions?account_type=robinhood", "")
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusBadGateway, w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_RealizedPnL(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedPnL    float64
+	}{
+		{name: "period", target: "/pnl/realized?account_type=robinhood&from=2025-01-01&to=2025-04-01", expectedStatus: http.StatusOK, expectedPnL: 399.72},
+		{name: "RFC 3339 period", target: "/pnl/realized?account_type=robinhood&from=2025-03-06T00:00:00Z&to=2025-04-01T00:00:00Z", expectedStatus: http.StatusOK, expectedPnL: 199.84},
+		{name: "nothing closed", target: "/pnl/realized?account_type=robinhood&from=2024-01-01&to=2024-12-31", expectedStatus: http.StatusOK},
+		{name: "missing account type", target: "/pnl/realized?from=2025-01-01", expectedStatus: http.StatusBadRequest},
+		{name: "invalid from", target: "/pnl/realized?account_type=robinhood&from=yesterday", expectedStatus: http.StatusBadRequest},
+		{name: "from after to", target: "/pnl/realized?account_type=robinhood&from=2025-04-01&to=2025-01-01", expectedStatus: http.StatusBadRequest},
+		{name: "unknown account", target: "/pnl/realized?account_type=robinhood&account_id=999", expectedStatus: http.StatusBadRequest},
+	}
+
+	srv := newFixtureServer(t, orderFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	h := NewHandler(s)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(h, http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var report RealizedPnLReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if !almostEqual(report.RealizedPnL, tt.expectedPnL) {
+				t.Errorf("Expected realized P&L %v, got %v", tt.expectedPnL, report.RealizedPnL)
+			}
+		})
+	}
+}
+
+func TestHandler_ListOrders(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{
+			name:           "all orders newest first",
+			target:         "/orders?account_type=robinhood",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"opt-tsla-put-open", "opt-spread-close", "eq-aapl-sell", "eq-aapl-cancelled", "opt-spread-open", "eq-aapl-buy-2", "eq-msft-sell", "eq-aapl-buy-1", "eq-msft-buy"},
+		},
+		{
+			name:           "filled since",
+			target:         "/orders?account_type=robinhood&state=FILLED&since=2025-03-01",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"opt-spread-close", "eq-aapl-sell"},
+		},
+		{
+			name:           "state",
+			target:         "/orders?account_type=robinhood&state=cancelled",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"opt-tsla-put-open", "eq-aapl-cancelled"},
+		},
+		{name: "missing account type", target: "/orders", expectedStatus: http.StatusBadRequest},
+		{name: "invalid since", target: "/orders?account_type=robinhood&since=last-week", expectedStatus: http.StatusBadRequest},
+		{name: "unknown account", target: "/orders?account_type=robinhood&account_label=ira", expectedStatus: http.StatusBadRequest},
+	}
+
+	srv := newFixtureServer(t, orderFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	h := NewHandler(s)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(h, http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var orders OrderList
+			if err := json.Unmarshal(w.Body.Bytes(), &orders); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(orders.Orders) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d orders, got %d", len(tt.expectedIDs), len(orders.Orders))
+			}
+			for i, id := range tt.expectedIDs {
+				if orders.Orders[i].ID != id {
+					t.Errorf("Expected order %d to be %s, got %s", i, id, orders.Orders[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_ErrorMapping(t *testing.T) {
+	// A broker answering every request with status and a body that must not reach clients
+	broker := func(status int) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"detail":"raw robinhood body"}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+
+	tests := []struct {
+		name           string
+		service        func() *Service
+		target         string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "unsupported account type",
+			service:        func() *Service { return newCachedService() },
+			target:         "/positions?account_type=etrade",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeUnsupportedAccountType,
+		},
+		{
+			name:           "unknown account",
+			service:        func() *Service { return newCachedService() },
+			target:         "/positions?account_type=robinhood&account_id=999",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeUnknownAccount,
+		},
+		{
+			name: "token service failure",
+			service: func() *Service {
+				return NewService(&stubTokenService{err: errors.New("token service down")}, "test-account")
+			},
+			target:         "/positions?account_type=robinhood",
+			expectedStatus: http.StatusBadGateway,
+			expectedCode:   CodeUpstreamAuth,
+		},
+		{
+			name: "broker rejects token",
+			service: func() *Service {
+				s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+				s.baseURL = broker(http.StatusUnauthorized)
+				return s
+			},
+			target:         "/orders?account_type=robinhood",
+			expectedStatus: http.StatusBadGateway,
+			expectedCode:   CodeUpstreamAuth,
+		},
+		{
+			name: "broker unavailable",
+			service: func() *Service {
+				s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+				s.baseURL = broker(http.StatusServiceUnavailable)
+				s.SetRetryPolicy(fastRetryPolicy)
+				return s
+			},
+			target:         "/positions?account_type=robinhood",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   CodeUpstreamUnavailable,
+		},
+		{
+			name: "no account configured",
+			service: func() *Service {
+				return NewService(&stubTokenService{token: "test-token"}, "")
+			},
+			target:         "/pnl/realized?account_type=robinhood",
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   CodeNotConfigured,
+		},
+		{
+			name: "unexpected broker response",
+			service: func() *Service {
+				s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+				s.baseURL = broker(http.StatusNotFound)
+				return s
+			},
+			target:         "/positions?account_type=robinhood",
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   CodeInternal,
+		},
+		{
+			name:           "invalid request",
+			service:        func() *Service { return newCachedService() },
+			target:         "/pnl/realized?account_type=robinhood&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeInvalidRequest,
+		},
+		{
+			name:           "symbol not found",
+			service:        func() *Service { return newCachedService() },
+			target:         "/positions/TSLA?account_type=robinhood",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   CodeNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.service()
+			s.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			w := performRequest(NewHandler(s), http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Code != tt.expectedCode {
+				t.Errorf("Expected code %s, got %s", tt.expectedCode, resp.Code)
+			}
+			if resp.Message == "" {
+				t.Error("Expected an error message")
+			}
+			if strings.Contains(w.Body.String(), "raw robinhood body") {
+				t.Errorf("Expected the broker response not to be leaked, got %s", w.Body.String())
+			}
+		})
+	}
+}
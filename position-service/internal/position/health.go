@@ -0,0 +1,220 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Health check defaults, see SetHealthThresholds
+const (
+	// DefaultTokenCheckTTL is how long a token check result is reused, so
+	// frequent probes do not turn into token requests
+	DefaultTokenCheckTTL = 30 * time.Second
+	// DefaultMaxFetchAge is how old the last successful position fetch may be
+	DefaultMaxFetchAge = 15 * time.Minute
+)
+
+// tokenCheckTimeout bounds a token check, retries included
+const tokenCheckTimeout = 5 * time.Second
+
+// refreshStallFactor scales the longest backoff interval to how long the
+// refresher may go without finishing a round before it counts as stalled
+const refreshStallFactor = 2
+
+// HealthStatus is the outcome of a health check
+type HealthStatus string
+
+const (
+	// HealthUp means the check passed
+	HealthUp HealthStatus = "up"
+	// HealthDown means the check failed
+	HealthDown HealthStatus = "down"
+)
+
+// HealthCheck is the result of a single deep health check
+type HealthCheck struct {
+	Status    HealthStatus `json:"status"`
+	Message   string       `json:"message,omitempty"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// HealthReport is the result of a deep health check, keyed by check name.
+// Checks that do not apply, e.g. the token service in mock mode, are left out.
+type HealthReport struct {
+	Status HealthStatus           `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// Healthy reports whether every check passed
+func (r HealthReport) Healthy() bool {
+	return r.Status == HealthUp
+}
+
+// SetHealthThresholds sets how long a token check result is reused and how
+// old the last successful position fetch may be before deep health checks
+// fail. Zero values keep the defaults.
+func (s *Service) SetHealthThresholds(tokenCheckTTL, maxFetchAge time.Duration) {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	if tokenCheckTTL > 0 {
+		s.tokenCheckTTL = tokenCheckTTL
+	}
+	if maxFetchAge > 0 {
+		s.maxFetchAge = maxFetchAge
+	}
+}
+
+// CheckHealth runs the deep health checks: the token service returns a token
+// for every login the accounts of the account type use, positions were fetched recently and, when started,
+// the background refresher is still running. The token check only asks the
+// token service, which answers from its own cache, and its result is reused
+// for the token check TTL.
+func (s *Service) CheckHealth(ctx context.Context, accountType AccountType) HealthReport {
+	report := HealthReport{
+		Status: HealthUp,
+		Checks: make(map[string]HealthCheck),
+	}
+
+	if s.tokenService != nil {
+		report.Checks["token_service"] = s.checkToken(ctx, accountType)
+	}
+	report.Checks["position_fetch"] = s.checkFetchAge()
+	if s.mock == nil {
+		report.Checks["circuit_breaker"] = s.checkCircuitBreaker()
+	}
+	if check, ok := s.checkRefresher(); ok {
+		report.Checks["refresher"] = check
+	}
+
+	for _, check := range report.Checks {
+		if check.Status != HealthUp {
+			report.Status = HealthDown
+		}
+	}
+	return report
+}
+
+// checkToken returns the cached token check result, requesting a token for
+// each token service account the registered accounts log in with when it
+// expired
+func (s *Service) checkToken(ctx context.Context, accountType AccountType) HealthCheck {
+	// Concurrent probes wait for a single token request
+	s.tokenCheckMutex.Lock()
+	defer s.tokenCheckMutex.Unlock()
+
+	s.healthMutex.Lock()
+	ttl := s.tokenCheckTTL
+	s.healthMutex.Unlock()
+
+	if s.tokenCheck != nil && time.Since(s.tokenCheck.CheckedAt) < ttl {
+		return *s.tokenCheck
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, tokenCheckTimeout)
+	defer cancel()
+
+	check := HealthCheck{Status: HealthUp, CheckedAt: time.Now()}
+	for _, label := range s.tokenLabels() {
+		name := string(accountType)
+		if label != "" {
+			name = fmt.Sprintf("%s %s", accountType, label)
+		}
+		token, err := s.tokenService.GetToken(checkCtx, accountType, label)
+		switch {
+		case err != nil:
+			check.Status = HealthDown
+			check.Message = fmt.Sprintf("failed to get %s token: %v", name, err)
+		case token == "":
+			check.Status = HealthDown
+			check.Message = fmt.Sprintf("token service returned an empty %s token", name)
+		}
+		if check.Status != HealthUp {
+			break
+		}
+	}
+
+	// A probe abandoned by its caller says nothing about the token service
+	if ctx.Err() == nil {
+		s.tokenCheck = &check
+	}
+	return check
+}
+
+// tokenLabels returns the distinct token service labels of the registered
+// accounts, the default login's "" when there are none
+func (s *Service) tokenLabels() []string {
+	accounts := s.Accounts()
+	if len(accounts) == 0 {
+		return []string{""}
+	}
+	seen := make(map[string]bool)
+	var labels []string
+	for _, account := range accounts {
+		if !seen[account.TokenLabel] {
+			seen[account.TokenLabel] = true
+			labels = append(labels, account.TokenLabel)
+		}
+	}
+	return labels
+}
+
+// checkFetchAge checks the age of the last successful position fetch
+func (s *Service) checkFetchAge() HealthCheck {
+	s.healthMutex.Lock()
+	lastFetch, maxAge := s.lastFetch, s.maxFetchAge
+	s.healthMutex.Unlock()
+
+	now := time.Now()
+	check := HealthCheck{Status: HealthUp, CheckedAt: now}
+	if lastFetch.IsZero() {
+		check.Status = HealthDown
+		check.Message = "no successful position fetch yet"
+		return check
+	}
+
+	age := now.Sub(lastFetch).Round(time.Second)
+	check.Message = fmt.Sprintf("last successful fetch %s ago", age)
+	if age > maxAge {
+		check.Status = HealthDown
+		check.Message += fmt.Sprintf(", more than %s", maxAge)
+	}
+	return check
+}
+
+// checkRefresher checks that the background refresher, if started, is still
+// running and finishing refresh rounds
+func (s *Service) checkRefresher() (HealthCheck, bool) {
+	if s.refreshDone == nil {
+		return HealthCheck{}, false
+	}
+
+	now := time.Now()
+	check := HealthCheck{Status: HealthUp, CheckedAt: now}
+	select {
+	case <-s.refreshDone:
+		check.Status = HealthDown
+		check.Message = "refresher stopped"
+		return check, true
+	default:
+	}
+
+	s.statusMutex.RLock()
+	lastRound, interval := s.refreshLastRound, s.refreshInterval
+	s.statusMutex.RUnlock()
+
+	since := now.Sub(lastRound).Round(time.Second)
+	check.Message = fmt.Sprintf("last refresh round %s ago", since)
+	if stall := interval * maxRefreshBackoffFactor * refreshStallFactor; since > stall {
+		check.Status = HealthDown
+		check.Message += fmt.Sprintf(", stalled for more than %s", stall)
+	}
+	return check, true
+}
+
+// recordFetch records a successful position fetch for the health checks
+func (s *Service) recordFetch() {
+	s.healthMutex.Lock()
+	s.lastFetch = time.Now()
+	s.healthMutex.Unlock()
+}
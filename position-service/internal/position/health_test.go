@@ -0,0 +1,258 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStubTokenServer runs a token service that issues a token while healthy
+// is set and fails otherwise
+func newStubTokenServer(t *testing.T, healthy *atomic.Bool) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if !healthy.Load() {
+			http.Error(w, `{"error":"login failed"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"test-token"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// newHealthService returns a service backed by a stub token service, with a
+// recent position fetch recorded
+func newHealthService(t *testing.T, healthy *atomic.Bool) (*Service, *atomic.Int32) {
+	srv, hits := newStubTokenServer(t, healthy)
+	tokenClient := NewTokenClient(srv.URL)
+	tokenClient.SetRetryPolicy(fastRetryPolicy)
+
+	s := NewService(tokenClient, "test-account")
+	s.recordFetch()
+	return s, hits
+}
+
+func TestCheckHealth_TokenService(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	s, hits := newHealthService(t, &healthy)
+
+	report := s.CheckHealth(context.Background(), Robinhood)
+	if !report.Healthy() || report.Checks["token_service"].Status != HealthUp {
+		t.Fatalf("Expected a healthy report, got %+v", report)
+	}
+
+	// The result is reused until the TTL expires, even once the token
+	// service fails
+	healthy.Store(false)
+	report = s.CheckHealth(context.Background(), Robinhood)
+	if !report.Healthy() || hits.Load() != 1 {
+		t.Errorf("Expected the cached result without a token request, got %+v after %d requests", report, hits.Load())
+	}
+
+	s.tokenCheck.CheckedAt = time.Now().Add(-DefaultTokenCheckTTL)
+	report = s.CheckHealth(context.Background(), Robinhood)
+	check := report.Checks["token_service"]
+	if report.Healthy() || check.Status != HealthDown || check.Message == "" {
+		t.Errorf("Expected a failed token check, got %+v", report)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("Expected 2 token requests, got %d", hits.Load())
+	}
+	// The other checks still pass
+	if report.Checks["position_fetch"].Status != HealthUp {
+		t.Errorf("Expected the position fetch check to pass, got %+v", report.Checks["position_fetch"])
+	}
+}
+
+func TestCheckHealth_TokenLabels(t *testing.T) {
+	tokens := &stubTokenService{token: "test-token"}
+	s := NewService(tokens, "test-account")
+	s.recordFetch()
+	s.AddAccount("ira", "ira-account")
+	s.AddAccountWithLogin("joint", "joint-account", "joint")
+
+	report := s.CheckHealth(context.Background(), Robinhood)
+	if !report.Healthy() {
+		t.Fatalf("Expected a healthy report, got %+v", report)
+	}
+	expected := []string{"", "joint"}
+	if !reflect.DeepEqual(tokens.labels, expected) {
+		t.Errorf("Expected tokens for labels %q, got %q", expected, tokens.labels)
+	}
+
+	// A login that fails only for one account fails the check
+	failing := &labelTokenService{failing: "joint"}
+	s = NewService(failing, "test-account")
+	s.recordFetch()
+	s.AddAccountWithLogin("joint", "joint-account", "joint")
+	check := s.CheckHealth(context.Background(), Robinhood).Checks["token_service"]
+	if check.Status != HealthDown || !strings.Contains(check.Message, "joint") {
+		t.Errorf("Expected the joint token check to fail, got %+v", check)
+	}
+}
+
+// labelTokenService fails token requests for one label
+type labelTokenService struct {
+	failing string
+}
+
+func (s *labelTokenService) GetToken(ctx context.Context, accountType AccountType, label string) (string, error) {
+	if label == s.failing {
+		return "", errors.New("login failed")
+	}
+	return "test-token", nil
+}
+
+func (s *labelTokenService) RefreshToken(ctx context.Context, accountType AccountType, label string) (string, error) {
+	return s.GetToken(ctx, accountType, label)
+}
+
+func TestCheckHealth_CanceledProbeNotCached(t *testing.T) {
+	s := NewService(&stubTokenService{err: context.Canceled}, "test-account")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.CheckHealth(ctx, Robinhood)
+	if s.tokenCheck != nil {
+		t.Errorf("Expected an abandoned probe not to be cached, got %+v", s.tokenCheck)
+	}
+}
+
+func TestCheckHealth_FetchAge(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+
+	tests := []struct {
+		name      string
+		lastFetch time.Time
+		expected  HealthStatus
+	}{
+		{name: "never fetched", expected: HealthDown},
+		{name: "recent fetch", lastFetch: time.Now().Add(-time.Minute), expected: HealthUp},
+		{name: "stale fetch", lastFetch: time.Now().Add(-DefaultMaxFetchAge - time.Minute), expected: HealthDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.lastFetch = tt.lastFetch
+			report := s.CheckHealth(context.Background(), Robinhood)
+			if check := report.Checks["position_fetch"]; check.Status != tt.expected {
+				t.Errorf("Expected %s, got %+v", tt.expected, check)
+			}
+			if report.Status != tt.expected {
+				t.Errorf("Expected overall status %s, got %s", tt.expected, report.Status)
+			}
+		})
+	}
+
+	// The threshold is configurable
+	s.lastFetch = time.Now().Add(-time.Minute)
+	s.SetHealthThresholds(0, 30*time.Second)
+	if report := s.CheckHealth(context.Background(), Robinhood); report.Healthy() {
+		t.Errorf("Expected a fetch older than the threshold to fail, got %+v", report)
+	}
+}
+
+func TestCheckHealth_Refresher(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	if _, ok := s.CheckHealth(context.Background(), Robinhood).Checks["refresher"]; ok {
+		t.Error("Expected no refresher check before the refresher is started")
+	}
+
+	s.StartRefresher(Robinhood, time.Hour)
+	defer s.Stop()
+	waitForStatus(t, s, func(statuses []RefreshStatus) bool {
+		return len(statuses) == 1 && !statuses[0].LastSuccess.IsZero()
+	})
+
+	report := s.CheckHealth(context.Background(), Robinhood)
+	if !report.Healthy() || report.Checks["refresher"].Status != HealthUp {
+		t.Fatalf("Expected a healthy report, got %+v", report)
+	}
+
+	// A refresher that stopped finishing rounds is stalled
+	s.statusMutex.Lock()
+	s.refreshLastRound = time.Now().Add(-100 * time.Hour)
+	s.statusMutex.Unlock()
+	if check := s.CheckHealth(context.Background(), Robinhood).Checks["refresher"]; check.Status != HealthDown {
+		t.Errorf("Expected a stalled refresher, got %+v", check)
+	}
+
+	s.Stop()
+	if check := s.CheckHealth(context.Background(), Robinhood).Checks["refresher"]; check.Status != HealthDown || check.Message != "refresher stopped" {
+		t.Errorf("Expected a stopped refresher, got %+v", check)
+	}
+}
+
+func TestHandler_Health(t *testing.T) {
+	tests := []struct {
+		name           string
+		healthy        bool
+		target         string
+		expectedStatus int
+		expectedBody   string
+		expectedChecks bool
+	}{
+		{name: "shallow with token service up", healthy: true, target: "/health", expectedStatus: http.StatusOK, expectedBody: "up"},
+		{name: "shallow with token service down", healthy: false, target: "/health", expectedStatus: http.StatusOK, expectedBody: "up"},
+		{name: "deep with token service up", healthy: true, target: "/health?deep=true", expectedStatus: http.StatusOK, expectedBody: "up", expectedChecks: true},
+		{name: "deep with token service down", healthy: false, target: "/health?deep=true", expectedStatus: http.StatusServiceUnavailable, expectedBody: "down", expectedChecks: true},
+		{name: "invalid deep", healthy: true, target: "/health?deep=maybe", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var healthy atomic.Bool
+			healthy.Store(tt.healthy)
+			s, hits := newHealthService(t, &healthy)
+
+			w := performRequest(NewHandler(s), http.MethodGet, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus == http.StatusBadRequest {
+				return
+			}
+
+			var body struct {
+				Status string                 `json:"status"`
+				Checks map[string]HealthCheck `json:"checks"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected a JSON body, got %v", err)
+			}
+			if body.Status != tt.expectedBody {
+				t.Errorf("Expected status %q, got %q", tt.expectedBody, body.Status)
+			}
+
+			if !tt.expectedChecks {
+				// Shallow checks never reach the token service
+				if body.Checks != nil || hits.Load() != 0 {
+					t.Errorf("Expected no checks, got %+v after %d token requests", body.Checks, hits.Load())
+				}
+				return
+			}
+			for _, name := range []string{"token_service", "position_fetch"} {
+				if _, ok := body.Checks[name]; !ok {
+					t.Errorf("Expected a %s check, got %+v", name, body.Checks)
+				}
+			}
+			if up := body.Checks["token_service"].Status == HealthUp; up != tt.healthy {
+				t.Errorf("Expected token service up %v, got %+v", tt.healthy, body.Checks["token_service"])
+			}
+		})
+	}
+}
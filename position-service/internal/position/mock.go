@@ -0,0 +1,145 @@
+package position
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultMockPositions is the sample fixture served in mock mode when no
+// file is given
+//
+//go:embed mock_positions.json
+var defaultMockPositions []byte
+
+// mockBroker serves positions from a local fixture instead of the broker, so
+// the service runs without a Robinhood session or the token service
+type mockBroker struct {
+	mu        sync.Mutex
+	lists     map[string]*PositionList // Keyed by account ID
+	priceWalk float64
+	rng       *rand.Rand
+}
+
+// NewMockService creates a position service in mock mode. Positions are read
+// from a JSON file with the schema of the GET /positions response; a merged
+// response with accounts registers every listed account, the first as the
+// primary. When priceWalk is positive, each fetch moves every current price
+// by a random step of up to that fraction, e.g. 0.01 for 1%. The service has
+// no order history, so orders and realized P&L are empty. An empty path
+// serves a built-in sample fixture.
+func NewMockService(path string, priceWalk float64) (*Service, error) {
+	if priceWalk < 0 || priceWalk >= 1 {
+		return nil, fmt.Errorf("price walk must be in [0, 1), got %v", priceWalk)
+	}
+
+	data := defaultMockPositions
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("error reading mock positions: %w", err)
+		}
+	}
+	var fixture PositionList
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("error decoding mock positions %s: %w", path, err)
+	}
+
+	lists := fixture.Accounts
+	if len(lists) == 0 {
+		lists = []*PositionList{&fixture}
+	}
+
+	mock := &mockBroker{
+		lists:     make(map[string]*PositionList),
+		priceWalk: priceWalk,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	s := NewService(nil, "")
+	s.mock = mock
+	for _, list := range lists {
+		if list.AccountID == "" {
+			return nil, fmt.Errorf("mock positions %s: account_id is required", path)
+		}
+		label := list.AccountLabel
+		if len(mock.lists) == 0 {
+			label = PrimaryAccountLabel
+		} else if label == "" {
+			label = list.AccountID
+		}
+		for i := range list.Positions {
+			if list.Positions[i].OptionSymbol == "" {
+				list.Positions[i].OptionSymbol = CompactOCCSymbol(list.Positions[i].OccSymbol)
+			}
+		}
+		s.AddAccount(label, list.AccountID)
+		mock.lists[list.AccountID] = list
+	}
+	return s, nil
+}
+
+// IsMock reports whether the service serves positions from a mock fixture
+func (s *Service) IsMock() bool {
+	return s.mock != nil
+}
+
+// positions returns a copy of the fixture positions of an account, applying
+// a step of the price walk first
+func (m *mockBroker) positions(accountType AccountType, account Account) (*PositionList, error) {
+	if accountType != Robinhood {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list, exists := m.lists[account.ID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccount, account.ID)
+	}
+
+	if m.priceWalk > 0 {
+		for i := range list.Positions {
+			walkPrice(&list.Positions[i], 1+m.priceWalk*(2*m.rng.Float64()-1))
+		}
+	}
+
+	positions := &PositionList{
+		Positions:   append([]Position{}, list.Positions...),
+		AccountID:   account.ID,
+		AccountType: Robinhood,
+		UpdatedAt:   time.Now(),
+	}
+	for i := range positions.Positions {
+		positions.Positions[i].AccountID = account.ID
+	}
+	return positions, nil
+}
+
+// walkPrice scales the current price of a position and recomputes its
+// market value and unrealized P&L
+func walkPrice(position *Position, factor float64) {
+	setCurrentPrice(position, position.CurrentPrice*factor)
+}
+
+// setCurrentPrice sets the current price of a position and recomputes its
+// market value and unrealized P&L
+func setCurrentPrice(position *Position, price float64) {
+	multiplier := position.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	position.CurrentPrice = price
+	position.MarketValue = position.Quantity * position.CurrentPrice * multiplier
+	position.UnrealizedPnL = position.MarketValue - position.CostBasis
+	position.UnrealizedPnLPercent = 0
+	if position.CostBasis != 0 {
+		position.UnrealizedPnLPercent = position.UnrealizedPnL / math.Abs(position.CostBasis) * 100
+	}
+}
@@ -0,0 +1,198 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mockFixture is the sample fixture served in mock mode
+const mockFixture = "mock_positions.json"
+
+func TestMockService_BuiltInFixture(t *testing.T) {
+	s, err := NewMockService("", 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	list, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(list.Positions) == 0 {
+		t.Error("Expected the built-in fixture positions")
+	}
+}
+
+func TestMockService_Endpoints(t *testing.T) {
+	s, err := NewMockService(mockFixture, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !s.IsMock() {
+		t.Fatal("Expected a mock service")
+	}
+	h := NewHandler(s)
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{name: "GET positions", method: http.MethodGet, target: "/positions?account_type=robinhood", expectedStatus: http.StatusOK, expectedIDs: []string{"mock-aapl-call", "mock-tsla-put", "mock-msft"}},
+		{name: "POST positions", method: http.MethodPost, target: "/positions", body: `{"account_type":"robinhood","refresh":true}`, expectedStatus: http.StatusOK, expectedIDs: []string{"mock-aapl-call", "mock-tsla-put", "mock-msft"}},
+		{name: "position by symbol", method: http.MethodGet, target: "/positions/tsla?account_type=robinhood", expectedStatus: http.StatusOK, expectedIDs: []string{"mock-tsla-put"}},
+		{name: "position miss", method: http.MethodGet, target: "/positions/NVDA?account_type=robinhood", expectedStatus: http.StatusNotFound},
+		{name: "primary account by label", method: http.MethodGet, target: "/positions?account_type=robinhood&account_label=primary", expectedStatus: http.StatusOK, expectedIDs: []string{"mock-aapl-call", "mock-tsla-put", "mock-msft"}},
+		{name: "unknown account", method: http.MethodGet, target: "/positions?account_type=robinhood&account_id=999", expectedStatus: http.StatusBadRequest},
+		{name: "missing account type", method: http.MethodGet, target: "/positions", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(h, tt.method, tt.target, tt.body)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var positions PositionList
+			if err := json.Unmarshal(w.Body.Bytes(), &positions); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if positions.AccountID != "mock-account" {
+				t.Errorf("Expected account mock-account, got %s", positions.AccountID)
+			}
+			if len(positions.Positions) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d positions, got %d", len(tt.expectedIDs), len(positions.Positions))
+			}
+			for i, id := range tt.expectedIDs {
+				if positions.Positions[i].ID != id {
+					t.Errorf("Expected position %d to be %s, got %s", i, id, positions.Positions[i].ID)
+				}
+			}
+		})
+	}
+
+	// There is no order history in mock mode
+	t.Run("orders", func(t *testing.T) {
+		w := performRequest(h, http.MethodGet, "/orders?account_type=robinhood", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var orders OrderList
+		if err := json.Unmarshal(w.Body.Bytes(), &orders); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(orders.Orders) != 0 {
+			t.Errorf("Expected no orders, got %d", len(orders.Orders))
+		}
+	})
+
+	t.Run("realized P&L", func(t *testing.T) {
+		w := performRequest(h, http.MethodGet, "/pnl/realized?account_type=robinhood&from=2025-01-01", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var report RealizedPnLReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if report.RealizedPnL != 0 || len(report.Symbols) != 0 {
+			t.Errorf("Expected an empty report, got %+v", report)
+		}
+	})
+}
+
+func TestMockService_PriceWalk(t *testing.T) {
+	s, err := NewMockService(mockFixture, 0.05)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	h := NewHandler(s)
+
+	price := 400.0 // MSFT in the fixture
+	for i := 0; i < 20; i++ {
+		w := performRequest(h, http.MethodGet, "/positions/MSFT?account_type=robinhood&refresh=true", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var positions PositionList
+		if err := json.Unmarshal(w.Body.Bytes(), &positions); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		// Each fetch moves the price by at most 5% of the previous one
+		msft := positions.Positions[0]
+		if msft.CurrentPrice < price*0.95-1e-9 || msft.CurrentPrice > price*1.05+1e-9 {
+			t.Fatalf("Fetch %d: price moved from %v to %v, more than the walk allows", i, price, msft.CurrentPrice)
+		}
+		if !almostEqual(msft.MarketValue, msft.CurrentPrice*10) || !almostEqual(msft.UnrealizedPnL, msft.MarketValue-3900) {
+			t.Errorf("Fetch %d: market value and P&L not recomputed: %+v", i, msft)
+		}
+		price = msft.CurrentPrice
+	}
+	if price == 400 {
+		t.Error("Expected the price to walk away from the fixture price")
+	}
+}
+
+func TestNewMockService_Accounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "positions.json")
+	fixture := `{"account_id":"all","accounts":[
+		{"account_id":"111","positions":[{"id":"a","symbol":"AAPL"}]},
+		{"account_id":"222","account_label":"ira","positions":[{"id":"b","symbol":"MSFT"}]}
+	]}`
+	if err := os.WriteFile(path, []byte(fixture), 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+
+	s, err := NewMockService(path, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	positions, err := s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Account: "ira"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if positions.AccountID != "222" || len(positions.Positions) != 1 || positions.Positions[0].ID != "b" {
+		t.Errorf("Unexpected ira positions: %+v", positions)
+	}
+
+	merged, err := s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Account: AllAccounts})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(merged.Positions) != 2 || len(merged.Accounts) != 2 || merged.Accounts[0].AccountLabel != PrimaryAccountLabel {
+		t.Errorf("Unexpected merged positions: %+v", merged)
+	}
+}
+
+func TestNewMockService_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		priceWalk float64
+	}{
+		{name: "missing file", path: filepath.Join("testdata", "missing.json")},
+		{name: "not positions", path: filepath.Join("testdata", "orders.json")},
+		{name: "negative walk", path: mockFixture, priceWalk: -0.1},
+		{name: "walk of 100%", path: mockFixture, priceWalk: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMockService(tt.path, tt.priceWalk); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
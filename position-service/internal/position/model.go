@@ -1,6 +1,11 @@
 package position
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -12,27 +17,317 @@ const (
 	Robinhood AccountType = "robinhood"
 )
 
-// Position represents a trading position
+// IsSupported reports whether the account type is handled by the service
+func (a AccountType) IsSupported() bool {
+	switch a {
+	case Robinhood:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseAccountType parses an account type case-insensitively, rejecting
+// unsupported values
+func ParseAccountType(s string) (AccountType, error) {
+	accountType := AccountType(strings.ToLower(strings.TrimSpace(s)))
+	if !accountType.IsSupported() {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedAccountType, s)
+	}
+	return accountType, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler so unknown account types are
+// rejected when a request is bound
+func (a *AccountType) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("account type must be a string: %w", err)
+	}
+	accountType, err := ParseAccountType(s)
+	if err != nil {
+		return err
+	}
+	*a = accountType
+	return nil
+}
+
+// OptionType is the right conveyed by an option contract
+type OptionType string
+
+const (
+	// Call option
+	Call OptionType = "call"
+	// Put option
+	Put OptionType = "put"
+)
+
+// PositionSide is the direction of a position
+type PositionSide string
+
+const (
+	// Long positions were bought
+	Long PositionSide = "long"
+	// Short positions were sold to open, e.g. the written leg of a credit spread
+	Short PositionSide = "short"
+)
+
+// Position represents a trading position. Option contract details are
+// empty for equities. Short positions have a negative quantity and market
+// value, and a credit position a negative cost basis.
 type Position struct {
-	ID                   string    `json:"id"`
-	AccountID            string    `json:"account_id"`
-	Symbol               string    `json:"symbol"`
-	Quantity             float64   `json:"quantity"`
-	AveragePrice         float64   `json:"average_price"`
-	CurrentPrice         float64   `json:"current_price"`
-	MarketValue          float64   `json:"market_value"`
-	CostBasis            float64   `json:"cost_basis"`
-	UnrealizedPnL        float64   `json:"unrealized_pnl"`
-	UnrealizedPnLPercent float64   `json:"unrealized_pnl_percent"`
-	InstrumentURL        string    `json:"instrument_url"`
-	CreatedAt            time.Time `json:"created_at"`
-	UpdatedAt            time.Time `json:"updated_at"`
-}
-
-// PositionList represents a list of positions
+	ID                   string       `json:"id"`
+	AccountID            string       `json:"account_id"`
+	Symbol               string       `json:"symbol"`                      // Equity symbol, or the underlying of an option
+	UnderlyingSymbol     string       `json:"underlying_symbol,omitempty"` // Options only
+	OccSymbol            string       `json:"occ_symbol,omitempty"`        // OCC symbol identifying an option contract
+	OptionSymbol         string       `json:"option_symbol,omitempty"`     // OccSymbol without the root padding, as quoted by data vendors
+	ChainID              string       `json:"chain_id,omitempty"`          // Option chain, shared by the legs of a spread
+	Side                 PositionSide `json:"side"`
+	Quantity             float64      `json:"quantity"`
+	AveragePrice         float64      `json:"average_price"`
+	CurrentPrice         float64      `json:"current_price"`
+	PriceUnavailable     bool         `json:"price_unavailable,omitempty"` // No current price could be fetched, values and P&L are zero
+	PriceSource          PriceSource  `json:"price_source,omitempty"`      // Set when a price feed is configured, see SetPriceFeed
+	MarketValue          float64      `json:"market_value"`
+	CostBasis            float64      `json:"cost_basis"`
+	UnrealizedPnL        float64      `json:"unrealized_pnl"`
+	UnrealizedPnLPercent float64      `json:"unrealized_pnl_percent"`
+	InstrumentURL        string       `json:"instrument_url"`
+	ExpirationDate       string       `json:"expiration_date,omitempty"` // YYYY-MM-DD
+	OptionType           OptionType   `json:"option_type,omitempty"`
+	StrikePrice          float64      `json:"strike_price,omitempty"`
+	Multiplier           float64      `json:"multiplier,omitempty"`
+	Delta                *float64     `json:"delta,omitempty"` // Per share of an option contract, nil when unknown
+	CreatedAt            time.Time    `json:"created_at"`
+	UpdatedAt            time.Time    `json:"updated_at"`
+}
+
+// PositionList represents a list of positions. When it merges several
+// accounts, AccountID is AllAccounts and Accounts holds each account's list.
 type PositionList struct {
-	Positions   []Position  `json:"positions"`
+	Positions    []Position      `json:"positions"`
+	AccountID    string          `json:"account_id"`
+	AccountLabel string          `json:"account_label,omitempty"`
+	AccountType  AccountType     `json:"account_type"`
+	Accounts     []*PositionList `json:"accounts,omitempty"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	// Stale lists are served from the cache while the circuit breaker is
+	// open, UpdatedAt tells their age
+	Stale bool `json:"stale,omitempty"`
+	// LivePricesStale lists were served while the price feed was
+	// disconnected, so their equities keep the fetched prices
+	LivePricesStale bool `json:"live_prices_stale,omitempty"`
+}
+
+// AssetClass distinguishes equity and option orders
+type AssetClass string
+
+const (
+	// Equity orders trade shares
+	Equity AssetClass = "equity"
+	// Option orders trade one or more option contracts
+	Option AssetClass = "option"
+	// Crypto positions are not fetched yet, filtering by it matches nothing
+	Crypto AssetClass = "crypto"
+)
+
+// ParseAssetClass parses an asset class case-insensitively
+func ParseAssetClass(s string) (AssetClass, error) {
+	switch assetClass := AssetClass(strings.ToLower(s)); assetClass {
+	case Equity, Option, Crypto:
+		return assetClass, nil
+	default:
+		return "", fmt.Errorf("invalid asset type %q, expected one of: option, equity, crypto", s)
+	}
+}
+
+// PositionSort is a key positions can be sorted by
+type PositionSort string
+
+const (
+	// SortByPnL sorts by unrealized P&L
+	SortByPnL PositionSort = "pnl"
+	// SortByPnLPercent sorts by unrealized P&L relative to the cost basis
+	SortByPnLPercent PositionSort = "pnl_percent"
+	// SortByMarketValue sorts by market value
+	SortByMarketValue PositionSort = "market_value"
+	// SortBySymbol sorts alphabetically by symbol
+	SortBySymbol PositionSort = "symbol"
+)
+
+// ParsePositionSort parses a sort key, rejecting unknown keys with the
+// allowed values
+func ParsePositionSort(s string) (PositionSort, error) {
+	switch key := PositionSort(strings.ToLower(s)); key {
+	case SortByPnL, SortByPnLPercent, SortByMarketValue, SortBySymbol:
+		return key, nil
+	default:
+		return "", fmt.Errorf("invalid sort %q, expected one of: pnl, pnl_percent, market_value, symbol", s)
+	}
+}
+
+// OrderSide is the side of an order or fill
+type OrderSide string
+
+const (
+	// Buy side
+	Buy OrderSide = "buy"
+	// Sell side
+	Sell OrderSide = "sell"
+)
+
+// Execution is a single fill of an order or order leg. Partially filled
+// orders have one execution per fill.
+type Execution struct {
+	ID       string    `json:"id"`
+	Quantity float64   `json:"quantity"`
+	Price    float64   `json:"price"`
+	Time     time.Time `json:"time"`
+}
+
+// OrderLeg is a single option contract traded by an option order
+type OrderLeg struct {
+	ID               string      `json:"id"`
+	Instrument       string      `json:"instrument"` // Option instrument URL
+	Side             OrderSide   `json:"side"`
+	PositionEffect   string      `json:"position_effect"` // open or close
+	RatioQuantity    float64     `json:"ratio_quantity"`
+	FilledQuantity   float64     `json:"filled_quantity"`
+	AverageFillPrice float64     `json:"average_fill_price"`
+	Executions       []Execution `json:"executions"`
+}
+
+// Order is an equity or option order normalized across brokers. Equity
+// orders carry their executions directly, option orders per leg.
+type Order struct {
+	ID         string     `json:"id"`
+	AccountID  string     `json:"account_id"`
+	Symbol     string     `json:"symbol"` // Equity symbol or option chain symbol
+	AssetClass AssetClass `json:"asset_class"`
+	// Side of a multi-leg order is buy for a net debit and sell for a net credit
+	Side           OrderSide `json:"side"`
+	Type           string    `json:"type"`  // market or limit
+	State          string    `json:"state"` // Broker order state, e.g. filled or cancelled
+	Quantity       float64   `json:"quantity"`
+	FilledQuantity float64   `json:"filled_quantity"`
+	// AverageFillPrice is per share, or the net premium per share for option orders
+	AverageFillPrice float64     `json:"average_fill_price"`
+	Fees             float64     `json:"fees"`
+	Instrument       string      `json:"instrument,omitempty"` // Equity instrument URL
+	Executions       []Execution `json:"executions,omitempty"`
+	Legs             []OrderLeg  `json:"legs,omitempty"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// OrderList represents a list of orders, newest first
+type OrderList struct {
+	Orders      []Order     `json:"orders"`
 	AccountID   string      `json:"account_id"`
 	AccountType AccountType `json:"account_type"`
 	UpdatedAt   time.Time   `json:"updated_at"`
 }
+
+// FilterByMinMarketValue returns a copy of the list without positions whose
+// absolute market value is below min. A non-positive min disables the filter.
+func (l *PositionList) FilterByMinMarketValue(min float64) *PositionList {
+	if min <= 0 {
+		return l
+	}
+
+	return l.filter(func(p Position) bool {
+		return math.Abs(p.MarketValue) >= min
+	})
+}
+
+// FilterBySymbol returns a copy of the list containing only positions whose
+// symbol matches, ignoring case
+func (l *PositionList) FilterBySymbol(symbol string) *PositionList {
+	return l.filter(func(p Position) bool {
+		return strings.EqualFold(p.Symbol, symbol)
+	})
+}
+
+// AssetClass returns whether the position holds option contracts or shares
+func (p Position) AssetClass() AssetClass {
+	if p.OptionType != "" || p.OccSymbol != "" {
+		return Option
+	}
+	return Equity
+}
+
+// FilterByAssetClass returns a copy of the list containing only positions
+// of the asset class
+func (l *PositionList) FilterByAssetClass(assetClass AssetClass) *PositionList {
+	return l.filter(func(p Position) bool {
+		return p.AssetClass() == assetClass
+	})
+}
+
+// FilterBySymbolPrefix returns a copy of the list containing only positions
+// whose symbol starts with prefix, ignoring case
+func (l *PositionList) FilterBySymbolPrefix(prefix string) *PositionList {
+	prefix = strings.ToUpper(prefix)
+	return l.filter(func(p Position) bool {
+		return strings.HasPrefix(strings.ToUpper(p.Symbol), prefix)
+	})
+}
+
+// SortBy returns a copy of the list, including any per-account lists,
+// sorted by key. Ties keep their order, ascending or descending.
+func (l *PositionList) SortBy(key PositionSort, descending bool) *PositionList {
+	less := func(a, b Position) bool {
+		switch key {
+		case SortByPnL:
+			return a.UnrealizedPnL < b.UnrealizedPnL
+		case SortByPnLPercent:
+			return a.UnrealizedPnLPercent < b.UnrealizedPnLPercent
+		case SortByMarketValue:
+			return a.MarketValue < b.MarketValue
+		default:
+			return strings.ToUpper(a.Symbol) < strings.ToUpper(b.Symbol)
+		}
+	}
+
+	sorted := l.filter(func(Position) bool { return true })
+	sorted.sortPositions(func(a, b Position) bool {
+		if descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return sorted
+}
+
+// sortPositions stably sorts the positions of the list and its per-account
+// lists in place
+func (l *PositionList) sortPositions(less func(a, b Position) bool) {
+	sort.SliceStable(l.Positions, func(i, j int) bool {
+		return less(l.Positions[i], l.Positions[j])
+	})
+	for _, account := range l.Accounts {
+		account.sortPositions(less)
+	}
+}
+
+// filter returns a copy of the list, including any per-account lists, with
+// only the positions for which keep returns true
+func (l *PositionList) filter(keep func(Position) bool) *PositionList {
+	filtered := *l
+	filtered.Positions = make([]Position, 0, len(l.Positions))
+	for _, p := range l.Positions {
+		if keep(p) {
+			filtered.Positions = append(filtered.Positions, p)
+		}
+	}
+
+	if l.Accounts != nil {
+		filtered.Accounts = make([]*PositionList, len(l.Accounts))
+		for i, account := range l.Accounts {
+			filtered.Accounts[i] = account.filter(keep)
+		}
+	}
+
+	return &filtered
+}
@@ -0,0 +1,87 @@
+package position
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseAccountType(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    AccountType
+		expectedErr bool
+	}{
+		{input: "robinhood", expected: Robinhood},
+		{input: "Robinhood", expected: Robinhood},
+		{input: " ROBINHOOD ", expected: Robinhood},
+		{input: "robinhod", expectedErr: true},
+		{input: "etrade", expectedErr: true},
+		{input: "", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			accountType, err := ParseAccountType(tt.input)
+			if tt.expectedErr {
+				if !errors.Is(err, ErrUnsupportedAccountType) {
+					t.Errorf("Expected ErrUnsupportedAccountType, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if accountType != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, accountType)
+			}
+		})
+	}
+}
+
+func TestAccountType_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		expected    AccountType
+		expectedErr bool
+	}{
+		{name: "valid", body: `{"account_type":"robinhood"}`, expected: Robinhood},
+		{name: "case variant", body: `{"account_type":"RobinHood"}`, expected: Robinhood},
+		{name: "unknown", body: `{"account_type":"robinhod"}`, expectedErr: true},
+		{name: "not a string", body: `{"account_type":1}`, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req PositionRequest
+			err := json.Unmarshal([]byte(tt.body), &req)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("Expected an error, got account type %s", req.AccountType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if req.AccountType != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, req.AccountType)
+			}
+		})
+	}
+}
+
+func TestPositionList_SortByAccounts(t *testing.T) {
+	account := &PositionList{Positions: []Position{{ID: "b", Symbol: "MSFT"}, {ID: "a", Symbol: "AAPL"}}}
+	merged := &PositionList{Positions: append([]Position(nil), account.Positions...), Accounts: []*PositionList{account}}
+
+	sorted := merged.SortBy(SortBySymbol, false)
+	if sorted.Positions[0].ID != "a" || sorted.Accounts[0].Positions[0].ID != "a" {
+		t.Errorf("Expected the merged and account lists sorted, got %+v", sorted)
+	}
+	// The cached lists are left alone
+	if merged.Positions[0].ID != "b" || account.Positions[0].ID != "b" {
+		t.Errorf("Expected the original lists unchanged, got %+v and %+v", merged.Positions, account.Positions)
+	}
+}
@@ -0,0 +1,55 @@
+package position
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// occRootWidth and occStrikeDigits size the fields of an OCC option symbol:
+// a 6 character root padded with spaces, a YYMMDD expiration, C or P, and
+// the strike in thousandths of a dollar padded to 8 digits, 21 characters
+// in total, e.g. "NVDA  250117C00150000"
+const (
+	occRootWidth    = 6
+	occStrikeDigits = 8
+)
+
+// FormatOCCSymbol builds the OCC symbol of an option contract from its
+// underlying symbol, YYYY-MM-DD expiration date, type and strike price
+func FormatOCCSymbol(underlying, expirationDate string, optionType OptionType, strikePrice float64) (string, error) {
+	root := strings.ToUpper(strings.TrimSpace(underlying))
+	if root == "" || len(root) > occRootWidth {
+		return "", fmt.Errorf("invalid OCC root %q: must be 1 to %d characters", underlying, occRootWidth)
+	}
+
+	expiration, err := time.Parse("2006-01-02", expirationDate)
+	if err != nil {
+		return "", fmt.Errorf("invalid expiration date %q: %w", expirationDate, err)
+	}
+
+	var right string
+	switch optionType {
+	case Call:
+		right = "C"
+	case Put:
+		right = "P"
+	default:
+		return "", fmt.Errorf("invalid option type %q", optionType)
+	}
+
+	// Strikes are quoted to at most 3 decimals, rounding absorbs float error
+	strike := math.Round(strikePrice * 1000)
+	if strike <= 0 || strike >= math.Pow10(occStrikeDigits) {
+		return "", fmt.Errorf("invalid strike price %v", strikePrice)
+	}
+
+	return fmt.Sprintf("%-*s%s%s%0*d", occRootWidth, root, expiration.Format("060102"), right, occStrikeDigits, int64(strike)), nil
+}
+
+// CompactOCCSymbol drops the padding of the root from an OCC symbol, e.g.
+// "AAPL  250117C00150000" becomes "AAPL250117C00150000"
+func CompactOCCSymbol(occSymbol string) string {
+	return strings.ReplaceAll(occSymbol, " ", "")
+}
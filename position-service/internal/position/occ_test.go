@@ -0,0 +1,84 @@
+package position
+
+import "testing"
+
+func TestFormatOCCSymbol(t *testing.T) {
+	tests := []struct {
+		name           string
+		underlying     string
+		expirationDate string
+		optionType     OptionType
+		strikePrice    float64
+		expected       string
+	}{
+		{name: "call", underlying: "NVDA", expirationDate: "2025-01-17", optionType: Call, strikePrice: 150, expected: "NVDA  250117C00150000"},
+		{name: "put", underlying: "MSFT", expirationDate: "2025-04-17", optionType: Put, strikePrice: 400, expected: "MSFT  250417P00400000"},
+		{name: "lowercase root", underlying: "aapl", expirationDate: "2025-06-20", optionType: Call, strikePrice: 200, expected: "AAPL  250620C00200000"},
+		{name: "single character root", underlying: "F", expirationDate: "2025-06-20", optionType: Put, strikePrice: 12, expected: "F     250620P00012000"},
+		{name: "six character root", underlying: "GOOGL1", expirationDate: "2025-06-20", optionType: Call, strikePrice: 180, expected: "GOOGL1250620C00180000"},
+		{name: "half dollar strike", underlying: "SPY", expirationDate: "2025-03-21", optionType: Call, strikePrice: 562.5, expected: "SPY   250321C00562500"},
+		{name: "fractional strike", underlying: "AMC", expirationDate: "2025-03-21", optionType: Put, strikePrice: 4.125, expected: "AMC   250321P00004125"},
+		{name: "float error in strike", underlying: "SOFI", expirationDate: "2025-03-21", optionType: Call, strikePrice: 7.1 * 3, expected: "SOFI  250321C00021300"},
+		{name: "five digit strike", underlying: "NDX", expirationDate: "2025-12-19", optionType: Call, strikePrice: 21000, expected: "NDX   251219C21000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbol, err := FormatOCCSymbol(tt.underlying, tt.expirationDate, tt.optionType, tt.strikePrice)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if symbol != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, symbol)
+			}
+			if len(symbol) != 21 {
+				t.Errorf("Expected 21 characters, got %d", len(symbol))
+			}
+		})
+	}
+}
+
+func TestFormatOCCSymbol_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		underlying     string
+		expirationDate string
+		optionType     OptionType
+		strikePrice    float64
+	}{
+		{name: "empty root", underlying: "", expirationDate: "2025-01-17", optionType: Call, strikePrice: 150},
+		{name: "root too long", underlying: "TOOLONG", expirationDate: "2025-01-17", optionType: Call, strikePrice: 150},
+		{name: "missing expiration", underlying: "NVDA", expirationDate: "", optionType: Call, strikePrice: 150},
+		{name: "malformed expiration", underlying: "NVDA", expirationDate: "01/17/2025", optionType: Call, strikePrice: 150},
+		{name: "missing type", underlying: "NVDA", expirationDate: "2025-01-17", optionType: "", strikePrice: 150},
+		{name: "zero strike", underlying: "NVDA", expirationDate: "2025-01-17", optionType: Call, strikePrice: 0},
+		{name: "strike too large", underlying: "NVDA", expirationDate: "2025-01-17", optionType: Call, strikePrice: 100000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbol, err := FormatOCCSymbol(tt.underlying, tt.expirationDate, tt.optionType, tt.strikePrice)
+			if err == nil {
+				t.Errorf("Expected an error, got %q", symbol)
+			}
+		})
+	}
+}
+
+func TestCompactOCCSymbol(t *testing.T) {
+	tests := []struct {
+		occSymbol string
+		expected  string
+	}{
+		{occSymbol: "AAPL  250117C00150000", expected: "AAPL250117C00150000"},
+		{occSymbol: "F     250620P00012000", expected: "F250620P00012000"},
+		{occSymbol: "GOOGL1250620C00180000", expected: "GOOGL1250620C00180000"},
+		{occSymbol: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		if got := CompactOCCSymbol(tt.occSymbol); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/trade-sonic/robinhood"
+)
+
+const (
+	// DefaultOrderHistoryTTL is how long fetched order history backs realized
+	// P&L. Order history is paginated and expensive, so it outlives the
+	// position cache.
+	DefaultOrderHistoryTTL = 15 * time.Minute
+	// DefaultOrderCacheTTL is how long fetched orders are served by
+	// ListOrders, which callers poll for fills
+	DefaultOrderCacheTTL = 30 * time.Second
+	// optionMultiplier is the standard option contract multiplier. Order
+	// history does not report it.
+	optionMultiplier = 100.0
+)
+
+// OrderQuery selects the orders returned by ListOrders
+type OrderQuery struct {
+	AccountType AccountType
+	// Account is an account number or label, as in PositionQuery
+	Account string
+	// State optionally keeps only orders in this broker state, e.g. filled
+	State string
+	// Since optionally keeps only orders updated at or after this time
+	Since time.Time
+	// Refresh bypasses the order cache
+	Refresh bool
+}
+
+// PnLQuery selects the account and period of a realized P&L report
+type PnLQuery struct {
+	AccountType AccountType
+	// Account is an account number or label, as in PositionQuery
+	Account string
+	// Trades closed in [From, To) are realized in the period
+	From time.Time
+	To   time.Time
+	// Refresh bypasses the order history cache
+	Refresh bool
+}
+
+// orderHistory is the cached order history of an account, newest first
+type orderHistory struct {
+	orders    []Order
+	fetchedAt time.Time
+}
+
+// SetOrderHistoryTTL sets how long fetched order history backs realized
+// P&L. A non-positive TTL disables the cache.
+func (s *Service) SetOrderHistoryTTL(ttl time.Duration) {
+	s.orderMutex.Lock()
+	s.orderHistoryTTL = ttl
+	s.orderMutex.Unlock()
+}
+
+// SetOrderCacheTTL sets how long fetched orders are served by ListOrders. A
+// non-positive TTL disables the cache.
+func (s *Service) SetOrderCacheTTL(ttl time.Duration) {
+	s.orderMutex.Lock()
+	s.orderCacheTTL = ttl
+	s.orderMutex.Unlock()
+}
+
+// ListOrders returns the equity and option orders of the selected account,
+// newest first. Orders are fetched with every page of history and cached
+// briefly.
+func (s *Service) ListOrders(ctx context.Context, q OrderQuery) (*OrderList, error) {
+	s.orderMutex.Lock()
+	ttl := s.orderCacheTTL
+	s.orderMutex.Unlock()
+
+	accounts, accountID, err := s.selectAccounts(q.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	list := &OrderList{
+		Orders:      []Order{},
+		AccountID:   accountID,
+		AccountType: q.AccountType,
+	}
+	for _, account := range accounts {
+		history, err := s.getOrders(ctx, q.AccountType, account, ttl, q.Refresh)
+		if err != nil {
+			if len(accounts) > 1 {
+				err = fmt.Errorf("account %s: %w", account.Label, err)
+			}
+			return nil, contextError(ctx, err)
+		}
+
+		for _, order := range history.orders {
+			if q.State != "" && order.State != q.State {
+				continue
+			}
+			if !q.Since.IsZero() && order.UpdatedAt.Before(q.Since) {
+				continue
+			}
+			list.Orders = append(list.Orders, order)
+		}
+		if history.fetchedAt.After(list.UpdatedAt) {
+			list.UpdatedAt = history.fetchedAt
+		}
+	}
+
+	sortOrders(list.Orders)
+	return list, nil
+}
+
+// RealizedPnL reconciles the order history of the selected account into
+// closed round-trips and reports the P&L realized in the query period. The
+// whole history is matched, so lots opened before the period are closed at
+// their actual entry price.
+func (s *Service) RealizedPnL(ctx context.Context, q PnLQuery) (*RealizedPnLReport, error) {
+	s.orderMutex.Lock()
+	ttl := s.orderHistoryTTL
+	s.orderMutex.Unlock()
+
+	accounts, accountID, err := s.selectAccounts(q.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	// Lots are never matched across accounts
+	var trades []ClosedTrade
+	for _, account := range accounts {
+		history, err := s.getOrders(ctx, q.AccountType, account, ttl, q.Refresh)
+		if err != nil {
+			if len(accounts) > 1 {
+				err = fmt.Errorf("account %s: %w", account.Label, err)
+			}
+			return nil, contextError(ctx, err)
+		}
+		trades = append(trades, MatchFills(OrderFills(history.orders))...)
+	}
+
+	report := &RealizedPnLReport{
+		From:        q.From,
+		To:          q.To,
+		AccountID:   accountID,
+		AccountType: q.AccountType,
+	}
+	report.Symbols, report.RealizedPnL, report.Fees = SummarizeRealizedPnL(trades, q.From, q.To)
+	return report, nil
+}
+
+// selectAccounts resolves an account number or label to the accounts it
+// selects and the account ID to report, AllAccounts selecting every account
+func (s *Service) selectAccounts(account string) ([]Account, string, error) {
+	if account == AllAccounts {
+		accounts := s.Accounts()
+		if len(accounts) == 0 {
+			return nil, "", fmt.Errorf("%w: no account ID", ErrNotConfigured)
+		}
+		return accounts, AllAccounts, nil
+	}
+
+	resolved, err := s.resolveAccount(account)
+	if err != nil {
+		return nil, "", err
+	}
+	return []Account{resolved}, resolved.ID, nil
+}
+
+// getOrders returns the cached order history of an account, fetching it if
+// missing, older than maxAge or when refresh is set
+func (s *Service) getOrders(ctx context.Context, accountType AccountType, account Account, maxAge time.Duration, refresh bool) (*orderHistory, error) {
+	key := cacheKey{accountType: accountType, accountID: account.ID}
+
+	s.orderMutex.Lock()
+	cached, exists := s.orderCache[key]
+	s.orderMutex.Unlock()
+
+	// Requests shed by NewLoadShedder make do with any cached history
+	if isCacheOnly(ctx) {
+		if !exists {
+			return nil, fmt.Errorf("%w: no cached orders for account %s", ErrOverloaded, account.Label)
+		}
+		return cached, nil
+	}
+	if exists && !refresh && time.Since(cached.fetchedAt) < maxAge {
+		return cached, nil
+	}
+
+	// Mock mode has no order history
+	var orders []Order
+	if s.mock == nil {
+		err := s.withToken(ctx, accountType, account, func(token string) error {
+			var err error
+			orders, err = s.fetchAccountOrders(ctx, accountType, token, account.ID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortOrders(orders)
+
+	history := &orderHistory{orders: orders, fetchedAt: time.Now()}
+	s.orderMutex.Lock()
+	s.orderCache[key] = history
+	s.orderMutex.Unlock()
+
+	return history, nil
+}
+
+// sortOrders sorts orders newest first
+func sortOrders(orders []Order) {
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.After(orders[j].CreatedAt)
+	})
+}
+
+// fetchAccountOrders fetches the order history of an account from its broker
+func (s *Service) fetchAccountOrders(ctx context.Context, accountType AccountType, token, accountID string) ([]Order, error) {
+	switch accountType {
+	case Robinhood:
+		return s.fetchRobinhoodOrders(ctx, token, accountID)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
+	}
+}
+
+// fetchRobinhoodOrders fetches the equity and option order history of an
+// account, every page of it
+func (s *Service) fetchRobinhoodOrders(ctx context.Context, token, accountID string) ([]Order, error) {
+	equityOrders, err := s.fetchRobinhoodEquityOrders(ctx, token, accountID)
+	if err != nil {
+		return nil, err
+	}
+	optionOrders, err := s.fetchRobinhoodOptionOrders(ctx, token, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return append(equityOrders, optionOrders...), nil
+}
+
+// fetchRobinhoodEquityOrders fetches the equity order history of an account
+func (s *Service) fetchRobinhoodEquityOrders(ctx context.Context, token, accountID string) ([]Order, error) {
+	items, err := s.robinhood().GetEquityOrders(ctx, token, accountID)
+	if err != nil {
+		return nil, s.robinhoodError(ctx, "equity orders", err)
+	}
+
+	orders := make([]Order, 0, len(items))
+	for _, item := range items {
+		side, err := parseOrderSide(item.Side)
+		if err != nil {
+			return nil, fmt.Errorf("order %s: %w", item.ID, err)
+		}
+		executions, err := parseExecutions(item.Executions)
+		if err != nil {
+			return nil, fmt.Errorf("order %s: %w", item.ID, err)
+		}
+		symbol, err := s.instrumentSymbol(ctx, item.Instrument, token)
+		if err != nil {
+			return nil, fmt.Errorf("order %s: %w", item.ID, err)
+		}
+
+		orders = append(orders, Order{
+			ID:               item.ID,
+			AccountID:        accountID,
+			Symbol:           symbol,
+			AssetClass:       Equity,
+			Side:             side,
+			Type:             item.Type,
+			State:            item.State,
+			Quantity:         parseDecimal(item.Quantity),
+			FilledQuantity:   parseDecimal(item.CumulativeQuantity),
+			AverageFillPrice: parseDecimal(item.AveragePrice),
+			Fees:             parseDecimal(item.Fees),
+			Instrument:       item.Instrument,
+			Executions:       executions,
+			CreatedAt:        parseTimestamp(item.CreatedAt),
+			UpdatedAt:        parseTimestamp(item.UpdatedAt),
+		})
+	}
+	return orders, nil
+}
+
+// fetchRobinhoodOptionOrders fetches the option order history of an account
+func (s *Service) fetchRobinhoodOptionOrders(ctx context.Context, token, accountID string) ([]Order, error) {
+	items, err := s.robinhood().GetOptionOrders(ctx, token, accountID)
+	if err != nil {
+		return nil, s.robinhoodError(ctx, "option orders", err)
+	}
+
+	orders := make([]Order, 0, len(items))
+	for _, item := range items {
+		order := Order{
+			ID:             item.ID,
+			AccountID:      accountID,
+			Symbol:         item.ChainSymbol,
+			AssetClass:     Option,
+			Type:           item.Type,
+			State:          item.State,
+			Quantity:       parseDecimal(item.Quantity),
+			FilledQuantity: parseDecimal(item.ProcessedQuantity),
+			Fees:           parseDecimal(item.RegulatoryFees),
+			CreatedAt:      parseTimestamp(item.CreatedAt),
+			UpdatedAt:      parseTimestamp(item.UpdatedAt),
+		}
+		// The processed premium covers every contract of every leg
+		if order.FilledQuantity > 0 {
+			order.AverageFillPrice = parseDecimal(item.ProcessedPremium) / (order.FilledQuantity * optionMultiplier)
+		}
+
+		for _, itemLeg := range item.Legs {
+			side, err := parseOrderSide(itemLeg.Side)
+			if err != nil {
+				return nil, fmt.Errorf("order %s: %w", item.ID, err)
+			}
+			executions, err := parseExecutions(itemLeg.Executions)
+			if err != nil {
+				return nil, fmt.Errorf("order %s: %w", item.ID, err)
+			}
+
+			leg := OrderLeg{
+				ID:             itemLeg.ID,
+				Instrument:     itemLeg.Option,
+				Side:           side,
+				PositionEffect: itemLeg.PositionEffect,
+				RatioQuantity:  itemLeg.RatioQuantity,
+				Executions:     executions,
+			}
+			notional := 0.0
+			for _, execution := range executions {
+				leg.FilledQuantity += execution.Quantity
+				notional += execution.Quantity * execution.Price
+			}
+			if leg.FilledQuantity > 0 {
+				leg.AverageFillPrice = notional / leg.FilledQuantity
+			}
+			order.Legs = append(order.Legs, leg)
+		}
+
+		switch {
+		case len(order.Legs) == 1:
+			order.Side = order.Legs[0].Side
+		case item.Direction == "debit":
+			order.Side = Buy
+		case item.Direction == "credit":
+			order.Side = Sell
+		}
+
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// parseOrderSide parses a broker order side
+func parseOrderSide(value string) (OrderSide, error) {
+	switch side := OrderSide(value); side {
+	case Buy, Sell:
+		return side, nil
+	default:
+		return "", fmt.Errorf("unknown side %q", value)
+	}
+}
+
+// parseExecutions parses the executions of a Robinhood order or order leg
+func parseExecutions(items []robinhood.Execution) ([]Execution, error) {
+	executions := make([]Execution, 0, len(items))
+	for _, item := range items {
+		price, err := strconv.ParseFloat(item.Price, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid execution price %q: %w", item.Price, err)
+		}
+		quantity, err := strconv.ParseFloat(item.Quantity, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid execution quantity %q: %w", item.Quantity, err)
+		}
+		executedAt, err := time.Parse(time.RFC3339, item.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid execution timestamp %q: %w", item.Timestamp, err)
+		}
+
+		executions = append(executions, Execution{
+			ID:       item.ID,
+			Quantity: quantity,
+			Price:    price,
+			Time:     executedAt,
+		})
+	}
+	return executions, nil
+}
+
+// parseDecimal parses an optional decimal string, returning zero when it is
+// empty or null as on unfilled orders
+func parseDecimal(value string) float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
+// parseTimestamp parses an optional RFC 3339 timestamp
+func parseTimestamp(value string) time.Time {
+	parsed, _ := time.Parse(time.RFC3339, value)
+	return parsed
+}
+
+// instrumentSymbol returns the symbol of an equity instrument. Symbols never
+// change, so they are cached for the lifetime of the service.
+func (s *Service) instrumentSymbol(ctx context.Context, instrumentURL, token string) (string, error) {
+	s.orderMutex.Lock()
+	symbol, ok := s.instrumentSymbols[instrumentURL]
+	s.orderMutex.Unlock()
+	if ok {
+		return symbol, nil
+	}
+
+	// Execute the request, retrying transient failures
+	resp, err := s.doGet(ctx, instrumentURL, token)
+	if err != nil {
+		return "", fmt.Errorf("error fetching instrument: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the response status code is OK
+	if resp.StatusCode != http.StatusOK {
+		return "", s.responseError(ctx, "instrument", resp)
+	}
+
+	var instrumentResp struct {
+		Symbol string `json:"symbol"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&instrumentResp); err != nil {
+		return "", fmt.Errorf("error decoding instrument response: %w", err)
+	}
+
+	s.orderMutex.Lock()
+	s.instrumentSymbols[instrumentURL] = instrumentResp.Symbol
+	s.orderMutex.Unlock()
+
+	return instrumentResp.Symbol, nil
+}
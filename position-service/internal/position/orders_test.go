@@ -0,0 +1,208 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// orderFixtures maps the Robinhood order history endpoints to their fixtures
+var orderFixtures = map[string]string{
+	"/orders/":           "orders.json",
+	"/orders/?cursor=2":  "orders_page2.json",
+	"/options/orders/":   "options_orders.json",
+	"/instruments/aapl/": "instrument_aapl.json",
+	"/instruments/msft/": "instrument_msft.json",
+}
+
+func TestRealizedPnL_OrderHistory(t *testing.T) {
+	srv := newFixtureServer(t, orderFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	report, err := s.RealizedPnL(context.Background(), PnLQuery{
+		AccountType: Robinhood,
+		From:        time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if report.AccountID != "test-account" {
+		t.Errorf("Expected account test-account, got %s", report.AccountID)
+	}
+	if len(report.Symbols) != 2 {
+		t.Fatalf("Expected 2 symbols, got %d: %+v", len(report.Symbols), report.Symbols)
+	}
+
+	// Equities: 220 gross less 0.12 fees, both pages matched FIFO.
+	// Options: the call spread makes 400 on the long leg and loses 200 on the
+	// short leg, less 0.16 fees.
+	aapl := report.Symbols[0]
+	if aapl.Symbol != "AAPL" || !almostEqual(aapl.RealizedPnL, 419.72) || !almostEqual(aapl.Fees, 0.28) {
+		t.Errorf("Unexpected AAPL P&L: %+v", aapl)
+	}
+	if len(aapl.Trades) != 6 {
+		t.Errorf("Expected 6 AAPL trades, got %d", len(aapl.Trades))
+	}
+
+	// The MSFT buy is listed after the sell but executed before it
+	msft := report.Symbols[1]
+	if msft.Symbol != "MSFT" || !almostEqual(msft.RealizedPnL, -20) {
+		t.Errorf("Unexpected MSFT P&L: %+v", msft)
+	}
+
+	if !almostEqual(report.RealizedPnL, 399.72) {
+		t.Errorf("Expected total realized P&L 399.72, got %v", report.RealizedPnL)
+	}
+	if !almostEqual(report.Fees, 0.28) {
+		t.Errorf("Expected total fees 0.28, got %v", report.Fees)
+	}
+}
+
+func TestRealizedPnL_Period(t *testing.T) {
+	srv := newFixtureServer(t, orderFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	// Only the spread is closed in the period, its opening orders are not
+	report, err := s.RealizedPnL(context.Background(), PnLQuery{
+		AccountType: Robinhood,
+		From:        time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(report.Symbols) != 1 || report.Symbols[0].Symbol != "AAPL" {
+		t.Fatalf("Expected only AAPL, got %+v", report.Symbols)
+	}
+	if !almostEqual(report.RealizedPnL, 199.84) {
+		t.Errorf("Expected realized P&L 199.84, got %v", report.RealizedPnL)
+	}
+}
+
+func TestRealizedPnL_CachesOrderHistory(t *testing.T) {
+	fixtures := newFixtureServer(t, orderFixtures)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fixtures.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	query := PnLQuery{
+		AccountType: Robinhood,
+		From:        time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if _, err := s.RealizedPnL(context.Background(), query); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fetched := requests.Load()
+
+	// A different period is served from the cached history
+	query.From = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.RealizedPnL(context.Background(), query); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requests.Load() != fetched {
+		t.Errorf("Expected the cached order history to be used, got %d more requests", requests.Load()-fetched)
+	}
+
+	// Without a TTL both equity pages and the option page are fetched again
+	s.SetOrderHistoryTTL(0)
+	if _, err := s.RealizedPnL(context.Background(), query); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if refetched := requests.Load() - fetched; refetched != 3 {
+		t.Errorf("Expected 3 order history requests after the TTL, got %d", refetched)
+	}
+}
+
+func TestRealizedPnL_UnknownAccount(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+
+	_, err := s.RealizedPnL(context.Background(), PnLQuery{AccountType: Robinhood, Account: "999"})
+	if !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Expected ErrUnknownAccount, got %v", err)
+	}
+}
+
+func TestListOrders_Normalizes(t *testing.T) {
+	srv := newFixtureServer(t, orderFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	list, err := s.ListOrders(context.Background(), OrderQuery{AccountType: Robinhood})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	orders := make(map[string]Order)
+	for _, order := range list.Orders {
+		orders[order.ID] = order
+	}
+
+	t.Run("equity with partial fills", func(t *testing.T) {
+		order := orders["eq-aapl-buy-1"]
+		if order.Symbol != "AAPL" || order.AssetClass != Equity || order.Side != Buy || order.State != "filled" {
+			t.Errorf("Unexpected order: %+v", order)
+		}
+		if order.Quantity != 10 || order.FilledQuantity != 10 || !almostEqual(order.AverageFillPrice, 100.4) {
+			t.Errorf("Unexpected fill: quantity %v, filled %v at %v", order.Quantity, order.FilledQuantity, order.AverageFillPrice)
+		}
+		if len(order.Executions) != 2 || order.Executions[1].Quantity != 4 || order.Executions[1].Price != 101 {
+			t.Errorf("Unexpected executions: %+v", order.Executions)
+		}
+		if len(order.Legs) != 0 {
+			t.Errorf("Expected no legs on an equity order, got %d", len(order.Legs))
+		}
+	})
+
+	t.Run("single-leg option", func(t *testing.T) {
+		order := orders["opt-tsla-put-open"]
+		if order.Symbol != "TSLA" || order.AssetClass != Option || order.Side != Buy || order.State != "cancelled" {
+			t.Errorf("Unexpected order: %+v", order)
+		}
+		if order.Quantity != 3 || order.FilledQuantity != 2 || !almostEqual(order.AverageFillPrice, 4.1) {
+			t.Errorf("Unexpected fill: quantity %v, filled %v at %v", order.Quantity, order.FilledQuantity, order.AverageFillPrice)
+		}
+		if len(order.Legs) != 1 {
+			t.Fatalf("Expected 1 leg, got %d", len(order.Legs))
+		}
+		leg := order.Legs[0]
+		if leg.PositionEffect != "open" || leg.FilledQuantity != 2 || !almostEqual(leg.AverageFillPrice, 4.1) || len(leg.Executions) != 2 {
+			t.Errorf("Unexpected leg: %+v", leg)
+		}
+	})
+
+	t.Run("multi-leg option", func(t *testing.T) {
+		order := orders["opt-spread-close"]
+		// A net credit, so the spread is sold
+		if order.Symbol != "AAPL" || order.Side != Sell || !almostEqual(order.AverageFillPrice, 4) || !almostEqual(order.Fees, 0.08) {
+			t.Errorf("Unexpected order: %+v", order)
+		}
+		if len(order.Legs) != 2 {
+			t.Fatalf("Expected 2 legs, got %d", len(order.Legs))
+		}
+		expected := []struct {
+			side  OrderSide
+			price float64
+		}{{side: Sell, price: 7}, {side: Buy, price: 3}}
+		for i, want := range expected {
+			leg := order.Legs[i]
+			if leg.Side != want.side || leg.AverageFillPrice != want.price || leg.PositionEffect != "close" || leg.RatioQuantity != 1 {
+				t.Errorf("Unexpected leg %d: %+v", i, leg)
+			}
+		}
+	})
+}
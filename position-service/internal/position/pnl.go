@@ -0,0 +1,210 @@
+package position
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// quantityEpsilon absorbs float rounding when comparing fractional quantities
+const quantityEpsilon = 1e-9
+
+// Fill is a single execution of an order. A partially filled order has one
+// fill per execution.
+type Fill struct {
+	OrderID string `json:"order_id"`
+	// Symbol is the equity symbol or the option's chain symbol, P&L is reported per symbol
+	Symbol string `json:"symbol"`
+	// Instrument identifies what was traded, e.g. a single option contract.
+	// Lots are only matched against fills of the same instrument.
+	Instrument string    `json:"instrument"`
+	Side       OrderSide `json:"side"`
+	Quantity   float64   `json:"quantity"`
+	Price      float64   `json:"price"`
+	Multiplier float64   `json:"multiplier"` // 1 for equities
+	Fees       float64   `json:"fees"`
+	Time       time.Time `json:"time"`
+}
+
+// ClosedTrade is a round-trip: a quantity opened by one fill and closed by a
+// later fill of the same instrument
+type ClosedTrade struct {
+	Symbol     string    `json:"symbol"`
+	Instrument string    `json:"instrument"`
+	Short      bool      `json:"short"` // Opened by a sell and closed by a buy
+	Quantity   float64   `json:"quantity"`
+	OpenPrice  float64   `json:"open_price"`
+	ClosePrice float64   `json:"close_price"`
+	OpenedAt   time.Time `json:"opened_at"`
+	ClosedAt   time.Time `json:"closed_at"`
+	// Fees are the matched shares of the opening and closing fills' fees
+	Fees float64 `json:"fees"`
+	// RealizedPnL is net of Fees
+	RealizedPnL float64 `json:"realized_pnl"`
+}
+
+// SymbolPnL is the realized P&L of a symbol over a period
+type SymbolPnL struct {
+	Symbol      string        `json:"symbol"`
+	RealizedPnL float64       `json:"realized_pnl"`
+	Fees        float64       `json:"fees"`
+	Trades      []ClosedTrade `json:"trades"`
+}
+
+// RealizedPnLReport is the realized P&L of the trades closed in [From, To)
+type RealizedPnLReport struct {
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	AccountID   string      `json:"account_id"`
+	AccountType AccountType `json:"account_type"`
+	Symbols     []SymbolPnL `json:"symbols"`
+	RealizedPnL float64     `json:"realized_pnl"`
+	Fees        float64     `json:"fees"`
+}
+
+// lot is the still open quantity of a fill
+type lot struct {
+	fill       Fill
+	remaining  float64
+	feePerUnit float64
+}
+
+// MatchFills reconciles fills into closed trades using FIFO matching per
+// instrument. A fill first closes the oldest open lots of the opposite side;
+// any remainder opens a new lot, so both long and short round-trips are
+// matched. Quantity still open at the end is not realized.
+func MatchFills(fills []Fill) []ClosedTrade {
+	sorted := append([]Fill(nil), fills...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	open := make(map[string][]*lot)
+	var trades []ClosedTrade
+	for _, fill := range sorted {
+		if fill.Quantity <= 0 {
+			continue
+		}
+		if fill.Multiplier == 0 {
+			fill.Multiplier = 1
+		}
+
+		remaining := fill.Quantity
+		feePerUnit := fill.Fees / fill.Quantity
+		lots := open[fill.Instrument]
+		for len(lots) > 0 && lots[0].fill.Side != fill.Side && remaining > quantityEpsilon {
+			opening := lots[0]
+			quantity := math.Min(opening.remaining, remaining)
+
+			trade := ClosedTrade{
+				Symbol:     fill.Symbol,
+				Instrument: fill.Instrument,
+				Short:      opening.fill.Side == Sell,
+				Quantity:   quantity,
+				OpenPrice:  opening.fill.Price,
+				ClosePrice: fill.Price,
+				OpenedAt:   opening.fill.Time,
+				ClosedAt:   fill.Time,
+				Fees:       quantity * (opening.feePerUnit + feePerUnit),
+			}
+			gross := (trade.ClosePrice - trade.OpenPrice) * quantity * fill.Multiplier
+			if trade.Short {
+				gross = -gross
+			}
+			trade.RealizedPnL = gross - trade.Fees
+			trades = append(trades, trade)
+
+			opening.remaining -= quantity
+			remaining -= quantity
+			if opening.remaining <= quantityEpsilon {
+				lots = lots[1:]
+			}
+		}
+
+		if remaining > quantityEpsilon {
+			lots = append(lots, &lot{fill: fill, remaining: remaining, feePerUnit: feePerUnit})
+		}
+		open[fill.Instrument] = lots
+	}
+
+	return trades
+}
+
+// SummarizeRealizedPnL groups the trades closed in [from, to) by symbol,
+// sorted by symbol, and returns the totals over all symbols
+func SummarizeRealizedPnL(trades []ClosedTrade, from, to time.Time) (symbols []SymbolPnL, realizedPnL, fees float64) {
+	bySymbol := make(map[string]*SymbolPnL)
+	for _, trade := range trades {
+		if trade.ClosedAt.Before(from) || !trade.ClosedAt.Before(to) {
+			continue
+		}
+
+		summary, ok := bySymbol[trade.Symbol]
+		if !ok {
+			summary = &SymbolPnL{Symbol: trade.Symbol}
+			bySymbol[trade.Symbol] = summary
+		}
+		summary.RealizedPnL += trade.RealizedPnL
+		summary.Fees += trade.Fees
+		summary.Trades = append(summary.Trades, trade)
+
+		realizedPnL += trade.RealizedPnL
+		fees += trade.Fees
+	}
+
+	symbols = make([]SymbolPnL, 0, len(bySymbol))
+	for _, summary := range bySymbol {
+		symbols = append(symbols, *summary)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Symbol < symbols[j].Symbol
+	})
+	return symbols, realizedPnL, fees
+}
+
+// OrderFills returns the executions of orders as fills. An order's fees are
+// spread over its fills by quantity.
+func OrderFills(orders []Order) []Fill {
+	var fills []Fill
+	for _, order := range orders {
+		var orderFills []Fill
+		switch order.AssetClass {
+		case Equity:
+			orderFills = executionFills(order, order.Instrument, order.Side, 1, order.Executions)
+		case Option:
+			for _, leg := range order.Legs {
+				orderFills = append(orderFills, executionFills(order, leg.Instrument, leg.Side, optionMultiplier, leg.Executions)...)
+			}
+		}
+
+		total := 0.0
+		for _, fill := range orderFills {
+			total += fill.Quantity
+		}
+		if order.Fees != 0 && total > 0 {
+			for i := range orderFills {
+				orderFills[i].Fees = order.Fees * orderFills[i].Quantity / total
+			}
+		}
+		fills = append(fills, orderFills...)
+	}
+	return fills
+}
+
+// executionFills converts the executions of an order or order leg into fills
+func executionFills(order Order, instrument string, side OrderSide, multiplier float64, executions []Execution) []Fill {
+	fills := make([]Fill, 0, len(executions))
+	for _, execution := range executions {
+		fills = append(fills, Fill{
+			OrderID:    order.ID,
+			Symbol:     order.Symbol,
+			Instrument: instrument,
+			Side:       side,
+			Quantity:   execution.Quantity,
+			Price:      execution.Price,
+			Multiplier: multiplier,
+			Time:       execution.Time,
+		})
+	}
+	return fills
+}
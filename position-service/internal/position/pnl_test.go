@@ -0,0 +1,204 @@
+package position
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// tradeTime returns a fill time the given number of minutes after a fixed base
+func tradeTime(minutes int) time.Time {
+	return time.Date(2025, 3, 3, 14, 30, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute)
+}
+
+func fill(instrument string, side OrderSide, quantity, price, fees float64, minutes int) Fill {
+	return Fill{
+		Symbol:     "AAPL",
+		Instrument: instrument,
+		Side:       side,
+		Quantity:   quantity,
+		Price:      price,
+		Multiplier: 1,
+		Fees:       fees,
+		Time:       tradeTime(minutes),
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestMatchFills(t *testing.T) {
+	type expectedTrade struct {
+		quantity    float64
+		openPrice   float64
+		closePrice  float64
+		short       bool
+		fees        float64
+		realizedPnL float64
+	}
+
+	tests := []struct {
+		name     string
+		fills    []Fill
+		expected []expectedTrade
+	}{
+		{
+			name: "single round-trip",
+			fills: []Fill{
+				fill("aapl", Buy, 10, 100, 0, 0),
+				fill("aapl", Sell, 10, 105, 0, 1),
+			},
+			expected: []expectedTrade{
+				{quantity: 10, openPrice: 100, closePrice: 105, realizedPnL: 50},
+			},
+		},
+		{
+			name: "partial fills close the oldest lots first",
+			fills: []Fill{
+				// One buy order filled in two executions, then a second buy
+				fill("aapl", Buy, 6, 100, 0, 0),
+				fill("aapl", Buy, 4, 101, 0, 1),
+				fill("aapl", Buy, 5, 110, 0, 2),
+				// One sell order filled in two executions
+				fill("aapl", Sell, 8, 120, 0, 3),
+				fill("aapl", Sell, 4, 121, 0, 4),
+			},
+			expected: []expectedTrade{
+				{quantity: 6, openPrice: 100, closePrice: 120, realizedPnL: 120},
+				{quantity: 2, openPrice: 101, closePrice: 120, realizedPnL: 38},
+				{quantity: 2, openPrice: 101, closePrice: 121, realizedPnL: 40},
+				{quantity: 2, openPrice: 110, closePrice: 121, realizedPnL: 22},
+			},
+		},
+		{
+			name: "short round-trip",
+			fills: []Fill{
+				fill("aapl", Sell, 3, 50, 0, 0),
+				fill("aapl", Buy, 1, 45, 0, 1),
+				fill("aapl", Buy, 2, 52, 0, 2),
+			},
+			expected: []expectedTrade{
+				{quantity: 1, openPrice: 50, closePrice: 45, short: true, realizedPnL: 5},
+				{quantity: 2, openPrice: 50, closePrice: 52, short: true, realizedPnL: -4},
+			},
+		},
+		{
+			name: "a fill larger than the open lots flips the position",
+			fills: []Fill{
+				fill("aapl", Buy, 2, 10, 0, 0),
+				fill("aapl", Sell, 5, 12, 0, 1),
+				fill("aapl", Buy, 3, 11, 0, 2),
+			},
+			expected: []expectedTrade{
+				{quantity: 2, openPrice: 10, closePrice: 12, realizedPnL: 4},
+				{quantity: 3, openPrice: 12, closePrice: 11, short: true, realizedPnL: 3},
+			},
+		},
+		{
+			name: "fees are split by matched quantity",
+			fills: []Fill{
+				fill("aapl", Buy, 10, 100, 1.00, 0),
+				fill("aapl", Sell, 4, 110, 0.20, 1),
+			},
+			expected: []expectedTrade{
+				// 4 of 10 opening shares (0.40) plus all closing fees (0.20)
+				{quantity: 4, openPrice: 100, closePrice: 110, fees: 0.60, realizedPnL: 39.40},
+			},
+		},
+		{
+			name: "lots are matched per instrument",
+			fills: []Fill{
+				fill("call-200", Buy, 1, 5, 0, 0),
+				fill("call-210", Sell, 1, 2, 0, 0),
+				fill("call-210", Buy, 1, 3, 0, 1),
+			},
+			expected: []expectedTrade{
+				{quantity: 1, openPrice: 2, closePrice: 3, short: true, realizedPnL: -1},
+			},
+		},
+		{
+			name: "fills are matched in time order",
+			fills: []Fill{
+				fill("aapl", Sell, 1, 20, 0, 5),
+				fill("aapl", Buy, 1, 15, 0, 0),
+			},
+			expected: []expectedTrade{
+				{quantity: 1, openPrice: 15, closePrice: 20, realizedPnL: 5},
+			},
+		},
+		{
+			name: "open lots are not realized",
+			fills: []Fill{
+				fill("aapl", Buy, 5, 100, 0, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trades := MatchFills(tt.fills)
+			if len(trades) != len(tt.expected) {
+				t.Fatalf("Expected %d trades, got %d: %+v", len(tt.expected), len(trades), trades)
+			}
+
+			for i, want := range tt.expected {
+				got := trades[i]
+				if !almostEqual(got.Quantity, want.quantity) || got.OpenPrice != want.openPrice || got.ClosePrice != want.closePrice {
+					t.Errorf("Trade %d: expected %v @ %v -> %v, got %v @ %v -> %v",
+						i, want.quantity, want.openPrice, want.closePrice, got.Quantity, got.OpenPrice, got.ClosePrice)
+				}
+				if got.Short != want.short {
+					t.Errorf("Trade %d: expected short %v, got %v", i, want.short, got.Short)
+				}
+				if !almostEqual(got.Fees, want.fees) {
+					t.Errorf("Trade %d: expected fees %v, got %v", i, want.fees, got.Fees)
+				}
+				if !almostEqual(got.RealizedPnL, want.realizedPnL) {
+					t.Errorf("Trade %d: expected realized P&L %v, got %v", i, want.realizedPnL, got.RealizedPnL)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchFills_Multiplier(t *testing.T) {
+	open := fill("call-200", Buy, 2, 5, 0, 0)
+	closing := fill("call-200", Sell, 2, 7, 0, 1)
+	open.Multiplier, closing.Multiplier = 100, 100
+
+	trades := MatchFills([]Fill{open, closing})
+	if len(trades) != 1 {
+		t.Fatalf("Expected 1 trade, got %d", len(trades))
+	}
+	if !almostEqual(trades[0].RealizedPnL, 400) {
+		t.Errorf("Expected realized P&L 400, got %v", trades[0].RealizedPnL)
+	}
+}
+
+func TestSummarizeRealizedPnL(t *testing.T) {
+	trades := []ClosedTrade{
+		{Symbol: "MSFT", RealizedPnL: -20, ClosedAt: tradeTime(0)},
+		{Symbol: "AAPL", RealizedPnL: 50, Fees: 0.5, ClosedAt: tradeTime(10)},
+		{Symbol: "AAPL", RealizedPnL: 30, Fees: 0.25, ClosedAt: tradeTime(20)},
+		// Closed exactly at the end of the period, so excluded
+		{Symbol: "NVDA", RealizedPnL: 100, ClosedAt: tradeTime(30)},
+	}
+
+	symbols, realizedPnL, fees := SummarizeRealizedPnL(trades, tradeTime(0), tradeTime(30))
+	if len(symbols) != 2 {
+		t.Fatalf("Expected 2 symbols, got %d: %+v", len(symbols), symbols)
+	}
+	if symbols[0].Symbol != "AAPL" || !almostEqual(symbols[0].RealizedPnL, 80) || !almostEqual(symbols[0].Fees, 0.75) || len(symbols[0].Trades) != 2 {
+		t.Errorf("Unexpected AAPL summary: %+v", symbols[0])
+	}
+	if symbols[1].Symbol != "MSFT" || !almostEqual(symbols[1].RealizedPnL, -20) {
+		t.Errorf("Unexpected MSFT summary: %+v", symbols[1])
+	}
+	if !almostEqual(realizedPnL, 60) {
+		t.Errorf("Expected total realized P&L 60, got %v", realizedPnL)
+	}
+	if !almostEqual(fees, 0.75) {
+		t.Errorf("Expected total fees 0.75, got %v", fees)
+	}
+}
@@ -0,0 +1,321 @@
+package position
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// priceFeedMinBackoff is the delay before reconnecting a dropped price feed
+	priceFeedMinBackoff = time.Second
+	// priceFeedMaxBackoff caps the reconnect delay while the relay stays down
+	priceFeedMaxBackoff = 30 * time.Second
+	// priceFeedWriteTimeout bounds how long subscribing new symbols may take
+	priceFeedWriteTimeout = 10 * time.Second
+)
+
+// PriceSource tells where the current price of a position came from
+type PriceSource string
+
+const (
+	// PriceSourceLive prices were streamed after the positions were fetched
+	PriceSourceLive PriceSource = "live"
+	// PriceSourceFetched prices are as fetched from the broker, because no
+	// newer trade was streamed or the price feed is disconnected
+	PriceSourceFetched PriceSource = "fetched"
+	// PriceSourceMark prices are the broker's option marks, options are not
+	// streamed
+	PriceSourceMark PriceSource = "mark"
+)
+
+// subscribeMessage subscribes more symbols on an open relay connection
+type subscribeMessage struct {
+	Type    string   `json:"type"`
+	Symbols []string `json:"symbols"`
+}
+
+// streamedTrade is a trade relayed by the market streaming service, in
+// Finnhub's format
+type streamedTrade struct {
+	Price  float64 `json:"p"`
+	Symbol string  `json:"s"`
+}
+
+// livePrice is the last streamed price of a symbol
+type livePrice struct {
+	price float64
+	at    time.Time // When the trade was received
+}
+
+// PriceFeed keeps live prices of the symbols held, streamed from the trade
+// relay of the market streaming service, e.g. ws://localhost:8082/trades.
+// Symbols are subscribed as positions holding them are served, and prices
+// are only reported while connected.
+type PriceFeed struct {
+	url    string
+	dialer *websocket.Dialer
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	symbols   map[string]bool
+	prices    map[string]livePrice
+	connected bool
+	changed   chan struct{} // Signalled when symbols are added
+}
+
+// NewPriceFeed creates a price feed streaming from the trade relay at
+// relayURL. Nothing is streamed until Run is called.
+func NewPriceFeed(relayURL string, logger *slog.Logger) *PriceFeed {
+	return &PriceFeed{
+		url:     relayURL,
+		dialer:  websocket.DefaultDialer,
+		logger:  logger,
+		symbols: make(map[string]bool),
+		prices:  make(map[string]livePrice),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Watch adds symbols to stream. Symbols the feed was not streaming yet are
+// subscribed on the open connection, so the prices of the others are kept.
+func (f *PriceFeed) Watch(symbols ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	added := false
+	for _, symbol := range symbols {
+		if symbol != "" && !f.symbols[symbol] {
+			f.symbols[symbol] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	select {
+	case f.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Price returns the last streamed price of a symbol and when it was
+// received. ok is false while the feed is disconnected or no trade of the
+// symbol was received since it connected.
+func (f *PriceFeed) Price(symbol string) (price float64, at time.Time, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.connected {
+		return 0, time.Time{}, false
+	}
+	live, ok := f.prices[symbol]
+	return live.price, live.at, ok
+}
+
+// Connected reports whether the feed is streaming from the relay
+func (f *PriceFeed) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected
+}
+
+// Run streams prices until ctx is done, reconnecting with backoff while the
+// relay is unreachable. Prices are dropped on every disconnect.
+func (f *PriceFeed) Run(ctx context.Context) {
+	backoff := priceFeedMinBackoff
+	for {
+		symbols := f.watched()
+		if len(symbols) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-f.changed:
+			}
+			continue
+		}
+
+		streamed, err := f.stream(ctx, symbols)
+		if ctx.Err() != nil {
+			return
+		}
+		if streamed {
+			backoff = priceFeedMinBackoff
+		}
+
+		f.logger.WarnContext(ctx, "Price feed disconnected, serving fetched prices", "url", f.url, "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > priceFeedMaxBackoff {
+			backoff = priceFeedMaxBackoff
+		}
+	}
+}
+
+// watched returns the watched symbols, sorted
+func (f *PriceFeed) watched() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	symbols := make([]string, 0, len(f.symbols))
+	for symbol := range f.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// stream connects to the relay and records trades of the symbols until the
+// connection ends. streamed reports whether the connection was established.
+func (f *PriceFeed) stream(ctx context.Context, symbols []string) (streamed bool, err error) {
+	relayURL, err := url.Parse(f.url)
+	if err != nil {
+		return false, err
+	}
+	query := relayURL.Query()
+	query.Set("symbols", strings.Join(symbols, ","))
+	relayURL.RawQuery = query.Encode()
+
+	conn, _, err := f.dialer.DialContext(ctx, relayURL.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	f.mu.Lock()
+	f.connected = true
+	f.mu.Unlock()
+	f.logger.InfoContext(ctx, "Price feed connected", "url", f.url, "symbols", len(symbols))
+
+	done := make(chan struct{})
+	subscribed := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		subscribed[symbol] = true
+	}
+	go f.subscribeWatched(ctx, conn, subscribed, done)
+
+	defer func() {
+		close(done)
+		conn.Close()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.connected = false
+		f.prices = make(map[string]livePrice)
+	}()
+
+	for {
+		var trade streamedTrade
+		if err := conn.ReadJSON(&trade); err != nil {
+			return true, err
+		}
+		if trade.Price <= 0 {
+			continue
+		}
+		f.mu.Lock()
+		f.prices[trade.Symbol] = livePrice{price: trade.Price, at: time.Now()}
+		f.mu.Unlock()
+	}
+}
+
+// subscribeWatched subscribes symbols watched after the connection was
+// dialed on it, until done is closed. It is the connection's only writer. A
+// failed subscription closes the connection, which then reconnects with
+// every watched symbol.
+func (f *PriceFeed) subscribeWatched(ctx context.Context, conn *websocket.Conn, subscribed map[string]bool, done <-chan struct{}) {
+	for {
+		var added []string
+		for _, symbol := range f.watched() {
+			if !subscribed[symbol] {
+				subscribed[symbol] = true
+				added = append(added, symbol)
+			}
+		}
+		if len(added) > 0 {
+			conn.SetWriteDeadline(time.Now().Add(priceFeedWriteTimeout))
+			if err := conn.WriteJSON(subscribeMessage{Type: "subscribe", Symbols: added}); err != nil {
+				f.logger.WarnContext(ctx, "Price feed subscription failed, reconnecting", "symbols", added, "error", err)
+				conn.Close()
+				return
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-f.changed:
+		}
+	}
+}
+
+// revalue returns a copy of the list with equities valued at prices
+// streamed since the list was fetched. Options keep their marks. Merged
+// lists are revalued account by account.
+func (f *PriceFeed) revalue(list *PositionList) *PositionList {
+	revalued := *list
+	if len(list.Accounts) > 0 {
+		revalued.Positions = []Position{}
+		revalued.Accounts = make([]*PositionList, len(list.Accounts))
+		for i, account := range list.Accounts {
+			revalued.Accounts[i] = f.revalue(account)
+			revalued.Positions = append(revalued.Positions, revalued.Accounts[i].Positions...)
+			revalued.LivePricesStale = revalued.LivePricesStale || revalued.Accounts[i].LivePricesStale
+		}
+		return &revalued
+	}
+
+	revalued.Positions = append([]Position{}, list.Positions...)
+	revalued.LivePricesStale = !f.Connected()
+	var symbols []string
+	for i := range revalued.Positions {
+		position := &revalued.Positions[i]
+		if position.AssetClass() == Option {
+			position.PriceSource = PriceSourceMark
+			continue
+		}
+		symbols = append(symbols, position.Symbol)
+
+		price, at, ok := f.Price(position.Symbol)
+		if !ok || at.Before(list.UpdatedAt) {
+			position.PriceSource = PriceSourceFetched
+			continue
+		}
+		setCurrentPrice(position, price)
+		position.PriceUnavailable = false
+		position.PriceSource = PriceSourceLive
+	}
+	f.Watch(symbols...)
+	return &revalued
+}
+
+// SetPriceFeed revalues the equities of served positions at live prices
+// from feed. The feed is run by the caller. Nil serves fetched prices only.
+// Positions fetched from Robinhood are options only, so in practice only
+// mock positions are revalued.
+func (s *Service) SetPriceFeed(feed *PriceFeed) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	s.priceFeed = feed
+}
+
+// applyLivePrices revalues a list with the price feed, if one is set
+func (s *Service) applyLivePrices(list *PositionList) *PositionList {
+	s.cacheMutex.RLock()
+	feed := s.priceFeed
+	s.cacheMutex.RUnlock()
+
+	if feed == nil {
+		return list
+	}
+	return feed.revalue(list)
+}
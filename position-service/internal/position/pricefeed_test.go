@@ -0,0 +1,318 @@
+package position
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tradeRelay is a fake market streaming trade relay
+type tradeRelay struct {
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	conns   []*websocket.Conn
+	queries []string // The symbols query of every connection
+	// subscribed are the symbols of subscribe messages, in order
+	subscribed []string
+}
+
+func newTradeRelay(t *testing.T) *tradeRelay {
+	relay := &tradeRelay{}
+	relay.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := relay.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		relay.mu.Lock()
+		relay.conns = append(relay.conns, conn)
+		relay.queries = append(relay.queries, r.URL.Query().Get("symbols"))
+		relay.mu.Unlock()
+
+		go func() {
+			for {
+				var msg subscribeMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					return
+				}
+				relay.mu.Lock()
+				relay.subscribed = append(relay.subscribed, msg.Symbols...)
+				relay.mu.Unlock()
+			}
+		}()
+	}))
+	t.Cleanup(relay.server.Close)
+	return relay
+}
+
+// url returns the websocket URL of the relay
+func (r *tradeRelay) url() string {
+	return "ws" + strings.TrimPrefix(r.server.URL, "http") + "/trades"
+}
+
+// lastQuery returns the symbols query of the latest connection
+func (r *tradeRelay) lastQuery() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queries) == 0 {
+		return ""
+	}
+	return r.queries[len(r.queries)-1]
+}
+
+// send writes a trade to the latest connection
+func (r *tradeRelay) send(t *testing.T, symbol string, price float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conn := r.conns[len(r.conns)-1]
+	if err := conn.WriteJSON(map[string]interface{}{"p": price, "s": symbol, "t": time.Now().UnixMilli(), "v": 1}); err != nil {
+		t.Fatalf("Failed to send trade: %v", err)
+	}
+}
+
+// disconnect closes every connection
+func (r *tradeRelay) disconnect() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+// newPriceFeedService returns a mock service revaluing positions with a
+// running price feed from relay
+func newPriceFeedService(t *testing.T, relay *tradeRelay) (*Service, *PriceFeed) {
+	s, err := NewMockService(mockFixture, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	feed := NewPriceFeed(relay.url(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetPriceFeed(feed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		feed.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s, feed
+}
+
+// findPosition returns the position with the given ID
+func findPosition(t *testing.T, list *PositionList, id string) Position {
+	t.Helper()
+	for _, p := range list.Positions {
+		if p.ID == id {
+			return p
+		}
+	}
+	t.Fatalf("Position %s not found", id)
+	return Position{}
+}
+
+func TestPriceFeed_RevaluesEquities(t *testing.T) {
+	relay := newTradeRelay(t)
+	s, feed := newPriceFeedService(t, relay)
+	ctx := context.Background()
+
+	// Serving positions subscribes the equities held
+	positions, err := s.GetPositions(ctx, Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !positions.LivePricesStale {
+		t.Error("Expected live prices to be stale before the feed connects")
+	}
+	waitFor(t, "the feed to connect", feed.Connected)
+	if query := relay.lastQuery(); query != "MSFT" {
+		t.Errorf("Expected the feed to subscribe MSFT, got %q", query)
+	}
+
+	relay.send(t, "MSFT", 420)
+	waitFor(t, "the MSFT price", func() bool {
+		_, _, ok := feed.Price("MSFT")
+		return ok
+	})
+
+	positions, err = s.GetPositions(ctx, Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if positions.LivePricesStale {
+		t.Error("Expected live prices not to be stale")
+	}
+
+	msft := findPosition(t, positions, "mock-msft")
+	if msft.PriceSource != PriceSourceLive {
+		t.Errorf("Expected price source %s, got %s", PriceSourceLive, msft.PriceSource)
+	}
+	if msft.CurrentPrice != 420 || msft.MarketValue != 4200 || msft.UnrealizedPnL != 300 {
+		t.Errorf("Expected MSFT revalued at 420, got price %v, value %v, P&L %v", msft.CurrentPrice, msft.MarketValue, msft.UnrealizedPnL)
+	}
+
+	// Options keep their marks
+	call := findPosition(t, positions, "mock-aapl-call")
+	if call.PriceSource != PriceSourceMark || call.CurrentPrice != 4.25 {
+		t.Errorf("Expected the AAPL call at its mark of 4.25, got %v from %s", call.CurrentPrice, call.PriceSource)
+	}
+
+	// The cached positions are left as fetched
+	cached, _, _ := s.positionCache.Get(ctx, Robinhood, "mock-account")
+	if p := findPosition(t, cached, "mock-msft"); p.CurrentPrice != 400 || p.PriceSource != "" {
+		t.Errorf("Expected the cached MSFT position untouched, got price %v from %q", p.CurrentPrice, p.PriceSource)
+	}
+}
+
+func TestPriceFeed_SubscribesOnOpenConnection(t *testing.T) {
+	relay := newTradeRelay(t)
+	feed := NewPriceFeed(relay.url(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		feed.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	feed.Watch("MSFT")
+	waitFor(t, "the feed to connect", feed.Connected)
+	relay.send(t, "MSFT", 420)
+	waitFor(t, "the MSFT price", func() bool {
+		_, _, ok := feed.Price("MSFT")
+		return ok
+	})
+
+	feed.Watch("NVDA")
+	waitFor(t, "NVDA to be subscribed", func() bool {
+		relay.mu.Lock()
+		defer relay.mu.Unlock()
+		return len(relay.subscribed) == 1 && relay.subscribed[0] == "NVDA"
+	})
+	relay.send(t, "NVDA", 900)
+	waitFor(t, "the NVDA price", func() bool {
+		_, _, ok := feed.Price("NVDA")
+		return ok
+	})
+
+	// The connection and the prices streamed on it were kept
+	if price, _, ok := feed.Price("MSFT"); !ok || price != 420 {
+		t.Errorf("Expected the MSFT price of 420 to be kept, got %v, %v", price, ok)
+	}
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	if len(relay.conns) != 1 {
+		t.Errorf("Expected a single connection, got %d", len(relay.conns))
+	}
+}
+
+func TestPriceFeed_FallsBackWhenDisconnected(t *testing.T) {
+	relay := newTradeRelay(t)
+	s, feed := newPriceFeedService(t, relay)
+	ctx := context.Background()
+
+	if _, err := s.GetPositions(ctx, Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	waitFor(t, "the feed to connect", feed.Connected)
+	relay.send(t, "MSFT", 420)
+	waitFor(t, "the MSFT price", func() bool {
+		_, _, ok := feed.Price("MSFT")
+		return ok
+	})
+
+	// Stop the relay so the feed stays down
+	relay.disconnect()
+	relay.server.Close()
+	waitFor(t, "the feed to disconnect", func() bool { return !feed.Connected() })
+
+	positions, err := s.GetPositions(ctx, Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !positions.LivePricesStale {
+		t.Error("Expected live prices to be stale while disconnected")
+	}
+	msft := findPosition(t, positions, "mock-msft")
+	if msft.PriceSource != PriceSourceFetched || msft.CurrentPrice != 400 || msft.MarketValue != 4000 {
+		t.Errorf("Expected MSFT at its fetched price of 400, got %v from %s", msft.CurrentPrice, msft.PriceSource)
+	}
+}
+
+func TestPriceFeed_IgnoresPricesOlderThanFetch(t *testing.T) {
+	feed := NewPriceFeed("ws://unused", slog.Default())
+	feed.connected = true
+	feed.prices["MSFT"] = livePrice{price: 420, at: time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC)}
+
+	list := &PositionList{
+		Positions: []Position{{ID: "msft", Symbol: "MSFT", Quantity: 10, CurrentPrice: 400, MarketValue: 4000, CostBasis: 3900, UnrealizedPnL: 100}},
+		UpdatedAt: time.Date(2025, 3, 10, 14, 5, 0, 0, time.UTC),
+	}
+	revalued := feed.revalue(list)
+
+	msft := revalued.Positions[0]
+	if msft.PriceSource != PriceSourceFetched || msft.CurrentPrice != 400 {
+		t.Errorf("Expected the fetched price of 400, got %v from %s", msft.CurrentPrice, msft.PriceSource)
+	}
+	if revalued.LivePricesStale {
+		t.Error("Expected live prices not to be stale while connected")
+	}
+}
+
+func TestPriceFeed_RevaluesMergedAccounts(t *testing.T) {
+	feed := NewPriceFeed("ws://unused", slog.Default())
+	feed.connected = true
+	feed.prices["MSFT"] = livePrice{price: 420, at: time.Now()}
+
+	fetchedAt := time.Now().Add(-time.Minute)
+	first := &PositionList{AccountID: "1", UpdatedAt: fetchedAt, Positions: []Position{{ID: "msft", Symbol: "MSFT", Quantity: 10, CurrentPrice: 400, CostBasis: 3900}}}
+	second := &PositionList{AccountID: "2", UpdatedAt: fetchedAt, Positions: []Position{{ID: "nvda", Symbol: "NVDA", Quantity: 5, CurrentPrice: 100, CostBasis: 400}}}
+	merged := &PositionList{
+		AccountID: AllAccounts,
+		UpdatedAt: fetchedAt,
+		Positions: append(append([]Position{}, first.Positions...), second.Positions...),
+		Accounts:  []*PositionList{first, second},
+	}
+
+	revalued := feed.revalue(merged)
+	if len(revalued.Positions) != 2 {
+		t.Fatalf("Expected 2 positions, got %d", len(revalued.Positions))
+	}
+	if p := revalued.Positions[0]; p.PriceSource != PriceSourceLive || p.MarketValue != 4200 {
+		t.Errorf("Expected MSFT revalued to 4200, got %v from %s", p.MarketValue, p.PriceSource)
+	}
+	if p := revalued.Accounts[1].Positions[0]; p.PriceSource != PriceSourceFetched || p.CurrentPrice != 100 {
+		t.Errorf("Expected NVDA at its fetched price of 100, got %v from %s", p.CurrentPrice, p.PriceSource)
+	}
+	if first.Positions[0].CurrentPrice != 400 {
+		t.Error("Expected the account list left untouched")
+	}
+}
@@ -0,0 +1,52 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultRateLimit is the default number of Robinhood requests per second
+	DefaultRateLimit = 5
+	// DefaultRateBurst is the default number of Robinhood requests allowed at once
+	DefaultRateBurst = 10
+)
+
+// SetRateLimit limits outbound Robinhood requests to requestsPerSecond with
+// bursts of up to burst requests. A non-positive rate disables the limit.
+func (s *Service) SetRateLimit(requestsPerSecond float64, burst int) {
+	limit := rate.Limit(requestsPerSecond)
+	if requestsPerSecond <= 0 {
+		limit = rate.Inf
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	s.limiter.SetLimit(limit)
+	s.limiter.SetBurst(burst)
+}
+
+// RateLimitWait returns the total time Robinhood requests spent waiting on
+// the rate limiter since the service started
+func (s *Service) RateLimitWait() time.Duration {
+	return time.Duration(s.rateLimitWait.Load())
+}
+
+// waitForRateLimit blocks until the rate limiter admits a request. It fails
+// without waiting when the wait would outlast the context deadline.
+func (s *Service) waitForRateLimit(ctx context.Context, requestURL string) error {
+	start := time.Now()
+	if err := s.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("rate limiter: %w", err)
+	}
+
+	waited := time.Since(start)
+	s.rateLimitWait.Add(int64(waited))
+	if waited > time.Millisecond {
+		s.logger.DebugContext(ctx, "Waited for rate limiter", "url", requestURL, "wait", waited)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package position
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoGet_RateLimitPacesConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.SetRateLimit(20, 1)
+
+	const requests = 6
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.doGet(context.Background(), srv.URL, "test-token")
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if len(arrivals) != requests {
+		t.Fatalf("Expected %d requests, got %d", requests, len(arrivals))
+	}
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].Before(arrivals[j]) })
+
+	// At 20 requests per second with no burst, requests are ~50ms apart
+	if span := arrivals[requests-1].Sub(arrivals[0]); span < 200*time.Millisecond {
+		t.Errorf("Expected requests to be paced over at least 200ms, got %v", span)
+	}
+	if s.RateLimitWait() < 200*time.Millisecond {
+		t.Errorf("Expected the limiter wait to be recorded, got %v", s.RateLimitWait())
+	}
+}
+
+func TestDoGet_RateLimitRespectsDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.SetRateLimit(0.1, 1)
+
+	// Use up the burst, the next request would wait 10 seconds
+	resp, err := s.doGet(context.Background(), srv.URL, "test-token")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := s.doGet(ctx, srv.URL, "test-token"); err == nil {
+		t.Fatal("Expected the rate limiter to fail the request")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to fail without waiting out the limiter, took %v", elapsed)
+	}
+}
+
+func TestSetRateLimit_Disabled(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.SetRateLimit(0, 0)
+
+	for i := 0; i < 100; i++ {
+		if err := s.waitForRateLimit(context.Background(), "test"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if s.RateLimitWait() > 100*time.Millisecond {
+		t.Errorf("Expected no pacing when disabled, waited %v", s.RateLimitWait())
+	}
+}
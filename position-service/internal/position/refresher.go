@@ -0,0 +1,151 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/trade-sonic/logging"
+)
+
+// maxRefreshBackoffFactor caps how far the refresh interval stretches while rate limited
+const maxRefreshBackoffFactor = 8
+
+// RefreshStatus reports the outcome of background refreshes for one account
+type RefreshStatus struct {
+	AccountType         AccountType `json:"account_type"`
+	AccountID           string      `json:"account_id"`
+	AccountLabel        string      `json:"account_label"`
+	LastSuccess         time.Time   `json:"last_success"`
+	LastError           string      `json:"last_error,omitempty"`
+	LastErrorAt         time.Time   `json:"last_error_at"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+}
+
+// Healthy reports whether the most recent refresh succeeded
+func (r RefreshStatus) Healthy() bool {
+	return r.ConsecutiveFailures == 0
+}
+
+// StartRefresher refreshes the positions of every configured account in the
+// background so reads are always served from a warm cache. A failed refresh
+// keeps the previous cache entry and is recorded in RefreshStatus. While the
+// broker rate limits us the interval is doubled, up to a fixed cap.
+func (s *Service) StartRefresher(accountType AccountType, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.refreshCancel = cancel
+	s.refreshDone = make(chan struct{})
+
+	s.statusMutex.Lock()
+	s.refreshInterval = interval
+	s.refreshLastRound = time.Now()
+	s.statusMutex.Unlock()
+
+	go s.runRefresher(ctx, accountType, interval)
+}
+
+// Stop stops the background refresher, if running, and waits for it to exit
+func (s *Service) Stop() {
+	if s.refreshCancel == nil {
+		return
+	}
+	s.refreshCancel()
+	<-s.refreshDone
+	s.refreshCancel = nil
+}
+
+// RefreshStatus returns the background refresh status of each account
+func (s *Service) RefreshStatus() []RefreshStatus {
+	s.statusMutex.RLock()
+	defer s.statusMutex.RUnlock()
+
+	statuses := make([]RefreshStatus, 0, len(s.refreshStatus))
+	for _, account := range s.Accounts() {
+		for key, status := range s.refreshStatus {
+			if key.accountID == account.ID {
+				statuses = append(statuses, *status)
+			}
+		}
+	}
+	return statuses
+}
+
+func (s *Service) runRefresher(ctx context.Context, accountType AccountType, interval time.Duration) {
+	defer close(s.refreshDone)
+
+	// Warm the cache immediately, then refresh on the interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	delay := interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// Each round logs under its own request ID
+		rateLimited := s.refreshAccounts(logging.WithRequestID(ctx, logging.NewRequestID()), accountType)
+		s.statusMutex.Lock()
+		s.refreshLastRound = time.Now()
+		s.statusMutex.Unlock()
+
+		if rateLimited {
+			delay *= 2
+			if delay > interval*maxRefreshBackoffFactor {
+				delay = interval * maxRefreshBackoffFactor
+			}
+			s.logger.WarnContext(ctx, "Robinhood rate limited position refresh, backing off", "delay", delay)
+		} else {
+			delay = interval
+		}
+		timer.Reset(delay)
+	}
+}
+
+// refreshAccounts refreshes every configured account once and reports
+// whether any refresh was rate limited
+func (s *Service) refreshAccounts(ctx context.Context, accountType AccountType) bool {
+	rateLimited := false
+	for _, account := range s.Accounts() {
+		positions, err := s.getPositions(ctx, accountType, account, true)
+		s.recordRefresh(accountType, account, err)
+		if err != nil {
+			s.logger.WarnContext(ctx, "Background position refresh failed", "account", account.Label, "error", err)
+			if errors.Is(err, ErrRateLimited) {
+				rateLimited = true
+			}
+			continue
+		}
+		s.publishPositions(positions)
+	}
+	return rateLimited
+}
+
+func (s *Service) recordRefresh(accountType AccountType, account Account, err error) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	key := cacheKey{accountType: accountType, accountID: account.ID}
+	status, exists := s.refreshStatus[key]
+	if !exists {
+		status = &RefreshStatus{
+			AccountType:  accountType,
+			AccountID:    account.ID,
+			AccountLabel: account.Label,
+		}
+		s.refreshStatus[key] = status
+	}
+
+	now := time.Now()
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorAt = now
+		status.ConsecutiveFailures++
+		return
+	}
+	status.LastSuccess = now
+	status.LastError = ""
+	status.ConsecutiveFailures = 0
+}
@@ -0,0 +1,76 @@
+package position
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitForStatus polls until the refresher has recorded a status for every account
+func waitForStatus(t *testing.T, s *Service, check func([]RefreshStatus) bool) []RefreshStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if statuses := s.RefreshStatus(); check(statuses) {
+			return statuses
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for background refresh")
+	return nil
+}
+
+func TestRefresher_WarmsCache(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	s.StartRefresher(Robinhood, time.Hour)
+	defer s.Stop()
+
+	statuses := waitForStatus(t, s, func(statuses []RefreshStatus) bool {
+		return len(statuses) == 1 && !statuses[0].LastSuccess.IsZero()
+	})
+	if !statuses[0].Healthy() {
+		t.Errorf("Expected a healthy refresh, got %+v", statuses[0])
+	}
+
+	cached, _ := cachedPositions(s, "test-account")
+	if cached == nil || len(cached.Positions) != 2 {
+		t.Fatalf("Expected the cache to be warmed with 2 positions, got %+v", cached)
+	}
+}
+
+func TestRefresher_KeepsCacheOnError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	s := newCachedService(Position{ID: "cached"})
+	s.baseURL = srv.URL
+
+	s.StartRefresher(Robinhood, time.Hour)
+	statuses := waitForStatus(t, s, func(statuses []RefreshStatus) bool {
+		return len(statuses) == 1 && statuses[0].ConsecutiveFailures > 0
+	})
+	s.Stop()
+
+	if statuses[0].Healthy() || statuses[0].LastError == "" {
+		t.Errorf("Expected the failure to be recorded, got %+v", statuses[0])
+	}
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(positions.Positions) != 1 || positions.Positions[0].ID != "cached" {
+		t.Errorf("Expected the previous cache entry to be kept, got %+v", positions.Positions)
+	}
+}
+
+func TestRefresher_StopWithoutStart(t *testing.T) {
+	s := newCachedService()
+	s.Stop()
+}
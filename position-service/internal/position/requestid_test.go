@@ -0,0 +1,157 @@
+package position
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
+	"github.com/trade-sonic/logging/ginlog"
+)
+
+// newRecordingUpstream serves the Robinhood fixtures and records the request
+// IDs it receives
+func newRecordingUpstream(t *testing.T) (*httptest.Server, func() []string) {
+	upstream := newFixtureServer(t, robinhoodFixtures)
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var mu sync.Mutex
+	var ids []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get(logging.RequestIDHeader))
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ids...)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		adopted  bool
+	}{
+		{name: "adopts the caller's ID", incoming: "engine-5c1d", adopted: true},
+		{name: "generates a missing ID"},
+		{name: "replaces an invalid ID", incoming: "forged\nline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, upstreamIDs := newRecordingUpstream(t)
+			var logs bytes.Buffer
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			s.baseURL = srv.URL
+			s.SetLogger(logging.New(&logs, logging.FormatJSON, nil))
+
+			r := gin.New()
+			r.Use(ginlog.RequestIDMiddleware())
+			r.GET("/positions", NewHandler(s).ListPositions)
+
+			req := httptest.NewRequest(http.MethodGet, "/positions?account_type=robinhood", nil)
+			if tt.incoming != "" {
+				req.Header.Set(logging.RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			id := w.Header().Get(logging.RequestIDHeader)
+			if tt.adopted && id != tt.incoming {
+				t.Errorf("Expected the response to echo %q, got %q", tt.incoming, id)
+			}
+			if !tt.adopted && (id == tt.incoming || !logging.ValidRequestID(id)) {
+				t.Errorf("Expected a generated ID, got %q", id)
+			}
+
+			// Every Robinhood request carries the ID
+			ids := upstreamIDs()
+			if len(ids) == 0 {
+				t.Fatal("Expected requests to Robinhood")
+			}
+			for _, upstreamID := range ids {
+				if upstreamID != id {
+					t.Errorf("Expected Robinhood requests to carry %q, got %q", id, upstreamID)
+				}
+			}
+
+			// And so does every line logged while serving the request
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			for _, line := range lines {
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("Expected a JSON log line, got %q", line)
+				}
+				if entry["request_id"] != id {
+					t.Errorf("Expected request_id %q in %v", id, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenClient_SendsRequestID(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(logging.RequestIDHeader)
+		w.Write([]byte(`{"access_token":"test-token"}`))
+	}))
+	defer srv.Close()
+
+	ctx := logging.WithRequestID(context.Background(), "engine-5c1d")
+	if _, err := NewTokenClient(srv.URL).GetToken(ctx, Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if id := <-received; id != "engine-5c1d" {
+		t.Errorf("Expected the token service to receive engine-5c1d, got %q", id)
+	}
+}
+
+func TestErrorResponse_CarriesRequestID(t *testing.T) {
+	r := gin.New()
+	r.Use(ginlog.RequestIDMiddleware())
+	r.GET("/positions", NewHandler(newCachedService()).ListPositions)
+
+	for _, incoming := range []string{"engine-5c1d", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/positions", nil)
+		if incoming != "" {
+			req.Header.Set(logging.RequestIDHeader, incoming)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Expected an error response, got %v", err)
+		}
+		id := w.Header().Get(logging.RequestIDHeader)
+		if incoming != "" && id != incoming {
+			t.Errorf("Expected the response to echo %q, got %q", incoming, id)
+		}
+		if resp.RequestID == "" || resp.RequestID != id {
+			t.Errorf("Expected request_id %q in the error response, got %q", id, resp.RequestID)
+		}
+	}
+}
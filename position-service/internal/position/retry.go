@@ -0,0 +1,222 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/trade-sonic/logging"
+	"github.com/trade-sonic/robinhood"
+)
+
+// RetryPolicy controls how GET requests to the Robinhood API are retried.
+// Network errors, 5xx responses and 429 responses are retried with
+// exponential backoff and jitter, failing with ErrUpstreamUnavailable once
+// exhausted. 401 and 403 responses fail with ErrUnauthorized and other 4xx
+// responses are returned as is.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubled on each retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between two attempts
+	MaxDelay time.Duration
+	// Deadline bounds the total time spent on a request, retries included
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used by NewService
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Deadline:    time.Minute,
+	}
+}
+
+// backoff returns the jittered delay before the given retry (1-based)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Equal jitter keeps at least half the delay while spreading retries
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// SetRetryPolicy replaces the retry policy for Robinhood API requests
+func (s *Service) SetRetryPolicy(policy RetryPolicy) {
+	s.retryPolicy = policy
+}
+
+// RetryCount returns the number of Robinhood API requests retried since the service started
+func (s *Service) RetryCount() int64 {
+	return s.retryCount.Load()
+}
+
+// doGet performs an authenticated GET request against the Robinhood API,
+// retrying transient failures according to the retry policy. The caller
+// must close the body of the returned response.
+func (s *Service) doGet(ctx context.Context, requestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	return s.doRequest(req)
+}
+
+// doRequest sends a bodiless request against the Robinhood API, retrying
+// transient failures according to the retry policy. While the circuit
+// breaker is open it fails with ErrCircuitOpen without sending anything.
+func (s *Service) doRequest(req *http.Request) (*http.Response, error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	// Robinhood ignores the header, it is sent so request dumps correlate
+	logging.SetRequestIDHeader(req)
+	start := time.Now()
+	resp, err := s.doRequestWithRetries(req)
+	s.breaker.record(req.Context(), err)
+	s.shedder.record(req.Context(), time.Since(start), err)
+	return resp, err
+}
+
+// doRequestWithRetries sends a request, retrying transient failures
+// according to the retry policy
+func (s *Service) doRequestWithRetries(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	requestURL := req.URL.String()
+	policy := s.retryPolicy
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+	}
+
+	attempts := 0
+	for {
+		attempts++
+		// Every attempt, retries included, passes through the shared limiter
+		if err := s.waitForRateLimit(ctx, requestURL); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+		}
+		resp, err := s.client.Do(req.Clone(ctx))
+
+		var retryAfter time.Duration
+		switch {
+		case err != nil:
+			// Network error, retried below
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			// Retrying with the same token cannot succeed, the caller refreshes it
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: status %d", ErrUnauthorized, resp.StatusCode)
+		case resp.StatusCode == http.StatusTooManyRequests:
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			err = fmt.Errorf("%w: status %d", ErrRateLimited, resp.StatusCode)
+			resp.Body.Close()
+		case resp.StatusCode >= http.StatusInternalServerError:
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBody))
+			s.logger.DebugContext(ctx, "Robinhood server error", "path", requestPath(requestURL), "status", resp.StatusCode, "body", string(body))
+			err = fmt.Errorf("status %d", resp.StatusCode)
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		if attempts >= policy.MaxAttempts || ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: giving up after %d attempts: %w", ErrUpstreamUnavailable, attempts, err)
+		}
+
+		delay := policy.backoff(attempts)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, fmt.Errorf("%w: giving up after %d attempts, retry deadline exceeded: %w", ErrUpstreamUnavailable, attempts, err)
+		}
+
+		s.retryCount.Add(1)
+		s.logger.WarnContext(ctx, "Retrying Robinhood request", "path", requestPath(requestURL), "attempt", attempts, "delay", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: giving up after %d attempts: %w", ErrUpstreamUnavailable, attempts, errors.Join(err, ctx.Err()))
+		case <-time.After(delay):
+		}
+	}
+}
+
+// maxLoggedBody caps how much of an unexpected response body is logged
+const maxLoggedBody = 4096
+
+// responseError reads an unexpected response into an error. Bodies can echo
+// account data, so the body is only logged at debug level and kept out of
+// the error, which callers log at warn level or above.
+func (s *Service) responseError(ctx context.Context, name string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBody))
+	s.logger.DebugContext(ctx, "Unexpected Robinhood response", "api", name, "status", resp.StatusCode, "body", string(body))
+	return fmt.Errorf("error response from Robinhood %s API, status: %d", name, resp.StatusCode)
+}
+
+// requestPath strips the query, which carries account numbers and option
+// IDs, from a request URL for logging
+func requestPath(requestURL string) string {
+	if u, err := url.Parse(requestURL); err == nil {
+		return u.Path
+	}
+	return ""
+}
+
+// robinhoodDoer sends the requests of the shared Robinhood client through the
+// service's retries and rate limiter
+type robinhoodDoer struct {
+	s *Service
+}
+
+// Do implements robinhood.Doer
+func (d robinhoodDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.s.doRequest(req)
+}
+
+// robinhood returns a Robinhood API client sending requests through the
+// service's retries and rate limiter
+func (s *Service) robinhood() *robinhood.Client {
+	return robinhood.NewClient(robinhood.WithBaseURL(s.baseURL), robinhood.WithDoer(robinhoodDoer{s: s}))
+}
+
+// robinhoodError converts a Robinhood client error. The body of an
+// unexpected response is only logged at debug level, see responseError.
+func (s *Service) robinhoodError(ctx context.Context, name string, err error) error {
+	var apiErr *robinhood.APIError
+	if errors.As(err, &apiErr) {
+		s.logger.DebugContext(ctx, "Unexpected Robinhood response", "api", name, "status", apiErr.StatusCode, "body", string(apiErr.Body))
+		return fmt.Errorf("error response from Robinhood %s API, status: %d", name, apiErr.StatusCode)
+	}
+	return fmt.Errorf("error fetching %s: %w", name, err)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
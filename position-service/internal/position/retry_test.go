@@ -0,0 +1,135 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetryPolicy retries quickly so flaky server tests stay fast
+var fastRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    5 * time.Millisecond,
+	Deadline:    5 * time.Second,
+}
+
+// newFlakyServer fails the first failures requests to each endpoint with the
+// given status before serving the Robinhood fixtures
+func newFlakyServer(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	fixtures := newFixtureServer(t, robinhoodFixtures)
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(status)
+			return
+		}
+		fixtures.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestGetPositions_RetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "bad gateway", status: http.StatusBadGateway},
+		{name: "service unavailable", status: http.StatusServiceUnavailable},
+		{name: "rate limited", status: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newFlakyServer(t, 2, tt.status, nil)
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			s.baseURL = srv.URL
+			s.SetRetryPolicy(fastRetryPolicy)
+
+			positions, err := s.GetPositions(context.Background(), Robinhood)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(positions.Positions) != 2 {
+				t.Errorf("Expected 2 positions, got %d", len(positions.Positions))
+			}
+			if s.RetryCount() != 2 {
+				t.Errorf("Expected 2 retries, got %d", s.RetryCount())
+			}
+		})
+	}
+}
+
+func TestGetPositions_GivesUpAfterMaxAttempts(t *testing.T) {
+	srv, hits := newFlakyServer(t, 100, http.StatusTooManyRequests, nil)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+
+	_, err := s.GetPositions(context.Background(), Robinhood)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("Expected the error to report 3 attempts, got %v", err)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("Expected 3 requests, got %d", hits.Load())
+	}
+}
+
+func TestGetPositions_DoesNotRetryClientErrors(t *testing.T) {
+	srv, hits := newFlakyServer(t, 100, http.StatusNotFound, nil)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+
+	if _, err := s.GetPositions(context.Background(), Robinhood); err == nil {
+		t.Fatal("Expected an error for a 404 response")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected a single request, got %d", hits.Load())
+	}
+	if s.RetryCount() != 0 {
+		t.Errorf("Expected no retries, got %d", s.RetryCount())
+	}
+}
+
+func TestGetPositions_RespectsRetryAfter(t *testing.T) {
+	srv, _ := newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": []string{"1"}})
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+
+	start := time.Now()
+	if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected to wait for Retry-After, retried after %v", elapsed)
+	}
+}
+
+func TestGetPositions_RetryAfterBeyondDeadline(t *testing.T) {
+	srv, hits := newFlakyServer(t, 100, http.StatusTooManyRequests, http.Header{"Retry-After": []string{"120"}})
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+
+	_, err := s.GetPositions(context.Background(), Robinhood)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected to give up without retrying, got %d requests", hits.Load())
+	}
+}
@@ -1,167 +1,528 @@
 package position
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/trade-sonic/robinhood"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
 // Service handles position-related operations
 type Service struct {
 	client        *http.Client
 	tokenService  TokenService
-	positionCache map[AccountType]*PositionList
+	positionCache PositionCache
 	cacheMutex    sync.RWMutex
-	accountID     string // Robinhood account ID
+	accounts      []Account // Robinhood accounts, the first is the primary
+	baseURL       string    // Robinhood API base URL
+	logger        *slog.Logger
+	retryPolicy   RetryPolicy
+	retryCount    atomic.Int64
+
+	// limiter paces every outbound Robinhood request, shared by all callers
+	limiter       *rate.Limiter
+	rateLimitWait atomic.Int64 // Total time spent waiting on the limiter, in nanoseconds
+
+	// breaker stops Robinhood requests during outages, see SetCircuitBreaker
+	breaker *circuitBreaker
+	// shedder tracks Robinhood requests for NewLoadShedder, see SetLoadShedding
+	shedder *loadShedder
+
+	// snapshots records every successfully fetched position list, if set
+	snapshots SnapshotStore
+
+	// mock replaces the broker and token service in mock mode, see NewMockService
+	mock *mockBroker
+
+	// Order history cache, see ListOrders and RealizedPnL
+	orderMutex        sync.Mutex
+	orderCache        map[cacheKey]*orderHistory
+	orderHistoryTTL   time.Duration
+	orderCacheTTL     time.Duration
+	instrumentSymbols map[string]string // Equity instrument URL to symbol
+
+	// Raw broker responses of the last fetch of each account, captured
+	// while debug capture is enabled, see SetDebugCapture
+	debugMutex   sync.Mutex
+	debugCapture bool
+	rawPositions map[string]RawPositions
+
+	// Recently fetched position lists per account, see PositionsDelta
+	historyMutex sync.Mutex
+	history      map[cacheKey][]*PositionList
+	historySize  int
+	deltaEpsilon float64
+
+	// Dividend cache, see Dividends
+	dividendMutex    sync.Mutex
+	dividendCache    map[cacheKey]*dividendHistory
+	dividendCacheTTL time.Duration
+
+	// priceFeed revalues equities at live prices, see SetPriceFeed
+	priceFeed *PriceFeed
+
+	// minMarketValue excludes positions below this market value from
+	// returned lists. Zero disables the filter.
+	minMarketValue float64
+
+	// Option IDs are fetched in batches, see SetOptionBatching
+	optionBatchSize    int
+	optionBatchWorkers int
+
+	// fetches shares one broker fetch between concurrent callers wanting the
+	// positions of the same account, see getPositions
+	fetches singleflight.Group
+
+	// requestTimeout bounds each query, including its broker requests. Zero
+	// leaves only the caller's deadline.
+	requestTimeout time.Duration
+
+	// Background refresher state, see StartRefresher
+	refreshCancel    context.CancelFunc
+	refreshDone      chan struct{}
+	statusMutex      sync.RWMutex
+	refreshStatus    map[cacheKey]*RefreshStatus
+	refreshInterval  time.Duration
+	refreshLastRound time.Time // When the refresher last finished refreshing every account
+
+	// Subscribers to refreshed positions, see SubscribePositions
+	subscriberMutex sync.Mutex
+	subscribers     map[*positionSubscriber]struct{}
+
+	// Deep health check state, see CheckHealth
+	healthMutex     sync.Mutex
+	tokenCheckTTL   time.Duration
+	maxFetchAge     time.Duration
+	lastFetch       time.Time // Last successful position fetch of any account
+	tokenCheckMutex sync.Mutex
+	tokenCheck      *HealthCheck // Cached token check result
+}
+
+// snapshotTimeout bounds how long a fetch waits for its snapshot to be saved
+const snapshotTimeout = 10 * time.Second
+
+// cacheKey identifies the cached positions of a single account
+type cacheKey struct {
+	accountType AccountType
+	accountID   string
 }
 
-// TokenService defines the interface for getting authentication tokens
+// TokenService defines the interface for getting authentication tokens. The
+// label selects one of several logins of an account type, an empty label
+// the default one.
 type TokenService interface {
-	GetToken(accountType AccountType) (string, error)
+	GetToken(ctx context.Context, accountType AccountType, label string) (string, error)
+	// RefreshToken discards the current token and returns a freshly issued one
+	RefreshToken(ctx context.Context, accountType AccountType, label string) (string, error)
+}
+
+// SnapshotStore defines the interface for persisting position history
+type SnapshotStore interface {
+	// SaveSnapshot records the positions of a single account
+	SaveSnapshot(ctx context.Context, positions *PositionList) error
+}
+
+// PositionQuery selects the positions returned by QueryPositions
+type PositionQuery struct {
+	AccountType AccountType
+	// Account is an account number or label. Empty selects the primary
+	// account and AllAccounts merges every configured account.
+	Account string
+	// Refresh bypasses the cache and replaces the cached entries
+	Refresh bool
 }
 
-// NewService creates a new position service
+// NewService creates a new position service for a single primary account.
+// Further accounts can be registered with AddAccount.
 func NewService(tokenService TokenService, accountID string) *Service {
-	return &Service{
+	s := &Service{
 		client: &http.Client{
 			Timeout: time.Second * 30,
 		},
 		tokenService:  tokenService,
-		positionCache: make(map[AccountType]*PositionList),
-		accountID:     accountID,
+		positionCache: newMemoryCache(),
+		refreshStatus: make(map[cacheKey]*RefreshStatus),
+		baseURL:       robinhood.DefaultBaseURL,
+		logger:        slog.Default(),
+		retryPolicy:   DefaultRetryPolicy(),
+		limiter:       rate.NewLimiter(DefaultRateLimit, DefaultRateBurst),
+		breaker:       newCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
+		shedder:       newLoadShedder(DefaultLoadShedConfig()),
+
+		optionBatchSize:    DefaultOptionBatchSize,
+		optionBatchWorkers: DefaultOptionBatchWorkers,
+
+		orderCache:        make(map[cacheKey]*orderHistory),
+		orderHistoryTTL:   DefaultOrderHistoryTTL,
+		orderCacheTTL:     DefaultOrderCacheTTL,
+		instrumentSymbols: make(map[string]string),
+		dividendCache:     make(map[cacheKey]*dividendHistory),
+		dividendCacheTTL:  DefaultDividendCacheTTL,
+		history:           make(map[cacheKey][]*PositionList),
+		historySize:       DefaultDeltaHistorySize,
+		deltaEpsilon:      DefaultDeltaEpsilon,
+		tokenCheckTTL:     DefaultTokenCheckTTL,
+		maxFetchAge:       DefaultMaxFetchAge,
+	}
+	if accountID != "" {
+		s.accounts = append(s.accounts, Account{Label: PrimaryAccountLabel, ID: accountID})
 	}
+	return s
 }
 
-// GetPositions retrieves positions for the specified account type
-func (s *Service) GetPositions(accountType AccountType) (*PositionList, error) {
-	// Check cache first
+// AddAccount registers an additional account under a label. The first
+// account registered on a service without one becomes the primary.
+func (s *Service) AddAccount(label, accountID string) {
+	s.AddAccountWithLogin(label, accountID, "")
+}
+
+// AddAccountWithLogin registers an additional account like AddAccount, whose
+// tokens are requested for the token service account labeled tokenLabel,
+// e.g. when it belongs to another broker login than the primary account
+func (s *Service) AddAccountWithLogin(label, accountID, tokenLabel string) {
+	s.cacheMutex.Lock()
+	s.accounts = append(s.accounts, Account{Label: label, ID: accountID, TokenLabel: tokenLabel})
+	s.cacheMutex.Unlock()
+}
+
+// Accounts returns the configured accounts, primary first
+func (s *Service) Accounts() []Account {
 	s.cacheMutex.RLock()
-	if cachedPositions, exists := s.positionCache[accountType]; exists {
-		// You might want to add cache expiration logic here
-		s.cacheMutex.RUnlock()
-		return cachedPositions, nil
+	defer s.cacheMutex.RUnlock()
+	return append([]Account(nil), s.accounts...)
+}
+
+// SetLogger replaces the service logger. Per-position details are logged at
+// debug level, so they are suppressed unless the logger enables it.
+func (s *Service) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// Logger returns the service logger
+func (s *Service) Logger() *slog.Logger {
+	return s.logger
+}
+
+// SetPositionCache replaces the in-memory position cache, e.g. with one
+// shared by several replicas
+func (s *Service) SetPositionCache(cache PositionCache) {
+	s.positionCache = cache
+}
+
+// SetSnapshotStore records a snapshot of every successfully fetched position
+// list. Snapshots are disabled by default.
+func (s *Service) SetSnapshotStore(store SnapshotStore) {
+	s.snapshots = store
+}
+
+// SetMinMarketValue sets the default market value floor applied to returned
+// positions. Positions are still cached unfiltered.
+func (s *Service) SetMinMarketValue(min float64) {
+	s.cacheMutex.Lock()
+	s.minMarketValue = min
+	s.cacheMutex.Unlock()
+}
+
+// SetRobinhoodBaseURL points the service at another Robinhood API host, e.g.
+// a local stub for integration testing or a debugging proxy. The URL may
+// carry a path prefix that every API path is joined to. Next page links are
+// only followed on this host, so a proxy must rewrite them to itself.
+func (s *Service) SetRobinhoodBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid Robinhood base URL: %w", err)
 	}
-	s.cacheMutex.RUnlock()
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid Robinhood base URL %q: expected an http or https URL", baseURL)
+	}
+	s.baseURL = strings.TrimSuffix(u.String(), "/")
+	return nil
+}
 
-	// Get token for authentication
-	token, err := s.tokenService.GetToken(accountType)
+// robinhoodURL joins an API path to the Robinhood base URL and appends the
+// query parameters, if any
+func (s *Service) robinhoodURL(path string, params url.Values) (string, error) {
+	endpoint, err := url.JoinPath(s.baseURL, path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get token: %w", err)
+		return "", fmt.Errorf("invalid Robinhood URL: %w", err)
+	}
+	if len(params) == 0 {
+		return endpoint, nil
 	}
+	return endpoint + "?" + params.Encode(), nil
+}
 
-	// Fetch positions based on account type
-	var positions *PositionList
-	switch accountType {
-	case Robinhood:
-		positions, err = s.fetchRobinhoodPositions(token)
+// SetRequestTimeout bounds how long a single query, e.g. one HTTP request to
+// the service, may spend fetching from the broker. Zero disables it.
+func (s *Service) SetRequestTimeout(timeout time.Duration) {
+	s.requestTimeout = timeout
+}
+
+// requestContext applies the request timeout to ctx
+func (s *Service) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.requestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.requestTimeout)
+}
+
+// contextError marks err with ErrRequestCanceled or ErrRequestTimeout when
+// ctx ended, so an abandoned request is not reported as a broker failure
+func contextError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", ErrRequestCanceled, err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrRequestTimeout, err)
 	default:
-		return nil, fmt.Errorf("unsupported account type: %s", accountType)
+		return err
+	}
+}
+
+// GetPositions retrieves positions of the primary account for the specified account type
+func (s *Service) GetPositions(ctx context.Context, accountType AccountType) (*PositionList, error) {
+	return s.QueryPositions(ctx, PositionQuery{AccountType: accountType})
+}
+
+// RefreshPositions retrieves positions of the primary account for the
+// specified account type, bypassing the cache and replacing the cached entry
+func (s *Service) RefreshPositions(ctx context.Context, accountType AccountType) (*PositionList, error) {
+	return s.QueryPositions(ctx, PositionQuery{AccountType: accountType, Refresh: true})
+}
+
+// QueryPositions retrieves positions for the account selected by the query.
+// Broker requests are bound to ctx and the request timeout; when either
+// ends first the error wraps ErrRequestCanceled or ErrRequestTimeout.
+func (s *Service) QueryPositions(ctx context.Context, q PositionQuery) (*PositionList, error) {
+	s.cacheMutex.RLock()
+	minMarketValue := s.minMarketValue
+	s.cacheMutex.RUnlock()
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	if q.Account == AllAccounts {
+		positions, err := s.getAllAccountPositions(ctx, q.AccountType, q.Refresh)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		return s.applyLivePrices(positions).FilterByMinMarketValue(minMarketValue), nil
 	}
 
+	account, err := s.resolveAccount(q.Account)
 	if err != nil {
 		return nil, err
 	}
 
-	// Cache the positions
-	s.cacheMutex.Lock()
-	s.positionCache[accountType] = positions
-	s.cacheMutex.Unlock()
+	positions, err := s.getPositionsOrStale(ctx, q.AccountType, account, q.Refresh)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return s.applyLivePrices(positions).FilterByMinMarketValue(minMarketValue), nil
+}
 
-	return positions, nil
+// resolveAccount finds a configured account by label or account number
+func (s *Service) resolveAccount(account string) (Account, error) {
+	s.cacheMutex.RLock()
+	defer s.cacheMutex.RUnlock()
+
+	if len(s.accounts) == 0 {
+		return Account{}, fmt.Errorf("%w: no account ID", ErrNotConfigured)
+	}
+	if account == "" {
+		return s.accounts[0], nil
+	}
+	for _, a := range s.accounts {
+		if a.Label == account || a.ID == account {
+			return a, nil
+		}
+	}
+	return Account{}, fmt.Errorf("%w: %s", ErrUnknownAccount, account)
 }
 
-// fetchRobinhoodPositions fetches positions from Robinhood API
-func (s *Service) fetchRobinhoodPositions(token string) (*PositionList, error) {
-	// Use the account ID from the service configuration
-	if s.accountID == "" {
-		return nil, fmt.Errorf("account ID not configured")
+// getAllAccountPositions fetches every configured account concurrently and
+// merges the results, keeping each account's list under Accounts
+func (s *Service) getAllAccountPositions(ctx context.Context, accountType AccountType, refresh bool) (*PositionList, error) {
+	accounts := s.Accounts()
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("%w: no account ID", ErrNotConfigured)
 	}
 
-	// Use the configured account ID
-	accountID := s.accountID
+	lists := make([]*PositionList, len(accounts))
+	errs := make([]error, len(accounts))
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		wg.Add(1)
+		go func(i int, account Account) {
+			defer wg.Done()
+			lists[i], errs[i] = s.getPositionsOrStale(ctx, accountType, account, refresh)
+		}(i, account)
+	}
+	wg.Wait()
 
-	// Now fetch positions using the account URL with the account ID
-	// Build the URL with query parameters using net/url
-	baseURL := "https://api.robinhood.com/options/positions/"
-	params := url.Values{}
-	params.Add("account_number", accountID)
-	params.Add("nonzero", "true")
+	merged := &PositionList{
+		Positions:   []Position{},
+		AccountID:   AllAccounts,
+		AccountType: accountType,
+	}
+	for i, list := range lists {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("account %s: %w", accounts[i].Label, errs[i])
+		}
+		merged.Positions = append(merged.Positions, list.Positions...)
+		merged.Accounts = append(merged.Accounts, list)
+		merged.Stale = merged.Stale || list.Stale
+		if list.UpdatedAt.After(merged.UpdatedAt) {
+			merged.UpdatedAt = list.UpdatedAt
+		}
+	}
 
-	// Construct the final URL with parameters
-	positionsURL := baseURL + "?" + params.Encode()
-	reqPositions, err := http.NewRequest("GET", positionsURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating positions request: %w", err)
+	return merged, nil
+}
+
+// getPositions returns the cached positions of an account, fetching them if
+// missing or when refresh is set. Failed fetches, cancelled ones included,
+// leave the cache untouched. An unavailable cache is bypassed.
+//
+// Concurrent fetches of an account are deduplicated: callers arriving while
+// a fetch is in flight, refreshes included, wait for it and receive its
+// result or error rather than starting another. The fetch keeps the values
+// of the caller that started it, such as the request ID, but not its
+// cancellation, so callers joining it are not failed when that caller goes
+// away; it is bounded by the request timeout instead. Each caller stops
+// waiting when its own ctx ends.
+func (s *Service) getPositions(ctx context.Context, accountType AccountType, account Account, refresh bool) (*PositionList, error) {
+	// Requests shed by NewLoadShedder do not reach the broker
+	if isCacheOnly(ctx) {
+		return s.getCachedOnly(ctx, accountType, account)
 	}
 
-	// Add authorization header
-	reqPositions.Header.Add("Authorization", "Bearer "+token)
+	// Check cache first
+	if !refresh {
+		cachedPositions, exists, err := s.positionCache.Get(ctx, accountType, account.ID)
+		if err != nil {
+			s.logger.WarnContext(ctx, "Position cache unavailable, fetching from the broker", "account", account.Label, "error", err)
+		} else if exists {
+			return cachedPositions, nil
+		}
+	}
 
-	// Execute the positions request
-	respPositions, err := s.client.Do(reqPositions)
+	key := string(accountType) + "/" + account.ID
+	fetched := s.fetches.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := s.requestContext(context.WithoutCancel(ctx))
+		defer cancel()
+		return s.fetchAndCachePositions(ctx, accountType, account)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-fetched:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*PositionList), nil
+	}
+}
+
+// fetchAndCachePositions fetches the positions of an account, then records,
+// caches and snapshots them
+func (s *Service) fetchAndCachePositions(ctx context.Context, accountType AccountType, account Account) (*PositionList, error) {
+	positions, err := s.fetchPositions(ctx, accountType, account)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching positions: %w", err)
+		return nil, err
+	}
+	positions.AccountLabel = account.Label
+	s.recordFetch()
+	s.recordHistory(accountType, account, positions)
+	s.logger.InfoContext(ctx, "Fetched positions", "account", account.Label, "account_type", accountType, "positions", len(positions.Positions))
+
+	// Cache the positions, even when the caller has gone away meanwhile
+	if err := s.positionCache.Set(context.WithoutCancel(ctx), accountType, account.ID, positions); err != nil {
+		s.logger.WarnContext(ctx, "Failed to cache positions", "account", account.Label, "error", err)
 	}
-	defer respPositions.Body.Close()
 
-	// Check if the response status code is OK
-	if respPositions.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(respPositions.Body)
-		return nil, fmt.Errorf("error response from Robinhood positions API: %s, status: %d", string(body), respPositions.StatusCode)
+	// A failed snapshot must not fail the fetch. Like the cache, it is saved
+	// even when the caller has gone away, keeping the request's values.
+	if s.snapshots != nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), snapshotTimeout)
+		if err := s.snapshots.SaveSnapshot(ctx, positions); err != nil {
+			s.logger.WarnContext(ctx, "Failed to save position snapshot", "account", account.Label, "error", err)
+		}
+		cancel()
 	}
 
-	// Read the response body
-	respBody, err := io.ReadAll(respPositions.Body)
+	return positions, nil
+}
+
+// fetchPositions fetches the positions of an account from its broker, or
+// from the fixture in mock mode
+func (s *Service) fetchPositions(ctx context.Context, accountType AccountType, account Account) (*PositionList, error) {
+	if s.mock != nil {
+		return s.mock.positions(accountType, account)
+	}
+
+	var positions *PositionList
+	err := s.withToken(ctx, accountType, account, func(token string) error {
+		var err error
+		positions, err = s.fetchAccountPositions(ctx, accountType, token, account.ID)
+		return err
+	})
+	return positions, err
+}
+
+// withToken calls fn with a token for the account's login. The token can
+// expire mid-session, so when the broker rejects it fn is retried once with
+// a fresh one.
+func (s *Service) withToken(ctx context.Context, accountType AccountType, account Account, fn func(token string) error) error {
+	token, err := s.tokenService.GetToken(ctx, accountType, account.TokenLabel)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-
-	// Create a new reader from the response body for JSON decoding
-	reader := bytes.NewReader(respBody)
-
-	// Parse the positions response
-	var positionsResp struct {
-		Next     interface{} `json:"next"`
-		Previous interface{} `json:"previous"`
-		Results  []struct {
-			Account                   string `json:"account"`
-			AccountNumber             string `json:"account_number"`
-			AveragePrice              string `json:"average_price"`
-			ChainID                   string `json:"chain_id"`
-			ChainSymbol               string `json:"chain_symbol"`
-			ID                        string `json:"id"`
-			Option                    string `json:"option"`
-			Type                      string `json:"type"`
-			PendingBuyQuantity        string `json:"pending_buy_quantity"`
-			PendingExpiredQuantity    string `json:"pending_expired_quantity"`
-			PendingExpirationQuantity string `json:"pending_expiration_quantity"`
-			PendingExerciseQuantity   string `json:"pending_exercise_quantity"`
-			PendingAssignmentQuantity string `json:"pending_assignment_quantity"`
-			PendingSellQuantity       string `json:"pending_sell_quantity"`
-			Quantity                  string `json:"quantity"`
-			IntradayQuantity          string `json:"intraday_quantity"`
-			IntradayAverageOpenPrice  string `json:"intraday_average_open_price"`
-			CreatedAt                 string `json:"created_at"`
-			ExpirationDate            string `json:"expiration_date"`
-			TradeValueMultiplier      string `json:"trade_value_multiplier"`
-			UpdatedAt                 string `json:"updated_at"`
-			URL                       string `json:"url"`
-			OptionID                  string `json:"option_id"`
-			ClearingRunningQuantity   string `json:"clearing_running_quantity"`
-			ClearingCostBasis         string `json:"clearing_cost_basis"`
-			ClearingDirection         string `json:"clearing_direction"`
-		} `json:"results"`
+		return fmt.Errorf("%w: failed to get token: %w", ErrUpstreamAuth, err)
 	}
 
-	if err := json.NewDecoder(reader).Decode(&positionsResp); err != nil {
-		return nil, fmt.Errorf("error decoding positions response: %w\nRaw response: %s", err, string(respBody))
+	err = fn(token)
+	if errors.Is(err, ErrUnauthorized) {
+		s.logger.WarnContext(ctx, "Broker rejected the access token, refreshing it", "account", account.Label, "error", err)
+		token, err = s.tokenService.RefreshToken(ctx, accountType, account.TokenLabel)
+		if err != nil {
+			return fmt.Errorf("%w: failed to refresh token: %w", ErrUpstreamAuth, err)
+		}
+		err = fn(token)
+	}
+	return err
+}
+
+// fetchAccountPositions fetches the positions of an account from its broker
+func (s *Service) fetchAccountPositions(ctx context.Context, accountType AccountType, token, accountID string) (*PositionList, error) {
+	switch accountType {
+	case Robinhood:
+		return s.fetchRobinhoodPositions(ctx, token, accountID)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
+	}
+}
+
+// fetchRobinhoodPositions fetches positions of an account from Robinhood API
+func (s *Service) fetchRobinhoodPositions(ctx context.Context, token, accountID string) (*PositionList, error) {
+	// Fetch the open option positions of the account, every page of them
+	results, err := s.robinhood().GetOptionPositions(ctx, token, robinhood.OptionPositionQuery{
+		AccountNumber: accountID,
+		NonZero:       true,
+	})
+	if err != nil {
+		return nil, s.robinhoodError(ctx, "positions", err)
 	}
 
 	// Create a list to hold our processed positions
@@ -176,65 +537,100 @@ func (s *Service) fetchRobinhoodPositions(token string) (*PositionList, error) {
 	var optionIDs []string
 
 	// First pass: collect all option IDs
-	for _, posItem := range positionsResp.Results {
+	for _, posItem := range results {
 		// Skip positions with zero quantity
 		quantity, err := strconv.ParseFloat(posItem.Quantity, 64)
-		if err != nil || quantity <= 0 {
+		if err != nil || quantity == 0 {
 			continue
 		}
 
 		optionIDs = append(optionIDs, posItem.OptionID)
 	}
 
-	// Fetch option prices in batch
-	optionPrices, err := s.fetchOptionPrices(optionIDs, token)
+	// Fetch option prices in batches. Positions of failed batches are
+	// flagged as PriceUnavailable below.
+	var rawMarketData *[]robinhood.OptionMarketData
+	if s.debugCaptureEnabled() {
+		rawMarketData = &[]robinhood.OptionMarketData{}
+	}
+	optionPrices, err := s.fetchOptionPrices(ctx, optionIDs, token, rawMarketData)
+	if err != nil {
+		s.logBatchError(ctx, "Error fetching option prices", err)
+	}
+	if rawMarketData != nil {
+		s.recordRawPositions(RawPositions{
+			AccountID:        accountID,
+			CapturedAt:       time.Now(),
+			Positions:        results,
+			OptionMarketData: *rawMarketData,
+		})
+	}
+
+	// Fetch option contract details (strike, call/put) in batches
+	optionInstruments, err := s.fetchOptionInstruments(ctx, optionIDs, token)
 	if err != nil {
-		// Log the error but continue with zero prices
-		fmt.Printf("Error fetching option prices: %v\n", err)
+		// Log the error but continue without contract details
+		s.logBatchError(ctx, "Error fetching option instruments", err)
+	}
+
+	// Missing prices are tolerated above, but not when the request was
+	// cancelled, as they would be cached
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	// Reset option IDs for the second pass
 	optionIDs = []string{}
 
 	// Second pass: process positions with prices
-	for _, posItem := range positionsResp.Results {
+	for _, posItem := range results {
 		// Skip positions with zero quantity
 		quantity, err := strconv.ParseFloat(posItem.Quantity, 64)
-		if err != nil || quantity <= 0 {
+		if err != nil || quantity == 0 {
 			continue
 		}
 
-		// For options, we'll use the chain symbol as the symbol
+		// Robinhood reports short positions with a positive quantity, so the
+		// side comes from the position type. Short quantities are negative.
+		side := Long
+		if posItem.Type == string(Short) || quantity < 0 {
+			side = Short
+		}
+		quantity = math.Abs(quantity)
+		if side == Short {
+			quantity = -quantity
+		}
+
+		// The chain symbol is the underlying, the contract is identified by
+		// its OCC symbol once the instrument details are known
 		symbol := posItem.ChainSymbol
 
-		// Parse the average price
+		// Parse the average price, negative for credit positions
 		averagePrice, err := strconv.ParseFloat(posItem.AveragePrice, 64)
 		if err != nil {
 			averagePrice = 0.0
 		}
+		averagePrice = math.Abs(averagePrice)
 
-		// Parse the cost basis
+		// Parse the cost basis. It is reported as a magnitude, a credit is
+		// premium received so it counts against the cost.
 		costBasis, err := strconv.ParseFloat(posItem.ClearingCostBasis, 64)
 		if err != nil {
-			fmt.Printf("Error parsing cost basis for %s: %v\n", posItem.OptionID, err)
+			s.logger.WarnContext(ctx, "Error parsing cost basis", "option_id", posItem.OptionID, "error", err)
 			costBasis = 0.0
 		}
-
-		// Debug output for cost basis
-		fmt.Printf("  Cost Basis: $%.2f\n", costBasis)
+		costBasis = math.Abs(costBasis)
+		if posItem.ClearingDirection == "credit" {
+			costBasis = -costBasis
+		}
 
 		// Parse timestamps
 		createdAt, _ := time.Parse(time.RFC3339, posItem.CreatedAt)
 		updatedAt, _ := time.Parse(time.RFC3339, posItem.UpdatedAt)
 
 		// Get current price from our price map
-		currentPrice := 0.0
-		if price, ok := optionPrices[posItem.OptionID]; ok {
-			currentPrice = price
-		}
-
-		// Debug output for option price
-		fmt.Printf("Option ID: %s, Symbol: %s, Price: $%.2f\n", posItem.OptionID, symbol, currentPrice)
+		quote, priceAvailable := optionPrices[posItem.OptionID]
+		currentPrice := quote.price
 
 		// Parse the trade value multiplier (typically 100 for options)
 		multiplier, err := strconv.ParseFloat(posItem.TradeValueMultiplier, 64)
@@ -242,41 +638,73 @@ func (s *Service) fetchRobinhoodPositions(token string) (*PositionList, error) {
 			multiplier = 100.0 // Default to standard option multiplier
 		}
 
-		// Calculate market value using current price and quantity
+		// Calculate market value using current price and quantity, negative
+		// for short positions as closing them costs money
 		marketValue := quantity * currentPrice * multiplier
 
-		// Debug output for market value calculation
-		fmt.Printf("  Quantity: %.2f, Multiplier: %.2f, Market Value: $%.2f\n", quantity, multiplier, marketValue)
-
-		// Calculate unrealized P&L
-		unrealizedPnL := marketValue - costBasis
+		// Calculate unrealized P&L, relative to the premium paid or received.
+		// Without a price it is left at zero rather than the whole cost basis.
+		unrealizedPnL := 0.0
 		unrealizedPnLPercent := 0.0
-		if costBasis > 0 {
-			unrealizedPnLPercent = (unrealizedPnL / costBasis) * 100
+		if priceAvailable {
+			unrealizedPnL = marketValue - costBasis
+			if costBasis != 0 {
+				unrealizedPnLPercent = (unrealizedPnL / math.Abs(costBasis)) * 100
+			}
 		}
 
-		// Debug output for P&L
-		fmt.Printf("  Unrealized P&L: $%.2f (%.2f%%)\n", unrealizedPnL, unrealizedPnLPercent)
+		s.logger.DebugContext(ctx, "Computed option position",
+			"option_id", posItem.OptionID,
+			"symbol", symbol,
+			"side", side,
+			"price", currentPrice,
+			"quantity", quantity,
+			"multiplier", multiplier,
+			"cost_basis", costBasis,
+			"market_value", marketValue,
+			"unrealized_pnl", unrealizedPnL,
+			"unrealized_pnl_percent", unrealizedPnLPercent,
+		)
 
 		// Create position object
 		position := Position{
 			ID:                   posItem.ID,
 			AccountID:            accountID,
 			Symbol:               symbol,
+			UnderlyingSymbol:     symbol,
+			ChainID:              posItem.ChainID,
+			Side:                 side,
 			Quantity:             quantity,
 			AveragePrice:         averagePrice,
 			CurrentPrice:         currentPrice,
+			PriceUnavailable:     !priceAvailable,
 			MarketValue:          marketValue,
 			CostBasis:            costBasis,
 			UnrealizedPnL:        unrealizedPnL,
 			UnrealizedPnLPercent: unrealizedPnLPercent,
 			InstrumentURL:        posItem.Option, // Use the option URL instead of instrument
+			ExpirationDate:       posItem.ExpirationDate,
+			Multiplier:           multiplier,
+			Delta:                quote.delta,
 			CreatedAt:            createdAt,
 			UpdatedAt:            updatedAt,
-			// Add additional option-specific fields if needed
-			// You might want to extend your Position struct to include these
-			// ExpirationDate: posItem.ExpirationDate,
-			// OptionType: posItem.Type,
+		}
+
+		// The position's own type is long/short, so call/put and the strike
+		// come from the option instrument
+		if instrument, ok := optionInstruments[posItem.OptionID]; ok {
+			position.OptionType = instrument.optionType
+			position.StrikePrice = instrument.strikePrice
+			if position.ExpirationDate == "" {
+				position.ExpirationDate = instrument.expirationDate
+			}
+
+			occSymbol, err := FormatOCCSymbol(symbol, position.ExpirationDate, position.OptionType, position.StrikePrice)
+			if err != nil {
+				s.logger.WarnContext(ctx, "Error building OCC symbol", "option_id", posItem.OptionID, "error", err)
+			}
+			position.OccSymbol = occSymbol
+			position.OptionSymbol = CompactOCCSymbol(occSymbol)
 		}
 
 		// Add to our list
@@ -286,73 +714,51 @@ func (s *Service) fetchRobinhoodPositions(token string) (*PositionList, error) {
 	return positionList, nil
 }
 
-// fetchOptionPrices fetches current prices for a batch of option IDs
-func (s *Service) fetchOptionPrices(optionIDs []string, token string) (map[string]float64, error) {
-	// If no option IDs, return empty map
-	if len(optionIDs) == 0 {
-		return map[string]float64{}, nil
-	}
-
-	// Build the URL with query parameters
-	baseURL := "https://api.robinhood.com/marketdata/options/"
-	params := url.Values{}
-
-	// Add all option IDs as a comma-separated list
-	params.Add("ids", strings.Join(optionIDs, ","))
-
-	// Construct the final URL with parameters
-	optionsURL := baseURL + "?" + params.Encode()
-
-	// Create a request to get option prices
-	req, err := http.NewRequest("GET", optionsURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating option prices request: %w", err)
-	}
-
-	// Add authorization header
-	req.Header.Add("Authorization", "Bearer "+token)
-
-	// Execute the request
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching option prices: %w", err)
-	}
-	defer resp.Body.Close()
+// optionQuote is the current price of an option contract and its delta,
+// when Robinhood reports one
+type optionQuote struct {
+	price float64
+	delta *float64
+}
 
-	// Check if the response status code is OK
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("error response from Robinhood option prices API: %s, status: %d", string(body), resp.StatusCode)
-	}
+// fetchOptionPrices fetches current quotes for option IDs in concurrent
+// batches. When some batches fail, the quotes of the others are returned
+// with a *BatchError. If raw is not nil, the market data is also appended to
+// it as Robinhood returned it.
+func (s *Service) fetchOptionPrices(ctx context.Context, optionIDs []string, token string, raw *[]robinhood.OptionMarketData) (map[string]optionQuote, error) {
+	var mu sync.Mutex
+	prices := make(map[string]optionQuote)
+	err := s.fetchOptionBatches(ctx, optionIDs, func(ctx context.Context, batch []string) error {
+		batchPrices, marketData, err := s.fetchOptionPriceBatch(ctx, batch, token)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for id, price := range batchPrices {
+			prices[id] = price
+		}
+		if raw != nil {
+			*raw = append(*raw, marketData...)
+		}
+		return nil
+	})
+	return prices, err
+}
 
-	// Read the response body
-	respBody, err := io.ReadAll(resp.Body)
+// fetchOptionPriceBatch fetches current quotes for a single batch of option
+// IDs, along with the market data they were parsed from
+func (s *Service) fetchOptionPriceBatch(ctx context.Context, optionIDs []string, token string) (map[string]optionQuote, []robinhood.OptionMarketData, error) {
+	marketData, err := s.robinhood().GetOptionMarketData(ctx, token, optionIDs)
 	if err != nil {
-		return nil, fmt.Errorf("error reading option prices response body: %w", err)
-	}
-
-	// Create a new reader from the response body for JSON decoding
-	reader := bytes.NewReader(respBody)
-
-	// Parse the option prices response
-	var optionPricesResp struct {
-		Results []struct {
-			AdjustedMarkPrice string `json:"adjusted_mark_price"`
-			InstrumentID      string `json:"instrument_id"`
-			MarkPrice         string `json:"mark_price"`
-			LastTradePrice    string `json:"last_trade_price"`
-		} `json:"results"`
-	}
-
-	if err := json.NewDecoder(reader).Decode(&optionPricesResp); err != nil {
-		return nil, fmt.Errorf("error decoding option prices response: %w", err)
+		return nil, nil, s.robinhoodError(ctx, "option prices", err)
 	}
 
 	// Create a map to hold our option prices
-	prices := make(map[string]float64)
+	prices := make(map[string]optionQuote)
 
 	// Process each option price
-	for _, option := range optionPricesResp.Results {
+	for _, option := range marketData {
 		// Use mark_price as the current price
 		price, err := strconv.ParseFloat(option.MarkPrice, 64)
 		if err != nil {
@@ -368,74 +774,220 @@ func (s *Service) fetchOptionPrices(optionIDs []string, token string) (map[strin
 			}
 		}
 
-		// Debug output for fetched prices
-		fmt.Printf("Fetched price for option ID %s: $%.2f\n", option.InstrumentID, price)
+		s.logger.DebugContext(ctx, "Fetched option price", "option_id", option.InstrumentID, "price", price)
 
-		// Add to our map
-		prices[option.InstrumentID] = price
+		// Add to our map, with the delta if there is one
+		quote := optionQuote{price: price}
+		if delta, err := strconv.ParseFloat(option.Delta, 64); err == nil {
+			quote.delta = &delta
+		}
+		prices[option.InstrumentID] = quote
 	}
 
-	return prices, nil
+	return prices, marketData, nil
 }
 
-// getInstrumentDetails fetches details about an instrument from Robinhood API
-func (s *Service) getInstrumentDetails(instrumentURL string, token string) (string, float64, error) {
-	// Create a request to get instrument details
-	req, err := http.NewRequest("GET", instrumentURL, nil)
-	if err != nil {
-		return "", 0, fmt.Errorf("error creating instrument request: %w", err)
+// logBatchError logs a failed batched fetch. The option IDs of the failed
+// batches identify positions, so they are only logged at debug level.
+func (s *Service) logBatchError(ctx context.Context, msg string, err error) {
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		s.logger.WarnContext(ctx, msg, "failed_batches", len(batchErr.Failed), "batches", batchErr.Batches, "error", err)
+		s.logger.DebugContext(ctx, msg, "option_ids", batchErr.FailedOptionIDs())
+		return
 	}
+	s.logger.WarnContext(ctx, msg, "error", err)
+}
+
+// optionInstrument holds the contract details of an option
+type optionInstrument struct {
+	optionType     OptionType
+	strikePrice    float64
+	expirationDate string
+}
+
+// fetchOptionInstruments fetches contract details for option IDs in
+// concurrent batches. When some batches fail, the details of the others are
+// returned with a *BatchError.
+func (s *Service) fetchOptionInstruments(ctx context.Context, optionIDs []string, token string) (map[string]optionInstrument, error) {
+	var mu sync.Mutex
+	instruments := make(map[string]optionInstrument)
+	err := s.fetchOptionBatches(ctx, optionIDs, func(ctx context.Context, batch []string) error {
+		batchInstruments, err := s.fetchOptionInstrumentBatch(ctx, batch, token)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for id, instrument := range batchInstruments {
+			instruments[id] = instrument
+		}
+		return nil
+	})
+	return instruments, err
+}
 
-	// Add authorization header
-	req.Header.Add("Authorization", "Bearer "+token)
+// fetchOptionInstrumentBatch fetches contract details for a single batch of option IDs
+func (s *Service) fetchOptionInstrumentBatch(ctx context.Context, optionIDs []string, token string) (map[string]optionInstrument, error) {
 
-	// Execute the request
-	resp, err := s.client.Do(req)
+	// Build the URL with query parameters
+	params := url.Values{}
+	params.Add("ids", strings.Join(optionIDs, ","))
+	instrumentsURL, err := s.robinhoodURL("/options/instruments/", params)
 	if err != nil {
-		return "", 0, fmt.Errorf("error fetching instrument details: %w", err)
+		return nil, err
+	}
+
+	// Execute the request, retrying transient failures
+	resp, err := s.doGet(ctx, instrumentsURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching option instruments: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Check if the response status code is OK
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("error response from Robinhood instrument API: %s, status: %d", string(body), resp.StatusCode)
+		return nil, s.responseError(ctx, "option instruments", resp)
 	}
 
-	// Parse the instrument response
-	var instrumentResp struct {
-		Symbol    string `json:"symbol"`
-		Name      string `json:"name"`
-		QuoteURL  string `json:"quote"`
-		Tradeable bool   `json:"tradeable"`
+	// Parse the option instruments response
+	var instrumentsResp struct {
+		Results []struct {
+			ID             string `json:"id"`
+			StrikePrice    string `json:"strike_price"`
+			ExpirationDate string `json:"expiration_date"`
+			Type           string `json:"type"`
+		} `json:"results"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&instrumentResp); err != nil {
-		return "", 0, fmt.Errorf("error decoding instrument response: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(&instrumentsResp); err != nil {
+		return nil, fmt.Errorf("error decoding option instruments response: %w", err)
 	}
 
-	// Now get the current price using the quote URL
-	currentPrice, err := s.getCurrentPrice(instrumentResp.QuoteURL, token)
-	if err != nil {
-		return instrumentResp.Symbol, 0, fmt.Errorf("error getting current price: %w", err)
+	instruments := make(map[string]optionInstrument)
+	for _, item := range instrumentsResp.Results {
+		strikePrice, err := strconv.ParseFloat(item.StrikePrice, 64)
+		if err != nil {
+			s.logger.WarnContext(ctx, "Error parsing strike price", "option_id", item.ID, "error", err)
+		}
+
+		instruments[item.ID] = optionInstrument{
+			optionType:     OptionType(item.Type),
+			strikePrice:    strikePrice,
+			expirationDate: item.ExpirationDate,
+		}
 	}
 
-	return instrumentResp.Symbol, currentPrice, nil
+	return instruments, nil
 }
 
-// getCurrentPrice fetches the current price of an instrument from Robinhood API
-func (s *Service) getCurrentPrice(quoteURL string, token string) (float64, error) {
-	// Create a request to get quote details
-	req, err := http.NewRequest("GET", quoteURL, nil)
-	if err != nil {
-		return 0, fmt.Errorf("error creating quote request: %w", err)
+// instrumentDetails holds the details of an equity instrument
+type instrumentDetails struct {
+	symbol    string
+	name      string
+	tradeable bool
+}
+
+// getInstrumentDetails fetches details about equity instruments from
+// Robinhood API, in concurrent batches of instrument IDs rather than one
+// request per instrument. Symbols are cached with those of instrumentSymbol,
+// so cached instruments return their symbol without being fetched. When some
+// batches fail, the details of the others are returned with a *BatchError.
+func (s *Service) getInstrumentDetails(ctx context.Context, instrumentURLs []string, token string) (map[string]instrumentDetails, error) {
+	details := make(map[string]instrumentDetails, len(instrumentURLs))
+	urlsByID := make(map[string]string)
+	var ids []string
+
+	s.orderMutex.Lock()
+	for _, instrumentURL := range instrumentURLs {
+		if symbol, ok := s.instrumentSymbols[instrumentURL]; ok {
+			details[instrumentURL] = instrumentDetails{symbol: symbol}
+			continue
+		}
+		id := instrumentID(instrumentURL)
+		if id == "" {
+			s.orderMutex.Unlock()
+			return nil, fmt.Errorf("invalid instrument URL: %q", instrumentURL)
+		}
+		if _, ok := urlsByID[id]; !ok {
+			urlsByID[id] = instrumentURL
+			ids = append(ids, id)
+		}
 	}
+	s.orderMutex.Unlock()
+
+	var mu sync.Mutex
+	err := s.fetchOptionBatches(ctx, ids, func(ctx context.Context, batch []string) error {
+		params := url.Values{}
+		params.Add("ids", strings.Join(batch, ","))
+		instrumentsURL, err := s.robinhoodURL("/instruments/", params)
+		if err != nil {
+			return err
+		}
 
-	// Add authorization header
-	req.Header.Add("Authorization", "Bearer "+token)
+		// Execute the request, retrying transient failures
+		resp, err := s.doGet(ctx, instrumentsURL, token)
+		if err != nil {
+			return fmt.Errorf("error fetching instrument details: %w", err)
+		}
+		defer resp.Body.Close()
 
-	// Execute the request
-	resp, err := s.client.Do(req)
+		// Check if the response status code is OK
+		if resp.StatusCode != http.StatusOK {
+			return s.responseError(ctx, "instruments", resp)
+		}
+
+		// Unknown IDs come back as null results
+		var instrumentsResp struct {
+			Results []*struct {
+				ID        string `json:"id"`
+				Symbol    string `json:"symbol"`
+				Name      string `json:"name"`
+				Tradeable bool   `json:"tradeable"`
+			} `json:"results"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&instrumentsResp); err != nil {
+			return fmt.Errorf("error decoding instruments response: %w", err)
+		}
+
+		for _, item := range instrumentsResp.Results {
+			if item == nil {
+				continue
+			}
+			instrumentURL, ok := urlsByID[item.ID]
+			if !ok {
+				continue
+			}
+			mu.Lock()
+			details[instrumentURL] = instrumentDetails{symbol: item.Symbol, name: item.Name, tradeable: item.Tradeable}
+			mu.Unlock()
+			s.orderMutex.Lock()
+			s.instrumentSymbols[instrumentURL] = item.Symbol
+			s.orderMutex.Unlock()
+		}
+		return nil
+	})
+	return details, err
+}
+
+// instrumentID returns the ID at the end of a Robinhood instrument URL, or
+// an empty string if there is none
+func instrumentID(instrumentURL string) string {
+	u, err := url.Parse(instrumentURL)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[len(segments)-2] != "instruments" {
+		return ""
+	}
+	return segments[len(segments)-1]
+}
+
+// getCurrentPrice fetches the current price of an instrument from Robinhood API
+func (s *Service) getCurrentPrice(ctx context.Context, quoteURL string, token string) (float64, error) {
+	// Execute the request, retrying transient failures
+	resp, err := s.doGet(ctx, quoteURL, token)
 	if err != nil {
 		return 0, fmt.Errorf("error fetching quote details: %w", err)
 	}
@@ -443,8 +995,7 @@ func (s *Service) getCurrentPrice(quoteURL string, token string) (float64, error
 
 	// Check if the response status code is OK
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("error response from Robinhood quote API: %s, status: %d", string(body), resp.StatusCode)
+		return 0, s.responseError(ctx, "quote", resp)
 	}
 
 	// Parse the quote response
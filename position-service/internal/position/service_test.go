@@ -0,0 +1,682 @@
+package position
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/trade-sonic/logging"
+)
+
+// stubTokenService returns a fixed token without calling the token service.
+// RefreshToken returns refreshedToken, or token when it is empty.
+type stubTokenService struct {
+	token          string
+	refreshedToken string
+	err            error
+	refreshes      int
+	labels         []string // Labels tokens were requested for, in order
+}
+
+func (s *stubTokenService) GetToken(ctx context.Context, accountType AccountType, label string) (string, error) {
+	s.labels = append(s.labels, label)
+	return s.token, s.err
+}
+
+func (s *stubTokenService) RefreshToken(ctx context.Context, accountType AccountType, label string) (string, error) {
+	s.labels = append(s.labels, label)
+	s.refreshes++
+	if s.refreshedToken != "" {
+		return s.refreshedToken, s.err
+	}
+	return s.token, s.err
+}
+
+func newCachedService(positions ...Position) *Service {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	cachePositions(s, &PositionList{
+		Positions:   positions,
+		AccountID:   "test-account",
+		AccountType: Robinhood,
+		UpdatedAt:   time.Now(),
+	})
+	return s
+}
+
+// cachePositions stores a position list in the service cache under its account
+func cachePositions(s *Service, positions *PositionList) {
+	s.positionCache.Set(context.Background(), positions.AccountType, positions.AccountID, positions)
+}
+
+// cachedPositions returns the cached Robinhood positions of an account
+func cachedPositions(s *Service, accountID string) (*PositionList, bool) {
+	positions, ok, _ := s.positionCache.Get(context.Background(), Robinhood, accountID)
+	return positions, ok
+}
+
+// newFixtureServer serves Robinhood API responses from testdata, keyed by
+// request path. Later pages of a list are keyed by path and cursor, e.g.
+// /orders/?cursor=2, and {{base_url}} in a fixture is replaced by the URL the
+// request was sent to, a proxy's when one forwards it with its Host header
+// and X-Forwarded-Prefix, the way a proxy rewrites next page links.
+func newFixtureServer(t *testing.T, fixtures map[string]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		key := r.URL.Path
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			key += "?cursor=" + cursor
+		}
+		name, ok := fixtures[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Errorf("failed to read fixture %s: %v", name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes.ReplaceAll(data, []byte("{{base_url}}"), []byte("http://"+r.Host+r.Header.Get("X-Forwarded-Prefix"))))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// robinhoodFixtures maps the Robinhood endpoints used for option positions to their fixtures
+var robinhoodFixtures = map[string]string{
+	"/options/positions/":   "options_positions.json",
+	"/options/instruments/": "options_instruments.json",
+	"/marketdata/options/":  "marketdata_options.json",
+}
+
+func TestGetPositions_OptionDetails(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The zero-quantity position is skipped
+	if len(positions.Positions) != 2 {
+		t.Fatalf("Expected 2 positions, got %d", len(positions.Positions))
+	}
+
+	tests := []struct {
+		symbol         string
+		expirationDate string
+		optionType     OptionType
+		strikePrice    float64
+		marketValue    float64
+		occSymbol      string
+		optionSymbol   string
+	}{
+		{symbol: "AAPL", expirationDate: "2025-06-20", optionType: Call, strikePrice: 200, marketValue: 600, occSymbol: "AAPL  250620C00200000", optionSymbol: "AAPL250620C00200000"},
+		{symbol: "MSFT", expirationDate: "2025-04-17", optionType: Put, strikePrice: 400, marketValue: 100, occSymbol: "MSFT  250417P00400000", optionSymbol: "MSFT250417P00400000"},
+	}
+
+	for i, tt := range tests {
+		p := positions.Positions[i]
+		if p.Symbol != tt.symbol {
+			t.Errorf("Expected symbol %s, got %s", tt.symbol, p.Symbol)
+		}
+		if p.UnderlyingSymbol != tt.symbol {
+			t.Errorf("Expected underlying symbol %s, got %s", tt.symbol, p.UnderlyingSymbol)
+		}
+		if p.OccSymbol != tt.occSymbol {
+			t.Errorf("%s: expected OCC symbol %q, got %q", tt.symbol, tt.occSymbol, p.OccSymbol)
+		}
+		if p.OptionSymbol != tt.optionSymbol {
+			t.Errorf("%s: expected option symbol %q, got %q", tt.symbol, tt.optionSymbol, p.OptionSymbol)
+		}
+		if p.ExpirationDate != tt.expirationDate {
+			t.Errorf("%s: expected expiration %s, got %s", tt.symbol, tt.expirationDate, p.ExpirationDate)
+		}
+		if p.OptionType != tt.optionType {
+			t.Errorf("%s: expected option type %s, got %s", tt.symbol, tt.optionType, p.OptionType)
+		}
+		if p.StrikePrice != tt.strikePrice {
+			t.Errorf("%s: expected strike %.2f, got %.2f", tt.symbol, tt.strikePrice, p.StrikePrice)
+		}
+		if p.Multiplier != 100 {
+			t.Errorf("%s: expected multiplier 100, got %.2f", tt.symbol, p.Multiplier)
+		}
+		if p.MarketValue != tt.marketValue {
+			t.Errorf("%s: expected market value %.2f, got %.2f", tt.symbol, tt.marketValue, p.MarketValue)
+		}
+	}
+}
+
+func TestGetPositions_CreditPositions(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{
+		"/options/positions/":   "options_positions_credit.json",
+		"/options/instruments/": "options_instruments.json",
+		"/marketdata/options/":  "marketdata_options_credit.json",
+	})
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(positions.Positions) != 3 {
+		t.Fatalf("Expected 3 positions, got %d", len(positions.Positions))
+	}
+
+	tests := []struct {
+		id            string
+		side          PositionSide
+		quantity      float64
+		averagePrice  float64
+		marketValue   float64
+		costBasis     float64
+		unrealizedPnL float64
+		pnlPercent    float64
+	}{
+		// The short leg of a put credit spread: 350 received, 200 to close
+		{id: "pos-tsla-short-put", side: Short, quantity: -1, averagePrice: 350, marketValue: -200, costBasis: -350, unrealizedPnL: 150, pnlPercent: 42.857143},
+		{id: "pos-tsla-long-put", side: Long, quantity: 1, averagePrice: 150, marketValue: 80, costBasis: 150, unrealizedPnL: -70, pnlPercent: -46.666667},
+		// A short call that moved against us: 100 received, 250 to close
+		{id: "pos-nvda-short-call", side: Short, quantity: -2, averagePrice: 50, marketValue: -250, costBasis: -100, unrealizedPnL: -150, pnlPercent: -150},
+	}
+
+	for i, tt := range tests {
+		p := positions.Positions[i]
+		if p.ID != tt.id {
+			t.Fatalf("Expected position %d to be %s, got %s", i, tt.id, p.ID)
+		}
+		if p.Side != tt.side || p.Quantity != tt.quantity || p.AveragePrice != tt.averagePrice {
+			t.Errorf("%s: expected %s %v at %v, got %s %v at %v", tt.id, tt.side, tt.quantity, tt.averagePrice, p.Side, p.Quantity, p.AveragePrice)
+		}
+		if !almostEqual(p.MarketValue, tt.marketValue) || !almostEqual(p.CostBasis, tt.costBasis) {
+			t.Errorf("%s: expected market value %v and cost basis %v, got %v and %v", tt.id, tt.marketValue, tt.costBasis, p.MarketValue, p.CostBasis)
+		}
+		if !almostEqual(p.UnrealizedPnL, tt.unrealizedPnL) || !almostEqual(p.UnrealizedPnLPercent, tt.pnlPercent) {
+			t.Errorf("%s: expected unrealized P&L %v (%v%%), got %v (%v%%)", tt.id, tt.unrealizedPnL, tt.pnlPercent, p.UnrealizedPnL, p.UnrealizedPnLPercent)
+		}
+	}
+}
+
+func TestPosition_EquityOmitsOptionFields(t *testing.T) {
+	data, err := json.Marshal(Position{ID: "equity", Symbol: "AAPL"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, field := range []string{"underlying_symbol", "occ_symbol", "expiration_date", "option_type", "strike_price", "multiplier"} {
+		if strings.Contains(string(data), field) {
+			t.Errorf("Expected %s to be omitted for equities, got %s", field, data)
+		}
+	}
+}
+
+func TestGetPositions_MinMarketValue(t *testing.T) {
+	small := Position{ID: "small", Symbol: "AAPL", MarketValue: 5}
+	large := Position{ID: "large", Symbol: "MSFT", MarketValue: 500}
+
+	tests := []struct {
+		name           string
+		minMarketValue float64
+		expectedIDs    []string
+	}{
+		{name: "filter disabled", minMarketValue: 0, expectedIDs: []string{"small", "large"}},
+		{name: "floor excludes small positions", minMarketValue: 10, expectedIDs: []string{"large"}},
+		{name: "floor is inclusive", minMarketValue: 500, expectedIDs: []string{"large"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCachedService(small, large)
+			s.SetMinMarketValue(tt.minMarketValue)
+
+			positions, err := s.GetPositions(context.Background(), Robinhood)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if len(positions.Positions) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d positions, got %d", len(tt.expectedIDs), len(positions.Positions))
+			}
+			for i, id := range tt.expectedIDs {
+				if positions.Positions[i].ID != id {
+					t.Errorf("Expected position %d to be %s, got %s", i, id, positions.Positions[i].ID)
+				}
+			}
+
+			// The cached list must stay unfiltered
+			cached, _ := cachedPositions(s, "test-account")
+			if len(cached.Positions) != 2 {
+				t.Errorf("Expected cache to keep 2 positions, got %d", len(cached.Positions))
+			}
+		})
+	}
+}
+
+func TestWithToken_UsesAccountTokenLabel(t *testing.T) {
+	tokens := &stubTokenService{token: "test-token"}
+	s := NewService(tokens, "111")
+	s.AddAccountWithLogin("joint", "333", "joint")
+	accounts := s.Accounts()
+
+	// The primary account uses the token service's default account
+	if err := s.withToken(context.Background(), Robinhood, accounts[0], func(string) error { return nil }); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// A rejected token is refreshed for the same login
+	calls := 0
+	err := s.withToken(context.Background(), Robinhood, accounts[1], func(string) error {
+		calls++
+		if calls == 1 {
+			return ErrUnauthorized
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"", "joint", "joint"}
+	if strings.Join(tokens.labels, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected tokens for labels %q, got %q", expected, tokens.labels)
+	}
+}
+
+func TestQueryPositions_MultipleAccounts(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "111")
+	s.AddAccount("ira", "222")
+	cachePositions(s, &PositionList{
+		Positions:    []Position{{ID: "taxable-1", AccountID: "111"}},
+		AccountID:    "111",
+		AccountLabel: PrimaryAccountLabel,
+		AccountType:  Robinhood,
+	})
+	cachePositions(s, &PositionList{
+		Positions:    []Position{{ID: "ira-1", AccountID: "222"}, {ID: "ira-2", AccountID: "222"}},
+		AccountID:    "222",
+		AccountLabel: "ira",
+		AccountType:  Robinhood,
+	})
+
+	tests := []struct {
+		name              string
+		account           string
+		expectedAccountID string
+		expectedCount     int
+	}{
+		{name: "default is primary", account: "", expectedAccountID: "111", expectedCount: 1},
+		{name: "by label", account: "ira", expectedAccountID: "222", expectedCount: 2},
+		{name: "by account number", account: "222", expectedAccountID: "222", expectedCount: 2},
+		{name: "all accounts", account: AllAccounts, expectedAccountID: AllAccounts, expectedCount: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions, err := s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Account: tt.account})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if positions.AccountID != tt.expectedAccountID {
+				t.Errorf("Expected account %s, got %s", tt.expectedAccountID, positions.AccountID)
+			}
+			if len(positions.Positions) != tt.expectedCount {
+				t.Errorf("Expected %d positions, got %d", tt.expectedCount, len(positions.Positions))
+			}
+		})
+	}
+
+	t.Run("all accounts keeps per-account lists", func(t *testing.T) {
+		positions, err := s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Account: AllAccounts})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(positions.Accounts) != 2 {
+			t.Fatalf("Expected 2 account lists, got %d", len(positions.Accounts))
+		}
+		if positions.Accounts[0].AccountLabel != PrimaryAccountLabel || positions.Accounts[1].AccountLabel != "ira" {
+			t.Errorf("Unexpected account lists: %s, %s", positions.Accounts[0].AccountLabel, positions.Accounts[1].AccountLabel)
+		}
+	})
+
+	t.Run("unknown account", func(t *testing.T) {
+		_, err := s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Account: "brokerage"})
+		if !errors.Is(err, ErrUnknownAccount) {
+			t.Errorf("Expected ErrUnknownAccount, got %v", err)
+		}
+	})
+}
+
+func TestGetPositions_DebugLogging(t *testing.T) {
+	tests := []struct {
+		name          string
+		level         slog.Level
+		expectDetails bool
+	}{
+		{name: "suppressed at default level", level: slog.LevelInfo, expectDetails: false},
+		{name: "emitted at debug level", level: slog.LevelDebug, expectDetails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFixtureServer(t, robinhoodFixtures)
+			var buf bytes.Buffer
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			s.baseURL = srv.URL
+			s.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: tt.level})))
+
+			if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			hasDetails := strings.Contains(buf.String(), "opt-aapl-call")
+			if hasDetails != tt.expectDetails {
+				t.Errorf("Expected position details logged=%v, got output: %q", tt.expectDetails, buf.String())
+			}
+		})
+	}
+}
+
+func TestGetPositions_InfoLoggingOmitsDetails(t *testing.T) {
+	// Market data fails with a body echoing the position, instruments with a
+	// server error that is retried
+	fixtures := newFixtureServer(t, robinhoodFixtures)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/marketdata/options/":
+			http.Error(w, `{"detail":"secret-body opt-aapl-call 3.0000"}`, http.StatusBadRequest)
+		case "/options/instruments/":
+			http.Error(w, `{"detail":"secret-body"}`, http.StatusBadGateway)
+		default:
+			fixtures.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var buf bytes.Buffer
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+	s.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	output := buf.String()
+	for _, expected := range []string{"Fetched positions", "Error fetching option prices", "Retrying Robinhood request"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q to be logged, got output: %q", expected, output)
+		}
+	}
+	for _, detail := range []string{"secret-body", "opt-aapl-call", "opt-msft-put", "test-account", "MSFT"} {
+		if strings.Contains(output, detail) {
+			t.Errorf("Expected %q not to be logged at info level, got output: %q", detail, output)
+		}
+	}
+}
+
+func TestGetPositions_RefreshesExpiredToken(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	tokens := &stubTokenService{token: "expired-token", refreshedToken: "test-token"}
+	s := NewService(tokens, "test-account")
+	s.baseURL = srv.URL
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(positions.Positions) != 2 {
+		t.Errorf("Expected 2 positions, got %d", len(positions.Positions))
+	}
+	if tokens.refreshes != 1 {
+		t.Errorf("Expected 1 token refresh, got %d", tokens.refreshes)
+	}
+}
+
+func TestGetPositions_TokenRejectedAfterRefresh(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	tokens := &stubTokenService{token: "expired-token"}
+	s := NewService(tokens, "test-account")
+	s.baseURL = srv.URL
+
+	_, err := s.GetPositions(context.Background(), Robinhood)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Expected ErrUnauthorized, got %v", err)
+	}
+	// The original request is retried exactly once
+	if tokens.refreshes != 1 {
+		t.Errorf("Expected 1 token refresh, got %d", tokens.refreshes)
+	}
+}
+
+// recordingSnapshotStore records saved snapshots and the request IDs they
+// were saved under, failing with err when set
+type recordingSnapshotStore struct {
+	saved      []*PositionList
+	requestIDs []string
+	err        error
+}
+
+func (r *recordingSnapshotStore) SaveSnapshot(ctx context.Context, positions *PositionList) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	r.saved = append(r.saved, positions)
+	r.requestIDs = append(r.requestIDs, logging.RequestID(ctx))
+	return r.err
+}
+
+func TestGetPositions_SavesSnapshots(t *testing.T) {
+	tests := []struct {
+		name     string
+		storeErr error
+	}{
+		{name: "snapshot saved"},
+		{name: "snapshot failure does not fail the fetch", storeErr: errors.New("database unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFixtureServer(t, robinhoodFixtures)
+			snapshots := &recordingSnapshotStore{err: tt.storeErr}
+			s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+			s.baseURL = srv.URL
+			s.SetSnapshotStore(snapshots)
+
+			ctx := logging.WithRequestID(context.Background(), "req-1")
+			if _, err := s.GetPositions(ctx, Robinhood); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			// Served from the cache, so no new snapshot
+			if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if len(snapshots.saved) != 1 {
+				t.Fatalf("Expected 1 snapshot, got %d", len(snapshots.saved))
+			}
+			if snapshots.requestIDs[0] != "req-1" {
+				t.Errorf("Expected the snapshot to be saved under request ID req-1, got %q", snapshots.requestIDs[0])
+			}
+			if len(snapshots.saved[0].Positions) != 2 || snapshots.saved[0].AccountLabel != PrimaryAccountLabel {
+				t.Errorf("Unexpected snapshot: %+v", snapshots.saved[0])
+			}
+		})
+	}
+}
+
+func TestGetPositions_PaginationAndMultiplierFallback(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{
+		"/options/positions/":          "options_positions_paged.json",
+		"/options/positions/?cursor=2": "options_positions_page2.json",
+		"/options/instruments/":        "options_instruments.json",
+		"/marketdata/options/":         "marketdata_options.json",
+	})
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	positions, err := s.GetPositions(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(positions.Positions) != 2 {
+		t.Fatalf("Expected a position from each page, got %d", len(positions.Positions))
+	}
+
+	tests := []struct {
+		id            string
+		multiplier    float64
+		marketValue   float64
+		unrealizedPnL float64
+		pnlPercent    float64
+	}{
+		// No multiplier reported, the standard 100 applies
+		{id: "pos-aapl-call", multiplier: 100, marketValue: 600, unrealizedPnL: 100, pnlPercent: 20},
+		// A mini contract keeps its reported multiplier
+		{id: "pos-msft-put", multiplier: 10, marketValue: 10, unrealizedPnL: -110, pnlPercent: -91.666667},
+	}
+
+	for i, tt := range tests {
+		p := positions.Positions[i]
+		if p.ID != tt.id {
+			t.Fatalf("Expected position %d to be %s, got %s", i, tt.id, p.ID)
+		}
+		if p.Multiplier != tt.multiplier {
+			t.Errorf("%s: expected multiplier %v, got %v", tt.id, tt.multiplier, p.Multiplier)
+		}
+		if !almostEqual(p.MarketValue, tt.marketValue) || !almostEqual(p.UnrealizedPnL, tt.unrealizedPnL) {
+			t.Errorf("%s: expected market value %v and P&L %v, got %v and %v", tt.id, tt.marketValue, tt.unrealizedPnL, p.MarketValue, p.UnrealizedPnL)
+		}
+		if !almostEqual(p.UnrealizedPnLPercent, tt.pnlPercent) {
+			t.Errorf("%s: expected P&L percent %v, got %v", tt.id, tt.pnlPercent, p.UnrealizedPnLPercent)
+		}
+	}
+}
+
+func TestGetPositions_ConcurrentCallersShareFetch(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Slow enough for every caller to arrive while the fetch is in flight
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results": [], "next": null}`))
+	}))
+	defer srv.Close()
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	if err := s.SetRobinhoodBaseURL(srv.URL); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	const callers = 10
+	results := make([]*PositionList, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Forced refreshes join the in-flight fetch too
+			results[i], errs[i] = s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Refresh: i%2 == 1})
+		}(i)
+	}
+	wg.Wait()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", got)
+	}
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Caller %d: expected no error, got %v", i, errs[i])
+		}
+		if results[i].AccountID != "test-account" {
+			t.Errorf("Caller %d: expected account test-account, got %q", i, results[i].AccountID)
+		}
+	}
+}
+
+func TestGetPositions_CancelledLeaderDoesNotFailJoinedCallers(t *testing.T) {
+	var requests atomic.Int32
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		arrived <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results": [], "next": null}`))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	if err := s.SetRobinhoodBaseURL(srv.URL); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The leader starts the fetch, then its client disconnects
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := s.QueryPositions(leaderCtx, PositionQuery{AccountType: Robinhood})
+		leaderErr <- err
+	}()
+	<-arrived
+
+	type result struct {
+		positions *PositionList
+		err       error
+	}
+	joined := make(chan result, 1)
+	go func() {
+		positions, err := s.QueryPositions(context.Background(), PositionQuery{AccountType: Robinhood, Refresh: true})
+		joined <- result{positions, err}
+	}()
+	// Give the second caller time to join the in-flight fetch
+	time.Sleep(50 * time.Millisecond)
+
+	cancelLeader()
+	select {
+	case err := <-leaderErr:
+		if !errors.Is(err, ErrRequestCanceled) {
+			t.Errorf("Expected ErrRequestCanceled for the leader, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the cancelled leader")
+	}
+
+	release <- struct{}{}
+	select {
+	case r := <-joined:
+		if r.err != nil {
+			t.Fatalf("Expected the joined caller to get the positions, got %v", r.err)
+		}
+		if r.positions.AccountID != "test-account" {
+			t.Errorf("Expected account test-account, got %q", r.positions.AccountID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the joined caller")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", got)
+	}
+}
@@ -0,0 +1,229 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultShedWindow is how far back the load shedder looks at Robinhood
+	// requests
+	DefaultShedWindow = 30 * time.Second
+	// DefaultShedMinRequests is how many Robinhood requests the window needs
+	// before the load shedder judges them, so a single failure sheds nothing
+	DefaultShedMinRequests = 10
+)
+
+// ErrOverloaded is returned for requests shed while Robinhood is failing or
+// slow and no cached positions could be served instead
+var ErrOverloaded = errors.New("overloaded")
+
+// LoadShedConfig configures the load shedder. Shedding is disabled while
+// both MaxErrorRate and MaxLatency are zero.
+type LoadShedConfig struct {
+	// Window is how far back Robinhood requests are considered
+	Window time.Duration
+	// MinRequests is how many Robinhood requests the window needs before
+	// anything is shed
+	MinRequests int
+	// MaxErrorRate sheds while the fraction of failed Robinhood requests in
+	// the window reaches it, e.g. 0.5. Zero disables the check.
+	MaxErrorRate float64
+	// MaxLatency sheds while the average Robinhood request latency in the
+	// window reaches it. Zero disables the check.
+	MaxLatency time.Duration
+	// ServeStale answers shed requests from the position cache, flagged as
+	// stale, instead of failing them
+	ServeStale bool
+}
+
+// DefaultLoadShedConfig returns a configuration with shedding disabled
+func DefaultLoadShedConfig() LoadShedConfig {
+	return LoadShedConfig{
+		Window:      DefaultShedWindow,
+		MinRequests: DefaultShedMinRequests,
+	}
+}
+
+// Enabled reports whether any shedding threshold is set
+func (c LoadShedConfig) Enabled() bool {
+	return c.MaxErrorRate > 0 || c.MaxLatency > 0
+}
+
+// upstreamSample is the outcome of a single Robinhood request
+type upstreamSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// loadShedder tracks recent Robinhood requests and decides whether new
+// position requests are shed
+type loadShedder struct {
+	mu      sync.Mutex
+	config  LoadShedConfig
+	samples []upstreamSample // Oldest first
+	shed    int64            // Requests shed since the service started
+	now     func() time.Time
+}
+
+func newLoadShedder(config LoadShedConfig) *loadShedder {
+	return &loadShedder{config: config, now: time.Now}
+}
+
+// record records the outcome of a Robinhood request. As for the circuit
+// breaker, only ErrUpstreamUnavailable counts as a failure and requests
+// abandoned by their caller are ignored.
+func (l *loadShedder) record(ctx context.Context, latency time.Duration, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.config.Enabled() {
+		return
+	}
+	l.samples = append(l.samples, upstreamSample{
+		at:      l.now(),
+		latency: latency,
+		failed:  errors.Is(err, ErrUpstreamUnavailable),
+	})
+	l.prune()
+}
+
+// prune drops the samples older than the window, must be called with l.mu
+// held
+func (l *loadShedder) prune() {
+	cutoff := l.now().Add(-l.config.Window)
+	i := 0
+	for i < len(l.samples) && l.samples[i].at.Before(cutoff) {
+		i++
+	}
+	l.samples = l.samples[i:]
+}
+
+// overloaded returns why new requests should be shed, or "" if they should
+// not
+func (l *loadShedder) overloaded() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.config.Enabled() {
+		return ""
+	}
+	l.prune()
+	if len(l.samples) == 0 || len(l.samples) < l.config.MinRequests {
+		return ""
+	}
+
+	var failed int
+	var latency time.Duration
+	for _, sample := range l.samples {
+		if sample.failed {
+			failed++
+		}
+		latency += sample.latency
+	}
+	errorRate := float64(failed) / float64(len(l.samples))
+	average := latency / time.Duration(len(l.samples))
+	switch {
+	case l.config.MaxErrorRate > 0 && errorRate >= l.config.MaxErrorRate:
+		return fmt.Sprintf("%.0f%% of %d Robinhood requests failed", errorRate*100, len(l.samples))
+	case l.config.MaxLatency > 0 && average >= l.config.MaxLatency:
+		return fmt.Sprintf("average Robinhood latency %s over %d requests", average.Round(time.Millisecond), len(l.samples))
+	default:
+		return ""
+	}
+}
+
+// SetLoadShedding configures the load shedder installed by NewLoadShedder.
+// Samples recorded under the previous configuration are dropped.
+func (s *Service) SetLoadShedding(config LoadShedConfig) {
+	s.shedder.mu.Lock()
+	defer s.shedder.mu.Unlock()
+	s.shedder.config = config
+	s.shedder.samples = nil
+}
+
+// ShedRequest reports why a new request is shed, or "" when it is not, with
+// the load shedding configuration. Shed requests are counted.
+func (s *Service) ShedRequest() (reason string, config LoadShedConfig) {
+	reason = s.shedder.overloaded()
+	s.shedder.mu.Lock()
+	defer s.shedder.mu.Unlock()
+	if reason != "" {
+		s.shedder.shed++
+	}
+	return reason, s.shedder.config
+}
+
+// ShedCount returns how many requests the load shedder has shed
+func (s *Service) ShedCount() int64 {
+	s.shedder.mu.Lock()
+	defer s.shedder.mu.Unlock()
+	return s.shedder.shed
+}
+
+// cacheOnlyKey marks contexts of shed requests that may only be answered
+// from the position cache
+type cacheOnlyKey struct{}
+
+// isCacheOnly reports whether ctx belongs to a shed request
+func isCacheOnly(ctx context.Context) bool {
+	cacheOnly, _ := ctx.Value(cacheOnlyKey{}).(bool)
+	return cacheOnly
+}
+
+// getCachedOnly returns the cached positions of an account flagged as stale,
+// and ErrOverloaded when there are none
+func (s *Service) getCachedOnly(ctx context.Context, accountType AccountType, account Account) (*PositionList, error) {
+	cached, exists, err := s.positionCache.Get(ctx, accountType, account.ID)
+	if err != nil || !exists {
+		return nil, fmt.Errorf("%w: no cached positions for account %s", ErrOverloaded, account.Label)
+	}
+	stale := *cached
+	stale.Stale = true
+	return &stale, nil
+}
+
+// LoadShedSource decides which requests NewLoadShedder sheds, implemented
+// by *Service
+type LoadShedSource interface {
+	ShedRequest() (reason string, config LoadShedConfig)
+	Logger() *slog.Logger
+}
+
+// NewLoadShedder returns middleware shedding requests while recent Robinhood
+// requests fail or are slow beyond the thresholds set with SetLoadShedding.
+// Shed requests are answered with a 503 right away, or from the position
+// and order caches when ServeStale is set, rather than queueing for a broker
+// that is not keeping up.
+func NewLoadShedder(source LoadShedSource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reason, config := source.ShedRequest()
+		if reason == "" {
+			c.Next()
+			return
+		}
+
+		logger := source.Logger()
+		if config.ServeStale {
+			logger.WarnContext(c.Request.Context(), "Shedding load, serving cached positions", "path", c.Request.URL.Path, "reason", reason)
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), cacheOnlyKey{}, true))
+			c.Next()
+			return
+		}
+
+		logger.WarnContext(c.Request.Context(), "Shedding load", "path", c.Request.URL.Path, "reason", reason)
+		c.Header("Retry-After", strconv.Itoa(int(config.Window.Seconds())))
+		writeError(c, http.StatusServiceUnavailable, CodeOverloaded, "service overloaded, try again later")
+		c.Abort()
+	}
+}
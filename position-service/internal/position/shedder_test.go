@@ -0,0 +1,213 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newShedRouter serves the position endpoints through the load shedder
+func newShedRouter(s *Service) *gin.Engine {
+	r := gin.New()
+	h := NewHandler(s)
+	shedder := NewLoadShedder(s)
+	r.GET("/positions", shedder, h.ListPositions)
+	r.GET("/positions/spreads", shedder, h.ListSpreads)
+	r.GET("/exposure", shedder, h.Exposure)
+	return r
+}
+
+// newOverloadedService returns a service shedding load after failed
+// Robinhood requests, with positions cached before the failures
+func newOverloadedService(t *testing.T, serveStale bool) (*Service, *atomic.Int64) {
+	var down atomic.Bool
+	srv, hits := newFlakyUpstream(t, &down)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+	s.SetCircuitBreaker(0, 0)
+	s.SetLoadShedding(LoadShedConfig{Window: time.Minute, MinRequests: 2, MaxErrorRate: 0.5, ServeStale: serveStale})
+	ctx := context.Background()
+
+	if _, err := s.GetPositions(ctx, Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Fail Robinhood requests until half of the window failed
+	down.Store(true)
+	for i := 0; s.shedder.overloaded() == ""; i++ {
+		if i == 20 {
+			t.Fatal("Expected the failures to trigger shedding")
+		}
+		if _, err := s.RefreshPositions(ctx, Robinhood); !errors.Is(err, ErrUpstreamUnavailable) {
+			t.Fatalf("Expected an upstream failure, got %v", err)
+		}
+	}
+	return s, hits
+}
+
+// serveShed runs a GET request through a router
+func serveShed(r *gin.Engine, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestLoadShedder_UpstreamFailures(t *testing.T) {
+	tests := []struct {
+		name           string
+		serveStale     bool
+		cached         bool
+		expectedStatus int
+	}{
+		{name: "rejects", expectedStatus: http.StatusServiceUnavailable},
+		{name: "serves stale cache", serveStale: true, cached: true, expectedStatus: http.StatusOK},
+		{name: "stale without cache", serveStale: true, expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, hits := newOverloadedService(t, tt.serveStale)
+			r := newShedRouter(s)
+
+			if !tt.cached {
+				s.SetPositionCache(newMemoryCache())
+			}
+
+			before := hits.Load()
+			w := serveShed(r, "/positions?account_type=robinhood&refresh=true")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if hits.Load() != before {
+				t.Errorf("Expected no request to Robinhood, got %d", hits.Load()-before)
+			}
+			if s.ShedCount() != 1 {
+				t.Errorf("Expected 1 shed request, got %d", s.ShedCount())
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var positions PositionList
+				if err := json.Unmarshal(w.Body.Bytes(), &positions); err != nil {
+					t.Fatalf("Expected positions, got %v", err)
+				}
+				if !positions.Stale || len(positions.Positions) != 2 {
+					t.Errorf("Expected 2 stale positions, got %+v", positions)
+				}
+				return
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected an error response, got %v", err)
+			}
+			if body.Code != CodeOverloaded {
+				t.Errorf("Expected code %s, got %s", CodeOverloaded, body.Code)
+			}
+		})
+	}
+}
+
+func TestLoadShedder_Exposure(t *testing.T) {
+	s, hits := newOverloadedService(t, false)
+
+	before := hits.Load()
+	w := serveShed(newShedRouter(s), "/exposure?account_type=robinhood&refresh=true")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+	if hits.Load() != before {
+		t.Errorf("Expected no request to Robinhood, got %d", hits.Load()-before)
+	}
+}
+
+func TestLoadShedder_StaleSpreads(t *testing.T) {
+	tests := []struct {
+		name           string
+		cachedOrders   bool
+		expectedStatus int
+	}{
+		{name: "from cached orders", cachedOrders: true, expectedStatus: http.StatusOK},
+		{name: "without cached orders", expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, hits := newOverloadedService(t, true)
+			if tt.cachedOrders {
+				// Expired, shed requests still make do with it
+				key := cacheKey{accountType: Robinhood, accountID: "test-account"}
+				s.orderCache[key] = &orderHistory{fetchedAt: time.Now().Add(-time.Hour)}
+			}
+
+			before := hits.Load()
+			w := serveShed(newShedRouter(s), "/positions/spreads?account_type=robinhood")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if hits.Load() != before {
+				t.Errorf("Expected no request to Robinhood, got %d", hits.Load()-before)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var spreads SpreadList
+			if err := json.Unmarshal(w.Body.Bytes(), &spreads); err != nil {
+				t.Fatalf("Expected spreads, got %v", err)
+			}
+			if !spreads.Stale || len(spreads.Spreads) != 2 {
+				t.Errorf("Expected 2 stale spreads, got %+v", spreads)
+			}
+		})
+	}
+}
+
+func TestLoadShedder_Thresholds(t *testing.T) {
+	now := time.Now()
+	l := newLoadShedder(LoadShedConfig{Window: time.Minute, MinRequests: 3, MaxLatency: 2 * time.Second})
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Too few requests to judge
+	l.record(ctx, 5*time.Second, nil)
+	l.record(ctx, 5*time.Second, nil)
+	if reason := l.overloaded(); reason != "" {
+		t.Errorf("Expected no shedding below the minimum requests, got %q", reason)
+	}
+
+	l.record(ctx, 5*time.Second, nil)
+	if reason := l.overloaded(); reason == "" {
+		t.Error("Expected shedding for slow requests")
+	}
+
+	// Abandoned requests are not counted
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	l.record(canceled, time.Hour, nil)
+	if len(l.samples) != 3 {
+		t.Errorf("Expected 3 samples, got %d", len(l.samples))
+	}
+
+	// Samples outside the window are forgotten
+	now = now.Add(2 * time.Minute)
+	l.record(ctx, time.Second, nil)
+	if reason := l.overloaded(); reason != "" {
+		t.Errorf("Expected no shedding once the slow requests left the window, got %q", reason)
+	}
+}
+
+func TestLoadShedder_Disabled(t *testing.T) {
+	l := newLoadShedder(DefaultLoadShedConfig())
+	for i := 0; i < 20; i++ {
+		l.record(context.Background(), time.Minute, ErrUpstreamUnavailable)
+	}
+	if reason := l.overloaded(); reason != "" {
+		t.Errorf("Expected no shedding by default, got %q", reason)
+	}
+}
@@ -0,0 +1,311 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// SpreadStrategy is a best-effort label for the option strategy of a spread
+type SpreadStrategy string
+
+const (
+	// Single is a position that could not be grouped with other legs
+	Single SpreadStrategy = "single"
+	// Vertical is a long and a short contract of the same type and
+	// expiration at different strikes
+	Vertical SpreadStrategy = "vertical"
+	// Calendar is a long and a short contract of the same type and strike
+	// expiring on different dates
+	Calendar SpreadStrategy = "calendar"
+	// IronCondor is a put vertical below a call vertical, both short the
+	// inner strikes, all expiring together
+	IronCondor SpreadStrategy = "iron_condor"
+	// Custom is any other combination of legs opened together
+	Custom SpreadStrategy = "custom"
+)
+
+// Spread is a group of option positions opened together by a multi-leg
+// order. Values are summed over the legs; when a leg has no price, the
+// market value and P&L are not meaningful and left at zero, as for a single
+// position.
+type Spread struct {
+	// ID is the opening order's ID, or the position's ID for singletons
+	ID                   string         `json:"id"`
+	AccountID            string         `json:"account_id"`
+	Symbol               string         `json:"symbol"` // Underlying symbol
+	ChainID              string         `json:"chain_id,omitempty"`
+	Strategy             SpreadStrategy `json:"strategy"`
+	OrderID              string         `json:"order_id,omitempty"` // Opening order, empty for singletons
+	Legs                 []Position     `json:"legs"`
+	CostBasis            float64        `json:"cost_basis"`
+	MarketValue          float64        `json:"market_value"`
+	UnrealizedPnL        float64        `json:"unrealized_pnl"`
+	UnrealizedPnLPercent float64        `json:"unrealized_pnl_percent"`
+	PriceUnavailable     bool           `json:"price_unavailable,omitempty"`
+}
+
+// SpreadList represents the spreads of an account, or of every account when
+// AccountID is AllAccounts
+type SpreadList struct {
+	Spreads     []Spread    `json:"spreads"`
+	AccountID   string      `json:"account_id"`
+	AccountType AccountType `json:"account_type"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	// Stale lists are grouped from cached positions, see PositionList
+	Stale bool `json:"stale,omitempty"`
+}
+
+// FilterByMinMarketValue returns a copy of the list without spreads whose
+// combined absolute market value is below min. A non-positive min disables
+// the filter.
+func (l *SpreadList) FilterByMinMarketValue(min float64) *SpreadList {
+	if min <= 0 {
+		return l
+	}
+
+	filtered := *l
+	filtered.Spreads = make([]Spread, 0, len(l.Spreads))
+	for _, spread := range l.Spreads {
+		if math.Abs(spread.MarketValue) >= min {
+			filtered.Spreads = append(filtered.Spreads, spread)
+		}
+	}
+	return &filtered
+}
+
+// Spreads groups the option positions of the selected account into the
+// spreads they were opened as, using the account's order history. Legs that
+// cannot be matched to an opening order are returned as singletons. Unlike
+// QueryPositions the minimum market value is not applied to the legs, so a
+// cheap leg does not break up its spread.
+func (s *Service) Spreads(ctx context.Context, q PositionQuery) (*SpreadList, error) {
+	s.orderMutex.Lock()
+	ttl := s.orderHistoryTTL
+	s.orderMutex.Unlock()
+
+	accounts, accountID, err := s.selectAccounts(q.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	list := &SpreadList{
+		Spreads:     []Spread{},
+		AccountID:   accountID,
+		AccountType: q.AccountType,
+	}
+	for _, account := range accounts {
+		positions, err := s.getPositions(ctx, q.AccountType, account, q.Refresh)
+		if err == nil {
+			var history *orderHistory
+			if history, err = s.getOrders(ctx, q.AccountType, account, ttl, q.Refresh); err == nil {
+				list.Spreads = append(list.Spreads, GroupSpreads(positions.Positions, history.orders)...)
+			}
+		}
+		if err != nil {
+			if len(accounts) > 1 {
+				err = fmt.Errorf("account %s: %w", account.Label, err)
+			}
+			return nil, contextError(ctx, err)
+		}
+		if positions.UpdatedAt.After(list.UpdatedAt) {
+			list.UpdatedAt = positions.UpdatedAt
+		}
+		list.Stale = list.Stale || positions.Stale
+	}
+
+	return list, nil
+}
+
+// positionKey identifies the position holding an option contract
+type positionKey struct {
+	accountID  string
+	instrument string
+}
+
+// GroupSpreads groups positions into spreads. Each filled multi-leg opening
+// order, newest first, claims the positions holding its legs: one per leg,
+// in the same account and chain, on the leg's side. An order whose legs are
+// not all still held, e.g. after closing one leg, groups nothing. Positions
+// left over become singletons. Spreads are sorted by symbol, then ID.
+func GroupSpreads(positions []Position, orders []Order) []Spread {
+	byInstrument := make(map[positionKey]int, len(positions))
+	for i, p := range positions {
+		byInstrument[positionKey{accountID: p.AccountID, instrument: p.InstrumentURL}] = i
+	}
+
+	sorted := append([]Order(nil), orders...)
+	sortOrders(sorted)
+
+	claimed := make([]bool, len(positions))
+	var spreads []Spread
+	for _, order := range sorted {
+		legs, ok := matchOpeningOrder(order, positions, byInstrument, claimed)
+		if !ok {
+			continue
+		}
+		for _, i := range legs {
+			claimed[i] = true
+		}
+		spreads = append(spreads, newSpread(order.ID, order.ID, positions, legs))
+	}
+
+	for i, p := range positions {
+		if !claimed[i] {
+			spreads = append(spreads, newSpread(p.ID, "", positions, []int{i}))
+		}
+	}
+
+	sort.SliceStable(spreads, func(i, j int) bool {
+		if spreads[i].Symbol != spreads[j].Symbol {
+			return spreads[i].Symbol < spreads[j].Symbol
+		}
+		return spreads[i].ID < spreads[j].ID
+	})
+	return spreads
+}
+
+// matchOpeningOrder returns the indexes of the unclaimed positions holding
+// every leg of a filled multi-leg opening order, and false if the order does
+// not open a spread or a leg is no longer held
+func matchOpeningOrder(order Order, positions []Position, byInstrument map[positionKey]int, claimed []bool) ([]int, bool) {
+	if order.AssetClass != Option || len(order.Legs) < 2 || order.FilledQuantity <= 0 {
+		return nil, false
+	}
+
+	legs := make([]int, 0, len(order.Legs))
+	chainID := ""
+	for _, leg := range order.Legs {
+		if leg.PositionEffect != "open" {
+			return nil, false
+		}
+		i, ok := byInstrument[positionKey{accountID: order.AccountID, instrument: leg.Instrument}]
+		if !ok || claimed[i] {
+			return nil, false
+		}
+		p := positions[i]
+		if p.UnderlyingSymbol != order.Symbol || (leg.Side == Buy) != (p.Side == Long) {
+			return nil, false
+		}
+		// Legs of one order share the chain, a mismatch means the
+		// instrument lookup went wrong
+		if chainID == "" {
+			chainID = p.ChainID
+		} else if p.ChainID != "" && p.ChainID != chainID {
+			return nil, false
+		}
+		for _, other := range legs {
+			if other == i {
+				return nil, false
+			}
+		}
+		legs = append(legs, i)
+	}
+	return legs, true
+}
+
+// newSpread builds a spread from the positions at the given indexes
+func newSpread(id, orderID string, positions []Position, indexes []int) Spread {
+	legs := make([]Position, 0, len(indexes))
+	for _, i := range indexes {
+		legs = append(legs, positions[i])
+	}
+	sort.SliceStable(legs, func(i, j int) bool {
+		a, b := legs[i], legs[j]
+		if a.ExpirationDate != b.ExpirationDate {
+			return a.ExpirationDate < b.ExpirationDate
+		}
+		if a.OptionType != b.OptionType {
+			return a.OptionType < b.OptionType
+		}
+		return a.StrikePrice < b.StrikePrice
+	})
+
+	first := legs[0]
+	spread := Spread{
+		ID:        id,
+		AccountID: first.AccountID,
+		Symbol:    first.Symbol,
+		ChainID:   first.ChainID,
+		Strategy:  Single,
+		OrderID:   orderID,
+		Legs:      legs,
+	}
+	if len(legs) > 1 {
+		spread.Strategy = classifySpread(legs)
+	}
+
+	for _, leg := range legs {
+		spread.CostBasis += leg.CostBasis
+		spread.MarketValue += leg.MarketValue
+		spread.UnrealizedPnL += leg.UnrealizedPnL
+		if leg.PriceUnavailable {
+			spread.PriceUnavailable = true
+		}
+	}
+	if spread.PriceUnavailable {
+		spread.MarketValue = 0
+		spread.UnrealizedPnL = 0
+	} else if spread.CostBasis != 0 {
+		spread.UnrealizedPnLPercent = spread.UnrealizedPnL / math.Abs(spread.CostBasis) * 100
+	}
+	return spread
+}
+
+// classifySpread labels the strategy of two or more legs. Legs missing
+// contract details are labelled Custom.
+func classifySpread(legs []Position) SpreadStrategy {
+	for _, leg := range legs {
+		if leg.OptionType == "" || leg.StrikePrice == 0 || leg.ExpirationDate == "" {
+			return Custom
+		}
+	}
+
+	switch len(legs) {
+	case 2:
+		a, b := legs[0], legs[1]
+		if a.OptionType != b.OptionType || a.Side == b.Side {
+			return Custom
+		}
+		switch {
+		case a.ExpirationDate == b.ExpirationDate && a.StrikePrice != b.StrikePrice:
+			return Vertical
+		case a.ExpirationDate != b.ExpirationDate && a.StrikePrice == b.StrikePrice:
+			return Calendar
+		}
+	case 4:
+		if isIronCondor(legs) {
+			return IronCondor
+		}
+	}
+	return Custom
+}
+
+// isIronCondor reports whether four legs, sorted by type then strike, are a
+// long put, short put, short call and long call at increasing strikes, all
+// expiring on the same date
+func isIronCondor(legs []Position) bool {
+	expected := []struct {
+		optionType OptionType
+		side       PositionSide
+	}{
+		{Call, Short}, {Call, Long}, {Put, Long}, {Put, Short},
+	}
+	for i, leg := range legs {
+		if leg.ExpirationDate != legs[0].ExpirationDate ||
+			leg.OptionType != expected[i].optionType || leg.Side != expected[i].side {
+			return false
+		}
+	}
+
+	// Sorted by type, the calls come first
+	shortCall, longCall, longPut, shortPut := legs[0], legs[1], legs[2], legs[3]
+	return longPut.StrikePrice < shortPut.StrikePrice &&
+		shortPut.StrikePrice < shortCall.StrikePrice &&
+		shortCall.StrikePrice < longCall.StrikePrice
+}
@@ -0,0 +1,297 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// spreadFixtures holds a call vertical, an iron condor and a put calendar,
+// a vertical with one leg since closed, and a single call
+var spreadFixtures = map[string]string{
+	"/options/positions/":   "spread_positions.json",
+	"/options/instruments/": "spread_instruments.json",
+	"/marketdata/options/":  "spread_marketdata.json",
+	"/options/orders/":      "spread_orders.json",
+	"/orders/":              "spread_equity_orders.json",
+}
+
+func newSpreadService(t *testing.T) *Service {
+	srv := newFixtureServer(t, spreadFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+	return s
+}
+
+func TestSpreads_GroupsOpeningOrders(t *testing.T) {
+	s := newSpreadService(t)
+
+	list, err := s.Spreads(context.Background(), PositionQuery{AccountType: Robinhood})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if list.AccountID != "test-account" || list.UpdatedAt.IsZero() {
+		t.Errorf("Expected the primary account with a fetch time, got %s at %v", list.AccountID, list.UpdatedAt)
+	}
+
+	tests := []struct {
+		id          string
+		symbol      string
+		strategy    SpreadStrategy
+		legs        []string
+		costBasis   float64
+		marketValue float64
+		pnl         float64
+		pnlPercent  float64
+	}{
+		{id: "ord-aapl-vertical", symbol: "AAPL", strategy: Vertical, legs: []string{"pos-aapl-c200", "pos-aapl-c210"},
+			costBasis: 600, marketValue: 700, pnl: 100, pnlPercent: 100.0 / 6},
+		{id: "ord-msft-calendar", symbol: "MSFT", strategy: Calendar, legs: []string{"pos-msft-p400-apr", "pos-msft-p400-jun"},
+			costBasis: 350, marketValue: 500, pnl: 150, pnlPercent: 150.0 / 3.5},
+		// Single-leg orders do not make spreads
+		{id: "pos-nvda-c120", symbol: "NVDA", strategy: Single, legs: []string{"pos-nvda-c120"},
+			costBasis: 1500, marketValue: 1800, pnl: 300, pnlPercent: 20},
+		{id: "ord-spy-condor", symbol: "SPY", strategy: IronCondor, legs: []string{"pos-spy-c520", "pos-spy-c530", "pos-spy-p480", "pos-spy-p490"},
+			costBasis: -330, marketValue: -230, pnl: 100, pnlPercent: 100.0 / 3.3},
+		// The short leg of the vertical was closed, the long leg is left alone
+		{id: "pos-tsla-c250", symbol: "TSLA", strategy: Single, legs: []string{"pos-tsla-c250"},
+			costBasis: 900, marketValue: 800, pnl: -100, pnlPercent: -100.0 / 9},
+	}
+
+	if len(list.Spreads) != len(tests) {
+		t.Fatalf("Expected %d spreads, got %d: %+v", len(tests), len(list.Spreads), list.Spreads)
+	}
+	for i, tt := range tests {
+		spread := list.Spreads[i]
+		if spread.ID != tt.id || spread.Symbol != tt.symbol || spread.Strategy != tt.strategy {
+			t.Errorf("Expected %s %s %s, got %s %s %s", tt.id, tt.symbol, tt.strategy, spread.ID, spread.Symbol, spread.Strategy)
+			continue
+		}
+		if len(spread.Legs) != len(tt.legs) {
+			t.Errorf("%s: expected legs %v, got %d legs", tt.id, tt.legs, len(spread.Legs))
+			continue
+		}
+		for j, leg := range spread.Legs {
+			if leg.ID != tt.legs[j] {
+				t.Errorf("%s: expected leg %d to be %s, got %s", tt.id, j, tt.legs[j], leg.ID)
+			}
+		}
+		if !almostEqual(spread.CostBasis, tt.costBasis) || !almostEqual(spread.MarketValue, tt.marketValue) {
+			t.Errorf("%s: expected cost basis %v and market value %v, got %v and %v", tt.id, tt.costBasis, tt.marketValue, spread.CostBasis, spread.MarketValue)
+		}
+		if !almostEqual(spread.UnrealizedPnL, tt.pnl) || !almostEqual(spread.UnrealizedPnLPercent, tt.pnlPercent) {
+			t.Errorf("%s: expected unrealized P&L %v (%v%%), got %v (%v%%)", tt.id, tt.pnl, tt.pnlPercent, spread.UnrealizedPnL, spread.UnrealizedPnLPercent)
+		}
+	}
+
+	// The cancelled order is newer but never filled, so the vertical goes
+	// to the order that opened it
+	if list.Spreads[0].OrderID != "ord-aapl-vertical" || list.Spreads[0].ChainID != "chain-aapl" {
+		t.Errorf("Expected the filled AAPL order on chain-aapl, got %s on %s", list.Spreads[0].OrderID, list.Spreads[0].ChainID)
+	}
+}
+
+// optionLeg returns a priced option position for spread tests
+func optionLeg(id, instrument string, side PositionSide, optionType OptionType, strike float64, expiration string) Position {
+	return Position{
+		ID:               id,
+		AccountID:        "acct-1",
+		Symbol:           "QQQ",
+		UnderlyingSymbol: "QQQ",
+		ChainID:          "chain-qqq",
+		Side:             side,
+		InstrumentURL:    instrument,
+		OptionType:       optionType,
+		StrikePrice:      strike,
+		ExpirationDate:   expiration,
+		CostBasis:        100,
+		MarketValue:      120,
+		UnrealizedPnL:    20,
+	}
+}
+
+// openingOrder returns a filled opening order trading the given legs
+func openingOrder(id string, legs ...OrderLeg) Order {
+	legs = append([]OrderLeg(nil), legs...)
+	for i := range legs {
+		legs[i].PositionEffect = "open"
+	}
+	return Order{ID: id, AccountID: "acct-1", Symbol: "QQQ", AssetClass: Option, State: "filled", FilledQuantity: 1, Legs: legs}
+}
+
+func TestGroupSpreads_Heuristics(t *testing.T) {
+	putVertical := []Position{
+		optionLeg("long-put", "inst-p1", Long, Put, 400, "2025-06-20"),
+		optionLeg("short-put", "inst-p2", Short, Put, 410, "2025-06-20"),
+	}
+	verticalOrder := openingOrder("order-1",
+		OrderLeg{Instrument: "inst-p1", Side: Buy},
+		OrderLeg{Instrument: "inst-p2", Side: Sell})
+
+	tests := []struct {
+		name       string
+		positions  []Position
+		orders     []Order
+		strategies []SpreadStrategy
+	}{
+		{name: "put vertical", positions: putVertical, orders: []Order{verticalOrder}, strategies: []SpreadStrategy{Vertical}},
+		{name: "no orders", positions: putVertical, strategies: []SpreadStrategy{Single, Single}},
+		{
+			name:      "leg side differs from the position",
+			positions: putVertical,
+			orders: []Order{openingOrder("order-1",
+				OrderLeg{Instrument: "inst-p1", Side: Sell},
+				OrderLeg{Instrument: "inst-p2", Side: Buy})},
+			strategies: []SpreadStrategy{Single, Single},
+		},
+		{
+			name:      "closing order",
+			positions: putVertical,
+			orders: []Order{func() Order {
+				o := openingOrder("order-1", verticalOrder.Legs...)
+				o.Legs[1].PositionEffect = "close"
+				return o
+			}()},
+			strategies: []SpreadStrategy{Single, Single},
+		},
+		{
+			name: "other account",
+			positions: []Position{
+				putVertical[0],
+				func() Position { p := putVertical[1]; p.AccountID = "acct-2"; return p }(),
+			},
+			orders:     []Order{verticalOrder},
+			strategies: []SpreadStrategy{Single, Single},
+		},
+		{
+			name: "other chain",
+			positions: []Position{
+				putVertical[0],
+				func() Position { p := putVertical[1]; p.ChainID = "chain-other"; return p }(),
+			},
+			orders:     []Order{verticalOrder},
+			strategies: []SpreadStrategy{Single, Single},
+		},
+		{
+			name: "straddle",
+			positions: []Position{
+				optionLeg("call", "inst-c", Long, Call, 400, "2025-06-20"),
+				optionLeg("put", "inst-p", Long, Put, 400, "2025-06-20"),
+			},
+			orders: []Order{openingOrder("order-1",
+				OrderLeg{Instrument: "inst-c", Side: Buy},
+				OrderLeg{Instrument: "inst-p", Side: Buy})},
+			strategies: []SpreadStrategy{Custom},
+		},
+		{
+			name: "missing contract details",
+			positions: []Position{
+				putVertical[0],
+				func() Position { p := putVertical[1]; p.StrikePrice = 0; return p }(),
+			},
+			orders:     []Order{verticalOrder},
+			strategies: []SpreadStrategy{Custom},
+		},
+		{
+			name: "iron condor with crossed strikes",
+			positions: []Position{
+				optionLeg("lp", "inst-1", Long, Put, 380, "2025-06-20"),
+				optionLeg("sp", "inst-2", Short, Put, 420, "2025-06-20"),
+				optionLeg("sc", "inst-3", Short, Call, 410, "2025-06-20"),
+				optionLeg("lc", "inst-4", Long, Call, 440, "2025-06-20"),
+			},
+			orders: []Order{openingOrder("order-1",
+				OrderLeg{Instrument: "inst-1", Side: Buy},
+				OrderLeg{Instrument: "inst-2", Side: Sell},
+				OrderLeg{Instrument: "inst-3", Side: Sell},
+				OrderLeg{Instrument: "inst-4", Side: Buy})},
+			strategies: []SpreadStrategy{Custom},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spreads := GroupSpreads(tt.positions, tt.orders)
+			if len(spreads) != len(tt.strategies) {
+				t.Fatalf("Expected %d spreads, got %+v", len(tt.strategies), spreads)
+			}
+			legs := 0
+			for i, spread := range spreads {
+				if spread.Strategy != tt.strategies[i] {
+					t.Errorf("Expected spread %d to be %s, got %s", i, tt.strategies[i], spread.Strategy)
+				}
+				legs += len(spread.Legs)
+			}
+			// Every position ends up in exactly one spread
+			if legs != len(tt.positions) {
+				t.Errorf("Expected %d legs over all spreads, got %d", len(tt.positions), legs)
+			}
+		})
+	}
+}
+
+func TestGroupSpreads_NewestOrderClaimsLegs(t *testing.T) {
+	positions := []Position{
+		optionLeg("long-put", "inst-p1", Long, Put, 400, "2025-06-20"),
+		optionLeg("short-put", "inst-p2", Short, Put, 410, "2025-06-20"),
+	}
+	older := openingOrder("order-old",
+		OrderLeg{Instrument: "inst-p1", Side: Buy},
+		OrderLeg{Instrument: "inst-p2", Side: Sell})
+	newer := openingOrder("order-new", older.Legs...)
+	newer.CreatedAt = older.CreatedAt.Add(1)
+
+	spreads := GroupSpreads(positions, []Order{older, newer})
+	if len(spreads) != 1 || spreads[0].OrderID != "order-new" {
+		t.Errorf("Expected a single spread opened by order-new, got %+v", spreads)
+	}
+}
+
+func TestGroupSpreads_PriceUnavailable(t *testing.T) {
+	positions := []Position{
+		optionLeg("long-put", "inst-p1", Long, Put, 400, "2025-06-20"),
+		optionLeg("short-put", "inst-p2", Short, Put, 410, "2025-06-20"),
+	}
+	positions[1].PriceUnavailable = true
+	positions[1].MarketValue = 0
+	positions[1].UnrealizedPnL = 0
+	order := openingOrder("order-1",
+		OrderLeg{Instrument: "inst-p1", Side: Buy},
+		OrderLeg{Instrument: "inst-p2", Side: Sell})
+
+	spreads := GroupSpreads(positions, []Order{order})
+	if len(spreads) != 1 {
+		t.Fatalf("Expected a single spread, got %+v", spreads)
+	}
+	spread := spreads[0]
+	if !spread.PriceUnavailable || spread.MarketValue != 0 || spread.UnrealizedPnL != 0 {
+		t.Errorf("Expected an unpriced spread with zero value, got %+v", spread)
+	}
+	if !almostEqual(spread.CostBasis, 200) {
+		t.Errorf("Expected cost basis 200, got %v", spread.CostBasis)
+	}
+}
+
+func TestHandler_ListSpreads(t *testing.T) {
+	h := NewHandler(newSpreadService(t))
+
+	w := performRequest(h, http.MethodGet, "/positions/spreads?account_type=robinhood&min_market_value=750", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list SpreadList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Expected a spread list, got %v", err)
+	}
+	// Only NVDA and TSLA are worth at least 750
+	if len(list.Spreads) != 2 || list.Spreads[0].Symbol != "NVDA" || list.Spreads[1].Symbol != "TSLA" {
+		t.Errorf("Expected the NVDA and TSLA spreads, got %+v", list.Spreads)
+	}
+
+	w = performRequest(h, http.MethodGet, "/positions/spreads?account_type=etrade", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,252 @@
+package position
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openStream starts a server streaming positions from s and connects to it,
+// returning the event reader once the response headers arrived
+func openStream(t *testing.T, s *Service, keepAlive time.Duration) (*bufio.Reader, context.CancelFunc) {
+	h := NewHandler(s)
+	h.SetStreamKeepAlive(keepAlive)
+	return openHandlerStream(t, h, "account_type=robinhood")
+}
+
+// openHandlerStream connects to a server streaming positions from h with the
+// given query
+func openHandlerStream(t *testing.T, h *Handler, query string) (*bufio.Reader, context.CancelFunc) {
+	r := gin.New()
+	r.GET("/positions/stream", h.StreamPositions)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/positions/stream?"+query, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected Content-Type text/event-stream, got %s", contentType)
+	}
+	return bufio.NewReader(resp.Body), cancel
+}
+
+// readEvent reads the lines of the next event, up to the blank line ending it
+func readEvent(t *testing.T, events *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected an event, got %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// subscriberCount returns how many position subscribers are registered
+func subscriberCount(s *Service) int {
+	s.subscriberMutex.Lock()
+	defer s.subscriberMutex.Unlock()
+	return len(s.subscribers)
+}
+
+func TestHandler_StreamPositions(t *testing.T) {
+	srv := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+
+	events, cancel := openStream(t, s, time.Hour)
+
+	// The refresher's first round publishes the positions
+	s.StartRefresher(Robinhood, time.Hour)
+	defer s.Stop()
+
+	lines := readEvent(t, events)
+	if len(lines) != 2 || lines[0] != "event:positions" || !strings.HasPrefix(lines[1], "data:") {
+		t.Fatalf("Expected a positions event, got %q", lines)
+	}
+	var positions PositionList
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data:")), &positions); err != nil {
+		t.Fatalf("Expected a position list, got %v", err)
+	}
+	if positions.AccountID != "test-account" || len(positions.Positions) != 2 {
+		t.Errorf("Expected 2 positions of test-account, got %+v", positions)
+	}
+
+	// Disconnecting unsubscribes
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(s) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the stream to unsubscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandler_StreamPositionsKeepAlive(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+
+	events, _ := openStream(t, s, 10*time.Millisecond)
+	if lines := readEvent(t, events); len(lines) != 1 || lines[0] != ": keepalive" {
+		t.Errorf("Expected a keepalive comment, got %q", lines)
+	}
+}
+
+func TestHandler_StreamPositionsInvalidRequest(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+
+	for _, target := range []string{
+		"/positions/stream",
+		"/positions/stream?account_type=etrade",
+		"/positions/stream?account_type=robinhood&account_label=unknown",
+		"/positions/stream?account_type=robinhood&min_market_value=-1",
+	} {
+		h := NewHandler(s)
+		r := gin.New()
+		r.GET("/positions/stream", h.StreamPositions)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, target, w.Code)
+		}
+	}
+	if subscriberCount(s) != 0 {
+		t.Errorf("Expected no subscribers, got %d", subscriberCount(s))
+	}
+}
+
+func TestHandler_StreamPositionsFiltered(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.AddAccount("ira", "222")
+	h := NewHandler(s)
+	h.SetStreamKeepAlive(time.Hour)
+
+	events, _ := openHandlerStream(t, h, "account_type=robinhood&account_label=ira&min_market_value=100")
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(s) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the stream to subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	s.publishPositions(&PositionList{AccountType: Robinhood, AccountID: "test-account", Positions: []Position{{Symbol: "AAPL", MarketValue: 500}}})
+	s.publishPositions(&PositionList{AccountType: Robinhood, AccountID: "222", Positions: []Position{
+		{Symbol: "MSFT", MarketValue: 500},
+		{Symbol: "PENNY", MarketValue: 5},
+	}})
+
+	lines := readEvent(t, events)
+	var positions PositionList
+	if len(lines) != 2 || json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data:")), &positions) != nil {
+		t.Fatalf("Expected a positions event, got %q", lines)
+	}
+	if positions.AccountID != "222" || len(positions.Positions) != 1 || positions.Positions[0].Symbol != "MSFT" {
+		t.Errorf("Expected only MSFT of the ira account, got %+v", positions)
+	}
+}
+
+func TestHandler_CloseStreams(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	h := NewHandler(s)
+	h.SetStreamKeepAlive(time.Hour)
+
+	events, _ := openHandlerStream(t, h, "account_type=robinhood")
+	h.CloseStreams()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := events.ReadString('\n')
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("Expected the stream to end, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stream to end")
+	}
+}
+
+func TestPublishPositions_KeepsLatestOfEachAccount(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.AddAccount("ira", "222")
+	updates, cancel, err := s.SubscribePositions(Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cancel()
+
+	first := &PositionList{AccountType: Robinhood, AccountID: "test-account"}
+	ira := &PositionList{AccountType: Robinhood, AccountID: "222"}
+	latest := &PositionList{AccountType: Robinhood, AccountID: "test-account"}
+	s.publishPositions(first)
+	s.publishPositions(ira)
+	s.publishPositions(latest)
+	s.publishPositions(&PositionList{AccountType: "other", AccountID: "other"})
+
+	// A slow reader still gets every account, each with its latest list
+	if got := <-updates; got != ira {
+		t.Errorf("Expected the ira list, got %+v", got)
+	}
+	if got := <-updates; got != latest {
+		t.Errorf("Expected the latest list, got %+v", got)
+	}
+	select {
+	case got := <-updates:
+		t.Errorf("Expected no further update, got %+v", got)
+	default:
+	}
+}
+
+func TestPublishPositions_PostProcesses(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.SetMinMarketValue(100)
+	updates, cancel, err := s.SubscribePositions(Robinhood, "test-account")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cancel()
+
+	s.publishPositions(&PositionList{AccountType: Robinhood, AccountID: "test-account", Positions: []Position{
+		{Symbol: "AAPL", MarketValue: 500},
+		{Symbol: "PENNY", MarketValue: 5},
+	}})
+	if got := <-updates; len(got.Positions) != 1 || got.Positions[0].Symbol != "AAPL" {
+		t.Errorf("Expected only AAPL above the minimum market value, got %+v", got.Positions)
+	}
+}
+
+func TestSubscribePositions_UnknownAccount(t *testing.T) {
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	if _, _, err := s.SubscribePositions(Robinhood, "unknown"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Expected ErrUnknownAccount, got %v", err)
+	}
+	if subscriberCount(s) != 0 {
+		t.Errorf("Expected no subscribers, got %d", subscriberCount(s))
+	}
+}
@@ -2,71 +2,263 @@ package position
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trade-sonic/logging"
+)
+
+const (
+	// DefaultTokenTimeout bounds a single request to the token service
+	DefaultTokenTimeout = 5 * time.Second
+	// DefaultTokenExpiryBuffer is how long before its expiry a cached token
+	// is replaced, so it does not expire while a fetch is using it
+	DefaultTokenExpiryBuffer = time.Minute
 )
 
-// TokenClient is a client for the token service
+// TokenClient is a client for the token service. Tokens are cached until
+// shortly before they expire.
 type TokenClient struct {
-	client    *http.Client
-	serviceURL string
+	client      *http.Client
+	serviceURL  string
+	readOnly    bool // Request the read-only token that cannot place orders
+	retryPolicy RetryPolicy
+
+	// Token cache. fetchMutex makes concurrent callers wait for a single
+	// token request instead of each sending their own.
+	mu           sync.Mutex
+	fetchMutex   sync.Mutex
+	tokens       map[tokenKey]cachedToken
+	expiryBuffer time.Duration
+	now          func() time.Time
+}
+
+// tokenKey identifies the cached token of a token service account
+type tokenKey struct {
+	accountType AccountType
+	label       string
+}
+
+// cachedToken is a token with its expiry
+type cachedToken struct {
+	accessToken string
+	expiresAt   time.Time
 }
 
-// TokenResponse represents a response from the token service
+// TokenResponse represents a response from the token service. ExpiresAt is
+// zero when the token service does not report it, and the token is then
+// not cached.
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// DefaultTokenRetryPolicy returns the retry policy used by NewTokenClient. It
+// rides out a token service restart without holding a request for long.
+func DefaultTokenRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   250 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+		Deadline:    15 * time.Second,
+	}
 }
 
 // NewTokenClient creates a new token client
 func NewTokenClient(serviceURL string) *TokenClient {
 	return &TokenClient{
-		client:    &http.Client{},
-		serviceURL: serviceURL,
+		client:      &http.Client{Timeout: DefaultTokenTimeout},
+		serviceURL:  serviceURL,
+		retryPolicy: DefaultTokenRetryPolicy(),
+
+		tokens:       make(map[tokenKey]cachedToken),
+		expiryBuffer: DefaultTokenExpiryBuffer,
+		now:          time.Now,
+	}
+}
+
+// SetTimeout bounds each attempt of a request to the token service
+func (c *TokenClient) SetTimeout(timeout time.Duration) {
+	c.client.Timeout = timeout
+}
+
+// SetRetryPolicy replaces the retry policy for token requests. Connection
+// errors, timeouts and 502, 503 and 504 responses, e.g. while the token
+// service restarts, are retried. Other responses, including 4xx and 500, are
+// returned as is.
+func (c *TokenClient) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetReadOnly makes the client request the broker's read-only token, so a
+// compromised position service cannot trade
+func (c *TokenClient) SetReadOnly(readOnly bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readOnly = readOnly
+	c.tokens = make(map[tokenKey]cachedToken)
+}
+
+// SetExpiryBuffer sets how long before its expiry a cached token is
+// replaced. Zero uses a token until it expires.
+func (c *TokenClient) SetExpiryBuffer(buffer time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiryBuffer = buffer
+}
+
+// GetToken returns the cached token for the account type and label or
+// retrieves one from the token service, retrying transient failures
+// according to the retry policy. An empty label is the token service's
+// default account of the type.
+func (c *TokenClient) GetToken(ctx context.Context, accountType AccountType, label string) (string, error) {
+	key := tokenKey{accountType: accountType, label: label}
+	if token, ok := c.cachedToken(key); ok {
+		return token, nil
+	}
+
+	c.fetchMutex.Lock()
+	defer c.fetchMutex.Unlock()
+	// Another caller may have fetched it while this one waited
+	if token, ok := c.cachedToken(key); ok {
+		return token, nil
+	}
+	return c.requestToken(ctx, key, false)
+}
+
+// RefreshToken discards the cached token, asks the token service to discard
+// its own and retrieves a freshly issued one. It is the force refresh path
+// for tokens the broker rejected.
+func (c *TokenClient) RefreshToken(ctx context.Context, accountType AccountType, label string) (string, error) {
+	key := tokenKey{accountType: accountType, label: label}
+	c.fetchMutex.Lock()
+	defer c.fetchMutex.Unlock()
+
+	c.mu.Lock()
+	delete(c.tokens, key)
+	c.mu.Unlock()
+	return c.requestToken(ctx, key, true)
+}
+
+// cachedToken returns the cached token of an account unless it expires
+// within the expiry buffer
+func (c *TokenClient) cachedToken(key tokenKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	token, ok := c.tokens[key]
+	if !ok || !c.now().Add(c.expiryBuffer).Before(token.expiresAt) {
+		return "", false
 	}
+	return token.accessToken, true
 }
 
-// GetToken retrieves a token from the token service
-func (c *TokenClient) GetToken(accountType AccountType) (string, error) {
-	// Create request body
-	reqBody, err := json.Marshal(map[string]string{
-		"account_type": string(accountType),
-	})
+// requestToken retrieves a token from the token service and caches it, must
+// be called with fetchMutex held
+func (c *TokenClient) requestToken(ctx context.Context, key tokenKey, forceRefresh bool) (string, error) {
+	// Create request body. The label is left out for the default account,
+	// which token services without labels understand too.
+	payload := map[string]interface{}{
+		"account_type":  string(key.accountType),
+		"read_only":     c.readOnly,
+		"force_refresh": forceRefresh,
+	}
+	if key.label != "" {
+		payload["label"] = key.label
+	}
+	reqBody, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	policy := c.retryPolicy
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+	}
+
+	attempts := 0
+	for {
+		attempts++
+		body, retryable, err := c.post(ctx, reqBody)
+		if err == nil {
+			token, err := parseTokenResponse(body)
+			if err != nil {
+				return "", err
+			}
+			if !token.ExpiresAt.IsZero() {
+				c.mu.Lock()
+				c.tokens[key] = cachedToken{accessToken: token.AccessToken, expiresAt: token.ExpiresAt}
+				c.mu.Unlock()
+			}
+			return token.AccessToken, nil
+		}
+		if !retryable || attempts >= policy.MaxAttempts || ctx.Err() != nil {
+			return "", err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", errors.Join(err, ctx.Err())
+		case <-time.After(policy.backoff(attempts)):
+		}
+	}
+}
+
+// post sends a single token request, reporting whether a failure is worth
+// retrying
+func (c *TokenClient) post(ctx context.Context, reqBody []byte) (body []byte, retryable bool, err error) {
 	// Create request
-	req, err := http.NewRequest("POST", c.serviceURL+"/token", bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.serviceURL+"/token", bytes.NewReader(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	logging.SetRequestIDHeader(req)
 
-	// Send request
+	// Send request, connection errors and timeouts are retryable
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return nil, true, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return nil, true, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token service returned error: %s", body)
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			retryable = true
+		}
+		// Only the error message is kept, the body is not echoed into logs
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			return nil, retryable, fmt.Errorf("token service returned status %d: %s", resp.StatusCode, errResp.Error)
+		}
+		return nil, retryable, fmt.Errorf("token service returned status %d", resp.StatusCode)
 	}
+	return body, false, nil
+}
 
-	// Parse response
+// parseTokenResponse parses a token service response
+func parseTokenResponse(body []byte) (*TokenResponse, error) {
 	var tokenResp TokenResponse
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	return tokenResp.AccessToken, nil
+	return &tokenResp, nil
 }
@@ -0,0 +1,343 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyTokenServer runs fail on the first request to the token service and
+// issues a token on every later one
+func newFlakyTokenServer(t *testing.T, fail http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			fail(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"test-token"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// dropConnection closes the connection without a response, as a restarting
+// token service does
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestTokenClient_Retries(t *testing.T) {
+	tests := []struct {
+		name          string
+		fail          http.HandlerFunc
+		expectedHits  int32
+		expectedError bool
+	}{
+		{name: "connection dropped", fail: dropConnection, expectedHits: 2},
+		{name: "service unavailable", fail: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, expectedHits: 2},
+		{name: "bad request", fail: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":"unsupported account type"}`, http.StatusBadRequest)
+		}, expectedHits: 1, expectedError: true},
+		{name: "internal error", fail: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":"login failed"}`, http.StatusInternalServerError)
+		}, expectedHits: 1, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := newFlakyTokenServer(t, tt.fail)
+			c := NewTokenClient(srv.URL)
+			c.SetRetryPolicy(fastRetryPolicy)
+
+			token, err := c.GetToken(context.Background(), Robinhood, "")
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, got token %q", token)
+				}
+			} else if err != nil || token != "test-token" {
+				t.Errorf("Expected token test-token, got %q, %v", token, err)
+			}
+			if hits.Load() != tt.expectedHits {
+				t.Errorf("Expected %d requests, got %d", tt.expectedHits, hits.Load())
+			}
+		})
+	}
+}
+
+func TestTokenClient_Timeout(t *testing.T) {
+	srv, hits := newFlakyTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	c := NewTokenClient(srv.URL)
+	c.SetRetryPolicy(fastRetryPolicy)
+	c.SetTimeout(50 * time.Millisecond)
+
+	// The slow first attempt times out and the retry succeeds
+	token, err := c.GetToken(context.Background(), Robinhood, "")
+	if err != nil || token != "test-token" {
+		t.Errorf("Expected token test-token, got %q, %v", token, err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("Expected 2 requests, got %d", hits.Load())
+	}
+}
+
+func TestTokenClient_GivesUpAfterMaxAttempts(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		dropConnection(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := NewTokenClient(srv.URL)
+	c.SetRetryPolicy(fastRetryPolicy)
+
+	if _, err := c.GetToken(context.Background(), Robinhood, ""); err == nil {
+		t.Error("Expected an error")
+	}
+	if hits.Load() != int32(fastRetryPolicy.MaxAttempts) {
+		t.Errorf("Expected %d requests, got %d", fastRetryPolicy.MaxAttempts, hits.Load())
+	}
+}
+
+func TestTokenClient_RequestBody(t *testing.T) {
+	var requests []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/token" {
+			t.Errorf("Expected POST /token, got %s %s", r.Method, r.URL.Path)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Expected a JSON body, got %v", err)
+		}
+		requests = append(requests, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"test-token"}`))
+	}))
+	defer srv.Close()
+
+	c := NewTokenClient(srv.URL)
+	if _, err := c.GetToken(context.Background(), Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	c.SetReadOnly(true)
+	token, err := c.RefreshToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token != "test-token" {
+		t.Errorf("Expected test-token, got %s", token)
+	}
+
+	expected := []map[string]interface{}{
+		{"account_type": "robinhood", "read_only": false, "force_refresh": false},
+		{"account_type": "robinhood", "read_only": true, "force_refresh": true},
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d", len(expected), len(requests))
+	}
+	for i, want := range expected {
+		for key, value := range want {
+			if requests[i][key] != value {
+				t.Errorf("Request %d: expected %s %v, got %v", i, key, value, requests[i][key])
+			}
+		}
+	}
+}
+
+func TestTokenClient_Labels(t *testing.T) {
+	var labels []interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Expected a JSON body, got %v", err)
+		}
+		label, hasLabel := body["label"]
+		if !hasLabel {
+			label = nil
+		}
+		labels = append(labels, label)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%v","expires_at":%q}`, label, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	c := NewTokenClient(srv.URL)
+	for _, label := range []string{"", "joint", "", "joint"} {
+		token, err := c.GetToken(context.Background(), Robinhood, label)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		want := "token-<nil>"
+		if label != "" {
+			want = "token-" + label
+		}
+		if token != want {
+			t.Errorf("Expected %s for label %q, got %s", want, label, token)
+		}
+	}
+
+	// Each label is cached on its own, the default one is requested without
+	// a label
+	expected := []interface{}{nil, "joint"}
+	if len(labels) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d: %v", len(expected), len(labels), labels)
+	}
+	for i, want := range expected {
+		if labels[i] != want {
+			t.Errorf("Request %d: expected label %v, got %v", i, want, labels[i])
+		}
+	}
+}
+
+func TestTokenClient_ClientErrorNotRetried(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, `{"error":"unsupported account type"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := NewTokenClient(srv.URL)
+	c.SetRetryPolicy(fastRetryPolicy)
+	if _, err := c.GetToken(context.Background(), "webull", ""); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected a single request, got %d", hits.Load())
+	}
+}
+
+// newCountingTokenServer issues a new token on every request, expiring at
+// expiresAt, and counts the requests
+func newCountingTokenServer(t *testing.T, expiresAt time.Time) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-token", ExpiresAt: expiresAt})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestTokenClient_CachesToken(t *testing.T) {
+	tokenSrv, hits := newCountingTokenServer(t, time.Now().Add(time.Hour))
+	fixtures := newFixtureServer(t, robinhoodFixtures)
+	s := NewService(NewTokenClient(tokenSrv.URL), "test-account")
+	s.baseURL = fixtures.URL
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.GetPositions(context.Background(), Robinhood); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected 1 token request, got %d", got)
+	}
+}
+
+func TestTokenClient_RenewsBeforeExpiry(t *testing.T) {
+	expiresAt := time.Date(2025, 3, 3, 15, 0, 0, 0, time.UTC)
+	srv, hits := newCountingTokenServer(t, expiresAt)
+	c := NewTokenClient(srv.URL)
+	c.SetExpiryBuffer(5 * time.Minute)
+	now := expiresAt.Add(-10 * time.Minute)
+	c.now = func() time.Time { return now }
+
+	get := func() {
+		t.Helper()
+		if _, err := c.GetToken(context.Background(), Robinhood, ""); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	get()
+	get()
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected 1 token request, got %d", got)
+	}
+
+	// Within the buffer the token is replaced before it expires
+	now = expiresAt.Add(-4 * time.Minute)
+	get()
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 2 token requests, got %d", got)
+	}
+}
+
+func TestTokenClient_NoExpiryNotCached(t *testing.T) {
+	srv, hits := newCountingTokenServer(t, time.Time{})
+	c := NewTokenClient(srv.URL)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetToken(context.Background(), Robinhood, ""); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 2 token requests, got %d", got)
+	}
+}
+
+func TestTokenClient_RefreshBypassesCache(t *testing.T) {
+	srv, hits := newCountingTokenServer(t, time.Now().Add(time.Hour))
+	c := NewTokenClient(srv.URL)
+	ctx := context.Background()
+
+	if _, err := c.GetToken(ctx, Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := c.RefreshToken(ctx, Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 2 token requests, got %d", got)
+	}
+
+	// The refreshed token replaces the cached one
+	if _, err := c.GetToken(ctx, Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 2 token requests, got %d", got)
+	}
+}
+
+func TestTokenClient_ConcurrentCallersShareRequest(t *testing.T) {
+	srv, hits := newCountingTokenServer(t, time.Now().Add(time.Hour))
+	c := NewTokenClient(srv.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetToken(context.Background(), Robinhood, ""); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected 1 token request, got %d", got)
+	}
+}
@@ -0,0 +1,94 @@
+package position
+
+// positionSubscriber receives the position lists refreshed for one account
+// type, see SubscribePositions
+type positionSubscriber struct {
+	accountType AccountType
+	// accountID limits the updates to one account, empty for every account
+	accountID string
+	updates   chan *PositionList
+}
+
+// SubscribePositions returns a channel receiving the positions of account
+// each time the background refresher updates them, of every account of
+// accountType when account is empty or "all". account is a label or account
+// number, like in QueryPositions. The lists are post-processed like
+// QueryPositions results, with live prices applied and the configured
+// minimum market value filtered. Only the latest list of each account is
+// kept for a subscriber that falls behind. cancel unsubscribes and must be
+// called once the updates are no longer read; the channel is not closed.
+func (s *Service) SubscribePositions(accountType AccountType, account string) (updates <-chan *PositionList, cancel func(), err error) {
+	sub := &positionSubscriber{accountType: accountType}
+	buffer := len(s.Accounts())
+	if account != "" && account != AllAccounts {
+		resolved, err := s.resolveAccount(account)
+		if err != nil {
+			return nil, nil, err
+		}
+		sub.accountID = resolved.ID
+		buffer = 1
+	}
+	// Room for the latest list of every account
+	sub.updates = make(chan *PositionList, max(buffer, 1))
+
+	s.subscriberMutex.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[*positionSubscriber]struct{})
+	}
+	s.subscribers[sub] = struct{}{}
+	s.subscriberMutex.Unlock()
+
+	return sub.updates, func() {
+		s.subscriberMutex.Lock()
+		delete(s.subscribers, sub)
+		s.subscriberMutex.Unlock()
+	}, nil
+}
+
+// publishPositions hands a refreshed position list to the subscribers of its
+// account, replacing a list of the same account they have not read yet
+func (s *Service) publishPositions(positions *PositionList) {
+	s.cacheMutex.RLock()
+	minMarketValue := s.minMarketValue
+	s.cacheMutex.RUnlock()
+	positions = s.applyLivePrices(positions).FilterByMinMarketValue(minMarketValue)
+
+	s.subscriberMutex.Lock()
+	defer s.subscriberMutex.Unlock()
+
+	for sub := range s.subscribers {
+		if sub.accountType != positions.AccountType {
+			continue
+		}
+		if sub.accountID != "" && sub.accountID != positions.AccountID {
+			continue
+		}
+		sub.send(positions)
+	}
+}
+
+// send queues positions, replacing an unread list of the same account. The
+// caller must hold subscriberMutex, so publishing is serialized and only the
+// reader takes lists out while the unread ones are queued again; there is
+// room for them and this one unless more accounts publish than the channel
+// holds, when the oldest unread list is dropped.
+func (sub *positionSubscriber) send(positions *PositionList) {
+	var pending []*PositionList
+	for drained := false; !drained; {
+		select {
+		case unread := <-sub.updates:
+			if unread.AccountID != positions.AccountID {
+				pending = append(pending, unread)
+			}
+		default:
+			drained = true
+		}
+	}
+	pending = append(pending, positions)
+	if overflow := len(pending) - cap(sub.updates); overflow > 0 {
+		pending = pending[overflow:]
+	}
+	for _, list := range pending {
+		sub.updates <- list
+	}
+}
@@ -0,0 +1,169 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trade-sonic/robinhood"
+)
+
+// ErrWatchlistNotFound is returned when the account has no watchlist of the
+// requested name
+var ErrWatchlistNotFound = errors.New("watchlist not found")
+
+// Watchlist is a named Robinhood list of equity symbols, in the order they
+// were added
+type Watchlist struct {
+	Name    string   `json:"name"`
+	Symbols []string `json:"symbols"`
+}
+
+// WatchlistList represents the watchlists of the primary account's user
+type WatchlistList struct {
+	Watchlists  []Watchlist `json:"watchlists"`
+	AccountType AccountType `json:"account_type"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// EngineSymbols is a watchlist in the shape the market streamer is
+// configured with: STOCK_SYMBOLS takes the comma-separated symbols
+type EngineSymbols struct {
+	Watchlist    string            `json:"watchlist"`
+	StockSymbols []string          `json:"stock_symbols"`
+	Env          map[string]string `json:"env"`
+}
+
+// NewEngineSymbols returns the streamer configuration for a watchlist
+func NewEngineSymbols(w *Watchlist) EngineSymbols {
+	return EngineSymbols{
+		Watchlist:    w.Name,
+		StockSymbols: w.Symbols,
+		Env:          map[string]string{"STOCK_SYMBOLS": strings.Join(w.Symbols, ",")},
+	}
+}
+
+// Watchlists returns every watchlist of the primary account's user with
+// their instruments resolved to symbols
+func (s *Service) Watchlists(ctx context.Context, accountType AccountType) (*WatchlistList, error) {
+	var watchlists []Watchlist
+	err := s.withWatchlistToken(ctx, accountType, func(ctx context.Context, token string) error {
+		lists, err := s.fetchRobinhoodWatchlists(ctx, token)
+		if err != nil {
+			return err
+		}
+		watchlists, err = s.resolveWatchlists(ctx, token, lists)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &WatchlistList{
+		Watchlists:  watchlists,
+		AccountType: accountType,
+		UpdatedAt:   time.Now(),
+	}, nil
+}
+
+// Watchlist returns a single watchlist by name, ignoring case, and
+// ErrWatchlistNotFound if there is none
+func (s *Service) Watchlist(ctx context.Context, accountType AccountType, name string) (*Watchlist, error) {
+	var watchlist *Watchlist
+	err := s.withWatchlistToken(ctx, accountType, func(ctx context.Context, token string) error {
+		lists, err := s.fetchRobinhoodWatchlists(ctx, token)
+		if err != nil {
+			return err
+		}
+		for _, list := range lists {
+			if strings.EqualFold(list.Name, name) {
+				resolved, err := s.resolveWatchlists(ctx, token, []robinhood.Watchlist{list})
+				if err != nil {
+					return err
+				}
+				watchlist = &resolved[0]
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrWatchlistNotFound, name)
+	})
+	return watchlist, err
+}
+
+// withWatchlistToken calls fn with a token of the primary account, bound to
+// the request timeout. Watchlists belong to the user, not to an account.
+func (s *Service) withWatchlistToken(ctx context.Context, accountType AccountType, fn func(ctx context.Context, token string) error) error {
+	if !accountType.IsSupported() {
+		return fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
+	}
+	if s.mock != nil {
+		return fmt.Errorf("%w: watchlists are not available in mock mode", ErrNotConfigured)
+	}
+	account, err := s.resolveAccount("")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	err = s.withToken(ctx, accountType, account, func(token string) error {
+		return fn(ctx, token)
+	})
+	if err == nil || errors.Is(err, ErrWatchlistNotFound) {
+		return err
+	}
+	return contextError(ctx, err)
+}
+
+// fetchRobinhoodWatchlists lists the watchlists of the token's user
+func (s *Service) fetchRobinhoodWatchlists(ctx context.Context, token string) ([]robinhood.Watchlist, error) {
+	lists, err := s.robinhood().GetWatchlists(ctx, token)
+	if err != nil {
+		return nil, s.robinhoodError(ctx, "watchlists", err)
+	}
+	return lists, nil
+}
+
+// resolveWatchlists fetches the instruments of each watchlist and resolves
+// them to symbols all at once, so instruments are fetched in batches across
+// watchlists. Instruments that cannot be resolved are left out.
+func (s *Service) resolveWatchlists(ctx context.Context, token string, lists []robinhood.Watchlist) ([]Watchlist, error) {
+	instruments := make([][]string, len(lists))
+	var all []string
+	for i, list := range lists {
+		items, err := s.robinhood().GetWatchlistItems(ctx, token, list.URL)
+		if err != nil {
+			return nil, fmt.Errorf("watchlist %s: %w", list.Name, s.robinhoodError(ctx, "watchlist", err))
+		}
+		for _, item := range items {
+			instruments[i] = append(instruments[i], item.Instrument)
+		}
+		all = append(all, instruments[i]...)
+	}
+
+	// Instruments of failed batches are left out rather than failing every
+	// watchlist, unless the request was cancelled
+	details, err := s.getInstrumentDetails(ctx, all, token)
+	if err != nil {
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) || ctx.Err() != nil {
+			return nil, err
+		}
+		s.logBatchError(ctx, "Error fetching watchlist instruments", err)
+	}
+
+	watchlists := make([]Watchlist, 0, len(lists))
+	for i, list := range lists {
+		watchlist := Watchlist{Name: list.Name, Symbols: []string{}}
+		for _, instrument := range instruments[i] {
+			if detail, ok := details[instrument]; ok && detail.symbol != "" {
+				watchlist.Symbols = append(watchlist.Symbols, detail.symbol)
+			}
+		}
+		watchlists = append(watchlists, watchlist)
+	}
+	return watchlists, nil
+}
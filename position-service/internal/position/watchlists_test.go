@@ -0,0 +1,156 @@
+package position
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// watchlistFixtures holds a paged Default watchlist with an instrument
+// Robinhood no longer knows, and a Tech watchlist sharing NVDA with it
+var watchlistFixtures = map[string]string{
+	"/watchlists/":                  "watchlists.json",
+	"/watchlists/Default/":          "watchlist_default.json",
+	"/watchlists/Default/?cursor=2": "watchlist_default_page2.json",
+	"/watchlists/Tech/":             "watchlist_tech.json",
+	"/instruments/":                 "instruments_batch.json",
+}
+
+// newWatchlistService returns a service backed by the watchlist fixtures
+// and the ids of every instrument batch it requested
+func newWatchlistService(t *testing.T) (*Service, func() []string) {
+	fixtures := newFixtureServer(t, watchlistFixtures)
+	target, _ := url.Parse(fixtures.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	var mu sync.Mutex
+	var batches []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/instruments/" {
+			mu.Lock()
+			batches = append(batches, r.URL.Query().Get("ids"))
+			mu.Unlock()
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	s := NewService(&stubTokenService{token: "test-token"}, "test-account")
+	s.baseURL = srv.URL
+	s.SetRetryPolicy(fastRetryPolicy)
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), batches...)
+	}
+}
+
+func TestWatchlists_ResolvesSymbolsInBatches(t *testing.T) {
+	s, batches := newWatchlistService(t)
+	s.SetOptionBatching(2, 1)
+
+	list, err := s.Watchlists(context.Background(), Robinhood)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(list.Watchlists) != 2 {
+		t.Fatalf("Expected 2 watchlists, got %+v", list.Watchlists)
+	}
+	// The delisted instrument is left out
+	expected := map[string]string{"Default": "AAPL,MSFT,NVDA", "Tech": "NVDA,TSLA"}
+	for _, watchlist := range list.Watchlists {
+		if got := strings.Join(watchlist.Symbols, ","); got != expected[watchlist.Name] {
+			t.Errorf("Expected %s to hold %s, got %s", watchlist.Name, expected[watchlist.Name], got)
+		}
+	}
+
+	// Five distinct instruments, two per request, NVDA only once
+	if got := batches(); strings.Join(got, ";") != "aapl-id,msft-id;nvda-id,delisted-id;tsla-id" {
+		t.Errorf("Expected 3 batches of distinct instruments, got %v", got)
+	}
+
+	// Resolved symbols are cached, only the unknown instrument is retried
+	if _, err := s.Watchlists(context.Background(), Robinhood); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := batches(); len(got) != 4 || got[3] != "delisted-id" {
+		t.Errorf("Expected a single request for the unknown instrument, got %v", got)
+	}
+}
+
+func TestWatchlist_ByName(t *testing.T) {
+	s, _ := newWatchlistService(t)
+
+	watchlist, err := s.Watchlist(context.Background(), Robinhood, "tech")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if watchlist.Name != "Tech" || strings.Join(watchlist.Symbols, ",") != "NVDA,TSLA" {
+		t.Errorf("Expected the Tech watchlist, got %+v", watchlist)
+	}
+}
+
+func TestInstrumentID(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{url: "https://api.robinhood.com/instruments/450dfc6d-5510-4d40-abfb-f633b7d9be3e/", expected: "450dfc6d-5510-4d40-abfb-f633b7d9be3e"},
+		{url: "http://localhost:8080/robinhood/instruments/aapl-id", expected: "aapl-id"},
+		{url: "https://api.robinhood.com/options/instruments/opt-1/", expected: "opt-1"},
+		{url: "https://api.robinhood.com/quotes/AAPL/"},
+		{url: "https://api.robinhood.com/instruments/"},
+	}
+	for _, tt := range tests {
+		if got := instrumentID(tt.url); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.url, tt.expected, got)
+		}
+	}
+}
+
+func TestHandler_Watchlists(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "list", method: http.MethodGet, target: "/watchlists?account_type=robinhood", expectedStatus: http.StatusOK, expectedBody: `"name":"Tech"`},
+		{name: "by name", method: http.MethodGet, target: "/watchlists/Default?account_type=robinhood", expectedStatus: http.StatusOK, expectedBody: `"symbols":["AAPL","MSFT","NVDA"]`},
+		{name: "unknown name", method: http.MethodGet, target: "/watchlists/crypto?account_type=robinhood", expectedStatus: http.StatusNotFound, expectedBody: CodeNotFound},
+		{name: "missing account type", method: http.MethodGet, target: "/watchlists", expectedStatus: http.StatusBadRequest},
+		{name: "sync to engine", method: http.MethodPost, target: "/watchlists/Tech/sync-to-engine?account_type=robinhood", expectedStatus: http.StatusOK, expectedBody: `"STOCK_SYMBOLS":"NVDA,TSLA"`},
+		{name: "sync unknown name", method: http.MethodPost, target: "/watchlists/crypto/sync-to-engine?account_type=robinhood", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newWatchlistService(t)
+			w := performRequest(NewHandler(s), tt.method, tt.target, "")
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Expected body to contain %s, got %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	// The sync response lists the symbols for the streamer
+	s, _ := newWatchlistService(t)
+	w := performRequest(NewHandler(s), http.MethodPost, "/watchlists/Default/sync-to-engine?account_type=robinhood", "")
+	var engine EngineSymbols
+	if err := json.Unmarshal(w.Body.Bytes(), &engine); err != nil {
+		t.Fatalf("Expected engine symbols, got %v", err)
+	}
+	if engine.Watchlist != "Default" || strings.Join(engine.StockSymbols, ",") != "AAPL,MSFT,NVDA" {
+		t.Errorf("Expected the Default symbols, got %+v", engine)
+	}
+}
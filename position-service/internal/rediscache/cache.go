@@ -0,0 +1,101 @@
+// Package rediscache is a position cache in Redis, shared by every replica
+// of the position service so they do not each fetch from the broker
+package rediscache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/trade-sonic/position-service/internal/position"
+)
+
+// DefaultTTL is how long cached positions are kept when no TTL is given
+const DefaultTTL = 5 * time.Minute
+
+// opTimeout bounds each Redis command, so an unreachable Redis costs a
+// request little before it falls back to the broker
+const opTimeout = time.Second
+
+// keyPrefix namespaces the cache keys
+const keyPrefix = "position-service:positions:"
+
+// Cache stores position lists in Redis as JSON, expiring them after a TTL
+type Cache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// Open creates a cache for the Redis server at url, e.g.
+// redis://localhost:6379/0. Zero ttl uses DefaultTTL. Connections are made on
+// demand, so Open succeeds while Redis is down; see Ping.
+func Open(url string, ttl time.Duration) (*Cache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return New(redis.NewClient(opts), ttl), nil
+}
+
+// New creates a cache on a Redis client. Zero ttl uses DefaultTTL.
+func New(client *redis.Client, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{client: client, ttl: ttl}
+}
+
+// Ping checks that Redis is reachable
+func (c *Cache) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	return c.client.Ping(ctx).Err()
+}
+
+// Get implements position.PositionCache
+func (c *Cache) Get(ctx context.Context, accountType position.AccountType, accountID string) (*position.PositionList, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, key(accountType, accountID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cached positions: %w", err)
+	}
+
+	var positions position.PositionList
+	if err := json.Unmarshal(data, &positions); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached positions: %w", err)
+	}
+	return &positions, true, nil
+}
+
+// Set implements position.PositionCache
+func (c *Cache) Set(ctx context.Context, accountType position.AccountType, accountID string, positions *position.PositionList) error {
+	data, err := json.Marshal(positions)
+	if err != nil {
+		return fmt.Errorf("failed to encode positions: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	if err := c.client.Set(ctx, key(accountType, accountID), data, c.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache positions: %w", err)
+	}
+	return nil
+}
+
+// Close closes the Redis connection
+func (c *Cache) Close() error {
+	return c.client.Close()
+}
+
+// key returns the cache key of an account
+func key(accountType position.AccountType, accountID string) string {
+	return keyPrefix + string(accountType) + ":" + accountID
+}
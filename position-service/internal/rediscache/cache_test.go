@@ -0,0 +1,99 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/trade-sonic/position-service/internal/position"
+)
+
+func newTestCache(t *testing.T, ttl time.Duration) (*Cache, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	c := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), ttl)
+	t.Cleanup(func() { c.Close() })
+	return c, mr
+}
+
+func TestCache_SetGet(t *testing.T) {
+	c, mr := newTestCache(t, time.Minute)
+	ctx := context.Background()
+
+	if _, ok, err := c.Get(ctx, position.Robinhood, "111"); ok || err != nil {
+		t.Fatalf("Expected a miss, got ok %v, error %v", ok, err)
+	}
+
+	updatedAt := time.Date(2025, 3, 10, 15, 0, 0, 0, time.UTC)
+	list := &position.PositionList{
+		Positions:    []position.Position{{ID: "pos-1", Symbol: "AAPL", Quantity: 2, MarketValue: 600}},
+		AccountID:    "111",
+		AccountLabel: "primary",
+		AccountType:  position.Robinhood,
+		UpdatedAt:    updatedAt,
+	}
+	if err := c.Set(ctx, position.Robinhood, "111", list); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cached, ok, err := c.Get(ctx, position.Robinhood, "111")
+	if err != nil || !ok {
+		t.Fatalf("Expected a hit, got ok %v, error %v", ok, err)
+	}
+	if cached.AccountLabel != "primary" || !cached.UpdatedAt.Equal(updatedAt) || len(cached.Positions) != 1 {
+		t.Errorf("Unexpected cached list: %+v", cached)
+	}
+	if p := cached.Positions[0]; p.ID != "pos-1" || p.Quantity != 2 || p.MarketValue != 600 {
+		t.Errorf("Unexpected cached position: %+v", p)
+	}
+
+	// Accounts are cached separately
+	if _, ok, _ := c.Get(ctx, position.Robinhood, "222"); ok {
+		t.Error("Expected a miss for another account")
+	}
+
+	// Entries expire after the TTL
+	if ttl := mr.TTL(key(position.Robinhood, "111")); ttl != time.Minute {
+		t.Errorf("Expected a TTL of 1m, got %v", ttl)
+	}
+	mr.FastForward(time.Minute)
+	if _, ok, _ := c.Get(ctx, position.Robinhood, "111"); ok {
+		t.Error("Expected the entry to expire")
+	}
+}
+
+func TestCache_DefaultTTL(t *testing.T) {
+	c, mr := newTestCache(t, 0)
+	if err := c.Set(context.Background(), position.Robinhood, "111", &position.PositionList{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ttl := mr.TTL(key(position.Robinhood, "111")); ttl != DefaultTTL {
+		t.Errorf("Expected the default TTL, got %v", ttl)
+	}
+}
+
+func TestCache_RedisDown(t *testing.T) {
+	c, mr := newTestCache(t, time.Minute)
+	ctx := context.Background()
+	mr.Close()
+
+	if err := c.Ping(ctx); err == nil {
+		t.Error("Expected ping to fail")
+	}
+	if _, ok, err := c.Get(ctx, position.Robinhood, "111"); err == nil || ok {
+		t.Errorf("Expected a read error, got ok %v, error %v", ok, err)
+	}
+	if err := c.Set(ctx, position.Robinhood, "111", &position.PositionList{}); err == nil {
+		t.Error("Expected a write error")
+	}
+}
+
+func TestCache_CorruptEntry(t *testing.T) {
+	c, mr := newTestCache(t, time.Minute)
+	mr.Set(key(position.Robinhood, "111"), "not json")
+
+	if _, ok, err := c.Get(context.Background(), position.Robinhood, "111"); err == nil || ok {
+		t.Errorf("Expected a decode error, got ok %v, error %v", ok, err)
+	}
+}
@@ -0,0 +1,185 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
+	"github.com/trade-sonic/position-service/internal/position"
+)
+
+const (
+	// defaultHistoryRange is queried when from is not given
+	defaultHistoryRange = 30 * 24 * time.Hour
+	// defaultHistoryInterval is used when interval is not given
+	defaultHistoryInterval = 24 * time.Hour
+)
+
+// HistoryStore defines the snapshot queries served by Handler
+type HistoryStore interface {
+	PositionHistory(ctx context.Context, symbol string, q HistoryQuery) ([]PositionPoint, error)
+	PortfolioHistory(ctx context.Context, q HistoryQuery) ([]PortfolioPoint, error)
+}
+
+// Handler serves position and portfolio history from stored snapshots
+type Handler struct {
+	store  HistoryStore
+	logger *slog.Logger
+}
+
+// HistoryRequest holds the query parameters of the history endpoints. from
+// and to accept RFC 3339 times or YYYY-MM-DD dates, interval accepts Go
+// durations and whole days such as 1d.
+type HistoryRequest struct {
+	Symbol   string `form:"symbol"`
+	From     string `form:"from"`
+	To       string `form:"to"`
+	Interval string `form:"interval"`
+}
+
+// NewHandler creates a new history handler
+func NewHandler(store HistoryStore) *Handler {
+	return &Handler{store: store, logger: slog.Default()}
+}
+
+// SetLogger replaces the logger of failed queries
+func (h *Handler) SetLogger(logger *slog.Logger) {
+	h.logger = logger
+}
+
+// PositionHistory handles GET /positions/history requests
+func (h *Handler) PositionHistory(c *gin.Context) {
+	var req HistoryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Symbol == "" {
+		writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, "symbol is required")
+		return
+	}
+
+	q, ok := parseHistoryQuery(c, req)
+	if !ok {
+		return
+	}
+
+	points, err := h.store.PositionHistory(c.Request.Context(), req.Symbol, q)
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":   strings.ToUpper(req.Symbol),
+		"interval": q.Interval.String(),
+		"points":   points,
+	})
+}
+
+// PortfolioHistory handles GET /portfolio/history requests
+func (h *Handler) PortfolioHistory(c *gin.Context) {
+	var req HistoryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	q, ok := parseHistoryQuery(c, req)
+	if !ok {
+		return
+	}
+
+	points, err := h.store.PortfolioHistory(c.Request.Context(), q)
+	if !h.respondError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interval": q.Interval.String(),
+		"points":   points,
+	})
+}
+
+// respondError writes the error response for a failed query and reports
+// whether the query succeeded. Store failures are logged, the response only
+// says that the query failed, so database errors never reach clients.
+func (h *Handler) respondError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrInvalidHistoryQuery):
+		writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, err.Error())
+	case errors.Is(err, context.Canceled):
+		// Nobody reads the response, the status only shows in access logs
+		writeError(c, position.StatusClientClosedRequest, position.CodeCanceled, "request canceled")
+	default:
+		h.logger.ErrorContext(c.Request.Context(), "Request failed", "path", c.Request.URL.Path, "error", err)
+		writeError(c, http.StatusInternalServerError, position.CodeInternal, "internal error")
+	}
+	return false
+}
+
+// writeError writes an error response in the position endpoints' format
+func writeError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, position.ErrorResponse{Code: code, Message: message, RequestID: logging.RequestID(c.Request.Context())})
+}
+
+// parseHistoryQuery converts a bound request into a query, defaulting to
+// daily points over the last 30 days. On failure the error response has
+// already been written and ok is false.
+func parseHistoryQuery(c *gin.Context, req HistoryRequest) (HistoryQuery, bool) {
+	q := HistoryQuery{
+		To:       time.Now().UTC(),
+		Interval: defaultHistoryInterval,
+	}
+
+	var err error
+	if req.To != "" {
+		if q.To, err = parseTime(req.To); err != nil {
+			writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, fmt.Sprintf("invalid to: %v", err))
+			return HistoryQuery{}, false
+		}
+	}
+	q.From = q.To.Add(-defaultHistoryRange)
+	if req.From != "" {
+		if q.From, err = parseTime(req.From); err != nil {
+			writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, fmt.Sprintf("invalid from: %v", err))
+			return HistoryQuery{}, false
+		}
+	}
+	if req.Interval != "" {
+		if q.Interval, err = parseInterval(req.Interval); err != nil {
+			writeError(c, http.StatusBadRequest, position.CodeInvalidRequest, fmt.Sprintf("invalid interval: %v", err))
+			return HistoryQuery{}, false
+		}
+	}
+
+	return q, true
+}
+
+// parseTime parses an RFC 3339 time or a YYYY-MM-DD date in UTC
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// parseInterval parses a Go duration or a whole number of days such as 7d
+func parseInterval(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("expected a positive number of days, got %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
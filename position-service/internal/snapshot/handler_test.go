@@ -0,0 +1,218 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/position-service/internal/position"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// historyBase is the first day of the seeded snapshots
+var historyBase = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// seedHistory stores snapshots of two accounts over three days. Day 0 has
+// snapshots of both accounts, day 1 none and day 2 one without NVDA.
+func seedHistory(t *testing.T, s *Store) {
+	nvda := func(quantity, marketValue, pnl float64) position.Position {
+		return position.Position{ID: "nvda", Symbol: "NVDA", Quantity: quantity, MarketValue: marketValue, CostBasis: marketValue - pnl, UnrealizedPnL: pnl}
+	}
+	aapl := func(marketValue, pnl float64) position.Position {
+		return position.Position{ID: "aapl", Symbol: "AAPL", Quantity: 1, MarketValue: marketValue, CostBasis: marketValue - pnl, UnrealizedPnL: pnl}
+	}
+	snapshot := func(accountID string, takenAt time.Time, positions ...position.Position) *position.PositionList {
+		return &position.PositionList{Positions: positions, AccountID: accountID, AccountType: position.Robinhood, UpdatedAt: takenAt}
+	}
+
+	snapshots := []*position.PositionList{
+		snapshot("111", historyBase.Add(10*time.Hour), nvda(10, 1000, 100)),
+		snapshot("111", historyBase.Add(14*time.Hour), nvda(10, 1200, 300)),
+		snapshot("222", historyBase.Add(12*time.Hour), nvda(5, 500, 50), aapl(200, -20)),
+		snapshot("111", historyBase.Add(2*24*time.Hour+9*time.Hour), aapl(300, 30)),
+	}
+	for _, list := range snapshots {
+		if err := s.SaveSnapshot(context.Background(), list); err != nil {
+			t.Fatalf("Failed to seed snapshot: %v", err)
+		}
+	}
+}
+
+// performHistoryRequest runs a request through a router with the history routes registered
+func performHistoryRequest(h *Handler, target string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/positions/history", h.PositionHistory)
+	r.GET("/portfolio/history", h.PortfolioHistory)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestHandler_PositionHistory(t *testing.T) {
+	s, _ := newSQLiteStore(t, 0)
+	seedHistory(t, s)
+
+	w := performHistoryRequest(NewHandler(s), "/positions/history?symbol=nvda&from=2025-03-01&to=2025-03-04&interval=1d")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Symbol string          `json:"symbol"`
+		Points []PositionPoint `json:"points"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Symbol != "NVDA" {
+		t.Errorf("Expected symbol NVDA, got %s", resp.Symbol)
+	}
+
+	expected := []PositionPoint{
+		// Account 111 is averaged over its two snapshots, then summed with account 222
+		{Time: historyBase, Quantity: float(15), MarketValue: float(1600), UnrealizedPnL: float(250), Samples: 3},
+		// No snapshots, so a gap
+		{Time: historyBase.Add(24 * time.Hour)},
+		// A snapshot without NVDA means the position was closed
+		{Time: historyBase.Add(48 * time.Hour), Quantity: float(0), MarketValue: float(0), UnrealizedPnL: float(0), Samples: 1},
+	}
+	if len(resp.Points) != len(expected) {
+		t.Fatalf("Expected %d points, got %d: %s", len(expected), len(resp.Points), w.Body.String())
+	}
+	for i, want := range expected {
+		assertPoint(t, i, want.Time, resp.Points[i].Time, want.Samples, resp.Points[i].Samples,
+			[]*float64{want.Quantity, want.MarketValue, want.UnrealizedPnL},
+			[]*float64{resp.Points[i].Quantity, resp.Points[i].MarketValue, resp.Points[i].UnrealizedPnL})
+	}
+}
+
+func TestHandler_PortfolioHistory(t *testing.T) {
+	s, _ := newSQLiteStore(t, 0)
+	seedHistory(t, s)
+
+	w := performHistoryRequest(NewHandler(s), "/portfolio/history?from=2025-03-01T00:00:00Z&to=2025-03-04T00:00:00Z&interval=24h")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Points []PortfolioPoint `json:"points"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []PortfolioPoint{
+		{Time: historyBase, MarketValue: float(1800), CostBasis: float(1570), UnrealizedPnL: float(230), Samples: 3},
+		{Time: historyBase.Add(24 * time.Hour)},
+		{Time: historyBase.Add(48 * time.Hour), MarketValue: float(300), CostBasis: float(270), UnrealizedPnL: float(30), Samples: 1},
+	}
+	if len(resp.Points) != len(expected) {
+		t.Fatalf("Expected %d points, got %d: %s", len(expected), len(resp.Points), w.Body.String())
+	}
+	for i, want := range expected {
+		assertPoint(t, i, want.Time, resp.Points[i].Time, want.Samples, resp.Points[i].Samples,
+			[]*float64{want.MarketValue, want.CostBasis, want.UnrealizedPnL},
+			[]*float64{resp.Points[i].MarketValue, resp.Points[i].CostBasis, resp.Points[i].UnrealizedPnL})
+	}
+}
+
+func assertPoint(t *testing.T, i int, wantTime, gotTime time.Time, wantSamples, gotSamples int, want, got []*float64) {
+	t.Helper()
+	if !gotTime.Equal(wantTime) {
+		t.Errorf("Point %d: expected time %s, got %s", i, wantTime, gotTime)
+	}
+	if gotSamples != wantSamples {
+		t.Errorf("Point %d: expected %d samples, got %d", i, wantSamples, gotSamples)
+	}
+	for j := range want {
+		switch {
+		case want[j] == nil && got[j] != nil:
+			t.Errorf("Point %d: expected a gap, got value %v", i, *got[j])
+		case want[j] != nil && got[j] == nil:
+			t.Errorf("Point %d: expected value %v, got a gap", i, *want[j])
+		case want[j] != nil && *want[j] != *got[j]:
+			t.Errorf("Point %d: expected value %v, got %v", i, *want[j], *got[j])
+		}
+	}
+}
+
+func TestHandler_HistoryInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{name: "missing symbol", target: "/positions/history?interval=1d"},
+		{name: "invalid from", target: "/portfolio/history?from=yesterday"},
+		{name: "invalid interval", target: "/portfolio/history?interval=daily"},
+		{name: "from after to", target: "/portfolio/history?from=2025-03-04&to=2025-03-01"},
+		{name: "too many points", target: "/portfolio/history?from=2024-01-01&to=2025-01-01&interval=1m"},
+	}
+
+	s, _ := newSQLiteStore(t, 0)
+	h := NewHandler(s)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performHistoryRequest(h, tt.target)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+			var resp position.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != position.CodeInvalidRequest || resp.Message == "" {
+				t.Errorf("Expected an invalid_request error response, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+// failingHistoryStore fails every query with err
+type failingHistoryStore struct {
+	err error
+}
+
+func (s failingHistoryStore) PositionHistory(ctx context.Context, symbol string, q HistoryQuery) ([]PositionPoint, error) {
+	return nil, s.err
+}
+
+func (s failingHistoryStore) PortfolioHistory(ctx context.Context, q HistoryQuery) ([]PortfolioPoint, error) {
+	return nil, s.err
+}
+
+func TestHandler_HistoryStoreFailure(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewHandler(failingHistoryStore{err: errors.New(`pq: relation "position_snapshots" does not exist`)})
+	h.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	for _, target := range []string{"/positions/history?symbol=nvda", "/portfolio/history"} {
+		w := performHistoryRequest(h, target)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusInternalServerError, target, w.Code)
+		}
+		var resp position.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != position.CodeInternal {
+			t.Errorf("Expected an internal error response for %s, got %s", target, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "position_snapshots") {
+			t.Errorf("Expected the database error to stay out of the response, got %s", w.Body.String())
+		}
+	}
+	if !strings.Contains(logs.String(), "position_snapshots") {
+		t.Errorf("Expected the database error to be logged, got %q", logs.String())
+	}
+}
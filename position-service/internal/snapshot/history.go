@@ -0,0 +1,190 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxHistoryPoints bounds the number of buckets a history query may return
+const MaxHistoryPoints = 5000
+
+// ErrInvalidHistoryQuery is returned for history queries with an invalid
+// range or interval, or spanning too many intervals
+var ErrInvalidHistoryQuery = errors.New("invalid history query")
+
+// HistoryQuery selects the time range and bucket size of a history query.
+// Buckets are aligned to the Unix epoch, so daily buckets start at midnight UTC.
+type HistoryQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval time.Duration
+}
+
+// PositionPoint is a position's holdings sampled over one bucket. Values are
+// nil when no snapshot was taken during the bucket.
+type PositionPoint struct {
+	Time          time.Time `json:"time"`
+	Quantity      *float64  `json:"quantity"`
+	MarketValue   *float64  `json:"market_value"`
+	UnrealizedPnL *float64  `json:"unrealized_pnl"`
+	Samples       int       `json:"samples"`
+}
+
+// PortfolioPoint is the portfolio totals sampled over one bucket. Values are
+// nil when no snapshot was taken during the bucket.
+type PortfolioPoint struct {
+	Time          time.Time `json:"time"`
+	MarketValue   *float64  `json:"market_value"`
+	CostBasis     *float64  `json:"cost_basis"`
+	UnrealizedPnL *float64  `json:"unrealized_pnl"`
+	Samples       int       `json:"samples"`
+}
+
+// buckets returns the first bucket and the number of buckets covered by the query
+func (q HistoryQuery) buckets() (first, count int64, err error) {
+	seconds := int64(q.Interval / time.Second)
+	if seconds < 1 {
+		return 0, 0, fmt.Errorf("%w: interval must be at least one second", ErrInvalidHistoryQuery)
+	}
+	if !q.To.After(q.From) {
+		return 0, 0, fmt.Errorf("%w: from must be before to", ErrInvalidHistoryQuery)
+	}
+
+	first = q.From.Unix() / seconds
+	last := (q.To.Unix() - 1) / seconds
+	count = last - first + 1
+	if count > MaxHistoryPoints {
+		return 0, 0, fmt.Errorf("%w: %d intervals exceeds the limit of %d", ErrInvalidHistoryQuery, count, MaxHistoryPoints)
+	}
+	return first, count, nil
+}
+
+// epochSeconds returns the dialect's expression for a timestamp column in Unix seconds
+func (s *Store) epochSeconds(column string) string {
+	if s.dialect == SQLite {
+		return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", column)
+	}
+	return fmt.Sprintf("CAST(FLOOR(EXTRACT(EPOCH FROM %s)) AS BIGINT)", column)
+}
+
+// PositionHistory returns the quantity, market value and unrealized P&L held
+// in a symbol, summed over its contracts and accounts. Each account's
+// snapshots are averaged per bucket in SQL; buckets without any snapshot are
+// returned as gaps.
+func (s *Store) PositionHistory(ctx context.Context, symbol string, q HistoryQuery) ([]PositionPoint, error) {
+	first, count, err := q.buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots that do not hold the symbol count as zero, not as gaps
+	query := fmt.Sprintf(`
+SELECT bucket, SUM(quantity), SUM(market_value), SUM(unrealized_pnl), SUM(samples)
+FROM (
+	SELECT bucket, account_type, account_id,
+		AVG(quantity) AS quantity, AVG(market_value) AS market_value, AVG(unrealized_pnl) AS unrealized_pnl, COUNT(*) AS samples
+	FROM (
+		SELECT %s / $1 AS bucket, s.account_type, s.account_id,
+			COALESCE(SUM(p.quantity), 0) AS quantity,
+			COALESCE(SUM(p.market_value), 0) AS market_value,
+			COALESCE(SUM(p.unrealized_pnl), 0) AS unrealized_pnl
+		FROM position_snapshots s
+		LEFT JOIN snapshot_positions p ON p.snapshot_id = s.id AND UPPER(p.symbol) = $2
+		WHERE s.taken_at >= $3 AND s.taken_at < $4
+		GROUP BY s.id, s.account_type, s.account_id, s.taken_at
+	) per_snapshot
+	GROUP BY bucket, account_type, account_id
+) per_account
+GROUP BY bucket
+ORDER BY bucket`, s.epochSeconds("s.taken_at"))
+
+	rows, err := s.db.QueryContext(ctx, query, int64(q.Interval/time.Second), strings.ToUpper(symbol), q.From.UTC(), q.To.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query position history: %w", err)
+	}
+	defer rows.Close()
+
+	points := make([]PositionPoint, count)
+	for i := range points {
+		points[i].Time = bucketTime(first+int64(i), q.Interval)
+	}
+	for rows.Next() {
+		var bucket int64
+		var quantity, marketValue, unrealizedPnL float64
+		var samples int
+		if err := rows.Scan(&bucket, &quantity, &marketValue, &unrealizedPnL, &samples); err != nil {
+			return nil, fmt.Errorf("failed to scan position history: %w", err)
+		}
+		if i := bucket - first; i >= 0 && i < count {
+			points[i].Quantity = &quantity
+			points[i].MarketValue = &marketValue
+			points[i].UnrealizedPnL = &unrealizedPnL
+			points[i].Samples = samples
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate position history: %w", err)
+	}
+
+	return points, nil
+}
+
+// PortfolioHistory returns the portfolio totals summed over accounts. Each
+// account's snapshots are averaged per bucket in SQL; buckets without any
+// snapshot are returned as gaps.
+func (s *Store) PortfolioHistory(ctx context.Context, q HistoryQuery) ([]PortfolioPoint, error) {
+	first, count, err := q.buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+SELECT bucket, SUM(market_value), SUM(cost_basis), SUM(unrealized_pnl), SUM(samples)
+FROM (
+	SELECT %s / $1 AS bucket, account_type, account_id,
+		AVG(total_market_value) AS market_value, AVG(total_cost_basis) AS cost_basis, AVG(total_unrealized_pnl) AS unrealized_pnl, COUNT(*) AS samples
+	FROM position_snapshots
+	WHERE taken_at >= $2 AND taken_at < $3
+	GROUP BY bucket, account_type, account_id
+) per_account
+GROUP BY bucket
+ORDER BY bucket`, s.epochSeconds("taken_at"))
+
+	rows, err := s.db.QueryContext(ctx, query, int64(q.Interval/time.Second), q.From.UTC(), q.To.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query portfolio history: %w", err)
+	}
+	defer rows.Close()
+
+	points := make([]PortfolioPoint, count)
+	for i := range points {
+		points[i].Time = bucketTime(first+int64(i), q.Interval)
+	}
+	for rows.Next() {
+		var bucket int64
+		var marketValue, costBasis, unrealizedPnL float64
+		var samples int
+		if err := rows.Scan(&bucket, &marketValue, &costBasis, &unrealizedPnL, &samples); err != nil {
+			return nil, fmt.Errorf("failed to scan portfolio history: %w", err)
+		}
+		if i := bucket - first; i >= 0 && i < count {
+			points[i].MarketValue = &marketValue
+			points[i].CostBasis = &costBasis
+			points[i].UnrealizedPnL = &unrealizedPnL
+			points[i].Samples = samples
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate portfolio history: %w", err)
+	}
+
+	return points, nil
+}
+
+// bucketTime returns the start time of a bucket
+func bucketTime(bucket int64, interval time.Duration) time.Time {
+	return time.Unix(bucket*int64(interval/time.Second), 0).UTC()
+}
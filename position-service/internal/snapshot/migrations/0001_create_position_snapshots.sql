@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS position_snapshots (
+	id                   BIGSERIAL PRIMARY KEY,
+	account_type         TEXT             NOT NULL,
+	account_id           TEXT             NOT NULL,
+	account_label        TEXT             NOT NULL,
+	taken_at             TIMESTAMPTZ      NOT NULL,
+	position_count       INTEGER          NOT NULL,
+	total_market_value   DOUBLE PRECISION NOT NULL,
+	total_cost_basis     DOUBLE PRECISION NOT NULL,
+	total_unrealized_pnl DOUBLE PRECISION NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_position_snapshots_account_taken_at ON position_snapshots (account_type, account_id, taken_at);
+
+CREATE TABLE IF NOT EXISTS snapshot_positions (
+	snapshot_id     BIGINT           NOT NULL REFERENCES position_snapshots (id) ON DELETE CASCADE,
+	position_id     TEXT             NOT NULL,
+	symbol          TEXT             NOT NULL,
+	quantity        DOUBLE PRECISION NOT NULL,
+	average_price   DOUBLE PRECISION NOT NULL,
+	current_price   DOUBLE PRECISION NOT NULL,
+	market_value    DOUBLE PRECISION NOT NULL,
+	cost_basis      DOUBLE PRECISION NOT NULL,
+	unrealized_pnl  DOUBLE PRECISION NOT NULL,
+	option_type     TEXT             NOT NULL,
+	strike_price    DOUBLE PRECISION NOT NULL,
+	expiration_date TEXT             NOT NULL,
+	multiplier      DOUBLE PRECISION NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_snapshot_positions_snapshot_id ON snapshot_positions (snapshot_id);
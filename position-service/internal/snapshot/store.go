@@ -0,0 +1,268 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trade-sonic/position-service/internal/position"
+
+	_ "github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Dialect is the SQL dialect of the snapshot database
+type Dialect string
+
+const (
+	// Postgres is the production snapshot database
+	Postgres Dialect = "postgres"
+	// SQLite is a local fallback, mainly for tests. The caller must register
+	// a SQLite driver.
+	SQLite Dialect = "sqlite3"
+)
+
+// insertBatchSize bounds the rows per INSERT so statements stay well below
+// the bind parameter limits of both dialects
+const insertBatchSize = 100
+
+// Store persists position snapshots for charting portfolio value over time
+type Store struct {
+	db        *sql.DB
+	dialect   Dialect
+	retention time.Duration
+}
+
+// Open connects to the Postgres database at databaseURL and applies pending
+// migrations. Snapshots older than retention are deleted as new ones are
+// saved; zero keeps every snapshot.
+func Open(databaseURL string, retention time.Duration) (*Store, error) {
+	db, err := sql.Open(string(Postgres), databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot database: %w", err)
+	}
+
+	s, err := New(context.Background(), db, Postgres, retention)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New creates a store on an open database and applies pending migrations
+func New(ctx context.Context, db *sql.DB, dialect Dialect, retention time.Duration) (*Store, error) {
+	s := &Store{db: db, dialect: dialect, retention: retention}
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the embedded migrations that have not been applied yet, in order
+func (s *Store) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS snapshot_schema_migrations (version TEXT PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("failed to list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+
+		var applied int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM snapshot_schema_migrations WHERE version = $1`, version).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("failed to read migration %s: %w", version, err)
+		}
+
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %s: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.rewrite(string(script))); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO snapshot_schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+// rewrite adapts Postgres DDL to the store's dialect
+func (s *Store) rewrite(script string) string {
+	if s.dialect != SQLite {
+		return script
+	}
+	return strings.NewReplacer(
+		"BIGSERIAL PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"TIMESTAMPTZ", "TIMESTAMP",
+	).Replace(script)
+}
+
+// SaveSnapshot implements position.SnapshotStore. It records the positions
+// of a single account along with their totals, then applies the retention
+// policy.
+func (s *Store) SaveSnapshot(ctx context.Context, positions *position.PositionList) error {
+	takenAt := positions.UpdatedAt.UTC()
+	if takenAt.IsZero() {
+		takenAt = time.Now().UTC()
+	}
+
+	var marketValue, costBasis, unrealizedPnL float64
+	for _, p := range positions.Positions {
+		marketValue += p.MarketValue
+		costBasis += p.CostBasis
+		unrealizedPnL += p.UnrealizedPnL
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	var snapshotID int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO position_snapshots (account_type, account_id, account_label, taken_at, position_count, total_market_value, total_cost_basis, total_unrealized_pnl)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		string(positions.AccountType),
+		positions.AccountID,
+		positions.AccountLabel,
+		takenAt,
+		len(positions.Positions),
+		marketValue,
+		costBasis,
+		unrealizedPnL,
+	).Scan(&snapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	for start := 0; start < len(positions.Positions); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(positions.Positions) {
+			end = len(positions.Positions)
+		}
+		if err := insertPositions(ctx, tx, snapshotID, positions.Positions[start:end]); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit snapshot: %w", err)
+	}
+
+	if s.retention > 0 {
+		if _, err := s.DeleteBefore(ctx, time.Now().Add(-s.retention)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPositions writes a batch of positions with a single multi-row INSERT
+func insertPositions(ctx context.Context, tx *sql.Tx, snapshotID int64, positions []position.Position) error {
+	const columns = 13
+
+	var query strings.Builder
+	query.WriteString(`INSERT INTO snapshot_positions (snapshot_id, position_id, symbol, quantity, average_price, current_price, market_value, cost_basis, unrealized_pnl, option_type, strike_price, expiration_date, multiplier) VALUES `)
+	args := make([]interface{}, 0, len(positions)*columns)
+	for i, p := range positions {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for c := 1; c <= columns; c++ {
+			if c > 1 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*columns+c)
+		}
+		query.WriteString(")")
+
+		args = append(args,
+			snapshotID,
+			p.ID,
+			p.Symbol,
+			p.Quantity,
+			p.AveragePrice,
+			p.CurrentPrice,
+			p.MarketValue,
+			p.CostBasis,
+			p.UnrealizedPnL,
+			string(p.OptionType),
+			p.StrikePrice,
+			p.ExpirationDate,
+			p.Multiplier,
+		)
+	}
+
+	if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("failed to save snapshot positions: %w", err)
+	}
+	return nil
+}
+
+// DeleteBefore deletes snapshots taken before cutoff and returns how many were deleted
+func (s *Store) DeleteBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin snapshot cleanup: %w", err)
+	}
+	defer tx.Rollback()
+
+	cutoff = cutoff.UTC()
+
+	// Delete the positions explicitly, SQLite only cascades with foreign keys enabled
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM snapshot_positions WHERE snapshot_id IN (SELECT id FROM position_snapshots WHERE taken_at < $1)`,
+		cutoff,
+	); err != nil {
+		return 0, fmt.Errorf("failed to delete old snapshot positions: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM position_snapshots WHERE taken_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old snapshots: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted snapshots: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit snapshot cleanup: %w", err)
+	}
+	return deleted, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,135 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trade-sonic/position-service/internal/position"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteStore creates a store on a fresh SQLite database, the
+// dependency-free stand-in for Postgres
+func newSQLiteStore(t *testing.T, retention time.Duration) (*Store, *sql.DB) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	s, err := New(context.Background(), db, SQLite, retention)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return s, db
+}
+
+func positionList(takenAt time.Time, positions ...position.Position) *position.PositionList {
+	return &position.PositionList{
+		Positions:    positions,
+		AccountID:    "111",
+		AccountLabel: position.PrimaryAccountLabel,
+		AccountType:  position.Robinhood,
+		UpdatedAt:    takenAt,
+	}
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("Failed to count %s: %v", table, err)
+	}
+	return count
+}
+
+func TestStore_SaveSnapshot(t *testing.T) {
+	s, db := newSQLiteStore(t, 0)
+
+	list := positionList(time.Now(),
+		position.Position{ID: "aapl", Symbol: "AAPL", Quantity: 2, MarketValue: 600, CostBasis: 500, UnrealizedPnL: 100, OptionType: position.Call, StrikePrice: 200, ExpirationDate: "2025-06-20", Multiplier: 100},
+		position.Position{ID: "msft", Symbol: "MSFT", Quantity: 1, MarketValue: 100, CostBasis: 150, UnrealizedPnL: -50, OptionType: position.Put, StrikePrice: 400, ExpirationDate: "2025-04-17", Multiplier: 100},
+	)
+	if err := s.SaveSnapshot(context.Background(), list); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var count int
+	var marketValue, costBasis, unrealizedPnL float64
+	err := db.QueryRow(`SELECT position_count, total_market_value, total_cost_basis, total_unrealized_pnl FROM position_snapshots`).
+		Scan(&count, &marketValue, &costBasis, &unrealizedPnL)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if count != 2 || marketValue != 700 || costBasis != 650 || unrealizedPnL != 50 {
+		t.Errorf("Unexpected totals: count=%d market_value=%.2f cost_basis=%.2f unrealized_pnl=%.2f", count, marketValue, costBasis, unrealizedPnL)
+	}
+
+	var symbol, optionType string
+	var strikePrice float64
+	err = db.QueryRow(`SELECT symbol, option_type, strike_price FROM snapshot_positions WHERE position_id = 'msft'`).
+		Scan(&symbol, &optionType, &strikePrice)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot position: %v", err)
+	}
+	if symbol != "MSFT" || optionType != "put" || strikePrice != 400 {
+		t.Errorf("Unexpected snapshot position: %s %s %.2f", symbol, optionType, strikePrice)
+	}
+}
+
+func TestStore_SaveSnapshotInBatches(t *testing.T) {
+	s, db := newSQLiteStore(t, 0)
+
+	positions := make([]position.Position, insertBatchSize*2+5)
+	for i := range positions {
+		positions[i] = position.Position{ID: fmt.Sprintf("pos-%d", i), Symbol: "AAPL", MarketValue: 1}
+	}
+	if err := s.SaveSnapshot(context.Background(), positionList(time.Now(), positions...)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := countRows(t, db, "snapshot_positions"); got != len(positions) {
+		t.Errorf("Expected %d snapshot positions, got %d", len(positions), got)
+	}
+}
+
+func TestStore_Retention(t *testing.T) {
+	s, db := newSQLiteStore(t, 7*24*time.Hour)
+	ctx := context.Background()
+
+	old := positionList(time.Now().Add(-30*24*time.Hour), position.Position{ID: "old", Symbol: "AAPL"})
+	recent := positionList(time.Now().Add(-24*time.Hour), position.Position{ID: "recent", Symbol: "AAPL"})
+	if err := s.SaveSnapshot(ctx, old); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := s.SaveSnapshot(ctx, recent); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := countRows(t, db, "position_snapshots"); got != 1 {
+		t.Errorf("Expected the old snapshot to be deleted, got %d snapshots", got)
+	}
+	var positionID string
+	if err := db.QueryRow(`SELECT position_id FROM snapshot_positions`).Scan(&positionID); err != nil {
+		t.Fatalf("Failed to read snapshot position: %v", err)
+	}
+	if positionID != "recent" {
+		t.Errorf("Expected only the recent snapshot's positions to remain, got %s", positionID)
+	}
+}
+
+func TestStore_MigrationsAreIdempotent(t *testing.T) {
+	s, db := newSQLiteStore(t, 0)
+
+	if _, err := New(context.Background(), db, SQLite, 0); err != nil {
+		t.Fatalf("Expected migrations to be skipped once applied, got %v", err)
+	}
+	if err := s.SaveSnapshot(context.Background(), positionList(time.Now())); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package testutil provides fakes for testing the position-service without
+// network access
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trade-sonic/position-service/internal/position"
+)
+
+// FakePositionProvider is an in-memory position.PositionProvider. Every call
+// fails with Err when it is set and otherwise returns the result configured
+// for it; calls without a configured result fail with
+// position.ErrNotConfigured. The queries received are recorded.
+type FakePositionProvider struct {
+	mu sync.Mutex
+
+	Err error
+
+	// Results
+	PositionList      *position.PositionList
+	SpreadList        *position.SpreadList
+	PositionDelta     *position.PositionDelta
+	OrderList         *position.OrderList
+	RealizedPnLReport *position.RealizedPnLReport
+	DividendReport    *position.DividendReport
+	WatchlistList     *position.WatchlistList // Watchlist looks the names up here too
+	HealthReport      position.HealthReport
+	Refreshes         []position.RefreshStatus
+	Breaker           position.BreakerStatus
+	Mock              bool
+	Retries           int64
+	Wait              time.Duration
+	RawPositions      []position.RawPositions
+	// PositionUpdates is returned by SubscribePositions, nil blocks forever
+	PositionUpdates chan *position.PositionList
+
+	// Recorded calls
+	PositionQueries []position.PositionQuery
+	OrderQueries    []position.OrderQuery
+	PnLQueries      []position.PnLQuery
+	DividendQueries []position.DividendQuery
+	DeltaSince      []time.Time
+	DebugCapture    bool
+
+	logger *slog.Logger
+}
+
+// NewFakePositionProvider returns a fake with a closed circuit breaker and a
+// logger that discards its output
+func NewFakePositionProvider() *FakePositionProvider {
+	return &FakePositionProvider{
+		Breaker: position.BreakerStatus{State: position.BreakerClosed},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// GetPositions implements position.PositionProvider
+func (f *FakePositionProvider) GetPositions(ctx context.Context, accountType position.AccountType) (*position.PositionList, error) {
+	return f.QueryPositions(ctx, position.PositionQuery{AccountType: accountType})
+}
+
+// QueryPositions implements position.PositionProvider
+func (f *FakePositionProvider) QueryPositions(ctx context.Context, q position.PositionQuery) (*position.PositionList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.PositionQueries = append(f.PositionQueries, q)
+	return result(f.PositionList, f.Err)
+}
+
+// Spreads implements position.PositionProvider
+func (f *FakePositionProvider) Spreads(ctx context.Context, q position.PositionQuery) (*position.SpreadList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.PositionQueries = append(f.PositionQueries, q)
+	return result(f.SpreadList, f.Err)
+}
+
+// PositionsDelta implements position.PositionProvider
+func (f *FakePositionProvider) PositionsDelta(ctx context.Context, q position.PositionQuery, since time.Time) (*position.PositionDelta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.PositionQueries = append(f.PositionQueries, q)
+	f.DeltaSince = append(f.DeltaSince, since)
+	return result(f.PositionDelta, f.Err)
+}
+
+// ListOrders implements position.PositionProvider
+func (f *FakePositionProvider) ListOrders(ctx context.Context, q position.OrderQuery) (*position.OrderList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.OrderQueries = append(f.OrderQueries, q)
+	return result(f.OrderList, f.Err)
+}
+
+// RealizedPnL implements position.PositionProvider
+func (f *FakePositionProvider) RealizedPnL(ctx context.Context, q position.PnLQuery) (*position.RealizedPnLReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.PnLQueries = append(f.PnLQueries, q)
+	return result(f.RealizedPnLReport, f.Err)
+}
+
+// Dividends implements position.PositionProvider
+func (f *FakePositionProvider) Dividends(ctx context.Context, q position.DividendQuery) (*position.DividendReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.DividendQueries = append(f.DividendQueries, q)
+	return result(f.DividendReport, f.Err)
+}
+
+// Watchlists implements position.PositionProvider
+func (f *FakePositionProvider) Watchlists(ctx context.Context, accountType position.AccountType) (*position.WatchlistList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return result(f.WatchlistList, f.Err)
+}
+
+// Watchlist implements position.PositionProvider, looking the name up in
+// WatchlistList ignoring case
+func (f *FakePositionProvider) Watchlist(ctx context.Context, accountType position.AccountType, name string) (*position.Watchlist, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lists, err := result(f.WatchlistList, f.Err)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lists.Watchlists {
+		if strings.EqualFold(lists.Watchlists[i].Name, name) {
+			return &lists.Watchlists[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", position.ErrWatchlistNotFound, name)
+}
+
+// SubscribePositions implements position.PositionProvider
+func (f *FakePositionProvider) SubscribePositions(accountType position.AccountType, account string) (<-chan *position.PositionList, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.PositionUpdates, func() {}, nil
+}
+
+// CheckHealth implements position.PositionProvider
+func (f *FakePositionProvider) CheckHealth(ctx context.Context, accountType position.AccountType) position.HealthReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.HealthReport
+}
+
+// RefreshStatus implements position.PositionProvider
+func (f *FakePositionProvider) RefreshStatus() []position.RefreshStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Refreshes
+}
+
+// CircuitBreakerStatus implements position.PositionProvider
+func (f *FakePositionProvider) CircuitBreakerStatus() position.BreakerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Breaker
+}
+
+// IsMock implements position.PositionProvider
+func (f *FakePositionProvider) IsMock() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Mock
+}
+
+// RetryCount implements position.PositionProvider
+func (f *FakePositionProvider) RetryCount() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Retries
+}
+
+// RateLimitWait implements position.PositionProvider
+func (f *FakePositionProvider) RateLimitWait() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Wait
+}
+
+// SetDebugCapture implements position.PositionProvider
+func (f *FakePositionProvider) SetDebugCapture(enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.DebugCapture = enabled
+}
+
+// LastRawPositions implements position.PositionProvider
+func (f *FakePositionProvider) LastRawPositions() []position.RawPositions {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.RawPositions
+}
+
+// Logger implements position.PositionProvider
+func (f *FakePositionProvider) Logger() *slog.Logger {
+	return f.logger
+}
+
+// result returns err when it is set, the configured value otherwise, and
+// position.ErrNotConfigured when there is none
+func result[T any](value *T, err error) (*T, error) {
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, position.ErrNotConfigured
+	}
+	return value, nil
+}
@@ -0,0 +1,274 @@
+package robinhood
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Numbers are returned by the API as decimal strings and kept that way, so
+// each service decides how to parse and fall back.
+
+// OptionPosition is an option position of an account
+type OptionPosition struct {
+	Account                   string `json:"account"`
+	AccountNumber             string `json:"account_number"`
+	AveragePrice              string `json:"average_price"`
+	ChainID                   string `json:"chain_id"`
+	ChainSymbol               string `json:"chain_symbol"`
+	ID                        string `json:"id"`
+	Option                    string `json:"option"` // Option instrument URL
+	Type                      string `json:"type"`   // long or short
+	PendingBuyQuantity        string `json:"pending_buy_quantity"`
+	PendingExpiredQuantity    string `json:"pending_expired_quantity"`
+	PendingExpirationQuantity string `json:"pending_expiration_quantity"`
+	PendingExerciseQuantity   string `json:"pending_exercise_quantity"`
+	PendingAssignmentQuantity string `json:"pending_assignment_quantity"`
+	PendingSellQuantity       string `json:"pending_sell_quantity"`
+	Quantity                  string `json:"quantity"`
+	IntradayQuantity          string `json:"intraday_quantity"`
+	IntradayAverageOpenPrice  string `json:"intraday_average_open_price"`
+	CreatedAt                 string `json:"created_at"`
+	ExpirationDate            string `json:"expiration_date"`
+	TradeValueMultiplier      string `json:"trade_value_multiplier"`
+	UpdatedAt                 string `json:"updated_at"`
+	URL                       string `json:"url"`
+	OptionID                  string `json:"option_id"`
+	ClearingRunningQuantity   string `json:"clearing_running_quantity"`
+	ClearingCostBasis         string `json:"clearing_cost_basis"`
+	ClearingDirection         string `json:"clearing_direction"` // debit or credit
+}
+
+// OptionPositionQuery selects the positions returned by GetOptionPositions
+type OptionPositionQuery struct {
+	AccountNumber string
+	// NonZero excludes closed positions
+	NonZero bool
+}
+
+// OptionMarketData is the market data of an option instrument
+type OptionMarketData struct {
+	InstrumentID      string `json:"instrument_id"`
+	MarkPrice         string `json:"mark_price"`
+	AdjustedMarkPrice string `json:"adjusted_mark_price"`
+	LastTradePrice    string `json:"last_trade_price"`
+	BidPrice          string `json:"bid_price"`
+	AskPrice          string `json:"ask_price"`
+	Delta             string `json:"delta"` // Empty when Robinhood has no greeks for the contract
+}
+
+// Quote is the quote of an equity
+type Quote struct {
+	Symbol                      string `json:"symbol"`
+	LastTradePrice              string `json:"last_trade_price"`
+	LastExtendedHoursTradePrice string `json:"last_extended_hours_trade_price"`
+	BidPrice                    string `json:"bid_price"`
+	AskPrice                    string `json:"ask_price"`
+	UpdatedAt                   string `json:"updated_at"`
+}
+
+// Account is a brokerage account of the authenticated user
+type Account struct {
+	URL                  string `json:"url"`
+	AccountNumber        string `json:"account_number"`
+	Type                 string `json:"type"`                   // e.g. cash or margin
+	BrokerageAccountType string `json:"brokerage_account_type"` // e.g. individual or ira_roth
+	Deactivated          bool   `json:"deactivated"`
+	CreatedAt            string `json:"created_at"`
+}
+
+// Dividend is a dividend paid or scheduled on an equity position. The
+// dividends endpoint lists those of every account of the user.
+type Dividend struct {
+	ID          string `json:"id"`
+	Account     string `json:"account"`    // Account URL
+	Instrument  string `json:"instrument"` // Equity instrument URL
+	Amount      string `json:"amount"`
+	Rate        string `json:"rate"`
+	Position    string `json:"position"`
+	PayableDate string `json:"payable_date"`
+	State       string `json:"state"`
+}
+
+// Execution is a single execution of an order or order leg
+type Execution struct {
+	ID        string `json:"id"`
+	Price     string `json:"price"`
+	Quantity  string `json:"quantity"`
+	Timestamp string `json:"timestamp"`
+}
+
+// EquityOrder is an equity order of an account
+type EquityOrder struct {
+	ID                 string      `json:"id"`
+	Instrument         string      `json:"instrument"` // Equity instrument URL
+	Side               string      `json:"side"`
+	Type               string      `json:"type"`
+	State              string      `json:"state"`
+	Quantity           string      `json:"quantity"`
+	CumulativeQuantity string      `json:"cumulative_quantity"`
+	AveragePrice       string      `json:"average_price"`
+	Fees               string      `json:"fees"`
+	Executions         []Execution `json:"executions"`
+	CreatedAt          string      `json:"created_at"`
+	UpdatedAt          string      `json:"updated_at"`
+}
+
+// OptionOrder is an option order of an account, of one or more legs
+type OptionOrder struct {
+	ID                string           `json:"id"`
+	ChainSymbol       string           `json:"chain_symbol"`
+	Direction         string           `json:"direction"` // debit or credit
+	Type              string           `json:"type"`
+	State             string           `json:"state"`
+	Quantity          string           `json:"quantity"`
+	ProcessedQuantity string           `json:"processed_quantity"`
+	ProcessedPremium  string           `json:"processed_premium"`
+	RegulatoryFees    string           `json:"regulatory_fees"`
+	Legs              []OptionOrderLeg `json:"legs"`
+	CreatedAt         string           `json:"created_at"`
+	UpdatedAt         string           `json:"updated_at"`
+}
+
+// OptionOrderLeg is a leg of an option order
+type OptionOrderLeg struct {
+	ID             string      `json:"id"`
+	Option         string      `json:"option"` // Option instrument URL
+	Side           string      `json:"side"`
+	PositionEffect string      `json:"position_effect"` // open or close
+	RatioQuantity  float64     `json:"ratio_quantity"`
+	Executions     []Execution `json:"executions"`
+}
+
+// Watchlist is a watchlist of the authenticated user
+type Watchlist struct {
+	Name string `json:"name"`
+	URL  string `json:"url"` // Lists the watchlist's instruments
+}
+
+// WatchlistItem is an instrument of a watchlist
+type WatchlistItem struct {
+	Instrument string `json:"instrument"` // Equity instrument URL
+}
+
+// GetOptionPositions returns the option positions selected by query,
+// following pagination
+func (c *Client) GetOptionPositions(ctx context.Context, token string, query OptionPositionQuery) ([]OptionPosition, error) {
+	params := url.Values{}
+	if query.AccountNumber != "" {
+		params.Set("account_number", query.AccountNumber)
+	}
+	if query.NonZero {
+		params.Set("nonzero", "true")
+	}
+
+	return getAll[OptionPosition](ctx, c, token, withQuery("/options/positions/", params))
+}
+
+// GetOptionMarketData returns the market data of option instruments by ID.
+// Robinhood limits how many IDs a request can carry, callers batch large
+// lists. Instruments without market data are missing from the result.
+func (c *Client) GetOptionMarketData(ctx context.Context, token string, ids []string) ([]OptionMarketData, error) {
+	if len(ids) == 0 {
+		return []OptionMarketData{}, nil
+	}
+
+	params := url.Values{}
+	params.Set("ids", strings.Join(ids, ","))
+
+	var resp struct {
+		Results []*OptionMarketData `json:"results"`
+	}
+	if err := c.get(ctx, token, withQuery("/marketdata/options/", params), &resp); err != nil {
+		return nil, err
+	}
+
+	// Unknown IDs come back as null results
+	data := make([]OptionMarketData, 0, len(resp.Results))
+	for _, result := range resp.Results {
+		if result != nil {
+			data = append(data, *result)
+		}
+	}
+	return data, nil
+}
+
+// GetQuote returns the quote of an equity symbol
+func (c *Client) GetQuote(ctx context.Context, token, symbol string) (*Quote, error) {
+	if symbol == "" {
+		return nil, fmt.Errorf("symbol is required")
+	}
+
+	var quote Quote
+	if err := c.get(ctx, token, "/quotes/"+url.PathEscape(strings.ToUpper(symbol))+"/", &quote); err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// GetAccounts returns the brokerage accounts of the authenticated user,
+// following pagination
+func (c *Client) GetAccounts(ctx context.Context, token string) ([]Account, error) {
+	return getAll[Account](ctx, c, token, "/accounts/")
+}
+
+// GetDividends returns the dividends of every account of the authenticated
+// user, following pagination
+func (c *Client) GetDividends(ctx context.Context, token string) ([]Dividend, error) {
+	return getAll[Dividend](ctx, c, token, "/dividends/")
+}
+
+// GetEquityOrders returns the equity order history of an account, newest
+// first, following pagination
+func (c *Client) GetEquityOrders(ctx context.Context, token, accountNumber string) ([]EquityOrder, error) {
+	params := url.Values{}
+	params.Set("account_number", accountNumber)
+	return getAll[EquityOrder](ctx, c, token, withQuery("/orders/", params))
+}
+
+// GetOptionOrders returns the option order history of an account, newest
+// first, following pagination
+func (c *Client) GetOptionOrders(ctx context.Context, token, accountNumber string) ([]OptionOrder, error) {
+	params := url.Values{}
+	params.Set("account_numbers", accountNumber)
+	return getAll[OptionOrder](ctx, c, token, withQuery("/options/orders/", params))
+}
+
+// GetWatchlists returns the watchlists of the authenticated user, following
+// pagination
+func (c *Client) GetWatchlists(ctx context.Context, token string) ([]Watchlist, error) {
+	return getAll[Watchlist](ctx, c, token, "/watchlists/")
+}
+
+// GetWatchlistItems returns the instruments of a watchlist by its URL,
+// following pagination
+func (c *Client) GetWatchlistItems(ctx context.Context, token, watchlistURL string) ([]WatchlistItem, error) {
+	return getAll[WatchlistItem](ctx, c, token, watchlistURL)
+}
+
+// getAll returns the results of every page of a list response
+func getAll[T any](ctx context.Context, c *Client, token, path string) ([]T, error) {
+	items := []T{}
+	err := c.getPages(ctx, token, path, func(results json.RawMessage) error {
+		var page []T
+		if err := json.Unmarshal(results, &page); err != nil {
+			return err
+		}
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// withQuery appends encoded query parameters to a path
+func withQuery(path string, params url.Values) string {
+	if len(params) == 0 {
+		return path
+	}
+	return path + "?" + params.Encode()
+}
@@ -0,0 +1,270 @@
+// Package robinhood is a client for the Robinhood API shared by the
+// trade-sonic services. It centralizes the base URL, default headers,
+// authentication, error handling and pagination, so each service only
+// supplies a token and, optionally, its own transport.
+package robinhood
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Robinhood API base URL
+const DefaultBaseURL = "https://api.robinhood.com"
+
+// maxErrorBody caps how much of an unexpected response body is kept
+const maxErrorBody = 4096
+
+// maxPages bounds the pages of a list response that are followed, so a
+// misbehaving API cannot keep a request paging forever
+const maxPages = 100
+
+// ErrPagination is returned when the next links of a list response loop, go
+// on for more than maxPages pages or leave the API base URL
+var ErrPagination = errors.New("pagination aborted")
+
+// ErrNoResponseBody is returned when a response expected to carry JSON is empty
+var ErrNoResponseBody = errors.New("empty response body")
+
+// Doer sends HTTP requests. *http.Client is a Doer; services wrap it to add
+// retries or rate limiting.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client is a Robinhood API client. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	doer    Doer
+	headers map[string]string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL replaces the API base URL, e.g. with a test server
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithDoer sends requests through d instead of a plain http.Client
+func WithDoer(d Doer) Option {
+	return func(c *Client) {
+		c.doer = d
+	}
+}
+
+// WithHeaders sets headers sent with every request, e.g. BrowserHeaders
+func WithHeaders(headers map[string]string) Option {
+	return func(c *Client) {
+		c.headers = headers
+	}
+}
+
+// NewClient creates a client for the Robinhood API
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		baseURL: DefaultBaseURL,
+		doer:    &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// BrowserHeaders returns the headers of the Robinhood web client, which the
+// login workflow expects
+func BrowserHeaders() map[string]string {
+	return map[string]string{
+		"sec-ch-ua-platform":      "macOS",
+		"Referer":                 "https://robinhood.com/",
+		"X-TimeZone-Id":           "America/Los_Angeles",
+		"X-Robinhood-API-Version": "1.431.4",
+		"sec-ch-ua":               "\"Not_A:Brand\";v=\"99\", \"Google Chrome\";v=\"133\", \"Chromium\";v=\"133\"",
+		"Content-Type":            "application/json",
+		"sec-ch-ua-mobile":        "?0",
+		"User-Agent":              "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36",
+	}
+}
+
+// URL resolves an API path against the base URL. Absolute URLs, such as the
+// next page links in list responses, are returned as is.
+func (c *Client) URL(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return c.baseURL + path
+}
+
+// APIError is a non-2xx response from the Robinhood API. The body can echo
+// account data, so it is kept out of Error.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("robinhood %s %s: status %d", e.Method, e.Path, e.StatusCode)
+}
+
+// Request describes a single API call
+type Request struct {
+	Method string
+	Path   string // API path or absolute URL
+	// Token is sent as a bearer token when set
+	Token string
+	// Headers are sent on top of the client headers
+	Headers map[string]string
+	// Payload is encoded as the JSON request body when set
+	Payload interface{}
+}
+
+// Do sends a request and decodes the JSON response body into out, whatever
+// the status, returning the status code. Flows that branch on the status,
+// such as the login workflow, use it directly; typed methods use get.
+func (c *Client) Do(ctx context.Context, r Request, out interface{}) (int, error) {
+	var body io.Reader
+	if r.Payload != nil {
+		payload, err := json.Marshal(r.Payload)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := c.newRequest(ctx, r.Method, r.Path, r.Token, body)
+	if err != nil {
+		return 0, err
+	}
+	for key, value := range r.Headers {
+		req.Header.Set(key, value)
+	}
+	if r.Payload != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.doer.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("robinhood %s %s: %w", r.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = ErrNoResponseBody
+		}
+		return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// get performs an authenticated GET request, decoding a 200 response into
+// out and returning any other status as an *APIError
+func (c *Client) get(ctx context.Context, token, path string, out interface{}) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, token, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.doer.Do(req)
+	if err != nil {
+		return fmt.Errorf("robinhood GET %s: %w", req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &APIError{Method: http.MethodGet, Path: req.URL.Path, StatusCode: resp.StatusCode, Body: body}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// newRequest creates a request carrying the client headers and, when set,
+// the bearer token
+func (c *Client) newRequest(ctx context.Context, method, path, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL(path), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// page is a page of a Robinhood list response
+type page struct {
+	Next    *string         `json:"next"`
+	Results json.RawMessage `json:"results"`
+}
+
+// getPages follows the next links of a list response, passing the results of
+// every page to decode. It fails with ErrPagination when a next link points
+// to a page already fetched or off the base URL host, since the token would
+// go with it, or after maxPages pages.
+func (c *Client) getPages(ctx context.Context, token, path string, decode func(results json.RawMessage) error) error {
+	visited := make(map[string]bool)
+	for path != "" {
+		// Next links are absolute, the first path may not be
+		pageURL := c.URL(path)
+		if !c.sameOrigin(pageURL) {
+			return fmt.Errorf("%w: next page %s is not on %s", ErrPagination, path, c.baseURL)
+		}
+		if visited[pageURL] {
+			return fmt.Errorf("%w: next page %s was already fetched", ErrPagination, path)
+		}
+		if len(visited) == maxPages {
+			return fmt.Errorf("%w: more than %d pages", ErrPagination, maxPages)
+		}
+		visited[pageURL] = true
+
+		var p page
+		if err := c.get(ctx, token, path, &p); err != nil {
+			return err
+		}
+		if err := decode(p.Results); err != nil {
+			return fmt.Errorf("failed to decode results: %w", err)
+		}
+
+		path = ""
+		if p.Next != nil {
+			path = *p.Next
+		}
+	}
+	return nil
+}
+
+// sameOrigin reports whether rawURL has the scheme and host of the base URL
+func (c *Client) sameOrigin(rawURL string) bool {
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return false
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(target.Scheme, base.Scheme) && strings.EqualFold(target.Host, base.Host)
+}
@@ -0,0 +1,350 @@
+package robinhood
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves responses keyed by request path, checking that every
+// request carries the test token. {{base_url}} in a response is replaced by
+// the server URL, for next page links, and {{secure_base_url}} by the same
+// URL over https.
+func newTestServer(t *testing.T, responses map[string]string) (*httptest.Server, *[]*http.Request) {
+	var requests []*http.Request
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		key := r.URL.Path
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			key += "?cursor=" + cursor
+		}
+		body, ok := responses[key]
+		if !ok {
+			http.Error(w, `{"detail":"Not found."}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		body = strings.ReplaceAll(body, "{{secure_base_url}}", strings.Replace(srv.URL, "http://", "https://", 1))
+		w.Write([]byte(strings.ReplaceAll(body, "{{base_url}}", srv.URL)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestClient_GetOptionPositions(t *testing.T) {
+	srv, requests := newTestServer(t, map[string]string{
+		"/options/positions/": `{"next":"{{base_url}}/options/positions/?cursor=2","results":[
+			{"id":"pos-1","chain_symbol":"AAPL","option_id":"opt-1","quantity":"2.0000","type":"long","clearing_direction":"debit"}
+		]}`,
+		"/options/positions/?cursor=2": `{"next":null,"results":[
+			{"id":"pos-2","chain_symbol":"MSFT","option_id":"opt-2","quantity":"1.0000","type":"short","clearing_direction":"credit"}
+		]}`,
+	})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	positions, err := c.GetOptionPositions(context.Background(), "test-token", OptionPositionQuery{AccountNumber: "123", NonZero: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(positions) != 2 {
+		t.Fatalf("Expected 2 positions across both pages, got %d", len(positions))
+	}
+	if positions[0].ID != "pos-1" || positions[0].ChainSymbol != "AAPL" || positions[0].Quantity != "2.0000" {
+		t.Errorf("Unexpected first position: %+v", positions[0])
+	}
+	if positions[1].ID != "pos-2" || positions[1].Type != "short" || positions[1].ClearingDirection != "credit" {
+		t.Errorf("Unexpected second position: %+v", positions[1])
+	}
+
+	query := (*requests)[0].URL.Query()
+	if query.Get("account_number") != "123" || query.Get("nonzero") != "true" {
+		t.Errorf("Unexpected query: %s", (*requests)[0].URL.RawQuery)
+	}
+}
+
+func TestClient_GetOptionMarketData(t *testing.T) {
+	srv, requests := newTestServer(t, map[string]string{
+		"/marketdata/options/": `{"results":[
+			{"instrument_id":"opt-1","mark_price":"3.0000","adjusted_mark_price":"3.1000","last_trade_price":"2.9500"},
+			null
+		]}`,
+	})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	data, err := c.GetOptionMarketData(context.Background(), "test-token", []string{"opt-1", "opt-unknown"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The null result of the unknown ID is dropped
+	if len(data) != 1 || data[0].InstrumentID != "opt-1" || data[0].MarkPrice != "3.0000" || data[0].AdjustedMarkPrice != "3.1000" {
+		t.Errorf("Unexpected market data: %+v", data)
+	}
+	if ids := (*requests)[0].URL.Query().Get("ids"); ids != "opt-1,opt-unknown" {
+		t.Errorf("Expected ids opt-1,opt-unknown, got %s", ids)
+	}
+
+	// No IDs, no request
+	data, err = c.GetOptionMarketData(context.Background(), "test-token", nil)
+	if err != nil || len(data) != 0 || len(*requests) != 1 {
+		t.Errorf("Expected an empty result without a request, got %+v, %v after %d requests", data, err, len(*requests))
+	}
+}
+
+func TestClient_GetQuote(t *testing.T) {
+	srv, _ := newTestServer(t, map[string]string{
+		"/quotes/AAPL/": `{"symbol":"AAPL","last_trade_price":"190.5000","bid_price":"190.4000","ask_price":"190.6000"}`,
+	})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	quote, err := c.GetQuote(context.Background(), "test-token", "aapl")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if quote.Symbol != "AAPL" || quote.LastTradePrice != "190.5000" || quote.BidPrice != "190.4000" || quote.AskPrice != "190.6000" {
+		t.Errorf("Unexpected quote: %+v", quote)
+	}
+
+	if _, err := c.GetQuote(context.Background(), "test-token", ""); err == nil {
+		t.Error("Expected an error for an empty symbol")
+	}
+}
+
+func TestClient_GetAccounts(t *testing.T) {
+	srv, _ := newTestServer(t, map[string]string{
+		"/accounts/": `{"next":null,"results":[
+			{"account_number":"111","type":"margin","brokerage_account_type":"individual"},
+			{"account_number":"222","type":"cash","brokerage_account_type":"ira_roth","deactivated":true}
+		]}`,
+	})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	accounts, err := c.GetAccounts(context.Background(), "test-token")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("Expected 2 accounts, got %d", len(accounts))
+	}
+	if accounts[0].AccountNumber != "111" || accounts[0].Type != "margin" || accounts[0].Deactivated {
+		t.Errorf("Unexpected first account: %+v", accounts[0])
+	}
+	if accounts[1].BrokerageAccountType != "ira_roth" || !accounts[1].Deactivated {
+		t.Errorf("Unexpected second account: %+v", accounts[1])
+	}
+}
+
+func TestClient_GetOptionOrders(t *testing.T) {
+	srv, requests := newTestServer(t, map[string]string{
+		"/options/orders/": `{"next":null,"results":[
+			{"id":"order-1","chain_symbol":"AAPL","direction":"credit","state":"filled","processed_premium":"120.00",
+			 "legs":[{"id":"leg-1","option":"{{base_url}}/options/instruments/opt-1/","side":"sell","position_effect":"open","ratio_quantity":1,
+			          "executions":[{"id":"exec-1","price":"1.20","quantity":"1.00000"}]}]}
+		]}`,
+	})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	orders, err := c.GetOptionOrders(context.Background(), "test-token", "123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(orders) != 1 || len(orders[0].Legs) != 1 {
+		t.Fatalf("Expected 1 order of 1 leg, got %+v", orders)
+	}
+	leg := orders[0].Legs[0]
+	if orders[0].Direction != "credit" || leg.PositionEffect != "open" || len(leg.Executions) != 1 || leg.Executions[0].Price != "1.20" {
+		t.Errorf("Unexpected order: %+v", orders[0])
+	}
+	if got := (*requests)[0].URL.Query().Get("account_numbers"); got != "123" {
+		t.Errorf("Expected account_numbers 123, got %q", got)
+	}
+}
+
+func TestClient_GetWatchlistItems(t *testing.T) {
+	srv, _ := newTestServer(t, map[string]string{
+		"/watchlists/": `{"next":null,"results":[{"name":"Default","url":"{{base_url}}/watchlists/Default/"}]}`,
+		"/watchlists/Default/": `{"next":"{{base_url}}/watchlists/Default/?cursor=2","results":[
+			{"instrument":"{{base_url}}/instruments/aapl/"}
+		]}`,
+		"/watchlists/Default/?cursor=2": `{"next":null,"results":[{"instrument":"{{base_url}}/instruments/msft/"}]}`,
+	})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	lists, err := c.GetWatchlists(context.Background(), "test-token")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(lists) != 1 || lists[0].Name != "Default" {
+		t.Fatalf("Unexpected watchlists: %+v", lists)
+	}
+
+	items, err := c.GetWatchlistItems(context.Background(), "test-token", lists[0].URL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(items) != 2 || items[1].Instrument != srv.URL+"/instruments/msft/" {
+		t.Errorf("Unexpected watchlist items: %+v", items)
+	}
+}
+
+func TestClient_PaginationLimits(t *testing.T) {
+	tooMany := make(map[string]string)
+	tooMany["/accounts/"] = `{"next":"{{base_url}}/accounts/?cursor=1","results":[]}`
+	for i := 1; i <= maxPages; i++ {
+		tooMany[fmt.Sprintf("/accounts/?cursor=%d", i)] = fmt.Sprintf(`{"next":"{{base_url}}/accounts/?cursor=%d","results":[]}`, i+1)
+	}
+
+	tests := []struct {
+		name      string
+		responses map[string]string
+		requests  int
+	}{
+		{
+			name: "next link loops",
+			responses: map[string]string{
+				"/accounts/":          `{"next":"{{base_url}}/accounts/?cursor=2","results":[]}`,
+				"/accounts/?cursor=2": `{"next":"{{base_url}}/accounts/","results":[]}`,
+			},
+			requests: 2,
+		},
+		{name: "too many pages", responses: tooMany, requests: maxPages},
+		{
+			name: "next link leaves the API host",
+			responses: map[string]string{
+				"/accounts/": `{"next":"https://attacker.example/accounts/?cursor=2","results":[]}`,
+			},
+			requests: 1,
+		},
+		{
+			name: "next link changes scheme",
+			responses: map[string]string{
+				"/accounts/": `{"next":"{{secure_base_url}}/accounts/?cursor=2","results":[]}`,
+			},
+			requests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newTestServer(t, tt.responses)
+			c := NewClient(WithBaseURL(srv.URL))
+
+			if _, err := c.GetAccounts(context.Background(), "test-token"); !errors.Is(err, ErrPagination) {
+				t.Errorf("Expected ErrPagination, got %v", err)
+			}
+			if len(*requests) != tt.requests {
+				t.Errorf("Expected %d requests, got %d", tt.requests, len(*requests))
+			}
+		})
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	srv, _ := newTestServer(t, map[string]string{})
+	c := NewClient(WithBaseURL(srv.URL))
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "rejected token", token: "expired-token", expectedStatus: http.StatusUnauthorized},
+		{name: "not found", token: "test-token", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.GetQuote(context.Background(), tt.token, "NOPE")
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected an APIError, got %v", err)
+			}
+			if apiErr.StatusCode != tt.expectedStatus || apiErr.Path != "/quotes/NOPE/" {
+				t.Errorf("Unexpected error: %+v", apiErr)
+			}
+			// The body is available but kept out of the message
+			if strings.Contains(err.Error(), "Not found") {
+				t.Errorf("Expected the body to be kept out of the error, got %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestClient_Do(t *testing.T) {
+	var received *http.Request
+	var payload map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"challenge_status":"issued"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := NewClient(WithBaseURL(srv.URL), WithHeaders(map[string]string{"X-Client": "test", "X-Override": "client"}))
+
+	var out map[string]interface{}
+	status, err := c.Do(context.Background(), Request{
+		Method:  http.MethodPost,
+		Path:    "/pathfinder/user_machine/",
+		Headers: map[string]string{"X-Override": "request"},
+		Payload: map[string]string{"flow": "suv"},
+	}, &out)
+
+	// Non-2xx responses are decoded, not errors
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if status != http.StatusBadRequest || out["challenge_status"] != "issued" {
+		t.Errorf("Unexpected response: %d %v", status, out)
+	}
+
+	if received.Method != http.MethodPost || received.URL.Path != "/pathfinder/user_machine/" {
+		t.Errorf("Unexpected request: %s %s", received.Method, received.URL.Path)
+	}
+	if received.Header.Get("X-Client") != "test" || received.Header.Get("X-Override") != "request" {
+		t.Errorf("Unexpected headers: %v", received.Header)
+	}
+	if received.Header.Get("Content-Type") != "application/json" || received.Header.Get("Authorization") != "" {
+		t.Errorf("Unexpected headers: %v", received.Header)
+	}
+	if payload["flow"] != "suv" {
+		t.Errorf("Unexpected payload: %v", payload)
+	}
+}
+
+// doerFunc adapts a function to the Doer interface
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestClient_WithDoer(t *testing.T) {
+	srv, _ := newTestServer(t, map[string]string{
+		"/quotes/AAPL/": `{"symbol":"AAPL"}`,
+	})
+
+	var sent []string
+	c := NewClient(WithBaseURL(srv.URL), WithDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.URL.Path)
+		return http.DefaultClient.Do(req)
+	})))
+
+	if _, err := c.GetQuote(context.Background(), "test-token", "AAPL"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sent) != 1 || sent[0] != "/quotes/AAPL/" {
+		t.Errorf("Expected the request to go through the doer, got %v", sent)
+	}
+}
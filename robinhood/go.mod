@@ -0,0 +1,3 @@
+module github.com/trade-sonic/robinhood
+
+go 1.21
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -11,9 +12,15 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/admin"
 	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/engine"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/feed"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/notify"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/paper"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/store"
 	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
 	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy/stoploss"
+	"github.com/trade-sonic/logging"
 )
 
 // Config holds the configuration for the strategy engine
@@ -24,7 +31,32 @@ type Config struct {
 		Channel string `json:"channel"`
 		GroupID string `json:"groupId"`
 	} `json:"queue"`
-	Strategies []struct {
+	// SignalStorePath is the SQLite database used to audit generated signals.
+	// Signals are not persisted when empty.
+	SignalStorePath string `json:"signalStorePath"`
+	// PaperTrading fills signals against a simulated portfolio instead of
+	// forwarding them for execution
+	PaperTrading bool `json:"paperTrading"`
+	// PaperSlippageBps fills paper buys above and sells below the signal
+	// price by this many basis points. Fills are exact when zero.
+	PaperSlippageBps float64 `json:"paperSlippageBps"`
+	// NotifyWebhookURL receives a message whenever a stop loss triggers, e.g.
+	// a Slack incoming webhook. No notifications are sent when empty.
+	NotifyWebhookURL string `json:"notifyWebhookUrl"`
+	// AdminAddr is the listen address of the admin API, e.g. ":8090". The
+	// admin API is not served when empty.
+	AdminAddr string `json:"adminAddr"`
+	// MarketDataThrottleMs forwards at most one tick per symbol to the engine
+	// every this many milliseconds, keeping the latest price. Every tick is
+	// forwarded when zero.
+	MarketDataThrottleMs int `json:"marketDataThrottleMs"`
+	// SignalNetting resolves conflicting buy and sell signals for a symbol
+	// from the same tick: none (default), sell_wins or net_quantity
+	SignalNetting string `json:"signalNetting"`
+	// DropOutOfOrderTicks drops ticks older than the latest one processed
+	// for their symbol, so strategies never see time go backwards
+	DropOutOfOrderTicks bool `json:"dropOutOfOrderTicks"`
+	Strategies          []struct {
 		Name       string                 `json:"name"`
 		Type       string                 `json:"type"`
 		Parameters map[string]interface{} `json:"parameters"`
@@ -43,14 +75,59 @@ func (sp *SignalProcessor) HandleSignal(ctx context.Context, signal *strategy.Si
 }
 
 func main() {
+	// LOG_FORMAT=json switches the log lines below to structured logs
+	if _, _, err := logging.FromEnv(); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+
 	// Load configuration
 	config := loadConfig()
 
+	// Cancelled on shutdown, so a signal also aborts initialization
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// WaitGroup for coordinating shutdown
+	var wg sync.WaitGroup
+
 	// Create signal handler
-	signalHandler := &SignalProcessor{}
+	var signalHandler strategy.SignalHandler = &SignalProcessor{}
+	if config.PaperTrading {
+		log.Println("Paper trading enabled, signals are simulated and not executed")
+		signalHandler = paper.NewHandler(paper.WithFillModel(paper.FixedBps(config.PaperSlippageBps)))
+	}
+	if config.NotifyWebhookURL != "" {
+		alerts := notify.NewSignalHandler(signalHandler, notify.NewWebhookNotifier(config.NotifyWebhookURL))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			alerts.Run(ctx)
+		}()
+		signalHandler = alerts
+	}
+
+	// Open the signal audit store if configured
+	var engineOpts []engine.Option
+	if config.SignalStorePath != "" {
+		signalStore, err := store.NewSQLiteStore(config.SignalStorePath)
+		if err != nil {
+			log.Fatalf("Failed to open signal store: %v", err)
+		}
+		defer signalStore.Close()
+		engineOpts = append(engineOpts, engine.WithSignalStore(signalStore))
+	}
+
+	netting, err := engine.ParseNettingPolicy(config.SignalNetting)
+	if err != nil {
+		log.Fatalf("Invalid signalNetting: %v", err)
+	}
+	engineOpts = append(engineOpts, engine.WithSignalNetting(netting))
+	if config.DropOutOfOrderTicks {
+		engineOpts = append(engineOpts, engine.WithTimestampOrdering())
+	}
 
 	// Create strategy engine
-	strategyEngine := engine.NewEngine(signalHandler)
+	strategyEngine := engine.NewEngine(signalHandler, engineOpts...)
 
 	// Initialize strategies from config
 	for _, stratCfg := range config.Strategies {
@@ -69,8 +146,13 @@ func main() {
 			log.Printf("Error initializing strategy %s: %v\n", stratCfg.Name, err)
 			continue
 		}
+		if err := strat.Initialize(ctx); err != nil {
+			log.Printf("Error initializing strategy %s: %v\n", stratCfg.Name, err)
+			continue
+		}
 
 		if err := strategyEngine.RegisterStrategy(strat); err != nil {
+			strat.Cleanup(context.Background())
 			log.Printf("Error registering strategy %s: %v\n", stratCfg.Name, err)
 			continue
 		}
@@ -78,33 +160,47 @@ func main() {
 		log.Printf("Successfully initialized and registered strategy: %s\n", stratCfg.Name)
 	}
 
-	// Create context that can be cancelled
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Setup graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Serve the admin API if configured
+	if config.AdminAddr != "" {
+		adminServer := &http.Server{Addr: config.AdminAddr, Handler: admin.NewHandler(strategyEngine)}
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Admin API stopped: %v", err)
+			}
+		}()
+		defer adminServer.Close()
+		log.Printf("Admin API listening on %s", config.AdminAddr)
+	}
 
-	// WaitGroup for coordinating shutdown
-	var wg sync.WaitGroup
+	// Coalesce bursts of ticks so firehose symbols cannot overwhelm
+	// strategy evaluation
+	throttle := feed.NewThrottle(strategyEngine, time.Duration(config.MarketDataThrottleMs)*time.Millisecond)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		throttle.Run(ctx)
+	}()
 
 	// Start market data consumer
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		consumeMarketData(ctx, strategyEngine, config)
+		consumeMarketData(ctx, throttle, config)
 	}()
 
-	// Wait for shutdown signal
-	<-sigChan
+	// Wait for shutdown signal, which cancels ctx
+	<-ctx.Done()
 	log.Println("Received shutdown signal")
 
-	// Cancel context to initiate shutdown
-	cancel()
-
 	// Wait for all goroutines to finish
 	wg.Wait()
+
+	// Unregistering cleans the strategies up, stopping their position refreshes
+	for _, name := range strategyEngine.ListStrategies() {
+		if err := strategyEngine.UnregisterStrategy(name); err != nil {
+			log.Printf("Error cleaning up strategy %s: %v\n", name, err)
+		}
+	}
 	log.Println("Strategy engine shutdown complete")
 }
 
@@ -151,7 +247,7 @@ func getDefaultConfig() *Config {
 	}
 }
 
-func consumeMarketData(ctx context.Context, e *engine.Engine, cfg *Config) {
+func consumeMarketData(ctx context.Context, e feed.Processor, cfg *Config) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
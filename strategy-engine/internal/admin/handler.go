@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/engine"
+)
+
+// ErrorResponse is the body of every non-2xx response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler serves the engine's admin API
+type Handler struct {
+	engine *engine.Engine
+	mux    *http.ServeMux
+}
+
+// NewHandler creates an admin API handler for an engine
+func NewHandler(e *engine.Engine) *Handler {
+	h := &Handler{
+		engine: e,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /strategies/{name}/parameters", h.getParameters)
+	h.mux.HandleFunc("POST /strategies/{name}/reset", h.resetStrategy)
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// getParameters returns the live parameters of a strategy
+func (h *Handler) getParameters(w http.ResponseWriter, r *http.Request) {
+	params, ok := h.engine.StrategyParameters(r.PathValue("name"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: engine.ErrStrategyNotFound.Error()})
+		return
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	writeJSON(w, http.StatusOK, params)
+}
+
+// resetStrategy clears the per-symbol state of a strategy, see
+// engine.Engine.ResetStrategy
+func (h *Handler) resetStrategy(w http.ResponseWriter, r *http.Request) {
+	err := h.engine.ResetStrategy(r.Context(), r.PathValue("name"))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, engine.ErrStrategyNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, engine.ErrStrategyNotResettable):
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+	}
+}
+
+// writeJSON writes body as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/engine"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/paper"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy/stoploss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEngine returns an engine running a stop loss strategy
+func newEngine(t *testing.T) (*engine.Engine, *stoploss.StopLossStrategy) {
+	t.Helper()
+	s, err := stoploss.NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+	})
+	require.NoError(t, err)
+
+	e := engine.NewEngine(paper.NewHandler())
+	require.NoError(t, e.RegisterStrategy(s))
+	return e, s
+}
+
+func TestHandler_GetParameters(t *testing.T) {
+	e, s := newEngine(t)
+	h := NewHandler(e)
+
+	get := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/strategies/stop_loss_strategy/parameters", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var params map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &params))
+		return params
+	}
+
+	assert.Equal(t, 5.0, get()["max_drawdown_percent"])
+
+	// Updates are visible on the next read
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{
+		"max_drawdown_percent": 8.0,
+		"entry_price_source":   "cost_basis",
+	}))
+	assert.Equal(t, map[string]interface{}{
+		"max_drawdown_percent":        8.0,
+		"entry_price_source":          "cost_basis",
+		"signal_ttl_seconds":          60.0,
+		"quantity_rounding":           "floor",
+		"close_before_expiry_minutes": 0.0,
+		"hold_on_missing_price":       true,
+		"stale_position_seconds":      0.0,
+		"position_refresh_seconds":    0.0,
+		"enable_entries":              false,
+	}, get())
+}
+
+func TestHandler_GetParametersNotFound(t *testing.T) {
+	e, _ := newEngine(t)
+	h := NewHandler(e)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/strategies/missing/parameters", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var body ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, engine.ErrStrategyNotFound.Error(), body.Error)
+
+	// Only reads are served
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/strategies/stop_loss_strategy/parameters", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandler_ResetStrategy(t *testing.T) {
+	e, s := newEngine(t)
+	h := NewHandler(e)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := s.ProcessData(ctx, strategy.MarketData{Symbol: "AAPL", Price: 100, Volume: 1, Timestamp: now})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/strategies/stop_loss_strategy/reset", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// The strategy is still registered and processes data
+	_, ok := e.GetStrategy("stop_loss_strategy")
+	assert.True(t, ok)
+	require.NoError(t, e.ProcessMarketData(ctx, strategy.MarketData{Symbol: "AAPL", Price: 90, Volume: 1, Timestamp: now}))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/strategies/missing/reset", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var body ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, engine.ErrStrategyNotFound.Error(), body.Error)
+}
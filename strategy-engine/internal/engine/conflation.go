@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// pendingTick is the latest unprocessed tick for a symbol
+type pendingTick struct {
+	ctx  context.Context
+	data strategy.MarketData
+}
+
+// conflator keeps at most one pending tick per symbol. Each symbol with
+// pending data has a single worker, so ticks for one symbol are processed in
+// order while different symbols proceed independently.
+type conflator struct {
+	process func(ctx context.Context, data strategy.MarketData)
+
+	mu      sync.Mutex
+	pending map[string]pendingTick
+	active  map[string]bool
+	workers sync.WaitGroup
+	dropped atomic.Uint64
+}
+
+func newConflator(process func(ctx context.Context, data strategy.MarketData)) *conflator {
+	return &conflator{
+		process: process,
+		pending: make(map[string]pendingTick),
+		active:  make(map[string]bool),
+	}
+}
+
+// submit buffers a tick, replacing any older pending tick for the same symbol
+func (c *conflator) submit(ctx context.Context, data strategy.MarketData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.pending[data.Symbol]; exists {
+		c.dropped.Add(1)
+	}
+	c.pending[data.Symbol] = pendingTick{ctx: ctx, data: data}
+
+	if !c.active[data.Symbol] {
+		c.active[data.Symbol] = true
+		c.workers.Add(1)
+		go c.drain(data.Symbol)
+	}
+}
+
+// drain processes the pending tick for a symbol until none is left
+func (c *conflator) drain(symbol string) {
+	defer c.workers.Done()
+
+	for {
+		c.mu.Lock()
+		tick, exists := c.pending[symbol]
+		if !exists {
+			delete(c.active, symbol)
+			c.mu.Unlock()
+			return
+		}
+		delete(c.pending, symbol)
+		c.mu.Unlock()
+
+		c.process(tick.ctx, tick.data)
+	}
+}
+
+// wait blocks until every symbol worker has exited
+func (c *conflator) wait() {
+	c.workers.Wait()
+}
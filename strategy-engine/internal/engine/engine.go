@@ -2,8 +2,15 @@ package engine
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/store"
 	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
 )
 
@@ -11,15 +18,34 @@ import (
 type Engine struct {
 	strategies    map[string]strategy.Strategy
 	signalHandler strategy.SignalHandler
+	signalStore   store.SignalStore // Optional audit store for generated signals
 	mu            sync.RWMutex
+
+	strategyTimeout  time.Duration    // Deadline for each ProcessData call
+	validationPolicy ValidationPolicy // Treatment of market data failing validation
+	rejectedCount    atomic.Uint64    // Market data that failed validation
+	conflator        *conflator       // Set when only the latest tick per symbol is processed
+	ordering         *orderingGuard   // Set when ticks older than the latest per symbol are dropped
+	signalLimiter    *signalLimiter   // Set when concurrent signal handling is bounded
+	nettingPolicy    NettingPolicy    // Resolution of conflicting signals from a single tick
+	inFlight         atomic.Int64     // Signals currently being handled
+
+	abandonedMu sync.Mutex
+	abandoned   map[string]int // Timed out ProcessData calls still running, per strategy
 }
 
 // NewEngine creates a new strategy engine
-func NewEngine(signalHandler strategy.SignalHandler) *Engine {
-	return &Engine{
-		strategies:    make(map[string]strategy.Strategy),
-		signalHandler: signalHandler,
+func NewEngine(signalHandler strategy.SignalHandler, opts ...Option) *Engine {
+	e := &Engine{
+		strategies:      make(map[string]strategy.Strategy),
+		abandoned:       make(map[string]int),
+		signalHandler:   signalHandler,
+		strategyTimeout: DefaultStrategyTimeout,
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 // RegisterStrategy adds a new strategy to the engine
@@ -50,25 +76,180 @@ func (e *Engine) UnregisterStrategy(name string) error {
 	return ErrStrategyNotFound
 }
 
-// ProcessMarketData sends market data to all registered strategies
+// ResetStrategy clears the per-symbol state of a registered strategy, which
+// keeps receiving market data. Strategies that do not implement
+// strategy.Resettable return ErrStrategyNotResettable. Cancelling ctx aborts
+// the reset.
+func (e *Engine) ResetStrategy(ctx context.Context, name string) error {
+	// Not under the engine lock, a reset may reload state from other services
+	s, exists := e.GetStrategy(name)
+	if !exists {
+		return ErrStrategyNotFound
+	}
+	resettable, ok := s.(strategy.Resettable)
+	if !ok {
+		return ErrStrategyNotResettable
+	}
+	return resettable.Reset(ctx)
+}
+
+// ProcessMarketData sends market data to all registered strategies. Invalid
+// market data is counted and, under ValidationReject, returned as an error
+// without reaching any strategy. With WithTimestampOrdering, so is market
+// data older than the latest processed for its symbol.
 func (e *Engine) ProcessMarketData(ctx context.Context, data strategy.MarketData) error {
+	if err := validateMarketData(data); err != nil {
+		e.rejectedCount.Add(1)
+		if e.validationPolicy == ValidationReject {
+			return err
+		}
+		log.Printf("Dispatching invalid market data: %v", err)
+	}
+
+	if e.ordering != nil {
+		if err := e.ordering.admit(data); err != nil {
+			return err
+		}
+	}
+
+	if e.conflator != nil {
+		e.conflator.submit(ctx, data)
+		return nil
+	}
+
+	e.dispatch(ctx, data)
+	return nil
+}
+
+// Flush waits until all market data buffered by conflation has been
+// processed. It returns immediately when conflation is disabled.
+func (e *Engine) Flush() {
+	if e.conflator != nil {
+		e.conflator.wait()
+	}
+}
+
+// ConflatedCount returns how many ticks were dropped because a newer tick for
+// the same symbol arrived before they were processed
+func (e *Engine) ConflatedCount() uint64 {
+	if e.conflator == nil {
+		return 0
+	}
+	return e.conflator.dropped.Load()
+}
+
+// dispatch runs market data through every registered strategy and forwards
+// the resulting signals in strategy name order, after netting conflicting
+// ones
+func (e *Engine) dispatch(ctx context.Context, data strategy.MarketData) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
+	var signals []sourcedSignal
 	for _, s := range e.strategies {
-		signal, err := s.ProcessData(ctx, data)
+		signal, err := e.processData(ctx, s, data)
 		if err != nil {
 			// Log error but continue processing other strategies
+			log.Printf("Error processing data in strategy %s: %v", s.Name(), err)
 			continue
 		}
 		if signal != nil {
-			if err := e.signalHandler.HandleSignal(ctx, signal); err != nil {
-				// Log error but continue processing
+			// Never hand an action the handlers cannot interpret downstream
+			if !signal.Action.Known() {
+				log.Printf("Ignoring signal from %s with unknown action %q for %s", s.Name(), signal.Action, signal.Symbol)
 				continue
 			}
+			// Every generated signal is audited, including those netted away
+			if e.signalStore != nil {
+				if err := e.signalStore.SaveSignal(ctx, s.Name(), signal); err != nil {
+					// A storage failure must not block the signal itself
+					log.Printf("Error saving signal from %s: %v", s.Name(), err)
+				}
+			}
+			signals = append(signals, sourcedSignal{strategy: s.Name(), signal: signal})
+		}
+	}
+
+	// Strategies run in map order, sort so netting picks the same template
+	// signal on every tick
+	sort.Slice(signals, func(i, j int) bool { return signals[i].strategy < signals[j].strategy })
+	netted := netSignals(signals, e.nettingPolicy)
+	if len(netted) != len(signals) {
+		log.Printf("Netted %d signals for %s into %d under %s", len(signals), data.Symbol, len(netted), e.nettingPolicy)
+	}
+	for _, s := range netted {
+		// Handler errors do not stop the remaining signals
+		if err := e.handleSignal(ctx, s.signal); errors.Is(err, ErrSignalDropped) {
+			log.Printf("Dropping signal from %s for %s: %v", s.strategy, s.signal.Symbol, err)
 		}
 	}
-	return nil
+}
+
+// processData runs a strategy's ProcessData under the engine's per-strategy
+// timeout. A strategy is skipped with ErrStrategyStuck while a call it timed
+// out on is still running, so one ignoring its context cannot pile up a
+// goroutine per tick.
+func (e *Engine) processData(ctx context.Context, s strategy.Strategy, data strategy.MarketData) (*strategy.Signal, error) {
+	if e.strategyTimeout <= 0 {
+		return s.ProcessData(ctx, data)
+	}
+
+	name := s.Name()
+	e.abandonedMu.Lock()
+	stuck := e.abandoned[name] > 0
+	e.abandonedMu.Unlock()
+	if stuck {
+		return nil, ErrStrategyStuck
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.strategyTimeout)
+	defer cancel()
+
+	type result struct {
+		signal *strategy.Signal
+		err    error
+	}
+
+	// Buffered so an abandoned strategy can still finish without leaking the send
+	done := make(chan result, 1)
+	go func() {
+		signal, err := s.ProcessData(ctx, data)
+		done <- result{signal: signal, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.signal, r.err
+	case <-ctx.Done():
+		// Skip the strategy until the abandoned call returns
+		e.abandonedMu.Lock()
+		e.abandoned[name]++
+		e.abandonedMu.Unlock()
+		go func() {
+			<-done
+			e.abandonedMu.Lock()
+			if e.abandoned[name]--; e.abandoned[name] == 0 {
+				delete(e.abandoned, name)
+			}
+			e.abandonedMu.Unlock()
+		}()
+		return nil, fmt.Errorf("strategy abandoned: %w", ctx.Err())
+	}
+}
+
+// RejectedCount returns how many market data updates have failed validation
+func (e *Engine) RejectedCount() uint64 {
+	return e.rejectedCount.Load()
+}
+
+// OutOfOrderCount returns how many ticks were dropped for being older than
+// the latest processed tick of their symbol, always zero without
+// WithTimestampOrdering
+func (e *Engine) OutOfOrderCount() uint64 {
+	if e.ordering == nil {
+		return 0
+	}
+	return e.ordering.dropped.Load()
 }
 
 // GetStrategy returns a strategy by name
@@ -79,6 +260,15 @@ func (e *Engine) GetStrategy(name string) (strategy.Strategy, bool) {
 	return s, exists
 }
 
+// StrategyParameters returns the live parameters of a strategy by name
+func (e *Engine) StrategyParameters(name string) (map[string]interface{}, bool) {
+	s, exists := e.GetStrategy(name)
+	if !exists {
+		return nil, false
+	}
+	return s.Parameters(), true
+}
+
 // ListStrategies returns all registered strategy names
 func (e *Engine) ListStrategies() []string {
 	e.mu.RLock()
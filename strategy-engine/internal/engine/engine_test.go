@@ -0,0 +1,672 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy/stoploss"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockStrategy is a configurable strategy for exercising the engine
+type mockStrategy struct {
+	name    string
+	process func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error)
+}
+
+func (m *mockStrategy) Initialize(ctx context.Context) error { return nil }
+func (m *mockStrategy) ProcessData(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+	return m.process(ctx, data)
+}
+func (m *mockStrategy) Name() string                                         { return m.name }
+func (m *mockStrategy) Parameters() map[string]interface{}                   { return nil }
+func (m *mockStrategy) UpdateParameters(params map[string]interface{}) error { return nil }
+func (m *mockStrategy) Cleanup(ctx context.Context) error                    { return nil }
+
+// signalOn returns a strategy that emits a sell signal for every tick
+func signalOn(name string) *mockStrategy {
+	return &mockStrategy{
+		name: name,
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			return &strategy.Signal{Symbol: data.Symbol, Action: strategy.SignalActionSell, Price: data.Price}, nil
+		},
+	}
+}
+
+// tick returns valid market data for a symbol
+func tick(symbol string, price float64) strategy.MarketData {
+	return strategy.MarketData{Symbol: symbol, Price: price, Volume: 1.0, Timestamp: time.Now()}
+}
+
+// recordingHandler collects every signal it is given
+type recordingHandler struct {
+	mu      sync.Mutex
+	signals []*strategy.Signal
+}
+
+func (h *recordingHandler) HandleSignal(ctx context.Context, signal *strategy.Signal) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.signals = append(h.signals, signal)
+	return nil
+}
+
+func (h *recordingHandler) received() []*strategy.Signal {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*strategy.Signal(nil), h.signals...)
+}
+
+func TestEngine_StrategyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	// Ignores its context entirely, so only the engine can move on
+	stuck := &mockStrategy{
+		name: "stuck",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			<-release
+			return nil, nil
+		},
+	}
+
+	handler := &recordingHandler{}
+	e := NewEngine(handler, WithStrategyTimeout(50*time.Millisecond))
+	assert.NoError(t, e.RegisterStrategy(stuck))
+	assert.NoError(t, e.RegisterStrategy(signalOn("healthy")))
+
+	start := time.Now()
+	err := e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000.0))
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	signals := handler.received()
+	assert.Len(t, signals, 1)
+}
+
+func TestEngine_StrategyTimeoutSkipsStuckStrategy(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	stuck := &mockStrategy{
+		name: "stuck",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			<-release
+			return nil, nil
+		},
+	}
+	callCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+
+	e := NewEngine(&recordingHandler{}, WithStrategyTimeout(20*time.Millisecond))
+	assert.NoError(t, e.RegisterStrategy(stuck))
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000.0)))
+	}
+	assert.Equal(t, 1, callCount())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000.0)))
+		return callCount() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEngine_StrategyTimeoutCancelsContext(t *testing.T) {
+	cancelled := make(chan struct{})
+	respectful := &mockStrategy{
+		name: "respectful",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		},
+	}
+
+	e := NewEngine(&recordingHandler{}, WithStrategyTimeout(10*time.Millisecond))
+	assert.NoError(t, e.RegisterStrategy(respectful))
+	assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000.0)))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the strategy context to be cancelled")
+	}
+}
+
+func TestEngine_MarketDataValidation(t *testing.T) {
+	invalid := []struct {
+		name string
+		data strategy.MarketData
+	}{
+		{name: "zero price", data: strategy.MarketData{Symbol: "BTC-USD", Price: 0, Volume: 1, Timestamp: time.Now()}},
+		{name: "negative price", data: strategy.MarketData{Symbol: "BTC-USD", Price: -1, Volume: 1, Timestamp: time.Now()}},
+		{name: "NaN price", data: strategy.MarketData{Symbol: "BTC-USD", Price: math.NaN(), Volume: 1, Timestamp: time.Now()}},
+		{name: "infinite price", data: strategy.MarketData{Symbol: "BTC-USD", Price: math.Inf(1), Volume: 1, Timestamp: time.Now()}},
+		{name: "negative infinite price", data: strategy.MarketData{Symbol: "BTC-USD", Price: math.Inf(-1), Volume: 1, Timestamp: time.Now()}},
+		{name: "zero volume", data: strategy.MarketData{Symbol: "BTC-USD", Price: 50000, Volume: 0, Timestamp: time.Now()}},
+		{name: "NaN volume", data: strategy.MarketData{Symbol: "BTC-USD", Price: 50000, Volume: math.NaN(), Timestamp: time.Now()}},
+		{name: "infinite volume", data: strategy.MarketData{Symbol: "BTC-USD", Price: 50000, Volume: math.Inf(1), Timestamp: time.Now()}},
+		{name: "zero timestamp", data: strategy.MarketData{Symbol: "BTC-USD", Price: 50000, Volume: 1}},
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		calls := 0
+		counting := &mockStrategy{
+			name: "counting",
+			process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+				calls++
+				return nil, nil
+			},
+		}
+		e := NewEngine(&recordingHandler{})
+		assert.NoError(t, e.RegisterStrategy(counting))
+
+		for _, tt := range invalid {
+			err := e.ProcessMarketData(context.Background(), tt.data)
+			assert.ErrorIs(t, err, ErrInvalidMarketData, tt.name)
+		}
+		assert.Equal(t, 0, calls)
+		assert.Equal(t, uint64(len(invalid)), e.RejectedCount())
+
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000.0)))
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, uint64(len(invalid)), e.RejectedCount())
+	})
+
+	t.Run("pass", func(t *testing.T) {
+		calls := 0
+		counting := &mockStrategy{
+			name: "counting",
+			process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+				calls++
+				return nil, nil
+			},
+		}
+		e := NewEngine(&recordingHandler{}, WithValidationPolicy(ValidationPass))
+		assert.NoError(t, e.RegisterStrategy(counting))
+
+		for _, tt := range invalid {
+			assert.NoError(t, e.ProcessMarketData(context.Background(), tt.data), tt.name)
+		}
+		assert.Equal(t, len(invalid), calls)
+		assert.Equal(t, uint64(len(invalid)), e.RejectedCount())
+	})
+}
+
+func TestEngine_TimestampOrdering(t *testing.T) {
+	var seen []time.Time
+	recording := &mockStrategy{
+		name: "recording",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			seen = append(seen, data.Timestamp)
+			return nil, nil
+		},
+	}
+	e := NewEngine(&recordingHandler{}, WithTimestampOrdering())
+	assert.NoError(t, e.RegisterStrategy(recording))
+
+	ctx := context.Background()
+	t0 := time.Date(2025, 6, 2, 14, 30, 0, 0, time.UTC)
+	at := func(symbol string, ts time.Time) strategy.MarketData {
+		return strategy.MarketData{Symbol: symbol, Price: 100, Volume: 1, Timestamp: ts}
+	}
+
+	assert.NoError(t, e.ProcessMarketData(ctx, at("BTC-USD", t0.Add(time.Minute))))
+	// Older than the latest BTC-USD tick, so it is dropped
+	assert.ErrorIs(t, e.ProcessMarketData(ctx, at("BTC-USD", t0)), ErrOutOfOrderMarketData)
+	// Equal timestamps are not out of order
+	assert.NoError(t, e.ProcessMarketData(ctx, at("BTC-USD", t0.Add(time.Minute))))
+	// Symbols are ordered independently
+	assert.NoError(t, e.ProcessMarketData(ctx, at("ETH-USD", t0)))
+	assert.NoError(t, e.ProcessMarketData(ctx, at("BTC-USD", t0.Add(2*time.Minute))))
+	e.Flush()
+
+	assert.Equal(t, []time.Time{t0.Add(time.Minute), t0.Add(time.Minute), t0, t0.Add(2 * time.Minute)}, seen)
+	assert.Equal(t, uint64(1), e.OutOfOrderCount())
+	assert.Equal(t, uint64(0), e.RejectedCount())
+}
+
+func TestEngine_TimestampOrderingDisabledByDefault(t *testing.T) {
+	calls := 0
+	counting := &mockStrategy{
+		name: "counting",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			calls++
+			return nil, nil
+		},
+	}
+	e := NewEngine(&recordingHandler{})
+	assert.NoError(t, e.RegisterStrategy(counting))
+
+	now := time.Now()
+	for _, ts := range []time.Time{now, now.Add(-time.Minute)} {
+		assert.NoError(t, e.ProcessMarketData(context.Background(), strategy.MarketData{Symbol: "BTC-USD", Price: 100, Volume: 1, Timestamp: ts}))
+	}
+	e.Flush()
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, uint64(0), e.OutOfOrderCount())
+}
+
+func TestEngine_Conflation(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var processed []float64
+	recording := &mockStrategy{
+		name: "recording",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			mu.Lock()
+			processed = append(processed, data.Price)
+			first := len(processed) == 1
+			mu.Unlock()
+
+			// Hold the first tick so the rest of the burst queues up behind it
+			if first {
+				close(started)
+				<-release
+			}
+			return nil, nil
+		},
+	}
+
+	e := NewEngine(&recordingHandler{}, WithConflation(), WithStrategyTimeout(0))
+	assert.NoError(t, e.RegisterStrategy(recording))
+
+	ctx := context.Background()
+	assert.NoError(t, e.ProcessMarketData(ctx, tick("BTC-USD", 1)))
+	<-started
+
+	for price := 2.0; price <= 10; price++ {
+		assert.NoError(t, e.ProcessMarketData(ctx, tick("BTC-USD", price)))
+	}
+	assert.NoError(t, e.ProcessMarketData(ctx, tick("ETH-USD", 100)))
+
+	close(release)
+	e.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []float64{1, 10, 100}, processed)
+	assert.Equal(t, uint64(8), e.ConflatedCount())
+}
+
+func TestEngine_ConflationDisabledByDefault(t *testing.T) {
+	calls := 0
+	counting := &mockStrategy{
+		name: "counting",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			calls++
+			return nil, nil
+		},
+	}
+
+	e := NewEngine(&recordingHandler{})
+	assert.NoError(t, e.RegisterStrategy(counting))
+	for price := 1.0; price <= 5; price++ {
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", price)))
+	}
+	e.Flush()
+
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, uint64(0), e.ConflatedCount())
+}
+
+func TestEngine_IgnoresUnknownSignalActions(t *testing.T) {
+	actions := []strategy.SignalAction{
+		strategy.SignalActionScaleIn,
+		strategy.SignalAction("SHORT"),
+		strategy.SignalActionCloseAll,
+	}
+	next := 0
+	emitting := &mockStrategy{
+		name: "emitting",
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			action := actions[next]
+			next++
+			return &strategy.Signal{Symbol: data.Symbol, Action: action, Price: data.Price}, nil
+		},
+	}
+
+	handler := &recordingHandler{}
+	e := NewEngine(handler)
+	assert.NoError(t, e.RegisterStrategy(emitting))
+	for range actions {
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000.0)))
+	}
+
+	received := handler.received()
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, strategy.SignalActionScaleIn, received[0].Action)
+		assert.Equal(t, strategy.SignalActionCloseAll, received[1].Action)
+	}
+}
+
+func TestEngine_StrategyParameters(t *testing.T) {
+	e := NewEngine(&recordingHandler{})
+	assert.NoError(t, e.RegisterStrategy(signalOn("registered")))
+
+	_, ok := e.StrategyParameters("registered")
+	assert.True(t, ok)
+
+	params, ok := e.StrategyParameters("missing")
+	assert.False(t, ok)
+	assert.Nil(t, params)
+}
+
+// blockingHandler holds every signal until released, reporting each arrival
+type blockingHandler struct {
+	recordingHandler
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{
+		arrived: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+}
+
+func (h *blockingHandler) HandleSignal(ctx context.Context, signal *strategy.Signal) error {
+	h.arrived <- struct{}{}
+	<-h.release
+	return h.recordingHandler.HandleSignal(ctx, signal)
+}
+
+func TestEngine_MaxInFlightSignalsBlock(t *testing.T) {
+	handler := newBlockingHandler()
+	e := NewEngine(handler, WithConflation(), WithStrategyTimeout(0), WithMaxInFlightSignals(1, InFlightBlock))
+	assert.NoError(t, e.RegisterStrategy(signalOn("selling")))
+
+	ctx := context.Background()
+	assert.NoError(t, e.ProcessMarketData(ctx, tick("BTC-USD", 50000)))
+	<-handler.arrived
+	assert.NoError(t, e.ProcessMarketData(ctx, tick("ETH-USD", 3000)))
+
+	// The second signal waits for the first to finish
+	select {
+	case <-handler.arrived:
+		t.Fatal("Expected the second signal to block while the first is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, int64(1), e.InFlightSignals())
+
+	close(handler.release)
+	e.Flush()
+
+	assert.Len(t, handler.received(), 2)
+	assert.Equal(t, int64(0), e.InFlightSignals())
+	assert.Equal(t, uint64(0), e.DroppedSignals())
+}
+
+func TestEngine_MaxInFlightSignalsBlockHonorsContext(t *testing.T) {
+	handler := newBlockingHandler()
+	e := NewEngine(handler, WithConflation(), WithStrategyTimeout(0), WithMaxInFlightSignals(1, InFlightBlock))
+	assert.NoError(t, e.RegisterStrategy(signalOn("selling")))
+
+	assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", 50000)))
+	<-handler.arrived
+
+	// A signal blocked on the limit is dropped once its context ends
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, e.ProcessMarketData(ctx, tick("ETH-USD", 3000)))
+	assert.Eventually(t, func() bool { return e.InFlightSignals() == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.Eventually(t, func() bool { return e.DroppedSignals() == 1 }, time.Second, 5*time.Millisecond)
+
+	close(handler.release)
+	e.Flush()
+	assert.Len(t, handler.received(), 1)
+}
+
+func TestEngine_MaxInFlightSignalsDrop(t *testing.T) {
+	handler := newBlockingHandler()
+	e := NewEngine(handler, WithConflation(), WithStrategyTimeout(0), WithMaxInFlightSignals(2, InFlightDrop))
+	assert.NoError(t, e.RegisterStrategy(signalOn("selling")))
+
+	ctx := context.Background()
+	for _, symbol := range []string{"BTC-USD", "ETH-USD"} {
+		assert.NoError(t, e.ProcessMarketData(ctx, tick(symbol, 100)))
+		<-handler.arrived
+	}
+	assert.Equal(t, int64(2), e.InFlightSignals())
+
+	// Signals beyond the limit are discarded without waiting
+	for _, symbol := range []string{"SOL-USD", "DOGE-USD"} {
+		assert.NoError(t, e.ProcessMarketData(ctx, tick(symbol, 100)))
+	}
+	assert.Eventually(t, func() bool { return e.DroppedSignals() == 2 }, time.Second, 5*time.Millisecond)
+
+	close(handler.release)
+	e.Flush()
+
+	received := handler.received()
+	if assert.Len(t, received, 2) {
+		assert.ElementsMatch(t, []string{"BTC-USD", "ETH-USD"}, []string{received[0].Symbol, received[1].Symbol})
+	}
+	assert.Equal(t, int64(0), e.InFlightSignals())
+
+	// Freed slots accept signals again
+	assert.NoError(t, e.ProcessMarketData(ctx, tick("SOL-USD", 100)))
+	e.Flush()
+	assert.Len(t, handler.received(), 3)
+	assert.Equal(t, uint64(2), e.DroppedSignals())
+}
+
+func TestEngine_InFlightSignalsUnbounded(t *testing.T) {
+	handler := &recordingHandler{}
+	e := NewEngine(handler, WithMaxInFlightSignals(0, InFlightDrop))
+	assert.NoError(t, e.RegisterStrategy(signalOn("selling")))
+
+	for price := 1.0; price <= 3; price++ {
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("BTC-USD", price)))
+	}
+	assert.Len(t, handler.received(), 3)
+	assert.Equal(t, int64(0), e.InFlightSignals())
+	assert.Equal(t, uint64(0), e.DroppedSignals())
+}
+
+// resettableStrategy is a mock strategy counting its resets
+type resettableStrategy struct {
+	*mockStrategy
+	resets int
+}
+
+func (r *resettableStrategy) Reset(ctx context.Context) error {
+	r.resets++
+	return nil
+}
+
+func TestEngine_ResetStrategy(t *testing.T) {
+	handler := &recordingHandler{}
+	e := NewEngine(handler)
+	resettable := &resettableStrategy{mockStrategy: signalOn("resettable")}
+	assert.NoError(t, e.RegisterStrategy(resettable))
+	assert.NoError(t, e.RegisterStrategy(signalOn("plain")))
+
+	assert.NoError(t, e.ResetStrategy(context.Background(), "resettable"))
+	assert.Equal(t, 1, resettable.resets)
+	assert.ErrorIs(t, e.ResetStrategy(context.Background(), "plain"), ErrStrategyNotResettable)
+	assert.ErrorIs(t, e.ResetStrategy(context.Background(), "missing"), ErrStrategyNotFound)
+
+	// The reset strategy stays registered and keeps receiving data
+	assert.ElementsMatch(t, []string{"resettable", "plain"}, e.ListStrategies())
+	assert.NoError(t, e.ProcessMarketData(context.Background(), tick("AAPL", 100)))
+	assert.Len(t, handler.received(), 2)
+}
+
+// emits returns a strategy that emits the given action and quantity on every tick
+func emits(name string, action strategy.SignalAction, quantity float64) *mockStrategy {
+	return &mockStrategy{
+		name: name,
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			return &strategy.Signal{Symbol: data.Symbol, Action: action, Price: data.Price, Quantity: quantity, Metadata: map[string]interface{}{"strategy": name}}, nil
+		},
+	}
+}
+
+// stopsOut returns a strategy that emits a stop loss sell of quantity on every tick
+func stopsOut(name string, quantity float64) *mockStrategy {
+	return &mockStrategy{
+		name: name,
+		process: func(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
+			return &strategy.Signal{Symbol: data.Symbol, Action: strategy.SignalActionSell, Price: data.Price, Quantity: quantity, RiskExit: true, Metadata: map[string]interface{}{"reason": stoploss.TriggerReason}}, nil
+		},
+	}
+}
+
+func TestEngine_SignalNetting(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     NettingPolicy
+		strategies []*mockStrategy
+		expected   []strategy.SignalAction
+		quantities []float64
+	}{
+		{
+			name:       "disabled forwards both",
+			policy:     NettingNone,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 3), emits("stop_loss", strategy.SignalActionSell, 5)},
+			expected:   []strategy.SignalAction{strategy.SignalActionBuy, strategy.SignalActionSell},
+		},
+		{
+			name:       "sell wins",
+			policy:     NettingSellWins,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 3), emits("stop_loss", strategy.SignalActionSell, 5)},
+			expected:   []strategy.SignalAction{strategy.SignalActionSell},
+			quantities: []float64{5},
+		},
+		{
+			name:       "net sell",
+			policy:     NettingNetQuantity,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 3), emits("stop_loss", strategy.SignalActionSell, 5)},
+			expected:   []strategy.SignalAction{strategy.SignalActionSell},
+			quantities: []float64{2},
+		},
+		{
+			name:       "net buy",
+			policy:     NettingNetQuantity,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 7), emits("stop_loss", strategy.SignalActionSell, 5)},
+			expected:   []strategy.SignalAction{strategy.SignalActionBuy},
+			quantities: []float64{2},
+		},
+		{
+			name:       "cancelling out",
+			policy:     NettingNetQuantity,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 5), emits("stop_loss", strategy.SignalActionSell, 5)},
+		},
+		{
+			name:       "close all cannot be netted",
+			policy:     NettingNetQuantity,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 3), emits("stop_loss", strategy.SignalActionCloseAll, 0)},
+			expected:   []strategy.SignalAction{strategy.SignalActionCloseAll},
+			quantities: []float64{0},
+		},
+		{
+			name:       "stop loss exits are not netted",
+			policy:     NettingNetQuantity,
+			strategies: []*mockStrategy{emits("entry", strategy.SignalActionBuy, 5), stopsOut("stop_loss", 5)},
+			expected:   []strategy.SignalAction{strategy.SignalActionSell},
+			quantities: []float64{5},
+		},
+		{
+			name:       "agreeing signals are kept",
+			policy:     NettingSellWins,
+			strategies: []*mockStrategy{emits("stop_loss", strategy.SignalActionSell, 3), emits("trailing", strategy.SignalActionSell, 3)},
+			expected:   []strategy.SignalAction{strategy.SignalActionSell, strategy.SignalActionSell},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &recordingHandler{}
+			e := NewEngine(handler, WithSignalNetting(tt.policy))
+			for _, s := range tt.strategies {
+				assert.NoError(t, e.RegisterStrategy(s))
+			}
+			assert.NoError(t, e.ProcessMarketData(context.Background(), tick("AAPL", 190.0)))
+
+			received := handler.received()
+			actions := make([]strategy.SignalAction, 0, len(received))
+			for _, signal := range received {
+				actions = append(actions, signal.Action)
+			}
+			assert.ElementsMatch(t, tt.expected, actions)
+			if tt.quantities != nil && assert.Len(t, received, len(tt.quantities)) {
+				for i, quantity := range tt.quantities {
+					assert.Equal(t, quantity, received[i].Quantity)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_SignalNettingTemplateIsStable(t *testing.T) {
+	// Both buys can model the net signal, the first strategy by name must
+	// win whatever order the strategies run in
+	for i := 0; i < 20; i++ {
+		handler := &recordingHandler{}
+		e := NewEngine(handler, WithSignalNetting(NettingNetQuantity))
+		for _, s := range []*mockStrategy{emits("momentum", strategy.SignalActionBuy, 4), emits("breakout", strategy.SignalActionBuy, 2), emits("trailing", strategy.SignalActionSell, 1)} {
+			assert.NoError(t, e.RegisterStrategy(s))
+		}
+		assert.NoError(t, e.ProcessMarketData(context.Background(), tick("AAPL", 190.0)))
+
+		received := handler.received()
+		if assert.Len(t, received, 1) {
+			assert.Equal(t, 5.0, received[0].Quantity)
+			assert.Equal(t, "breakout", received[0].Metadata["strategy"])
+		}
+	}
+}
+
+func TestNetSignals_NetQuantityKeepsTemplate(t *testing.T) {
+	entry := &strategy.Signal{Symbol: "AAPL", Action: strategy.SignalActionBuy, Price: 190, Quantity: 3, Metadata: map[string]interface{}{"strategy": "entry"}}
+	stop := &strategy.Signal{Symbol: "AAPL", Action: strategy.SignalActionSell, Price: 189.5, Quantity: 5, Metadata: map[string]interface{}{"strategy": "stop_loss"}}
+	other := &strategy.Signal{Symbol: "MSFT", Action: strategy.SignalActionBuy, Price: 400, Quantity: 1}
+
+	netted := netSignals([]sourcedSignal{{"entry", entry}, {"other", other}, {"stop_loss", stop}}, NettingNetQuantity)
+	if assert.Len(t, netted, 2) {
+		assert.Same(t, other, netted[1].signal)
+		assert.Equal(t, "other", netted[1].strategy)
+		// The net signal is attributed to the strategy of its template
+		assert.Equal(t, "stop_loss", netted[0].strategy)
+		assert.Equal(t, strategy.SignalActionSell, netted[0].signal.Action)
+		assert.Equal(t, 189.5, netted[0].signal.Price)
+		assert.Equal(t, 2.0, netted[0].signal.Quantity)
+		assert.Equal(t, "stop_loss", netted[0].signal.Metadata["strategy"])
+		assert.Equal(t, 2, netted[0].signal.Metadata["netted_signals"])
+	}
+	// The original signals are left untouched
+	assert.Equal(t, 5.0, stop.Quantity)
+	assert.NotContains(t, stop.Metadata, "netted_signals")
+}
+
+func TestParseNettingPolicy(t *testing.T) {
+	for _, policy := range []NettingPolicy{NettingNone, NettingSellWins, NettingNetQuantity} {
+		parsed, err := ParseNettingPolicy(policy.String())
+		assert.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	parsed, err := ParseNettingPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, NettingNone, parsed)
+
+	_, err = ParseNettingPolicy("buy_wins")
+	assert.EqualError(t, err, `unknown signal netting policy "buy_wins", expected none, sell_wins or net_quantity`)
+}
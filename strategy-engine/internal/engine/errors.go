@@ -5,4 +5,9 @@ import "errors"
 var (
 	ErrStrategyAlreadyExists = errors.New("strategy already exists")
 	ErrStrategyNotFound      = errors.New("strategy not found")
+	ErrStrategyNotResettable = errors.New("strategy cannot be reset")
+	ErrInvalidMarketData     = errors.New("invalid market data")
+	ErrOutOfOrderMarketData  = errors.New("out of order market data")
+	ErrSignalDropped         = errors.New("signal dropped: too many signals in flight")
+	ErrStrategyStuck         = errors.New("strategy skipped: an abandoned call is still running")
 )
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// InFlightPolicy decides what happens to a signal when the maximum number of
+// signals is already being handled
+type InFlightPolicy int
+
+const (
+	// InFlightBlock waits for a signal to finish before handing over the next
+	InFlightBlock InFlightPolicy = iota
+	// InFlightDrop discards the signal and counts it
+	InFlightDrop
+)
+
+// signalLimiter bounds how many signals are handled concurrently
+type signalLimiter struct {
+	slots   chan struct{}
+	policy  InFlightPolicy
+	dropped atomic.Uint64
+}
+
+func newSignalLimiter(max int, policy InFlightPolicy) *signalLimiter {
+	return &signalLimiter{
+		slots:  make(chan struct{}, max),
+		policy: policy,
+	}
+}
+
+// acquire takes a slot for a signal. It reports false when the signal is
+// dropped, either by the drop policy or because the context ended while
+// blocked.
+func (l *signalLimiter) acquire(ctx context.Context) bool {
+	if l.policy == InFlightDrop {
+		select {
+		case l.slots <- struct{}{}:
+			return true
+		default:
+			l.dropped.Add(1)
+			return false
+		}
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		l.dropped.Add(1)
+		return false
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *signalLimiter) release() {
+	<-l.slots
+}
+
+// handleSignal hands a signal to the signal handler, subject to the in-flight
+// limit when one is configured
+func (e *Engine) handleSignal(ctx context.Context, signal *strategy.Signal) error {
+	if e.signalLimiter != nil {
+		if !e.signalLimiter.acquire(ctx) {
+			return ErrSignalDropped
+		}
+		defer e.signalLimiter.release()
+	}
+
+	e.inFlight.Add(1)
+	defer e.inFlight.Add(-1)
+	return e.signalHandler.HandleSignal(ctx, signal)
+}
+
+// InFlightSignals returns how many signals the signal handler is currently
+// handling
+func (e *Engine) InFlightSignals() int64 {
+	return e.inFlight.Load()
+}
+
+// DroppedSignals returns how many signals never reached the signal handler
+// because the in-flight limit was reached
+func (e *Engine) DroppedSignals() uint64 {
+	if e.signalLimiter == nil {
+		return 0
+	}
+	return e.signalLimiter.dropped.Load()
+}
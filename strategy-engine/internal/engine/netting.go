@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// NettingPolicy decides how conflicting signals for the same symbol from a
+// single tick are resolved before reaching the signal handler. Signals
+// conflict when some of them buy (BUY, SCALE_IN) and others sell (SELL,
+// SCALE_OUT, CLOSE_ALL); signals that agree are always forwarded as they are.
+type NettingPolicy int
+
+const (
+	// NettingNone forwards every signal, conflicting or not
+	NettingNone NettingPolicy = iota
+	// NettingSellWins drops the buying side of a conflict, so risk-reducing
+	// signals such as stop losses always win
+	NettingSellWins
+	// NettingNetQuantity replaces a conflict between BUY and SELL signals
+	// with a single signal for the difference of their quantities, or none
+	// when they cancel out. Conflicts involving fractional or close-all
+	// actions cannot be netted by quantity and fall back to NettingSellWins,
+	// and so do risk exits such as stop losses, which a buy must never
+	// reduce.
+	NettingNetQuantity
+)
+
+// ParseNettingPolicy parses a netting policy name: none, sell_wins or
+// net_quantity. An empty name is NettingNone.
+func ParseNettingPolicy(name string) (NettingPolicy, error) {
+	switch name {
+	case "", "none":
+		return NettingNone, nil
+	case "sell_wins":
+		return NettingSellWins, nil
+	case "net_quantity":
+		return NettingNetQuantity, nil
+	default:
+		return NettingNone, fmt.Errorf("unknown signal netting policy %q, expected none, sell_wins or net_quantity", name)
+	}
+}
+
+// String returns the policy name accepted by ParseNettingPolicy
+func (p NettingPolicy) String() string {
+	switch p {
+	case NettingSellWins:
+		return "sell_wins"
+	case NettingNetQuantity:
+		return "net_quantity"
+	default:
+		return "none"
+	}
+}
+
+// side returns +1 for actions that buy, -1 for actions that sell and 0 for
+// the rest
+func side(action strategy.SignalAction) int {
+	switch action {
+	case strategy.SignalActionBuy, strategy.SignalActionScaleIn:
+		return 1
+	case strategy.SignalActionSell, strategy.SignalActionScaleOut, strategy.SignalActionCloseAll:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// sourcedSignal is a signal along with the name of the strategy that
+// generated it
+type sourcedSignal struct {
+	strategy string
+	signal   *strategy.Signal
+}
+
+// netSignals resolves conflicting signals per symbol under the policy,
+// keeping the order in which symbols first appear
+func netSignals(signals []sourcedSignal, policy NettingPolicy) []sourcedSignal {
+	if policy == NettingNone || len(signals) < 2 {
+		return signals
+	}
+
+	var symbols []string
+	bySymbol := make(map[string][]sourcedSignal)
+	for _, s := range signals {
+		if _, seen := bySymbol[s.signal.Symbol]; !seen {
+			symbols = append(symbols, s.signal.Symbol)
+		}
+		bySymbol[s.signal.Symbol] = append(bySymbol[s.signal.Symbol], s)
+	}
+
+	netted := make([]sourcedSignal, 0, len(signals))
+	for _, symbol := range symbols {
+		netted = append(netted, netSymbol(bySymbol[symbol], policy)...)
+	}
+	return netted
+}
+
+// netSymbol resolves the signals of a single symbol
+func netSymbol(signals []sourcedSignal, policy NettingPolicy) []sourcedSignal {
+	buys, sells := 0, 0
+	byQuantity := true // Whether every buy and sell has an absolute quantity
+	for _, s := range signals {
+		switch side(s.signal.Action) {
+		case 1:
+			buys++
+		case -1:
+			sells++
+		}
+		if s.signal.Action != strategy.SignalActionBuy && s.signal.Action != strategy.SignalActionSell && side(s.signal.Action) != 0 {
+			byQuantity = false
+		}
+		if s.signal.RiskExit {
+			byQuantity = false
+		}
+	}
+	if buys == 0 || sells == 0 {
+		return signals
+	}
+
+	if policy == NettingNetQuantity && byQuantity {
+		return netByQuantity(signals)
+	}
+
+	// Sell wins: everything but the buying side is kept
+	kept := make([]sourcedSignal, 0, len(signals)-buys)
+	for _, s := range signals {
+		if side(s.signal.Action) != 1 {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// netByQuantity replaces the BUY and SELL signals of a symbol with a single
+// signal for their net quantity, modeled on the first signal of the winning
+// side, and attributed to its strategy. Signals on neither side are kept.
+func netByQuantity(signals []sourcedSignal) []sourcedSignal {
+	var net float64
+	var firstBuy, firstSell *sourcedSignal
+	kept := make([]sourcedSignal, 0, len(signals))
+	conflicting := 0
+	for i, s := range signals {
+		switch s.signal.Action {
+		case strategy.SignalActionBuy:
+			net += s.signal.Quantity
+			if firstBuy == nil {
+				firstBuy = &signals[i]
+			}
+		case strategy.SignalActionSell:
+			net -= s.signal.Quantity
+			if firstSell == nil {
+				firstSell = &signals[i]
+			}
+		default:
+			kept = append(kept, s)
+			continue
+		}
+		conflicting++
+	}
+	if net == 0 {
+		return kept
+	}
+
+	template, quantity := firstBuy, net
+	if net < 0 {
+		template, quantity = firstSell, -net
+	}
+	result := *template.signal
+	result.Quantity = quantity
+	result.Metadata = make(map[string]interface{}, len(template.signal.Metadata)+1)
+	for k, v := range template.signal.Metadata {
+		result.Metadata[k] = v
+	}
+	result.Metadata["netted_signals"] = conflicting
+	return append(kept, sourcedSignal{strategy: template.strategy, signal: &result})
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/store"
+)
+
+// DefaultStrategyTimeout bounds a single ProcessData call
+const DefaultStrategyTimeout = 5 * time.Second
+
+// Option configures optional engine behavior
+type Option func(*Engine)
+
+// WithSignalStore records every generated signal in the given store before
+// it is handed to the signal handler
+func WithSignalStore(s store.SignalStore) Option {
+	return func(e *Engine) {
+		e.signalStore = s
+	}
+}
+
+// WithStrategyTimeout bounds each ProcessData call. A strategy that does not
+// return in time is abandoned so it cannot stall the engine, and is skipped
+// until the abandoned call returns. A non-positive
+// timeout calls strategies directly with no deadline.
+func WithStrategyTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.strategyTimeout = timeout
+	}
+}
+
+// WithValidationPolicy sets how market data failing validation is treated.
+// Invalid data is rejected by default.
+func WithValidationPolicy(policy ValidationPolicy) Option {
+	return func(e *Engine) {
+		e.validationPolicy = policy
+	}
+}
+
+// WithConflation makes ProcessMarketData buffer only the most recent tick per
+// symbol and process it asynchronously. Ticks superseded before a strategy
+// sees them are dropped and counted. Use Flush to wait for buffered ticks.
+func WithConflation() Option {
+	return func(e *Engine) {
+		e.conflator = newConflator(e.dispatch)
+	}
+}
+
+// WithMaxInFlightSignals bounds how many signals the signal handler may be
+// handling at once. This matters when market data is dispatched concurrently,
+// e.g. with conflation. Once the limit is reached the policy either blocks
+// until a signal finishes or drops the new signal. A non-positive max leaves
+// signal handling unbounded.
+func WithMaxInFlightSignals(max int, policy InFlightPolicy) Option {
+	return func(e *Engine) {
+		if max <= 0 {
+			e.signalLimiter = nil
+			return
+		}
+		e.signalLimiter = newSignalLimiter(max, policy)
+	}
+}
+
+// WithSignalNetting resolves conflicting buy and sell signals for the same
+// symbol from a single tick under the policy before they reach the signal
+// handler. Every signal is forwarded by default.
+func WithSignalNetting(policy NettingPolicy) Option {
+	return func(e *Engine) {
+		e.nettingPolicy = policy
+	}
+}
+
+// WithTimestampOrdering drops market data whose timestamp is older than the
+// latest one processed for the same symbol, e.g. ticks redelivered out of
+// order after a queue reconnect, so strategies never see time go backwards
+// and a stale price cannot overwrite newer state. Drops are counted, see
+// OutOfOrderCount.
+func WithTimestampOrdering() Option {
+	return func(e *Engine) {
+		e.ordering = newOrderingGuard()
+	}
+}
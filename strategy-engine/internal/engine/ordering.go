@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// orderingGuard remembers the latest timestamp processed per symbol so
+// ticks older than it, e.g. redelivered after a queue reconnect, can be
+// dropped before they reach the strategies
+type orderingGuard struct {
+	mu      sync.Mutex
+	latest  map[string]time.Time
+	dropped atomic.Uint64
+}
+
+func newOrderingGuard() *orderingGuard {
+	return &orderingGuard{latest: make(map[string]time.Time)}
+}
+
+// admit records the tick's timestamp and returns nil, or returns an error
+// wrapping ErrOutOfOrderMarketData when it is older than the latest one seen
+// for the symbol. Equal timestamps are admitted, so strategies see
+// non-decreasing time per symbol. Ticks without a timestamp are not ordered.
+func (g *orderingGuard) admit(data strategy.MarketData) error {
+	if data.Timestamp.IsZero() {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	latest := g.latest[data.Symbol]
+	if data.Timestamp.Before(latest) {
+		g.dropped.Add(1)
+		return fmt.Errorf("%w: tick for %s at %s is older than %s", ErrOutOfOrderMarketData, data.Symbol, data.Timestamp.Format(time.RFC3339Nano), latest.Format(time.RFC3339Nano))
+	}
+	g.latest[data.Symbol] = data.Timestamp
+	return nil
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// ValidationPolicy decides what happens to market data that fails validation
+type ValidationPolicy int
+
+const (
+	// ValidationReject drops invalid market data before it reaches any strategy
+	ValidationReject ValidationPolicy = iota
+	// ValidationPass counts invalid market data but still dispatches it
+	ValidationPass
+)
+
+// validateMarketData checks that a tick is usable by strategies
+func validateMarketData(data strategy.MarketData) error {
+	switch {
+	case data.Symbol == "":
+		return fmt.Errorf("%w: empty symbol", ErrInvalidMarketData)
+	// NaN compares false with everything, so it is checked explicitly
+	case math.IsNaN(data.Price) || math.IsInf(data.Price, 0):
+		return fmt.Errorf("%w: non-finite price %v for %s", ErrInvalidMarketData, data.Price, data.Symbol)
+	case data.Price <= 0:
+		return fmt.Errorf("%w: non-positive price %v for %s", ErrInvalidMarketData, data.Price, data.Symbol)
+	case math.IsNaN(data.Volume) || math.IsInf(data.Volume, 0):
+		return fmt.Errorf("%w: non-finite volume %v for %s", ErrInvalidMarketData, data.Volume, data.Symbol)
+	case data.Volume <= 0:
+		return fmt.Errorf("%w: non-positive volume %v for %s", ErrInvalidMarketData, data.Volume, data.Symbol)
+	case data.Timestamp.IsZero():
+		return fmt.Errorf("%w: zero timestamp for %s", ErrInvalidMarketData, data.Symbol)
+	}
+	return nil
+}
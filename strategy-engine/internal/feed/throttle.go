@@ -0,0 +1,191 @@
+package feed
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// Processor consumes market data, e.g. an *engine.Engine
+type Processor interface {
+	ProcessMarketData(ctx context.Context, data strategy.MarketData) error
+}
+
+// pendingTick is the latest held back tick for a symbol
+type pendingTick struct {
+	ctx  context.Context
+	data strategy.MarketData
+}
+
+// Throttle forwards market data to a Processor at most once per symbol per
+// interval. The first tick of a symbol is forwarded right away; ticks
+// arriving before the interval has passed are coalesced into the latest one,
+// which is forwarded by Flush once the interval is up. This keeps firehose
+// symbols from overwhelming strategy evaluation while strategies still see
+// the latest price.
+//
+// Next is called without holding the throttle's lock, so a slow strategy
+// does not stall ticks that are only held back, and different symbols may
+// be forwarded concurrently. Ticks of a symbol still reach next in order:
+// while one is being forwarded, newer ones are held back.
+type Throttle struct {
+	next     Processor
+	interval time.Duration
+	now      func() time.Time
+	wake     chan struct{} // Tells Run that a tick was held back
+
+	mu         sync.Mutex
+	last       map[string]time.Time // When a tick of the symbol was last forwarded
+	pending    map[string]pendingTick
+	forwarding map[string]bool // Symbols with a tick being forwarded
+	coalesced  uint64
+}
+
+// NewThrottle creates a throttle in front of next. A non-positive interval
+// forwards every tick.
+func NewThrottle(next Processor, interval time.Duration) *Throttle {
+	return &Throttle{
+		next:       next,
+		interval:   interval,
+		now:        time.Now,
+		wake:       make(chan struct{}, 1),
+		last:       make(map[string]time.Time),
+		pending:    make(map[string]pendingTick),
+		forwarding: make(map[string]bool),
+	}
+}
+
+// ProcessMarketData forwards data if no tick of its symbol was forwarded
+// within the interval, and otherwise holds it back in place of any older
+// held back tick. Only errors of forwarded ticks are returned.
+func (t *Throttle) ProcessMarketData(ctx context.Context, data strategy.MarketData) error {
+	if t.interval <= 0 {
+		return t.next.ProcessMarketData(ctx, data)
+	}
+
+	t.mu.Lock()
+	now := t.now()
+	last, ok := t.last[data.Symbol]
+	if t.forwarding[data.Symbol] || (ok && now.Sub(last) < t.interval) {
+		if _, exists := t.pending[data.Symbol]; exists {
+			t.coalesced++
+		} else {
+			t.signalRun()
+		}
+		t.pending[data.Symbol] = pendingTick{ctx: ctx, data: data}
+		t.mu.Unlock()
+		return nil
+	}
+
+	// A newer tick supersedes anything held back
+	if _, exists := t.pending[data.Symbol]; exists {
+		delete(t.pending, data.Symbol)
+		t.coalesced++
+	}
+	t.last[data.Symbol] = now
+	t.forwarding[data.Symbol] = true
+	t.mu.Unlock()
+
+	err := t.next.ProcessMarketData(ctx, data)
+	t.forwarded(data.Symbol)
+	return err
+}
+
+// forwarded clears the forwarding mark of a symbol once next returned, and
+// has Run look at a tick held back meanwhile
+func (t *Throttle) forwarded(symbol string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.forwarding, symbol)
+	if _, exists := t.pending[symbol]; exists {
+		t.signalRun()
+	}
+}
+
+// signalRun wakes Run to reschedule its next flush, must be called with t.mu
+// held
+func (t *Throttle) signalRun() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Flush forwards every held back tick whose symbol's interval is up. Ticks
+// that fail are logged and dropped.
+func (t *Throttle) Flush() {
+	t.flush()
+}
+
+// flush implements Flush and returns when the next held back tick is due,
+// zero when nothing is held back
+func (t *Throttle) flush() time.Time {
+	t.mu.Lock()
+	now := t.now()
+	due := make(map[string]pendingTick)
+	for symbol, tick := range t.pending {
+		if t.forwarding[symbol] || now.Sub(t.last[symbol]) < t.interval {
+			continue
+		}
+		delete(t.pending, symbol)
+		t.last[symbol] = now
+		t.forwarding[symbol] = true
+		due[symbol] = tick
+	}
+	t.mu.Unlock()
+
+	for symbol, tick := range due {
+		if err := t.next.ProcessMarketData(tick.ctx, tick.data); err != nil {
+			log.Printf("Error processing throttled market data for %s: %v", symbol, err)
+		}
+		t.forwarded(symbol)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var next time.Time
+	for symbol := range t.pending {
+		if at := t.last[symbol].Add(t.interval); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// Run flushes each held back tick as soon as its symbol's interval is up,
+// until ctx is done. Held back ticks are dropped when it returns. It returns
+// immediately for a non-positive interval, which holds nothing back.
+func (t *Throttle) Run(ctx context.Context) {
+	if t.interval <= 0 {
+		return
+	}
+	timer := time.NewTimer(t.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.wake:
+		case <-timer.C:
+		}
+
+		// Sleep until the next held back tick is due, or one is held back
+		if next := t.flush(); next.IsZero() {
+			timer.Stop()
+		} else {
+			timer.Reset(next.Sub(t.now()))
+		}
+	}
+}
+
+// CoalescedCount returns how many ticks were dropped because a newer tick of
+// the same symbol arrived before they were forwarded
+func (t *Throttle) CoalescedCount() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.coalesced
+}
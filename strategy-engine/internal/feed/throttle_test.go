@@ -0,0 +1,286 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingProcessor collects every tick it is given
+type recordingProcessor struct {
+	mu    sync.Mutex
+	ticks []strategy.MarketData
+	at    []time.Time // When each tick was received, per now
+	now   func() time.Time
+	err   error
+}
+
+func (p *recordingProcessor) ProcessMarketData(ctx context.Context, data strategy.MarketData) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ticks = append(p.ticks, data)
+	if p.now != nil {
+		p.at = append(p.at, p.now())
+	}
+	return p.err
+}
+
+func (p *recordingProcessor) received() []strategy.MarketData {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]strategy.MarketData(nil), p.ticks...)
+}
+
+// pricesOf returns the prices of the ticks received for a symbol
+func (p *recordingProcessor) pricesOf(symbol string) []float64 {
+	var prices []float64
+	for _, tick := range p.received() {
+		if tick.Symbol == symbol {
+			prices = append(prices, tick.Price)
+		}
+	}
+	return prices
+}
+
+// timesOf returns when the ticks of a symbol were received
+func (p *recordingProcessor) timesOf(symbol string) []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var times []time.Time
+	for i, tick := range p.ticks {
+		if tick.Symbol == symbol {
+			times = append(times, p.at[i])
+		}
+	}
+	return times
+}
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestThrottle(next Processor, interval time.Duration) (*Throttle, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)}
+	throttle := NewThrottle(next, interval)
+	throttle.now = clock.Now
+	return throttle, clock
+}
+
+func tick(symbol string, price float64) strategy.MarketData {
+	return strategy.MarketData{Symbol: symbol, Price: price, Volume: 1.0, Timestamp: time.Now()}
+}
+
+func TestThrottleCoalescesBurst(t *testing.T) {
+	processor := &recordingProcessor{}
+	throttle, clock := newTestThrottle(processor, time.Second)
+	ctx := context.Background()
+
+	// A burst of ticks within one interval
+	for i := 0; i < 100; i++ {
+		require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50000+float64(i))))
+		clock.Advance(5 * time.Millisecond)
+	}
+	assert.Equal(t, []float64{50000}, processor.pricesOf("BTC-USD"), "only the first tick is forwarded right away")
+
+	// Flushing within the interval forwards nothing
+	throttle.Flush()
+	assert.Len(t, processor.received(), 1)
+
+	// Once the interval is up the latest price is forwarded
+	clock.Advance(time.Second)
+	throttle.Flush()
+	assert.Equal(t, []float64{50000, 50099}, processor.pricesOf("BTC-USD"))
+	assert.Equal(t, uint64(98), throttle.CoalescedCount())
+
+	// Nothing is left to flush
+	clock.Advance(time.Second)
+	throttle.Flush()
+	assert.Len(t, processor.received(), 2)
+}
+
+func TestThrottleAtMostOncePerIntervalPerSymbol(t *testing.T) {
+	processor := &recordingProcessor{}
+	interval := 100 * time.Millisecond
+	throttle, clock := newTestThrottle(processor, interval)
+	processor.now = clock.Now
+	ctx := context.Background()
+
+	// Ten intervals of ticks every millisecond for two symbols, flushing
+	// as Run would
+	for ms := 0; ms < 1000; ms++ {
+		require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", float64(ms))))
+		require.NoError(t, throttle.ProcessMarketData(ctx, tick("ETH-USD", float64(ms))))
+		if ms%10 == 0 {
+			throttle.Flush()
+		}
+		clock.Advance(time.Millisecond)
+	}
+
+	for _, symbol := range []string{"BTC-USD", "ETH-USD"} {
+		forwardedAt := processor.timesOf(symbol)
+		assert.LessOrEqual(t, len(forwardedAt), 10, "%s forwarded more than once per interval", symbol)
+		assert.GreaterOrEqual(t, len(forwardedAt), 9, "%s starved", symbol)
+		for i := 1; i < len(forwardedAt); i++ {
+			assert.GreaterOrEqual(t, forwardedAt[i].Sub(forwardedAt[i-1]), interval, "%s forwarded twice within an interval", symbol)
+		}
+	}
+}
+
+func TestThrottleSymbolsAreIndependent(t *testing.T) {
+	processor := &recordingProcessor{}
+	throttle, clock := newTestThrottle(processor, time.Second)
+	ctx := context.Background()
+
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50000)))
+	clock.Advance(100 * time.Millisecond)
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("ETH-USD", 3000)))
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50100)))
+
+	assert.Equal(t, []float64{50000}, processor.pricesOf("BTC-USD"))
+	assert.Equal(t, []float64{3000}, processor.pricesOf("ETH-USD"))
+}
+
+func TestThrottleForwardsAfterQuietInterval(t *testing.T) {
+	processor := &recordingProcessor{}
+	throttle, clock := newTestThrottle(processor, time.Second)
+	ctx := context.Background()
+
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50000)))
+	clock.Advance(500 * time.Millisecond)
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50100)))
+
+	// A tick after the interval supersedes the held back one without a flush
+	clock.Advance(600 * time.Millisecond)
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50200)))
+	throttle.Flush()
+
+	assert.Equal(t, []float64{50000, 50200}, processor.pricesOf("BTC-USD"))
+	assert.Equal(t, uint64(1), throttle.CoalescedCount())
+}
+
+func TestThrottleDisabled(t *testing.T) {
+	processor := &recordingProcessor{}
+	throttle := NewThrottle(processor, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", float64(i))))
+	}
+	assert.Len(t, processor.received(), 5)
+
+	// Run returns right away
+	throttle.Run(ctx)
+}
+
+func TestThrottleReturnsForwardedErrors(t *testing.T) {
+	processor := &recordingProcessor{err: errors.New("invalid market data")}
+	throttle, _ := newTestThrottle(processor, time.Second)
+
+	err := throttle.ProcessMarketData(context.Background(), tick("BTC-USD", 50000))
+	assert.EqualError(t, err, "invalid market data")
+
+	// Held back ticks report no error
+	assert.NoError(t, throttle.ProcessMarketData(context.Background(), tick("BTC-USD", 50100)))
+}
+
+func TestThrottleRunFlushes(t *testing.T) {
+	processor := &recordingProcessor{}
+	throttle := NewThrottle(processor, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		throttle.Run(ctx)
+	}()
+
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50000)))
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50100)))
+
+	assert.Eventually(t, func() bool {
+		return len(processor.received()) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []float64{50000, 50100}, processor.pricesOf("BTC-USD"))
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+// blockingProcessor blocks forwarding ticks of a symbol until released
+type blockingProcessor struct {
+	recordingProcessor
+	symbol  string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProcessor) ProcessMarketData(ctx context.Context, data strategy.MarketData) error {
+	if data.Symbol == p.symbol {
+		p.entered <- struct{}{}
+		<-p.release
+	}
+	return p.recordingProcessor.ProcessMarketData(ctx, data)
+}
+
+func TestThrottleDoesNotHoldLockWhileForwarding(t *testing.T) {
+	processor := &blockingProcessor{symbol: "BTC-USD", entered: make(chan struct{}, 1), release: make(chan struct{})}
+	throttle, clock := newTestThrottle(processor, time.Second)
+	ctx := context.Background()
+
+	forwarded := make(chan error, 1)
+	go func() {
+		forwarded <- throttle.ProcessMarketData(ctx, tick("BTC-USD", 50000))
+	}()
+	<-processor.entered
+
+	// While BTC-USD is being forwarded, other symbols still get through and
+	// its own newer ticks are held back, even once its interval is up
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("ETH-USD", 3000)))
+	clock.Advance(2 * time.Second)
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50100)))
+	throttle.Flush()
+	assert.Equal(t, uint64(0), throttle.CoalescedCount())
+	assert.Equal(t, []float64{3000}, processor.pricesOf("ETH-USD"))
+
+	close(processor.release)
+	require.NoError(t, <-forwarded)
+	throttle.Flush()
+	assert.Equal(t, []float64{50000, 50100}, processor.pricesOf("BTC-USD"))
+}
+
+func TestThrottleRunFlushesWhenDue(t *testing.T) {
+	processor := &recordingProcessor{now: time.Now}
+	interval := 300 * time.Millisecond
+	throttle := NewThrottle(processor, interval)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go throttle.Run(ctx)
+
+	// Out of phase with any fixed flush schedule started with Run
+	time.Sleep(interval / 3)
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50000)))
+	require.NoError(t, throttle.ProcessMarketData(ctx, tick("BTC-USD", 50100)))
+
+	assert.Eventually(t, func() bool {
+		return len(processor.received()) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	forwardedAt := processor.timesOf("BTC-USD")
+	require.Len(t, forwardedAt, 2)
+	wait := forwardedAt[1].Sub(forwardedAt[0])
+	assert.GreaterOrEqual(t, wait, interval)
+	assert.Less(t, wait, interval*3/2, "the held back tick waited well past its interval")
+}
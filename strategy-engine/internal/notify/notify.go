@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy/stoploss"
+)
+
+// StopLossAlert describes a triggered stop loss
+type StopLossAlert struct {
+	Symbol          string    `json:"symbol"`
+	Price           float64   `json:"price"` // Price that triggered the stop
+	Quantity        float64   `json:"quantity"`
+	EntryPrice      float64   `json:"entry_price"`
+	HighestPrice    float64   `json:"highest_price"`
+	DrawdownPercent float64   `json:"drawdown_percent"` // Drawdown from the highest price
+	TriggeredAt     time.Time `json:"triggered_at"`
+}
+
+// Message formats the alert as a single human readable line
+func (a StopLossAlert) Message() string {
+	return fmt.Sprintf("Stop loss triggered for %s: price %.2f is down %.2f%% from a high of %.2f (entry %.2f), selling %g",
+		a.Symbol, a.Price, a.DrawdownPercent, a.HighestPrice, a.EntryPrice, a.Quantity)
+}
+
+// alertQueueSize bounds the alerts waiting for delivery. Alerts beyond it
+// are dropped rather than holding up signal handling.
+const alertQueueSize = 64
+
+// Notifier pushes alerts to an external channel
+type Notifier interface {
+	NotifyStopLoss(ctx context.Context, alert StopLossAlert) error
+}
+
+// SignalHandler wraps a strategy.SignalHandler and notifies whenever a stop
+// loss signal passes through. Alerts are queued and delivered by Run, so
+// notification failures are logged and never fail or delay signal
+// processing.
+type SignalHandler struct {
+	next     strategy.SignalHandler
+	notifier Notifier
+	alerts   chan StopLossAlert
+}
+
+// NewSignalHandler creates a handler forwarding signals to next and stop loss
+// alerts to notifier. Alerts are only delivered while Run is running.
+func NewSignalHandler(next strategy.SignalHandler, notifier Notifier) *SignalHandler {
+	return &SignalHandler{
+		next:     next,
+		notifier: notifier,
+		alerts:   make(chan StopLossAlert, alertQueueSize),
+	}
+}
+
+// HandleSignal implements strategy.SignalHandler. The signal is handled by the
+// wrapped handler, then its alert is queued without waiting for delivery, so
+// a slow notifier never delays execution. The alert is dropped when the
+// queue is full.
+func (h *SignalHandler) HandleSignal(ctx context.Context, signal *strategy.Signal) error {
+	err := h.next.HandleSignal(ctx, signal)
+
+	if alert, ok := stopLossAlert(signal); ok {
+		select {
+		case h.alerts <- alert:
+		default:
+			log.Printf("Dropping stop loss alert for %s, %d alerts are waiting for delivery", signal.Symbol, alertQueueSize)
+		}
+	}
+
+	return err
+}
+
+// Run delivers queued alerts one at a time until ctx is done. Alerts still
+// queued when it returns are not delivered.
+func (h *SignalHandler) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-h.alerts:
+			if err := h.notifier.NotifyStopLoss(ctx, alert); err != nil {
+				log.Printf("Failed to notify stop loss for %s: %v", alert.Symbol, err)
+			}
+		}
+	}
+}
+
+// stopLossAlert builds the alert of a stop loss signal, reporting false for
+// any other signal
+func stopLossAlert(signal *strategy.Signal) (StopLossAlert, bool) {
+	if signal == nil || signal.Metadata["reason"] != stoploss.TriggerReason {
+		return StopLossAlert{}, false
+	}
+
+	metadata := func(key string) float64 {
+		value, _ := signal.Metadata[key].(float64)
+		return value
+	}
+	return StopLossAlert{
+		Symbol:          signal.Symbol,
+		Price:           signal.Price,
+		Quantity:        signal.Quantity,
+		EntryPrice:      metadata("entry_price"),
+		HighestPrice:    metadata("highest_price"),
+		DrawdownPercent: metadata("current_drawdown"),
+		TriggeredAt:     signal.GeneratedAt,
+	}, true
+}
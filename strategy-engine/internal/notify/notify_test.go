@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy/stoploss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler records the signals it handles
+type recordingHandler struct {
+	signals []*strategy.Signal
+	err     error
+}
+
+func (h *recordingHandler) HandleSignal(ctx context.Context, signal *strategy.Signal) error {
+	h.signals = append(h.signals, signal)
+	return h.err
+}
+
+// stopLossSignal returns a signal as emitted by the stop loss strategy
+func stopLossSignal() *strategy.Signal {
+	return &strategy.Signal{
+		Symbol:      "AAPL",
+		Action:      strategy.SignalActionSell,
+		Price:       90,
+		Quantity:    10,
+		GeneratedAt: time.Date(2025, 3, 3, 14, 30, 0, 0, time.UTC),
+		Metadata: map[string]interface{}{
+			"reason":           stoploss.TriggerReason,
+			"entry_price":      95.0,
+			"highest_price":    100.0,
+			"current_drawdown": 10.0,
+		},
+	}
+}
+
+// newWebhookServer returns a webhook server decoding each payload it receives
+// and answering with status
+func newWebhookServer(t *testing.T, status int) (*httptest.Server, <-chan WebhookPayload) {
+	t.Helper()
+	payloads := make(chan WebhookPayload, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var payload WebhookPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		payloads <- payload
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, payloads
+}
+
+// runHandler delivers the alerts of h until the test ends
+func runHandler(t *testing.T, h *SignalHandler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// receive waits for the next payload posted to the webhook
+func receive(t *testing.T, payloads <-chan WebhookPayload) WebhookPayload {
+	t.Helper()
+	select {
+	case payload := <-payloads:
+		return payload
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a webhook delivery")
+		return WebhookPayload{}
+	}
+}
+
+// blockingNotifier holds every delivery until released, counting them
+type blockingNotifier struct {
+	started   chan struct{}
+	release   chan struct{}
+	delivered atomic.Int32
+}
+
+func (n *blockingNotifier) NotifyStopLoss(ctx context.Context, alert StopLossAlert) error {
+	select {
+	case n.started <- struct{}{}:
+	default:
+	}
+	<-n.release
+	n.delivered.Add(1)
+	return nil
+}
+
+func TestSignalHandler_NotifiesStopLoss(t *testing.T) {
+	srv, payloads := newWebhookServer(t, http.StatusOK)
+	next := &recordingHandler{}
+	h := NewSignalHandler(next, NewWebhookNotifier(srv.URL))
+	runHandler(t, h)
+
+	signal := stopLossSignal()
+	require.NoError(t, h.HandleSignal(context.Background(), signal))
+	assert.Equal(t, []*strategy.Signal{signal}, next.signals)
+
+	payload := receive(t, payloads)
+	assert.Equal(t, StopLossAlert{
+		Symbol:          "AAPL",
+		Price:           90,
+		Quantity:        10,
+		EntryPrice:      95,
+		HighestPrice:    100,
+		DrawdownPercent: 10,
+		TriggeredAt:     signal.GeneratedAt,
+	}, payload.Alert)
+	assert.Equal(t, "Stop loss triggered for AAPL: price 90.00 is down 10.00% from a high of 100.00 (entry 95.00), selling 10", payload.Text)
+}
+
+func TestSignalHandler_IgnoresOtherSignals(t *testing.T) {
+	srv, payloads := newWebhookServer(t, http.StatusOK)
+	next := &recordingHandler{}
+	h := NewSignalHandler(next, NewWebhookNotifier(srv.URL))
+	runHandler(t, h)
+
+	signal := &strategy.Signal{Symbol: "MSFT", Action: strategy.SignalActionBuy, Price: 100, Quantity: 1}
+	require.NoError(t, h.HandleSignal(context.Background(), signal))
+	require.NoError(t, h.HandleSignal(context.Background(), stopLossSignal()))
+
+	// Alerts are delivered in order, so the buy queued none
+	assert.Len(t, next.signals, 2)
+	assert.Equal(t, "AAPL", receive(t, payloads).Alert.Symbol)
+}
+
+func TestSignalHandler_NotifyFailureNonFatal(t *testing.T) {
+	srv, payloads := newWebhookServer(t, http.StatusInternalServerError)
+	next := &recordingHandler{}
+	h := NewSignalHandler(next, NewWebhookNotifier(srv.URL))
+	runHandler(t, h)
+
+	require.NoError(t, h.HandleSignal(context.Background(), stopLossSignal()))
+	assert.Len(t, next.signals, 1)
+	receive(t, payloads)
+
+	// Errors of the wrapped handler are still returned, and the alert sent
+	next.err = errors.New("order rejected")
+	err := h.HandleSignal(context.Background(), stopLossSignal())
+	assert.ErrorIs(t, err, next.err)
+	receive(t, payloads)
+}
+
+func TestSignalHandler_SlowNotifierDoesNotDelay(t *testing.T) {
+	notifier := &blockingNotifier{started: make(chan struct{}, 1), release: make(chan struct{})}
+	next := &recordingHandler{}
+	h := NewSignalHandler(next, notifier)
+	runHandler(t, h)
+	t.Cleanup(func() { close(notifier.release) })
+
+	// The first alert holds up delivery
+	require.NoError(t, h.HandleSignal(context.Background(), stopLossSignal()))
+	select {
+	case <-notifier.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the first alert to be delivered")
+	}
+
+	// Signals keep being handled, queueing their alerts until the queue is
+	// full and dropping the rest
+	for i := 0; i < alertQueueSize+1; i++ {
+		require.NoError(t, h.HandleSignal(context.Background(), stopLossSignal()))
+	}
+	assert.Len(t, next.signals, alertQueueSize+2)
+	assert.Len(t, h.alerts, alertQueueSize)
+	assert.Zero(t, notifier.delivered.Load())
+}
+
+func TestWebhookNotifier_Status(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "not found", status: http.StatusNotFound, wantErr: true},
+		{name: "server error", status: http.StatusBadGateway, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newWebhookServer(t, tt.status)
+			err := NewWebhookNotifier(srv.URL).NotifyStopLoss(context.Background(), StopLossAlert{Symbol: "AAPL"})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds a single webhook delivery
+const webhookTimeout = 5 * time.Second
+
+// WebhookPayload is the JSON body posted to the webhook. Text makes it a valid
+// Slack incoming webhook message; Alert carries the structured fields for
+// other receivers.
+type WebhookPayload struct {
+	Text  string        `json:"text"`
+	Alert StopLossAlert `json:"alert"`
+}
+
+// WebhookNotifier posts alerts to a webhook URL, e.g. a Slack incoming webhook
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// NotifyStopLoss implements Notifier. Any non-2xx response is an error.
+func (n *WebhookNotifier) NotifyStopLoss(ctx context.Context, alert StopLossAlert) error {
+	body, err := json.Marshal(WebhookPayload{Text: alert.Message(), Alert: alert})
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
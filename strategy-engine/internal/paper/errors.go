@@ -0,0 +1,10 @@
+package paper
+
+import "errors"
+
+var (
+	ErrInvalidSignal        = errors.New("invalid signal")
+	ErrNoPosition           = errors.New("no position to scale")
+	ErrInsufficientPosition = errors.New("insufficient position")
+	ErrInvalidFillPrice     = errors.New("fill model returned a non-positive price")
+)
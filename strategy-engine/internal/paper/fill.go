@@ -0,0 +1,43 @@
+package paper
+
+// FillModel prices simulated fills. It receives the side and the signal
+// price and returns the execution price, so paper P&L can account for
+// slippage and the bid/ask spread.
+type FillModel interface {
+	FillPrice(side Side, price float64) float64
+}
+
+// FillModelFunc adapts a function to a FillModel
+type FillModelFunc func(side Side, price float64) float64
+
+// FillPrice implements FillModel
+func (f FillModelFunc) FillPrice(side Side, price float64) float64 {
+	return f(side, price)
+}
+
+// ExactFill fills every signal at exactly its price, the default
+var ExactFill FillModel = FixedBps(0)
+
+// FixedBps fills buys above and sells below the signal price by a fixed
+// number of basis points, e.g. FixedBps(5) buys 100 at 100.05
+type FixedBps float64
+
+// FillPrice implements FillModel
+func (b FixedBps) FillPrice(side Side, price float64) float64 {
+	slippage := price * float64(b) / 10000
+	if side == SideBuy {
+		return price + slippage
+	}
+	return price - slippage
+}
+
+// Option configures a paper trading handler
+type Option func(*Handler)
+
+// WithFillModel prices fills with the given model instead of at the signal
+// price
+func WithFillModel(model FillModel) Option {
+	return func(h *Handler) {
+		h.fillModel = model
+	}
+}
@@ -0,0 +1,80 @@
+package paper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBps_FillPrice(t *testing.T) {
+	assert.InDelta(t, 100.05, FixedBps(5).FillPrice(SideBuy, 100), 1e-9)
+	assert.InDelta(t, 99.95, FixedBps(5).FillPrice(SideSell, 100), 1e-9)
+	assert.Equal(t, 100.0, ExactFill.FillPrice(SideBuy, 100))
+	assert.Equal(t, 100.0, ExactFill.FillPrice(SideSell, 100))
+}
+
+// tradeRoundTrip buys, scales out and closes AAPL, returning the handler
+func tradeRoundTrip(t *testing.T, opts ...Option) *Handler {
+	t.Helper()
+	h := NewHandler(opts...)
+	signals := []*strategy.Signal{
+		signal(strategy.SignalActionBuy, 10, 100),
+		signal(strategy.SignalActionScaleOut, 0.5, 110),
+		signal(strategy.SignalActionCloseAll, 0, 120),
+	}
+	for _, s := range signals {
+		require.NoError(t, h.HandleSignal(context.Background(), s))
+	}
+	return h
+}
+
+func TestHandler_FillModelSlippage(t *testing.T) {
+	exact := tradeRoundTrip(t)
+	slipped := tradeRoundTrip(t, WithFillModel(FixedBps(10)))
+
+	// 5 sold 10 above and 5 sold 20 above the entry
+	assert.InDelta(t, 150, exact.RealizedPnL(), 1e-9)
+
+	// Bought at 100.10, sold 5 at 109.89 and 5 at 119.88
+	assert.InDelta(t, 5*(109.89-100.10)+5*(119.88-100.10), slipped.RealizedPnL(), 1e-9)
+	assert.Less(t, slipped.RealizedPnL(), exact.RealizedPnL())
+
+	fills := slipped.Fills()
+	require.Len(t, fills, 3)
+	assert.InDelta(t, 100.10, fills[0].Price, 1e-9)
+	assert.Equal(t, 100.0, fills[0].SignalPrice)
+	assert.InDelta(t, 109.89, fills[1].Price, 1e-9)
+	assert.Equal(t, 110.0, fills[1].SignalPrice)
+}
+
+func TestHandler_FillModelFunc(t *testing.T) {
+	// A fixed half-spread of 5 cents
+	spread := FillModelFunc(func(side Side, price float64) float64 {
+		if side == SideBuy {
+			return price + 0.05
+		}
+		return price - 0.05
+	})
+	h := NewHandler(WithFillModel(spread))
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionBuy, 10, 100)))
+
+	pos, ok := h.Position("AAPL")
+	require.True(t, ok)
+	assert.InDelta(t, 100.05, pos.AveragePrice, 1e-9)
+
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionSell, 10, 100)))
+	assert.InDelta(t, -1, h.RealizedPnL(), 1e-9)
+}
+
+func TestHandler_FillModelNonPositivePrice(t *testing.T) {
+	h := NewHandler(WithFillModel(FixedBps(10000)))
+	h.SetPosition("AAPL", 10, 100)
+
+	// Selling at the full price below leaves nothing, the fill is rejected
+	err := h.HandleSignal(context.Background(), signal(strategy.SignalActionSell, 5, 100))
+	assert.ErrorIs(t, err, ErrInvalidFillPrice)
+	assert.Empty(t, h.Fills())
+}
@@ -0,0 +1,245 @@
+package paper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// Side is the direction of a simulated fill
+type Side string
+
+const (
+	SideBuy  Side = "buy"
+	SideSell Side = "sell"
+)
+
+// Position is a simulated long position
+type Position struct {
+	Symbol       string
+	Quantity     float64
+	AveragePrice float64
+}
+
+// Fill is a simulated execution of a signal
+type Fill struct {
+	Symbol      string
+	Action      strategy.SignalAction
+	Side        Side
+	Quantity    float64
+	Price       float64 // Execution price from the fill model
+	SignalPrice float64
+	RealizedPnL float64 // Set on sells, against the average price
+	Time        time.Time
+}
+
+// Handler implements strategy.SignalHandler by filling signals against an
+// in-memory long-only portfolio, without placing orders. Fills are priced by
+// the fill model, at the signal price by default.
+type Handler struct {
+	mu          sync.Mutex
+	positions   map[string]*Position
+	fills       []Fill
+	realizedPnL float64
+	fillModel   FillModel
+}
+
+// NewHandler creates a paper trading handler with an empty portfolio
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{
+		positions: make(map[string]*Position),
+		fillModel: ExactFill,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// SetPosition seeds the portfolio with an existing position, replacing any
+// position in the symbol
+func (h *Handler) SetPosition(symbol string, quantity, averagePrice float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if quantity <= 0 {
+		delete(h.positions, symbol)
+		return
+	}
+	h.positions[symbol] = &Position{Symbol: symbol, Quantity: quantity, AveragePrice: averagePrice}
+}
+
+// HandleSignal implements strategy.SignalHandler. BUY and SELL trade the
+// signal quantity, SCALE_IN and SCALE_OUT a fraction of the existing
+// position and CLOSE_ALL the whole position. HOLD and unknown actions do
+// not trade; unknown actions are logged.
+func (h *Handler) HandleSignal(ctx context.Context, signal *strategy.Signal) error {
+	if !signal.Action.Known() {
+		log.Printf("Paper trading ignoring signal with unknown action %q for %s", signal.Action, signal.Symbol)
+		return nil
+	}
+	if signal.Action == strategy.SignalActionHold {
+		return nil
+	}
+	if signal.Price <= 0 {
+		return fmt.Errorf("%w: non-positive price %v for %s", ErrInvalidSignal, signal.Price, signal.Symbol)
+	}
+
+	side := SideSell
+	if signal.Action == strategy.SignalActionBuy || signal.Action == strategy.SignalActionScaleIn {
+		side = SideBuy
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	price := h.fillModel.FillPrice(side, signal.Price)
+	if price <= 0 {
+		return fmt.Errorf("%w: %v for %s at %v", ErrInvalidFillPrice, price, signal.Symbol, signal.Price)
+	}
+
+	pos := h.positions[signal.Symbol]
+	held := 0.0
+	if pos != nil {
+		held = pos.Quantity
+	}
+
+	switch signal.Action {
+	case strategy.SignalActionBuy:
+		if signal.Quantity <= 0 {
+			return fmt.Errorf("%w: non-positive quantity %v for %s", ErrInvalidSignal, signal.Quantity, signal.Symbol)
+		}
+		h.buy(signal, signal.Quantity, price)
+
+	case strategy.SignalActionSell:
+		if signal.Quantity <= 0 {
+			return fmt.Errorf("%w: non-positive quantity %v for %s", ErrInvalidSignal, signal.Quantity, signal.Symbol)
+		}
+		if signal.Quantity > held {
+			return fmt.Errorf("%w: cannot sell %v %s, holding %v", ErrInsufficientPosition, signal.Quantity, signal.Symbol, held)
+		}
+		h.sell(signal, signal.Quantity, price)
+
+	case strategy.SignalActionScaleIn:
+		if held == 0 {
+			return fmt.Errorf("%w: %s", ErrNoPosition, signal.Symbol)
+		}
+		if signal.Quantity <= 0 {
+			return fmt.Errorf("%w: scale in fraction must be positive, got %v", ErrInvalidSignal, signal.Quantity)
+		}
+		h.buy(signal, held*signal.Quantity, price)
+
+	case strategy.SignalActionScaleOut:
+		if held == 0 {
+			return fmt.Errorf("%w: %s", ErrNoPosition, signal.Symbol)
+		}
+		if signal.Quantity <= 0 || signal.Quantity > 1 {
+			return fmt.Errorf("%w: scale out fraction must be in (0, 1], got %v", ErrInvalidSignal, signal.Quantity)
+		}
+		h.sell(signal, held*signal.Quantity, price)
+
+	case strategy.SignalActionCloseAll:
+		// Closing a flat position is a no-op, not an error
+		if held > 0 {
+			h.sell(signal, held, price)
+		}
+	}
+
+	return nil
+}
+
+// buy adds to the position in the signal's symbol at the fill price. The
+// caller must hold the lock.
+func (h *Handler) buy(signal *strategy.Signal, quantity, price float64) {
+	pos, exists := h.positions[signal.Symbol]
+	if !exists {
+		pos = &Position{Symbol: signal.Symbol}
+		h.positions[signal.Symbol] = pos
+	}
+
+	cost := pos.AveragePrice*pos.Quantity + price*quantity
+	pos.Quantity += quantity
+	pos.AveragePrice = cost / pos.Quantity
+
+	h.record(signal, SideBuy, quantity, price, 0)
+}
+
+// sell reduces the position in the signal's symbol at the fill price,
+// removing it once flat. The caller must hold the lock.
+func (h *Handler) sell(signal *strategy.Signal, quantity, price float64) {
+	pos := h.positions[signal.Symbol]
+	realized := (price - pos.AveragePrice) * quantity
+	h.realizedPnL += realized
+
+	pos.Quantity -= quantity
+	if pos.Quantity <= 0 {
+		delete(h.positions, signal.Symbol)
+	}
+
+	h.record(signal, SideSell, quantity, price, realized)
+}
+
+// record appends a fill for the signal. The caller must hold the lock.
+func (h *Handler) record(signal *strategy.Signal, side Side, quantity, price, realizedPnL float64) {
+	filledAt := signal.GeneratedAt
+	if filledAt.IsZero() {
+		filledAt = time.Now()
+	}
+
+	h.fills = append(h.fills, Fill{
+		Symbol:      signal.Symbol,
+		Action:      signal.Action,
+		Side:        side,
+		Quantity:    quantity,
+		Price:       price,
+		SignalPrice: signal.Price,
+		RealizedPnL: realizedPnL,
+		Time:        filledAt,
+	})
+}
+
+// Position returns the simulated position in a symbol
+func (h *Handler) Position(symbol string) (Position, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pos, exists := h.positions[symbol]
+	if !exists {
+		return Position{}, false
+	}
+	return *pos, true
+}
+
+// Positions returns all open simulated positions sorted by symbol
+func (h *Handler) Positions() []Position {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	positions := make([]Position, 0, len(h.positions))
+	for _, pos := range h.positions {
+		positions = append(positions, *pos)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Symbol < positions[j].Symbol
+	})
+	return positions
+}
+
+// Fills returns every simulated fill, oldest first
+func (h *Handler) Fills() []Fill {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Fill(nil), h.fills...)
+}
+
+// RealizedPnL returns the P&L realized by simulated sells
+func (h *Handler) RealizedPnL() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.realizedPnL
+}
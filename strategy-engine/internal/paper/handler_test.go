@@ -0,0 +1,140 @@
+package paper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signal returns a signal for AAPL at the given price
+func signal(action strategy.SignalAction, quantity, price float64) *strategy.Signal {
+	return &strategy.Signal{
+		Symbol:      "AAPL",
+		Action:      action,
+		Price:       price,
+		Quantity:    quantity,
+		GeneratedAt: time.Date(2025, 3, 3, 14, 30, 0, 0, time.UTC),
+	}
+}
+
+// handlerHolding returns a handler holding 10 AAPL at 100
+func handlerHolding() *Handler {
+	h := NewHandler()
+	h.SetPosition("AAPL", 10, 100)
+	return h
+}
+
+func TestHandler_Buy(t *testing.T) {
+	h := handlerHolding()
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionBuy, 10, 110)))
+
+	pos, ok := h.Position("AAPL")
+	require.True(t, ok)
+	assert.Equal(t, 20.0, pos.Quantity)
+	assert.InDelta(t, 105, pos.AveragePrice, 1e-9)
+
+	fills := h.Fills()
+	require.Len(t, fills, 1)
+	assert.Equal(t, SideBuy, fills[0].Side)
+	assert.Equal(t, strategy.SignalActionBuy, fills[0].Action)
+}
+
+func TestHandler_Sell(t *testing.T) {
+	h := handlerHolding()
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionSell, 4, 120)))
+
+	pos, ok := h.Position("AAPL")
+	require.True(t, ok)
+	assert.Equal(t, 6.0, pos.Quantity)
+	assert.Equal(t, 100.0, pos.AveragePrice)
+	assert.InDelta(t, 80, h.RealizedPnL(), 1e-9)
+
+	// Paper trading is long only
+	err := h.HandleSignal(context.Background(), signal(strategy.SignalActionSell, 7, 120))
+	assert.ErrorIs(t, err, ErrInsufficientPosition)
+}
+
+func TestHandler_Hold(t *testing.T) {
+	h := handlerHolding()
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionHold, 5, 120)))
+
+	pos, _ := h.Position("AAPL")
+	assert.Equal(t, 10.0, pos.Quantity)
+	assert.Empty(t, h.Fills())
+}
+
+func TestHandler_ScaleIn(t *testing.T) {
+	h := handlerHolding()
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionScaleIn, 0.5, 130)))
+
+	pos, ok := h.Position("AAPL")
+	require.True(t, ok)
+	assert.InDelta(t, 15, pos.Quantity, 1e-9)
+	assert.InDelta(t, 110, pos.AveragePrice, 1e-9)
+
+	fills := h.Fills()
+	require.Len(t, fills, 1)
+	assert.InDelta(t, 5, fills[0].Quantity, 1e-9)
+
+	// There is nothing to scale into without a position
+	err := NewHandler().HandleSignal(context.Background(), signal(strategy.SignalActionScaleIn, 0.5, 130))
+	assert.ErrorIs(t, err, ErrNoPosition)
+}
+
+func TestHandler_ScaleOut(t *testing.T) {
+	h := handlerHolding()
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionScaleOut, 0.25, 120)))
+
+	pos, ok := h.Position("AAPL")
+	require.True(t, ok)
+	assert.InDelta(t, 7.5, pos.Quantity, 1e-9)
+	assert.InDelta(t, 50, h.RealizedPnL(), 1e-9)
+
+	tests := []struct {
+		name     string
+		handler  *Handler
+		fraction float64
+		expected error
+	}{
+		{name: "fraction above one", handler: handlerHolding(), fraction: 1.5, expected: ErrInvalidSignal},
+		{name: "zero fraction", handler: handlerHolding(), fraction: 0, expected: ErrInvalidSignal},
+		{name: "no position", handler: NewHandler(), fraction: 0.5, expected: ErrNoPosition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.HandleSignal(context.Background(), signal(strategy.SignalActionScaleOut, tt.fraction, 120))
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Empty(t, tt.handler.Fills())
+		})
+	}
+}
+
+func TestHandler_CloseAll(t *testing.T) {
+	h := handlerHolding()
+	h.SetPosition("MSFT", 2, 400)
+
+	// Quantity is ignored, the whole position is sold
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionCloseAll, 1, 90)))
+
+	_, ok := h.Position("AAPL")
+	assert.False(t, ok)
+	assert.InDelta(t, -100, h.RealizedPnL(), 1e-9)
+	assert.Equal(t, []Position{{Symbol: "MSFT", Quantity: 2, AveragePrice: 400}}, h.Positions())
+
+	// Closing again is a no-op
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalActionCloseAll, 0, 90)))
+	assert.Len(t, h.Fills(), 1)
+}
+
+func TestHandler_UnknownActionIgnored(t *testing.T) {
+	h := handlerHolding()
+	require.NoError(t, h.HandleSignal(context.Background(), signal(strategy.SignalAction("SHORT"), 10, 120)))
+
+	pos, _ := h.Position("AAPL")
+	assert.Equal(t, 10.0, pos.Quantity)
+	assert.Empty(t, h.Fills())
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createSignalsTable = `
+CREATE TABLE IF NOT EXISTS signals (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	strategy_name TEXT    NOT NULL,
+	symbol        TEXT    NOT NULL,
+	action        TEXT    NOT NULL,
+	price         REAL    NOT NULL,
+	quantity      REAL    NOT NULL,
+	confidence    REAL    NOT NULL,
+	metadata      TEXT    NOT NULL,
+	generated_at  INTEGER NOT NULL,
+	expires_at    INTEGER NOT NULL,
+	recorded_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_signals_strategy_symbol ON signals (strategy_name, symbol, generated_at);
+`
+
+// SQLiteStore implements SignalStore on top of a SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path and ensures the schema exists
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open signal store: %w", err)
+	}
+
+	// SQLite only supports a single writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(createSignalsTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create signal store schema: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// SaveSignal implements SignalStore
+func (s *SQLiteStore) SaveSignal(ctx context.Context, strategyName string, signal *strategy.Signal) error {
+	metadata, err := json.Marshal(signal.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signal metadata: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO signals (strategy_name, symbol, action, price, quantity, confidence, metadata, generated_at, expires_at, recorded_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		strategyName,
+		signal.Symbol,
+		string(signal.Action),
+		signal.Price,
+		signal.Quantity,
+		signal.Confidence,
+		string(metadata),
+		toUnixNano(signal.GeneratedAt),
+		toUnixNano(signal.ExpiresAt),
+		time.Now().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save signal: %w", err)
+	}
+
+	return nil
+}
+
+// ListSignals implements SignalStore
+func (s *SQLiteStore) ListSignals(ctx context.Context, filter SignalFilter) ([]SignalRecord, error) {
+	query := `SELECT id, strategy_name, symbol, action, price, quantity, confidence, metadata, generated_at, expires_at, recorded_at FROM signals`
+
+	var conditions []string
+	var args []interface{}
+	if filter.StrategyName != "" {
+		conditions = append(conditions, "strategy_name = ?")
+		args = append(args, filter.StrategyName)
+	}
+	if filter.Symbol != "" {
+		conditions = append(conditions, "symbol = ?")
+		args = append(args, filter.Symbol)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "generated_at >= ?")
+		args = append(args, filter.Since.UnixNano())
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signals: %w", err)
+	}
+	defer rows.Close()
+
+	var records []SignalRecord
+	for rows.Next() {
+		var record SignalRecord
+		var action, metadata string
+		var generatedAt, expiresAt, recordedAt int64
+		if err := rows.Scan(
+			&record.ID,
+			&record.StrategyName,
+			&record.Signal.Symbol,
+			&action,
+			&record.Signal.Price,
+			&record.Signal.Quantity,
+			&record.Signal.Confidence,
+			&metadata,
+			&generatedAt,
+			&expiresAt,
+			&recordedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan signal: %w", err)
+		}
+
+		record.Signal.Action = strategy.SignalAction(action)
+		if err := json.Unmarshal([]byte(metadata), &record.Signal.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata for signal %d: %w", record.ID, err)
+		}
+		record.Signal.GeneratedAt = fromUnixNano(generatedAt)
+		record.Signal.ExpiresAt = fromUnixNano(expiresAt)
+		record.RecordedAt = fromUnixNano(recordedAt)
+
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate signals: %w", err)
+	}
+
+	return records, nil
+}
+
+// Close implements SignalStore
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// toUnixNano stores zero times as 0 so they round-trip as time.Time{}
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStore_SaveAndList(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "signals.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	sell := &strategy.Signal{
+		Symbol:      "BTC-USD",
+		Action:      strategy.SignalActionSell,
+		Price:       48000.0,
+		Quantity:    1.5,
+		Confidence:  1.0,
+		GeneratedAt: now,
+		ExpiresAt:   now.Add(time.Minute),
+		Metadata: map[string]interface{}{
+			"reason":           "stop_loss",
+			"current_drawdown": 5.88,
+			"tags":             []interface{}{"risk", "auto"},
+		},
+	}
+	buy := &strategy.Signal{
+		Symbol:      "ETH-USD",
+		Action:      strategy.SignalActionBuy,
+		Price:       3000.0,
+		Quantity:    2.0,
+		GeneratedAt: now.Add(time.Second),
+	}
+
+	require.NoError(t, s.SaveSignal(ctx, "stop_loss_strategy", sell))
+	require.NoError(t, s.SaveSignal(ctx, "momentum", buy))
+
+	records, err := s.ListSignals(ctx, SignalFilter{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	got := records[0]
+	assert.Equal(t, "stop_loss_strategy", got.StrategyName)
+	assert.Equal(t, sell.Symbol, got.Signal.Symbol)
+	assert.Equal(t, sell.Action, got.Signal.Action)
+	assert.Equal(t, sell.Price, got.Signal.Price)
+	assert.Equal(t, sell.Quantity, got.Signal.Quantity)
+	assert.Equal(t, sell.Confidence, got.Signal.Confidence)
+	assert.True(t, sell.GeneratedAt.Equal(got.Signal.GeneratedAt))
+	assert.True(t, sell.ExpiresAt.Equal(got.Signal.ExpiresAt))
+	assert.Equal(t, sell.Metadata, got.Signal.Metadata)
+	assert.False(t, got.RecordedAt.IsZero())
+
+	// Signals without an expiry or metadata round-trip as zero values
+	assert.True(t, records[1].Signal.ExpiresAt.IsZero())
+	assert.Nil(t, records[1].Signal.Metadata)
+}
+
+func TestSQLiteStore_ListFilter(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "signals.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	for i, symbol := range []string{"BTC-USD", "ETH-USD", "BTC-USD"} {
+		require.NoError(t, s.SaveSignal(ctx, "stop_loss_strategy", &strategy.Signal{
+			Symbol:      symbol,
+			Action:      strategy.SignalActionSell,
+			GeneratedAt: now.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	tests := []struct {
+		name          string
+		filter        SignalFilter
+		expectedCount int
+	}{
+		{name: "by strategy", filter: SignalFilter{StrategyName: "stop_loss_strategy"}, expectedCount: 3},
+		{name: "unknown strategy", filter: SignalFilter{StrategyName: "momentum"}, expectedCount: 0},
+		{name: "by symbol", filter: SignalFilter{Symbol: "BTC-USD"}, expectedCount: 2},
+		{name: "since", filter: SignalFilter{Since: now.Add(time.Minute)}, expectedCount: 2},
+		{name: "limit", filter: SignalFilter{Limit: 1}, expectedCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.ListSignals(ctx, tt.filter)
+			assert.NoError(t, err)
+			assert.Len(t, records, tt.expectedCount)
+		})
+	}
+}
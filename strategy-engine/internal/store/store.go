@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// SignalRecord is a persisted signal along with the strategy that generated it
+type SignalRecord struct {
+	ID           int64
+	StrategyName string
+	Signal       strategy.Signal
+	RecordedAt   time.Time
+}
+
+// SignalFilter narrows the signals returned by ListSignals. Zero values match everything.
+type SignalFilter struct {
+	StrategyName string
+	Symbol       string
+	Since        time.Time
+	Limit        int
+}
+
+// SignalStore defines the interface for durable signal storage
+type SignalStore interface {
+	// SaveSignal records a signal generated by the named strategy
+	SaveSignal(ctx context.Context, strategyName string, signal *strategy.Signal) error
+
+	// ListSignals returns recorded signals matching the filter, oldest first
+	ListSignals(ctx context.Context, filter SignalFilter) ([]SignalRecord, error)
+
+	// Close releases any resources held by the store
+	Close() error
+}
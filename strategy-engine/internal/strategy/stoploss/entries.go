@@ -0,0 +1,113 @@
+package stoploss
+
+import (
+	"fmt"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// EntryReason is the "reason" metadata of the buy signals emitted when an
+// entry condition triggers
+const EntryReason = "entry"
+
+// EntryTarget is a symbol to buy at market once its price crosses above
+// Price while no position in it is held
+type EntryTarget struct {
+	Symbol   string
+	Price    float64
+	Quantity float64
+}
+
+// parseEntries reads the optional enable_entries and entries parameters.
+// Entries are required when enabled and ignored otherwise.
+func parseEntries(params map[string]interface{}) (bool, map[string]EntryTarget, error) {
+	var enabled bool
+	if raw, exists := params["enable_entries"]; exists {
+		var ok bool
+		if enabled, ok = raw.(bool); !ok {
+			return false, nil, fmt.Errorf("enable_entries must be a bool")
+		}
+	}
+	if !enabled {
+		return false, nil, nil
+	}
+
+	entries, ok := params["entries"].([]interface{})
+	if !ok || len(entries) == 0 {
+		return false, nil, fmt.Errorf("entries must be a non-empty list when enable_entries is set")
+	}
+	targets := make(map[string]EntryTarget, len(entries))
+	for i, rawEntry := range entries {
+		entry, ok := rawEntry.(map[string]interface{})
+		if !ok {
+			return false, nil, fmt.Errorf("entries[%d] must be an object", i)
+		}
+
+		symbol, ok := entry["symbol"].(string)
+		if !ok || symbol == "" {
+			return false, nil, fmt.Errorf("entries[%d].symbol must be a non-empty string", i)
+		}
+		if _, duplicate := targets[symbol]; duplicate {
+			return false, nil, fmt.Errorf("entries[%d]: duplicate symbol %s", i, symbol)
+		}
+		price, ok := entry["price"].(float64)
+		if !ok || price <= 0 {
+			return false, nil, fmt.Errorf("entries[%d].price must be a positive float64", i)
+		}
+		quantity, ok := entry["quantity"].(float64)
+		if !ok || quantity <= 0 {
+			return false, nil, fmt.Errorf("entries[%d].quantity must be a positive float64", i)
+		}
+
+		targets[symbol] = EntryTarget{Symbol: symbol, Price: price, Quantity: quantity}
+	}
+	return true, targets, nil
+}
+
+// checkEntry returns a buy signal when the price of an entry target crosses
+// above its entry price while no position in it is held, and tracks the
+// bought quantity so the stop loss protects it from then on. After the stop
+// loss sells, the price has to cross again before the next entry. Must be
+// called with s.mu held.
+func (s *StopLossStrategy) checkEntry(data strategy.MarketData) *strategy.Signal {
+	if !s.entriesEnabled {
+		return nil
+	}
+	target, ok := s.entries[data.Symbol]
+	if !ok {
+		return nil
+	}
+
+	last, seen := s.lastPrices[data.Symbol]
+	s.lastPrices[data.Symbol] = data.Price
+	if pos, held := s.positions[data.Symbol]; held && pos.Quantity > 0 {
+		return nil
+	}
+	// A crossing needs a price below the entry price first, so a restart
+	// above it does not buy
+	if !seen || last >= target.Price || data.Price < target.Price {
+		return nil
+	}
+
+	generatedAt := s.signalTime(data)
+	s.positions[data.Symbol] = Position{
+		EntryPrice:     data.Price,
+		HighestPrice:   data.Price,
+		Quantity:       target.Quantity,
+		LastUpdateTime: generatedAt,
+	}
+	return &strategy.Signal{
+		Symbol:      data.Symbol,
+		Action:      strategy.SignalActionBuy,
+		Price:       data.Price,
+		Quantity:    target.Quantity,
+		Confidence:  1.0,
+		GeneratedAt: generatedAt,
+		ExpiresAt:   generatedAt.Add(s.signalTTL),
+		Metadata: map[string]interface{}{
+			"reason":      EntryReason,
+			"order_type":  "market",
+			"entry_price": target.Price,
+		},
+	}
+}
@@ -0,0 +1,149 @@
+package stoploss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entryParams returns parameters buying 5 AAPL when it crosses above 200
+func entryParams() map[string]interface{} {
+	return map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"enable_entries":       true,
+		"entries": []interface{}{
+			map[string]interface{}{"symbol": "AAPL", "price": 200.0, "quantity": 5.0},
+		},
+	}
+}
+
+func TestStopLossStrategy_EntryWithoutPosition(t *testing.T) {
+	s, err := NewStopLossStrategy(entryParams())
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+
+	process := func(symbol string, price float64) *strategy.Signal {
+		signal, err := s.ProcessData(ctx, strategy.MarketData{Symbol: symbol, Price: price, Volume: 1, Timestamp: now})
+		require.NoError(t, err)
+		return signal
+	}
+
+	// Below the entry price nothing happens
+	assert.Nil(t, process("AAPL", 195))
+	assert.Nil(t, process("AAPL", 199))
+
+	// Crossing above it buys at market
+	signal := process("AAPL", 201)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionBuy, signal.Action)
+	assert.Equal(t, 201.0, signal.Price)
+	assert.Equal(t, 5.0, signal.Quantity)
+	assert.Equal(t, EntryReason, signal.Metadata["reason"])
+	assert.Equal(t, "market", signal.Metadata["order_type"])
+	assert.Equal(t, now.Add(DefaultSignalTTL), signal.ExpiresAt)
+
+	// The bought position is held, so crossing again does not buy twice
+	assert.Nil(t, process("AAPL", 199.5))
+	assert.Nil(t, process("AAPL", 202))
+
+	// The stop loss protects it and, once sold, the next crossing buys again
+	signal = process("AAPL", 190)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionSell, signal.Action)
+	assert.Equal(t, 5.0, signal.Quantity)
+	assert.Nil(t, process("AAPL", 195))
+	signal = process("AAPL", 200)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionBuy, signal.Action)
+
+	// Symbols without an entry target are never bought
+	assert.Nil(t, process("MSFT", 100))
+	assert.Nil(t, process("MSFT", 500))
+}
+
+func TestStopLossStrategy_EntriesDisabledByDefault(t *testing.T) {
+	params := entryParams()
+	delete(params, "enable_entries")
+	s, err := NewStopLossStrategy(params)
+	require.NoError(t, err)
+	assert.Equal(t, false, s.Parameters()["enable_entries"])
+
+	for _, price := range []float64{195, 201} {
+		signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: "AAPL", Price: price, Volume: 1, Timestamp: time.Now()})
+		require.NoError(t, err)
+		assert.Nil(t, signal)
+	}
+}
+
+func TestStopLossStrategy_NoEntryWhenHeld(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"positions":[
+			{"symbol":"AAPL","quantity":3,"average_price":180,"cost_basis":540},
+			{"symbol":"MSFT","quantity":10,"average_price":410,"cost_basis":4100}
+		]}`)
+	}))
+	defer srv.Close()
+
+	params := entryParams()
+	params["position_service_url"] = srv.URL
+	s, err := NewStopLossStrategy(params)
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+
+	require.Contains(t, s.positions, "AAPL")
+	assert.NotContains(t, s.positions, "MSFT", "equities are only tracked as entry targets")
+
+	for _, price := range []float64{195, 201} {
+		signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: "AAPL", Price: price, Volume: 1, Timestamp: time.Now()})
+		require.NoError(t, err)
+		assert.Nil(t, signal)
+	}
+}
+
+func TestNewStopLossStrategy_InvalidEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		enable  interface{}
+		entries interface{}
+	}{
+		{name: "enable not a bool", enable: "yes", entries: []interface{}{}},
+		{name: "missing entries", enable: true},
+		{name: "empty entries", enable: true, entries: []interface{}{}},
+		{name: "entry not an object", enable: true, entries: []interface{}{"AAPL"}},
+		{name: "missing symbol", enable: true, entries: []interface{}{
+			map[string]interface{}{"price": 200.0, "quantity": 5.0},
+		}},
+		{name: "non-positive price", enable: true, entries: []interface{}{
+			map[string]interface{}{"symbol": "AAPL", "price": 0.0, "quantity": 5.0},
+		}},
+		{name: "non-positive quantity", enable: true, entries: []interface{}{
+			map[string]interface{}{"symbol": "AAPL", "price": 200.0, "quantity": -1.0},
+		}},
+		{name: "duplicate symbol", enable: true, entries: []interface{}{
+			map[string]interface{}{"symbol": "AAPL", "price": 200.0, "quantity": 5.0},
+			map[string]interface{}{"symbol": "AAPL", "price": 210.0, "quantity": 1.0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"max_drawdown_percent": 5.0,
+				"enable_entries":       tt.enable,
+			}
+			if tt.entries != nil {
+				params["entries"] = tt.entries
+			}
+			_, err := NewStopLossStrategy(params)
+			assert.Error(t, err)
+		})
+	}
+}
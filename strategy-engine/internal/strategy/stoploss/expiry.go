@@ -0,0 +1,98 @@
+package stoploss
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+)
+
+// ExpiryCloseReason is the "reason" metadata of the signals emitted when an
+// option is closed ahead of its expiration
+const ExpiryCloseReason = "expiry_close"
+
+// expirationCloseHour is when options stop trading on their expiration
+// date, 4:00 PM Eastern
+const expirationCloseHour = 16
+
+// expirationLocation is the timezone of option expirations, a fixed EST
+// offset when timezone data is unavailable
+var expirationLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.FixedZone("EST", -5*60*60)
+	}
+	return loc
+}()
+
+// ParseExpiration returns when an option expiring on date, formatted
+// YYYY-MM-DD, stops trading
+func ParseExpiration(date string) (time.Time, error) {
+	day, err := time.ParseInLocation(time.DateOnly, date, expirationLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration date %q, expected YYYY-MM-DD", date)
+	}
+	// The wall clock hour, midnight plus 16 hours is off by one on DST days
+	year, month, dayOfMonth := day.Date()
+	return time.Date(year, month, dayOfMonth, expirationCloseHour, 0, 0, 0, expirationLocation), nil
+}
+
+// parseCloseBeforeExpiry reads the optional close_before_expiry_minutes
+// parameter, returning def when it is not set. Zero disables expiry closes.
+func parseCloseBeforeExpiry(params map[string]interface{}, def time.Duration) (time.Duration, error) {
+	raw, exists := params["close_before_expiry_minutes"]
+	if !exists {
+		return def, nil
+	}
+
+	minutes, ok := raw.(float64)
+	if !ok {
+		return 0, fmt.Errorf("close_before_expiry_minutes must be a float64")
+	}
+	if minutes < 0 {
+		return 0, fmt.Errorf("close_before_expiry_minutes must not be negative")
+	}
+	return time.Duration(minutes * float64(time.Minute)), nil
+}
+
+// checkExpiry returns a signal selling a held option once the data is
+// within close_before_expiry_minutes of its expiration, whatever its
+// drawdown. The position stops being tracked once the signal is emitted.
+// The caller must hold s.mu.
+func (s *StopLossStrategy) checkExpiry(data strategy.MarketData, pos Position) (*strategy.Signal, error) {
+	if s.closeBeforeExpiry <= 0 || pos.Expiration.IsZero() || pos.Quantity <= 0 {
+		return nil, nil
+	}
+	generatedAt := s.signalTime(data)
+	remaining := pos.Expiration.Sub(generatedAt)
+	if remaining > s.closeBeforeExpiry {
+		return nil, nil
+	}
+
+	quantity, err := SignalQuantity(pos.Quantity, pos.Option, s.quantityRounding)
+	if err != nil {
+		return nil, fmt.Errorf("expiry close for %s: %w", data.Symbol, err)
+	}
+	if quantity == 0 {
+		// Less than a whole contract is held, nothing can be sold
+		return nil, nil
+	}
+
+	s.closePosition(data.Symbol)
+	return &strategy.Signal{
+		Symbol:      data.Symbol,
+		Action:      strategy.SignalActionSell,
+		Price:       data.Price,
+		Quantity:    quantity,
+		Confidence:  1.0,
+		GeneratedAt: generatedAt,
+		ExpiresAt:   generatedAt.Add(s.signalTTL),
+		RiskExit:    true,
+		Metadata: map[string]interface{}{
+			"reason":            ExpiryCloseReason,
+			"entry_price":       pos.EntryPrice,
+			"expiration":        pos.Expiration,
+			"minutes_to_expiry": remaining.Minutes(),
+		},
+	}, nil
+}
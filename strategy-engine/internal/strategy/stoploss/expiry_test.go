@@ -0,0 +1,137 @@
+package stoploss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiringCall is the OCC symbol of a call expiring on 2025-06-20
+const expiringCall = "AAPL  250620C00200000"
+
+// expiryParams returns parameters tracking 2 contracts of expiringCall,
+// closed 30 minutes before expiry
+func expiryParams() map[string]interface{} {
+	return map[string]interface{}{
+		"max_drawdown_percent":        50.0,
+		"close_before_expiry_minutes": 30.0,
+		"positions": []interface{}{
+			map[string]interface{}{"symbol": expiringCall, "entry_price": 4.0, "quantity": 2.0, "option": true, "expiration_date": "2025-06-20"},
+		},
+	}
+}
+
+func TestParseExpiration(t *testing.T) {
+	expiration, err := ParseExpiration("2025-06-20")
+	require.NoError(t, err)
+	// 4:00 PM EDT
+	assert.True(t, expiration.Equal(time.Date(2025, 6, 20, 20, 0, 0, 0, time.UTC)), "got %s", expiration)
+
+	_, err = ParseExpiration("06/20/2025")
+	assert.EqualError(t, err, `invalid expiration date "06/20/2025", expected YYYY-MM-DD`)
+}
+
+func TestParseExpiration_DSTChange(t *testing.T) {
+	// The clocks change at 2:00 AM on these days, the close is still 4:00 PM
+	for _, date := range []string{"2025-03-09", "2025-11-02"} {
+		expiration, err := ParseExpiration(date)
+		require.NoError(t, err)
+		local := expiration.In(expirationLocation)
+		assert.Equal(t, date, local.Format(time.DateOnly))
+		assert.Equal(t, expirationCloseHour, local.Hour(), "got %s", local)
+		assert.Equal(t, 0, local.Minute(), "got %s", local)
+	}
+}
+
+func TestStopLossStrategy_ClosesBeforeExpiry(t *testing.T) {
+	s, err := NewStopLossStrategy(expiryParams())
+	require.NoError(t, err)
+	ctx := context.Background()
+	expiration := time.Date(2025, 6, 20, 20, 0, 0, 0, time.UTC)
+
+	process := func(at time.Time, price float64) *strategy.Signal {
+		signal, err := s.ProcessData(ctx, strategy.MarketData{Symbol: expiringCall, Price: price, Volume: 1, Timestamp: at})
+		require.NoError(t, err)
+		return signal
+	}
+
+	// Outside the window nothing happens, even at a gain
+	assert.Nil(t, process(expiration.Add(-24*time.Hour), 4.5))
+	assert.Nil(t, process(expiration.Add(-31*time.Minute), 4.6))
+
+	// Within it the position is closed whatever its drawdown
+	at := expiration.Add(-20 * time.Minute)
+	signal := process(at, 4.4)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionSell, signal.Action)
+	assert.Equal(t, 4.4, signal.Price)
+	assert.Equal(t, 2.0, signal.Quantity)
+	assert.Equal(t, ExpiryCloseReason, signal.Metadata["reason"])
+	assert.True(t, signal.RiskExit)
+	assert.True(t, expiration.Equal(signal.Metadata["expiration"].(time.Time)))
+	assert.InDelta(t, 20.0, signal.Metadata["minutes_to_expiry"], 1e-9)
+	assert.Equal(t, at, signal.GeneratedAt)
+	assert.Equal(t, at.Add(DefaultSignalTTL), signal.ExpiresAt)
+
+	// The position is no longer tracked, so it is not sold twice
+	assert.Nil(t, process(expiration.Add(-10*time.Minute), 4.3))
+}
+
+func TestStopLossStrategy_ExpiryCloseDisabled(t *testing.T) {
+	params := expiryParams()
+	delete(params, "close_before_expiry_minutes")
+	s, err := NewStopLossStrategy(params)
+	require.NoError(t, err)
+
+	expiration := time.Date(2025, 6, 20, 20, 0, 0, 0, time.UTC)
+	signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: expiringCall, Price: 4.4, Volume: 1, Timestamp: expiration.Add(-time.Minute)})
+	require.NoError(t, err)
+	assert.Nil(t, signal)
+}
+
+func TestStopLossStrategy_ExpiryFromPositionService(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"positions":[{"symbol":"AAPL","occ_symbol":%q,"quantity":1,"average_price":4.0,"multiplier":100,"expiration_date":"2025-06-20"}]}`, expiringCall)
+	}))
+	defer srv.Close()
+
+	params := expiryParams()
+	delete(params, "positions")
+	params["position_service_url"] = srv.URL
+	s, err := NewStopLossStrategy(params)
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+
+	expiration := time.Date(2025, 6, 20, 20, 0, 0, 0, time.UTC)
+	signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: expiringCall, Price: 4.2, Volume: 1, Timestamp: expiration.Add(-15 * time.Minute)})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, ExpiryCloseReason, signal.Metadata["reason"])
+	assert.Equal(t, 1.0, signal.Quantity)
+}
+
+func TestNewStopLossStrategy_InvalidExpiry(t *testing.T) {
+	params := expiryParams()
+	params["close_before_expiry_minutes"] = -5.0
+	_, err := NewStopLossStrategy(params)
+	assert.EqualError(t, err, "close_before_expiry_minutes must not be negative")
+
+	params = expiryParams()
+	params["close_before_expiry_minutes"] = "30"
+	_, err = NewStopLossStrategy(params)
+	assert.EqualError(t, err, "close_before_expiry_minutes must be a float64")
+
+	params = expiryParams()
+	params["positions"] = []interface{}{
+		map[string]interface{}{"symbol": expiringCall, "entry_price": 4.0, "quantity": 2.0, "option": true, "expiration_date": "soon"},
+	}
+	_, err = NewStopLossStrategy(params)
+	assert.EqualError(t, err, `positions[0]: invalid expiration date "soon", expected YYYY-MM-DD`)
+}
@@ -0,0 +1,284 @@
+package stoploss
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/trade-sonic/logging"
+)
+
+// fetchTimeout bounds the initial position fetch from the position-service
+const fetchTimeout = 10 * time.Second
+
+// EntryPriceSource selects how a position's entry price is derived from the
+// broker position payload
+type EntryPriceSource string
+
+const (
+	// EntryPriceAveragePrice uses the broker reported average price
+	EntryPriceAveragePrice EntryPriceSource = "average_price"
+	// EntryPriceCostBasis uses cost_basis / (quantity * multiplier), which stays
+	// accurate for old positions whose average price was adjusted (e.g. DRIP)
+	EntryPriceCostBasis EntryPriceSource = "cost_basis"
+)
+
+// BrokerPosition is a position as served by the position-service
+type BrokerPosition struct {
+	Symbol         string  `json:"symbol"`               // Underlying symbol of options
+	OccSymbol      string  `json:"occ_symbol,omitempty"` // OCC symbol of options
+	Quantity       float64 `json:"quantity"`
+	AveragePrice   float64 `json:"average_price"`
+	CostBasis      float64 `json:"cost_basis"`
+	Multiplier     float64 `json:"multiplier,omitempty"`      // Empty for equities
+	ExpirationDate string  `json:"expiration_date,omitempty"` // YYYY-MM-DD, options only
+}
+
+// Key returns the symbol a position is tracked under: the OCC symbol of an
+// option, so contracts on the same underlying are kept apart, or the symbol
+func (p BrokerPosition) Key() string {
+	if p.OccSymbol != "" {
+		return p.OccSymbol
+	}
+	return p.Symbol
+}
+
+// parseEntryPriceSource reads the optional entry_price_source parameter,
+// returning def when it is not set
+func parseEntryPriceSource(params map[string]interface{}, def EntryPriceSource) (EntryPriceSource, error) {
+	raw, exists := params["entry_price_source"]
+	if !exists {
+		return def, nil
+	}
+
+	value, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("entry_price_source must be a string")
+	}
+
+	switch source := EntryPriceSource(value); source {
+	case EntryPriceAveragePrice, EntryPriceCostBasis:
+		return source, nil
+	default:
+		return "", fmt.Errorf("entry_price_source must be %q or %q", EntryPriceAveragePrice, EntryPriceCostBasis)
+	}
+}
+
+// EntryPrice derives the entry price of a broker position using the given source
+func EntryPrice(pos BrokerPosition, source EntryPriceSource) (float64, error) {
+	switch source {
+	case EntryPriceAveragePrice:
+		return pos.AveragePrice, nil
+	case EntryPriceCostBasis:
+		multiplier := pos.Multiplier
+		if multiplier == 0 {
+			multiplier = 1
+		}
+		if pos.Quantity == 0 {
+			return 0, fmt.Errorf("cannot derive entry price of %s from cost basis: zero quantity", pos.Key())
+		}
+		return pos.CostBasis / (pos.Quantity * multiplier), nil
+	default:
+		return 0, fmt.Errorf("unknown entry price source: %s", source)
+	}
+}
+
+// LoadPositions seeds the tracked positions from broker positions, keyed by
+// Key, deriving each entry price from the configured entry_price_source.
+// Market data for an option must carry its OCC symbol to match. Short
+// positions are not protected, their negative quantity is zeroed. A
+// position already held keeps its highest price, so a refresh does not
+// restart its drawdown. Positions sold by a stop or an expiry close are
+// skipped while the broker still reports them. With stale_position_seconds
+// set, loaded positions missing from the refresh for longer than that are
+// dropped.
+func (s *StopLossStrategy) LoadPositions(positions []BrokerPosition, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Nothing changes unless every position loads
+	tracked := copyPositions(s.positions)
+	if err := s.loadPositions(tracked, positions, now); err != nil {
+		return err
+	}
+	s.positions = tracked
+	s.lastRefresh = now
+	s.pruneStalePositions(now)
+	return nil
+}
+
+// loadPositions adds broker positions to tracked, see LoadPositions. The
+// caller must hold s.mu.
+func (s *StopLossStrategy) loadPositions(tracked map[string]Position, positions []BrokerPosition, now time.Time) error {
+	reported := make(map[string]bool, len(positions))
+	for _, pos := range positions {
+		reported[pos.Key()] = true
+		if s.closed[pos.Key()] {
+			// Sold, the sell has not filled yet
+			continue
+		}
+		entryPrice, err := EntryPrice(pos, s.entryPriceSource)
+		if err != nil {
+			return err
+		}
+		var expiration time.Time
+		if pos.ExpirationDate != "" {
+			if expiration, err = ParseExpiration(pos.ExpirationDate); err != nil {
+				return fmt.Errorf("position %s: %w", pos.Key(), err)
+			}
+		}
+
+		highestPrice := entryPrice
+		if held, exists := tracked[pos.Key()]; exists && held.Quantity > 0 {
+			highestPrice = math.Max(held.HighestPrice, entryPrice)
+		}
+		tracked[pos.Key()] = Position{
+			EntryPrice:     entryPrice,
+			HighestPrice:   highestPrice,
+			Quantity:       math.Max(pos.Quantity, 0),
+			Option:         pos.Multiplier != 0,
+			Expiration:     expiration,
+			LastUpdateTime: now,
+			LastSeenTime:   now,
+		}
+	}
+
+	// Once the broker stops reporting a sold position, a new one in the
+	// symbol is tracked again
+	for symbol := range s.closed {
+		if !reported[symbol] {
+			delete(s.closed, symbol)
+		}
+	}
+	return nil
+}
+
+// fetchTrackedPositions fetches the current Robinhood positions from the
+// position-service, keeping option positions and, with entries enabled, the
+// positions in entry targets so held targets are not bought again. The request is bound to
+// ctx and fetchTimeout, so the position-service abandons its broker calls
+// too when the fetch is cancelled. The position_service_api_key parameter
+// is sent as a bearer token. Errors name the X-Request-ID the fetch was
+// sent with, to find it in the position-service logs.
+func (s *StopLossStrategy) fetchTrackedPositions(ctx context.Context) ([]BrokerPosition, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	endpoint := s.positionServiceURL + "/positions?" + url.Values{"account_type": {"robinhood"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set(logging.RequestIDHeader, requestID)
+	if s.positionServiceKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.positionServiceKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch positions (request %s): %w", requestID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Code == "" {
+			return nil, fmt.Errorf("position-service returned status %d (request %s)", resp.StatusCode, requestID)
+		}
+		return nil, fmt.Errorf("position-service returned status %d: %s (%s, request %s)", resp.StatusCode, apiErr.Message, apiErr.Code, requestID)
+	}
+
+	var list struct {
+		Positions []BrokerPosition `json:"positions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode positions: %w", err)
+	}
+
+	tracked := make([]BrokerPosition, 0, len(list.Positions))
+	for _, pos := range list.Positions {
+		_, target := s.entries[pos.Key()]
+		if pos.Multiplier != 0 || target {
+			tracked = append(tracked, pos)
+		}
+	}
+	return tracked, nil
+}
+
+// parseStaticPositions reads the optional positions parameter, a list of
+// positions to track from the start instead of fetching them. Each entry has
+// a symbol (the OCC symbol of an option), an entry_price, a quantity and, for
+// options sold in whole contracts, option set to true and optionally their
+// expiration_date.
+func parseStaticPositions(params map[string]interface{}, now time.Time) (map[string]Position, error) {
+	positions := make(map[string]Position)
+	raw, exists := params["positions"]
+	if !exists {
+		return positions, nil
+	}
+
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("positions must be a list")
+	}
+	for i, rawEntry := range entries {
+		entry, ok := rawEntry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("positions[%d] must be an object", i)
+		}
+
+		symbol, ok := entry["symbol"].(string)
+		if !ok || symbol == "" {
+			return nil, fmt.Errorf("positions[%d].symbol must be a non-empty string", i)
+		}
+		if _, duplicate := positions[symbol]; duplicate {
+			return nil, fmt.Errorf("positions[%d]: duplicate symbol %s", i, symbol)
+		}
+		entryPrice, ok := entry["entry_price"].(float64)
+		if !ok || entryPrice <= 0 {
+			return nil, fmt.Errorf("positions[%d].entry_price must be a positive float64", i)
+		}
+		quantity, ok := entry["quantity"].(float64)
+		if !ok || quantity < 0 {
+			return nil, fmt.Errorf("positions[%d].quantity must be a non-negative float64", i)
+		}
+		var option bool
+		if rawOption, exists := entry["option"]; exists {
+			if option, ok = rawOption.(bool); !ok {
+				return nil, fmt.Errorf("positions[%d].option must be a bool", i)
+			}
+		}
+		var expiration time.Time
+		if rawExpiration, exists := entry["expiration_date"]; exists {
+			date, ok := rawExpiration.(string)
+			if !ok {
+				return nil, fmt.Errorf("positions[%d].expiration_date must be a string", i)
+			}
+			var err error
+			if expiration, err = ParseExpiration(date); err != nil {
+				return nil, fmt.Errorf("positions[%d]: %w", i, err)
+			}
+		}
+
+		positions[symbol] = Position{
+			EntryPrice:     entryPrice,
+			HighestPrice:   entryPrice,
+			Quantity:       quantity,
+			Option:         option,
+			Expiration:     expiration,
+			LastUpdateTime: now,
+		}
+	}
+	return positions, nil
+}
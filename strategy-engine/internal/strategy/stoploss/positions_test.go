@@ -0,0 +1,396 @@
+package stoploss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trade-sonic/logging"
+)
+
+func TestStopLossStrategy_LoadPositions(t *testing.T) {
+	// An option position whose average price was adjusted after opening
+	option := BrokerPosition{
+		Symbol:       "AAPL",
+		Quantity:     2,
+		AveragePrice: 3.10,
+		CostBasis:    500,
+		Multiplier:   100,
+	}
+	equity := BrokerPosition{
+		Symbol:       "MSFT",
+		Quantity:     10,
+		AveragePrice: 410,
+		CostBasis:    4000,
+	}
+
+	tests := []struct {
+		name     string
+		source   interface{}
+		expected map[string]float64
+	}{
+		{
+			name:     "defaults to average price",
+			source:   nil,
+			expected: map[string]float64{"AAPL": 3.10, "MSFT": 410},
+		},
+		{
+			name:     "average price",
+			source:   "average_price",
+			expected: map[string]float64{"AAPL": 3.10, "MSFT": 410},
+		},
+		{
+			name:     "cost basis",
+			source:   "cost_basis",
+			expected: map[string]float64{"AAPL": 2.50, "MSFT": 400},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{"max_drawdown_percent": 5.0}
+			if tt.source != nil {
+				params["entry_price_source"] = tt.source
+			}
+			s, err := NewStopLossStrategy(params)
+			require.NoError(t, err)
+
+			now := time.Now()
+			require.NoError(t, s.LoadPositions([]BrokerPosition{option, equity}, now))
+
+			for symbol, entry := range tt.expected {
+				pos := s.positions[symbol]
+				assert.InDelta(t, entry, pos.EntryPrice, 1e-9, symbol)
+				assert.Equal(t, pos.EntryPrice, pos.HighestPrice, symbol)
+				assert.Equal(t, now, pos.LastUpdateTime, symbol)
+			}
+		})
+	}
+}
+
+func TestStopLossStrategy_LoadPositionsKeysOptionsByOccSymbol(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+
+	// Two contracts on the same underlying are tracked separately
+	require.NoError(t, s.LoadPositions([]BrokerPosition{
+		{Symbol: "NVDA", OccSymbol: "NVDA  250117C00150000", Quantity: 1, AveragePrice: 4.20, Multiplier: 100},
+		{Symbol: "NVDA", OccSymbol: "NVDA  250117C00160000", Quantity: 3, AveragePrice: 1.10, Multiplier: 100},
+		{Symbol: "MSFT", Quantity: 10, AveragePrice: 410},
+	}, time.Now()))
+
+	require.Len(t, s.positions, 3)
+	assert.Equal(t, 4.20, s.positions["NVDA  250117C00150000"].EntryPrice)
+	assert.Equal(t, 3.0, s.positions["NVDA  250117C00160000"].Quantity)
+	assert.Equal(t, 410.0, s.positions["MSFT"].EntryPrice)
+	assert.NotContains(t, s.positions, "NVDA")
+}
+
+func TestEntryPrice_CostBasisZeroQuantity(t *testing.T) {
+	_, err := EntryPrice(BrokerPosition{Symbol: "AAPL", CostBasis: 500}, EntryPriceCostBasis)
+	assert.Error(t, err)
+}
+
+func TestStopLossStrategy_UpdateEntryPriceSource(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"entry_price_source":   "cost_basis",
+	}))
+	assert.Equal(t, "cost_basis", s.Parameters()["entry_price_source"])
+
+	// Omitting the source keeps the current one
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 6.0}))
+	assert.Equal(t, "cost_basis", s.Parameters()["entry_price_source"])
+}
+
+func TestStopLossStrategy_InitializeLoadsOptionPositions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/positions", r.URL.Path)
+		assert.Equal(t, "robinhood", r.URL.Query().Get("account_type"))
+		fmt.Fprint(w, `{"positions":[
+			{"symbol":"AAPL","underlying_symbol":"AAPL","occ_symbol":"AAPL  250620C00200000","quantity":2,"average_price":3.10,"cost_basis":500,"multiplier":100},
+			{"symbol":"MSFT","quantity":10,"average_price":410,"cost_basis":4000}
+		]}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"entry_price_source":   "cost_basis",
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+
+	require.Contains(t, s.positions, "AAPL  250620C00200000")
+	assert.NotContains(t, s.positions, "MSFT", "equities are not tracked")
+	assert.InDelta(t, 2.5, s.positions["AAPL  250620C00200000"].EntryPrice, 1e-9)
+	assert.Equal(t, 2.0, s.positions["AAPL  250620C00200000"].Quantity)
+}
+
+func TestStopLossStrategy_InitializeCancelled(t *testing.T) {
+	// The position-service hangs until the test finishes
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = s.Initialize(ctx)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second, "Initialize should return once ctx is cancelled")
+}
+
+func TestStopLossStrategy_InitializeServiceError(t *testing.T) {
+	requestIDs := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs <- r.Header.Get(logging.RequestIDHeader)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"code":"upstream_unavailable","message":"broker unavailable, try again later"}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+
+	err = s.Initialize(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 503: broker unavailable, try again later (upstream_unavailable")
+	assert.Empty(t, s.positions)
+
+	// The error names the request ID sent, to find the request in the
+	// position-service logs
+	requestID := <-requestIDs
+	assert.True(t, logging.ValidRequestID(requestID), "request ID %q", requestID)
+	assert.Contains(t, err.Error(), "request "+requestID)
+}
+
+func TestStopLossStrategy_FetchSendsContextRequestID(t *testing.T) {
+	requestIDs := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs <- r.Header.Get(logging.RequestIDHeader)
+		fmt.Fprint(w, `{"positions":[]}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Initialize(logging.WithRequestID(context.Background(), "engine-start")))
+	assert.Equal(t, "engine-start", <-requestIDs)
+}
+
+func TestStopLossStrategy_FetchSendsAPIKey(t *testing.T) {
+	authorizations := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations <- r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"positions":[]}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":     5.0,
+		"position_service_url":     srv.URL,
+		"position_service_api_key": "engine-key",
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+	assert.Equal(t, "Bearer engine-key", <-authorizations)
+
+	// Without a key no credentials are sent
+	s, err = NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+	assert.Empty(t, <-authorizations)
+
+	_, err = NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":     5.0,
+		"position_service_api_key": 42.0,
+	})
+	assert.EqualError(t, err, "position_service_api_key must be a string")
+}
+
+func TestStopLossStrategy_InitializeWithoutPositionService(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+	assert.Empty(t, s.positions)
+}
+
+// roundTripFunc lets a function serve as an http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestStopLossStrategy_StaticPositions(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"positions": []interface{}{
+			map[string]interface{}{"symbol": "MSFT", "entry_price": 400.0, "quantity": 10.0},
+			map[string]interface{}{"symbol": "AAPL  250620C00200000", "entry_price": 4.0, "quantity": 2.7, "option": true},
+		},
+	})
+	require.NoError(t, err)
+	s.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("Unexpected request to %s", r.URL)
+		return nil, fmt.Errorf("unexpected request")
+	})}
+	require.NoError(t, s.Initialize(context.Background()))
+
+	now := time.Now()
+	signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: "MSFT", Price: 370, Volume: 1, Timestamp: now})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, 10.0, signal.Quantity)
+	assert.Equal(t, 400.0, signal.Metadata["entry_price"])
+
+	// Options are sold in whole contracts
+	signal, err = s.ProcessData(context.Background(), strategy.MarketData{Symbol: "AAPL  250620C00200000", Price: 3.5, Volume: 1, Timestamp: now})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, 2.0, signal.Quantity)
+}
+
+func TestStopLossStrategy_StaticPositionsWithPositionService(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"positions":[
+			{"symbol":"AAPL","occ_symbol":"AAPL  250620C00200000","quantity":2,"average_price":3.10,"cost_basis":620,"multiplier":100}
+		]}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+		"positions": []interface{}{
+			map[string]interface{}{"symbol": "MSFT", "entry_price": 400.0, "quantity": 10.0},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+
+	assert.Contains(t, s.positions, "MSFT")
+	assert.Contains(t, s.positions, "AAPL  250620C00200000")
+}
+
+func TestNewStopLossStrategy_InvalidStaticPositions(t *testing.T) {
+	tests := []struct {
+		name      string
+		positions interface{}
+	}{
+		{name: "not a list", positions: "MSFT"},
+		{name: "entry not an object", positions: []interface{}{"MSFT"}},
+		{name: "missing symbol", positions: []interface{}{map[string]interface{}{"entry_price": 400.0, "quantity": 1.0}}},
+		{name: "missing entry price", positions: []interface{}{map[string]interface{}{"symbol": "MSFT", "quantity": 1.0}}},
+		{name: "zero entry price", positions: []interface{}{map[string]interface{}{"symbol": "MSFT", "entry_price": 0.0, "quantity": 1.0}}},
+		{name: "negative quantity", positions: []interface{}{map[string]interface{}{"symbol": "MSFT", "entry_price": 400.0, "quantity": -1.0}}},
+		{name: "invalid option", positions: []interface{}{map[string]interface{}{"symbol": "MSFT", "entry_price": 400.0, "quantity": 1.0, "option": "yes"}}},
+		{name: "duplicate symbol", positions: []interface{}{
+			map[string]interface{}{"symbol": "MSFT", "entry_price": 400.0, "quantity": 1.0},
+			map[string]interface{}{"symbol": "MSFT", "entry_price": 410.0, "quantity": 2.0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStopLossStrategy(map[string]interface{}{
+				"max_drawdown_percent": 5.0,
+				"positions":            tt.positions,
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestStopLossStrategy_ResetReloadsPositions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"positions":[
+			{"symbol":"AAPL","occ_symbol":"AAPL  250620C00200000","quantity":2,"average_price":3.10,"cost_basis":620,"multiplier":100}
+		]}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+
+	_, err = s.ProcessData(context.Background(), strategy.MarketData{Symbol: "AAPL  250620C00200000", Price: 9.0, Volume: 1, Timestamp: time.Now()})
+	require.NoError(t, err)
+	require.Equal(t, 9.0, s.positions["AAPL  250620C00200000"].HighestPrice)
+
+	require.NoError(t, s.Reset(context.Background()))
+	require.Len(t, s.positions, 1)
+	assert.Equal(t, 3.10, s.positions["AAPL  250620C00200000"].HighestPrice)
+	assert.Equal(t, 2.0, s.positions["AAPL  250620C00200000"].Quantity)
+}
+
+func TestStopLossStrategy_FailedResetKeepsPositions(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"positions":[
+			{"symbol":"AAPL","occ_symbol":"AAPL  250620C00200000","quantity":2,"average_price":3.10,"cost_basis":620,"multiplier":100}
+		]}`)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"position_service_url": srv.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+	_, err = s.ProcessData(context.Background(), strategy.MarketData{Symbol: "AAPL  250620C00200000", Price: 9.0, Volume: 1, Timestamp: time.Now()})
+	require.NoError(t, err)
+
+	failing.Store(true)
+	assert.Error(t, s.Reset(context.Background()))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, s.Reset(cancelled))
+
+	// The held option is still protected with its high-water mark
+	require.Contains(t, s.positions, "AAPL  250620C00200000")
+	assert.Equal(t, 2.0, s.positions["AAPL  250620C00200000"].Quantity)
+	assert.Equal(t, 9.0, s.positions["AAPL  250620C00200000"].HighestPrice)
+}
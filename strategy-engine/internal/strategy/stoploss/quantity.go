@@ -0,0 +1,63 @@
+package stoploss
+
+import (
+	"fmt"
+	"math"
+)
+
+// QuantityRounding selects how fractional option quantities are rounded to
+// whole contracts in signals
+type QuantityRounding string
+
+const (
+	// RoundFloor rounds down, never selling more contracts than are held
+	RoundFloor QuantityRounding = "floor"
+	// RoundNearest rounds to the nearest whole contract
+	RoundNearest QuantityRounding = "nearest"
+)
+
+// quantityEpsilon absorbs float error, so 1.9999999999 is 2 contracts
+const quantityEpsilon = 1e-6
+
+// parseQuantityRounding reads the optional quantity_rounding parameter,
+// returning def when it is not set
+func parseQuantityRounding(params map[string]interface{}, def QuantityRounding) (QuantityRounding, error) {
+	raw, exists := params["quantity_rounding"]
+	if !exists {
+		return def, nil
+	}
+
+	value, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("quantity_rounding must be a string")
+	}
+
+	switch rounding := QuantityRounding(value); rounding {
+	case RoundFloor, RoundNearest:
+		return rounding, nil
+	default:
+		return "", fmt.Errorf("quantity_rounding must be %q or %q", RoundFloor, RoundNearest)
+	}
+}
+
+// SignalQuantity returns the quantity a signal carries for a position.
+// Options trade in whole contracts, so their quantity is rounded; equities
+// can trade fractional shares and keep theirs. Negative quantities are
+// rejected for both.
+func SignalQuantity(quantity float64, option bool, rounding QuantityRounding) (float64, error) {
+	if quantity < 0 || math.IsNaN(quantity) || math.IsInf(quantity, 0) {
+		return 0, fmt.Errorf("invalid signal quantity %v", quantity)
+	}
+	if !option {
+		return quantity, nil
+	}
+
+	switch rounding {
+	case RoundFloor:
+		return math.Floor(quantity + quantityEpsilon), nil
+	case RoundNearest:
+		return math.Round(quantity), nil
+	default:
+		return 0, fmt.Errorf("unknown quantity rounding: %s", rounding)
+	}
+}
@@ -0,0 +1,122 @@
+package stoploss
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalQuantity(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity float64
+		option   bool
+		rounding QuantityRounding
+		expected float64
+		wantErr  bool
+	}{
+		{name: "whole contracts", quantity: 3, option: true, rounding: RoundFloor, expected: 3},
+		{name: "fractional contracts floor", quantity: 2.7, option: true, rounding: RoundFloor, expected: 2},
+		{name: "fractional contracts nearest", quantity: 2.7, option: true, rounding: RoundNearest, expected: 3},
+		{name: "float error floor", quantity: 1.9999999999, option: true, rounding: RoundFloor, expected: 2},
+		{name: "less than a contract", quantity: 0.4, option: true, rounding: RoundFloor, expected: 0},
+		{name: "fractional shares are kept", quantity: 2.7, option: false, rounding: RoundFloor, expected: 2.7},
+		{name: "negative contracts", quantity: -2, option: true, rounding: RoundFloor, wantErr: true},
+		{name: "negative shares", quantity: -0.5, option: false, rounding: RoundFloor, wantErr: true},
+		{name: "not a number", quantity: math.NaN(), option: true, rounding: RoundFloor, wantErr: true},
+		{name: "unknown rounding", quantity: 2.7, option: true, rounding: "ceil", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quantity, err := SignalQuantity(tt.quantity, tt.option, tt.rounding)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, quantity)
+		})
+	}
+}
+
+// triggerStopLoss loads a position and feeds it a 10% drop, returning the
+// resulting signal
+func triggerStopLoss(t *testing.T, params map[string]interface{}, pos BrokerPosition) *strategy.Signal {
+	t.Helper()
+	params["max_drawdown_percent"] = 5.0
+	s, err := NewStopLossStrategy(params)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, s.LoadPositions([]BrokerPosition{pos}, now))
+
+	signal, err := s.ProcessData(context.Background(), strategy.MarketData{
+		Symbol:    pos.Key(),
+		Price:     pos.AveragePrice * 0.9,
+		Volume:    1,
+		Timestamp: now.Add(time.Minute),
+	})
+	require.NoError(t, err)
+	return signal
+}
+
+func TestStopLossStrategy_SignalQuantityRounding(t *testing.T) {
+	option := BrokerPosition{Symbol: "AAPL", OccSymbol: "AAPL  250620C00200000", Quantity: 2.7, AveragePrice: 4, Multiplier: 100}
+	equity := BrokerPosition{Symbol: "MSFT", Quantity: 2.7, AveragePrice: 400}
+
+	tests := []struct {
+		name     string
+		params   map[string]interface{}
+		pos      BrokerPosition
+		expected float64
+	}{
+		{name: "option floors by default", params: map[string]interface{}{}, pos: option, expected: 2},
+		{name: "option rounds to nearest", params: map[string]interface{}{"quantity_rounding": "nearest"}, pos: option, expected: 3},
+		{name: "equity keeps fractional shares", params: map[string]interface{}{}, pos: equity, expected: 2.7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := triggerStopLoss(t, tt.params, tt.pos)
+			require.NotNil(t, signal)
+			assert.Equal(t, tt.expected, signal.Quantity)
+		})
+	}
+}
+
+func TestStopLossStrategy_NoSignalWithoutWholeQuantity(t *testing.T) {
+	// Less than a contract floors to nothing to sell
+	signal := triggerStopLoss(t, map[string]interface{}{}, BrokerPosition{
+		Symbol: "AAPL", OccSymbol: "AAPL  250620C00200000", Quantity: 0.4, AveragePrice: 4, Multiplier: 100,
+	})
+	assert.Nil(t, signal)
+
+	// Short positions are zeroed and never produce a sell
+	signal = triggerStopLoss(t, map[string]interface{}{}, BrokerPosition{
+		Symbol: "MSFT", OccSymbol: "MSFT  250417P00400000", Quantity: -2, AveragePrice: 3, Multiplier: 100,
+	})
+	assert.Nil(t, signal)
+}
+
+func TestStopLossStrategy_QuantityRoundingParameter(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+	assert.Equal(t, "floor", s.Parameters()["quantity_rounding"])
+
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 5.0, "quantity_rounding": "nearest"}))
+	assert.Equal(t, "nearest", s.Parameters()["quantity_rounding"])
+
+	// Omitted keeps the current value, invalid values are rejected
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 6.0}))
+	assert.Equal(t, "nearest", s.Parameters()["quantity_rounding"])
+	assert.Error(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 5.0, "quantity_rounding": "ceil"}))
+
+	_, err = NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0, "quantity_rounding": 1.0})
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package stoploss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// parseStalePosition reads the optional stale_position_seconds parameter,
+// returning def when it is not set. Zero disables the staleness guard.
+func parseStalePosition(params map[string]interface{}, def time.Duration) (time.Duration, error) {
+	raw, exists := params["stale_position_seconds"]
+	if !exists {
+		return def, nil
+	}
+
+	seconds, ok := raw.(float64)
+	if !ok {
+		return 0, fmt.Errorf("stale_position_seconds must be a float64")
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("stale_position_seconds must not be negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parsePositionRefresh reads the optional position_refresh_seconds
+// parameter, how often the positions are fetched again from the
+// position-service. Zero, the default, disables refreshes; it requires
+// position_service_url otherwise.
+func parsePositionRefresh(params map[string]interface{}, positionServiceURL string) (time.Duration, error) {
+	raw, exists := params["position_refresh_seconds"]
+	if !exists {
+		return 0, nil
+	}
+
+	seconds, ok := raw.(float64)
+	if !ok {
+		return 0, fmt.Errorf("position_refresh_seconds must be a float64")
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("position_refresh_seconds must not be negative")
+	}
+	if seconds > 0 && positionServiceURL == "" {
+		return 0, fmt.Errorf("position_refresh_seconds requires position_service_url")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// isStale reports whether a broker position was missing from the latest
+// refresh and was last reported more than stale_position_seconds before at.
+// A position is never stale while no refresh left it out, so positions that
+// are still held stay protected between refreshes however long they are
+// apart. Positions that did not come from the position-service, static ones
+// or prices tracked for a symbol without a position, never go stale. The
+// caller must hold s.mu.
+func (s *StopLossStrategy) isStale(pos Position, at time.Time) bool {
+	if s.stalePosition <= 0 || pos.LastSeenTime.IsZero() || !pos.LastSeenTime.Before(s.lastRefresh) {
+		return false
+	}
+	return at.Sub(pos.LastSeenTime) > s.stalePosition
+}
+
+// pruneStalePositions stops tracking the broker positions that refreshes
+// have not reported for longer than stale_position_seconds, e.g. positions
+// closed outside of the engine. The caller must hold s.mu.
+func (s *StopLossStrategy) pruneStalePositions(now time.Time) {
+	for symbol, pos := range s.positions {
+		if s.isStale(pos, now) {
+			delete(s.positions, symbol)
+		}
+	}
+}
+
+// startPositionRefresh refreshes the positions from the position-service
+// every position_refresh_seconds until Cleanup, when refreshes are enabled
+// and not already running
+func (s *StopLossStrategy) startPositionRefresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.positionRefresh <= 0 || s.stopRefresh != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.stopRefresh = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		s.refreshPositions(ctx, s.positionRefresh)
+	}()
+}
+
+// refreshPositions fetches and loads the positions every interval until ctx
+// is cancelled. A failed refresh is logged and leaves the tracked positions
+// as they are, so an unreachable position-service cannot make positions
+// stale.
+func (s *StopLossStrategy) refreshPositions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		positions, err := s.fetchTrackedPositions(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Stop loss position refresh failed: %v", err)
+			}
+			continue
+		}
+		s.mu.RLock()
+		now := s.now()
+		s.mu.RUnlock()
+		if err := s.LoadPositions(positions, now); err != nil {
+			log.Printf("Stop loss position refresh failed: %v", err)
+		}
+	}
+}
+
+// stopPositionRefresh stops the refreshes started by startPositionRefresh
+// and waits for a running one to finish
+func (s *StopLossStrategy) stopPositionRefresh() {
+	s.mu.Lock()
+	stop := s.stopRefresh
+	s.stopRefresh = nil
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
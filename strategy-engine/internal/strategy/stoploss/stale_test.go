@@ -0,0 +1,226 @@
+package stoploss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopLossStrategy_PrunesPositionsMissingFromRefresh(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":   5.0,
+		"stale_position_seconds": 60.0,
+	})
+	require.NoError(t, err)
+
+	aapl := BrokerPosition{Symbol: "AAPL", Quantity: 10, AveragePrice: 150}
+	msft := BrokerPosition{Symbol: "MSFT", Quantity: 5, AveragePrice: 300}
+	start := time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+	require.NoError(t, s.LoadPositions([]BrokerPosition{aapl, msft}, start))
+
+	// MSFT disappears from the refresh but is kept within the window
+	require.NoError(t, s.LoadPositions([]BrokerPosition{aapl}, start.Add(30*time.Second)))
+	require.Contains(t, s.positions, "MSFT")
+
+	// Past the window it is no longer tracked, AAPL still is
+	require.NoError(t, s.LoadPositions([]BrokerPosition{aapl}, start.Add(90*time.Second)))
+	assert.NotContains(t, s.positions, "MSFT")
+	assert.Contains(t, s.positions, "AAPL")
+}
+
+func TestStopLossStrategy_StalePositionDoesNotTrigger(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":   5.0,
+		"stale_position_seconds": 60.0,
+		"positions": []interface{}{
+			map[string]interface{}{"symbol": "TSLA", "entry_price": 200.0, "quantity": 1.0},
+		},
+	})
+	require.NoError(t, err)
+
+	start := time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+	require.NoError(t, s.LoadPositions([]BrokerPosition{{Symbol: "MSFT", Quantity: 5, AveragePrice: 300}}, start))
+	require.NoError(t, s.LoadPositions(nil, start.Add(30*time.Second)))
+
+	// A refresh no longer reported the position: a crash once the window
+	// passed does not sell a position that may be gone
+	ctx := context.Background()
+	at := start.Add(2 * time.Minute)
+	signal, err := s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 250, Volume: 1, Timestamp: at})
+	require.NoError(t, err)
+	assert.Nil(t, signal)
+	assert.Equal(t, 0.0, s.positions["MSFT"].Quantity)
+
+	// Static positions are not reported by refreshes and never go stale
+	signal, err = s.ProcessData(ctx, strategy.MarketData{Symbol: "TSLA", Price: 150, Volume: 1, Timestamp: at})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionSell, signal.Action)
+}
+
+func TestStopLossStrategy_HeldPositionWithoutRefreshStaysTracked(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":   5.0,
+		"stale_position_seconds": 60.0,
+	})
+	require.NoError(t, err)
+
+	start := time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+	require.NoError(t, s.LoadPositions([]BrokerPosition{{Symbol: "MSFT", Quantity: 5, AveragePrice: 300}}, start))
+
+	// No refresh ran since, so nothing says the position is gone
+	ctx := context.Background()
+	at := start.Add(time.Hour)
+	signal, err := s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 300, Volume: 1, Timestamp: at})
+	require.NoError(t, err)
+	assert.Nil(t, signal)
+	require.Contains(t, s.positions, "MSFT")
+	assert.Equal(t, 5.0, s.positions["MSFT"].Quantity)
+
+	signal, err = s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 250, Volume: 1, Timestamp: at.Add(time.Second)})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionSell, signal.Action)
+}
+
+func TestStopLossStrategy_RefreshKeepsHighestPrice(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+
+	msft := BrokerPosition{Symbol: "MSFT", Quantity: 5, AveragePrice: 300}
+	start := time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+	require.NoError(t, s.LoadPositions([]BrokerPosition{msft}, start))
+	_, err = s.ProcessData(context.Background(), strategy.MarketData{Symbol: "MSFT", Price: 320, Volume: 1, Timestamp: start.Add(time.Second)})
+	require.NoError(t, err)
+
+	require.NoError(t, s.LoadPositions([]BrokerPosition{msft}, start.Add(time.Minute)))
+	assert.Equal(t, 320.0, s.positions["MSFT"].HighestPrice)
+}
+
+func TestStopLossStrategy_RefreshDoesNotResellTriggeredPosition(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	msft := BrokerPosition{Symbol: "MSFT", Quantity: 5, AveragePrice: 300}
+	start := time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+	require.NoError(t, s.LoadPositions([]BrokerPosition{msft}, start))
+	signal, err := s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 280, Volume: 1, Timestamp: start.Add(time.Second)})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+
+	// The sell has not filled, the refresh still reports the position
+	require.NoError(t, s.LoadPositions([]BrokerPosition{msft}, start.Add(time.Minute)))
+	signal, err = s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 279, Volume: 1, Timestamp: start.Add(61 * time.Second)})
+	require.NoError(t, err)
+	assert.Nil(t, signal, "the position was sold again")
+
+	// Once it is gone, a new position in the symbol is protected again
+	require.NoError(t, s.LoadPositions(nil, start.Add(2*time.Minute)))
+	require.NoError(t, s.LoadPositions([]BrokerPosition{msft}, start.Add(3*time.Minute)))
+	signal, err = s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 280, Volume: 1, Timestamp: start.Add(181 * time.Second)})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionSell, signal.Action)
+}
+
+func TestStopLossStrategy_PeriodicRefresh(t *testing.T) {
+	var mu sync.Mutex
+	body := `{"positions":[{"symbol":"AAPL","occ_symbol":"AAPL  250620C00200000","quantity":2,"average_price":3.10,"multiplier":100}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":     5.0,
+		"stale_position_seconds":   0.01,
+		"position_refresh_seconds": 0.01,
+		"position_service_url":     srv.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Initialize(context.Background()))
+	defer s.Cleanup(context.Background())
+
+	tracked := func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, exists := s.positions["AAPL  250620C00200000"]
+		return exists
+	}
+	require.True(t, tracked())
+
+	// The position was closed outside of the engine
+	mu.Lock()
+	body = `{"positions":[]}`
+	mu.Unlock()
+	assert.Eventually(t, func() bool { return !tracked() }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, s.Cleanup(context.Background()))
+	assert.Nil(t, s.stopRefresh)
+}
+
+func TestStopLossStrategy_PositionRefreshParameter(t *testing.T) {
+	_, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent":     5.0,
+		"position_refresh_seconds": 60.0,
+	})
+	assert.Error(t, err, "refreshes need a position-service")
+
+	for _, invalid := range []interface{}{-1.0, "60"} {
+		_, err := NewStopLossStrategy(map[string]interface{}{
+			"max_drawdown_percent":     5.0,
+			"position_refresh_seconds": invalid,
+			"position_service_url":     "http://localhost",
+		})
+		assert.Error(t, err, "position_refresh_seconds %v", invalid)
+	}
+}
+
+func TestStopLossStrategy_StalePositionDisabledByDefault(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+
+	start := time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+	require.NoError(t, s.LoadPositions([]BrokerPosition{{Symbol: "MSFT", Quantity: 5, AveragePrice: 300}}, start))
+	require.NoError(t, s.LoadPositions(nil, start.Add(24*time.Hour)))
+	require.Contains(t, s.positions, "MSFT")
+
+	signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: "MSFT", Price: 250, Volume: 1, Timestamp: start.Add(24 * time.Hour)})
+	require.NoError(t, err)
+	assert.NotNil(t, signal)
+}
+
+func TestStopLossStrategy_StalePositionParameter(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, s.Parameters()["stale_position_seconds"])
+
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{
+		"max_drawdown_percent":   5.0,
+		"stale_position_seconds": 120.0,
+	}))
+	assert.Equal(t, 120.0, s.Parameters()["stale_position_seconds"])
+
+	// Omitted, the current value is kept
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 5.0}))
+	assert.Equal(t, 120.0, s.Parameters()["stale_position_seconds"])
+
+	for _, invalid := range []interface{}{-1.0, "60"} {
+		_, err := NewStopLossStrategy(map[string]interface{}{
+			"max_drawdown_percent":   5.0,
+			"stale_position_seconds": invalid,
+		})
+		assert.Error(t, err, "stale_position_seconds %v", invalid)
+	}
+}
@@ -3,19 +3,51 @@ package stoploss
 import (
 	"context"
 	"fmt"
+	"math"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
 )
 
+// TriggerReason is the "reason" metadata of the signals emitted when a stop
+// loss triggers
+const TriggerReason = "stop_loss"
+
+// DefaultSignalTTL is how long a stop loss signal stays valid when
+// signal_ttl_seconds is not set
+const DefaultSignalTTL = time.Minute
+
 // StopLossStrategy implements a simple stop loss strategy based on maximum drawdown
 type StopLossStrategy struct {
 	mu sync.RWMutex
 
 	// Strategy parameters
 	maxDrawdownPercent float64             // Maximum allowed drawdown in percentage
-	positions          map[string]Position // Current positions keyed by symbol
+	entryPriceSource   EntryPriceSource    // How EntryPrice is derived from broker positions
+	signalTTL          time.Duration       // Validity of a signal from its data timestamp
+	quantityRounding   QuantityRounding    // How option quantities are rounded to whole contracts
+	closeBeforeExpiry  time.Duration       // Options are sold this long before expiring, zero disables it
+	holdOnMissingPrice bool                // Ticks without a usable price are ignored rather than evaluated
+	stalePosition      time.Duration       // Broker positions missing from refreshes for this long are dropped, zero disables it
+	positionRefresh    time.Duration       // Interval of position refreshes after Initialize, zero disables them
+	lastRefresh        time.Time           // Time of the latest successful position load
+	positions          map[string]Position // Current positions keyed by symbol, the OCC symbol for options
+	staticPositions    map[string]Position // From the positions parameter, restored by Reset
+	closed             map[string]bool     // Positions sold by a stop or expiry close, see closePosition
+
+	// Optional entries, see checkEntry
+	entriesEnabled bool
+	entries        map[string]EntryTarget
+	lastPrices     map[string]float64 // Last price of each entry target
+
+	positionServiceURL string       // Optional position-service to seed positions from
+	positionServiceKey string       // API key sent to the position-service, if it requires one
+	client             *http.Client // Client used for the position fetches
+	stopRefresh        func()       // Stops the position refreshes, nil when none run
+
+	now func() time.Time // Clock for ticks without a timestamp and position loads
 
 	name string
 }
@@ -25,7 +57,10 @@ type Position struct {
 	EntryPrice     float64   // Price at which we entered the position
 	HighestPrice   float64   // Highest price seen since entry
 	Quantity       float64   // Current position quantity
+	Option         bool      // Options are sold in whole contracts
+	Expiration     time.Time // When an option stops trading, zero when unknown
 	LastUpdateTime time.Time // Last time this position was updated
+	LastSeenTime   time.Time // Last refresh reporting this position, zero when not loaded from the broker
 }
 
 // NewStopLossStrategy creates a new instance of StopLossStrategy
@@ -39,24 +74,226 @@ func NewStopLossStrategy(params map[string]interface{}) (*StopLossStrategy, erro
 		return nil, fmt.Errorf("max_drawdown_percent must be between 0 and 100")
 	}
 
+	entryPriceSource, err := parseEntryPriceSource(params, EntryPriceAveragePrice)
+	if err != nil {
+		return nil, err
+	}
+
+	signalTTL, err := parseSignalTTL(params, DefaultSignalTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	quantityRounding, err := parseQuantityRounding(params, RoundFloor)
+	if err != nil {
+		return nil, err
+	}
+
+	closeBeforeExpiry, err := parseCloseBeforeExpiry(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	holdOnMissingPrice, err := parseHoldOnMissingPrice(params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	stalePosition, err := parseStalePosition(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var positionServiceURL string
+	if raw, exists := params["position_service_url"]; exists {
+		if positionServiceURL, ok = raw.(string); !ok {
+			return nil, fmt.Errorf("position_service_url must be a string")
+		}
+	}
+	var positionServiceKey string
+	if raw, exists := params["position_service_api_key"]; exists {
+		if positionServiceKey, ok = raw.(string); !ok {
+			return nil, fmt.Errorf("position_service_api_key must be a string")
+		}
+	}
+
+	positionRefresh, err := parsePositionRefresh(params, positionServiceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Static positions are tracked from the start; with a position service
+	// configured, Initialize adds the fetched ones on top
+	positions, err := parseStaticPositions(params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	entriesEnabled, entries, err := parseEntries(params)
+	if err != nil {
+		return nil, err
+	}
+
 	return &StopLossStrategy{
 		maxDrawdownPercent: maxDrawdown,
-		positions:          make(map[string]Position),
+		entryPriceSource:   entryPriceSource,
+		signalTTL:          signalTTL,
+		quantityRounding:   quantityRounding,
+		closeBeforeExpiry:  closeBeforeExpiry,
+		holdOnMissingPrice: holdOnMissingPrice,
+		stalePosition:      stalePosition,
+		positionRefresh:    positionRefresh,
+		positions:          positions,
+		staticPositions:    copyPositions(positions),
+		closed:             make(map[string]bool),
+		entriesEnabled:     entriesEnabled,
+		entries:            entries,
+		lastPrices:         make(map[string]float64),
+		positionServiceURL: positionServiceURL,
+		positionServiceKey: positionServiceKey,
+		client:             &http.Client{},
+		now:                time.Now,
 		name:               "stop_loss_strategy",
 	}, nil
 }
 
-// Initialize implements strategy.Strategy
+// SetClock replaces the wall clock, which only stamps positions loaded by
+// Initialize and signals for ticks without a timestamp. Signal times
+// otherwise follow the market data, see strategy.Signal.
+func (s *StopLossStrategy) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// parseSignalTTL reads the optional signal_ttl_seconds parameter, returning
+// def when it is not set
+func parseSignalTTL(params map[string]interface{}, def time.Duration) (time.Duration, error) {
+	raw, exists := params["signal_ttl_seconds"]
+	if !exists {
+		return def, nil
+	}
+
+	seconds, ok := raw.(float64)
+	if !ok {
+		return 0, fmt.Errorf("signal_ttl_seconds must be a float64")
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("signal_ttl_seconds must be positive")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseHoldOnMissingPrice reads the optional hold_on_missing_price
+// parameter, returning def when it is not set
+func parseHoldOnMissingPrice(params map[string]interface{}, def bool) (bool, error) {
+	raw, exists := params["hold_on_missing_price"]
+	if !exists {
+		return def, nil
+	}
+	hold, ok := raw.(bool)
+	if !ok {
+		return false, fmt.Errorf("hold_on_missing_price must be a bool")
+	}
+	return hold, nil
+}
+
+// missingPrice reports whether a price cannot be valued, e.g. a zero price
+// of an option whose quote was unavailable
+func missingPrice(price float64) bool {
+	return price <= 0 || math.IsNaN(price) || math.IsInf(price, 0)
+}
+
+// Initialize implements strategy.Strategy. When position_service_url is set,
+// the current option positions, and held entry targets, are fetched and
+// tracked; cancelling ctx aborts the fetch. With position_refresh_seconds
+// they are then fetched again periodically until Cleanup. Without
+// position_service_url, only the static positions parameter is tracked.
 func (s *StopLossStrategy) Initialize(ctx context.Context) error {
+	if s.positionServiceURL == "" {
+		return nil
+	}
+
+	positions, err := s.fetchTrackedPositions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch initial positions: %w", err)
+	}
+	s.mu.RLock()
+	now := s.now()
+	s.mu.RUnlock()
+	if err := s.LoadPositions(positions, now); err != nil {
+		return err
+	}
+	s.startPositionRefresh()
+	return nil
+}
+
+// Reset implements strategy.Resettable. Tracked prices are dropped and the
+// positions are seeded again as on start: from the positions parameter and,
+// when position_service_url is set, from the position-service. The fetch
+// comes first and the tracked positions are only replaced once it
+// succeeded, so a failed reset keeps protecting the positions held;
+// cancelling ctx aborts it the same way.
+func (s *StopLossStrategy) Reset(ctx context.Context) error {
+	var fetched []BrokerPosition
+	if s.positionServiceURL != "" {
+		var err error
+		if fetched, err = s.fetchTrackedPositions(ctx); err != nil {
+			return fmt.Errorf("failed to fetch positions: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	positions := copyPositions(s.staticPositions)
+	for symbol, pos := range positions {
+		pos.LastUpdateTime = now
+		positions[symbol] = pos
+	}
+	if err := s.loadPositions(positions, fetched, now); err != nil {
+		return err
+	}
+
+	s.positions = positions
+	s.lastPrices = make(map[string]float64)
+	if s.positionServiceURL != "" {
+		s.lastRefresh = now
+	}
 	return nil
 }
 
-// ProcessData implements strategy.Strategy
+// copyPositions returns a copy of a position map
+func copyPositions(positions map[string]Position) map[string]Position {
+	copied := make(map[string]Position, len(positions))
+	for symbol, pos := range positions {
+		copied[symbol] = pos
+	}
+	return copied
+}
+
+// ProcessData implements strategy.Strategy. With hold_on_missing_price,
+// the default, ticks without a usable price are ignored: a zero price would
+// look like a 100% drawdown and trigger a spurious stop.
 func (s *StopLossStrategy) ProcessData(ctx context.Context, data strategy.MarketData) (*strategy.Signal, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.holdOnMissingPrice && missingPrice(data.Price) {
+		return nil, nil
+	}
+
+	if signal := s.checkEntry(data); signal != nil {
+		return signal, nil
+	}
+
 	pos, exists := s.positions[data.Symbol]
+	if exists && s.isStale(pos, s.signalTime(data)) {
+		// No refresh reported the position for too long, it may no longer
+		// be held; it is tracked again like any symbol without a position
+		delete(s.positions, data.Symbol)
+		exists = false
+	}
 	if !exists {
 		// No position for this symbol yet, track it as a potential entry
 		s.positions[data.Symbol] = Position{
@@ -74,30 +311,46 @@ func (s *StopLossStrategy) ProcessData(ctx context.Context, data strategy.Market
 		s.positions[data.Symbol] = pos
 	}
 
+	// Options about to expire are closed whatever their drawdown
+	if signal, err := s.checkExpiry(data, pos); signal != nil || err != nil {
+		return signal, err
+	}
+
 	// If we have an active position, check for stop loss
 	if pos.Quantity > 0 {
 		currentDrawdown := (pos.HighestPrice - data.Price) / pos.HighestPrice * 100
 
 		if currentDrawdown >= s.maxDrawdownPercent {
+			quantity, err := SignalQuantity(pos.Quantity, pos.Option, s.quantityRounding)
+			if err != nil {
+				return nil, fmt.Errorf("stop loss for %s: %w", data.Symbol, err)
+			}
+			if quantity == 0 {
+				// Less than a whole contract is held, nothing can be sold
+				return nil, nil
+			}
+
+			generatedAt := s.signalTime(data)
+
 			// Generate sell signal - stop loss triggered
 			signal := &strategy.Signal{
 				Symbol:      data.Symbol,
 				Action:      strategy.SignalActionSell,
 				Price:       data.Price,
-				Quantity:    pos.Quantity,
+				Quantity:    quantity,
 				Confidence:  1.0, // High confidence for stop loss
-				GeneratedAt: data.Timestamp,
-				ExpiresAt:   data.Timestamp.Add(time.Minute), // Signal expires in 1 minute
+				GeneratedAt: generatedAt,
+				ExpiresAt:   generatedAt.Add(s.signalTTL),
+				RiskExit:    true,
 				Metadata: map[string]interface{}{
-					"reason":           "stop_loss",
+					"reason":           TriggerReason,
 					"entry_price":      pos.EntryPrice,
 					"highest_price":    pos.HighestPrice,
 					"current_drawdown": currentDrawdown,
 				},
 			}
 
-			// Reset position tracking
-			delete(s.positions, data.Symbol)
+			s.closePosition(data.Symbol)
 			return signal, nil
 		}
 	}
@@ -105,6 +358,24 @@ func (s *StopLossStrategy) ProcessData(ctx context.Context, data strategy.Market
 	return nil, nil
 }
 
+// closePosition stops tracking a position a signal sells. Until the sell
+// fills the broker still reports the position, so position loads skip it
+// until a refresh no longer does; it would be sold again otherwise. The
+// caller must hold s.mu.
+func (s *StopLossStrategy) closePosition(symbol string) {
+	delete(s.positions, symbol)
+	s.closed[symbol] = true
+}
+
+// signalTime returns the generation time of a signal for market data. Signal
+// times follow the data, the clock only stands in for a missing timestamp.
+func (s *StopLossStrategy) signalTime(data strategy.MarketData) time.Time {
+	if data.Timestamp.IsZero() {
+		return s.now()
+	}
+	return data.Timestamp
+}
+
 // Name implements strategy.Strategy
 func (s *StopLossStrategy) Name() string {
 	return s.name
@@ -112,8 +383,19 @@ func (s *StopLossStrategy) Name() string {
 
 // Parameters implements strategy.Strategy
 func (s *StopLossStrategy) Parameters() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return map[string]interface{}{
-		"max_drawdown_percent": s.maxDrawdownPercent,
+		"max_drawdown_percent":        s.maxDrawdownPercent,
+		"entry_price_source":          string(s.entryPriceSource),
+		"signal_ttl_seconds":          s.signalTTL.Seconds(),
+		"quantity_rounding":           string(s.quantityRounding),
+		"close_before_expiry_minutes": s.closeBeforeExpiry.Minutes(),
+		"hold_on_missing_price":       s.holdOnMissingPrice,
+		"stale_position_seconds":      s.stalePosition.Seconds(),
+		"position_refresh_seconds":    s.positionRefresh.Seconds(),
+		"enable_entries":              s.entriesEnabled,
 	}
 }
 
@@ -129,13 +411,50 @@ func (s *StopLossStrategy) UpdateParameters(params map[string]interface{}) error
 	}
 
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// entry_price_source, signal_ttl_seconds, quantity_rounding,
+	// close_before_expiry_minutes, hold_on_missing_price and
+	// stale_position_seconds are optional and keep their current values when
+	// omitted
+	entryPriceSource, err := parseEntryPriceSource(params, s.entryPriceSource)
+	if err != nil {
+		return err
+	}
+	signalTTL, err := parseSignalTTL(params, s.signalTTL)
+	if err != nil {
+		return err
+	}
+	quantityRounding, err := parseQuantityRounding(params, s.quantityRounding)
+	if err != nil {
+		return err
+	}
+	closeBeforeExpiry, err := parseCloseBeforeExpiry(params, s.closeBeforeExpiry)
+	if err != nil {
+		return err
+	}
+	holdOnMissingPrice, err := parseHoldOnMissingPrice(params, s.holdOnMissingPrice)
+	if err != nil {
+		return err
+	}
+	stalePosition, err := parseStalePosition(params, s.stalePosition)
+	if err != nil {
+		return err
+	}
+
 	s.maxDrawdownPercent = maxDrawdown
-	s.mu.Unlock()
+	s.entryPriceSource = entryPriceSource
+	s.signalTTL = signalTTL
+	s.quantityRounding = quantityRounding
+	s.closeBeforeExpiry = closeBeforeExpiry
+	s.holdOnMissingPrice = holdOnMissingPrice
+	s.stalePosition = stalePosition
 
 	return nil
 }
 
-// Cleanup implements strategy.Strategy
+// Cleanup implements strategy.Strategy, stopping the position refreshes
 func (s *StopLossStrategy) Cleanup(ctx context.Context) error {
+	s.stopPositionRefresh()
 	return nil
 }
@@ -2,10 +2,13 @@ package stoploss
 
 import (
 	"context"
+	"math"
 	"testing"
 	"time"
 
+	"github.com/ZhouDavid/trade-sonic/strategy-engine/internal/strategy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewStopLossStrategy(t *testing.T) {
@@ -35,6 +38,22 @@ func TestNewStopLossStrategy(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "valid entry price source",
+			params: map[string]interface{}{
+				"max_drawdown_percent": 5.0,
+				"entry_price_source":   "cost_basis",
+			},
+			expectedError: false,
+		},
+		{
+			name: "invalid entry price source",
+			params: map[string]interface{}{
+				"max_drawdown_percent": 5.0,
+				"entry_price_source":   "last_price",
+			},
+			expectedError: true,
+		},
 		{
 			name: "invalid drawdown value - too large",
 			params: map[string]interface{}{
@@ -136,6 +155,55 @@ func TestStopLossStrategy_ProcessData(t *testing.T) {
 	assert.Nil(t, signal)
 }
 
+func TestStopLossStrategy_SignalTimestamps(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"signal_ttl_seconds":   30.0,
+	})
+	require.NoError(t, err)
+
+	// The wall clock is far from the replayed ticks and must not leak in
+	wallClock := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.SetClock(func() time.Time { return wallClock })
+
+	trigger := func(timestamp time.Time) *strategy.Signal {
+		s.positions["AAPL"] = Position{EntryPrice: 100, HighestPrice: 100, Quantity: 1}
+		signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: "AAPL", Price: 90, Volume: 1, Timestamp: timestamp})
+		require.NoError(t, err)
+		require.NotNil(t, signal)
+		return signal
+	}
+
+	// A historical tick stamps the signal in data time
+	historical := time.Date(2020, 3, 16, 14, 30, 0, 0, time.UTC)
+	signal := trigger(historical)
+	assert.Equal(t, historical, signal.GeneratedAt)
+	assert.Equal(t, historical.Add(30*time.Second), signal.ExpiresAt)
+
+	// A tick without a timestamp falls back to the clock
+	signal = trigger(time.Time{})
+	assert.Equal(t, wallClock, signal.GeneratedAt)
+	assert.Equal(t, wallClock.Add(30*time.Second), signal.ExpiresAt)
+}
+
+func TestStopLossStrategy_SignalTTLParameter(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSignalTTL.Seconds(), s.Parameters()["signal_ttl_seconds"])
+
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 5.0, "signal_ttl_seconds": 5.0}))
+	assert.Equal(t, 5.0, s.Parameters()["signal_ttl_seconds"])
+
+	// Omitted keeps the current value, invalid values are rejected
+	require.NoError(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 6.0}))
+	assert.Equal(t, 5.0, s.Parameters()["signal_ttl_seconds"])
+	assert.Error(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 5.0, "signal_ttl_seconds": 0.0}))
+	assert.Error(t, s.UpdateParameters(map[string]interface{}{"max_drawdown_percent": 5.0, "signal_ttl_seconds": "60"}))
+
+	_, err = NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 5.0, "signal_ttl_seconds": -1.0})
+	assert.Error(t, err)
+}
+
 func TestStopLossStrategy_UpdateParameters(t *testing.T) {
 	strategy, err := NewStopLossStrategy(map[string]interface{}{
 		"max_drawdown_percent": 5.0,
@@ -183,3 +251,86 @@ func TestStopLossStrategy_UpdateParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestStopLossStrategy_Reset(t *testing.T) {
+	s, err := NewStopLossStrategy(map[string]interface{}{
+		"max_drawdown_percent": 5.0,
+		"positions": []interface{}{
+			map[string]interface{}{"symbol": "MSFT", "entry_price": 400.0, "quantity": 10.0},
+		},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+
+	// A bad tick raises the high-water mark, another symbol starts being tracked
+	_, err = s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 4500, Volume: 1, Timestamp: now})
+	require.NoError(t, err)
+	_, err = s.ProcessData(ctx, strategy.MarketData{Symbol: "TSLA", Price: 250, Volume: 1, Timestamp: now})
+	require.NoError(t, err)
+	require.Equal(t, 4500.0, s.positions["MSFT"].HighestPrice)
+
+	require.NoError(t, s.Reset(ctx))
+	assert.NotContains(t, s.positions, "TSLA")
+	require.Contains(t, s.positions, "MSFT")
+	assert.Equal(t, 400.0, s.positions["MSFT"].HighestPrice)
+	assert.Equal(t, 10.0, s.positions["MSFT"].Quantity)
+
+	// The strategy keeps running from the restored state
+	signal, err := s.ProcessData(ctx, strategy.MarketData{Symbol: "MSFT", Price: 380, Volume: 1, Timestamp: now})
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, strategy.SignalActionSell, signal.Action)
+	assert.Equal(t, 400.0, signal.Metadata["highest_price"])
+}
+
+func TestStopLossStrategy_HoldsOnMissingPrice(t *testing.T) {
+	const call = "AAPL  250620C00200000"
+	newStrategy := func(t *testing.T, params map[string]interface{}) *StopLossStrategy {
+		params["max_drawdown_percent"] = 20.0
+		params["positions"] = []interface{}{
+			map[string]interface{}{"symbol": call, "entry_price": 4.0, "quantity": 1.0, "option": true},
+		}
+		s, err := NewStopLossStrategy(params)
+		require.NoError(t, err)
+		return s
+	}
+	at := time.Date(2025, 6, 2, 15, 0, 0, 0, time.UTC)
+	process := func(t *testing.T, s *StopLossStrategy, price float64) *strategy.Signal {
+		signal, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: call, Price: price, Volume: 1, Timestamp: at})
+		require.NoError(t, err)
+		return signal
+	}
+
+	t.Run("hold by default", func(t *testing.T) {
+		s := newStrategy(t, map[string]interface{}{})
+		assert.Nil(t, process(t, s, 0))
+		assert.Nil(t, process(t, s, math.NaN()))
+		assert.Nil(t, process(t, s, -1))
+		// The position is still tracked and evaluated on the next real price
+		assert.Equal(t, 4.0, s.positions[call].HighestPrice)
+		signal := process(t, s, 3.0)
+		require.NotNil(t, signal)
+		assert.Equal(t, TriggerReason, signal.Metadata["reason"])
+		assert.True(t, signal.RiskExit)
+	})
+
+	t.Run("missing prices of untracked symbols are not tracked", func(t *testing.T) {
+		s := newStrategy(t, map[string]interface{}{})
+		_, err := s.ProcessData(context.Background(), strategy.MarketData{Symbol: "MSFT", Price: 0, Volume: 1, Timestamp: at})
+		require.NoError(t, err)
+		assert.NotContains(t, s.positions, "MSFT")
+	})
+
+	t.Run("evaluated when disabled", func(t *testing.T) {
+		s := newStrategy(t, map[string]interface{}{"hold_on_missing_price": false})
+		signal := process(t, s, 0)
+		require.NotNil(t, signal)
+		assert.Equal(t, 100.0, signal.Metadata["current_drawdown"])
+	})
+
+	t.Run("invalid parameter", func(t *testing.T) {
+		_, err := NewStopLossStrategy(map[string]interface{}{"max_drawdown_percent": 20.0, "hold_on_missing_price": "yes"})
+		assert.EqualError(t, err, "hold_on_missing_price must be a bool")
+	})
+}
@@ -14,7 +14,11 @@ type MarketData struct {
 	// Add other relevant market data fields
 }
 
-// Signal represents a trading signal generated by a strategy
+// Signal represents a trading signal generated by a strategy. GeneratedAt and
+// ExpiresAt are in data time: they derive from the timestamp of the market
+// data that produced the signal, not the wall clock, so a backtest replaying
+// historical ticks generates the same signals as the live run. Expiry must be
+// checked against the same data clock.
 type Signal struct {
 	Symbol      string
 	Action      SignalAction
@@ -24,17 +28,40 @@ type Signal struct {
 	GeneratedAt time.Time
 	ExpiresAt   time.Time  // Optional expiration time for the signal
 	Metadata    map[string]interface{} // Additional strategy-specific metadata
+	// RiskExit marks a signal that exits a position to limit its loss, such
+	// as a stop loss. Signal netting never lets a buy reduce or drop it.
+	RiskExit bool
 }
 
 // SignalAction represents the type of trading action to take
 type SignalAction string
 
 const (
-	SignalActionBuy    SignalAction = "BUY"
-	SignalActionSell   SignalAction = "SELL"
-	SignalActionHold   SignalAction = "HOLD"
+	SignalActionBuy  SignalAction = "BUY"
+	SignalActionSell SignalAction = "SELL"
+	SignalActionHold SignalAction = "HOLD"
+	// SignalActionScaleIn grows an existing position. Quantity is the
+	// fraction of the current position to add, e.g. 0.5 adds half.
+	SignalActionScaleIn SignalAction = "SCALE_IN"
+	// SignalActionScaleOut trims an existing position. Quantity is the
+	// fraction of the current position to sell, between 0 and 1.
+	SignalActionScaleOut SignalAction = "SCALE_OUT"
+	// SignalActionCloseAll sells the whole position in the symbol, Quantity is ignored
+	SignalActionCloseAll SignalAction = "CLOSE_ALL"
 )
 
+// Known reports whether the action is one handlers know how to act on.
+// Signals with unknown actions must be ignored rather than acted on.
+func (a SignalAction) Known() bool {
+	switch a {
+	case SignalActionBuy, SignalActionSell, SignalActionHold,
+		SignalActionScaleIn, SignalActionScaleOut, SignalActionCloseAll:
+		return true
+	default:
+		return false
+	}
+}
+
 // Strategy defines the interface that all trading strategies must implement
 type Strategy interface {
 	// Initialize sets up any necessary resources for the strategy
@@ -56,6 +83,14 @@ type Strategy interface {
 	Cleanup(ctx context.Context) error
 }
 
+// Resettable is implemented by strategies whose per-symbol state can be
+// cleared without unregistering them, e.g. after a corporate action left
+// tracked prices wrong
+type Resettable interface {
+	// Reset clears the per-symbol state, as if the strategy had just started
+	Reset(ctx context.Context) error
+}
+
 // SignalHandler defines the interface for components that process generated signals
 type SignalHandler interface {
 	// HandleSignal processes a trading signal
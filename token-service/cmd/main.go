@@ -1,23 +1,110 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/trade-sonic/logging"
+	"github.com/trade-sonic/logging/ginlog"
 	"github.com/trade-sonic/token-service/internal/token"
 )
 
+// configFile is the name of the credentials config looked up next to the
+// binary and in the working directory
+const configFile = "config.json"
+
 func main() {
-	r := gin.Default()
+	configFlag := flag.String("config", "", "path to the credentials config, overrides TOKEN_SERVICE_CONFIG")
+	flag.Parse()
+
+	// LOG_FORMAT=json switches to structured logs
+	logger, logFormat, err := logging.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+
+	r := ginlog.NewRouter(logger, logFormat)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	// Credentials may come from the environment or secrets instead, so a
+	// config file is only required when named explicitly
+	configPath, err := findConfig(*configFlag)
+	if errors.Is(err, errNoConfig) {
+		logger.Info("No config file, credentials must come from the environment or secrets", "error", err)
+	} else if err != nil {
+		log.Fatalf("Failed to find config: %v", err)
+	}
+
+	// SECRETS_DIR holds one file per secret, e.g. robinhood_password, like a
+	// Docker or Kubernetes secrets mount
+	var secrets token.SecretsProvider
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		secrets = token.NewFileSecrets(dir)
+	}
 
-	handler, err := token.NewHandler()
+	handler, err := token.NewHandler(configPath, secrets, token.NewMetrics(registry))
 	if err != nil {
 		log.Fatalf("Failed to create handler: %v", err)
 	}
 
 	r.POST("/token", handler.GetToken)
+	r.POST("/token/challenge", handler.SubmitChallenge)
+	r.POST("/token/revoke", handler.RevokeToken)
+	r.DELETE("/token/cache/:account_type", handler.ClearToken)
+	r.POST("/token/device/rotate", handler.RotateDevice)
+	r.GET("/metrics", gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
 
 	if err := r.Run(":8080"); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// errNoConfig is returned by findConfig when no config file exists in the
+// default locations
+var errNoConfig = errors.New("no config file found")
+
+// findConfig returns the path of the credentials config: flagPath when set,
+// else TOKEN_SERVICE_CONFIG, else config.json next to the binary or in the
+// working directory. The error names every path tried, and wraps
+// errNoConfig when none was named explicitly.
+func findConfig(flagPath string) (string, error) {
+	explicit := flagPath
+	if explicit == "" {
+		explicit = os.Getenv("TOKEN_SERVICE_CONFIG")
+	}
+
+	var candidates []string
+	if explicit != "" {
+		candidates = []string{explicit}
+	} else {
+		if execPath, err := os.Executable(); err == nil {
+			candidates = append(candidates, filepath.Join(filepath.Dir(execPath), configFile))
+		}
+		candidates = append(candidates, configFile)
+	}
+
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("cannot access config %s: %w", path, err)
+		}
+	}
+	if explicit != "" {
+		return "", fmt.Errorf("config file %s not found", explicit)
+	}
+	return "", fmt.Errorf("%w, tried %s", errNoConfig, strings.Join(candidates, ", "))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	flagConfig := filepath.Join(dir, "flag.json")
+	envConfig := filepath.Join(dir, "env.json")
+	for _, path := range []string{flagConfig, envConfig} {
+		if err := os.WriteFile(path, []byte(`{}`), 0o600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	missing := filepath.Join(dir, "missing.json")
+
+	tests := []struct {
+		name      string
+		flag      string
+		env       string
+		expected  string
+		expectErr string
+	}{
+		{name: "flag", flag: flagConfig, expected: flagConfig},
+		{name: "flag wins over env", flag: flagConfig, env: envConfig, expected: flagConfig},
+		{name: "env", env: envConfig, expected: envConfig},
+		{name: "missing flag path", flag: missing, env: envConfig, expectErr: missing},
+		{name: "missing env path", env: missing, expectErr: missing},
+		{name: "directory", flag: dir, expectErr: dir},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOKEN_SERVICE_CONFIG", tt.env)
+
+			path, err := findConfig(tt.flag)
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Errorf("Expected an error naming %s, got %v", tt.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if path != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, path)
+			}
+		})
+	}
+}
+
+func TestFindConfig_DefaultsNamesEveryPathTried(t *testing.T) {
+	t.Setenv("TOKEN_SERVICE_CONFIG", "")
+	// The test binary's directory and the package directory hold no config
+	execPath, err := os.Executable()
+	if err != nil {
+		t.Skipf("executable path unavailable: %v", err)
+	}
+
+	_, err = findConfig("")
+	if !errors.Is(err, errNoConfig) {
+		t.Fatalf("Expected errNoConfig, got %v", err)
+	}
+	for _, path := range []string{filepath.Join(filepath.Dir(execPath), configFile), configFile} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected the error to name %s, got %v", path, err)
+		}
+	}
+}
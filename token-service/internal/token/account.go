@@ -0,0 +1,99 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultLabel is the label of an account configured or requested without
+// one, such as the credentials in the robinhood section of the config
+const DefaultLabel = "default"
+
+// ErrUnknownAccount is returned for an account label that is not configured
+var ErrUnknownAccount = errors.New("unknown account")
+
+// labelPattern restricts labels to what fits environment variable and
+// secret names
+var labelPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Account identifies a broker login. An account type can have several, told
+// apart by their labels, each with its own credentials, tokens and device.
+type Account struct {
+	Type  AccountType
+	Label string
+}
+
+// DefaultAccount returns the account of an account type without a label
+func DefaultAccount(accountType AccountType) Account {
+	return Account{Type: accountType, Label: DefaultLabel}
+}
+
+// ParseLabel validates an account label: lowercase letters, digits and
+// underscores. An empty label is DefaultLabel.
+func ParseLabel(label string) (string, error) {
+	if label == "" {
+		return DefaultLabel, nil
+	}
+	if !labelPattern.MatchString(label) {
+		return "", fmt.Errorf("invalid account label %q, expected lowercase letters, digits and underscores", label)
+	}
+	return label, nil
+}
+
+// String returns the account type, followed by the label unless it is
+// DefaultLabel, e.g. robinhood or robinhood/joint
+func (a Account) String() string {
+	if a.Label == DefaultLabel {
+		return string(a.Type)
+	}
+	return string(a.Type) + "/" + a.Label
+}
+
+// MarshalText implements encoding.TextMarshaler, keying the token cache file
+// by String, so caches written before labels existed still load
+func (a Account) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (a *Account) UnmarshalText(text []byte) error {
+	typ, label, _ := strings.Cut(string(text), "/")
+	accountType, err := ParseAccountType(typ)
+	if err != nil {
+		return err
+	}
+	if label, err = ParseLabel(label); err != nil {
+		return err
+	}
+	*a = Account{Type: accountType, Label: label}
+	return nil
+}
+
+// envPrefix returns the prefix of the account's environment variables, e.g.
+// ROBINHOOD for the default account and ROBINHOOD_JOINT for the joint one
+func (a Account) envPrefix() string {
+	if a.Label == DefaultLabel {
+		return strings.ToUpper(string(a.Type))
+	}
+	return strings.ToUpper(string(a.Type) + "_" + a.Label)
+}
+
+// account returns the configured account of an account type with a label,
+// the default one when the label is empty
+func (s *Service) account(accountType AccountType, label string) (Account, error) {
+	label, err := ParseLabel(label)
+	if err != nil {
+		return Account{}, fmt.Errorf("%w: %w", ErrUnknownAccount, err)
+	}
+	account := Account{Type: accountType, Label: label}
+
+	s.cacheMutex.RLock()
+	_, exists := s.credentials[account]
+	s.cacheMutex.RUnlock()
+	if !exists {
+		return Account{}, fmt.Errorf("%w: no credentials configured for %s", ErrUnknownAccount, account)
+	}
+	return account, nil
+}
@@ -0,0 +1,274 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var jointAccount = Account{Type: Robinhood, Label: "joint"}
+
+// newTwoAccountService returns a service for the default and the joint
+// Robinhood accounts, answering logins with responses
+func newTwoAccountService(responses []mockResponse) (*Service, *mockTransport) {
+	client := newMockClient(responses)
+	s := &Service{
+		client:     client,
+		tokenCache: make(map[Account]*cachedToken),
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "default-user", password: "test"},
+			jointAccount:              {username: "joint-user", password: "test"},
+		},
+	}
+	return s, client.Transport.(*mockTransport)
+}
+
+// loginUsername returns the username a token request body logged in with
+func loginUsername(t *testing.T, body string) string {
+	t.Helper()
+	var payload struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("Failed to parse request body: %v", err)
+	}
+	return payload.Username
+}
+
+// inScratchDir runs the test in a temporary directory, since NewService
+// keeps its token cache under ./data
+func inScratchDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestAccount_Text(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected Account
+	}{
+		{text: "robinhood", expected: DefaultAccount(Robinhood)},
+		{text: "robinhood/joint", expected: jointAccount},
+	}
+	for _, tt := range tests {
+		var account Account
+		if err := account.UnmarshalText([]byte(tt.text)); err != nil {
+			t.Fatalf("Expected no error for %q, got %v", tt.text, err)
+		}
+		if account != tt.expected {
+			t.Errorf("Expected %+v for %q, got %+v", tt.expected, tt.text, account)
+		}
+		if text, _ := account.MarshalText(); string(text) != tt.text {
+			t.Errorf("Expected %q, got %q", tt.text, text)
+		}
+	}
+
+	for _, invalid := range []string{"webull", "robinhood/Joint", "robinhood/a/b"} {
+		var account Account
+		if err := account.UnmarshalText([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %q, got %+v", invalid, account)
+		}
+	}
+}
+
+func TestTokenCacheFile_LoadsUnlabeledTokens(t *testing.T) {
+	// Caches written before labels existed are keyed by account type
+	var cache tokenCacheFile
+	if err := json.Unmarshal([]byte(`{"tokens":{"robinhood":{"access_token":"test"}},"devices":{"robinhood":"device-token"}}`), &cache); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token := cache.Tokens[DefaultAccount(Robinhood)]; token == nil || token.AccessToken != "test" {
+		t.Errorf("Expected the token of the default account, got %+v", cache.Tokens)
+	}
+	if device := cache.Devices[DefaultAccount(Robinhood)]; device != "device-token" {
+		t.Errorf("Expected the device token of the default account, got %q", device)
+	}
+}
+
+func TestGetToken_SeparatesAccounts(t *testing.T) {
+	s, transport := newTwoAccountService([]mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{"access_token": "joint-token", "expires_in": 3600}),
+		newMockResponse(http.StatusOK, map[string]interface{}{"access_token": "default-token", "expires_in": 3600}),
+	})
+
+	joint, err := s.GetToken(context.Background(), Robinhood, "joint")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if joint.AccessToken != "joint-token" {
+		t.Errorf("Expected joint-token, got %s", joint.AccessToken)
+	}
+	// An empty label is the default account, which logs in on its own
+	token, err := s.GetToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "default-token" {
+		t.Errorf("Expected default-token, got %s", token.AccessToken)
+	}
+
+	if got := loginUsername(t, transport.bodies[0]); got != "joint-user" {
+		t.Errorf("Expected the joint account to log in as joint-user, got %s", got)
+	}
+	if got := loginUsername(t, transport.bodies[1]); got != "default-user" {
+		t.Errorf("Expected the default account to log in as default-user, got %s", got)
+	}
+	if sentDeviceToken(t, transport.bodies[0]) == sentDeviceToken(t, transport.bodies[1]) {
+		t.Error("Expected each account to identify as its own device")
+	}
+
+	// Both stay cached under their own label
+	if cached, err := s.GetToken(context.Background(), Robinhood, "joint"); err != nil || cached.AccessToken != "joint-token" {
+		t.Errorf("Expected the cached joint-token, got %+v, %v", cached, err)
+	}
+	if cached, err := s.GetToken(context.Background(), Robinhood, DefaultLabel); err != nil || cached.AccessToken != "default-token" {
+		t.Errorf("Expected the cached default-token, got %+v, %v", cached, err)
+	}
+	if len(transport.bodies) != 2 {
+		t.Errorf("Expected 2 logins, got %d", len(transport.bodies))
+	}
+
+	for _, label := range []string{"missing", "Joint"} {
+		if _, err := s.GetToken(context.Background(), Robinhood, label); !errors.Is(err, ErrUnknownAccount) {
+			t.Errorf("Expected ErrUnknownAccount for %q, got %v", label, err)
+		}
+	}
+}
+
+func TestNewService_LabeledAccounts(t *testing.T) {
+	dir := inScratchDir(t)
+	t.Setenv("ROBINHOOD_USERNAME", "")
+	t.Setenv("ROBINHOOD_PASSWORD", "")
+	t.Setenv("ROBINHOOD_JOINT_USERNAME", "")
+	t.Setenv("ROBINHOOD_JOINT_PASSWORD", "")
+	t.Setenv("ROBINHOOD_IRA_USERNAME", "env-ira-user")
+	t.Setenv("ROBINHOOD_IRA_PASSWORD", "env-ira-pass")
+
+	configPath := filepath.Join(dir, "config.json")
+	config := `{
+		"robinhood": {"username": "user", "password": "secret"},
+		"accounts": [
+			{"label": "joint", "type": "robinhood", "username": "joint-user", "password": "joint-secret"},
+			{"label": "ira", "type": "robinhood", "username": "config-ira-user", "password": "config-ira-pass"}
+		]
+	}`
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	s, err := NewService(configPath, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[Account]string{
+		DefaultAccount(Robinhood):       "user",
+		jointAccount:                    "joint-user",
+		{Type: Robinhood, Label: "ira"}: "env-ira-user",
+	}
+	for account, username := range expected {
+		if creds := s.credentials[account]; creds.username != username {
+			t.Errorf("Expected %s to log in as %s, got %+v", account, username, creds)
+		}
+	}
+}
+
+func TestNewService_OnlyLabeledAccounts(t *testing.T) {
+	dir := inScratchDir(t)
+	t.Setenv("ROBINHOOD_USERNAME", "")
+	t.Setenv("ROBINHOOD_PASSWORD", "")
+
+	configPath := filepath.Join(dir, "config.json")
+	config := `{"accounts": [{"label": "joint", "type": "robinhood", "username": "joint-user", "password": "joint-secret"}]}`
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	s, err := NewService(configPath, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, exists := s.credentials[jointAccount]; !exists {
+		t.Error("Expected the joint account to be configured")
+	}
+	if _, err := s.GetToken(context.Background(), Robinhood, ""); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Expected ErrUnknownAccount for the default account, got %v", err)
+	}
+}
+
+func TestLoadConfig_InvalidAccounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "missing type", config: `{"accounts": [{"label": "joint", "username": "u", "password": "p"}]}`},
+		{name: "unknown type", config: `{"accounts": [{"label": "joint", "type": "webull", "username": "u", "password": "p"}]}`},
+		{name: "invalid label", config: `{"accounts": [{"label": "Joint Account", "type": "robinhood", "username": "u", "password": "p"}]}`},
+		{name: "duplicate label", config: `{"accounts": [
+			{"label": "joint", "type": "robinhood", "username": "u", "password": "p"},
+			{"label": "joint", "type": "robinhood", "username": "u2", "password": "p2"}
+		]}`},
+		{name: "default configured twice", config: `{
+			"robinhood": {"username": "u", "password": "p"},
+			"accounts": [{"type": "robinhood", "username": "u2", "password": "p2"}]
+		}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			if _, err := loadConfig(path); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestHandler_GetTokenByLabel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _ := newTwoAccountService(nil)
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "default-token", ExpiresAt: time.Now().Add(time.Hour)}
+	s.tokenCache[jointAccount] = &cachedToken{AccessToken: "joint-token", ExpiresAt: time.Now().Add(time.Hour)}
+	r := gin.New()
+	r.POST("/token", (&Handler{service: s}).GetToken)
+
+	request := func(body string) (int, TokenResponse) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		var resp TokenResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	if code, resp := request(`{"account_type":"robinhood"}`); code != http.StatusOK || resp.AccessToken != "default-token" {
+		t.Errorf("Expected default-token without a label, got %d %+v", code, resp)
+	}
+	if code, resp := request(`{"account_type":"robinhood","label":"joint"}`); code != http.StatusOK || resp.AccessToken != "joint-token" {
+		t.Errorf("Expected joint-token, got %d %+v", code, resp)
+	}
+	if code, _ := request(`{"account_type":"robinhood","label":"missing"}`); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown label, got %d", http.StatusNotFound, code)
+	}
+}
@@ -0,0 +1,95 @@
+package token
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// AlpacaPaperURL is Alpaca's paper trading API, used unless an account
+	// is configured as live
+	AlpacaPaperURL = "https://paper-api.alpaca.markets"
+	// AlpacaLiveURL is Alpaca's live trading API
+	AlpacaLiveURL = "https://api.alpaca.markets"
+)
+
+// Alpaca authenticates every request with the API key in these headers
+const (
+	AlpacaKeyIDHeader     = "APCA-API-KEY-ID"
+	AlpacaSecretKeyHeader = "APCA-API-SECRET-KEY"
+)
+
+// alpacaConfig holds the API key of an Alpaca account. Paper and live
+// trading have separate keys.
+type alpacaConfig struct {
+	KeyID     string `json:"key_id"`
+	SecretKey string `json:"secret_key"`
+	// Live selects the live trading API instead of paper trading
+	Live bool `json:"live"`
+}
+
+func (c alpacaConfig) complete() bool {
+	return c.KeyID != "" && c.SecretKey != ""
+}
+
+func (c alpacaConfig) credentials() accountCredentials {
+	return accountCredentials{username: c.KeyID, password: c.SecretKey}
+}
+
+// alpacaEndpoint returns the API an Alpaca account trades on: the live one
+// when ALPACA_LIVE, or ALPACA_JOINT_LIVE for the account labeled joint, is
+// true, or when the variable is unset and the config says live, paper
+// trading otherwise. cfg may be nil.
+func alpacaEndpoint(account Account, cfg *config) (string, error) {
+	live := false
+	if cfg != nil {
+		live = cfg.alpacaLive(account)
+	}
+	env := account.envPrefix() + "_LIVE"
+	if value := os.Getenv(env); value != "" {
+		var err error
+		if live, err = strconv.ParseBool(value); err != nil {
+			return "", fmt.Errorf("invalid %s, expected true or false: %w", env, err)
+		}
+	}
+	if live {
+		return AlpacaLiveURL, nil
+	}
+	return AlpacaPaperURL, nil
+}
+
+// alpacaLive reports whether the config selects live trading for an Alpaca
+// account
+func (c *config) alpacaLive(account Account) bool {
+	if account == DefaultAccount(Alpaca) && c.Alpaca.complete() {
+		return c.Alpaca.Live
+	}
+	for _, entry := range c.Accounts {
+		if entry.account() == account {
+			return entry.Live
+		}
+	}
+	return false
+}
+
+// alpacaToken returns the API key of an Alpaca account as request headers.
+// Alpaca has no login, so nothing is fetched or cached and the key never
+// leaves memory. It still gets an expiry, so clients ask again now and then
+// and pick up a rotated key.
+func (s *Service) alpacaToken(account Account) *TokenResponse {
+	s.cacheMutex.RLock()
+	creds := s.credentials[account]
+	s.cacheMutex.RUnlock()
+
+	return &TokenResponse{
+		AuthType: AuthAPIKey,
+		Headers: map[string]string{
+			AlpacaKeyIDHeader:     creds.username,
+			AlpacaSecretKeyHeader: creds.password,
+		},
+		BaseURL:   creds.endpoint,
+		ExpiresAt: time.Now().Add(defaultTokenValidity),
+	}
+}
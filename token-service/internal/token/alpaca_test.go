@@ -0,0 +1,268 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// clearAlpacaEnv unsets the variables configuring the default and the
+// labeled Alpaca accounts used in the tests
+func clearAlpacaEnv(t *testing.T) {
+	t.Helper()
+	for _, prefix := range []string{"ALPACA", "ALPACA_SWING"} {
+		t.Setenv(prefix+"_KEY_ID", "")
+		t.Setenv(prefix+"_SECRET_KEY", "")
+		t.Setenv(prefix+"_LIVE", "")
+	}
+}
+
+func TestNewService_AlpacaAccounts(t *testing.T) {
+	dir := inScratchDir(t)
+	clearAlpacaEnv(t)
+	t.Setenv("ROBINHOOD_USERNAME", "")
+	t.Setenv("ROBINHOOD_PASSWORD", "")
+	t.Setenv("ALPACA_KEY_ID", "env-key")
+	t.Setenv("ALPACA_SECRET_KEY", "env-secret")
+
+	configPath := filepath.Join(dir, "config.json")
+	config := `{
+		"robinhood": {"username": "user", "password": "secret"},
+		"accounts": [
+			{"label": "swing", "type": "alpaca", "key_id": "swing-key", "secret_key": "swing-secret", "live": true}
+		]
+	}`
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	s, err := NewService(configPath, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	token, err := s.GetToken(context.Background(), Alpaca, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AuthType != AuthAPIKey {
+		t.Errorf("Expected auth type %s, got %s", AuthAPIKey, token.AuthType)
+	}
+	if token.AccessToken != "" {
+		t.Errorf("Expected no access token, got %s", token.AccessToken)
+	}
+	if token.Headers[AlpacaKeyIDHeader] != "env-key" || token.Headers[AlpacaSecretKeyHeader] != "env-secret" {
+		t.Errorf("Expected the key from the environment, got %v", token.Headers)
+	}
+	if token.BaseURL != AlpacaPaperURL {
+		t.Errorf("Expected %s, got %s", AlpacaPaperURL, token.BaseURL)
+	}
+	if !token.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected an expiry in the future, got %v", token.ExpiresAt)
+	}
+
+	swing, err := s.GetToken(context.Background(), Alpaca, "swing")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if swing.Headers[AlpacaKeyIDHeader] != "swing-key" || swing.BaseURL != AlpacaLiveURL {
+		t.Errorf("Expected the live swing key, got %+v", swing)
+	}
+
+	// API keys are never cached, so they are not persisted either
+	if len(s.tokenCache) != 0 {
+		t.Errorf("Expected no cached tokens, got %v", s.tokenCache)
+	}
+	if err := s.RotateDeviceToken(Alpaca, ""); !errors.Is(err, ErrUnsupportedAccountType) {
+		t.Errorf("Expected ErrUnsupportedAccountType rotating an Alpaca device, got %v", err)
+	}
+}
+
+func TestNewService_AlpacaIsOptional(t *testing.T) {
+	inScratchDir(t)
+	clearAlpacaEnv(t)
+	t.Setenv("ROBINHOOD_USERNAME", "user")
+	t.Setenv("ROBINHOOD_PASSWORD", "secret")
+
+	s, err := NewService("", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := s.GetToken(context.Background(), Alpaca, ""); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Expected ErrUnknownAccount, got %v", err)
+	}
+}
+
+func TestNewService_OnlyAlpaca(t *testing.T) {
+	inScratchDir(t)
+	clearAlpacaEnv(t)
+	t.Setenv("ROBINHOOD_USERNAME", "")
+	t.Setenv("ROBINHOOD_PASSWORD", "")
+	t.Setenv("ALPACA_KEY_ID", "env-key")
+	t.Setenv("ALPACA_SECRET_KEY", "env-secret")
+
+	s, err := NewService("", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := s.GetToken(context.Background(), Alpaca, ""); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, err := s.GetToken(context.Background(), Robinhood, ""); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Expected ErrUnknownAccount for the default Robinhood account, got %v", err)
+	}
+
+	// Without any account there is nothing to serve
+	t.Setenv("ALPACA_KEY_ID", "")
+	t.Setenv("ALPACA_SECRET_KEY", "")
+	if _, err := NewService("", nil); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials without any account, got %v", err)
+	}
+}
+
+func TestGetToken_AlpacaMetrics(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	s := &Service{
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Alpaca): {username: "key", password: "secret", endpoint: AlpacaPaperURL},
+		},
+	}
+	s.SetMetrics(metrics)
+
+	if _, err := s.GetToken(context.Background(), Alpaca, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.cacheHits); got != 1 {
+		t.Errorf("Expected 1 cache hit, got %v", got)
+	}
+}
+
+func TestGetReadOnlyToken_Alpaca(t *testing.T) {
+	s := &Service{
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Alpaca): {username: "key", password: "secret", endpoint: AlpacaPaperURL},
+		},
+	}
+
+	_, err := s.GetReadOnlyToken(context.Background(), Alpaca, "")
+	if !errors.Is(err, ErrUnsupportedAccountType) || !strings.Contains(err.Error(), AuthAPIKey) {
+		t.Errorf("Expected read-only tokens to be unsupported for API keys, got %v", err)
+	}
+}
+
+func TestResolveCredentials_AlpacaSecrets(t *testing.T) {
+	clearAlpacaEnv(t)
+	secrets := mapSecrets{"alpaca_swing_key_id": "secret-key", "alpaca_swing_secret_key": "secret-secret"}
+
+	creds, source, err := resolveCredentials(Account{Type: Alpaca, Label: "swing"}, secrets, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if creds.username != "secret-key" || creds.password != "secret-secret" {
+		t.Errorf("Expected the key from the secrets, got %+v", creds)
+	}
+	if source != SourceSecrets {
+		t.Errorf("Expected source %s, got %s", SourceSecrets, source)
+	}
+
+	if _, _, err := resolveCredentials(Account{Type: Alpaca, Label: "swing"}, mapSecrets{"alpaca_swing_key_id": "secret-key"}, nil); err == nil {
+		t.Error("Expected an error for a key ID without a secret key")
+	}
+}
+
+func TestAlpacaEndpoint(t *testing.T) {
+	cfg := &config{Alpaca: alpacaConfig{KeyID: "key", SecretKey: "secret", Live: true}}
+
+	tests := []struct {
+		name      string
+		env       string
+		cfg       *config
+		expected  string
+		expectErr bool
+	}{
+		{name: "paper by default", expected: AlpacaPaperURL},
+		{name: "live in the config", cfg: cfg, expected: AlpacaLiveURL},
+		{name: "env over config", env: "false", cfg: cfg, expected: AlpacaPaperURL},
+		{name: "live in the env", env: "true", expected: AlpacaLiveURL},
+		{name: "invalid env", env: "yes please", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALPACA_LIVE", tt.env)
+
+			endpoint, err := alpacaEndpoint(DefaultAccount(Alpaca), tt.cfg)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %s", endpoint)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if endpoint != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, endpoint)
+			}
+		})
+	}
+}
+
+func TestHandler_GetTokenResponseShapes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Service{
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {AccessToken: "robinhood-token", ExpiresAt: time.Now().Add(time.Hour)},
+		},
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "user", password: "secret"},
+			DefaultAccount(Alpaca):    {username: "key", password: "secret", endpoint: AlpacaPaperURL},
+		},
+	}
+	r := gin.New()
+	r.POST("/token", (&Handler{service: s}).GetToken)
+
+	request := func(body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	// Robinhood consumers still find the bearer token where they did
+	code, resp := request(`{"account_type":"robinhood"}`)
+	if code != http.StatusOK || resp["access_token"] != "robinhood-token" || resp["auth_type"] != AuthBearer {
+		t.Errorf("Expected the bearer token, got %d %v", code, resp)
+	}
+	if _, exists := resp["headers"]; exists {
+		t.Errorf("Expected no headers for a bearer token, got %v", resp)
+	}
+
+	code, resp = request(`{"account_type":"alpaca"}`)
+	headers, _ := resp["headers"].(map[string]interface{})
+	if code != http.StatusOK || resp["auth_type"] != AuthAPIKey || headers[AlpacaKeyIDHeader] != "key" || resp["base_url"] != AlpacaPaperURL {
+		t.Errorf("Expected the Alpaca key, got %d %v", code, resp)
+	}
+	if _, exists := resp["access_token"]; exists {
+		t.Errorf("Expected no access token for an API key, got %v", resp)
+	}
+
+	// API keys have no read-only variant
+	if code, resp = request(`{"account_type":"alpaca","read_only":true}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a read-only API key, got %d %v", http.StatusBadRequest, code, resp)
+	}
+}
@@ -0,0 +1,235 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheKeyEnv names the environment variable holding the token cache key, 32
+// base64 encoded bytes, e.g. from `openssl rand -base64 32`. Without it the
+// token cache is only kept in memory.
+const CacheKeyEnv = "TOKEN_CACHE_KEY"
+
+// legacyCacheFile is the unencrypted token cache of earlier versions, next to
+// the encrypted one
+const legacyCacheFile = "token_cache.json"
+
+// ParseCacheKey decodes a base64 encoded AES-256 token cache key
+func ParseCacheKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("token cache key is not base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("token cache key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// cacheKeyFromEnv returns the key in CacheKeyEnv, nil when it is unset
+func cacheKeyFromEnv() ([]byte, error) {
+	encoded := os.Getenv(CacheKeyEnv)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := ParseCacheKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", CacheKeyEnv, err)
+	}
+	return key, nil
+}
+
+// loadTokenCache loads the encrypted token cache from disk. Tokens that
+// expired are skipped, unless they can still be refreshed. Without a cache
+// key nothing is loaded.
+func (s *Service) loadTokenCache() error {
+	if s.cacheKey == nil {
+		return nil
+	}
+
+	sealed, err := os.ReadFile(s.cacheFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		// File doesn't exist yet, which is fine for first run
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read token cache file: %w", err)
+	}
+
+	data, err := openCache(s.cacheKey, sealed)
+	if err != nil {
+		return err
+	}
+
+	var cache tokenCacheFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("failed to parse token cache: %w", err)
+	}
+
+	now := time.Now()
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+
+	for account, token := range cache.Tokens {
+		if token != nil && (now.Before(token.ExpiresAt) || token.RefreshToken != "") {
+			s.tokenCache[account] = token
+		}
+	}
+	if len(cache.Devices) > 0 {
+		s.devices = cache.Devices
+	}
+
+	return nil
+}
+
+// migrateLegacyTokenCache moves the tokens of the unencrypted cache of
+// earlier versions into the encrypted one and deletes the file, so tokens do
+// not stay on disk in plaintext. Tokens already in the encrypted cache win.
+// Without a cache key they are only kept in memory. A legacy file that
+// cannot be parsed is deleted too, as it may still hold tokens.
+func (s *Service) migrateLegacyTokenCache() {
+	path := filepath.Join(filepath.Dir(s.cacheFilePath), legacyCacheFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to read the plaintext token cache, delete it", "path", path, "error", err)
+		return
+	}
+
+	var cache tokenCacheFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		slog.Warn("Deleting unreadable plaintext token cache", "path", path, "error", err)
+	} else {
+		now := time.Now()
+		s.cacheMutex.Lock()
+		for account, token := range cache.Tokens {
+			if _, exists := s.tokenCache[account]; !exists && token != nil && (now.Before(token.ExpiresAt) || token.RefreshToken != "") {
+				s.tokenCache[account] = token
+			}
+		}
+		s.cacheMutex.Unlock()
+		if err := s.saveTokenCache(); err != nil {
+			// Keep the file rather than lose the tokens
+			slog.Error("Failed to migrate the plaintext token cache", "path", path, "error", err)
+			return
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		slog.Error("Failed to delete the plaintext token cache, delete it", "path", path, "error", err)
+		return
+	}
+	if s.cacheKey == nil {
+		slog.Warn("Deleted the plaintext token cache, its tokens are only kept in memory", "path", path)
+		return
+	}
+	slog.Info("Migrated the plaintext token cache", "path", path, "to", s.cacheFilePath)
+}
+
+// saveTokenCache encrypts the token cache and replaces the file atomically,
+// so a crash never leaves a partially written cache. Without a cache key
+// nothing is saved.
+func (s *Service) saveTokenCache() error {
+	if s.cacheKey == nil {
+		return nil
+	}
+
+	s.cacheMutex.RLock()
+	data, err := json.Marshal(tokenCacheFile{Tokens: s.tokenCache, Devices: s.devices})
+	s.cacheMutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal token cache: %w", err)
+	}
+
+	sealed, err := sealCache(s.cacheKey, data)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.cacheFilePath, sealed); err != nil {
+		return fmt.Errorf("failed to write token cache file: %w", err)
+	}
+
+	return nil
+}
+
+// persistTokenCache saves the token cache, only logging a failure
+func (s *Service) persistTokenCache() {
+	if err := s.saveTokenCache(); err != nil {
+		// Not fatal, the cache is still kept in memory
+		slog.Warn("Failed to save token cache", "error", err)
+	}
+}
+
+// sealCache encrypts data with AES-GCM, prefixing the random nonce
+func sealCache(key, data []byte) ([]byte, error) {
+	gcm, err := newCacheCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate token cache nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// openCache decrypts data sealed by sealCache. It fails for a different key
+// and for corrupted data.
+func openCache(key, sealed []byte) ([]byte, error) {
+	gcm, err := newCacheCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("failed to decrypt token cache: file too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	data, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token cache: %w", err)
+	}
+	return data, nil
+}
+
+func newCacheCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token cache key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, readable
+// only by the owner, and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Harmless once renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,295 @@
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCacheKey encrypts the token caches of tests
+var testCacheKey = bytes.Repeat([]byte{7}, 32)
+
+// newPersistingService returns a service for the default Robinhood account
+// persisting its token cache in dir
+func newPersistingService(dir string, key []byte) *Service {
+	return &Service{
+		tokenCache: make(map[Account]*cachedToken),
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+		cacheFilePath: filepath.Join(dir, "token_cache.enc"),
+		cacheKey:      key,
+	}
+}
+
+func TestTokenCache_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	expiresAt := time.Now().Add(time.Hour).Round(0)
+	saved := newPersistingService(dir, testCacheKey)
+	saved.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{
+		AccessToken:  "access-token",
+		RefreshToken: "refresh-token",
+		ExpiresAt:    expiresAt,
+	}
+	saved.devices = map[Account]string{DefaultAccount(Robinhood): "device-token"}
+	if err := saved.saveTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Only the encrypted file is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list cache directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "token_cache.enc" {
+		t.Errorf("Expected only token_cache.enc, got %v", entries)
+	}
+	data, err := os.ReadFile(saved.cacheFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read token cache: %v", err)
+	}
+	if bytes.Contains(data, []byte("access-token")) || bytes.Contains(data, []byte("refresh-token")) {
+		t.Error("Expected the tokens to be encrypted")
+	}
+
+	loaded := newPersistingService(dir, testCacheKey)
+	if err := loaded.loadTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	token := loaded.tokenCache[DefaultAccount(Robinhood)]
+	if token == nil {
+		t.Fatal("Expected the token to be loaded")
+	}
+	if token.AccessToken != "access-token" || token.RefreshToken != "refresh-token" {
+		t.Errorf("Expected the saved tokens, got %+v", token)
+	}
+	if device := loaded.devices[DefaultAccount(Robinhood)]; device != "device-token" {
+		t.Errorf("Expected the saved device token, got %q", device)
+	}
+	if !token.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected expiry %s, got %s", expiresAt, token.ExpiresAt)
+	}
+}
+
+func TestTokenCache_SkipsExpiredTokens(t *testing.T) {
+	dir := t.TempDir()
+	saved := newPersistingService(dir, testCacheKey)
+	saved.tokenCache = map[Account]*cachedToken{
+		{Type: Robinhood, Label: "valid"}:       {AccessToken: "valid", ExpiresAt: time.Now().Add(time.Hour)},
+		{Type: Robinhood, Label: "expired"}:     {AccessToken: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+		{Type: Robinhood, Label: "refreshable"}: {AccessToken: "refreshable", RefreshToken: "refresh-token", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+	if err := saved.saveTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	loaded := newPersistingService(dir, testCacheKey)
+	if err := loaded.loadTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, exists := loaded.tokenCache[Account{Type: Robinhood, Label: "expired"}]; exists {
+		t.Error("Expected the expired token to be skipped")
+	}
+	// An expired token that can be refreshed still saves a login
+	for _, label := range []string{"valid", "refreshable"} {
+		if _, exists := loaded.tokenCache[Account{Type: Robinhood, Label: label}]; !exists {
+			t.Errorf("Expected the %s token to be loaded", label)
+		}
+	}
+}
+
+func TestTokenCache_IgnoresUnreadableFiles(t *testing.T) {
+	otherKey := bytes.Repeat([]byte{9}, 32)
+	sealed, err := sealCache(otherKey, []byte(`{"tokens":{}}`))
+	if err != nil {
+		t.Fatalf("Failed to seal cache: %v", err)
+	}
+	valid, err := sealCache(testCacheKey, []byte(`{"tokens":{"robinhood":{"access_token":"test"}}}`))
+	if err != nil {
+		t.Fatalf("Failed to seal cache: %v", err)
+	}
+	tampered := append([]byte(nil), valid...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "garbage", data: []byte("not a token cache")},
+		{name: "plaintext", data: []byte(`{"tokens":{"robinhood":{"access_token":"test","expires_at":"2099-01-01T00:00:00Z"}}}`)},
+		{name: "other key", data: sealed},
+		{name: "tampered", data: tampered},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPersistingService(t.TempDir(), testCacheKey)
+			if err := os.WriteFile(s.cacheFilePath, tt.data, 0o600); err != nil {
+				t.Fatalf("Failed to write token cache: %v", err)
+			}
+			if err := s.loadTokenCache(); err == nil {
+				t.Error("Expected an error")
+			}
+			if len(s.tokenCache) != 0 {
+				t.Errorf("Expected no tokens, got %d", len(s.tokenCache))
+			}
+		})
+	}
+}
+
+func TestNewService_CorruptTokenCache(t *testing.T) {
+	// The token cache is kept under ./data, so run in a scratch directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	t.Setenv("ROBINHOOD_USERNAME", "user")
+	t.Setenv("ROBINHOOD_PASSWORD", "secret")
+	t.Setenv(CacheKeyEnv, base64.StdEncoding.EncodeToString(testCacheKey))
+	if err := os.MkdirAll("data", 0o755); err != nil {
+		t.Fatalf("Failed to create data directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("data", "token_cache.enc"), []byte("corrupt"), 0o600); err != nil {
+		t.Fatalf("Failed to write token cache: %v", err)
+	}
+
+	s, err := NewService("", nil)
+	if err != nil {
+		t.Fatalf("Expected the corrupt cache to be ignored, got %v", err)
+	}
+	if len(s.tokenCache) != 0 {
+		t.Errorf("Expected no tokens, got %d", len(s.tokenCache))
+	}
+
+	// The next save replaces the corrupt file
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "test", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.saveTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	loaded := newPersistingService("data", testCacheKey)
+	if err := loaded.loadTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	t.Setenv(CacheKeyEnv, "too-short")
+	if _, err := NewService("", nil); err == nil || !strings.Contains(err.Error(), CacheKeyEnv) {
+		t.Errorf("Expected an error naming %s, got %v", CacheKeyEnv, err)
+	}
+}
+
+func TestTokenCache_NotPersistedWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	s := newPersistingService(dir, nil)
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "test", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.saveTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(s.cacheFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected no token cache file, got %v", err)
+	}
+}
+
+func TestMigrateLegacyTokenCache(t *testing.T) {
+	legacy := `{"tokens":{"robinhood":{"access_token":"legacy-token","expires_at":"2999-01-01T00:00:00Z"},"robinhood/joint":{"access_token":"expired","expires_at":"2000-01-01T00:00:00Z"}}}`
+
+	tests := []struct {
+		name      string
+		key       []byte
+		data      string
+		persisted bool
+	}{
+		{name: "with a cache key", key: testCacheKey, data: legacy, persisted: true},
+		{name: "without a cache key", data: legacy},
+		{name: "unreadable", key: testCacheKey, data: "{not json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			legacyPath := filepath.Join(dir, legacyCacheFile)
+			if err := os.WriteFile(legacyPath, []byte(tt.data), 0o600); err != nil {
+				t.Fatalf("Failed to write legacy token cache: %v", err)
+			}
+			s := newPersistingService(dir, tt.key)
+
+			s.migrateLegacyTokenCache()
+			if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+				t.Errorf("Expected the plaintext token cache to be deleted, got %v", err)
+			}
+			if tt.data != legacy {
+				return
+			}
+			if len(s.tokenCache) != 1 || s.tokenCache[DefaultAccount(Robinhood)].AccessToken != "legacy-token" {
+				t.Errorf("Expected the unexpired legacy token, got %v", s.tokenCache)
+			}
+
+			loaded := newPersistingService(dir, testCacheKey)
+			if err := loaded.loadTokenCache(); err != nil && tt.persisted {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if persisted := loaded.tokenCache[DefaultAccount(Robinhood)] != nil; persisted != tt.persisted {
+				t.Errorf("Expected the token persisted encrypted %v, got %v", tt.persisted, persisted)
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyTokenCache_KeepsNewerTokens(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"tokens":{"robinhood":{"access_token":"legacy-token","expires_at":"2999-01-01T00:00:00Z"}}}`
+	if err := os.WriteFile(filepath.Join(dir, legacyCacheFile), []byte(legacy), 0o600); err != nil {
+		t.Fatalf("Failed to write legacy token cache: %v", err)
+	}
+	s := newPersistingService(dir, testCacheKey)
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "current-token", ExpiresAt: time.Now().Add(time.Hour)}
+
+	s.migrateLegacyTokenCache()
+	if token := s.tokenCache[DefaultAccount(Robinhood)]; token.AccessToken != "current-token" {
+		t.Errorf("Expected the encrypted cache's token to win, got %s", token.AccessToken)
+	}
+}
+
+func TestNewService_DeviceTokenWithoutCacheKey(t *testing.T) {
+	inScratchDir(t)
+	clearAlpacaEnv(t)
+	t.Setenv("ROBINHOOD_USERNAME", "user")
+	t.Setenv("ROBINHOOD_PASSWORD", "secret")
+	t.Setenv(CacheKeyEnv, "")
+	t.Setenv(deviceTokenEnv(DefaultAccount(Robinhood)), "")
+
+	// The service starts, keeping the device token in memory
+	s, err := NewService("", nil)
+	if err != nil {
+		t.Fatalf("Expected no error without %s, got %v", CacheKeyEnv, err)
+	}
+	first, err := s.deviceToken(DefaultAccount(Robinhood))
+	if err != nil {
+		t.Fatalf("Expected a device token, got %v", err)
+	}
+	if second, _ := s.deviceToken(DefaultAccount(Robinhood)); second != first {
+		t.Errorf("Expected the device token %s to be reused, got %s", first, second)
+	}
+}
+
+func TestParseCacheKey(t *testing.T) {
+	if _, err := ParseCacheKey(base64.StdEncoding.EncodeToString(testCacheKey)); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, err := ParseCacheKey("not base64!"); err == nil {
+		t.Error("Expected an error for invalid base64")
+	}
+	if _, err := ParseCacheKey(base64.StdEncoding.EncodeToString([]byte("16 bytes is AES1"))); err == nil {
+		t.Error("Expected an error for a 16 byte key")
+	}
+}
@@ -0,0 +1,180 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trade-sonic/robinhood"
+)
+
+// challengeTTL is how long a login waits for its challenge code. Robinhood's
+// codes expire after a few minutes, so a later code would be rejected anyway.
+const challengeTTL = 5 * time.Minute
+
+var (
+	// ErrChallengeNotFound is returned for a challenge handle that is unknown,
+	// already answered or expired
+	ErrChallengeNotFound = errors.New("challenge not found")
+	// ErrInvalidChallengeCode is returned when the broker rejects a challenge
+	// code. The challenge stays pending, so the code can be submitted again.
+	ErrInvalidChallengeCode = errors.New("invalid challenge code")
+)
+
+// ChallengeRequiredError is returned when a login is waiting for an SMS or
+// email code. The code is submitted with SubmitChallengeCode under ID before
+// ExpiresAt.
+type ChallengeRequiredError struct {
+	AccountType AccountType
+	Label       string
+	ID          string
+	// Type is how the code was sent, sms or email
+	Type      string
+	ExpiresAt time.Time
+}
+
+func (e *ChallengeRequiredError) Error() string {
+	account := Account{Type: e.AccountType, Label: e.Label}
+	return fmt.Sprintf("%s login requires the %s challenge code for challenge %s", account, e.Type, e.ID)
+}
+
+// pendingLogin is a login parked until its challenge code is submitted. It
+// keeps what resuming the verification workflow needs.
+type pendingLogin struct {
+	account    Account
+	creds      accountCredentials
+	deviceUUID string
+	// viewURL is the user view of the workflow's inquiry
+	viewURL string
+	// brokerChallengeID is the broker's challenge, not the handle given out
+	brokerChallengeID string
+	challengeType     string
+	expiresAt         time.Time
+}
+
+// isCodeChallenge reports whether a sheriff challenge type is answered with a
+// code rather than approved in the app
+func isCodeChallenge(challengeType string) bool {
+	return challengeType == "sms" || challengeType == "email"
+}
+
+// parkChallenge keeps a login until its challenge code is submitted and
+// returns the ChallengeRequiredError telling the caller so
+func (s *Service) parkChallenge(login *pendingLogin) error {
+	login.expiresAt = time.Now().Add(challengeTTL)
+	handle := uuid.New().String()
+
+	s.challengeMutex.Lock()
+	defer s.challengeMutex.Unlock()
+	s.pruneChallenges()
+	if s.challenges == nil {
+		s.challenges = make(map[string]*pendingLogin)
+	}
+	s.challenges[handle] = login
+
+	return login.required(handle)
+}
+
+// pendingChallenge returns the unexpired challenge of an account, so a new
+// login does not send another code while one is waiting
+func (s *Service) pendingChallenge(account Account) error {
+	s.challengeMutex.Lock()
+	defer s.challengeMutex.Unlock()
+	s.pruneChallenges()
+	for handle, login := range s.challenges {
+		if login.account == account {
+			return login.required(handle)
+		}
+	}
+	return nil
+}
+
+// takeChallenge removes and returns the pending login of a handle, so it is
+// only resumed once at a time
+func (s *Service) takeChallenge(account Account, handle string) (*pendingLogin, error) {
+	s.challengeMutex.Lock()
+	defer s.challengeMutex.Unlock()
+	s.pruneChallenges()
+	login, exists := s.challenges[handle]
+	if !exists || login.account != account {
+		return nil, fmt.Errorf("%w: %s", ErrChallengeNotFound, handle)
+	}
+	delete(s.challenges, handle)
+	return login, nil
+}
+
+// restoreChallenge puts back a login taken by takeChallenge
+func (s *Service) restoreChallenge(handle string, login *pendingLogin) {
+	s.challengeMutex.Lock()
+	defer s.challengeMutex.Unlock()
+	s.challenges[handle] = login
+}
+
+// pruneChallenges drops expired logins. The caller holds challengeMutex.
+func (s *Service) pruneChallenges() {
+	now := time.Now()
+	for handle, login := range s.challenges {
+		if !now.Before(login.expiresAt) {
+			delete(s.challenges, handle)
+		}
+	}
+}
+
+func (l *pendingLogin) required(handle string) *ChallengeRequiredError {
+	return &ChallengeRequiredError{
+		AccountType: l.account.Type,
+		Label:       l.account.Label,
+		ID:          handle,
+		Type:        l.challengeType,
+		ExpiresAt:   l.expiresAt,
+	}
+}
+
+// SubmitChallengeCode answers the challenge a GetToken of the account with
+// the same type and label returned with ChallengeRequiredError, resumes the
+// login and caches its token like GetToken. A rejected code returns
+// ErrInvalidChallengeCode and can be retried until the challenge expires.
+func (s *Service) SubmitChallengeCode(ctx context.Context, accountType AccountType, label, challengeID, code string) (*TokenResponse, error) {
+	account, err := s.account(accountType, label)
+	if err != nil {
+		return nil, err
+	}
+	login, err := s.takeChallenge(account, challengeID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.resumeRobinhoodLogin(ctx, login, code)
+	if errors.Is(err, ErrInvalidChallengeCode) {
+		s.restoreChallenge(challengeID, login)
+	}
+	s.metrics.tokenFetched(accountType, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cacheToken(account, token)
+	return token.response(), nil
+}
+
+// resumeRobinhoodLogin submits the challenge code of a parked login and
+// finishes its verification workflow
+func (s *Service) resumeRobinhoodLogin(ctx context.Context, login *pendingLogin, code string) (*cachedToken, error) {
+	headers := robinhood.BrowserHeaders()
+	respondURL := fmt.Sprintf("/challenge/%s/respond/", login.brokerChallengeID)
+	resp, err := s.makeRequest(ctx, http.MethodPost, respondURL, headers, map[string]interface{}{"response": code})
+	if err != nil {
+		return nil, s.stepFailed(StepChallengeCode, fmt.Errorf("challenge code submission failed: %w", err))
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, s.stepFailed(StepChallengeCode, fmt.Errorf("%w: %v", ErrInvalidChallengeCode, resp.Body))
+	}
+	if status, _ := resp.Body["status"].(string); resp.StatusCode != http.StatusOK || status != "validated" {
+		return nil, s.stepFailed(StepChallengeCode, fmt.Errorf("challenge code submission failed with status %d: %v", resp.StatusCode, resp.Body))
+	}
+
+	return s.finishRobinhoodWorkflow(ctx, login.creds, login.deviceUUID, login.viewURL, headers)
+}
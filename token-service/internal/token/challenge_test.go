@@ -0,0 +1,203 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// smsChallengeResponses are the responses of a login up to Robinhood asking
+// for an SMS code
+func smsChallengeResponses() []mockResponse {
+	return []mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"verification_workflow": map[string]interface{}{"id": "workflow-123"},
+		}),
+		newMockResponse(http.StatusOK, map[string]interface{}{"id": "inquiry-123"}),
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"context": map[string]interface{}{
+				"sheriff_challenge": map[string]interface{}{"id": "challenge-123", "type": "sms"},
+			},
+		}),
+	}
+}
+
+// approvedResponses are the responses of a login after its code was accepted
+func approvedResponses() []mockResponse {
+	return []mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{"status": "validated"}),
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"type_context": map[string]interface{}{"result": "workflow_status_approved"},
+		}),
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"access_token": "new-token",
+			"expires_in":   3600,
+		}),
+	}
+}
+
+func newChallengeService(t *testing.T, responses []mockResponse) (*Service, *mockTransport) {
+	client := newMockClient(responses)
+	s := &Service{
+		client:     client,
+		tokenCache: make(map[Account]*cachedToken),
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+		cacheFilePath: t.TempDir() + "/token_cache.json",
+	}
+	return s, client.Transport.(*mockTransport)
+}
+
+// requireChallenge asserts err is a ChallengeRequiredError and returns it
+func requireChallenge(t *testing.T, err error) *ChallengeRequiredError {
+	t.Helper()
+	var challenge *ChallengeRequiredError
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Expected a ChallengeRequiredError, got %v", err)
+	}
+	return challenge
+}
+
+func TestSubmitChallengeCode_RoundTrip(t *testing.T) {
+	s, transport := newChallengeService(t, append(smsChallengeResponses(), approvedResponses()...))
+
+	_, err := s.GetToken(context.Background(), Robinhood, "")
+	challenge := requireChallenge(t, err)
+	if challenge.Type != "sms" {
+		t.Errorf("Expected challenge type sms, got %s", challenge.Type)
+	}
+	if challenge.ID == "" || challenge.ID == "challenge-123" {
+		t.Errorf("Expected a handle other than the broker's challenge ID, got %q", challenge.ID)
+	}
+	if until := time.Until(challenge.ExpiresAt); until <= 0 || until > challengeTTL {
+		t.Errorf("Expected the challenge to expire within %s, got %s", challengeTTL, until)
+	}
+
+	// Asking again returns the same challenge instead of sending another code
+	_, err = s.GetToken(context.Background(), Robinhood, "")
+	if again := requireChallenge(t, err); again.ID != challenge.ID {
+		t.Errorf("Expected challenge %s, got %s", challenge.ID, again.ID)
+	}
+
+	token, err := s.SubmitChallengeCode(context.Background(), Robinhood, "", challenge.ID, "123456")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "new-token" {
+		t.Errorf("Expected token 'new-token', got %s", token.AccessToken)
+	}
+	if path := transport.paths[3]; path != "/challenge/challenge-123/respond/" {
+		t.Errorf("Expected the code to be sent to the broker's challenge, got %s", path)
+	}
+	if body := transport.bodies[3]; !strings.Contains(body, `"response":"123456"`) {
+		t.Errorf("Expected the code in the request, got %s", body)
+	}
+
+	// The token is cached and the challenge answered
+	if cached, err := s.GetToken(context.Background(), Robinhood, ""); err != nil || cached.AccessToken != "new-token" {
+		t.Errorf("Expected the cached token, got %v, %v", cached, err)
+	}
+	if _, err := s.SubmitChallengeCode(context.Background(), Robinhood, "", challenge.ID, "123456"); !errors.Is(err, ErrChallengeNotFound) {
+		t.Errorf("Expected ErrChallengeNotFound, got %v", err)
+	}
+}
+
+func TestSubmitChallengeCode_InvalidCodeCanBeRetried(t *testing.T) {
+	responses := smsChallengeResponses()
+	responses = append(responses, newMockResponse(http.StatusBadRequest, map[string]interface{}{"detail": "Incorrect code"}))
+	responses = append(responses, approvedResponses()...)
+	s, _ := newChallengeService(t, responses)
+
+	_, err := s.GetToken(context.Background(), Robinhood, "")
+	challenge := requireChallenge(t, err)
+
+	if _, err := s.SubmitChallengeCode(context.Background(), Robinhood, "", challenge.ID, "000000"); !errors.Is(err, ErrInvalidChallengeCode) {
+		t.Fatalf("Expected ErrInvalidChallengeCode, got %v", err)
+	}
+	token, err := s.SubmitChallengeCode(context.Background(), Robinhood, "", challenge.ID, "123456")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "new-token" {
+		t.Errorf("Expected token 'new-token', got %s", token.AccessToken)
+	}
+}
+
+func TestSubmitChallengeCode_Expired(t *testing.T) {
+	s, _ := newChallengeService(t, smsChallengeResponses())
+
+	_, err := s.GetToken(context.Background(), Robinhood, "")
+	challenge := requireChallenge(t, err)
+	s.challenges[challenge.ID].expiresAt = time.Now().Add(-time.Second)
+
+	if _, err := s.SubmitChallengeCode(context.Background(), Robinhood, "", challenge.ID, "123456"); !errors.Is(err, ErrChallengeNotFound) {
+		t.Errorf("Expected ErrChallengeNotFound, got %v", err)
+	}
+	if len(s.challenges) != 0 {
+		t.Errorf("Expected the expired challenge to be dropped, got %d pending", len(s.challenges))
+	}
+}
+
+func TestSubmitChallengeCode_UnknownChallenge(t *testing.T) {
+	s, _ := newChallengeService(t, nil)
+
+	if _, err := s.SubmitChallengeCode(context.Background(), Robinhood, "", "unknown", "123456"); !errors.Is(err, ErrChallengeNotFound) {
+		t.Errorf("Expected ErrChallengeNotFound, got %v", err)
+	}
+}
+
+func TestHandler_ChallengeRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _ := newChallengeService(t, append(smsChallengeResponses(), approvedResponses()...))
+	r := gin.New()
+	h := &Handler{service: s}
+	r.POST("/token", h.GetToken)
+	r.POST("/token/challenge", h.SubmitChallenge)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/token", `{"account_type":"robinhood"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body)
+	}
+	var challenge ChallengeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &challenge); err != nil {
+		t.Fatalf("Expected a challenge response, got %v", err)
+	}
+	if challenge.ChallengeType != "sms" {
+		t.Errorf("Expected challenge type sms, got %s", challenge.ChallengeType)
+	}
+
+	if w := post("/token/challenge", `{"account_type":"robinhood","challenge_id":"unknown","code":"123456"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown challenge, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := post("/token/challenge", `{"account_type":"robinhood","challenge_id":"`+challenge.ChallengeID+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without a code, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = post("/token/challenge", `{"account_type":"robinhood","challenge_id":"`+challenge.ChallengeID+`","code":"123456"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var token TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil {
+		t.Fatalf("Expected a token response, got %v", err)
+	}
+	if token.AccessToken != "new-token" {
+		t.Errorf("Expected token 'new-token', got %s", token.AccessToken)
+	}
+}
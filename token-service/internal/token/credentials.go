@@ -0,0 +1,129 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialSource names where an account's credentials were found
+type CredentialSource string
+
+const (
+	// SourceEnv is the ROBINHOOD_USERNAME and ROBINHOOD_PASSWORD variables,
+	// or ALPACA_KEY_ID and ALPACA_SECRET_KEY
+	SourceEnv CredentialSource = "env"
+	// SourceSecrets is the configured SecretsProvider
+	SourceSecrets CredentialSource = "secrets"
+	// SourceConfig is the config file
+	SourceConfig CredentialSource = "config"
+)
+
+// ErrNoCredentials is returned when no source provides credentials for an
+// account type
+var ErrNoCredentials = errors.New("no credentials")
+
+// SecretsProvider looks up secrets by name, e.g. robinhood_password. ok is
+// false when the secret does not exist.
+type SecretsProvider interface {
+	Secret(name string) (value string, ok bool, err error)
+}
+
+// FileSecrets reads each secret from a file named after it in a directory,
+// like Docker and Kubernetes secret mounts
+type FileSecrets struct {
+	dir string
+}
+
+// NewFileSecrets returns a provider reading secrets from files in dir
+func NewFileSecrets(dir string) *FileSecrets {
+	return &FileSecrets{dir: dir}
+}
+
+// Secret returns the trimmed content of the file named name
+func (f *FileSecrets) Secret(name string) (string, bool, error) {
+	data, err := os.ReadFile(filepath.Join(f.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// credentialNames returns the suffixes of an account type's variable and
+// secret names for the username and the password, the key ID and the secret
+// key for Alpaca
+func credentialNames(accountType AccountType) (string, string) {
+	if accountType == Alpaca {
+		return "_KEY_ID", "_SECRET_KEY"
+	}
+	return "_USERNAME", "_PASSWORD"
+}
+
+// resolveCredentials returns the credentials of an account from the first
+// source providing them: environment variables, then secrets, then the
+// config file. Labeled accounts have the label in their variable and secret
+// names, e.g. ROBINHOOD_JOINT_USERNAME and robinhood_joint_username. secrets
+// and cfg may be nil. A source providing only the username or only the
+// password is an error rather than being skipped, so a half-configured
+// source is noticed.
+func resolveCredentials(account Account, secrets SecretsProvider, cfg *config) (accountCredentials, CredentialSource, error) {
+	prefix := account.envPrefix()
+	usernameSuffix, passwordSuffix := credentialNames(account.Type)
+	usernameEnv, passwordEnv := prefix+usernameSuffix, prefix+passwordSuffix
+	username, password := os.Getenv(usernameEnv), os.Getenv(passwordEnv)
+	if username != "" || password != "" {
+		if username == "" || password == "" {
+			return accountCredentials{}, "", fmt.Errorf("%s and %s must be set together", usernameEnv, passwordEnv)
+		}
+		return accountCredentials{username: username, password: password}, SourceEnv, nil
+	}
+
+	if secrets != nil {
+		usernameSecret, passwordSecret := strings.ToLower(usernameEnv), strings.ToLower(passwordEnv)
+		username, hasUsername, err := secrets.Secret(usernameSecret)
+		if err != nil {
+			return accountCredentials{}, "", err
+		}
+		password, hasPassword, err := secrets.Secret(passwordSecret)
+		if err != nil {
+			return accountCredentials{}, "", err
+		}
+		if hasUsername || hasPassword {
+			if username == "" || password == "" {
+				return accountCredentials{}, "", fmt.Errorf("secrets %s and %s must both be set", usernameSecret, passwordSecret)
+			}
+			return accountCredentials{username: username, password: password}, SourceSecrets, nil
+		}
+	}
+
+	if cfg != nil {
+		if creds, ok := cfg.credentials(account); ok {
+			return creds, SourceConfig, nil
+		}
+	}
+
+	return accountCredentials{}, "", fmt.Errorf("%w for %s: set %s and %s, provide secrets or add them to the config file", ErrNoCredentials, account, usernameEnv, passwordEnv)
+}
+
+// credentials returns the credentials of an account in the config, false
+// when they are missing. The robinhood and alpaca sections hold the default
+// accounts of their type, the accounts list any account.
+func (c *config) credentials(account Account) (accountCredentials, bool) {
+	if account == DefaultAccount(Robinhood) && c.Robinhood.complete() {
+		return c.Robinhood.credentials(), true
+	}
+	if account == DefaultAccount(Alpaca) && c.Alpaca.complete() {
+		return c.Alpaca.credentials(), true
+	}
+	for _, entry := range c.Accounts {
+		if entry.account() == account {
+			return entry.credentials(), entry.complete()
+		}
+	}
+	return accountCredentials{}, false
+}
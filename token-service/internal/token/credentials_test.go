@@ -0,0 +1,96 @@
+package token
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mapSecrets is a SecretsProvider backed by a map
+type mapSecrets map[string]string
+
+func (m mapSecrets) Secret(name string) (string, bool, error) {
+	value, ok := m[name]
+	return value, ok, nil
+}
+
+func TestResolveCredentials(t *testing.T) {
+	fileConfig := &config{}
+	fileConfig.Robinhood.Username = "config-user"
+	fileConfig.Robinhood.Password = "config-pass"
+	secrets := mapSecrets{"robinhood_username": "secret-user", "robinhood_password": "secret-pass"}
+
+	tests := []struct {
+		name             string
+		envUsername      string
+		envPassword      string
+		secrets          SecretsProvider
+		cfg              *config
+		expectedUsername string
+		expectedSource   CredentialSource
+		expectErr        bool
+	}{
+		{name: "env only", envUsername: "env-user", envPassword: "env-pass", expectedUsername: "env-user", expectedSource: SourceEnv},
+		{name: "env over secrets and config", envUsername: "env-user", envPassword: "env-pass", secrets: secrets, cfg: fileConfig, expectedUsername: "env-user", expectedSource: SourceEnv},
+		{name: "secrets only", secrets: secrets, expectedUsername: "secret-user", expectedSource: SourceSecrets},
+		{name: "secrets over config", secrets: secrets, cfg: fileConfig, expectedUsername: "secret-user", expectedSource: SourceSecrets},
+		{name: "config only", cfg: fileConfig, expectedUsername: "config-user", expectedSource: SourceConfig},
+		{name: "config behind empty secrets", secrets: mapSecrets{}, cfg: fileConfig, expectedUsername: "config-user", expectedSource: SourceConfig},
+		{name: "env username without password", envUsername: "env-user", cfg: fileConfig, expectErr: true},
+		{name: "secret password without username", secrets: mapSecrets{"robinhood_password": "secret-pass"}, cfg: fileConfig, expectErr: true},
+		{name: "config without password", cfg: &config{}, expectErr: true},
+		{name: "nothing", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ROBINHOOD_USERNAME", tt.envUsername)
+			t.Setenv("ROBINHOOD_PASSWORD", tt.envPassword)
+
+			creds, source, err := resolveCredentials(DefaultAccount(Robinhood), tt.secrets, tt.cfg)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got credentials from %s", source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if creds.username != tt.expectedUsername {
+				t.Errorf("Expected username %q, got %q", tt.expectedUsername, creds.username)
+			}
+			if source != tt.expectedSource {
+				t.Errorf("Expected source %s, got %s", tt.expectedSource, source)
+			}
+		})
+	}
+}
+
+func TestResolveCredentials_NoneIsErrNoCredentials(t *testing.T) {
+	t.Setenv("ROBINHOOD_USERNAME", "")
+	t.Setenv("ROBINHOOD_PASSWORD", "")
+
+	_, _, err := resolveCredentials(DefaultAccount(Robinhood), mapSecrets{}, nil)
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestFileSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "robinhood_password"), []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	secrets := NewFileSecrets(dir)
+
+	value, ok, err := secrets.Secret("robinhood_password")
+	if err != nil || !ok || value != "s3cret" {
+		t.Errorf("Expected the trimmed secret, got %q, %v, %v", value, ok, err)
+	}
+
+	if _, ok, err := secrets.Secret("robinhood_username"); err != nil || ok {
+		t.Errorf("Expected a missing secret, got %v, %v", ok, err)
+	}
+}
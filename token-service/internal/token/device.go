@@ -0,0 +1,82 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// ErrDeviceTokenPinned is returned when rotating a device token that is set
+// in the environment
+var ErrDeviceTokenPinned = errors.New("device token pinned in the environment")
+
+// deviceTokenEnv names the variable pinning the device token of an account,
+// e.g. ROBINHOOD_DEVICE_TOKEN, or ROBINHOOD_JOINT_DEVICE_TOKEN for the
+// account labeled joint
+func deviceTokenEnv(account Account) string {
+	return account.envPrefix() + "_DEVICE_TOKEN"
+}
+
+// deviceToken returns the device token logins of an account identify with.
+// Robinhood asks to verify every new device, so the token is generated once
+// and then reused, persisted with the token cache. A token pinned in the
+// environment takes precedence.
+func (s *Service) deviceToken(account Account) (string, error) {
+	env := deviceTokenEnv(account)
+	if pinned := os.Getenv(env); pinned != "" {
+		if _, err := uuid.Parse(pinned); err != nil {
+			return "", fmt.Errorf("invalid %s, expected a UUID: %w", env, err)
+		}
+		return pinned, nil
+	}
+
+	s.cacheMutex.Lock()
+	device, exists := s.devices[account]
+	if !exists {
+		if s.devices == nil {
+			s.devices = make(map[Account]string)
+		}
+		device = uuid.New().String()
+		s.devices[account] = device
+	}
+	s.cacheMutex.Unlock()
+
+	if !exists {
+		slog.Info("Generated a new device token", "account_type", account.Type, "label", account.Label)
+		s.persistTokenCache()
+	}
+	return device, nil
+}
+
+// RotateDeviceToken replaces the device token of an account, see GetToken,
+// with a new one, e.g. when the device was removed from the broker account.
+// The next login goes through device verification again. Cached tokens are
+// kept. Alpaca accounts have no device token.
+func (s *Service) RotateDeviceToken(accountType AccountType, label string) error {
+	account, err := s.account(accountType, label)
+	if err != nil {
+		return err
+	}
+	if account.Type == Alpaca {
+		return fmt.Errorf("%w: %s accounts have no device token", ErrUnsupportedAccountType, account.Type)
+	}
+	if env := deviceTokenEnv(account); os.Getenv(env) != "" {
+		return fmt.Errorf("%w: unset %s to rotate it", ErrDeviceTokenPinned, env)
+	}
+
+	s.cacheMutex.Lock()
+	if s.devices == nil {
+		s.devices = make(map[Account]string)
+	}
+	s.devices[account] = uuid.New().String()
+	s.cacheMutex.Unlock()
+
+	slog.Info("Rotated the device token", "account_type", account.Type, "label", account.Label)
+	if err := s.saveTokenCache(); err != nil {
+		return fmt.Errorf("failed to persist rotated device token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// directTokenResponse is a login that needs no device verification
+func directTokenResponse() mockResponse {
+	return newMockResponse(http.StatusOK, map[string]interface{}{
+		"access_token": "test-token",
+		"expires_in":   3600,
+	})
+}
+
+// sentDeviceToken returns the device_token of a recorded token request
+func sentDeviceToken(t *testing.T, body string) string {
+	t.Helper()
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("Expected a JSON request body, got %q", body)
+	}
+	device, _ := payload["device_token"].(string)
+	return device
+}
+
+func TestFetchRobinhoodToken_ReusesDeviceToken(t *testing.T) {
+	t.Setenv("ROBINHOOD_DEVICE_TOKEN", "")
+	client := newMockClient([]mockResponse{directTokenResponse(), directTokenResponse()})
+	transport := client.Transport.(*mockTransport)
+	dir := t.TempDir()
+	s := newPersistingService(dir, testCacheKey)
+	s.client = client
+
+	creds := accountCredentials{username: "test", password: "test"}
+	for i := 0; i < 2; i++ {
+		if _, err := s.fetchRobinhoodToken(context.Background(), DefaultAccount(Robinhood), creds); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	first, second := sentDeviceToken(t, transport.bodies[0]), sentDeviceToken(t, transport.bodies[1])
+	if first == "" || first != second {
+		t.Errorf("Expected the same device token in both logins, got %q and %q", first, second)
+	}
+
+	// It survives a restart
+	loaded := newPersistingService(dir, testCacheKey)
+	if err := loaded.loadTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if device, err := loaded.deviceToken(DefaultAccount(Robinhood)); err != nil || device != first {
+		t.Errorf("Expected the persisted device token %q, got %q, %v", first, device, err)
+	}
+}
+
+func TestDeviceToken_Pinned(t *testing.T) {
+	const pinned = "0b4c2d6e-8f1a-4b3c-9d5e-7f6a8b9c0d1e"
+	t.Setenv("ROBINHOOD_DEVICE_TOKEN", pinned)
+	client := newMockClient([]mockResponse{directTokenResponse()})
+	s := &Service{
+		client: client,
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+	}
+
+	if _, err := s.fetchRobinhoodToken(context.Background(), DefaultAccount(Robinhood), accountCredentials{username: "test", password: "test"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if device := sentDeviceToken(t, client.Transport.(*mockTransport).bodies[0]); device != pinned {
+		t.Errorf("Expected the pinned device token, got %q", device)
+	}
+	if err := s.RotateDeviceToken(Robinhood, ""); !errors.Is(err, ErrDeviceTokenPinned) {
+		t.Errorf("Expected ErrDeviceTokenPinned, got %v", err)
+	}
+
+	t.Setenv("ROBINHOOD_DEVICE_TOKEN", "not-a-uuid")
+	if _, err := s.deviceToken(DefaultAccount(Robinhood)); err == nil || !strings.Contains(err.Error(), "ROBINHOOD_DEVICE_TOKEN") {
+		t.Errorf("Expected an error naming ROBINHOOD_DEVICE_TOKEN, got %v", err)
+	}
+}
+
+func TestRotateDeviceToken(t *testing.T) {
+	t.Setenv("ROBINHOOD_DEVICE_TOKEN", "")
+	dir := t.TempDir()
+	s := newPersistingService(dir, testCacheKey)
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "test", ExpiresAt: time.Now().Add(time.Hour)}
+
+	before, err := s.deviceToken(DefaultAccount(Robinhood))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := s.RotateDeviceToken(Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	after, err := s.deviceToken(DefaultAccount(Robinhood))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if after == before {
+		t.Error("Expected a new device token")
+	}
+	if _, exists := s.tokenCache[DefaultAccount(Robinhood)]; !exists {
+		t.Error("Expected the cached token to be kept")
+	}
+
+	// Clearing the token cache keeps the device
+	if err := s.ClearToken(Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	loaded := newPersistingService(dir, testCacheKey)
+	if err := loaded.loadTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if device := loaded.devices[DefaultAccount(Robinhood)]; device != after {
+		t.Errorf("Expected the rotated device token %q to be persisted, got %q", after, device)
+	}
+}
+
+func TestHandler_RotateDevice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newPersistingService(t.TempDir(), testCacheKey)
+	r := gin.New()
+	r.POST("/token/device/rotate", (&Handler{service: s}).RotateDevice)
+
+	rotate := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/token/device/rotate", strings.NewReader(`{"account_type":"robinhood"}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Setenv("ROBINHOOD_DEVICE_TOKEN", "")
+	if code := rotate(); code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, code)
+	}
+	t.Setenv("ROBINHOOD_DEVICE_TOKEN", "0b4c2d6e-8f1a-4b3c-9d5e-7f6a8b9c0d1e")
+	if code := rotate(); code != http.StatusConflict {
+		t.Errorf("Expected status %d when pinned, got %d", http.StatusConflict, code)
+	}
+}
@@ -1,9 +1,13 @@
 package token
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
 )
 
 type Handler struct {
@@ -12,32 +16,204 @@ type Handler struct {
 
 type TokenRequest struct {
 	AccountType AccountType `json:"account_type" binding:"required"`
+	// Label selects one of several accounts of the type, the default
+	// account when empty
+	Label string `json:"label"`
+	// ReadOnly returns the read-only secondary token as the access token
+	ReadOnly bool `json:"read_only"`
+	// ForceRefresh discards the cached token, e.g. after the broker rejected it
+	ForceRefresh bool `json:"force_refresh"`
 }
 
-func NewHandler() (*Handler, error) {
-	service, err := NewService()
+// ChallengeRequest submits the code of an SMS or email login challenge
+type ChallengeRequest struct {
+	AccountType AccountType `json:"account_type" binding:"required"`
+	Label       string      `json:"label"`
+	// ChallengeID is the challenge_id of the 202 response to the token request
+	ChallengeID string `json:"challenge_id" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+}
+
+// ChallengeResponse tells the caller a login waits for a challenge code
+type ChallengeResponse struct {
+	ChallengeID   string    `json:"challenge_id"`
+	ChallengeType string    `json:"challenge_type"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// RevokeRequest revokes the cached tokens of an account
+type RevokeRequest struct {
+	AccountType AccountType `json:"account_type" binding:"required"`
+	Label       string      `json:"label"`
+}
+
+// DeviceRequest selects the account whose device token is rotated
+type DeviceRequest struct {
+	AccountType AccountType `json:"account_type" binding:"required"`
+	Label       string      `json:"label"`
+}
+
+// NewHandler creates a handler for a new Service, see NewService, recording
+// its metrics in metrics, which may be nil
+func NewHandler(configPath string, secrets SecretsProvider, metrics *Metrics) (*Handler, error) {
+	service, err := NewService(configPath, secrets)
 	if err != nil {
 		return nil, err
 	}
+	service.SetMetrics(metrics)
 
 	return &Handler{
 		service: service,
 	}, nil
 }
 
-// GetToken returns a token for the specified account type
+// GetToken returns a token for the specified account
 func (h *Handler) GetToken(c *gin.Context) {
 	var req TokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	resp, err := h.service.GetToken(req.AccountType)
+	if req.ForceRefresh {
+		h.service.InvalidateToken(req.AccountType, req.Label)
+	}
+
+	var resp *TokenResponse
+	var err error
+	if req.ReadOnly {
+		resp, err = h.service.GetReadOnlyToken(c.Request.Context(), req.AccountType, req.Label)
+	} else {
+		resp, err = h.service.GetToken(c.Request.Context(), req.AccountType, req.Label)
+	}
+	var challenge *ChallengeRequiredError
+	if errors.As(err, &challenge) {
+		c.JSON(http.StatusAccepted, ChallengeResponse{
+			ChallengeID:   challenge.ID,
+			ChallengeType: challenge.Type,
+			ExpiresAt:     challenge.ExpiresAt,
+		})
+		return
+	}
+	if errors.Is(err, ErrUnknownAccount) {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, ErrUnsupportedAccountType) {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// SubmitChallenge submits the code of a login challenge and returns the
+// token the login was waiting for
+func (h *Handler) SubmitChallenge(c *gin.Context) {
+	var req ChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	resp, err := h.service.SubmitChallengeCode(c.Request.Context(), req.AccountType, req.Label, req.ChallengeID, req.Code)
+	switch {
+	case errors.Is(err, ErrChallengeNotFound), errors.Is(err, ErrUnknownAccount):
+		respondError(c, http.StatusNotFound, err)
+		return
+	case errors.Is(err, ErrInvalidChallengeCode):
+		respondError(c, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeToken revokes the cached tokens of an account with the broker and
+// drops them from the cache
+func (h *Handler) RevokeToken(c *gin.Context) {
+	var req RevokeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.service.RevokeToken(c.Request.Context(), req.AccountType, req.Label)
+	switch {
+	case errors.Is(err, ErrUnknownAccount):
+		respondError(c, http.StatusNotFound, err)
+		return
+	case err != nil:
+		respondError(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ClearToken drops the cached tokens of the account type in the path, and
+// the label query parameter, without revoking them
+func (h *Handler) ClearToken(c *gin.Context) {
+	accountType, err := ParseAccountType(c.Param("account_type"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.service.ClearToken(accountType, c.Query("label"))
+	switch {
+	case errors.Is(err, ErrUnknownAccount):
+		respondError(c, http.StatusNotFound, err)
+		return
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RotateDevice replaces the device token logins identify with, see
+// Service.RotateDeviceToken
+func (h *Handler) RotateDevice(c *gin.Context) {
+	var req DeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.service.RotateDeviceToken(req.AccountType, req.Label)
+	switch {
+	case errors.Is(err, ErrUnknownAccount):
+		respondError(c, http.StatusNotFound, err)
+		return
+	case errors.Is(err, ErrUnsupportedAccountType):
+		respondError(c, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrDeviceTokenPinned):
+		respondError(c, http.StatusConflict, err)
+		return
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondError writes an error response carrying the request ID, logging
+// server side failures under it
+func respondError(c *gin.Context, status int, err error) {
+	ctx := c.Request.Context()
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "Request failed", "path", c.Request.URL.Path, "error", err)
+	}
+	c.JSON(status, gin.H{"error": err.Error(), "request_id": logging.RequestID(ctx)})
+}
@@ -0,0 +1,102 @@
+package token
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Steps of the Robinhood verification workflow, used as the step label of
+// workflow failures
+const (
+	StepInitialToken        = "initial_token"
+	StepMachineVerification = "machine_verification"
+	StepUserView            = "user_view"
+	StepPromptStatus        = "prompt_status"
+	StepChallengeCode       = "challenge_code"
+	StepWorkflowStatus      = "workflow_status"
+	StepFinalToken          = "final_token"
+)
+
+// Metrics records how token minting behaves. A nil *Metrics records
+// nothing, so a Service works without one.
+type Metrics struct {
+	tokenFetches     *prometheus.CounterVec
+	cacheHits        prometheus.Counter
+	cacheMisses      prometheus.Counter
+	workflowFailures *prometheus.CounterVec
+	pollAttempts     prometheus.Counter
+}
+
+// NewMetrics creates the token service metrics and registers them with reg
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		tokenFetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "token_service",
+			Name:      "token_fetches_total",
+			Help:      "Tokens fetched from a broker, by account type and result.",
+		}, []string{"account_type", "result"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "token_service",
+			Name:      "cache_hits_total",
+			Help:      "Token requests served from the cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "token_service",
+			Name:      "cache_misses_total",
+			Help:      "Token requests that found no valid cached token.",
+		}),
+		workflowFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "token_service",
+			Name:      "workflow_step_failures_total",
+			Help:      "Verification workflow failures, by step.",
+		}, []string{"step"}),
+		pollAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "token_service",
+			Name:      "challenge_poll_attempts_total",
+			Help:      "Challenge status polls made while waiting for the user to approve a login.",
+		}),
+	}
+	reg.MustRegister(m.tokenFetches, m.cacheHits, m.cacheMisses, m.workflowFailures, m.pollAttempts)
+	return m
+}
+
+func (m *Metrics) cacheHit() {
+	if m != nil {
+		m.cacheHits.Inc()
+	}
+}
+
+func (m *Metrics) cacheMiss() {
+	if m != nil {
+		m.cacheMisses.Inc()
+	}
+}
+
+// tokenFetched records a broker token fetch and whether it succeeded or is
+// waiting for a challenge code
+func (m *Metrics) tokenFetched(accountType AccountType, err error) {
+	if m == nil {
+		return
+	}
+	var challenge *ChallengeRequiredError
+	result := "success"
+	if errors.As(err, &challenge) {
+		result = "challenge"
+	} else if err != nil {
+		result = "error"
+	}
+	m.tokenFetches.WithLabelValues(string(accountType), result).Inc()
+}
+
+func (m *Metrics) workflowStepFailed(step string) {
+	if m != nil {
+		m.workflowFailures.WithLabelValues(step).Inc()
+	}
+}
+
+func (m *Metrics) pollAttempted() {
+	if m != nil {
+		m.pollAttempts.Inc()
+	}
+}
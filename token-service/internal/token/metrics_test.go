@@ -0,0 +1,86 @@
+package token
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics_CacheHitAndMiss(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	s := &Service{
+		client: newMockClient([]mockResponse{
+			newMockResponse(http.StatusOK, map[string]interface{}{
+				"access_token": "new-token",
+				"expires_in":   3600,
+			}),
+		}),
+		tokenCache: make(map[Account]*cachedToken),
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+		cacheFilePath: t.TempDir() + "/token_cache.json",
+	}
+	s.SetMetrics(metrics)
+
+	// The first call finds nothing cached and fetches a token
+	if _, err := s.GetToken(context.Background(), Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.cacheMisses); got != 1 {
+		t.Errorf("Expected 1 cache miss, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.cacheHits); got != 0 {
+		t.Errorf("Expected 0 cache hits, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.tokenFetches.WithLabelValues(string(Robinhood), "success")); got != 1 {
+		t.Errorf("Expected 1 successful fetch, got %v", got)
+	}
+
+	// The second is served from the cache
+	if _, err := s.GetToken(context.Background(), Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.cacheMisses); got != 1 {
+		t.Errorf("Expected 1 cache miss, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.cacheHits); got != 1 {
+		t.Errorf("Expected 1 cache hit, got %v", got)
+	}
+}
+
+func TestMetrics_WorkflowStepFailure(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	s := &Service{
+		client: newMockClient([]mockResponse{
+			newMockResponse(http.StatusOK, map[string]interface{}{
+				"verification_workflow": map[string]interface{}{"id": "workflow-123"},
+			}),
+			newMockResponse(http.StatusOK, map[string]interface{}{"id": "inquiry-123"}),
+			newMockResponse(http.StatusOK, map[string]interface{}{
+				"context": map[string]interface{}{
+					"sheriff_challenge": map[string]interface{}{"id": "challenge-123"},
+				},
+			}),
+			// The user denied the login
+			newMockResponse(http.StatusOK, map[string]interface{}{"challenge_status": "failed"}),
+		}),
+		metrics: metrics,
+	}
+
+	if _, err := s.fetchRobinhoodToken(context.Background(), DefaultAccount(Robinhood), accountCredentials{username: "test", password: "test"}); err == nil {
+		t.Fatal("Expected an error for a failed challenge")
+	}
+	if got := testutil.ToFloat64(metrics.pollAttempts); got != 1 {
+		t.Errorf("Expected 1 poll attempt, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.workflowFailures.WithLabelValues(StepPromptStatus)); got != 1 {
+		t.Errorf("Expected 1 prompt status failure, got %v", got)
+	}
+	if got := testutil.CollectAndCount(metrics.workflowFailures); got != 1 {
+		t.Errorf("Expected failures for 1 step, got %d", got)
+	}
+}
@@ -0,0 +1,129 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trade-sonic/logging"
+	"github.com/trade-sonic/logging/ginlog"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		incoming string
+		adopted  bool
+	}{
+		{name: "adopts the caller's ID", incoming: "positions-5c1d", adopted: true},
+		{name: "generates a missing ID"},
+		{name: "replaces an invalid ID", incoming: "forged\nline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defaultLogger := slog.Default()
+			slog.SetDefault(logging.New(&logs, logging.FormatJSON, nil))
+			t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+			// A login answered without a token fails
+			s := &Service{
+				client:     newMockClient([]mockResponse{newMockResponse(http.StatusOK, map[string]interface{}{})}),
+				tokenCache: make(map[Account]*cachedToken),
+				credentials: map[Account]accountCredentials{
+					DefaultAccount(Robinhood): {username: "test", password: "test"},
+				},
+				devices: map[Account]string{DefaultAccount(Robinhood): "device-token"},
+			}
+			r := gin.New()
+			r.Use(ginlog.RequestIDMiddleware())
+			r.POST("/token", (&Handler{service: s}).GetToken)
+
+			req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"account_type":"robinhood"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.incoming != "" {
+				req.Header.Set(logging.RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusInternalServerError, w.Code, w.Body)
+			}
+
+			id := w.Header().Get(logging.RequestIDHeader)
+			if tt.adopted && id != tt.incoming {
+				t.Errorf("Expected the response to echo %q, got %q", tt.incoming, id)
+			}
+			if !tt.adopted && (id == tt.incoming || !logging.ValidRequestID(id)) {
+				t.Errorf("Expected a generated ID, got %q", id)
+			}
+
+			// The error response names the ID
+			var resp map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected an error response, got %v", err)
+			}
+			if resp["request_id"] != id {
+				t.Errorf("Expected request_id %q in the error response, got %q", id, resp["request_id"])
+			}
+
+			// And so does the failure logged
+			var entry map[string]interface{}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("Expected a JSON log line, got %q", logs.String())
+			}
+			if entry["request_id"] != id {
+				t.Errorf("Expected request_id %q in %v", id, entry)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware_TextLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defaultLogger := slog.Default()
+	flags, writer := log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		log.SetFlags(flags)
+		log.SetOutput(writer)
+	})
+
+	// Set up as the service is with the default LOG_FORMAT
+	var logs bytes.Buffer
+	logging.Setup(&logs, logging.FormatText, slog.LevelInfo)
+
+	// A login answered without a token fails
+	s := &Service{
+		client:     newMockClient([]mockResponse{newMockResponse(http.StatusOK, map[string]interface{}{})}),
+		tokenCache: make(map[Account]*cachedToken),
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+		devices: map[Account]string{DefaultAccount(Robinhood): "device-token"},
+	}
+	r := gin.New()
+	r.Use(ginlog.RequestIDMiddleware())
+	r.POST("/token", (&Handler{service: s}).GetToken)
+
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"account_type":"robinhood"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, "positions-5c1d")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusInternalServerError, w.Code, w.Body)
+	}
+
+	if line := logs.String(); !strings.Contains(line, `msg="Request failed"`) || !strings.Contains(line, "request_id=positions-5c1d") {
+		t.Errorf("Expected the failure logged with its request_id, got %q", line)
+	}
+}
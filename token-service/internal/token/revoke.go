@@ -0,0 +1,107 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/trade-sonic/robinhood"
+)
+
+// RevokeResult tells which of an account's cached tokens were revoked with
+// the broker. Both are false when nothing was cached.
+type RevokeResult struct {
+	AccessTokenRevoked  bool `json:"access_token_revoked"`
+	RefreshTokenRevoked bool `json:"refresh_token_revoked"`
+}
+
+// RevokeToken revokes the cached access and refresh tokens of an account,
+// see GetToken, with the broker, e.g. when they may have leaked, and then
+// drops them from the cache and its file. When revoking fails the tokens
+// stay cached, so it can be retried. Without cached tokens it does nothing.
+func (s *Service) RevokeToken(ctx context.Context, accountType AccountType, label string) (*RevokeResult, error) {
+	account, err := s.account(accountType, label)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cacheMutex.RLock()
+	token, exists := s.tokenCache[account]
+	s.cacheMutex.RUnlock()
+	result := &RevokeResult{}
+	if !exists {
+		return result, nil
+	}
+
+	if token.AccessToken != "" {
+		if err := s.revokeBrokerToken(ctx, accountType, token.AccessToken); err != nil {
+			return nil, fmt.Errorf("failed to revoke access token: %w", err)
+		}
+		result.AccessTokenRevoked = true
+	}
+	// A leaked refresh token would mint new access tokens
+	if token.RefreshToken != "" {
+		if err := s.revokeBrokerToken(ctx, accountType, token.RefreshToken); err != nil {
+			return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+		}
+		result.RefreshTokenRevoked = true
+	}
+
+	if err := s.ClearToken(accountType, label); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ClearToken drops every cached token of an account, see GetToken,
+// including its refresh token unlike InvalidateToken, and persists the cache
+// so they are gone from disk too. The tokens are not revoked with the broker.
+func (s *Service) ClearToken(accountType AccountType, label string) error {
+	account, err := s.account(accountType, label)
+	if err != nil {
+		return err
+	}
+
+	s.cacheMutex.Lock()
+	_, exists := s.tokenCache[account]
+	delete(s.tokenCache, account)
+	s.cacheMutex.Unlock()
+	if !exists {
+		return nil
+	}
+
+	if err := s.saveTokenCache(); err != nil {
+		return fmt.Errorf("failed to clear persisted token: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) revokeBrokerToken(ctx context.Context, accountType AccountType, token string) error {
+	switch accountType {
+	case Robinhood:
+		return s.revokeRobinhoodToken(ctx, token)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
+	}
+}
+
+// revokeRobinhoodToken revokes an access or refresh token. Robinhood answers
+// with an empty body, so only the status is checked.
+func (s *Service) revokeRobinhoodToken(ctx context.Context, token string) error {
+	api := robinhood.NewClient(robinhood.WithDoer(s.client))
+	status, err := api.Do(ctx, robinhood.Request{
+		Method: http.MethodPost,
+		Path:   "/oauth2/revoke_token/",
+		Payload: map[string]interface{}{
+			"client_id": robinhoodClientID,
+			"token":     token,
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("revoke token request failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("revoke token request failed with status %d", status)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCachedService returns a service with a persisted access and refresh
+// token for Robinhood
+func newCachedService(t *testing.T, responses []mockResponse) (*Service, *mockTransport) {
+	client := newMockClient(responses)
+	s := &Service{
+		client: client,
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {
+				AccessToken:  "access-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    time.Now().Add(time.Hour),
+			},
+		},
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+		cacheFilePath: t.TempDir() + "/token_cache.enc",
+		cacheKey:      testCacheKey,
+	}
+	if err := s.saveTokenCache(); err != nil {
+		t.Fatalf("Failed to persist token cache: %v", err)
+	}
+	return s, client.Transport.(*mockTransport)
+}
+
+// persistedTokens returns the tokens in the service's cache file
+func persistedTokens(t *testing.T, s *Service) map[Account]*cachedToken {
+	t.Helper()
+	sealed, err := os.ReadFile(s.cacheFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read token cache: %v", err)
+	}
+	data, err := openCache(s.cacheKey, sealed)
+	if err != nil {
+		t.Fatalf("Failed to decrypt token cache: %v", err)
+	}
+	var cache tokenCacheFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatalf("Failed to parse token cache: %v", err)
+	}
+	return cache.Tokens
+}
+
+func TestRevokeToken(t *testing.T) {
+	s, transport := newCachedService(t, []mockResponse{
+		newMockResponse(http.StatusOK, nil),
+		newMockResponse(http.StatusOK, nil),
+	})
+
+	result, err := s.RevokeToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !result.AccessTokenRevoked || !result.RefreshTokenRevoked {
+		t.Errorf("Expected both tokens revoked, got %+v", result)
+	}
+
+	if len(transport.bodies) != 2 {
+		t.Fatalf("Expected 2 revoke requests, got %d", len(transport.bodies))
+	}
+	for i, token := range []string{"access-token", "refresh-token"} {
+		if transport.paths[i] != "/oauth2/revoke_token/" {
+			t.Errorf("Expected a revoke request, got %s", transport.paths[i])
+		}
+		if !strings.Contains(transport.bodies[i], `"token":"`+token+`"`) {
+			t.Errorf("Expected %s to be revoked, got %s", token, transport.bodies[i])
+		}
+	}
+
+	if _, exists := s.tokenCache[DefaultAccount(Robinhood)]; exists {
+		t.Error("Expected the token to be dropped from the cache")
+	}
+	if _, exists := persistedTokens(t, s)[DefaultAccount(Robinhood)]; exists {
+		t.Error("Expected the token to be dropped from the cache file")
+	}
+
+	// Revoking again is a no-op
+	result, err = s.RevokeToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.AccessTokenRevoked || result.RefreshTokenRevoked {
+		t.Errorf("Expected nothing revoked, got %+v", result)
+	}
+	if len(transport.bodies) != 2 {
+		t.Errorf("Expected no further requests, got %d", len(transport.bodies)-2)
+	}
+}
+
+func TestRevokeToken_FailureKeepsToken(t *testing.T) {
+	s, _ := newCachedService(t, []mockResponse{
+		newMockResponse(http.StatusUnauthorized, map[string]interface{}{"detail": "Invalid token."}),
+	})
+
+	if _, err := s.RevokeToken(context.Background(), Robinhood, ""); err == nil {
+		t.Fatal("Expected an error for a failed revocation")
+	}
+	if token := s.tokenCache[DefaultAccount(Robinhood)]; token == nil || token.AccessToken != "access-token" {
+		t.Errorf("Expected the token to stay cached, got %+v", token)
+	}
+	if _, exists := persistedTokens(t, s)[DefaultAccount(Robinhood)]; !exists {
+		t.Error("Expected the token to stay in the cache file")
+	}
+}
+
+func TestClearToken(t *testing.T) {
+	s, transport := newCachedService(t, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := s.ClearToken(Robinhood, ""); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	// Unlike InvalidateToken, the refresh token goes too
+	if _, exists := s.tokenCache[DefaultAccount(Robinhood)]; exists {
+		t.Error("Expected the token to be dropped from the cache")
+	}
+	if _, exists := persistedTokens(t, s)[DefaultAccount(Robinhood)]; exists {
+		t.Error("Expected the token to be dropped from the cache file")
+	}
+	if len(transport.bodies) != 0 {
+		t.Errorf("Expected no broker requests, got %d", len(transport.bodies))
+	}
+}
+
+func TestHandler_RevokeAndClear(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _ := newCachedService(t, []mockResponse{
+		newMockResponse(http.StatusOK, nil),
+		newMockResponse(http.StatusOK, nil),
+		newMockResponse(http.StatusInternalServerError, nil),
+	})
+	r := gin.New()
+	h := &Handler{service: s}
+	r.POST("/token/revoke", h.RevokeToken)
+	r.DELETE("/token/cache/:account_type", h.ClearToken)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	revoke := func() *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/token/revoke", `{"account_type":"robinhood"}`)
+	}
+
+	w := revoke()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if expected := `{"access_token_revoked":true,"refresh_token_revoked":true}`; w.Body.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, w.Body)
+	}
+
+	// Nothing is cached anymore
+	w = revoke()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if expected := `{"access_token_revoked":false,"refresh_token_revoked":false}`; w.Body.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, w.Body)
+	}
+
+	// The broker failing is reported as a bad gateway
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "access-token", ExpiresAt: time.Now().Add(time.Hour)}
+	if w := revoke(); w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve(http.MethodDelete, "/token/cache/robinhood", ""); w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+		}
+	}
+	if _, exists := s.tokenCache[DefaultAccount(Robinhood)]; exists {
+		t.Error("Expected the token to be dropped from the cache")
+	}
+	if w := serve(http.MethodDelete, "/token/cache/robinhod", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown account type, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve(http.MethodPost, "/token/revoke", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without an account type, got %d", http.StatusBadRequest, w.Code)
+	}
+}
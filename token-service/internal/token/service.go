@@ -1,69 +1,316 @@
 package token
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/trade-sonic/robinhood"
 )
 
 type AccountType string
 
 const (
 	Robinhood AccountType = "robinhood"
+	// Alpaca accounts authenticate with an API key rather than a token
+	Alpaca AccountType = "alpaca"
 )
 
+// ErrUnsupportedAccountType is returned for account types the service does not handle
+var ErrUnsupportedAccountType = errors.New("unsupported account type")
+
+// ParseAccountType parses an account type case-insensitively, rejecting
+// unsupported values
+func ParseAccountType(s string) (AccountType, error) {
+	switch accountType := AccountType(strings.ToLower(strings.TrimSpace(s))); accountType {
+	case Robinhood, Alpaca:
+		return accountType, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedAccountType, s)
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler so unknown account types are
+// rejected when a request is bound
+func (a *AccountType) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("account type must be a string: %w", err)
+	}
+	accountType, err := ParseAccountType(s)
+	if err != nil {
+		return err
+	}
+	*a = accountType
+	return nil
+}
+
 type cachedToken struct {
-	AccessToken string    `json:"access_token"`
-	ExpiresAt   time.Time `json:"expires_at"`
+	AccessToken         string    `json:"access_token"`
+	ReadOnlyAccessToken string    `json:"read_only_access_token,omitempty"`
+	ExpiresAt           time.Time `json:"expires_at"`
+	// RefreshToken renews the access token once it expired without another
+	// password login
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
-// tokenCache represents the structure of the persisted token cache file
+// tokenCache represents the structure of the persisted token cache file.
+// Accounts are keyed by Account.String.
 type tokenCacheFile struct {
-	Tokens map[AccountType]*cachedToken `json:"tokens"`
+	Tokens map[Account]*cachedToken `json:"tokens"`
+	// Devices are the device tokens logins identify with, see deviceToken
+	Devices map[Account]string `json:"devices,omitempty"`
 }
 
 type Service struct {
 	client        *http.Client
-	tokenCache    map[AccountType]*cachedToken
+	tokenCache    map[Account]*cachedToken
+	devices       map[Account]string // Guarded by cacheMutex, persisted with the tokens
 	cacheMutex    sync.RWMutex
-	credentials   map[AccountType]accountCredentials
+	credentials   map[Account]accountCredentials
 	cacheFilePath string
+	// cacheKey encrypts the token cache file, which is not used when nil
+	cacheKey []byte
+	metrics  *Metrics
+	// challenges are logins waiting for an SMS or email code, by handle
+	challenges     map[string]*pendingLogin
+	challengeMutex sync.Mutex
+	// forcedRefreshes is when InvalidateToken last discarded a token, by
+	// account, guarded by cacheMutex
+	forcedRefreshes map[Account]time.Time
 }
 
+// forceRefreshInterval is how often InvalidateToken discards the token of an
+// account. Every client whose requests the broker rejects asks for a refresh,
+// so without it a burst of rejections turns into a burst of logins.
+const forceRefreshInterval = 30 * time.Second
+
+// accountCredentials are a login's username and password, or the key ID
+// and secret key of an Alpaca account
 type accountCredentials struct {
 	username string
 	password string
+	// endpoint is the API the credentials are for, when the broker has
+	// several
+	endpoint string
 }
 
+// Ways a TokenResponse authenticates requests
+const (
+	// AuthBearer sends AccessToken in the Authorization header
+	AuthBearer = "bearer"
+	// AuthAPIKey sends Headers with every request
+	AuthAPIKey = "api_key"
+)
+
+// TokenResponse carries what authenticates an account's requests: a bearer
+// token, or for brokers using API keys like Alpaca, the headers to send
 type TokenResponse struct {
-	AccessToken string    `json:"access_token"`
-	ExpiresAt   time.Time `json:"expires_at"`
+	AuthType    string `json:"auth_type"`
+	AccessToken string `json:"access_token,omitempty"`
+	// ReadOnlyAccessToken is the secondary token that can read account data
+	// but not trade, when the broker issued one
+	ReadOnlyAccessToken string `json:"read_only_access_token,omitempty"`
+	// Headers are sent as is with AuthAPIKey
+	Headers map[string]string `json:"headers,omitempty"`
+	// BaseURL is the API the credentials are valid for, e.g. Alpaca's paper
+	// or live trading API, when the broker has several
+	BaseURL   string    `json:"base_url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// defaultTokenValidity is assumed when the broker does not say when a token expires
+const defaultTokenValidity = time.Hour
+
+// ErrReadOnlyTokenUnavailable is returned when the broker did not issue a read-only token
+var ErrReadOnlyTokenUnavailable = errors.New("read-only token not available")
+
+// ErrInvalidGrant is returned when the broker rejects a refresh token, e.g.
+// because it expired or was revoked
+var ErrInvalidGrant = errors.New("invalid grant")
+
+// robinhoodClientID identifies the web client to Robinhood's OAuth endpoint
+const robinhoodClientID = "c82SH0WZOsabOXGP2sxqcj34FxkvfnWRZBKlBjFS"
+
 type config struct {
-	Robinhood struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	} `json:"robinhood"`
+	// Robinhood holds the credentials of the default Robinhood account
+	Robinhood credentialConfig `json:"robinhood"`
+	// Alpaca holds the API key of the default Alpaca account
+	Alpaca alpacaConfig `json:"alpaca"`
+	// Accounts lists accounts by label, e.g. a second Robinhood login
+	Accounts []accountConfig `json:"accounts"`
 }
 
-func NewService() (*Service, error) {
-	data, err := os.ReadFile("config.json")
+type credentialConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// accountConfig is an entry of the config's accounts list. An empty label
+// is DefaultLabel. Alpaca accounts set the API key instead of a username
+// and password.
+type accountConfig struct {
+	Label string      `json:"label"`
+	Type  AccountType `json:"type"`
+	credentialConfig
+	alpacaConfig
+}
+
+func (c credentialConfig) complete() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+func (c credentialConfig) credentials() accountCredentials {
+	return accountCredentials{username: c.Username, password: c.Password}
+}
+
+func (e accountConfig) complete() bool {
+	if e.Type == Alpaca {
+		return e.alpacaConfig.complete()
+	}
+	return e.credentialConfig.complete()
+}
+
+func (e accountConfig) credentials() accountCredentials {
+	if e.Type == Alpaca {
+		return e.alpacaConfig.credentials()
+	}
+	return e.credentialConfig.credentials()
+}
+
+func (e accountConfig) account() Account {
+	label := e.Label
+	if label == "" {
+		label = DefaultLabel
+	}
+	return Account{Type: e.Type, Label: label}
+}
+
+// loadConfig reads the credentials config at path
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	var cfg config
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// validate checks the accounts list: every entry needs a type and a valid
+// label, and an account may only be configured once
+func (c *config) validate() error {
+	seen := make(map[Account]bool)
+	if c.Robinhood != (credentialConfig{}) {
+		seen[DefaultAccount(Robinhood)] = true
+	}
+	if c.Alpaca != (alpacaConfig{}) {
+		seen[DefaultAccount(Alpaca)] = true
+	}
+	for i, entry := range c.Accounts {
+		if entry.Type == "" {
+			return fmt.Errorf("accounts[%d]: type is required", i)
+		}
+		if _, err := ParseLabel(entry.Label); err != nil {
+			return fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+		account := entry.account()
+		if seen[account] {
+			return fmt.Errorf("accounts[%d]: %s is configured more than once", i, account)
+		}
+		seen[account] = true
+	}
+	return nil
+}
+
+// accounts returns the accounts to load credentials for: the default account
+// of every supported account type, followed by the labeled ones in the
+// config. cfg may be nil.
+func (c *config) accounts() []Account {
+	accounts := []Account{DefaultAccount(Robinhood), DefaultAccount(Alpaca)}
+	if c == nil {
+		return accounts
+	}
+	for _, entry := range c.Accounts {
+		if account := entry.account(); account.Label != DefaultLabel {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// NewService creates a service for the default account of every supported
+// account type and the labeled accounts in the config, taking credentials
+// from the environment, then secrets, then the config file at configPath.
+// secrets may be nil and configPath empty. It fails when no source provides
+// a labeled account's credentials, and when no account has credentials at
+// all; the default accounts are optional as long as another account is
+// configured, e.g. only an Alpaca key. Robinhood accounts need
+// TOKEN_CACHE_KEY to persist their device token, unless it is pinned in the
+// environment.
+func NewService(configPath string, secrets SecretsProvider) (*Service, error) {
+	var cfg *config
+	if configPath != "" {
+		var err error
+		if cfg, err = loadConfig(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	credentials := make(map[Account]accountCredentials)
+	var missingDefault error
+	for _, account := range cfg.accounts() {
+		creds, source, err := resolveCredentials(account, secrets, cfg)
+		if errors.Is(err, ErrNoCredentials) && account.Label == DefaultLabel {
+			// Fine as long as some other account is configured
+			if missingDefault == nil {
+				missingDefault = fmt.Errorf("failed to load %s credentials: %w", account, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s credentials: %w", account, err)
+		}
+		if account.Type == Alpaca {
+			if creds.endpoint, err = alpacaEndpoint(account, cfg); err != nil {
+				return nil, err
+			}
+		}
+		slog.Info("Loaded broker credentials", "account_type", account.Type, "label", account.Label, "source", source)
+		credentials[account] = creds
+	}
+	if len(credentials) == 0 && missingDefault != nil {
+		return nil, missingDefault
+	}
+
+	cacheKey, err := cacheKeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if cacheKey == nil {
+		// An error, not a warning: every restart then costs a login
+		slog.Error("Token cache is not persisted, tokens are lost on restart and each restart needs a new login; set " + CacheKeyEnv + " to keep them")
+		// A device token generated now only lives in memory, so the device
+		// is verified again after a restart
+		for _, account := range cfg.accounts() {
+			if _, exists := credentials[account]; exists && account.Type == Robinhood && os.Getenv(deviceTokenEnv(account)) == "" {
+				slog.Error("Device token is not persisted, Robinhood verifies the device again after every restart; set "+CacheKeyEnv+" or pin it with "+deviceTokenEnv(account), "account_type", account.Type, "label", account.Label)
+			}
+		}
 	}
 
 	// Ensure data directory exists
@@ -76,283 +323,413 @@ func NewService() (*Service, error) {
 		client: &http.Client{
 			Timeout: time.Second * 30,
 		},
-		tokenCache:    make(map[AccountType]*cachedToken),
-		credentials:   make(map[AccountType]accountCredentials),
-		cacheFilePath: filepath.Join(dataDir, "token_cache.json"),
-	}
-
-	// Load credentials from config
-	s.credentials[Robinhood] = accountCredentials{
-		username: cfg.Robinhood.Username,
-		password: cfg.Robinhood.Password,
+		tokenCache:    make(map[Account]*cachedToken),
+		credentials:   credentials,
+		cacheFilePath: filepath.Join(dataDir, "token_cache.enc"),
+		cacheKey:      cacheKey,
 	}
 
 	// Load cached tokens from file
 	if err := s.loadTokenCache(); err != nil {
-		// Just log the error but continue - it's not fatal if we can't load the cache
-		fmt.Printf("Warning: Failed to load token cache: %v\n", err)
+		// A corrupt cache or one written with another key only costs a login
+		slog.Warn("Ignoring unreadable token cache", "path", s.cacheFilePath, "error", err)
 	}
+	s.migrateLegacyTokenCache()
 
 	return s, nil
 }
 
-// loadTokenCache loads the token cache from disk
-func (s *Service) loadTokenCache() error {
-	// Check if cache file exists
-	if _, err := os.Stat(s.cacheFilePath); os.IsNotExist(err) {
-		// File doesn't exist yet, which is fine for first run
-		return nil
-	}
-
-	data, err := os.ReadFile(s.cacheFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to read token cache file: %w", err)
-	}
-
-	var cache tokenCacheFile
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return fmt.Errorf("failed to parse token cache: %w", err)
-	}
-
-	// Only load tokens that haven't expired yet
-	now := time.Now()
-	s.cacheMutex.Lock()
-	defer s.cacheMutex.Unlock()
-
-	for accountType, token := range cache.Tokens {
-		if now.Before(token.ExpiresAt) {
-			s.tokenCache[accountType] = token
-		}
-	}
-
-	return nil
+// SetMetrics records the service's cache and workflow metrics in m
+func (s *Service) SetMetrics(m *Metrics) {
+	s.metrics = m
 }
 
-// saveTokenCache persists the token cache to disk
-func (s *Service) saveTokenCache() error {
-	s.cacheMutex.RLock()
-	cache := tokenCacheFile{
-		Tokens: s.tokenCache,
-	}
-	s.cacheMutex.RUnlock()
-
-	data, err := json.Marshal(cache)
+// GetToken returns a valid token for the account of the specified type and
+// label, the default account when label is empty. Accounts without
+// configured credentials return ErrUnknownAccount. An expired token is
+// renewed with its refresh token when it has one, and with a password login
+// otherwise or when the refresh token was rejected. A login needing an SMS
+// or email code returns a ChallengeRequiredError, also while that code has
+// not been submitted with SubmitChallengeCode. Alpaca accounts return their
+// API key, see alpacaToken, which counts as a cache hit.
+func (s *Service) GetToken(ctx context.Context, accountType AccountType, label string) (*TokenResponse, error) {
+	account, err := s.account(accountType, label)
 	if err != nil {
-		return fmt.Errorf("failed to marshal token cache: %w", err)
+		return nil, err
 	}
-
-	if err := os.WriteFile(s.cacheFilePath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write token cache file: %w", err)
+	if account.Type == Alpaca {
+		s.metrics.cacheHit()
+		return s.alpacaToken(account), nil
 	}
 
-	return nil
-}
-
-// GetToken returns a valid token for the specified account type
-func (s *Service) GetToken(accountType AccountType) (*TokenResponse, error) {
 	// Check if we have a valid cached token
+	var refreshToken string
 	s.cacheMutex.RLock()
-	if token, exists := s.tokenCache[accountType]; exists {
+	if token, exists := s.tokenCache[account]; exists {
 		if time.Now().Before(token.ExpiresAt) {
 			s.cacheMutex.RUnlock()
-			return &TokenResponse{
-				AccessToken: token.AccessToken,
-				ExpiresAt:   token.ExpiresAt,
-			}, nil
+			s.metrics.cacheHit()
+			return token.response(), nil
 		}
+		refreshToken = token.RefreshToken
 	}
 	s.cacheMutex.RUnlock()
+	s.metrics.cacheMiss()
 
 	// Get credentials
 	s.cacheMutex.RLock()
-	creds, exists := s.credentials[accountType]
+	creds := s.credentials[account]
 	s.cacheMutex.RUnlock()
-	if !exists {
-		return nil, fmt.Errorf("no credentials found for account type: %s", accountType)
-	}
 
 	// Get new token
-	token, expiresAt, err := s.fetchNewToken(accountType, creds)
+	token, err := s.renewToken(ctx, account, creds, refreshToken)
+	s.metrics.tokenFetched(accountType, err)
 	if err != nil {
 		return nil, err
 	}
 
-	// Cache the token
+	s.cacheToken(account, token)
+	return token.response(), nil
+}
+
+// cacheToken caches a new token and persists the cache
+func (s *Service) cacheToken(account Account, token *cachedToken) {
+	s.cacheMutex.Lock()
+	s.tokenCache[account] = token
+	s.cacheMutex.Unlock()
+
+	s.persistTokenCache()
+}
+
+// InvalidateToken discards the cached token of an account, see GetToken, so
+// the next GetToken fetches a new one. Its refresh token is kept for that.
+// The token is only discarded once per forceRefreshInterval, later calls
+// keep the token the last one led to. The cache is persisted, so a restart
+// does not bring the discarded token back.
+func (s *Service) InvalidateToken(accountType AccountType, label string) {
+	account, err := s.account(accountType, label)
+	if err != nil {
+		// Nothing is cached for an unknown account
+		return
+	}
+
+	now := time.Now()
 	s.cacheMutex.Lock()
-	s.tokenCache[accountType] = &cachedToken{
-		AccessToken: token,
-		ExpiresAt:   expiresAt,
+	if last, exists := s.forcedRefreshes[account]; exists && now.Sub(last) < forceRefreshInterval {
+		s.cacheMutex.Unlock()
+		slog.Info("Ignoring forced token refresh, the token was refreshed recently", "account_type", account.Type, "label", account.Label, "refreshed_at", last)
+		return
+	}
+	if s.forcedRefreshes == nil {
+		s.forcedRefreshes = make(map[Account]time.Time)
+	}
+	s.forcedRefreshes[account] = now
+	if token, exists := s.tokenCache[account]; exists && token.RefreshToken != "" {
+		s.tokenCache[account] = &cachedToken{RefreshToken: token.RefreshToken}
+	} else {
+		delete(s.tokenCache, account)
 	}
 	s.cacheMutex.Unlock()
-	
-	// Persist the token cache
-	if err := s.saveTokenCache(); err != nil {
-		// Just log the error but continue - it's not fatal if we can't save the cache
-		fmt.Printf("Warning: Failed to save token cache: %v\n", err)
+
+	s.persistTokenCache()
+}
+
+// GetReadOnlyToken returns the read-only token of an account, see GetToken,
+// as the access token, so callers that only read data never see the
+// trading-capable token. API key accounts, like Alpaca ones, have no
+// read-only token and return ErrUnsupportedAccountType.
+func (s *Service) GetReadOnlyToken(ctx context.Context, accountType AccountType, label string) (*TokenResponse, error) {
+	token, err := s.GetToken(ctx, accountType, label)
+	if err != nil {
+		return nil, err
+	}
+	if token.AuthType == AuthAPIKey {
+		return nil, fmt.Errorf("%w: read-only tokens are unsupported for %s accounts", ErrUnsupportedAccountType, AuthAPIKey)
+	}
+
+	if token.ReadOnlyAccessToken == "" {
+		return nil, fmt.Errorf("%w for account type: %s", ErrReadOnlyTokenUnavailable, accountType)
 	}
 
 	return &TokenResponse{
-		AccessToken: token,
-		ExpiresAt:   expiresAt,
+		AuthType:    AuthBearer,
+		AccessToken: token.ReadOnlyAccessToken,
+		ExpiresAt:   token.ExpiresAt,
 	}, nil
 }
 
-func (s *Service) fetchNewToken(accountType AccountType, creds accountCredentials) (string, time.Time, error) {
+// response converts a cached token into a TokenResponse
+func (t *cachedToken) response() *TokenResponse {
+	return &TokenResponse{
+		AuthType:            AuthBearer,
+		AccessToken:         t.AccessToken,
+		ReadOnlyAccessToken: t.ReadOnlyAccessToken,
+		ExpiresAt:           t.ExpiresAt,
+	}
+}
+
+// renewToken fetches a new token, first with refreshToken when set. Only a
+// rejected refresh token falls back to a password login; other failures are
+// returned, since a login would likely fail the same way. No login is started
+// while one waits for a challenge code.
+func (s *Service) renewToken(ctx context.Context, account Account, creds accountCredentials, refreshToken string) (*cachedToken, error) {
+	if refreshToken != "" {
+		token, err := s.refreshAccessToken(ctx, account.Type, refreshToken)
+		if !errors.Is(err, ErrInvalidGrant) {
+			return token, err
+		}
+		slog.InfoContext(ctx, "Refresh token rejected, logging in again", "account_type", account.Type, "label", account.Label)
+	}
+
+	// Don't send another code while one is waiting to be submitted
+	if err := s.pendingChallenge(account); err != nil {
+		return nil, err
+	}
+	return s.fetchNewToken(ctx, account, creds)
+}
+
+// refreshAccessToken exchanges a refresh token for a new token
+func (s *Service) refreshAccessToken(ctx context.Context, accountType AccountType, refreshToken string) (*cachedToken, error) {
 	switch accountType {
 	case Robinhood:
-		return s.fetchRobinhoodToken(creds)
+		return s.refreshRobinhoodToken(ctx, refreshToken)
 	default:
-		return "", time.Time{}, fmt.Errorf("unsupported account type: %s", accountType)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAccountType, accountType)
 	}
 }
 
-func (s *Service) fetchRobinhoodToken(creds accountCredentials) (string, time.Time, error) {
-	deviceUUID := uuid.New().String()
+// refreshRobinhoodToken runs the refresh_token grant. The broker may rotate
+// the refresh token; when it does not, the current one is kept.
+func (s *Service) refreshRobinhoodToken(ctx context.Context, refreshToken string) (*cachedToken, error) {
+	payload := map[string]interface{}{
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+		"client_id":     robinhoodClientID,
+		"scope":         "internal",
+	}
+	resp, err := s.makeRequest(ctx, http.MethodPost, "/oauth2/token/", map[string]string{"Content-Type": "application/json"}, payload)
+	if err != nil {
+		return nil, fmt.Errorf("refresh token request failed: %w", err)
+	}
+	if code, _ := resp.Body["error"].(string); code == "invalid_grant" {
+		return nil, fmt.Errorf("%w: refresh token rejected with status %d", ErrInvalidGrant, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("refresh token request failed with status %d: %v", resp.StatusCode, resp.Body)
+	}
+
+	token, ok := tokenFromResponse(resp.Body)
+	if !ok {
+		return nil, fmt.Errorf("no access token in refresh response: %v", resp.Body)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+func (s *Service) fetchNewToken(ctx context.Context, account Account, creds accountCredentials) (*cachedToken, error) {
+	switch account.Type {
+	case Robinhood:
+		return s.fetchRobinhoodToken(ctx, account, creds)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAccountType, account.Type)
+	}
+}
+
+// tokenFromResponse extracts the access token, and the read-only secondary
+// and refresh tokens when they were issued, from an oauth2 token response
+func tokenFromResponse(tokenData map[string]interface{}) (*cachedToken, bool) {
+	accessToken, ok := tokenData["access_token"].(string)
+	if !ok {
+		return nil, false
+	}
+
+	// Without a usable expires_in the token would look expired immediately,
+	// so assume a conservative validity instead
+	validity := defaultTokenValidity
+	if expiresIn, _ := tokenData["expires_in"].(float64); expiresIn > 0 {
+		validity = time.Duration(expiresIn) * time.Second
+	}
+	readOnlyToken, _ := tokenData["read_only_secondary_access_token"].(string)
+	refreshToken, _ := tokenData["refresh_token"].(string)
+
+	return &cachedToken{
+		AccessToken:         accessToken,
+		ReadOnlyAccessToken: readOnlyToken,
+		ExpiresAt:           time.Now().Add(validity),
+		RefreshToken:        refreshToken,
+	}, true
+}
 
-	// Common headers used across requests
-	headers := map[string]string{
-		"sec-ch-ua-platform":      "macOS",
-		"Referer":                 "https://robinhood.com/",
-		"X-TimeZone-Id":           "America/Los_Angeles",
-		"X-Robinhood-API-Version": "1.431.4",
-		"sec-ch-ua":               "\"Not_A:Brand\";v=\"99\", \"Google Chrome\";v=\"133\", \"Chromium\";v=\"133\"",
-		"Content-Type":            "application/json",
-		"sec-ch-ua-mobile":        "?0",
-		"User-Agent":              "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36",
+func (s *Service) fetchRobinhoodToken(ctx context.Context, account Account, creds accountCredentials) (*cachedToken, error) {
+	// A device Robinhood already verified can get a token directly
+	deviceUUID, err := s.deviceToken(account)
+	if err != nil {
+		return nil, s.stepFailed(StepInitialToken, err)
 	}
 
+	// The verification workflow expects the web client's headers
+	headers := robinhood.BrowserHeaders()
+
 	// Step 1: Initial token request
 	tokenHeaders := map[string]string{
 		"Content-Type": "application/json",
 	}
-	tokenData, err := s.getToken(creds, deviceUUID, tokenHeaders)
+	tokenData, err := s.getToken(ctx, creds, deviceUUID, tokenHeaders)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("initial token request failed: %w", err)
+		return nil, s.stepFailed(StepInitialToken, fmt.Errorf("initial token request failed: %w", err))
 	}
 
 	// First check for direct access token
-	if accessToken, ok := tokenData["access_token"].(string); ok {
-		expiresIn, _ := tokenData["expires_in"].(float64)
-		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
-		return accessToken, expiresAt, nil
+	if token, ok := tokenFromResponse(tokenData); ok {
+		return token, nil
 	}
 
 	// If no access token, look for workflow ID
 	workflowRaw, exists := tokenData["verification_workflow"]
 	if !exists {
-		return "", time.Time{}, fmt.Errorf("response missing both access_token and verification_workflow: %v", tokenData)
+		return nil, s.stepFailed(StepInitialToken, fmt.Errorf("response missing both access_token and verification_workflow: %v", tokenData))
 	}
 
 	workflow, ok := workflowRaw.(map[string]interface{})
 	if !ok {
-		return "", time.Time{}, fmt.Errorf("verification_workflow is not a map: %v", tokenData)
+		return nil, s.stepFailed(StepInitialToken, fmt.Errorf("verification_workflow is not a map: %v", tokenData))
 	}
 
 	workflowID, ok := workflow["id"].(string)
 	if !ok {
-		return "", time.Time{}, fmt.Errorf("workflow missing id field: %v", workflow)
+		return nil, s.stepFailed(StepInitialToken, fmt.Errorf("workflow missing id field: %v", workflow))
 	}
 
 	// Step 2: Machine verification
-	machineURL := "https://api.robinhood.com/pathfinder/user_machine/"
+	machineURL := "/pathfinder/user_machine/"
 	machinePayload := map[string]interface{}{
 		"device_id": deviceUUID,
 		"flow":      "suv",
 		"input":     map[string]string{"workflow_id": workflowID},
 	}
 
-	machineResp, err := s.makeRequest(http.MethodPost, machineURL, headers, machinePayload)
+	machineResp, err := s.makeRequest(ctx, http.MethodPost, machineURL, headers, machinePayload)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("machine verification failed: %w", err)
+		return nil, s.stepFailed(StepMachineVerification, fmt.Errorf("machine verification failed: %w", err))
 	}
 
 	inquiryID, ok := machineResp.Body["id"].(string)
 	if !ok {
-		return "", time.Time{}, fmt.Errorf("no inquiry ID in response")
+		return nil, s.stepFailed(StepMachineVerification, fmt.Errorf("no inquiry ID in response"))
 	}
 
 	// Step 3: Get user view
-	viewURL := fmt.Sprintf("https://api.robinhood.com/pathfinder/inquiries/%s/user_view/", inquiryID)
-	viewResp, err := s.makeRequest(http.MethodGet, viewURL, headers, nil)
+	viewURL := fmt.Sprintf("/pathfinder/inquiries/%s/user_view/", inquiryID)
+	viewResp, err := s.makeRequest(ctx, http.MethodGet, viewURL, headers, nil)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("user view request failed: %w", err)
+		return nil, s.stepFailed(StepUserView, fmt.Errorf("user view request failed: %w", err))
 	}
 
-	challengeID, ok := viewResp.Body["context"].(map[string]interface{})["sheriff_challenge"].(map[string]interface{})["id"].(string)
+	viewContext, _ := viewResp.Body["context"].(map[string]interface{})
+	challenge, _ := viewContext["sheriff_challenge"].(map[string]interface{})
+	challengeID, ok := challenge["id"].(string)
 	if !ok {
-		return "", time.Time{}, fmt.Errorf("no challenge ID in response")
+		return nil, s.stepFailed(StepUserView, fmt.Errorf("no challenge ID in response"))
+	}
+
+	// SMS and email challenges wait for the user to submit the code
+	if challengeType, _ := challenge["type"].(string); isCodeChallenge(challengeType) {
+		return nil, s.parkChallenge(&pendingLogin{
+			account:           account,
+			creds:             creds,
+			deviceUUID:        deviceUUID,
+			viewURL:           viewURL,
+			brokerChallengeID: challengeID,
+			challengeType:     challengeType,
+		})
 	}
 
 	// Step 4: Poll for prompt status
-	promptURL := fmt.Sprintf("https://api.robinhood.com/push/%s/get_prompts_status/", challengeID)
+	promptURL := fmt.Sprintf("/push/%s/get_prompts_status/", challengeID)
 	for attempt := 0; attempt < 30; attempt++ {
-		promptResp, err := s.makeRequest(http.MethodGet, promptURL, headers, nil)
+		s.metrics.pollAttempted()
+		promptResp, err := s.makeRequest(ctx, http.MethodGet, promptURL, headers, nil)
 		if err != nil {
-			return "", time.Time{}, fmt.Errorf("prompt status check failed: %w", err)
+			return nil, s.stepFailed(StepPromptStatus, fmt.Errorf("prompt status check failed: %w", err))
 		}
 
 		// Handle non-200 responses
 		if promptResp.StatusCode != http.StatusOK {
-			return "", time.Time{}, fmt.Errorf("prompt status check failed with status %d: %v", promptResp.StatusCode, promptResp.Body)
+			return nil, s.stepFailed(StepPromptStatus, fmt.Errorf("prompt status check failed with status %d: %v", promptResp.StatusCode, promptResp.Body))
 		}
 
 		status, _ := promptResp.Body["challenge_status"].(string)
 		if status == "validated" {
 			break
 		} else if status != "issued" {
-			return "", time.Time{}, fmt.Errorf("unexpected challenge status: %s", status)
+			return nil, s.stepFailed(StepPromptStatus, fmt.Errorf("unexpected challenge status: %s", status))
 		}
 
-		time.Sleep(2 * time.Second)
+		select {
+		case <-ctx.Done():
+			return nil, s.stepFailed(StepPromptStatus, fmt.Errorf("waiting for the challenge to be validated: %w", ctx.Err()))
+		case <-time.After(2 * time.Second):
+		}
 	}
 
+	return s.finishRobinhoodWorkflow(ctx, creds, deviceUUID, viewURL, headers)
+}
+
+// finishRobinhoodWorkflow continues a verification workflow whose challenge
+// was validated and requests the token it unlocked
+func (s *Service) finishRobinhoodWorkflow(ctx context.Context, creds accountCredentials, deviceUUID, viewURL string, headers map[string]string) (*cachedToken, error) {
 	// Step 5: Check workflow status
 	viewPayload := map[string]interface{}{
 		"sequence":   0,
 		"user_input": map[string]string{"status": "continue"},
 	}
 
-	viewResp, err = s.makeRequest(http.MethodPost, viewURL, headers, viewPayload)
+	viewResp, err := s.makeRequest(ctx, http.MethodPost, viewURL, headers, viewPayload)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("workflow status check failed: %w", err)
+		return nil, s.stepFailed(StepWorkflowStatus, fmt.Errorf("workflow status check failed: %w", err))
 	}
 
 	workflowStatus, ok := viewResp.Body["type_context"].(map[string]interface{})["result"].(string)
 	if !ok || workflowStatus != "workflow_status_approved" {
-		return "", time.Time{}, fmt.Errorf("unexpected workflow status: %v", workflowStatus)
+		return nil, s.stepFailed(StepWorkflowStatus, fmt.Errorf("unexpected workflow status: %v", workflowStatus))
 	}
 
 	// Step 6: Final token request
-	finalTokenData, err := s.getToken(creds, deviceUUID, tokenHeaders)
+	finalTokenData, err := s.getToken(ctx, creds, deviceUUID, map[string]string{"Content-Type": "application/json"})
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("final token request failed: %w", err)
+		return nil, s.stepFailed(StepFinalToken, fmt.Errorf("final token request failed: %w", err))
 	}
 
 	// After workflow validation, we must get an access token
-	accessToken, ok := finalTokenData["access_token"].(string)
+	token, ok := tokenFromResponse(finalTokenData)
 	if !ok {
-		return "", time.Time{}, fmt.Errorf("no access token in final response: %v", finalTokenData)
+		return nil, s.stepFailed(StepFinalToken, fmt.Errorf("no access token in final response: %v", finalTokenData))
 	}
 
-	expiresIn, _ := finalTokenData["expires_in"].(float64)
-	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return token, nil
+}
 
-	return accessToken, expiresAt, nil
+// stepFailed records a failure of the workflow step and returns err
+func (s *Service) stepFailed(step string, err error) error {
+	s.metrics.workflowStepFailed(step)
+	return err
 }
 
-func (s *Service) getToken(creds accountCredentials, deviceUUID string, headers map[string]string) (map[string]interface{}, error) {
-	tokenURL := "https://api.robinhood.com/oauth2/token/"
+func (s *Service) getToken(ctx context.Context, creds accountCredentials, deviceUUID string, headers map[string]string) (map[string]interface{}, error) {
+	tokenURL := "/oauth2/token/"
 	payload := map[string]interface{}{
 		"device_token":                     deviceUUID,
 		"create_read_only_secondary_token": true,
-		"client_id":                        "c82SH0WZOsabOXGP2sxqcj34FxkvfnWRZBKlBjFS",
+		"client_id":                        robinhoodClientID,
 		"grant_type":                       "password",
 		"scope":                            "internal",
 		"username":                         creds.username,
 		"password":                         creds.password,
 	}
 
-	resp, err := s.makeRequest(http.MethodPost, tokenURL, headers, payload)
+	resp, err := s.makeRequest(ctx, http.MethodPost, tokenURL, headers, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -365,38 +742,26 @@ type Response struct {
 	Body       map[string]interface{}
 }
 
-func (s *Service) makeRequest(method, url string, headers map[string]string, payload interface{}) (*Response, error) {
-	var body io.Reader
-	if payload != nil {
-		jsonPayload, err := json.Marshal(payload)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal payload: %w", err)
-		}
-		body = bytes.NewBuffer(jsonPayload)
-	}
-
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+// makeRequest sends a request to the Robinhood API path and decodes its JSON
+// response whatever the status, so the workflow can branch on it. The
+// request is bound to ctx, which carries the request ID of the token request
+// that started it.
+func (s *Service) makeRequest(ctx context.Context, method, path string, headers map[string]string, payload interface{}) (*Response, error) {
+	api := robinhood.NewClient(robinhood.WithDoer(s.client))
 
 	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	status, err := api.Do(ctx, robinhood.Request{
+		Method:  method,
+		Path:    path,
+		Headers: headers,
+		Payload: payload,
+	}, &result)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Response{
-		StatusCode: resp.StatusCode,
+		StatusCode: status,
 		Body:       result,
 	}, nil
 }
@@ -1,7 +1,7 @@
 package token
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
 	"os"
 	"testing"
@@ -14,31 +14,30 @@ func TestFetchRobinhoodToken_Integration(t *testing.T) {
 		t.Skip("Skipping integration test in short mode")
 	}
 
-	// Read credentials from config.json
-	data, err := os.ReadFile("/Users/jianyu.zhou/personal-projects/trade-sonic/token-service/config.json")
-	if err != nil {
-		t.Fatalf("Failed to read config file: %v", err)
+	// Read real credentials from the config named by TOKEN_SERVICE_CONFIG
+	configPath := os.Getenv("TOKEN_SERVICE_CONFIG")
+	if configPath == "" {
+		t.Skip("Skipping integration test, TOKEN_SERVICE_CONFIG is not set")
 	}
-
-	var cfg config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		t.Fatalf("Failed to parse config: %v", err)
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
 	}
 
 	s := &Service{
 		client: &http.Client{
 			Timeout: time.Second * 30,
 		},
-		credentials: map[AccountType]accountCredentials{
-			Robinhood: {
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {
 				username: cfg.Robinhood.Username,
 				password: cfg.Robinhood.Password,
 			},
 		},
-		tokenCache: make(map[AccountType]*cachedToken),
+		tokenCache: make(map[Account]*cachedToken),
 	}
 
-	token, err := s.GetToken(Robinhood)
+	token, err := s.GetToken(context.Background(), Robinhood, "")
 	if err != nil {
 		t.Fatalf("Failed to fetch token: %v", err)
 	}
@@ -2,12 +2,19 @@ package token
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/trade-sonic/logging"
 )
 
 // Unit tests for the token service
@@ -15,15 +22,18 @@ import (
 func TestGetToken_CachedToken(t *testing.T) {
 	s := &Service{
 		client: &http.Client{},
-		tokenCache: map[AccountType]*cachedToken{
-			Robinhood: {
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {
 				AccessToken: "test-token",
 				ExpiresAt:   time.Now().Add(time.Hour),
 			},
 		},
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
 	}
 
-	token, err := s.GetToken(Robinhood)
+	token, err := s.GetToken(context.Background(), Robinhood, "")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -44,14 +54,14 @@ func TestGetToken_ExpiredToken_DirectToken(t *testing.T) {
 	// Create a service with an expired token
 	s := &Service{
 		client: mockClient,
-		tokenCache: map[AccountType]*cachedToken{
-			Robinhood: {
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {
 				AccessToken: "expired-token",
 				ExpiresAt:   time.Now().Add(-time.Hour),
 			},
 		},
-		credentials: map[AccountType]accountCredentials{
-			Robinhood: {
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {
 				username: "test",
 				password: "test",
 			},
@@ -59,7 +69,7 @@ func TestGetToken_ExpiredToken_DirectToken(t *testing.T) {
 	}
 
 	// Call GetToken - it should fetch a new token
-	token, err := s.GetToken(Robinhood)
+	token, err := s.GetToken(context.Background(), Robinhood, "")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -70,7 +80,7 @@ func TestGetToken_ExpiredToken_DirectToken(t *testing.T) {
 	}
 
 	// Verify token was cached
-	cachedToken := s.tokenCache[Robinhood]
+	cachedToken := s.tokenCache[DefaultAccount(Robinhood)]
 	if cachedToken == nil {
 		t.Fatal("Expected token to be cached")
 	}
@@ -123,14 +133,14 @@ func TestGetToken_ExpiredToken_WithWorkflow(t *testing.T) {
 	// Create a service with an expired token
 	s := &Service{
 		client: mockClient,
-		tokenCache: map[AccountType]*cachedToken{
-			Robinhood: {
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {
 				AccessToken: "expired-token",
 				ExpiresAt:   time.Now().Add(-time.Hour),
 			},
 		},
-		credentials: map[AccountType]accountCredentials{
-			Robinhood: {
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {
 				username: "test",
 				password: "test",
 			},
@@ -138,7 +148,7 @@ func TestGetToken_ExpiredToken_WithWorkflow(t *testing.T) {
 	}
 
 	// Call GetToken - it should fetch a new token
-	token, err := s.GetToken(Robinhood)
+	token, err := s.GetToken(context.Background(), Robinhood, "")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -149,7 +159,7 @@ func TestGetToken_ExpiredToken_WithWorkflow(t *testing.T) {
 	}
 
 	// Verify token was cached
-	cachedToken := s.tokenCache[Robinhood]
+	cachedToken := s.tokenCache[DefaultAccount(Robinhood)]
 	if cachedToken == nil {
 		t.Fatal("Expected token to be cached")
 	}
@@ -161,12 +171,48 @@ func TestGetToken_ExpiredToken_WithWorkflow(t *testing.T) {
 	}
 }
 
+func TestInvalidateToken(t *testing.T) {
+	mockClient := newMockClient([]mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"access_token": "new-token",
+			"expires_in":   3600,
+		}),
+	})
+
+	// The cached token is still valid locally but was rejected by the broker
+	s := &Service{
+		client: mockClient,
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {
+				AccessToken: "rejected-token",
+				ExpiresAt:   time.Now().Add(time.Hour),
+			},
+		},
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {
+				username: "test",
+				password: "test",
+			},
+		},
+	}
+
+	s.InvalidateToken(Robinhood, "")
+
+	token, err := s.GetToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "new-token" {
+		t.Errorf("Expected new token 'new-token', got %s", token.AccessToken)
+	}
+}
+
 func TestGetToken_NoCredentials(t *testing.T) {
 	s := &Service{
 		client: &http.Client{},
 	}
 
-	_, err := s.GetToken(Robinhood)
+	_, err := s.GetToken(context.Background(), Robinhood, "")
 	if err == nil {
 		t.Error("Expected error for missing credentials")
 	}
@@ -177,7 +223,7 @@ func TestGetToken_InvalidAccountType(t *testing.T) {
 		client: &http.Client{},
 	}
 
-	_, err := s.GetToken("invalid")
+	_, err := s.GetToken(context.Background(), "invalid", "")
 	if err == nil {
 		t.Error("Expected error for invalid account type")
 	}
@@ -195,6 +241,27 @@ type mockResponse struct {
 	err      error
 }
 
+func TestGetToken_BrokerRequestsUseContext(t *testing.T) {
+	transport := &mockTransport{responses: []mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{"access_token": "new-token", "expires_in": 3600}),
+	}}
+	s := &Service{
+		client:     &http.Client{Transport: transport},
+		tokenCache: make(map[Account]*cachedToken),
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+	}
+
+	ctx := logging.WithRequestID(context.Background(), "positions-5c1d")
+	if _, err := s.GetToken(ctx, Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(transport.requestIDs) != 1 || transport.requestIDs[0] != "positions-5c1d" {
+		t.Errorf("Expected the login to run on the request's context, got request IDs %q", transport.requestIDs)
+	}
+}
+
 func newMockClient(responses []mockResponse) *http.Client {
 	return &http.Client{
 		Transport: &mockTransport{responses: responses},
@@ -204,9 +271,21 @@ func newMockClient(responses []mockResponse) *http.Client {
 type mockTransport struct {
 	responses []mockResponse
 	current   int
+	bodies    []string // Bodies of the requests sent, in order
+	paths     []string // URL paths of the requests sent, in order
+	// Request IDs carried by the contexts of the requests sent, in order
+	requestIDs []string
 }
 
 func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}
+	m.bodies = append(m.bodies, body)
+	m.paths = append(m.paths, req.URL.Path)
+	m.requestIDs = append(m.requestIDs, logging.RequestID(req.Context()))
 	if m.current >= len(m.responses) {
 		return nil, fmt.Errorf("no more responses")
 	}
@@ -239,7 +318,7 @@ func TestFetchRobinhoodToken_DirectSuccess(t *testing.T) {
 		client: mockClient,
 	}
 
-	token, expiresAt, err := s.fetchRobinhoodToken(accountCredentials{
+	token, err := s.fetchRobinhoodToken(context.Background(), DefaultAccount(Robinhood), accountCredentials{
 		username: "test",
 		password: "test",
 	})
@@ -247,10 +326,10 @@ func TestFetchRobinhoodToken_DirectSuccess(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
-	if token != "test-token" {
-		t.Errorf("Expected token 'test-token', got %s", token)
+	if token.AccessToken != "test-token" {
+		t.Errorf("Expected token 'test-token', got %s", token.AccessToken)
 	}
-	if expiresAt.IsZero() {
+	if token.ExpiresAt.IsZero() {
 		t.Error("Expected non-zero expiration time")
 	}
 }
@@ -297,7 +376,7 @@ func TestFetchRobinhoodToken_WorkflowSuccess(t *testing.T) {
 		client: mockClient,
 	}
 
-	token, expiresAt, err := s.fetchRobinhoodToken(accountCredentials{
+	token, err := s.fetchRobinhoodToken(context.Background(), DefaultAccount(Robinhood), accountCredentials{
 		username: "test",
 		password: "test",
 	})
@@ -305,10 +384,439 @@ func TestFetchRobinhoodToken_WorkflowSuccess(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
-	if token != "test-token" {
-		t.Errorf("Expected token 'test-token', got %s", token)
+	if token.AccessToken != "test-token" {
+		t.Errorf("Expected token 'test-token', got %s", token.AccessToken)
 	}
-	if expiresAt.IsZero() {
+	if token.ExpiresAt.IsZero() {
 		t.Error("Expected non-zero expiration time")
 	}
 }
+
+func TestGetToken_ReadOnlyToken(t *testing.T) {
+	tests := []struct {
+		name             string
+		response         map[string]interface{}
+		expectedReadOnly string
+	}{
+		{
+			name: "read-only token present",
+			response: map[string]interface{}{
+				"access_token":                     "trading-token",
+				"read_only_secondary_access_token": "read-only-token",
+				"expires_in":                       3600,
+			},
+			expectedReadOnly: "read-only-token",
+		},
+		{
+			name: "read-only token absent",
+			response: map[string]interface{}{
+				"access_token": "trading-token",
+				"expires_in":   3600,
+			},
+			expectedReadOnly: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				client:     newMockClient([]mockResponse{newMockResponse(http.StatusOK, tt.response)}),
+				tokenCache: make(map[Account]*cachedToken),
+				credentials: map[Account]accountCredentials{
+					DefaultAccount(Robinhood): {username: "test", password: "test"},
+				},
+			}
+
+			token, err := s.GetToken(context.Background(), Robinhood, "")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if token.AccessToken != "trading-token" {
+				t.Errorf("Expected access token 'trading-token', got %s", token.AccessToken)
+			}
+			if token.ReadOnlyAccessToken != tt.expectedReadOnly {
+				t.Errorf("Expected read-only token %q, got %q", tt.expectedReadOnly, token.ReadOnlyAccessToken)
+			}
+
+			// Served from the cache, so no further responses are needed
+			readOnly, err := s.GetReadOnlyToken(context.Background(), Robinhood, "")
+			if tt.expectedReadOnly == "" {
+				if !errors.Is(err, ErrReadOnlyTokenUnavailable) {
+					t.Errorf("Expected ErrReadOnlyTokenUnavailable, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if readOnly.AccessToken != tt.expectedReadOnly {
+				t.Errorf("Expected read-only token as access token, got %s", readOnly.AccessToken)
+			}
+			if readOnly.ReadOnlyAccessToken != "" {
+				t.Error("Expected the read-only response to omit other tokens")
+			}
+		})
+	}
+}
+
+func TestGetToken_MissingExpiry(t *testing.T) {
+	tests := []struct {
+		name             string
+		response         map[string]interface{}
+		expectedValidity time.Duration
+	}{
+		{
+			name:             "expires_in absent",
+			response:         map[string]interface{}{"access_token": "test-token"},
+			expectedValidity: defaultTokenValidity,
+		},
+		{
+			name:             "expires_in zero",
+			response:         map[string]interface{}{"access_token": "test-token", "expires_in": 0},
+			expectedValidity: defaultTokenValidity,
+		},
+		{
+			name:             "expires_in present",
+			response:         map[string]interface{}{"access_token": "test-token", "expires_in": 86400},
+			expectedValidity: 24 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				client:     newMockClient([]mockResponse{newMockResponse(http.StatusOK, tt.response)}),
+				tokenCache: make(map[Account]*cachedToken),
+				credentials: map[Account]accountCredentials{
+					DefaultAccount(Robinhood): {username: "test", password: "test"},
+				},
+			}
+
+			start := time.Now()
+			token, err := s.GetToken(context.Background(), Robinhood, "")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if validity := token.ExpiresAt.Sub(start); validity < tt.expectedValidity-time.Minute || validity > tt.expectedValidity+time.Minute {
+				t.Errorf("Expected the token to be valid for about %v, got %v", tt.expectedValidity, validity)
+			}
+
+			// The serialized expiry must be in the future
+			data, err := json.Marshal(token)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			var decoded struct {
+				ExpiresAt time.Time `json:"expires_at"`
+			}
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Failed to decode token response: %v", err)
+			}
+			if !decoded.ExpiresAt.After(start) {
+				t.Errorf("Expected expires_at in the future, got %s", decoded.ExpiresAt)
+			}
+
+			// The token is not treated as expired, so it is served from the cache
+			if _, err := s.GetToken(context.Background(), Robinhood, ""); err != nil {
+				t.Errorf("Expected the cached token to be reused, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseAccountType(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    AccountType
+		expectedErr bool
+	}{
+		{input: "robinhood", expected: Robinhood},
+		{input: "Robinhood", expected: Robinhood},
+		{input: " ROBINHOOD ", expected: Robinhood},
+		{input: "robinhod", expectedErr: true},
+		{input: "", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			accountType, err := ParseAccountType(tt.input)
+			if tt.expectedErr {
+				if !errors.Is(err, ErrUnsupportedAccountType) {
+					t.Errorf("Expected ErrUnsupportedAccountType, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if accountType != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, accountType)
+			}
+		})
+	}
+}
+
+func TestTokenRequest_RejectsUnknownAccountType(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		expected    AccountType
+		expectedErr bool
+	}{
+		{name: "valid", body: `{"account_type":"robinhood"}`, expected: Robinhood},
+		{name: "case variant", body: `{"account_type":"RobinHood"}`, expected: Robinhood},
+		{name: "unknown", body: `{"account_type":"robinhod"}`, expectedErr: true},
+		{name: "not a string", body: `{"account_type":true}`, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req TokenRequest
+			err := json.Unmarshal([]byte(tt.body), &req)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("Expected an error, got account type %s", req.AccountType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if req.AccountType != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, req.AccountType)
+			}
+		})
+	}
+}
+
+func TestNewService_ConfigPath(t *testing.T) {
+	// The token cache is kept under ./data, so run in a scratch directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// Credentials in the environment would take precedence over the file
+	t.Setenv("ROBINHOOD_USERNAME", "")
+	t.Setenv("ROBINHOOD_PASSWORD", "")
+
+	configPath := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(configPath, []byte(`{"robinhood": {"username": "user", "password": "secret"}}`), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	s, err := NewService(configPath, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if creds := s.credentials[DefaultAccount(Robinhood)]; creds.username != "user" || creds.password != "secret" {
+		t.Errorf("Expected the configured credentials, got %+v", creds)
+	}
+
+	missing := filepath.Join(dir, "missing.json")
+	if _, err := NewService(missing, nil); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected an error naming %s, got %v", missing, err)
+	}
+}
+
+// expiredWithRefreshToken returns a service whose cached token expired but
+// can be refreshed, sending requests through client
+func expiredWithRefreshToken(client *http.Client) *Service {
+	return &Service{
+		client: client,
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {
+				AccessToken:  "expired-token",
+				ExpiresAt:    time.Now().Add(-time.Minute),
+				RefreshToken: "refresh-1",
+			},
+		},
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+	}
+}
+
+// grantType returns the grant_type of a token request body
+func grantType(t *testing.T, body string) string {
+	t.Helper()
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("Failed to parse request body %q: %v", body, err)
+	}
+	grant, _ := payload["grant_type"].(string)
+	return grant
+}
+
+func TestGetToken_RefreshTokenGrant(t *testing.T) {
+	transport := &mockTransport{responses: []mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"access_token":  "refreshed-token",
+			"expires_in":    3600,
+			"refresh_token": "refresh-2",
+		}),
+	}}
+	s := expiredWithRefreshToken(&http.Client{Transport: transport})
+
+	token, err := s.GetToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "refreshed-token" {
+		t.Errorf("Expected token 'refreshed-token', got %s", token.AccessToken)
+	}
+	if len(transport.bodies) != 1 || grantType(t, transport.bodies[0]) != "refresh_token" {
+		t.Fatalf("Expected a single refresh_token request, got %v", transport.bodies)
+	}
+	if !strings.Contains(transport.bodies[0], `"refresh_token":"refresh-1"`) {
+		t.Errorf("Expected the cached refresh token to be sent, got %s", transport.bodies[0])
+	}
+	// The rotated refresh token replaces the old one
+	if got := s.tokenCache[DefaultAccount(Robinhood)].RefreshToken; got != "refresh-2" {
+		t.Errorf("Expected refresh token 'refresh-2', got %s", got)
+	}
+}
+
+func TestGetToken_RefreshKeepsUnrotatedRefreshToken(t *testing.T) {
+	s := expiredWithRefreshToken(newMockClient([]mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"access_token": "refreshed-token",
+			"expires_in":   3600,
+		}),
+	}))
+
+	if _, err := s.GetToken(context.Background(), Robinhood, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := s.tokenCache[DefaultAccount(Robinhood)].RefreshToken; got != "refresh-1" {
+		t.Errorf("Expected refresh token 'refresh-1' to be kept, got %s", got)
+	}
+}
+
+func TestGetToken_RefreshFallsBackToPassword(t *testing.T) {
+	transport := &mockTransport{responses: []mockResponse{
+		newMockResponse(http.StatusBadRequest, map[string]interface{}{
+			"error": "invalid_grant",
+		}),
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"access_token":  "password-token",
+			"expires_in":    3600,
+			"refresh_token": "refresh-new",
+		}),
+	}}
+	s := expiredWithRefreshToken(&http.Client{Transport: transport})
+
+	token, err := s.GetToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "password-token" {
+		t.Errorf("Expected token 'password-token', got %s", token.AccessToken)
+	}
+	if len(transport.bodies) != 2 || grantType(t, transport.bodies[0]) != "refresh_token" || grantType(t, transport.bodies[1]) != "password" {
+		t.Fatalf("Expected a refresh_token then a password request, got %v", transport.bodies)
+	}
+	if got := s.tokenCache[DefaultAccount(Robinhood)].RefreshToken; got != "refresh-new" {
+		t.Errorf("Expected refresh token 'refresh-new', got %s", got)
+	}
+}
+
+func TestGetToken_RefreshErrorWithoutFallback(t *testing.T) {
+	transport := &mockTransport{responses: []mockResponse{
+		newMockResponse(http.StatusServiceUnavailable, map[string]interface{}{
+			"detail": "maintenance",
+		}),
+	}}
+	s := expiredWithRefreshToken(&http.Client{Transport: transport})
+
+	if _, err := s.GetToken(context.Background(), Robinhood, ""); err == nil {
+		t.Fatal("Expected an error")
+	}
+	// Only an invalid grant is worth a password login
+	if len(transport.bodies) != 1 {
+		t.Errorf("Expected no password login, got %d requests", len(transport.bodies))
+	}
+}
+
+func TestInvalidateToken_RateLimited(t *testing.T) {
+	transport := &mockTransport{responses: []mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{"access_token": "new-token", "expires_in": 3600}),
+		newMockResponse(http.StatusOK, map[string]interface{}{"access_token": "newer-token", "expires_in": 3600}),
+	}}
+	s := &Service{
+		client: &http.Client{Transport: transport},
+		tokenCache: map[Account]*cachedToken{
+			DefaultAccount(Robinhood): {AccessToken: "rejected-token", ExpiresAt: time.Now().Add(time.Hour)},
+		},
+		credentials: map[Account]accountCredentials{
+			DefaultAccount(Robinhood): {username: "test", password: "test"},
+		},
+	}
+
+	// Several clients rejected at once get the same new token
+	for i := 0; i < 3; i++ {
+		s.InvalidateToken(Robinhood, "")
+		token, err := s.GetToken(context.Background(), Robinhood, "")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if token.AccessToken != "new-token" {
+			t.Errorf("Expected new-token, got %s", token.AccessToken)
+		}
+	}
+	if len(transport.bodies) != 1 {
+		t.Errorf("Expected a single login, got %d", len(transport.bodies))
+	}
+
+	// Once the interval passed, the token is discarded again
+	s.forcedRefreshes[DefaultAccount(Robinhood)] = time.Now().Add(-forceRefreshInterval)
+	s.InvalidateToken(Robinhood, "")
+	if token, err := s.GetToken(context.Background(), Robinhood, ""); err != nil || token.AccessToken != "newer-token" {
+		t.Errorf("Expected newer-token, got %v, %v", token, err)
+	}
+}
+
+func TestInvalidateToken_Persists(t *testing.T) {
+	dir := t.TempDir()
+	s := newPersistingService(dir, testCacheKey)
+	s.tokenCache[DefaultAccount(Robinhood)] = &cachedToken{AccessToken: "rejected-token", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.saveTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	s.InvalidateToken(Robinhood, "")
+
+	loaded := newPersistingService(dir, testCacheKey)
+	if err := loaded.loadTokenCache(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token, exists := loaded.tokenCache[DefaultAccount(Robinhood)]; exists {
+		t.Errorf("Expected the discarded token to be gone from disk, got %+v", token)
+	}
+}
+
+func TestInvalidateToken_KeepsRefreshToken(t *testing.T) {
+	transport := &mockTransport{responses: []mockResponse{
+		newMockResponse(http.StatusOK, map[string]interface{}{
+			"access_token": "refreshed-token",
+			"expires_in":   3600,
+		}),
+	}}
+	s := expiredWithRefreshToken(&http.Client{Transport: transport})
+	s.tokenCache[DefaultAccount(Robinhood)].ExpiresAt = time.Now().Add(time.Hour)
+
+	s.InvalidateToken(Robinhood, "")
+
+	token, err := s.GetToken(context.Background(), Robinhood, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.AccessToken != "refreshed-token" || grantType(t, transport.bodies[0]) != "refresh_token" {
+		t.Errorf("Expected the token to be refreshed, got %s via %v", token.AccessToken, transport.bodies)
+	}
+}